@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// POST /admin/prompts/sandbox
+// Runs a persona/template against a sample writing session and returns the
+// output without persisting anything.
+func (s *APIServer) handlePromptSandbox(w http.ResponseWriter, r *http.Request) error {
+	var req services.PromptSandboxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+
+	result, err := ankyService.RunPromptSandbox(req)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, result)
+}
+
+// GET /admin/fid-requests?status=pending
+// Lists scored FID requests, by default the ones waiting for manual review.
+func (s *APIServer) handleGetFIDRequests(w http.ResponseWriter, r *http.Request) error {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = types.FIDRequestPending
+	}
+
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	requests, err := s.store.GetFIDRequestsByStatus(r.Context(), status, limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, requests)
+}
+
+// GET /admin/suspect-sessions
+// Lists the writing sessions whose keystrokes don't look typed by hand, the
+// highest suspect score first, with what gave them away.
+func (s *APIServer) handleGetSuspectSessions(w http.ResponseWriter, r *http.Request) error {
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	sessions, err := s.store.GetSuspectWritingSessions(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, sessions)
+}
+
+// POST /admin/fid-requests/{id}/review
+// Approves or rejects a FID request from the manual review queue.
+func (s *APIServer) handleReviewFIDRequest(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid fid request id: %v", err)
+	}
+
+	var req struct {
+		Approve bool   `json:"approve"`
+		Note    string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	status := types.FIDRequestRejected
+	if req.Approve {
+		status = types.FIDRequestApproved
+	}
+
+	if err := s.store.ReviewFIDRequest(r.Context(), id, status, req.Note); err != nil {
+		return err
+	}
+	log.Printf("🕵️ FID request %s reviewed: %s", id, status)
+
+	request, err := s.store.GetFIDRequestByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, request)
+}
+
+// GET /admin/missing-casts
+// Lists the Ankys whose cast the reconciler could no longer find on Farcaster.
+func (s *APIServer) handleGetMissingCasts(w http.ResponseWriter, r *http.Request) error {
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	ankys, err := s.store.GetAnkysWithMissingCasts(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, ankys)
+}
+
+// GET /admin/backup-verifications
+// Lists the restore tests of the latest database backup, most recent first.
+func (s *APIServer) handleGetBackupVerifications(w http.ResponseWriter, r *http.Request) error {
+	limit := 20
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	verifications, err := s.store.GetBackupVerifications(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, verifications)
+}
+
+// POST /admin/backup-verifications
+// Verifies the latest database backup now and returns the outcome.
+func (s *APIServer) handleVerifyBackup(w http.ResponseWriter, r *http.Request) error {
+	verification, err := services.NewBackupVerificationService(s.store).VerifyLatestBackup(r.Context())
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusOK
+	if verification.Status != types.BackupVerificationPassed {
+		status = http.StatusUnprocessableEntity
+	}
+	return WriteJSON(w, status, verification)
+}
+
+// GET /admin/session-archive
+// How many writing sessions are archived and how many are due.
+func (s *APIServer) handleGetSessionArchive(w http.ResponseWriter, r *http.Request) error {
+	stats, err := s.archive.Stats(r.Context())
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, stats)
+}
+
+// POST /admin/session-archive
+// Archives the writing sessions that are due now and returns the outcome.
+func (s *APIServer) handleArchiveSessions(w http.ResponseWriter, r *http.Request) error {
+	run, err := s.archive.ArchiveOldSessions(r.Context())
+	if errors.Is(err, services.ErrArchiveDisabled) {
+		return newHTTPError(http.StatusServiceUnavailable, CodeFeatureDisabled, "%v", err)
+	}
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, run)
+}
+
+// GET /admin/anonymous-sessions?older_than_days=90
+// Dry run of the prune: how many anonymous sessions, and Ankys made from
+// them, are older than the retention or the given number of days.
+// POST /admin/anonymous-sessions/prune?older_than_days=90
+// Deletes them, or archives them first with ANONYMOUS_RETENTION_ACTION=archive.
+func (s *APIServer) handlePruneAnonymousSessions(w http.ResponseWriter, r *http.Request) error {
+	retention := services.NewAnonymousRetentionService(s.store, s.archive)
+	maxAge, err := retention.ParseRetentionDays(r.URL.Query().Get("older_than_days"))
+	if err != nil {
+		return Validation("%v", err)
+	}
+
+	report, err := retention.Prune(r.Context(), maxAge, r.Method == http.MethodGet)
+	if errors.Is(err, services.ErrArchiveDisabled) {
+		return newHTTPError(http.StatusServiceUnavailable, CodeFeatureDisabled, "%v", err)
+	}
+	if err != nil {
+		return err
+	}
+	if adminID, ok := authenticatedUserID(r); ok && !report.DryRun {
+		log.Printf("🧹 Admin %s pruned %d anonymous sessions", adminID, report.Sessions)
+	}
+	return WriteJSON(w, http.StatusOK, report)
+}
+
+// GET/PUT /admin/kill-switches
+// Reads or flips the per-feature kill switches, e.g. {"casting": true} switches
+// casting off. Changes last until the next restart, which starts from KILL_SWITCHES.
+// Switching image generation or casting back on resumes the Ankys that waited
+// for it.
+func (s *APIServer) handleKillSwitches(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPut {
+		var switches map[string]bool
+		if err := json.NewDecoder(r.Body).Decode(&switches); err != nil {
+			return Validation("error decoding request body: %v", err)
+		}
+		if err := services.SetKillSwitches(switches); err != nil {
+			return Validation("%v", err)
+		}
+	}
+
+	return WriteJSON(w, http.StatusOK, services.KillSwitches())
+}
+
+// GET /admin/llm-usage
+// Returns the requests and tokens of every LLM provider and model used since the server started.
+func (s *APIServer) handleGetLLMUsage(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, services.LLMUsageTotals())
+}
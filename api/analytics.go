@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+// recordSessionFocus stores the focus metrics on the session, if the session
+// is in the database. Failing to store them never fails the request.
+func (s *APIServer) recordSessionFocus(ctx context.Context, sessionID string, focus types.FocusMetrics) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+	if err := s.store.UpdateWritingSessionFocus(ctx, id, &focus); err != nil {
+		log.Printf("⚠️ Could not store focus score for session %s: %v", sessionID, err)
+		return
+	}
+	log.Printf("🎯 Session %s focus score: %d (%s)", sessionID, focus.Score, focus.Label)
+}
+
+// recordSessionPaste stores what the keystrokes show of pasted text on the
+// session, if the session is in the database. Failing to store it never fails
+// the request; the Anky pipeline checks the keystrokes again.
+func (s *APIServer) recordSessionPaste(ctx context.Context, sessionID string, paste types.PasteMetrics) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+	if err := s.store.UpdateWritingSessionPaste(ctx, id, &paste); err != nil {
+		log.Printf("⚠️ Could not store paste metrics for session %s: %v", sessionID, err)
+		return
+	}
+	if paste.Flagged {
+		log.Printf("📋 Session %s flagged for pasted text: %d pasted and %d burst characters of %d", sessionID, paste.PastedCharacters, paste.BurstCharacters, paste.TotalCharacters)
+	}
+}
+
+// recordSessionSuspect stores how scripted the keystrokes of the session
+// look, if the session is in the database. Failing to store it never fails
+// the request; the Anky pipeline checks the keystrokes again.
+func (s *APIServer) recordSessionSuspect(ctx context.Context, sessionID string, cheat types.CheatMetrics) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+	if err := s.store.UpdateWritingSessionSuspect(ctx, id, &cheat); err != nil {
+		log.Printf("⚠️ Could not store cheat metrics for session %s: %v", sessionID, err)
+		return
+	}
+	if cheat.Suspect {
+		log.Printf("🕵️ Session %s is suspect, scored %d: %s", sessionID, cheat.Score, strings.Join(cheat.Reasons, ", "))
+	}
+}
+
+// GET /users/{userId}/analytics/focus?limit=30
+// Focus score of the user's latest sessions plus their average and best.
+func (s *APIServer) handleGetUserFocusAnalytics(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	limit := 30
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 365 {
+			limit = parsedLimit
+		}
+	}
+
+	points, err := s.store.GetUserFocusHistory(r.Context(), userID, limit)
+	if err != nil {
+		return err
+	}
+
+	average, best := 0, 0
+	for _, point := range points {
+		average += point.Score
+		if point.Score > best {
+			best = point.Score
+		}
+	}
+	if len(points) > 0 {
+		average /= len(points)
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"average_score": average,
+		"average_label": utils.FocusLabel(average),
+		"best_score":    best,
+		"sessions":      points,
+	})
+}
+
+// GET /users/{userId}/streak
+// Current and longest run of days on which the user finished a session.
+func (s *APIServer) handleGetUserStreak(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	streak, err := s.store.GetUserWritingStreak(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, streak)
+}
+
+// GET /users/{userId}/journey
+// The depth level of the prompts the user gets and how far the next one is.
+func (s *APIServer) handleGetUserJourney(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	journey, err := services.NewJourneyService(s.store).GetUserJourney(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, journey)
+}
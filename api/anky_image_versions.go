@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+)
+
+// POST /ankys/{id}/regenerate-image
+// Runs the image prompt of one of the user's Ankys through the image backend again.
+// The candidates are generated in the background: poll the version in
+// /ankys/{id}/images until it is ready, then keep one of them. The Anky
+// keeps its image until then.
+func (s *APIServer) handleRegenerateAnkyImage(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	userID, ok := authenticatedUserID(r)
+	if !ok || userID != anky.UserID {
+		return Forbidden("you can only regenerate the image of your own ankys")
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	version, err := ankyService.StartImageRegeneration(ctx, anky)
+	switch {
+	case errors.Is(err, services.ErrNoImage),
+		errors.Is(err, services.ErrTooManyImageVersions),
+		errors.Is(err, storage.ErrImageRegenerationRunning):
+		return Conflict("%v", err)
+	case err != nil:
+		return err
+	}
+	log.Printf("🖼️ User %s is regenerating the image of anky %s, version %d", userID, anky.ID, version.Version)
+
+	s.runInBackground(func(ctx context.Context) {
+		if err := ankyService.GenerateImageCandidates(ctx, version); err != nil {
+			log.Printf("❌ Error regenerating image version %d of anky %s: %v", version.Version, anky.ID, err)
+		}
+	})
+
+	return WriteJSON(w, http.StatusAccepted, version)
+}
+
+// GET /ankys/{id}/images
+// Every version of the image of one of the user's Ankys, oldest first, with
+// the candidates of regenerated versions and the current one marked.
+func (s *APIServer) handleGetAnkyImageVersions(w http.ResponseWriter, r *http.Request) error {
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	if err := authorizeUser(r, anky.UserID); err != nil {
+		return err
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	versions, err := s.store.GetAnkyImageVersions(r.Context(), anky.ID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, versions)
+}
+
+// PUT /ankys/{id}/images/{version}
+// Makes a version the Anky's image. Regenerated versions need the index of
+// the candidate to keep, {"candidate": 2}; versions kept before don't.
+func (s *APIServer) handleKeepAnkyImageVersion(w http.ResponseWriter, r *http.Request) error {
+	versionNumber, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil || versionNumber <= 0 {
+		return Validation("version must be a positive number")
+	}
+	var req struct {
+		Candidate int `json:"candidate"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return Validation("error decoding request body: %v", err)
+		}
+	}
+
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	userID, ok := authenticatedUserID(r)
+	if !ok || userID != anky.UserID {
+		return Forbidden("you can only choose the image of your own ankys")
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	version, err := ankyService.KeepImageVersion(r.Context(), anky, versionNumber, req.Candidate)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return NotFound("anky %s has no image version %d", anky.ID, versionNumber)
+	case errors.Is(err, services.ErrImageCandidateNotFound):
+		return Validation("%v", err)
+	case errors.Is(err, services.ErrImageVersionNotReady):
+		return Conflict("%v", err)
+	case err != nil:
+		return err
+	}
+	log.Printf("🖼️ Anky %s now shows image version %d", anky.ID, version.Version)
+
+	return WriteJSON(w, http.StatusOK, version)
+}
+
+type imageCandidatesResponse struct {
+	Status          string     `json:"status"`
+	Candidates      []string   `json:"candidates"`
+	ChosenCandidate *int       `json:"chosen_candidate"`
+	ChooseBefore    *time.Time `json:"choose_before,omitempty"`
+}
+
+// GET /ankys/{id}/image-candidates
+// The upscaled images the pipeline made for one of the user's Ankys. While
+// the status is ready and none was chosen, the writer can choose one with
+// POST /ankys/{id}/choose-image until choose_before; then the first one is
+// kept.
+func (s *APIServer) handleGetAnkyImageCandidates(w http.ResponseWriter, r *http.Request) error {
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	if err := authorizeUser(r, anky.UserID); err != nil {
+		return err
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	version, err := s.store.GetAnkyImageVersion(r.Context(), anky.ID, 1)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && version.Source != types.AnkyImageSourcePipeline) {
+		return NotFound("anky %s has no image candidates", anky.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	response := imageCandidatesResponse{
+		Status:          version.Status,
+		Candidates:      version.CandidateURLs,
+		ChosenCandidate: version.ChosenCandidate,
+	}
+	if version.Status == types.AnkyImageReady && version.ChosenCandidate == nil {
+		chooseBefore := version.CreatedAt.Add(services.ImageChoiceTimeoutFromEnv())
+		response.ChooseBefore = &chooseBefore
+	}
+	return WriteJSON(w, http.StatusOK, response)
+}
+
+// POST /ankys/{id}/choose-image
+// Chooses which of the candidates becomes the image of one of the user's
+// Ankys, {"candidate": 2}, while its pipeline waits for it.
+func (s *APIServer) handleChooseAnkyImage(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Candidate *int `json:"candidate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if req.Candidate == nil {
+		return Validation("candidate is required")
+	}
+
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	userID, ok := authenticatedUserID(r)
+	if !ok || userID != anky.UserID {
+		return Forbidden("you can only choose the image of your own ankys")
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	version, err := ankyService.ChooseImage(r.Context(), anky, *req.Candidate)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return NotFound("anky %s has no image candidates", anky.ID)
+	case errors.Is(err, services.ErrImageCandidateNotFound):
+		return Validation("%v", err)
+	case errors.Is(err, services.ErrImageAlreadyChosen):
+		return Conflict("%v", err)
+	case err != nil:
+		return err
+	}
+	log.Printf("🖼️ User %s chose candidate %d for the image of anky %s", userID, *req.Candidate, anky.ID)
+
+	return WriteJSON(w, http.StatusOK, imageCandidatesResponse{
+		Status:          version.Status,
+		Candidates:      version.CandidateURLs,
+		ChosenCandidate: version.ChosenCandidate,
+	})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+)
+
+type ankyOnchainResponse struct {
+	AnkyID           uuid.UUID  `json:"anky_id"`
+	Status           string     `json:"status"`
+	ContractAddress  string     `json:"contract_address,omitempty"`
+	TxHash           string     `json:"tx_hash,omitempty"`
+	TokenID          string     `json:"token_id,omitempty"`
+	MetadataIPFSHash string     `json:"metadata_ipfs_hash,omitempty"`
+	MetadataURL      string     `json:"metadata_url,omitempty"`
+	BlockNumber      *int64     `json:"block_number,omitempty"`
+	ConfirmedAt      *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// GET /ankys/{id}/onchain
+// Where the Anky's NFT is: not revealed, queued, pending with its
+// transaction, confirmed with its token ID, or failed.
+func (s *APIServer) handleGetAnkyOnchain(w http.ResponseWriter, r *http.Request) error {
+	ankyID, err := pathAnkyID(r)
+	if err != nil {
+		return err
+	}
+	anky, err := s.store.GetAnkyByID(r.Context(), ankyID)
+	if err != nil {
+		return err
+	}
+	anky = anky.Withheld()
+
+	response := ankyOnchainResponse{
+		AnkyID:           anky.ID,
+		Status:           anky.OnchainStatus,
+		TxHash:           anky.OnchainTxHash,
+		TokenID:          anky.TokenID,
+		MetadataIPFSHash: anky.MetadataIPFSHash,
+		BlockNumber:      anky.OnchainBlockNumber,
+		ConfirmedAt:      anky.OnchainConfirmedAt,
+	}
+	if response.Status == "" {
+		response.Status = "not_revealed"
+	}
+	if contract := services.AnkyNFTContractAddress(); contract != (common.Address{}) {
+		response.ContractAddress = contract.Hex()
+	}
+	if anky.MetadataIPFSHash != "" {
+		response.MetadataURL = services.IPFSGatewayURL(anky.MetadataIPFSHash)
+	}
+	// Confirmed reveals are final, until then the transaction moves along
+	setAnkyCacheHeaders(w, anky.ID.String(), anky.OnchainStatus == types.OnchainStatusConfirmed)
+	return WriteJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgx/v4"
+)
+
+type regeneratedReflectionResponse struct {
+	Reflection *types.AnkyReflection `json:"reflection"`
+	Cost       int                   `json:"cost"`
+	Balance    int                   `json:"balance"`
+}
+
+// GET /ankys/{id}/reflections
+// Every version of the reflection of one of the user's Ankys, oldest first,
+// with the canonical one marked.
+func (s *APIServer) handleGetAnkyReflections(w http.ResponseWriter, r *http.Request) error {
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	if err := authorizeUser(r, anky.UserID); err != nil {
+		return err
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	reflections, err := ankyService.GetAnkyReflections(r.Context(), anky)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, reflections)
+}
+
+// POST /ankys/{id}/regenerate-reflection
+// Writes a new reflection of one of the user's Ankys from its writing, for
+// services.ReflectionRegenerationCost newen. The new version is kept in the
+// history and only replaces the Anky's reflection once it is made canonical.
+// Send an Idempotency-Key so retries don't pay twice.
+func (s *APIServer) handleRegenerateAnkyReflection(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	userID, ok := authenticatedUserID(r)
+	if !ok || userID != anky.UserID {
+		return Forbidden("you can only regenerate the reflection of your own ankys")
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	session, err := s.store.GetWritingSessionById(ctx, anky.WritingSessionID)
+	if err != nil {
+		return err
+	}
+	if err := s.archive.Rehydrate(ctx, session); err != nil {
+		return err
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	cost := services.ReflectionRegenerationCost()
+	reflection, balance, err := ankyService.RegenerateReflection(ctx, anky, session.Writing)
+	switch {
+	case errors.Is(err, storage.ErrInsufficientNewen):
+		return newHTTPError(http.StatusConflict, CodeInsufficientBalance, "insufficient balance: %d newen available, %d needed", balance, cost)
+	case errors.Is(err, services.ErrNoReflection),
+		errors.Is(err, services.ErrTooManyReflections),
+		errors.Is(err, services.ErrReflectionUnchanged):
+		return Conflict("%v", err)
+	case err != nil:
+		return err
+	}
+	log.Printf("🔄 User %s regenerated the reflection of anky %s for %d newen, version %d", userID, anky.ID, cost, reflection.Version)
+
+	return WriteJSON(w, http.StatusCreated, regeneratedReflectionResponse{
+		Reflection: reflection,
+		Cost:       cost,
+		Balance:    balance,
+	})
+}
+
+// PUT /ankys/{id}/reflections/canonical
+// Makes a version of the reflection the Anky's, the one its metadata tells.
+func (s *APIServer) handleSetCanonicalAnkyReflection(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if req.Version <= 0 {
+		return Validation("version must be positive")
+	}
+
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	userID, ok := authenticatedUserID(r)
+	if !ok || userID != anky.UserID {
+		return Forbidden("you can only choose the reflection of your own ankys")
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	reflection, err := ankyService.SetCanonicalReflection(r.Context(), anky.ID, req.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NotFound("anky %s has no reflection version %d", anky.ID, req.Version)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("📖 Anky %s now tells reflection version %d", anky.ID, reflection.Version)
+
+	return WriteJSON(w, http.StatusOK, reflection)
+}
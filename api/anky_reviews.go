@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// GET /admin/reviews?status=pending
+// Lists the safety reviews with the status, pending by default and oldest
+// first, with how many are waiting past the SLA.
+func (s *APIServer) handleGetAnkyReviews(w http.ResponseWriter, r *http.Request) error {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = types.ReviewPending
+	}
+	switch status {
+	case types.ReviewPending, types.ReviewApproved, types.ReviewRejected:
+	default:
+		return Validation("invalid status %q, expected pending, approved or rejected", status)
+	}
+
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	queue, err := services.NewSafetyReviewService(s.store).Queue(r.Context(), status, limit, offset)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, queue)
+}
+
+// POST /admin/reviews/{id}/approve
+// Releases the Anky and resumes its pipeline where the review stopped it.
+func (s *APIServer) handleApproveAnkyReview(w http.ResponseWriter, r *http.Request) error {
+	return s.decideAnkyReview(w, r, types.ReviewApproved)
+}
+
+// POST /admin/reviews/{id}/reject
+// Keeps the Anky from ever being revealed, minted or cast.
+func (s *APIServer) handleRejectAnkyReview(w http.ResponseWriter, r *http.Request) error {
+	return s.decideAnkyReview(w, r, types.ReviewRejected)
+}
+
+func (s *APIServer) decideAnkyReview(w http.ResponseWriter, r *http.Request, verdict string) error {
+	reviewID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid review id: %v", err)
+	}
+	reviewerID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("no authenticated user")
+	}
+
+	// The note is optional, so is the body
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return Validation("error decoding request body: %v", err)
+	}
+	note := strings.TrimSpace(req.Note)
+	if len(note) > 1000 {
+		return Validation("note is at most 1000 characters")
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+
+	review, anky, err := services.NewSafetyReviewService(s.store).Decide(r.Context(), reviewID, verdict, reviewerID, note)
+	if errors.Is(err, storage.ErrReviewDecided) {
+		return Conflict("this review was already decided")
+	}
+	if err != nil {
+		return err
+	}
+
+	if verdict == types.ReviewApproved {
+		s.runInBackground(func(ctx context.Context) {
+			if err := ankyService.ResumeReviewedAnky(ctx, anky); err != nil {
+				log.Printf("❌ Error resuming approved anky %s: %v", anky.ID, err)
+			}
+		})
+	}
+	return WriteJSON(w, http.StatusOK, review)
+}
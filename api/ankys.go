@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+)
+
+// ankyStatusResponse is where an Anky is in the minting pipeline, plus every
+// step it went through so far.
+type ankyStatusResponse struct {
+	AnkyID           uuid.UUID                `json:"anky_id"`
+	WritingSessionID uuid.UUID                `json:"writing_session_id"`
+	Status           string                   `json:"status"`
+	ImageURL         string                   `json:"image_url,omitempty"`
+	ImageIPFSHash    string                   `json:"image_ipfs_hash,omitempty"`
+	CastHash         string                   `json:"cast_hash,omitempty"`
+	TokenAddress     string                   `json:"token_address,omitempty"`
+	StorageDegraded  bool                     `json:"storage_degraded"`
+	MetadataURI      string                   `json:"metadata_uri,omitempty"`
+	RevealAt         *time.Time               `json:"reveal_at,omitempty"`
+	RevealedAt       *time.Time               `json:"revealed_at,omitempty"`
+	LastUpdatedAt    time.Time                `json:"last_updated_at"`
+	Events           []*types.AnkyStatusEvent `json:"events"`
+}
+
+// errAnkySealed rejects requests for what a time capsule withholds until its reveal.
+func errAnkySealed(anky *types.Anky) error {
+	return newHTTPError(http.StatusForbidden, CodeAnkySealed, "anky %s is sealed until %s", anky.ID, anky.RevealAt.UTC().Format(time.RFC3339))
+}
+
+// POST /ankys
+// Creates the Anky of a finished writing session that lasted at least eight
+// minutes and starts minting it in the background. With a reveal_at the Anky
+// is a time capsule: its reflection and image are withheld until then, and
+// it is only cast on reveal when cast_on_reveal is set.
+func (s *APIServer) handleCreateAnky(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	var req struct {
+		WritingSessionID uuid.UUID  `json:"writing_session_id"`
+		ChosenPrompt     string     `json:"chosen_prompt"`
+		License          string     `json:"license"`
+		RevealAt         *time.Time `json:"reveal_at"`
+		CastOnReveal     bool       `json:"cast_on_reveal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if req.WritingSessionID == uuid.Nil {
+		return Validation("missing writing_session_id in request body")
+	}
+	if err := services.ValidateTimeCapsule(time.Now(), req.RevealAt, req.CastOnReveal); err != nil {
+		return Validation("%v", err)
+	}
+
+	session, err := s.store.GetWritingSessionById(ctx, req.WritingSessionID)
+	if err != nil {
+		return err
+	}
+	if err := authorizeUser(r, session.UserID); err != nil {
+		return err
+	}
+	if session.EndingTimestamp == nil {
+		return Conflict("writing session %s hasn't ended yet", session.ID)
+	}
+	if !session.IsValidAnky() {
+		return Validation("writing session %s lasted less than eight minutes", session.ID)
+	}
+	if session.PasteFlagged {
+		return Validation("writing session %s has pasted text, only typed sessions become ankys", session.ID)
+	}
+	if session.Suspect {
+		return Validation("writing session %s doesn't look typed by hand, only typed sessions become ankys", session.ID)
+	}
+	if err := s.archive.Rehydrate(ctx, session); err != nil {
+		return err
+	}
+	existing, err := s.store.GetAnkyByWritingSessionID(ctx, session.ID)
+	if err == nil {
+		return Conflict("writing session %s already has anky %s", session.ID, existing.ID)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	license, err := s.licenseForSubmission(ctx, session.UserID.String(), req.License)
+	if err != nil {
+		return err
+	}
+	prompt := req.ChosenPrompt
+	if prompt == "" {
+		prompt = session.Prompt
+	}
+
+	anky := types.NewAnky(session.ID, prompt, session.UserID)
+	anky.License = license
+	anky.LastUpdatedAt = anky.CreatedAt
+	if req.RevealAt != nil {
+		revealAt := req.RevealAt.UTC()
+		anky.RevealAt = &revealAt
+		anky.CastOnReveal = req.CastOnReveal
+	}
+	err = s.store.CreateAnky(ctx, anky)
+	if errors.Is(err, storage.ErrSessionHasAnky) {
+		return Conflict("writing session %s already has an anky", session.ID)
+	}
+	if err != nil {
+		return err
+	}
+	session.AnkyID = &anky.ID
+	if err := s.store.UpdateWritingSession(ctx, session); err != nil {
+		return fmt.Errorf("error linking anky to writing session: %w", err)
+	}
+	log.Printf("🎨 Created anky %s for writing session %s", anky.ID, session.ID)
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	writing := session.Writing
+	s.runInBackground(func(ctx context.Context) {
+		if err := ankyService.ProcessStoredAnky(ctx, anky, writing); err != nil {
+			log.Printf("❌ Error processing anky %s: %v", anky.ID, err)
+		}
+	})
+
+	return WriteJSON(w, http.StatusCreated, anky)
+}
+
+// GET /ankys/{id}/status, /{frames|mobile}/ankys/{id}/status
+// Frames ask by the writing session ID and get the status they get from
+// /framesgiving/status/batch, frames sessions that aren't stored included.
+// The app asks by Anky ID. Requests without a client surface may send
+// either ID and get the app's response.
+func (s *APIServer) handleGetAnkyStatus(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	surface, err := negotiateClientSurface(w, r)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid anky ID: %v", err)
+	}
+
+	if surface == surfaceFrames {
+		status, err := s.sessionStatus(ctx, id.String())
+		if err != nil {
+			return err
+		}
+		status["session_id"] = id
+		return WriteJSON(w, http.StatusOK, status)
+	}
+
+	anky, err := s.store.GetAnkyByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) && surface == surfaceUnspecified {
+		anky, err = s.store.GetAnkyByWritingSessionID(ctx, id)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NotFound("anky not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	events, err := s.store.GetAnkyStatusEvents(ctx, anky.ID)
+	if err != nil {
+		return err
+	}
+	anky = anky.Withheld()
+
+	return WriteJSON(w, http.StatusOK, ankyStatusResponse{
+		AnkyID:           anky.ID,
+		WritingSessionID: anky.WritingSessionID,
+		Status:           anky.Status,
+		ImageURL:         anky.ImageURL,
+		ImageIPFSHash:    anky.ImageIPFSHash,
+		CastHash:         anky.CastHash,
+		TokenAddress:     anky.TokenAddress,
+		StorageDegraded:  anky.StorageDegraded,
+		MetadataURI:      anky.MetadataURI,
+		RevealAt:         anky.RevealAt,
+		RevealedAt:       anky.RevealedAt,
+		LastUpdatedAt:    anky.LastUpdatedAt,
+		Events:           events,
+	})
+}
+
+// PATCH /ankys/{id}
+// Lets admins correct an Anky the pipeline left in a bad state. Only the
+// fields present in the body change; licenses go through PUT /ankys/{id}/license.
+// The body carries the version the Anky was read at, so a correction never
+// overwrites a change the admin hasn't seen.
+func (s *APIServer) handlePatchAnky(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	var req struct {
+		Status          *string `json:"status"`
+		ImageURL        *string `json:"image_url"`
+		ImageIPFSHash   *string `json:"image_ipfs_hash"`
+		CastHash        *string `json:"cast_hash"`
+		Ticker          *string `json:"ticker"`
+		TokenName       *string `json:"token_name"`
+		MetadataURI     *string `json:"metadata_uri"`
+		StorageDegraded *bool   `json:"storage_degraded"`
+		FID             *int    `json:"fid"`
+		Version         int     `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if req.Version <= 0 {
+		return Validation("version is required")
+	}
+
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+
+	changed := make(map[string]string)
+	setString := func(field string, value *string, target *string) {
+		if value != nil && *value != *target {
+			changed[field] = fmt.Sprintf("%q -> %q", *target, *value)
+			*target = *value
+		}
+	}
+	setString("status", req.Status, &anky.Status)
+	setString("image_url", req.ImageURL, &anky.ImageURL)
+	setString("image_ipfs_hash", req.ImageIPFSHash, &anky.ImageIPFSHash)
+	setString("cast_hash", req.CastHash, &anky.CastHash)
+	setString("ticker", req.Ticker, &anky.Ticker)
+	setString("token_name", req.TokenName, &anky.TokenName)
+	setString("metadata_uri", req.MetadataURI, &anky.MetadataURI)
+	if req.StorageDegraded != nil && *req.StorageDegraded != anky.StorageDegraded {
+		changed["storage_degraded"] = fmt.Sprintf("%t -> %t", anky.StorageDegraded, *req.StorageDegraded)
+		anky.StorageDegraded = *req.StorageDegraded
+	}
+	if req.FID != nil && *req.FID != anky.FID {
+		changed["fid"] = fmt.Sprintf("%d -> %d", anky.FID, *req.FID)
+		anky.FID = *req.FID
+	}
+	if len(changed) == 0 {
+		return WriteJSON(w, http.StatusOK, anky)
+	}
+
+	anky.Version = req.Version
+	anky.LastUpdatedAt = time.Now().UTC()
+	if err := s.store.UpdateAnky(ctx, anky); err != nil {
+		return err
+	}
+
+	fields := make([]string, 0, len(changed))
+	for field := range changed {
+		fields = append(fields, field+": "+changed[field])
+	}
+	sort.Strings(fields)
+	detail := strings.Join(fields, ", ")
+	if adminID, ok := authenticatedUserID(r); ok {
+		detail = fmt.Sprintf("by admin %s: %s", adminID, detail)
+	}
+	if err := s.store.CreateAnkyStatusEvent(ctx, &types.AnkyStatusEvent{AnkyID: anky.ID, Status: "admin_correction", Detail: detail}); err != nil {
+		log.Printf("⚠️ Failed to record admin correction of anky %s: %v", anky.ID, err)
+	}
+	log.Printf("🛠️ Anky %s corrected %s", anky.ID, detail)
+
+	return WriteJSON(w, http.StatusOK, anky)
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var announcementKinds = map[string]bool{
+	types.AnnouncementSeason:   true,
+	types.AnnouncementFeature:  true,
+	types.AnnouncementDowntime: true,
+}
+
+// GET /announcements?since=2024-11-01T00:00:00Z&limit=50
+// The what's-new feed: announcements shown now, published after since when
+// given, most recent first, each with whether the user read it.
+func (s *APIServer) handleGetAnnouncements(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("no user ID in context")
+	}
+
+	var since time.Time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			return Validation("since must be an RFC 3339 timestamp")
+		}
+		since = parsed
+	}
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	announcements, err := s.store.GetPublishedAnnouncements(r.Context(), userID, since, limit)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, announcements)
+}
+
+// POST /announcements/read
+// Marks the announcements in {"ids": [...]} read, or every one shown now
+// when no ids are given.
+func (s *APIServer) handleMarkAnnouncementsRead(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("no user ID in context")
+	}
+	var req struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return Validation("error decoding request body: %v", err)
+		}
+	}
+
+	marked, err := s.store.MarkAnnouncementsRead(r.Context(), userID, req.IDs)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int64{"marked_read": marked})
+}
+
+// GET /admin/announcements
+// Lists every announcement, scheduled and expired ones included, most
+// recently published first.
+func (s *APIServer) handleGetAdminAnnouncements(w http.ResponseWriter, r *http.Request) error {
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	announcements, err := s.store.GetAnnouncements(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, announcements)
+}
+
+type announcementRequest struct {
+	Kind        *string    `json:"kind"`
+	Title       *string    `json:"title"`
+	Body        *string    `json:"body"`
+	URL         *string    `json:"url"`
+	PublishedAt *time.Time `json:"published_at"`
+	// RFC 3339, an empty string removes the expiry
+	ExpiresAt *string `json:"expires_at"`
+}
+
+// apply copies the fields present in the request onto the announcement.
+func (req *announcementRequest) apply(announcement *types.Announcement) error {
+	if req.Kind != nil {
+		announcement.Kind = *req.Kind
+	}
+	if req.Title != nil {
+		announcement.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Body != nil {
+		announcement.Body = strings.TrimSpace(*req.Body)
+	}
+	if req.URL != nil {
+		announcement.URL = strings.TrimSpace(*req.URL)
+	}
+	if req.PublishedAt != nil {
+		announcement.PublishedAt = *req.PublishedAt
+	}
+	if req.ExpiresAt != nil {
+		announcement.ExpiresAt = nil
+		if *req.ExpiresAt != "" {
+			expiresAt, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+			if err != nil {
+				return Validation("expires_at must be an RFC 3339 timestamp")
+			}
+			announcement.ExpiresAt = &expiresAt
+		}
+	}
+
+	if !announcementKinds[announcement.Kind] {
+		return Validation("kind must be season, feature or downtime")
+	}
+	if announcement.Title == "" || len(announcement.Title) > 255 {
+		return Validation("title is required and at most 255 characters")
+	}
+	if announcement.URL != "" && !strings.HasPrefix(announcement.URL, "https://") {
+		return Validation("url must be an https URL")
+	}
+	if announcement.ExpiresAt != nil && !announcement.PublishedAt.IsZero() && !announcement.ExpiresAt.After(announcement.PublishedAt) {
+		return Validation("expires_at must be after published_at")
+	}
+	return nil
+}
+
+// POST /admin/announcements
+// Posts an announcement to the what's-new feed. Kind and title are required;
+// it is published right away unless published_at schedules it.
+func (s *APIServer) handleCreateAnnouncement(w http.ResponseWriter, r *http.Request) error {
+	var req announcementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	announcement := &types.Announcement{}
+	if err := req.apply(announcement); err != nil {
+		return err
+	}
+	if announcement.ExpiresAt != nil && announcement.PublishedAt.IsZero() && !announcement.ExpiresAt.After(s.store.Clock().Now()) {
+		return Validation("expires_at must be in the future")
+	}
+	if err := s.store.CreateAnnouncement(r.Context(), announcement); err != nil {
+		return err
+	}
+	log.Printf("📢 Announcement %s (%s) published at %s: %s", announcement.ID, announcement.Kind,
+		announcement.PublishedAt.UTC().Format(time.RFC3339), announcement.Title)
+
+	return WriteJSON(w, http.StatusCreated, announcement)
+}
+
+// PATCH /admin/announcements/{id}
+// Updates an announcement. Only the fields present in the body change.
+func (s *APIServer) handleUpdateAnnouncement(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid announcement id: %v", err)
+	}
+
+	var req announcementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	announcement, err := s.store.GetAnnouncement(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := req.apply(announcement); err != nil {
+		return err
+	}
+	if err := s.store.UpdateAnnouncement(r.Context(), announcement); err != nil {
+		return err
+	}
+	log.Printf("📢 Announcement %s updated: %s", announcement.ID, announcement.Title)
+
+	return WriteJSON(w, http.StatusOK, announcement)
+}
+
+// DELETE /admin/announcements/{id}
+// Removes an announcement from the feed, with who read it.
+func (s *APIServer) handleDeleteAnnouncement(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid announcement id: %v", err)
+	}
+
+	deleted, err := s.store.DeleteAnnouncement(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return NotFound("announcement not found")
+	}
+	log.Printf("📢 Announcement %s deleted", id)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -1 +1,119 @@
 package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Scoped tokens are meant for bots, frames and integrations, so they are short lived
+const maxScopedTokenTTL = 30 * 24 * time.Hour
+
+// POST /auth/scoped-tokens
+// Issues a token carrying a subset of the caller's own scopes.
+func (s *APIServer) handleCreateScopedToken(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("no user ID in context")
+	}
+
+	var req struct {
+		Scopes   []string `json:"scopes"`
+		TTLHours int      `json:"ttl_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	if len(req.Scopes) == 0 {
+		return Validation("at least one scope is required")
+	}
+
+	granted := authenticatedScopes(r)
+	if !utils.HasScopes(granted, req.Scopes...) {
+		return Forbidden("cannot issue a token with scopes you do not have")
+	}
+
+	ttl := time.Duration(req.TTLHours) * time.Hour
+	if ttl <= 0 || ttl > maxScopedTokenTTL {
+		ttl = maxScopedTokenTTL
+	}
+	// A token can't outlive the one that issued it, or scoped tokens could
+	// keep renewing themselves
+	if expiresAt, ok := authenticatedTokenExpiry(r); ok {
+		remaining := time.Until(expiresAt).Truncate(time.Second)
+		if remaining <= 0 {
+			return Unauthorized("token expired")
+		}
+		if ttl > remaining {
+			ttl = remaining
+		}
+	}
+
+	token, err := utils.CreateScopedJWT(userID, req.Scopes, ttl)
+	if err != nil {
+		return fmt.Errorf("error creating scoped token: %w", err)
+	}
+	log.Printf("🔑 Issued scoped token for user %s with scopes %v", userID, req.Scopes)
+
+	return WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"token":      token,
+		"scopes":     req.Scopes,
+		"expires_at": time.Now().Add(ttl).Unix(),
+	})
+}
+
+// POST /users/{userId}/merge-into/{targetUserId}
+// Moves the history of an anonymous account to the registered account the
+// writer logged in with through Privy, then deletes the anonymous account and
+// revokes its tokens. The request is authenticated as the anonymous account
+// and proves the registered one with its token in target_token.
+func (s *APIServer) handleMergeAnonymousUser(w http.ResponseWriter, r *http.Request) error {
+	anonymousUserID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+	targetUserID, err := uuid.Parse(mux.Vars(r)["targetUserId"])
+	if err != nil {
+		return Validation("invalid target user ID: %v", err)
+	}
+
+	var req struct {
+		TargetToken string `json:"target_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if !utils.HasScopes(authenticatedScopes(r), utils.ScopeAdmin) {
+		claims, err := utils.ValidateJWT(req.TargetToken)
+		if err != nil {
+			return Unauthorized("invalid target_token")
+		}
+		if tokenUserID, err := utils.UserIDFromClaims(claims); err != nil || tokenUserID != targetUserID {
+			return Forbidden("target_token doesn't belong to user %s", targetUserID)
+		}
+	}
+
+	merge, err := s.store.MergeAnonymousUser(r.Context(), anonymousUserID, targetUserID)
+	if errors.Is(err, storage.ErrNotAnonymous) || errors.Is(err, storage.ErrMergeIntoSelf) {
+		return Conflict("%v", err)
+	}
+	if err != nil {
+		return err
+	}
+	if revokedUsers != nil {
+		revokedUsers.revoke(anonymousUserID)
+	}
+	log.Printf("🔀 Merged anonymous user %s into %s (%d sessions, %d ankys, %d badges, %d newen transactions)",
+		anonymousUserID, targetUserID, merge.WritingSessions, merge.Ankys, merge.Badges, merge.NewenTransactions)
+
+	return WriteJSON(w, http.StatusOK, merge)
+}
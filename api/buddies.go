@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+)
+
+const maxBuddyNudges = 50
+
+type buddyResponse struct {
+	OptedIn bool                `json:"opted_in"`
+	Buddy   *types.WritingBuddy `json:"buddy"`
+}
+
+// GET /users/{userId}/buddy
+// Whether the writer wants a buddy and, once paired, how their buddy is
+// keeping up: written today, current streak and last day written. Never what
+// they wrote.
+func (s *APIServer) handleGetBuddy(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	optedIn, err := s.store.IsOptedInToBuddies(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	buddy, err := services.NewWritingBuddyService(s.store).GetBuddy(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, buddyResponse{OptedIn: optedIn, Buddy: buddy})
+}
+
+// PUT /users/{userId}/buddy/opt-in
+// Puts the writer in line for a buddy, the pairing job picks them up on its
+// next run.
+func (s *APIServer) handleBuddyOptIn(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.OptInToBuddies(r.Context(), userID); err != nil {
+		return err
+	}
+	log.Printf("🤝 User %s opted in to writing buddies", userID)
+
+	return WriteJSON(w, http.StatusOK, buddyResponse{OptedIn: true})
+}
+
+// DELETE /users/{userId}/buddy/opt-in
+// Takes the writer out of pairing and ends their current pairing; their
+// buddy goes back in line.
+func (s *APIServer) handleBuddyOptOut(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.OptOutOfBuddies(r.Context(), userID); err != nil {
+		return err
+	}
+	log.Printf("🤝 User %s opted out of writing buddies", userID)
+
+	return WriteJSON(w, http.StatusOK, buddyResponse{OptedIn: false})
+}
+
+// DELETE /users/{userId}/buddy
+// Ends the writer's current pairing. Both stay in line and are paired with
+// someone new.
+func (s *APIServer) handleEndBuddy(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ended, err := s.store.EndBuddyPair(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if !ended {
+		return NotFound("%v", services.ErrNoBuddy)
+	}
+	log.Printf("🤝 User %s ended their buddy pairing", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// POST /users/{userId}/buddy/nudge
+// Reminds the writer's buddy to write today.
+func (s *APIServer) handleNudgeBuddy(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	nudge, err := services.NewWritingBuddyService(s.store).Nudge(r.Context(), userID)
+	switch {
+	case errors.Is(err, services.ErrNoBuddy):
+		return NotFound("%v", err)
+	case errors.Is(err, services.ErrNudgeTooSoon):
+		return newHTTPError(http.StatusTooManyRequests, CodeRateLimited, "%v", err)
+	case errors.Is(err, services.ErrBuddyWroteToday):
+		return Conflict("%v", err)
+	case err != nil:
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, nudge)
+}
+
+// GET /users/{userId}/buddy/nudges?unread=true&limit=20
+// The nudges the writer received, most recent first.
+func (s *APIServer) handleGetBuddyNudges(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	limit := 20
+	if parsedLimit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsedLimit > 0 {
+		limit = min(parsedLimit, maxBuddyNudges)
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	nudges, err := s.store.GetBuddyNudges(r.Context(), userID, unreadOnly, limit)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, nudges)
+}
+
+// POST /users/{userId}/buddy/nudges/read
+// Marks every nudge of the writer read.
+func (s *APIServer) handleMarkBuddyNudgesRead(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	marked, err := s.store.MarkBuddyNudgesRead(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int64{"marked_read": marked})
+}
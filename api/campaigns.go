@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Keeps a typo from paying out a thousand times the reward
+const maxNewenMultiplier = 10
+
+// GET /campaigns/active
+// The campaigns running now, latest start first, so clients can show their
+// banners.
+func (s *APIServer) handleGetActiveCampaigns(w http.ResponseWriter, r *http.Request) error {
+	campaigns, err := services.NewCampaignService(s.store).ActiveCampaigns(r.Context())
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, campaigns)
+}
+
+// GET /admin/campaigns
+// Lists every campaign, past and upcoming ones included, latest start first.
+func (s *APIServer) handleGetCampaigns(w http.ResponseWriter, r *http.Request) error {
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	campaigns, err := s.store.GetCampaigns(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, campaigns)
+}
+
+type campaignRequest struct {
+	Name            *string    `json:"name"`
+	Description     *string    `json:"description"`
+	BannerText      *string    `json:"banner_text"`
+	BannerURL       *string    `json:"banner_url"`
+	NewenMultiplier *float64   `json:"newen_multiplier"`
+	Prompt          *string    `json:"prompt"`
+	StartsAt        *time.Time `json:"starts_at"`
+	EndsAt          *time.Time `json:"ends_at"`
+}
+
+// apply copies the fields present in the request onto the campaign.
+func (req *campaignRequest) apply(campaign *types.Campaign) error {
+	if req.Name != nil {
+		campaign.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		campaign.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.BannerText != nil {
+		campaign.BannerText = strings.TrimSpace(*req.BannerText)
+	}
+	if req.BannerURL != nil {
+		campaign.BannerURL = strings.TrimSpace(*req.BannerURL)
+	}
+	if req.NewenMultiplier != nil {
+		campaign.NewenMultiplier = *req.NewenMultiplier
+	}
+	if req.Prompt != nil {
+		campaign.Prompt = strings.TrimSpace(*req.Prompt)
+	}
+	if req.StartsAt != nil {
+		campaign.StartsAt = *req.StartsAt
+	}
+	if req.EndsAt != nil {
+		campaign.EndsAt = *req.EndsAt
+	}
+
+	if campaign.Name == "" || len(campaign.Name) > 255 {
+		return Validation("name is required and at most 255 characters")
+	}
+	if len(campaign.BannerText) > 255 {
+		return Validation("banner_text is at most 255 characters")
+	}
+	if campaign.BannerURL != "" && !strings.HasPrefix(campaign.BannerURL, "https://") {
+		return Validation("banner_url must be an https URL")
+	}
+	if campaign.NewenMultiplier < 1 || campaign.NewenMultiplier > maxNewenMultiplier {
+		return Validation("newen_multiplier must be between 1 and %d", maxNewenMultiplier)
+	}
+	if campaign.NewenMultiplier == 1 && campaign.Prompt == "" {
+		return Validation("a campaign needs a newen_multiplier above 1 or a prompt")
+	}
+	if campaign.StartsAt.IsZero() || campaign.EndsAt.IsZero() {
+		return Validation("starts_at and ends_at are required")
+	}
+	if !campaign.EndsAt.After(campaign.StartsAt) {
+		return Validation("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// POST /admin/campaigns
+// Schedules a campaign. Name, starts_at and ends_at are required, and a
+// newen_multiplier above 1 or a prompt for it to do anything.
+func (s *APIServer) handleCreateCampaign(w http.ResponseWriter, r *http.Request) error {
+	var req campaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	campaign := &types.Campaign{NewenMultiplier: 1}
+	if err := req.apply(campaign); err != nil {
+		return err
+	}
+	if !campaign.EndsAt.After(s.store.Clock().Now()) {
+		return Validation("ends_at must be in the future")
+	}
+	if err := s.store.CreateCampaign(r.Context(), campaign); err != nil {
+		return err
+	}
+	log.Printf("🎉 Campaign %s scheduled from %s to %s: %s (newen x%g)", campaign.ID,
+		campaign.StartsAt.UTC().Format(time.RFC3339), campaign.EndsAt.UTC().Format(time.RFC3339),
+		campaign.Name, campaign.NewenMultiplier)
+
+	return WriteJSON(w, http.StatusCreated, campaign)
+}
+
+// PATCH /admin/campaigns/{id}
+// Updates a campaign. Only the fields present in the body change.
+func (s *APIServer) handleUpdateCampaign(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid campaign id: %v", err)
+	}
+
+	var req campaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	campaign, err := s.store.GetCampaign(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := req.apply(campaign); err != nil {
+		return err
+	}
+	if err := s.store.UpdateCampaign(r.Context(), campaign); err != nil {
+		return err
+	}
+	log.Printf("🎉 Campaign %s updated: %s", campaign.ID, campaign.Name)
+
+	return WriteJSON(w, http.StatusOK, campaign)
+}
+
+// DELETE /admin/campaigns/{id}
+// Removes a campaign. Rewards it already multiplied are kept.
+func (s *APIServer) handleDeleteCampaign(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid campaign id: %v", err)
+	}
+
+	deleted, err := s.store.DeleteCampaign(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return NotFound("campaign not found")
+	}
+	log.Printf("🎉 Campaign %s deleted", id)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+)
+
+const (
+	// Public responses the CDN keeps until the Anky changes and is purged;
+	// browsers, which can't be purged, check back sooner
+	cdnCacheControl = "public, max-age=300, s-maxage=604800, stale-while-revalidate=3600"
+	// Responses still expected to change soon, e.g. Ankys in the pipeline
+	shortCacheControl = "public, max-age=15"
+)
+
+// setAnkyCacheHeaders lets the CDN cache a public response about the Anky,
+// tagged so it is purged when the Anky changes. Responses that may still
+// change on their own are only cached briefly.
+func setAnkyCacheHeaders(w http.ResponseWriter, ankyID string, final bool) {
+	w.Header().Set(services.SurrogateKeyHeader, services.AnkySurrogateKey(ankyID))
+	if final {
+		w.Header().Set("Cache-Control", cdnCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", shortCacheControl)
+	}
+}
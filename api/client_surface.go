@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// clientSurface is the kind of client calling an endpoint that frames and
+// the mobile app call with different shapes: frames identify writers by
+// their FID and sessions by the ID they were set up with, the app by the
+// user's UUID and the Ankys it created.
+type clientSurface string
+
+const (
+	surfaceFrames clientSurface = "frames"
+	surfaceMobile clientSurface = "mobile"
+	// Requests that didn't say, answered the way they were before clients
+	// had to, until the legacy routes are sunset
+	surfaceUnspecified clientSurface = ""
+)
+
+// Clients pick their surface with the X-Anky-Client header, or a /frames or
+// /mobile path segment, which wins over the header.
+const (
+	clientSurfaceHeader = "X-Anky-Client"
+	// Prefix of the routes pinning the surface, after the version prefix
+	clientSurfacePathSegment = "/{client:frames|mobile}"
+)
+
+// requestedClientSurface reads the surface from the path segment or the
+// X-Anky-Client header, in that order.
+func requestedClientSurface(r *http.Request) (clientSurface, error) {
+	if client, ok := mux.Vars(r)["client"]; ok {
+		return clientSurface(client), nil
+	}
+	header := strings.ToLower(strings.TrimSpace(r.Header.Get(clientSurfaceHeader)))
+	switch clientSurface(header) {
+	case surfaceFrames, surfaceMobile, surfaceUnspecified:
+		return clientSurface(header), nil
+	}
+	return surfaceUnspecified, Validation("invalid %s header %q, expected frames or mobile", clientSurfaceHeader, header)
+}
+
+// negotiateClientSurface is requestedClientSurface for handlers whose
+// response depends on the surface, which caches need to know.
+func negotiateClientSurface(w http.ResponseWriter, r *http.Request) (clientSurface, error) {
+	w.Header().Add("Vary", clientSurfaceHeader)
+	return requestedClientSurface(r)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+)
+
+// GET /users/{userId}/dataset-opt-in
+// Whether the writer contributes to the public research dataset.
+func (s *APIServer) handleGetDatasetOptIn(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	optedIn, err := s.store.IsOptedInToResearchDataset(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]bool{"opted_in": optedIn})
+}
+
+// PUT /users/{userId}/dataset-opt-in
+// Contributes the statistics of the sessions the writer writes from now on
+// to the public research dataset: lengths, word counts and times, never the
+// writing or who wrote it.
+func (s *APIServer) handleDatasetOptIn(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.OptInToResearchDataset(r.Context(), userID); err != nil {
+		return err
+	}
+	log.Printf("📊 User %s opted in to the research dataset", userID)
+
+	return WriteJSON(w, http.StatusOK, map[string]bool{"opted_in": true})
+}
+
+// DELETE /users/{userId}/dataset-opt-in
+// Stops contributing. Datasets already published keep their numbers.
+func (s *APIServer) handleDatasetOptOut(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.OptOutOfResearchDataset(r.Context(), userID); err != nil {
+		return err
+	}
+	log.Printf("📊 User %s opted out of the research dataset", userID)
+
+	return WriteJSON(w, http.StatusOK, map[string]bool{"opted_in": false})
+}
+
+// GET /datasets/latest
+// The most recent research dataset and where to download it from IPFS.
+func (s *APIServer) handleGetLatestDataset(w http.ResponseWriter, r *http.Request) error {
+	dataset, err := s.store.GetLatestResearchDataset(r.Context())
+	if err != nil {
+		return err
+	}
+	dataset.URL = services.IPFSGatewayURL(dataset.IPFSHash)
+	return WriteJSON(w, http.StatusOK, dataset)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/jackc/pgx/v4"
+)
+
+// Machine readable codes sent with every error response. Clients branch on
+// these, so they never change once released.
+const (
+	CodeValidation            = "validation_failed"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeNotFound              = "not_found"
+	CodeConflict              = "conflict"
+	CodeVersionConflict       = "version_conflict"
+	CodeInsufficientBalance   = "insufficient_balance"
+	CodeRateLimited           = "rate_limited"
+	CodeQuotaExceeded         = "quota_exceeded"
+	CodeTooLarge              = "request_too_large"
+	CodeNotAcceptable         = "not_acceptable"
+	CodeFeatureDisabled       = "feature_disabled"
+	CodeCapabilityUnavailable = "capability_unavailable"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeRequestInProgress     = "request_in_progress"
+	CodeLinkedAccountMismatch = "linked_account_mismatch"
+	CodeAnkySealed            = "anky_sealed"
+	CodeClockMismatch         = "clock_mismatch"
+	CodeAccountDeleting       = "account_deletion_scheduled"
+	CodeTimeout               = "database_timeout"
+	CodeInternal              = "internal_error"
+)
+
+const internalErrorMessage = "something went wrong on our side, please try again later"
+
+// HTTPError is an error a handler returns to choose the response status. Its
+// message is shown to the client; the wrapped error is only logged.
+type HTTPError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+func newHTTPError(status int, code string, format string, args ...interface{}) *HTTPError {
+	err := fmt.Errorf(format, args...)
+	return &HTTPError{Status: status, Code: code, Message: err.Error(), Err: errors.Unwrap(err)}
+}
+
+// Validation rejects a malformed or invalid request with 400.
+func Validation(format string, args ...interface{}) *HTTPError {
+	return newHTTPError(http.StatusBadRequest, CodeValidation, format, args...)
+}
+
+// Unauthorized answers requests without valid credentials with 401.
+func Unauthorized(format string, args ...interface{}) *HTTPError {
+	return newHTTPError(http.StatusUnauthorized, CodeUnauthorized, format, args...)
+}
+
+// Forbidden answers authenticated requests for someone else's data with 403.
+func Forbidden(format string, args ...interface{}) *HTTPError {
+	return newHTTPError(http.StatusForbidden, CodeForbidden, format, args...)
+}
+
+// NotFound answers requests for something that doesn't exist with 404.
+func NotFound(format string, args ...interface{}) *HTTPError {
+	return newHTTPError(http.StatusNotFound, CodeNotFound, format, args...)
+}
+
+// Conflict rejects requests that clash with the current state with 409.
+func Conflict(format string, args ...interface{}) *HTTPError {
+	return newHTTPError(http.StatusConflict, CodeConflict, format, args...)
+}
+
+// Internal answers with a generic 500, keeping err out of the response.
+func Internal(err error) *HTTPError {
+	return &HTTPError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: internalErrorMessage, Err: err}
+}
+
+// writeError answers a failed handler. Errors that aren't an *HTTPError or a
+// known storage or service error are internal: they are logged and the client
+// gets a generic message instead of their text.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr *HTTPError
+	var versionErr *storage.VersionConflictError
+	var disabledErr *services.FeatureDisabledError
+	var capabilityErr *services.CapabilityError
+	switch {
+	case errors.As(err, &httpErr):
+		if httpErr.Status >= http.StatusInternalServerError {
+			log.Printf("❌ %s %s failed: %v", r.Method, r.URL.Path, httpErr.Err)
+		}
+		WriteJSON(w, httpErr.Status, ApiError{Error: httpErr.Message, Code: httpErr.Code})
+	case errors.Is(err, storage.ErrQueryCanceled):
+		// The client went away, nobody is left to read the response
+		log.Printf("⚠️ Request %s %s canceled during a database query", r.Method, r.URL.Path)
+	case errors.Is(err, storage.ErrQueryTimeout):
+		WriteJSON(w, http.StatusGatewayTimeout, ApiError{Error: "the database took too long to respond, please try again", Code: CodeTimeout})
+	case errors.As(err, &versionErr):
+		WriteJSON(w, http.StatusConflict, ConflictResponse{
+			Error:          versionErr.Error(),
+			Code:           CodeVersionConflict,
+			CurrentVersion: versionErr.CurrentVersion,
+			Retry:          "reload the resource, reapply your change and send it again with the current version",
+		})
+	case errors.As(err, &disabledErr):
+		WriteJSON(w, http.StatusServiceUnavailable, ApiError{Error: disabledErr.Error(), Code: CodeFeatureDisabled})
+	case errors.As(err, &capabilityErr):
+		WriteJSON(w, http.StatusServiceUnavailable, ApiError{Error: capabilityErr.Error(), Code: CodeCapabilityUnavailable})
+	case errors.Is(err, pgx.ErrNoRows):
+		WriteJSON(w, http.StatusNotFound, ApiError{Error: "not found", Code: CodeNotFound})
+	default:
+		log.Printf("❌ %s %s failed: %v", r.Method, r.URL.Path, err)
+		WriteJSON(w, http.StatusInternalServerError, ApiError{Error: internalErrorMessage, Code: CodeInternal})
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+// seasonFidCap is how many FIDs Anky registers during the current season.
+// Unlinked FIDs keep counting toward it.
+const seasonFidCap = 504
+
+// maxFarcasterUnlinks is how many times a user can unlink and restart the FID flow.
+var maxFarcasterUnlinks = envInt("FARCASTER_MAX_UNLINKS", 2)
+
+// DELETE /users/{userId}/farcaster
+// Unlinks the user's Farcaster account (lost signer, half-completed
+// registration) so the FID flow can be started again.
+func (s *APIServer) handleUnlinkFarcaster(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	authUserID, ok := authenticatedUserID(r)
+	if !ok || (authUserID != userID && !utils.HasScopes(authenticatedScopes(r), utils.ScopeAdmin)) {
+		return Forbidden("you can only unlink your own farcaster account")
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return Validation("error decoding request body: %v", err)
+		}
+	}
+
+	fid, unlinks, err := s.store.UnlinkFarcasterUser(r.Context(), userID, req.Reason, maxFarcasterUnlinks)
+	if errors.Is(err, storage.ErrUnlinkLimitReached) {
+		return Conflict("this account already used all its farcaster resets, please contact support")
+	}
+	if err != nil {
+		return err
+	}
+	if fid == 0 {
+		log.Printf("ℹ️ User %s had no farcaster account linked", userID)
+	} else {
+		log.Printf("🔓 Unlinked FID %d from user %s", fid, userID)
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"unlinked_fid":     fid,
+		"resets_remaining": maxFarcasterUnlinks - unlinks,
+	})
+}
+
+// checkCanStartFIDFlow guards the FID registration flow: the season must not
+// be full and the user must not already have a FID linked.
+func (s *APIServer) checkCanStartFIDFlow(ctx context.Context, userID uuid.UUID) error {
+	numberOfFids, err := s.store.CountNumberOfFids(ctx)
+	if err != nil {
+		return fmt.Errorf("error counting FIDs: %w", err)
+	}
+	if numberOfFids >= seasonFidCap {
+		return Conflict("the fifth season of anky is complete")
+	}
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	if user.FID > 0 {
+		return Conflict("this account already has FID %d, unlink it before registering a new one", user.FID)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+)
+
+// Neynar webhook deliveries are one cast
+const maxNeynarWebhookBytes = 1 << 20
+
+// POST /farcaster/webhook
+// Where Neynar delivers cast.created events for casts mentioning the Anky
+// account or replying to its casts, signed with NEYNAR_WEBHOOK_SECRET. New
+// ones are recorded and, with FARCASTER_AUTO_REPLY on, answered in the
+// background so Neynar gets its response right away.
+func (s *APIServer) handleNeynarWebhook(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxNeynarWebhookBytes))
+	if err != nil {
+		return Validation("error reading request body: %v", err)
+	}
+
+	mentionService := services.NewFarcasterMentionService(s.store)
+	mention, err := mentionService.HandleWebhook(r.Context(), body, r.Header.Get(services.NeynarSignatureHeader))
+	switch {
+	case errors.Is(err, services.ErrNeynarWebhookRejected):
+		log.Printf("⚠️ Rejected Neynar webhook: %v", err)
+		return Unauthorized("webhook rejected")
+	case errors.Is(err, services.ErrInvalidNeynarWebhook):
+		return Validation("%v", err)
+	case err != nil:
+		return err
+	}
+
+	if mention != nil {
+		s.runInBackground(func(ctx context.Context) {
+			if err := mentionService.Reply(ctx, mention); err != nil {
+				log.Printf("❌ Error replying to cast %s: %v", mention.CastHash, err)
+			}
+		})
+	}
+	return WriteJSON(w, http.StatusOK, map[string]string{"status": "received"})
+}
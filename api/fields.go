@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// Fields a client may ask for with ?fields=, read from the JSON tags of each
+// response type. Fields that are never serialized can't be selected.
+var (
+	privateUserFields    = jsonFieldNames(types.PrivateUser{})
+	publicUserFields     = jsonFieldNames(types.PublicUser{})
+	ankyFields           = jsonFieldNames(types.Anky{})
+	writingSessionFields = jsonFieldNames(types.WritingSession{})
+)
+
+func jsonFieldNames(v any) map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// selectedFields parses ?fields=id,fid,settings. It returns nil when the
+// request doesn't select fields, and rejects fields outside allowed.
+func selectedFields(r *http.Request, allowed map[string]bool) ([]string, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+
+	var fields, unknown []string
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !allowed[field] {
+			unknown = append(unknown, field)
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if len(unknown) > 0 {
+		names := make([]string, 0, len(allowed))
+		for name := range allowed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, Validation("unknown fields: %s, expected any of %s", strings.Join(unknown, ", "), strings.Join(names, ", "))
+	}
+	if len(fields) == 0 {
+		return nil, Validation("no fields selected")
+	}
+	return fields, nil
+}
+
+// WriteSelectedJSON answers like WriteJSON, keeping only the fields the
+// request selected with ?fields= in v, or in each element when v is a list.
+func WriteSelectedJSON(w http.ResponseWriter, r *http.Request, status int, v any, allowed map[string]bool) error {
+	fields, err := selectedFields(r, allowed)
+	if err != nil {
+		return err
+	}
+	if fields == nil {
+		return WriteJSON(w, status, v)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, []byte("null")) {
+		return WriteJSON(w, status, v)
+	}
+	if bytes.HasPrefix(data, []byte("[")) {
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		for i, item := range items {
+			items[i] = pickFields(item, fields)
+		}
+		return WriteJSON(w, status, items)
+	}
+
+	var item map[string]json.RawMessage
+	if err := json.Unmarshal(data, &item); err != nil {
+		return err
+	}
+	return WriteJSON(w, status, pickFields(item, fields))
+}
+
+func pickFields(item map[string]json.RawMessage, fields []string) map[string]json.RawMessage {
+	picked := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := item[field]; ok {
+			picked[field] = value
+		}
+	}
+	return picked
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// framesUser returns the account of a frames writer, creating an anonymous
+// one linked to the FID the first time the FID submits a session.
+func (s *APIServer) framesUser(ctx context.Context, fid string) (*types.User, error) {
+	parsedFID, err := strconv.Atoi(fid)
+	if err != nil || parsedFID <= 0 {
+		return nil, Validation("invalid fid %q", fid)
+	}
+
+	// Two sessions of a new FID must not create two accounts
+	s.framesUsers.Lock()
+	defer s.framesUsers.Unlock()
+
+	user, err := s.store.GetUserByFID(ctx, parsedFID)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	user = types.NewUser(uuid.New(), true, time.Now().UTC(), &types.UserMetadata{})
+	if user == nil {
+		return nil, fmt.Errorf("failed to create user for fid %d", parsedFID)
+	}
+	user.FID = parsedFID
+	if err := s.store.CreateUserWithRelations(ctx, user); err != nil {
+		return nil, err
+	}
+	log.Printf("👤 Created user %s for frames FID %d", user.ID, parsedFID)
+	return user, nil
+}
+
+// enforceFramesCap counts a submission of an anonymous frames FID and stops
+// FIDs that already used up FRAMES_DAILY_SUBMISSION_CAP (5 by default) today.
+// Registered users and the FIDs in FRAMES_CAP_EXEMPT_FIDS are never capped.
+func (s *APIServer) enforceFramesCap(w http.ResponseWriter, r *http.Request, fid string) error {
+	parsedFID, err := strconv.Atoi(fid)
+	if err != nil || parsedFID <= 0 {
+		return Validation("invalid fid %q", fid)
+	}
+	if framesCapExempt(parsedFID) {
+		return nil
+	}
+	user, err := s.store.GetUserByFID(r.Context(), parsedFID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if user != nil && user.IsRegistered() {
+		return nil
+	}
+
+	dailyCap := envInt("FRAMES_DAILY_SUBMISSION_CAP", 5)
+	_, allowed, err := s.store.ConsumeFramesSubmission(r.Context(), parsedFID, dailyCap)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		log.Printf("🚧 FID %d reached the daily frames cap of %d", parsedFID, dailyCap)
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(nextUTCMidnight()).Seconds())+1))
+		return newHTTPError(http.StatusTooManyRequests, CodeQuotaExceeded,
+			"you already wrote %d sessions today, that's plenty. come back tomorrow and keep writing", dailyCap)
+	}
+	return nil
+}
+
+func framesCapExempt(fid int) bool {
+	for _, exempt := range strings.Split(os.Getenv("FRAMES_CAP_EXEMPT_FIDS"), ",") {
+		if exemptFID, err := strconv.Atoi(strings.TrimSpace(exempt)); err == nil && exemptFID == fid {
+			return true
+		}
+	}
+	return false
+}
+
+// persistFramesWritingSession stores a session submitted through the frames
+// flow like the app's own sessions, so it is listed under the writer's user.
+// Submitting the same session again updates its record.
+func (s *APIServer) persistFramesWritingSession(ctx context.Context, parsed *utils.WritingSession) (*types.WritingSession, error) {
+	sessionID, err := uuid.Parse(parsed.SessionID)
+	if err != nil {
+		return nil, Validation("invalid session ID %q", parsed.SessionID)
+	}
+	user, err := s.framesUser(ctx, parsed.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.store.GetWritingSessionById(ctx, sessionID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+
+		sessionIndex := 0
+		previous, err := s.store.GetUserWritingSessions(ctx, user.ID, false, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(previous) > 0 {
+			sessionIndex = previous[0].SessionIndexForUser + 1
+		}
+
+		session = types.NewWritingSession(sessionID, user.ID, parsed.Prompt, sessionIndex, false)
+		session.StartingTimestamp = parseSessionTimestamp(parsed.Timestamp)
+		if err := s.store.CreateWritingSession(ctx, session); err != nil {
+			return nil, err
+		}
+	} else if session.UserID != user.ID {
+		return nil, Forbidden("writing session %s belongs to another user", sessionID)
+	}
+
+	timeSpent := int(parsed.Duration().Seconds())
+	endingTimestamp := session.StartingTimestamp.Add(parsed.Duration())
+	session.Writing = parsed.RawContent
+	session.WordsWritten = len(strings.Fields(parsed.RawContent))
+	session.TimeSpent = &timeSpent
+	session.EndingTimestamp = &endingTimestamp
+	session.IsAnky = timeSpent >= types.AnkySessionSeconds
+	session.Status = "completed"
+	if err := s.store.UpdateWritingSession(ctx, session); err != nil {
+		return nil, err
+	}
+	s.recordSessionFocus(ctx, parsed.SessionID, parsed.Focus)
+	s.recordSessionPaste(ctx, parsed.SessionID, parsed.Paste)
+	s.recordSessionSuspect(ctx, parsed.SessionID, parsed.Cheat)
+
+	return session, nil
+}
+
+// parseSessionTimestamp reads the starting timestamp line of a session long
+// string, which clients write in Unix milliseconds.
+func parseSessionTimestamp(value string) time.Time {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis).UTC()
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC()
+	}
+	return time.Now().UTC()
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/google/uuid"
+)
+
+// maxBatchStatusSessions caps how many sessions one batch status call can ask about
+const maxBatchStatusSessions = 50
+
+// framesSessionStatus returns the pipeline status of a frames session, read
+// from its metadata file.
+func framesSessionStatus(sessionID string) (map[string]interface{}, error) {
+	if strings.ContainsAny(sessionID, "/\\.") {
+		return nil, Validation("invalid session id")
+	}
+
+	metadata, err := services.ReadFramesAnkyMetadata(sessionID)
+	if os.IsNotExist(err) {
+		return map[string]interface{}{"status": "pending"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := map[string]interface{}{
+		"status":     "completed",
+		"token_name": metadata.TokenName,
+		"ticker":     metadata.Ticker,
+		"number":     metadata.Number,
+		"story":      metadata.Story,
+		"license":    metadata.License,
+	}
+
+	switch {
+	case metadata.Degraded():
+		// Pinning failed, the NFT resolves through data URI metadata until it is repaired
+		status["storage_degraded"] = true
+		status["metadata_uri"] = metadata.MetadataURI
+		status["image_url"] = metadata.ImageURL
+	case metadata.IPFSHash == "":
+		return map[string]interface{}{"status": "pending"}, nil
+	default:
+		status["ipfs_hash"] = metadata.IPFSHash
+	}
+
+	return status, nil
+}
+
+// sessionStatus returns the pipeline status of a session's Anky from the
+// database, falling back to the metadata file of frames sessions that aren't
+// stored there.
+func (s *APIServer) sessionStatus(ctx context.Context, sessionID string) (map[string]interface{}, error) {
+	if sessionUUID, err := uuid.Parse(sessionID); err == nil {
+		if anky, err := s.store.GetAnkyByWritingSessionID(ctx, sessionUUID); err == nil {
+			anky = anky.Withheld()
+			status := map[string]interface{}{
+				"status":           anky.Status,
+				"anky_id":          anky.ID,
+				"token_name":       anky.TokenName,
+				"ticker":           anky.Ticker,
+				"story":            anky.AnkyReflection,
+				"license":          anky.License,
+				"image_url":        anky.ImageURL,
+				"ipfs_hash":        anky.ImageIPFSHash,
+				"storage_degraded": anky.StorageDegraded,
+			}
+			if anky.StorageDegraded {
+				status["metadata_uri"] = anky.MetadataURI
+			}
+			if anky.Sealed() {
+				status["sealed"] = true
+				status["reveal_at"] = anky.RevealAt
+			}
+			s.addShareLink(ctx, status, sessionUUID)
+			return status, nil
+		}
+	}
+	status, err := framesSessionStatus(sessionID)
+	if err == nil && status["status"] != "pending" {
+		if sessionUUID, err := uuid.Parse(sessionID); err == nil {
+			s.addShareLink(ctx, status, sessionUUID)
+		}
+	}
+	return status, err
+}
+
+// addShareLink adds the slug of the session's Anky, when it has one, and
+// the link frames share.
+func (s *APIServer) addShareLink(ctx context.Context, status map[string]interface{}, sessionID uuid.UUID) {
+	status["share_url"] = ankyShareURL(sessionID.String())
+	if slug, err := s.store.GetAnkySlug(ctx, sessionID); err == nil {
+		status["slug"] = slug
+		status["share_url"] = ankyShareURL(slug)
+	}
+}
+
+// POST /framesgiving/status/batch
+// Returns the pipeline status of several frames sessions in one call, in the
+// order they were requested.
+func (s *APIServer) handleFramesV2BatchStatus(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		SessionIDs []string `json:"session_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	if len(req.SessionIDs) == 0 {
+		return Validation("missing session_ids in request body")
+	}
+	if len(req.SessionIDs) > maxBatchStatusSessions {
+		return Validation("at most %d session ids can be requested at once", maxBatchStatusSessions)
+	}
+
+	seen := make(map[string]bool, len(req.SessionIDs))
+	statuses := make([]map[string]interface{}, 0, len(req.SessionIDs))
+	for _, sessionID := range req.SessionIDs {
+		if seen[sessionID] {
+			continue
+		}
+		seen[sessionID] = true
+
+		status, err := s.sessionStatus(r.Context(), sessionID)
+		if err != nil {
+			log.Printf("❌ Error reading status for session %s: %v", sessionID, err)
+			status = map[string]interface{}{"status": "error", "error": err.Error()}
+		}
+		status["session_id"] = sessionID
+		statuses = append(statuses, status)
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"statuses": statuses,
+	})
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+)
+
+type readinessResponse struct {
+	Status       string                      `json:"status"`
+	Database     string                      `json:"database"`
+	Capabilities []services.CapabilityStatus `json:"capabilities"`
+}
+
+// GET /health
+// Liveness: the process is up and serving requests. It checks nothing else,
+// so a slow database never gets the instance restarted.
+func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /health/ready
+// Readiness: the database answers. The optional integrations are listed with
+// whether they are configured, but missing ones don't make the instance
+// unready, the endpoints that need them answer 503 on their own.
+func (s *APIServer) handleReadiness(w http.ResponseWriter, r *http.Request) error {
+	response := readinessResponse{
+		Status:       "ready",
+		Database:     services.StatusOperational,
+		Capabilities: services.Capabilities(),
+	}
+	status := http.StatusOK
+	if err := s.store.Ping(r.Context()); err != nil {
+		log.Printf("⚠️ Readiness check could not reach the database: %v", err)
+		response.Status = "unavailable"
+		response.Database = services.StatusDown
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return WriteJSON(w, status, response)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/utils"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotent replays the original response to retried POSTs instead of
+// running the handler again. Requests are keyed by their Idempotency-Key
+// header or, without one, by the key deriveKey finds in the body; requests
+// without either run as usual. Only successful responses are kept, so
+// requests that failed can be retried.
+func (s *APIServer) idempotent(deriveKey func(body []byte) string) func(http.Handler) http.Handler {
+	idempotency := services.NewIdempotencyService(s.store)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBufferedBody+1))
+			if err != nil {
+				WriteJSON(w, http.StatusBadRequest, ApiError{Error: "error reading request body: " + err.Error(), Code: CodeValidation})
+				return
+			}
+			if len(body) > maxBufferedBody {
+				WriteJSON(w, http.StatusRequestEntityTooLarge, ApiError{Error: "request body too large", Code: CodeTooLarge})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+			if key == "" {
+				key = deriveKey(body)
+			}
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				WriteJSON(w, http.StatusBadRequest, ApiError{Error: "Idempotency-Key must be at most 255 characters", Code: CodeValidation})
+				return
+			}
+
+			route, _ := unversionedPathTemplate(r)
+			// On authenticated routes a key only replays to the same user and
+			// path, anyone else reusing it is told so
+			request := body
+			if userID, ok := authenticatedUserID(r); ok {
+				request = append([]byte(userID.String()+" "+r.URL.Path+"\n"), body...)
+			}
+			record, err := idempotency.Claim(r.Context(), route, key, request)
+			switch {
+			case errors.Is(err, services.ErrIdempotencyKeyReused):
+				WriteJSON(w, http.StatusUnprocessableEntity, ApiError{Error: err.Error(), Code: CodeIdempotencyKeyReused})
+				return
+			case errors.Is(err, services.ErrRequestInProgress):
+				w.Header().Set("Retry-After", "5")
+				WriteJSON(w, http.StatusConflict, ApiError{Error: err.Error(), Code: CodeRequestInProgress})
+				return
+			case err != nil:
+				// Submissions still go through, only without replays
+				log.Printf("⚠️ Could not claim idempotency key %s for %s: %v", key, route, err)
+				next.ServeHTTP(w, r)
+				return
+			case record != nil:
+				log.Printf("🔁 Replaying the response to %s for idempotency key %s", route, key)
+				if record.ContentType != "" {
+					w.Header().Set("Content-Type", record.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.Response)
+				return
+			}
+
+			capture := &responseCapture{ResponseWriter: w}
+			next.ServeHTTP(capture, r)
+
+			// Clients on flaky connections are often gone by now, which is
+			// exactly when their retry needs the response
+			ctx := context.WithoutCancel(r.Context())
+			if capture.status >= 200 && capture.status < 300 {
+				err = idempotency.Complete(ctx, route, key, capture.status, capture.Header().Get("Content-Type"), capture.body.Bytes())
+			} else {
+				err = idempotency.Release(ctx, route, key)
+			}
+			if err != nil {
+				log.Printf("⚠️ Could not save idempotency key %s for %s: %v", key, route, err)
+			}
+		})
+	}
+}
+
+// headerKey only keys requests by their Idempotency-Key header.
+func headerKey(body []byte) string {
+	return ""
+}
+
+// responseCapture keeps a copy of the status and body written through it.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// framesSessionKey keys frames submissions by the ID of the session they carry.
+func framesSessionKey(body []byte) string {
+	var req struct {
+		SessionLongString string `json:"session_long_string"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return sessionKey(req.SessionLongString)
+}
+
+// rawSessionKey keys raw submissions by the ID of the session they carry.
+func rawSessionKey(body []byte) string {
+	var req struct {
+		WritingString string `json:"writingString"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return sessionKey(req.WritingString)
+}
+
+// sessionKey is the key of a writing session string, from the session ID in
+// its header.
+func sessionKey(session string) string {
+	header, _, err := utils.ParseSessionHeader(session)
+	if err != nil || header.SessionID == "" {
+		return ""
+	}
+	return "session:" + header.SessionID
+}
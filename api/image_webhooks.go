@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/gorilla/mux"
+)
+
+// Completion callbacks are a job's status and image URLs
+const maxImageWebhookBytes = 1 << 20
+
+// POST /webhooks/images/{provider}?token=...
+// Where the image backend reports finished jobs when IMAGE_WEBHOOK_URL is
+// set, so the pipeline waiting on one moves on without polling. The token is
+// IMAGE_WEBHOOK_SECRET, the backend gets the whole URL with every job.
+func (s *APIServer) handleImageWebhook(w http.ResponseWriter, r *http.Request) error {
+	provider := mux.Vars(r)["provider"]
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxImageWebhookBytes))
+	if err != nil {
+		return Validation("error reading request body: %v", err)
+	}
+
+	job, err := services.HandleImageWebhook(provider, r.URL.Query().Get("token"), body)
+	switch {
+	case errors.Is(err, services.ErrImageWebhookRejected):
+		log.Printf("⚠️ Rejected image webhook from %s: %v", provider, err)
+		return Forbidden("webhook rejected")
+	case err != nil:
+		return Validation("%v", err)
+	}
+	log.Printf("🖼️ Image job %s on %s is %s", job.ID, provider, job.Status)
+
+	return WriteJSON(w, http.StatusOK, map[string]string{"status": "received"})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+)
+
+// GET /ankys/{id}/image?size=thumbnail|card|full
+// Redirects to the Anky's image in the given size (card by default), or
+// answers with the variant when the client accepts JSON. Images that aren't on
+// Cloudinary are served as they are.
+func (s *APIServer) handleGetAnkyImage(w http.ResponseWriter, r *http.Request) error {
+	ankyID, err := pathAnkyID(r)
+	if err != nil {
+		return err
+	}
+
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = types.ImageSizeCard
+	}
+	if !types.IsValidImageSize(size) {
+		return Validation("invalid size %q, expected one of %s", size, strings.Join(types.ImageSizes, ", "))
+	}
+
+	anky, err := s.store.GetAnkyByID(r.Context(), ankyID)
+	if err != nil {
+		return err
+	}
+	if anky.HeldForReview() {
+		return NotFound("anky not found")
+	}
+	if anky.Sealed() {
+		return errAnkySealed(anky)
+	}
+	if anky.ImageURL == "" {
+		return NotFound("this anky has no image yet")
+	}
+
+	variant, err := services.NewImageVariantService(s.store).GetAnkyImageVariant(r.Context(), anky, size)
+	if errors.Is(err, services.ErrImageNotOnCloudinary) {
+		log.Printf("⚠️ Image of anky %s is not on Cloudinary, serving the original", anky.ID)
+		variant = &types.AnkyImageVariant{AnkyID: anky.ID, Size: size, URL: anky.ImageURL}
+	} else if err != nil {
+		return err
+	}
+
+	// Variants never change once generated, but the Anky can get another
+	// image and is purged then
+	setAnkyCacheHeaders(w, anky.ID.String(), true)
+	w.Header().Add("Vary", "Accept")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		return WriteJSON(w, http.StatusOK, variant)
+	}
+	http.Redirect(w, r, variant.URL, http.StatusFound)
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// GET /leaderboard?metric=words&period=week&limit=50&offset=0
+// Writers ranked by words written, newen earned or sessions finished over the
+// last day, the last week or all time. Writers can opt out in their settings.
+func (s *APIServer) handleGetLeaderboard(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = types.LeaderboardMetricWords
+	}
+	if !slices.Contains(types.LeaderboardMetrics, metric) {
+		return Validation("invalid metric %q, expected one of %s", metric, strings.Join(types.LeaderboardMetrics, ", "))
+	}
+	period := query.Get("period")
+	if period == "" {
+		period = types.LeaderboardPeriodWeek
+	}
+	if !slices.Contains(types.LeaderboardPeriods, period) {
+		return Validation("invalid period %q, expected one of %s", period, strings.Join(types.LeaderboardPeriods, ", "))
+	}
+
+	limit := 50
+	offset := 0
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = min(parsedLimit, 100)
+		}
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	entries, err := s.store.GetLeaderboard(r.Context(), metric, period, limit, offset)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"metric":  metric,
+		"period":  period,
+		"limit":   limit,
+		"offset":  offset,
+		"entries": entries,
+	})
+}
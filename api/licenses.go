@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type ankyLicenseResponse struct {
+	AnkyID     uuid.UUID                  `json:"anky_id"`
+	License    string                     `json:"license"`
+	LicenseURL string                     `json:"license_url,omitempty"`
+	History    []*types.AnkyLicenseChange `json:"history"`
+}
+
+// licenseForSubmission validates the license chosen with a submission. When
+// none was chosen, the writer's default from their settings applies.
+func (s *APIServer) licenseForSubmission(ctx context.Context, userID string, requested string) (string, error) {
+	if requested != "" {
+		if !types.IsValidLicense(requested) {
+			return "", Validation("invalid license %q, expected one of %s", requested, strings.Join(types.Licenses, ", "))
+		}
+		return requested, nil
+	}
+
+	parsedUserID, err := uuid.Parse(userID)
+	if err != nil {
+		return types.DefaultLicense, nil
+	}
+	user, err := s.store.GetUserByID(ctx, parsedUserID)
+	if err != nil {
+		log.Printf("⚠️ Could not look up default license of user %s: %v", userID, err)
+		return types.DefaultLicense, nil
+	}
+	return user.PreferredLicense(), nil
+}
+
+// GET /ankys/{id}/license
+// Returns the license of the Anky and every change made to it since it was published.
+func (s *APIServer) handleGetAnkyLicense(w http.ResponseWriter, r *http.Request) error {
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+	return s.writeAnkyLicense(w, r, anky)
+}
+
+// PUT /ankys/{id}/license
+// Lets the owner move a published Anky to a more permissive license.
+func (s *APIServer) handleUpdateAnkyLicense(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		License string `json:"license"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if !types.IsValidLicense(req.License) {
+		return Validation("invalid license %q, expected one of %s", req.License, strings.Join(types.Licenses, ", "))
+	}
+
+	anky, err := s.ankyFromPath(r)
+	if err != nil {
+		return NotFound("anky not found")
+	}
+
+	userID, ok := authenticatedUserID(r)
+	if !ok || userID != anky.UserID {
+		return Forbidden("you can only change the license of your own ankys")
+	}
+
+	ankyService, err := services.NewAnkyService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating anky service: %w", err)
+	}
+	change, err := ankyService.RelicenseAnky(r.Context(), anky, userID, req.License)
+	if errors.Is(err, services.ErrLicenseNarrowing) {
+		return Conflict("%v", err)
+	}
+	if err != nil {
+		return err
+	}
+	if change != nil {
+		log.Printf("📜 Anky %s relicensed from %s to %s", anky.ID, change.PreviousLicense, change.NewLicense)
+	}
+
+	return s.writeAnkyLicense(w, r, anky)
+}
+
+func (s *APIServer) ankyFromPath(r *http.Request) (*types.Anky, error) {
+	ankyID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, err
+	}
+	return s.store.GetAnkyByID(r.Context(), ankyID)
+}
+
+func (s *APIServer) writeAnkyLicense(w http.ResponseWriter, r *http.Request, anky *types.Anky) error {
+	history, err := s.store.GetAnkyLicenseChanges(r.Context(), anky.ID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, ankyLicenseResponse{
+		AnkyID:     anky.ID,
+		License:    anky.License,
+		LicenseURL: types.LicenseURL(anky.License),
+		History:    history,
+	})
+}
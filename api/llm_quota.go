@@ -0,0 +1,58 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/utils"
+)
+
+// LLMQuota caps how many requests each user may make to the routes that call
+// the LLM, LLM_DAILY_REQUEST_QUOTA per UTC day (100 by default), and records
+// every request against the user. Admins are counted but never capped. It
+// must run after JWTAuth.
+func LLMQuota(store *storage.PostgresStore) func(http.Handler) http.Handler {
+	dailyLimit := envInt("LLM_DAILY_REQUEST_QUOTA", 100)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := authenticatedUserID(r)
+			if !ok {
+				writeError(w, r, Unauthorized("missing authenticated user"))
+				return
+			}
+
+			limit := dailyLimit
+			if utils.HasScopes(authenticatedScopes(r), utils.ScopeAdmin) {
+				limit = math.MaxInt32
+			}
+			inputBytes := max(r.ContentLength, 0)
+
+			used, allowed, err := store.ConsumeLLMRequest(r.Context(), userID, inputBytes, limit)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if !allowed {
+				log.Printf("[LLMQuota] User %s is out of LLM requests for today (%d), rejected %s", userID, dailyLimit, r.URL.Path)
+				w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(nextUTCMidnight()).Seconds())+1))
+				WriteJSON(w, http.StatusTooManyRequests, ApiError{Error: "daily LLM request quota reached, try again tomorrow", Code: CodeQuotaExceeded})
+				return
+			}
+
+			log.Printf("[LLMQuota] User %s made LLM request %d today on %s (%d bytes)", userID, used, r.URL.Path, inputBytes)
+			if limit == dailyLimit {
+				w.Header().Set("X-LLM-Quota-Limit", strconv.Itoa(dailyLimit))
+				w.Header().Set("X-LLM-Quota-Remaining", strconv.Itoa(max(dailyLimit-used, 0)))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func nextUTCMidnight() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
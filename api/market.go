@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+)
+
+// GET /ankys/{id}/market?history=24
+// Latest price and market cap of the Anky's token, plus up to `history`
+// previous snapshots, newest first.
+func (s *APIServer) handleGetAnkyMarket(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	ankyID, err := pathAnkyID(r)
+	if err != nil {
+		return err
+	}
+
+	anky, err := s.store.GetAnkyByID(ctx, ankyID)
+	if err != nil {
+		return err
+	}
+
+	market, err := services.NewMarketDataService(s.store).GetMarket(ctx, anky)
+	if errors.Is(err, services.ErrTokenNotFound) {
+		return NotFound("this anky's token is not trading yet")
+	}
+	if err != nil {
+		return err
+	}
+
+	history := []*types.AnkyMarketSnapshot{}
+	if historyStr := r.URL.Query().Get("history"); historyStr != "" {
+		if limit, err := strconv.Atoi(historyStr); err == nil && limit > 0 {
+			if limit > 500 {
+				limit = 500
+			}
+			history, err = s.store.GetAnkyMarketSnapshots(ctx, ankyID, limit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"market":  market,
+		"history": history,
+	})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/metrics"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("anky_http_requests_total",
+		"HTTP requests by method, route and status code.", "method", "route", "status")
+	httpRequestDuration = metrics.NewHistogramVec("anky_http_request_duration_seconds",
+		"Duration of HTTP requests by method and route.",
+		metrics.DurationBuckets, "method", "route")
+)
+
+// RequestMetrics counts the requests of every route with their status and
+// duration. Routes are labeled with their path template, without the version
+// prefix, so the legacy aliases add up with /v1.
+func RequestMetrics() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			route, _ := unversionedPathTemplate(r)
+			httpRequests.Inc(r.Method, route, strconv.Itoa(recorder.status))
+			httpRequestDuration.Observe(time.Since(started).Seconds(), r.Method, route)
+		})
+	}
+}
+
+// statusRecorder remembers the status code written through it. It passes
+// hijacking and flushing through, so websockets still upgrade.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metricsHandler serves the metrics to Prometheus. Scrapers authenticate with
+// METRICS_TOKEN as a bearer token; without it only admins can read them.
+func metricsHandler() http.Handler {
+	handler := metrics.Handler()
+	token := os.Getenv("METRICS_TOKEN")
+	if token == "" {
+		return JWTAuth(utils.ScopeAdmin)(handler)
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid metrics token", Code: CodeUnauthorized})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -8,8 +8,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ankylat/anky/server/logging"
+	"github.com/ankylat/anky/server/utils"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/time/rate"
+	"github.com/google/uuid"
 )
 
 // PrivyAuth is a middleware function that authenticates requests using Privy
@@ -21,13 +23,12 @@ func PrivyAuth(appID, appSecret string) func(http.Handler) http.Handler {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
 				log.Println("[PrivyAuth] Missing authorization header")
-				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Missing authorization header"})
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Missing authorization header", Code: CodeUnauthorized})
 				return
 			}
-			log.Printf("[PrivyAuth] Received authorization header: %s", authHeader[:10]+"...")
+			log.Printf("[PrivyAuth] Received authorization header: %s", logging.Secret(authHeader))
 
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			log.Printf("[PrivyAuth] Processing token: %s", token[:10]+"...")
 
 			// Define custom claims struct
 			type PrivyClaims struct {
@@ -58,7 +59,7 @@ func PrivyAuth(appID, appSecret string) func(http.Handler) http.Handler {
 
 			if err != nil {
 				log.Printf("[PrivyAuth] Token parsing failed: %v", err)
-				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: fmt.Sprintf("Invalid token: %v", err)})
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: fmt.Sprintf("Invalid token: %v", err), Code: CodeUnauthorized})
 				return
 			}
 			log.Println("[PrivyAuth] Token parsed successfully")
@@ -66,7 +67,7 @@ func PrivyAuth(appID, appSecret string) func(http.Handler) http.Handler {
 			claims, ok := parsedToken.Claims.(*PrivyClaims)
 			if !ok || !parsedToken.Valid {
 				log.Println("[PrivyAuth] Invalid token claims or token not valid")
-				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid token claims"})
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid token claims", Code: CodeUnauthorized})
 				return
 			}
 			log.Printf("[PrivyAuth] Claims extracted successfully for user: %s", claims.UserId)
@@ -75,14 +76,14 @@ func PrivyAuth(appID, appSecret string) func(http.Handler) http.Handler {
 			log.Printf("[PrivyAuth] Validating app ID: %s", claims.AppId)
 			if claims.AppId != appID {
 				log.Printf("[PrivyAuth] Invalid app ID: expected %s, got %s", appID, claims.AppId)
-				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid app ID"})
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid app ID", Code: CodeUnauthorized})
 				return
 			}
 
 			log.Printf("[PrivyAuth] Validating issuer: %s", claims.Issuer)
 			if claims.Issuer != "privy.io" {
 				log.Printf("[PrivyAuth] Invalid issuer: %s", claims.Issuer)
-				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid issuer"})
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid issuer", Code: CodeUnauthorized})
 				return
 			}
 
@@ -99,6 +100,149 @@ type contextKey string
 
 const UserIDKey contextKey = "userID"
 
+// AuthUserIDKey holds the uuid.UUID of the user authenticated through JWTAuth
+const AuthUserIDKey contextKey = "authUserID"
+
+// ScopesKey holds the scopes granted to the token authenticated through JWTAuth
+const ScopesKey contextKey = "scopes"
+
+// TokenExpiresAtKey holds when the token authenticated through JWTAuth expires
+const TokenExpiresAtKey contextKey = "tokenExpiresAt"
+
+// JWTAuth is a middleware function that authenticates requests using the
+// server issued JWT and checks that the token carries the required scopes.
+// Tokens of accounts scheduled for deletion are refused.
+func JWTAuth(requiredScopes ...string) func(http.Handler) http.Handler {
+	return jwtAuth(false, requiredScopes...)
+}
+
+// JWTAuthDuringDeletion is JWTAuth for the routes an account scheduled for
+// deletion can still use, to follow and cancel its deletion.
+func JWTAuthDuringDeletion(requiredScopes ...string) func(http.Handler) http.Handler {
+	return jwtAuth(true, requiredScopes...)
+}
+
+func jwtAuth(allowDeleting bool, requiredScopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Missing authorization header", Code: CodeUnauthorized})
+				return
+			}
+
+			tokenParts := strings.Split(authHeader, " ")
+			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid authorization header format", Code: CodeUnauthorized})
+				return
+			}
+
+			claims, err := utils.ValidateJWT(tokenParts[1])
+			if err != nil {
+				log.Printf("[JWTAuth] Token validation failed: %v", err)
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid token", Code: CodeUnauthorized})
+				return
+			}
+
+			userID, err := utils.UserIDFromClaims(claims)
+			if err != nil {
+				WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid token claims", Code: CodeUnauthorized})
+				return
+			}
+
+			if revokedUsers != nil {
+				revoked, deleting := revokedUsers.check(r.Context(), userID)
+				if revoked {
+					WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "This account was merged into another one, log in again", Code: CodeUnauthorized})
+					return
+				}
+				if deleting && !allowDeleting {
+					WriteJSON(w, http.StatusForbidden, ApiError{Error: "This account is scheduled for deletion, cancel it to use it again", Code: CodeAccountDeleting})
+					return
+				}
+			}
+
+			scopes := utils.ScopesFromClaims(claims)
+			if !utils.HasScopes(scopes, requiredScopes...) {
+				log.Printf("[JWTAuth] User %s is missing scopes %v (has %v)", userID, requiredScopes, scopes)
+				WriteJSON(w, http.StatusForbidden, ApiError{Error: fmt.Sprintf("Token is missing required scopes: %s", strings.Join(requiredScopes, ", ")), Code: CodeForbidden})
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), AuthUserIDKey, userID)
+			ctx = context.WithValue(ctx, ScopesKey, scopes)
+			if expiresAt, ok := utils.ExpiryFromClaims(claims); ok {
+				ctx = context.WithValue(ctx, TokenExpiresAtKey, expiresAt)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// OptionalJWTAuth authenticates the requests that carry a token like JWTAuth
+// with the required scopes, and lets those without one through
+// unauthenticated. Handlers check authenticatedUserID where they need a user.
+func OptionalJWTAuth(requiredScopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := JWTAuth(requiredScopes...)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticatedUserID returns the user authenticated through JWTAuth
+func authenticatedUserID(r *http.Request) (uuid.UUID, bool) {
+	userID, ok := r.Context().Value(AuthUserIDKey).(uuid.UUID)
+	return userID, ok
+}
+
+// authenticatedScopes returns the scopes granted to the token authenticated through JWTAuth
+func authenticatedScopes(r *http.Request) []string {
+	scopes, _ := r.Context().Value(ScopesKey).([]string)
+	return scopes
+}
+
+// authenticatedTokenExpiry returns when the token authenticated through
+// JWTAuth expires
+func authenticatedTokenExpiry(r *http.Request) (time.Time, bool) {
+	expiresAt, ok := r.Context().Value(TokenExpiresAtKey).(time.Time)
+	return expiresAt, ok
+}
+
+// authorizeUser checks that the request was authenticated through JWTAuth as
+// userID. Admins may act on behalf of any user.
+func authorizeUser(r *http.Request, userID uuid.UUID) error {
+	authUserID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("missing authenticated user")
+	}
+	if authUserID != userID && !utils.HasScopes(authenticatedScopes(r), utils.ScopeAdmin) {
+		return Forbidden("you can only access your own data")
+	}
+	return nil
+}
+
+// RequireUser only lets through requests authenticated as the user in the
+// {userId} route variable. It must run after JWTAuth.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := pathUserID(r)
+		if err == nil {
+			err = authorizeUser(r, userID)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Logger is a middleware function that logs request details
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -127,17 +271,3 @@ func Logger(next http.Handler) http.Handler {
 		)
 	})
 }
-
-// RateLimiter is a middleware function that implements rate limiting
-func RateLimiter(next http.Handler) http.Handler {
-	// Create a new rate limiter that allows 1 request per second with a burst of 5
-	limiter := rate.NewLimiter(1, 5)
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if the request is allowed based on the rate limit
-		if !limiter.Allow() {
-			WriteJSON(w, http.StatusTooManyRequests, ApiError{Error: "Too many requests"})
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	oEmbedProviderName = "Anky"
+	oEmbedProviderURL  = "https://anky.bot"
+	oEmbedDefaultWidth = 500
+	oEmbedMinWidth     = 200
+	oEmbedMaxWidth     = 1000
+	// Room under the square image for the excerpt and the link back
+	oEmbedTextHeight     = 180
+	oEmbedExcerptLength  = 280
+	oEmbedPublicPagePath = "anky"
+	// Where the public pages of Ankys are served
+	publicPageBaseURL = "https://farcaster.anky.bot"
+)
+
+// OEmbedResponse is a "rich" oEmbed response, see https://oembed.com.
+type OEmbedResponse struct {
+	Type            string `json:"type"`
+	Version         string `json:"version"`
+	Title           string `json:"title,omitempty"`
+	AuthorName      string `json:"author_name,omitempty"`
+	AuthorURL       string `json:"author_url,omitempty"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	CacheAge        int    `json:"cache_age,omitempty"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	HTML            string `json:"html"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+}
+
+// GET /oembed?url=https://farcaster.anky.bot/anky/{id or slug}&maxwidth=500
+// Lets blogs and newsletters embed the public page of an Anky with its image
+// and an excerpt of its story. Only JSON is served.
+func (s *APIServer) handleOEmbed(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		return newHTTPError(http.StatusNotImplemented, CodeNotAcceptable, "format %q is not supported, use json", format)
+	}
+
+	pageURL := query.Get("url")
+	id, err := publicAnkyIDFromURL(pageURL)
+	if err != nil {
+		return Validation("%v", err)
+	}
+
+	publicAnky, fid, err := s.findPublicAnky(r.Context(), id)
+	var lookupErr *publicAnkyLookupError
+	if errors.As(err, &lookupErr) {
+		return err
+	}
+	if err != nil {
+		w.Header().Set("Cache-Control", "public, max-age=30")
+		return NotFound("anky not found")
+	}
+	if fid != 0 {
+		publicAnky.AuthorFname = lookupFname(fid)
+	}
+
+	width := oEmbedDefaultWidth
+	if maxWidth, err := strconv.Atoi(query.Get("maxwidth")); err == nil && maxWidth > 0 {
+		width = min(max(maxWidth, oEmbedMinWidth), oEmbedMaxWidth)
+	}
+	height := width + oEmbedTextHeight
+	if maxHeight, err := strconv.Atoi(query.Get("maxheight")); err == nil && maxHeight > 0 && maxHeight < height {
+		// Shrink the image so the text still fits
+		width = max(maxHeight-oEmbedTextHeight, oEmbedMinWidth)
+		height = width + oEmbedTextHeight
+	}
+
+	title := publicAnky.TokenName
+	if title == "" {
+		title = "an anky"
+	}
+	response := &OEmbedResponse{
+		Type:         "rich",
+		Version:      "1.0",
+		Title:        title,
+		ProviderName: oEmbedProviderName,
+		ProviderURL:  oEmbedProviderURL,
+		HTML:         oEmbedHTML(publicAnky, pageURL, title, width),
+		Width:        width,
+		Height:       height,
+	}
+	if publicAnky.AuthorFname != "" {
+		response.AuthorName = publicAnky.AuthorFname
+		response.AuthorURL = "https://warpcast.com/" + url.PathEscape(publicAnky.AuthorFname)
+	}
+	if publicAnky.ImageURL != "" {
+		response.ThumbnailURL = publicAnky.ImageURL
+		response.ThumbnailWidth = width
+		response.ThumbnailHeight = width
+	}
+	// A sealed time capsule changes when it opens, so it isn't cached for long
+	final := publicAnky.Status == "completed" && !publicAnky.Sealed
+	if final {
+		response.CacheAge = 86400
+	}
+	setAnkyCacheHeaders(w, publicAnky.ID, final)
+
+	return WriteJSON(w, http.StatusOK, response)
+}
+
+// publicAnkyIDFromURL extracts the Anky ID, session ID or slug from the URL
+// of an Anky's public page, https://<anky.bot or a subdomain>/anky/{id}.
+func publicAnkyIDFromURL(rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", fmt.Errorf("url is required")
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", fmt.Errorf("invalid url %q", rawURL)
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host != "anky.bot" && !strings.HasSuffix(host, ".anky.bot") {
+		return "", fmt.Errorf("only anky.bot urls can be embedded")
+	}
+
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) != 2 || segments[0] != oEmbedPublicPagePath || segments[1] == "" {
+		return "", fmt.Errorf("url is not the public page of an anky")
+	}
+	return segments[1], nil
+}
+
+func oEmbedHTML(anky *PublicAnky, pageURL string, title string, width int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<blockquote class="anky-embed" style="max-width:%dpx;margin:0;font-family:sans-serif">`, width)
+	if anky.ImageURL != "" {
+		fmt.Fprintf(&b, `<a href="%s"><img src="%s" alt="%s" width="%d" height="%d" style="display:block;width:100%%;height:auto"></a>`,
+			html.EscapeString(pageURL), html.EscapeString(anky.ImageURL), html.EscapeString(title), width, width)
+	}
+	if excerpt := storyExcerpt(anky.Story, oEmbedExcerptLength); excerpt != "" {
+		fmt.Fprintf(&b, `<p>%s</p>`, html.EscapeString(excerpt))
+	}
+	byline := ""
+	if anky.AuthorFname != "" {
+		byline = " by @" + html.EscapeString(anky.AuthorFname)
+	}
+	fmt.Fprintf(&b, `<p><a href="%s">%s</a>%s on anky</p></blockquote>`, html.EscapeString(pageURL), html.EscapeString(title), byline)
+	return b.String()
+}
+
+// storyExcerpt cuts the story at the last word that fits in limit characters.
+func storyExcerpt(story string, limit int) string {
+	story = strings.Join(strings.Fields(story), " ")
+	runes := []rune(story)
+	if len(runes) <= limit {
+		return story
+	}
+	cut := string(runes[:limit])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, ".,;:!?") + "…"
+}
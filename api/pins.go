@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/gorilla/mux"
+)
+
+// GET /ipfs/pins
+// Lists what was pinned through Pinata, most recent first, with how many
+// duplicate uploads each pin saved.
+func (s *APIServer) handleGetIPFSPins(w http.ResponseWriter, r *http.Request) error {
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	pins, err := s.store.GetIPFSPins(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, pins)
+}
+
+// DELETE /ipfs/pins/{hash}?force=true
+// Unpins the IPFS hash from Pinata and forgets it. Hashes an Anky still points
+// at are refused unless forced, since unpinning them breaks the NFT.
+func (s *APIServer) handleDeleteIPFSPin(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	ipfsHash := mux.Vars(r)["hash"]
+
+	if r.URL.Query().Get("force") != "true" {
+		referenced, err := s.store.IsIPFSHashReferenced(ctx, ipfsHash)
+		if err != nil {
+			return err
+		}
+		if referenced {
+			return Conflict("%s is still used by an anky, pass force=true to unpin it anyway", ipfsHash)
+		}
+	}
+
+	pinataService, err := services.NewPinataService(s.store)
+	if err != nil {
+		return err
+	}
+	if err := pinataService.Unpin(ctx, ipfsHash); err != nil {
+		return err
+	}
+	deleted, err := s.store.DeleteIPFSPins(ctx, ipfsHash)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"ipfs_hash":       ipfsHash,
+		"unpinned":        true,
+		"records_removed": deleted,
+	})
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+)
+
+// Prompts used to live in this file, one "<fid> <prompt>" line per FID
+const legacyPromptsFile = "data/framesgiving/upcoming-prompts.txt"
+
+const promptHistoryLimit = 50
+
+type promptResponse struct {
+	Prompt  *types.WritingPrompt               `json:"prompt"`
+	History []*types.WritingPromptHistoryEntry `json:"history"`
+}
+
+// storeNextPrompt saves the prompt the LLM generated for the FID's next session.
+func (s *APIServer) storeNextPrompt(ctx context.Context, fid string, prompt string) error {
+	parsedFID, err := strconv.Atoi(fid)
+	if err != nil {
+		return Validation("invalid fid %q", fid)
+	}
+	return s.store.UpsertPrompt(ctx, &types.WritingPrompt{
+		FID:    parsedFID,
+		Prompt: prompt,
+		Source: types.PromptSourceLLM,
+	})
+}
+
+// importLegacyPromptsFile moves the prompts of the old flat file into the
+// prompts table, then renames the file so the import only runs once.
+func (s *APIServer) importLegacyPromptsFile(ctx context.Context) {
+	data, err := utils.Files.ReadFile(legacyPromptsFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("⚠️ Could not read legacy prompts file: %v", err)
+		}
+		return
+	}
+
+	imported := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		fid, err := strconv.Atoi(parts[0])
+		if len(parts) != 2 || err != nil {
+			log.Printf("⚠️ Skipping malformed prompt line: %s", line)
+			continue
+		}
+		err = s.store.UpsertPrompt(ctx, &types.WritingPrompt{
+			FID:    fid,
+			Prompt: strings.TrimSpace(parts[1]),
+			Source: types.PromptSourceImport,
+		})
+		if err != nil {
+			log.Printf("❌ Error importing prompt of FID %d, keeping the prompts file: %v", fid, err)
+			return
+		}
+		imported++
+	}
+
+	if err := utils.Files.Rename(legacyPromptsFile, legacyPromptsFile+".imported"); err != nil {
+		log.Printf("⚠️ Could not rename legacy prompts file: %v", err)
+	}
+	log.Printf("📥 Imported %d prompts from %s", imported, legacyPromptsFile)
+}
+
+func promptFIDFromPath(r *http.Request) (int, error) {
+	fid, err := strconv.Atoi(mux.Vars(r)["fid"])
+	if err != nil || fid < 0 {
+		return 0, Validation("invalid fid %q", mux.Vars(r)["fid"])
+	}
+	return fid, nil
+}
+
+// GET /admin/prompts
+// Lists the upcoming prompt of every FID, most recently updated first.
+func (s *APIServer) handleGetPrompts(w http.ResponseWriter, r *http.Request) error {
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	prompts, err := s.store.GetPrompts(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, prompts)
+}
+
+// GET /admin/prompts/{fid}
+// Returns the FID's upcoming prompt, null if it gets the default one, and its prompt history.
+func (s *APIServer) handleGetPrompt(w http.ResponseWriter, r *http.Request) error {
+	fid, err := promptFIDFromPath(r)
+	if err != nil {
+		return Validation("%v", err)
+	}
+
+	prompt, err := s.store.GetPrompt(r.Context(), fid)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	history, err := s.store.GetPromptHistory(r.Context(), fid, promptHistoryLimit)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, promptResponse{Prompt: prompt, History: history})
+}
+
+// PUT /admin/prompts/{fid}
+// Sets the FID's upcoming prompt. FID 0 sets the default prompt.
+func (s *APIServer) handleUpsertPrompt(w http.ResponseWriter, r *http.Request) error {
+	fid, err := promptFIDFromPath(r)
+	if err != nil {
+		return Validation("%v", err)
+	}
+
+	var req struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	req.Prompt = strings.TrimSpace(req.Prompt)
+	if req.Prompt == "" {
+		return Validation("prompt is required")
+	}
+
+	source := types.PromptSourceAdmin
+	if fid == types.DefaultPromptFID {
+		source = types.PromptSourceDefault
+	}
+	prompt := &types.WritingPrompt{FID: fid, Prompt: req.Prompt, Source: source}
+	if err := s.store.UpsertPrompt(r.Context(), prompt); err != nil {
+		return err
+	}
+	log.Printf("📝 Prompt of FID %d set", fid)
+
+	return WriteJSON(w, http.StatusOK, prompt)
+}
+
+// DELETE /admin/prompts/{fid}
+// Removes the FID's upcoming prompt so it gets the default one again. Its history is kept.
+func (s *APIServer) handleDeletePrompt(w http.ResponseWriter, r *http.Request) error {
+	fid, err := promptFIDFromPath(r)
+	if err != nil {
+		return Validation("%v", err)
+	}
+
+	if err := s.store.DeletePrompt(r.Context(), fid); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return NotFound("prompt not found")
+		}
+		return err
+	}
+	log.Printf("🗑️ Prompt of FID %d deleted", fid)
+
+	return WriteJSON(w, http.StatusOK, map[string]int{"deleted": 1})
+}
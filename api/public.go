@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+)
+
+// PublicAnky is the sanitized view of an Anky that is safe to show to anyone
+// holding the link. It never includes the raw writing or user identifiers.
+type PublicAnky struct {
+	ID            string    `json:"id"`
+	SessionID     string    `json:"session_id"`
+	Slug          string    `json:"slug,omitempty"`
+	ShareURL      string    `json:"share_url"`
+	Status        string    `json:"status"`
+	Story         string    `json:"story"`
+	ImageURL      string    `json:"image_url"`
+	ImageIPFSHash string    `json:"image_ipfs_hash"`
+	TokenName     string    `json:"token_name"`
+	Ticker        string    `json:"ticker"`
+	TokenAddress  string    `json:"token_address,omitempty"`
+	MetadataURI   string    `json:"metadata_uri,omitempty"`
+	Degraded      bool      `json:"storage_degraded"`
+	CastHash      string    `json:"cast_hash,omitempty"`
+	CastURL       string    `json:"cast_url,omitempty"`
+	AuthorFname   string    `json:"author_fname,omitempty"`
+	License       string    `json:"license"`
+	LicenseURL    string    `json:"license_url,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Time capsules show no story or image until they are revealed
+	Sealed   bool       `json:"sealed"`
+	RevealAt *time.Time `json:"reveal_at,omitempty"`
+	// Panels of the triptych, in order, for deep-dive sessions
+	Images []PublicAnkyImage `json:"images,omitempty"`
+}
+
+type PublicAnkyImage struct {
+	Position      int    `json:"position"`
+	ImageURL      string `json:"image_url"`
+	ImageIPFSHash string `json:"image_ipfs_hash"`
+}
+
+// GET /public/ankys/{id}
+// The id can be the Anky ID, the writing session ID it was created from or
+// its slug.
+func (s *APIServer) handleGetPublicAnky(w http.ResponseWriter, r *http.Request) error {
+	return s.writePublicAnky(w, r, mux.Vars(r)["id"])
+}
+
+// GET /public/ankys/slug/{slug}
+// The Anky with the slug, e.g. wisdom-light-dancing-0421.
+func (s *APIServer) handleGetPublicAnkyBySlug(w http.ResponseWriter, r *http.Request) error {
+	slug := mux.Vars(r)["slug"]
+	if !utils.ValidSlug(slug) {
+		w.Header().Set("Cache-Control", "public, max-age=30")
+		return NotFound("anky not found")
+	}
+	return s.writePublicAnky(w, r, slug)
+}
+
+func (s *APIServer) writePublicAnky(w http.ResponseWriter, r *http.Request, id string) error {
+	log.Printf("🌐 Fetching public anky for id: %s", id)
+
+	publicAnky, fid, err := s.findPublicAnky(r.Context(), id)
+	var lookupErr *publicAnkyLookupError
+	if errors.As(err, &lookupErr) {
+		return err
+	}
+	if err != nil {
+		log.Printf("❌ Public anky %s not found: %v", id, err)
+		w.Header().Set("Cache-Control", "public, max-age=30")
+		return NotFound("anky not found")
+	}
+
+	if fid != 0 {
+		publicAnky.AuthorFname = lookupFname(fid)
+	}
+	publicAnky.CastURL = castURL(publicAnky.AuthorFname, publicAnky.CastHash)
+
+	etag := publicAnkyETag(publicAnky)
+	w.Header().Set("ETag", etag)
+	// Finished Ankys only change through events that purge them
+	setAnkyCacheHeaders(w, publicAnky.ID, publicAnky.Status == "completed" && !publicAnky.Sealed)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return WriteJSON(w, http.StatusOK, publicAnky)
+}
+
+// findPublicAnky returns the public view of the Anky and the FID of its
+// author, if known. The id can be an Anky ID, a session ID or a slug.
+func (s *APIServer) findPublicAnky(ctx context.Context, id string) (*PublicAnky, int, error) {
+	if _, err := uuid.Parse(id); err != nil && utils.ValidSlug(id) {
+		if sessionID, err := s.store.GetSessionIDBySlug(ctx, id); err == nil {
+			id = sessionID.String()
+		}
+	}
+
+	publicAnky, fid, err := s.findPublicAnkyByID(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	publicAnky.ShareURL = ankyShareURL(publicAnky.ID)
+	if sessionID, err := uuid.Parse(publicAnky.SessionID); err == nil {
+		if slug, err := s.store.GetAnkySlug(ctx, sessionID); err == nil {
+			publicAnky.Slug = slug
+			publicAnky.ShareURL = ankyShareURL(slug)
+		}
+	}
+	return publicAnky, fid, nil
+}
+
+func (s *APIServer) findPublicAnkyByID(ctx context.Context, id string) (*PublicAnky, int, error) {
+	if parsedID, err := uuid.Parse(id); err == nil {
+		anky, err := s.store.GetAnkyByID(ctx, parsedID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			anky, err = s.store.GetAnkyByWritingSessionID(ctx, parsedID)
+		}
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, 0, &publicAnkyLookupError{err: err}
+		}
+		if err == nil && (anky.HeldForReview() || s.isUserDeleting(ctx, anky.UserID)) {
+			return nil, 0, NotFound("anky not found")
+		}
+		if err == nil {
+			if err := s.store.AttachAnkyImages(ctx, anky); err != nil {
+				log.Printf("⚠️ Could not load image collection of anky %s: %v", anky.ID, err)
+			}
+			return newPublicAnky(anky), anky.FID, nil
+		}
+	}
+
+	// Frames sessions are not in the database yet, fall back to their metadata file
+	publicAnky, err := readFramesPublicAnky(id)
+	return publicAnky, 0, err
+}
+
+// publicAnkyLookupError is a failure to look a public Anky up that doesn't
+// mean it doesn't exist, like a database timeout. It is answered as such
+// instead of a cacheable 404.
+type publicAnkyLookupError struct {
+	err error
+}
+
+func (e *publicAnkyLookupError) Error() string {
+	return e.err.Error()
+}
+
+func (e *publicAnkyLookupError) Unwrap() error {
+	return e.err
+}
+
+func newPublicAnky(anky *types.Anky) *PublicAnky {
+	anky = anky.Withheld()
+	return &PublicAnky{
+		ID:            anky.ID.String(),
+		SessionID:     anky.WritingSessionID.String(),
+		Status:        anky.Status,
+		Story:         anky.AnkyReflection,
+		ImageURL:      anky.ImageURL,
+		ImageIPFSHash: anky.ImageIPFSHash,
+		TokenName:     anky.TokenName,
+		Ticker:        anky.Ticker,
+		TokenAddress:  anky.TokenAddress,
+		MetadataURI:   anky.MetadataURI,
+		Degraded:      anky.StorageDegraded,
+		CastHash:      anky.CastHash,
+		License:       anky.License,
+		LicenseURL:    types.LicenseURL(anky.License),
+		CreatedAt:     anky.CreatedAt,
+		Sealed:        anky.Sealed(),
+		RevealAt:      anky.RevealAt,
+		Images:        newPublicAnkyImages(anky.Images),
+	}
+}
+
+func newPublicAnkyImages(images []*types.AnkyImage) []PublicAnkyImage {
+	publicImages := make([]PublicAnkyImage, 0, len(images))
+	for _, image := range images {
+		publicImages = append(publicImages, PublicAnkyImage{
+			Position:      image.Position,
+			ImageURL:      image.ImageURL,
+			ImageIPFSHash: image.ImageIPFSHash,
+		})
+	}
+	return publicImages
+}
+
+func readFramesPublicAnky(sessionID string) (*PublicAnky, error) {
+	if strings.ContainsAny(sessionID, "/\\.") {
+		return nil, Validation("invalid session id")
+	}
+
+	filename := fmt.Sprintf("data/framesgiving/ankys/%s.txt", sessionID)
+	info, err := utils.Files.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := services.ReadFramesAnkyMetadata(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := "completed"
+	if metadata.IPFSHash == "" && !metadata.Degraded() {
+		status = "pending"
+	}
+
+	images, err := services.ReadFramesAnkyImages(sessionID)
+	if err != nil {
+		log.Printf("⚠️ Could not read image collection of session %s: %v", sessionID, err)
+	}
+
+	return &PublicAnky{
+		ID:            sessionID,
+		SessionID:     sessionID,
+		Status:        status,
+		TokenName:     metadata.TokenName,
+		Ticker:        metadata.Ticker,
+		Story:         metadata.Story,
+		ImageURL:      metadata.ImageURL,
+		ImageIPFSHash: metadata.IPFSHash,
+		MetadataURI:   metadata.MetadataURI,
+		Degraded:      metadata.Degraded(),
+		License:       metadata.License,
+		LicenseURL:    types.LicenseURL(metadata.License),
+		CreatedAt:     info.ModTime().UTC(),
+		Images:        newPublicAnkyImages(images),
+	}, nil
+}
+
+// ankyShareURL is the link to the public page of an Anky, by slug when it
+// has one.
+func ankyShareURL(slugOrID string) string {
+	return fmt.Sprintf("%s/%s/%s", publicPageBaseURL, oEmbedPublicPagePath, slugOrID)
+}
+
+// Fnames rarely change, so public pages and embeds look each one up on
+// Neynar at most once an hour, and once a minute while Neynar fails
+const (
+	fnameTTL        = time.Hour
+	fnameFailureTTL = time.Minute
+)
+
+// fnames caches the fnames of the authors of public Ankys by FID.
+var fnames = &fnameCache{entries: make(map[int]cachedFname)}
+
+type fnameCache struct {
+	mu      sync.Mutex
+	entries map[int]cachedFname
+}
+
+type cachedFname struct {
+	fname     string
+	expiresAt time.Time
+}
+
+func (c *fnameCache) get(fid int) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[fid]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false
+	}
+	return entry.fname, true
+}
+
+func (c *fnameCache) set(fid int, fname string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) > 100000 {
+		c.entries = make(map[int]cachedFname)
+	}
+	c.entries[fid] = cachedFname{fname: fname, expiresAt: time.Now().Add(ttl)}
+}
+
+func lookupFname(fid int) string {
+	if fname, ok := fnames.get(fid); ok {
+		return fname
+	}
+
+	result, err := services.NewFarcasterService().GetUserByFid(fid)
+	if err != nil {
+		log.Printf("⚠️ Could not look up fname for fid %d: %v", fid, err)
+		fnames.set(fid, "", fnameFailureTTL)
+		return ""
+	}
+	username := ""
+	if user, ok := result["user"].(map[string]interface{}); ok {
+		username, _ = user["username"].(string)
+	}
+	fnames.set(fid, username, fnameTTL)
+	return username
+}
+
+func castURL(fname string, castHash string) string {
+	if castHash == "" {
+		return ""
+	}
+	if fname == "" {
+		return fmt.Sprintf("https://warpcast.com/~/conversations/%s", castHash)
+	}
+	shortHash := castHash
+	if len(shortHash) > 10 {
+		shortHash = shortHash[:10]
+	}
+	return fmt.Sprintf("https://warpcast.com/%s/%s", fname, shortHash)
+}
+
+func publicAnkyETag(anky *PublicAnky) string {
+	parts := []string{
+		anky.ID, anky.Status, anky.Story, anky.ImageIPFSHash, anky.ImageURL, anky.Ticker, anky.TokenName, anky.TokenAddress, anky.CastHash, anky.AuthorFname, anky.License,
+	}
+	for _, image := range anky.Images {
+		parts = append(parts, image.ImageURL, image.ImageIPFSHash)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
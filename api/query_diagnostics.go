@@ -0,0 +1,86 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/gorilla/mux"
+)
+
+// Longest SQL excerpt printed in a query diagnostics warning
+const queryDiagnosticsSQLLength = 160
+
+// QueryDiagnostics is a development middleware that counts the database
+// queries of each request and warns when a request runs too many, repeats the
+// same query (usually an N+1 loop) or runs a slow one. It only does anything
+// when QUERY_DIAGNOSTICS=1, which should never be set in production:
+//
+//	QUERY_DIAGNOSTICS_MAX_QUERIES   queries per request before warning (20)
+//	QUERY_DIAGNOSTICS_MAX_REPEATS   runs of one query before warning (3)
+//	QUERY_DIAGNOSTICS_SLOW_MS       duration of a slow query (100)
+func QueryDiagnostics() mux.MiddlewareFunc {
+	if os.Getenv("QUERY_DIAGNOSTICS") != "1" {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	maxQueries := envInt("QUERY_DIAGNOSTICS_MAX_QUERIES", 20)
+	maxRepeats := envInt("QUERY_DIAGNOSTICS_MAX_REPEATS", 3)
+	slowQuery := time.Duration(envInt("QUERY_DIAGNOSTICS_SLOW_MS", 100)) * time.Millisecond
+	log.Printf("🔎 Query diagnostics on: warning above %d queries, %d repeats or %v per query", maxQueries, maxRepeats, slowQuery)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, queryLog := storage.WithQueryLog(r.Context())
+			started := time.Now()
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			route := r.URL.Path
+			if template, _ := unversionedPathTemplate(r); template != "" {
+				route = template
+			}
+			route = r.Method + " " + route
+
+			queries := queryLog.Queries()
+			if len(queries) > maxQueries {
+				log.Printf("[QueryDiagnostics] %s ran %d queries in %v (more than %d)", route, len(queries), time.Since(started).Round(time.Millisecond), maxQueries)
+			}
+
+			runs := make(map[string]int)
+			var total time.Duration
+			for _, query := range queries {
+				runs[query.SQL]++
+				total += query.Duration
+				if query.Duration >= slowQuery {
+					log.Printf("[QueryDiagnostics] %s slow query took %v: %s", route, query.Duration.Round(time.Millisecond), sqlExcerpt(query.SQL))
+				}
+			}
+
+			repeated := make([]string, 0)
+			for sql, count := range runs {
+				if count > maxRepeats {
+					repeated = append(repeated, sql)
+				}
+			}
+			sort.Slice(repeated, func(i, j int) bool { return runs[repeated[i]] > runs[repeated[j]] })
+			for _, sql := range repeated {
+				log.Printf("[QueryDiagnostics] %s ran the same query %d times, likely an N+1: %s", route, runs[sql], sqlExcerpt(sql))
+			}
+
+			if len(queries) > 0 {
+				log.Printf("[QueryDiagnostics] %s: %d queries, %v in the database", route, len(queries), total.Round(time.Millisecond))
+			}
+		})
+	}
+}
+
+func sqlExcerpt(sql string) string {
+	if len(sql) <= queryDiagnosticsSQLLength {
+		return sql
+	}
+	return sql[:queryDiagnosticsSQLLength] + "…"
+}
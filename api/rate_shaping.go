@@ -0,0 +1,330 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/utils"
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+// RouteCost describes how many rate limit tokens a request to a route
+// consumes: a fixed base cost plus a cost per KB of request body.
+type RouteCost struct {
+	Base  int
+	PerKB int
+}
+
+// defaultRouteCost applies to every route without an explicit entry.
+var defaultRouteCost = RouteCost{Base: 1}
+
+// routeCosts is keyed by the mux path template, without the version prefix. Reads are cheap, session
+// submissions pay for their size and for the LLM/image work they trigger.
+var routeCosts = map[string]RouteCost{
+	"/writing-session-started":                                   {Base: 2},
+	"/writing-sessions/{id}/end":                                 {Base: 2, PerKB: 1},
+	"/writing-sessions/sync":                                     {Base: 5, PerKB: 1},
+	"/anky/raw-writing-session":                                  {Base: 10, PerKB: 1},
+	"/anky/process-writing-conversation":                         {Base: 10, PerKB: 1},
+	"/anky/simple-prompt":                                        {Base: 5, PerKB: 1},
+	"/anky/messages-prompt":                                      {Base: 5, PerKB: 1},
+	"/anky/onboarding/{userId}":                                  {Base: 10, PerKB: 1},
+	"/framesgiving/submit-writing-session":                       {Base: 10, PerKB: 1},
+	"/framesgiving/generate-anky-image-from-session-long-string": {Base: 30, PerKB: 1},
+	"/framesgiving/status/batch":                                 {Base: 3},
+	"/leaderboard":                                               {Base: 3},
+	"/status":                                                    {Base: 2},
+	"/ankys/{id}/market":                                         {Base: 2},
+	"/ankys/{id}/image":                                          {Base: 2},
+	"/ankys/{id}/regenerate-reflection":                          {Base: 10},
+	"/ankys/{id}/regenerate-image":                               {Base: 30},
+	"/ankys/{id}/images/{version}":                               {Base: 10},
+	"/users/{userId}/writing-sessions/search":                    {Base: 3},
+	"/sessions/{id}/handoff":                                     {Base: 5},
+	"/sessions/handoff/redeem":                                   {Base: 20},
+	"/users/{userId}/export":                                     {Base: 10},
+	"/exports/{token}":                                           {Base: 10},
+	"/farcaster/get-new-fid":                                     {Base: 20},
+	"/farcaster/register-new-fid":                                {Base: 20},
+}
+
+// Route groups with their own token buckets. Routes that call the LLM or
+// generate images draw from a stricter bucket than the rest of the API.
+const (
+	defaultRateLimitGroup = "default"
+	llmRateLimitGroup     = "llm"
+)
+
+// routeGroups is keyed like routeCosts, routes without an entry are in the
+// default group.
+var routeGroups = map[string]string{
+	"/anky/raw-writing-session":                                  llmRateLimitGroup,
+	"/anky/process-writing-conversation":                         llmRateLimitGroup,
+	"/anky/simple-prompt":                                        llmRateLimitGroup,
+	"/anky/messages-prompt":                                      llmRateLimitGroup,
+	"/anky/onboarding/{userId}":                                  llmRateLimitGroup,
+	"/framesgiving/submit-writing-session":                       llmRateLimitGroup,
+	"/framesgiving/generate-anky-image-from-session-long-string": llmRateLimitGroup,
+	"/ankys/{id}/regenerate-reflection":                          llmRateLimitGroup,
+	"/ankys/{id}/regenerate-image":                               llmRateLimitGroup,
+}
+
+// rateLimitGroup is how fast the clients of a route group refill their
+// bucket and how many tokens it holds.
+type rateLimitGroup struct {
+	perSecond rate.Limit
+	burst     int
+}
+
+// maxBufferedBody caps how much of a chunked body is read to estimate its cost
+const maxBufferedBody = 10 << 20
+
+// costLimiter keeps one token bucket per client and route group. Clients are
+// the authenticated user or, for anonymous requests, the IP address.
+type costLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*clientBucket
+	groups  map[string]rateLimitGroup
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newCostLimiter() *costLimiter {
+	l := &costLimiter{
+		buckets: make(map[string]*clientBucket),
+		groups: map[string]rateLimitGroup{
+			defaultRateLimitGroup: {
+				perSecond: rate.Limit(envInt("RATE_LIMIT_TOKENS_PER_SECOND", 5)),
+				burst:     envInt("RATE_LIMIT_BURST", 120),
+			},
+			llmRateLimitGroup: {
+				perSecond: rate.Limit(envInt("RATE_LIMIT_LLM_TOKENS_PER_SECOND", 2)),
+				burst:     envInt("RATE_LIMIT_LLM_BURST", 60),
+			},
+		},
+	}
+	go l.cleanup()
+	return l
+}
+
+// group returns the route group of the request and its limits.
+func (l *costLimiter) group(r *http.Request) (string, rateLimitGroup) {
+	name := defaultRateLimitGroup
+	if template, _ := unversionedPathTemplate(r); template != "" {
+		if group, ok := routeGroups[template]; ok {
+			name = group
+		}
+	}
+	return name, l.groups[name]
+}
+
+func (l *costLimiter) bucket(key string, group rateLimitGroup) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &clientBucket{limiter: rate.NewLimiter(group.perSecond, group.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = time.Now()
+	return b.limiter
+}
+
+// cleanup forgets clients that have been idle long enough for their bucket to refill
+func (l *costLimiter) cleanup() {
+	for {
+		time.Sleep(5 * time.Minute)
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if time.Since(b.lastSeen) > 10*time.Minute {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// CostRateLimiter is a middleware function that charges each request a number
+// of tokens proportional to its route's processing cost and body size, from
+// the bucket of its client for its route group
+func CostRateLimiter() mux.MiddlewareFunc {
+	limiter := newCostLimiter()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cost, err := requestCost(r)
+			if err != nil {
+				WriteJSON(w, http.StatusRequestEntityTooLarge, ApiError{Error: err.Error(), Code: CodeTooLarge})
+				return
+			}
+
+			groupName, group := limiter.group(r)
+			client := rateLimitClient(r)
+			bucket := limiter.bucket(groupName+" "+client, group)
+			if cost > group.burst {
+				cost = group.burst
+			}
+			reservation := bucket.ReserveN(time.Now(), cost)
+			if delay := reservation.Delay(); delay > 0 {
+				reservation.Cancel()
+				log.Printf("[RateLimit] %s %s from %s rejected (%s group, cost %d, retry in %v)", r.Method, r.URL.Path, client, groupName, cost, delay)
+				w.Header().Set("Retry-After", strconv.Itoa(int(delay.Seconds())+1))
+				WriteJSON(w, http.StatusTooManyRequests, ApiError{Error: "Too many requests", Code: CodeRateLimited})
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Cost", strconv.Itoa(cost))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestCost returns the number of tokens the request consumes. Bodies
+// without a declared length are buffered so they can be measured.
+func requestCost(r *http.Request) (int, error) {
+	routeCost := defaultRouteCost
+	if template, _ := unversionedPathTemplate(r); template != "" {
+		if c, ok := routeCosts[template]; ok {
+			routeCost = c
+		}
+	}
+
+	if routeCost.PerKB == 0 || r.Body == nil {
+		return routeCost.Base, nil
+	}
+
+	size := r.ContentLength
+	if size < 0 {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBufferedBody+1))
+		if err != nil {
+			return 0, fmt.Errorf("error reading request body: %v", err)
+		}
+		if len(body) > maxBufferedBody {
+			return 0, fmt.Errorf("request body too large")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		size = int64(len(body))
+	}
+
+	return routeCost.Base + int(size/1024)*routeCost.PerKB, nil
+}
+
+// rateLimitClient identifies who a request is charged to: the user of a
+// valid bearer token, or the IP address for anonymous requests. Invalid
+// tokens are charged to the IP, JWTAuth rejects them later.
+func rateLimitClient(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims, err := utils.ValidateJWT(token); err == nil {
+			if userID, err := utils.UserIDFromClaims(claims); err == nil {
+				return "user:" + userID.String()
+			}
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP is the address of the client that sent the request. Behind the
+// reverse proxies listed in TRUSTED_PROXIES it is the one they forwarded in
+// X-Forwarded-For or X-Real-IP; the headers of anyone else are ignored, since
+// clients can send them too.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	// Each proxy appends the address it got the request from, so the client
+	// is the last hop that isn't one of ours
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			break
+		}
+		if i == 0 || !isTrustedProxy(hops[i]) {
+			return hops[i]
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		if _, err := netip.ParseAddr(realIP); err == nil {
+			return realIP
+		}
+	}
+	return host
+}
+
+var trustedProxies struct {
+	once     sync.Once
+	prefixes []netip.Prefix
+}
+
+// isTrustedProxy reports whether ip is in TRUSTED_PROXIES, a comma separated
+// list of addresses and CIDR ranges of the reverse proxies in front of the
+// server.
+func isTrustedProxy(ip string) bool {
+	trustedProxies.once.Do(func() {
+		for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(entry)
+				if addrErr != nil {
+					log.Printf("⚠️ Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+					continue
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			trustedProxies.prefixes = append(trustedProxies.prefixes, prefix.Masked())
+		}
+	})
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
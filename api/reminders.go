@@ -0,0 +1,96 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ankylat/anky/server/services"
+)
+
+const maxWritingReminders = 50
+
+// GET /users/{userId}/reminders/schedule
+// When the writer gets their daily reminder: a little before the hour they
+// usually start writing, learned from their recent sessions, and when the
+// next one is due.
+func (s *APIServer) handleGetReminderSchedule(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := services.NewWritingReminderService(s.store).GetSchedule(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, schedule)
+}
+
+// PUT /users/{userId}/reminders/opt-out
+// Turns the writer's daily reminders off.
+func (s *APIServer) handleReminderOptOut(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.OptOutOfReminders(r.Context(), userID); err != nil {
+		return err
+	}
+	log.Printf("⏰ User %s turned writing reminders off", userID)
+
+	return WriteJSON(w, http.StatusOK, map[string]bool{"enabled": false})
+}
+
+// DELETE /users/{userId}/reminders/opt-out
+// Turns the writer's daily reminders back on.
+func (s *APIServer) handleReminderOptIn(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.OptInToReminders(r.Context(), userID); err != nil {
+		return err
+	}
+	log.Printf("⏰ User %s turned writing reminders on", userID)
+
+	return WriteJSON(w, http.StatusOK, map[string]bool{"enabled": true})
+}
+
+// GET /users/{userId}/reminders?unread=true&limit=20
+// The reminders the writer received, most recent first.
+func (s *APIServer) handleGetWritingReminders(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	limit := 20
+	if parsedLimit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsedLimit > 0 {
+		limit = min(parsedLimit, maxWritingReminders)
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	reminders, err := s.store.GetWritingReminders(r.Context(), userID, unreadOnly, limit)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, reminders)
+}
+
+// POST /users/{userId}/reminders/read
+// Marks every reminder of the writer read.
+func (s *APIServer) handleMarkWritingRemindersRead(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	marked, err := s.store.MarkWritingRemindersRead(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, map[string]int64{"marked_read": marked})
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// responseFormatFor validates the format a request asks Anky's generated text
+// to be returned in. When none was asked for, the writer's accessibility
+// setting applies, and markdown for anyone else.
+func (s *APIServer) responseFormatFor(ctx context.Context, userID string, requested string) (string, error) {
+	if requested != "" {
+		if !types.IsValidResponseFormat(requested) {
+			return "", Validation("invalid response format %q, expected one of %s", requested, strings.Join(types.ResponseFormats, ", "))
+		}
+		return requested, nil
+	}
+
+	parsedUserID, err := uuid.Parse(userID)
+	if err != nil {
+		return types.DefaultResponseFormat, nil
+	}
+	user, err := s.store.GetUserByID(ctx, parsedUserID)
+	if err != nil {
+		log.Printf("⚠️ Could not look up response format of user %s: %v", userID, err)
+		return types.DefaultResponseFormat, nil
+	}
+	return user.PreferredResponseFormat(), nil
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/gorilla/mux"
+)
+
+type seasonsResponse struct {
+	Seasons []*types.Season `json:"seasons"`
+	// Used while no season has started
+	DefaultPipeline types.PipelineSpec `json:"default_pipeline"`
+}
+
+// GET /admin/seasons
+// Every season with its pipeline, the latest first.
+func (s *APIServer) handleGetSeasons(w http.ResponseWriter, r *http.Request) error {
+	seasons, err := s.store.GetSeasons(r.Context())
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, seasonsResponse{Seasons: seasons, DefaultPipeline: services.DefaultPipelineSpec()})
+}
+
+// PUT /admin/seasons/{number}
+// Creates or replaces a season. Ankys started after starts_at go through its
+// pipeline, the default one when the request has none.
+func (s *APIServer) handleUpsertSeason(w http.ResponseWriter, r *http.Request) error {
+	number, err := strconv.Atoi(mux.Vars(r)["number"])
+	if err != nil || number <= 0 {
+		return Validation("invalid season number %q", mux.Vars(r)["number"])
+	}
+
+	var req struct {
+		Name     string              `json:"name"`
+		StartsAt time.Time           `json:"starts_at"`
+		Pipeline *types.PipelineSpec `json:"pipeline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if req.Name == "" {
+		return Validation("name is required")
+	}
+	if req.StartsAt.IsZero() {
+		return Validation("starts_at is required")
+	}
+	pipeline := services.DefaultPipelineSpec()
+	if req.Pipeline != nil {
+		pipeline = *req.Pipeline
+	}
+	if err := services.ValidatePipelineSpec(pipeline); err != nil {
+		return Validation("%v", err)
+	}
+
+	season := &types.Season{Number: number, Name: req.Name, Pipeline: pipeline, StartsAt: req.StartsAt}
+	if err := s.store.UpsertSeason(r.Context(), season); err != nil {
+		return err
+	}
+	log.Printf("🧬 Season %d (%s) saved, starting %s", season.Number, season.Name, season.StartsAt.Format(time.RFC3339))
+
+	return WriteJSON(w, http.StatusOK, season)
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"log"
@@ -11,14 +13,19 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ankylat/anky/server/logging"
 	"github.com/ankylat/anky/server/services"
 	"github.com/ankylat/anky/server/storage"
 	"github.com/ankylat/anky/server/types"
 	"github.com/ankylat/anky/server/utils"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
 )
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
@@ -31,93 +38,287 @@ type apiFunc func(w http.ResponseWriter, r *http.Request) error
 
 type ApiError struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// ConflictResponse answers updates that lost a race with another update of
+// the same row. Nothing was written; the client should read the resource
+// again, reapply its change and send it with the current version.
+type ConflictResponse struct {
+	Error          string `json:"error"`
+	Code           string `json:"code"`
+	CurrentVersion int    `json:"current_version"`
+	Retry          string `json:"retry"`
 }
 
 func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			writeError(w, r, err)
 		}
 	}
 }
 
 type APIServer struct {
-	listenAddr string
-	store      *storage.PostgresStore
+	listenAddr      string
+	store           *storage.PostgresStore
+	conversations   *services.ConversationCache
+	writingSessions *WritingSessionHub
+	status          *services.StatusService
+	archive         *services.SessionArchiveService
+	exports         *services.UserExportService
+	httpServer      *http.Server
+
+	// Serializes creating the accounts of frames FIDs
+	framesUsers sync.Mutex
+
+	// Anky processing outlives the request that triggered it, it runs on
+	// this context instead so Shutdown can cancel it
+	background     context.Context
+	stopBackground context.CancelFunc
+	backgroundJobs sync.WaitGroup
+	// How many jobs are running, shown on the status page
+	backgroundJobsRunning atomic.Int64
 }
 
-// Add WebSocket message types
-
 func NewAPIServer(listenAddr string, store *storage.PostgresStore) (*APIServer, error) {
-	return &APIServer{
-		listenAddr: listenAddr,
-		store:      store,
-	}, nil
+	background, stopBackground := context.WithCancel(context.Background())
+	server := &APIServer{
+		listenAddr:     listenAddr,
+		store:          store,
+		conversations:  services.NewConversationCache(time.Duration(envInt("COMPANION_CONVERSATION_TTL_MINUTES", 60)) * time.Minute),
+		status:         services.NewStatusService(store),
+		archive:        services.NewSessionArchiveService(store),
+		httpServer:     &http.Server{Addr: listenAddr},
+		background:     background,
+		stopBackground: stopBackground,
+	}
+	server.exports = services.NewUserExportService(store, server.archive)
+	server.writingSessions = newWritingSessionHub(server)
+	return server, nil
 }
 
 func (s *APIServer) Run() error {
 	log.Printf("Loaded Privy App ID: %s", os.Getenv("PRIVY_APP_ID"))
-	log.Printf("Loaded Privy Public Key length: %d", len(os.Getenv("PRIVY_PUBLIC_KEY")))
+	log.Printf("Loaded Privy Public Key: %s", logging.Secret(os.Getenv("PRIVY_PUBLIC_KEY")))
+	// Frames prompts moved from a flat file to the database
+	s.importLegacyPromptsFile(context.Background())
+	revokedUsers = newUserRevocations(s.store)
+
 	router := mux.NewRouter()
 
 	router.Use(corsMiddleware)
+	router.Use(RequestMetrics())
+	router.Use(APIVersioning)
+	router.Use(CostRateLimiter())
+	router.Use(QueryDiagnostics())
+
+	// Every route is served under /v1 and, until the sunset, without a prefix
+	s.registerRoutes(router.PathPrefix(apiVersionPrefix).Subrouter())
+	s.registerRoutes(router)
+
+	// Metrics (storage query counters and cancellation rate)
+	router.Handle("/debug/vars", JWTAuth(utils.ScopeAdmin)(expvar.Handler())).Methods("GET")
+	// Prometheus metrics of the routes and the minting pipeline
+	router.Handle("/metrics", metricsHandler()).Methods("GET")
+
+	s.httpServer.Handler = router
+
+	log.Println("Server running on port:", s.listenAddr)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
 
+func (s *APIServer) registerRoutes(router *mux.Router) {
 	router.HandleFunc("/", makeHTTPHandleFunc(s.handleHelloWorld))
+
+	// Routes holding one user's data only answer that user, or an admin
+	userOnly := func(handler apiFunc, scopes ...string) http.Handler {
+		return JWTAuth(scopes...)(RequireUser(makeHTTPHandleFunc(handler)))
+	}
+
 	// User routes
 	router.HandleFunc("/users/register-anon-user", makeHTTPHandleFunc(s.handleRegisterAnonymousUser)).Methods("POST")
-	router.HandleFunc("/users", makeHTTPHandleFunc(s.handleGetUsers)).Methods("GET")
-	router.HandleFunc("/users/{userId}", makeHTTPHandleFunc(s.handleGetUserByID)).Methods("GET")
-	router.HandleFunc("/users/{userId}", makeHTTPHandleFunc(s.handleUpdateUser)).Methods("PUT")
-	router.HandleFunc("/users/{userId}", makeHTTPHandleFunc(s.handleDeleteUser)).Methods("DELETE")
-	router.HandleFunc("/users/create-profile/{userId}", makeHTTPHandleFunc(s.handleCreateUserProfile)).Methods("POST")
+	router.Handle("/users", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetUsers))).Methods("GET")
+	router.Handle("/users/{userId}", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetUserByID))).Methods("GET")
+	router.Handle("/users/{userId}", userOnly(s.handleUpdateUser, utils.DefaultUserScopes...)).Methods("PUT")
+	router.Handle("/users/{userId}", userOnly(s.handleDeleteUser, utils.DefaultUserScopes...)).Methods("DELETE")
+	router.Handle("/users/{userId}/deletion", JWTAuthDuringDeletion(utils.ScopeReadProfile)(RequireUser(makeHTTPHandleFunc(s.handleGetUserDeletion)))).Methods("GET")
+	router.Handle("/users/{userId}/deletion", JWTAuthDuringDeletion(utils.DefaultUserScopes...)(RequireUser(makeHTTPHandleFunc(s.handleCancelUserDeletion)))).Methods("DELETE")
+	router.Handle("/users/{userId}/merge-into/{targetUserId}", userOnly(s.handleMergeAnonymousUser, utils.DefaultUserScopes...)).Methods("POST")
+	router.Handle("/users/{userId}/farcaster", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleUnlinkFarcaster))).Methods("DELETE")
+	router.Handle("/users/create-profile/{userId}", userOnly(s.handleCreateUserProfile, utils.DefaultUserScopes...)).Methods("POST")
 	router.Handle("/user/register-privy-user", PrivyAuth(os.Getenv("PRIVY_APP_ID"), os.Getenv("PRIVY_PUBLIC_KEY"))(makeHTTPHandleFunc(s.handleRegisterPrivyUser))).Methods("POST")
 
+	// Auth routes
+	router.Handle("/auth/scoped-tokens", JWTAuth()(makeHTTPHandleFunc(s.handleCreateScopedToken))).Methods("POST")
+
+	// Admin routes
+	router.Handle("/admin/fid-requests", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetFIDRequests))).Methods("GET")
+	router.Handle("/admin/fid-requests/{id}/review", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleReviewFIDRequest))).Methods("POST")
+	router.Handle("/admin/suspect-sessions", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetSuspectSessions))).Methods("GET")
+	router.Handle("/admin/prompts/sandbox", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handlePromptSandbox))).Methods("POST")
+	router.Handle("/admin/prompts", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetPrompts))).Methods("GET")
+	router.Handle("/admin/prompts/{fid:[0-9]+}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetPrompt))).Methods("GET")
+	router.Handle("/admin/prompts/{fid:[0-9]+}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleUpsertPrompt))).Methods("PUT")
+	router.Handle("/admin/prompts/{fid:[0-9]+}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleDeletePrompt))).Methods("DELETE")
+	router.Handle("/admin/missing-casts", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetMissingCasts))).Methods("GET")
+	router.Handle("/admin/backup-verifications", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetBackupVerifications))).Methods("GET")
+	router.Handle("/admin/backup-verifications", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleVerifyBackup))).Methods("POST")
+	router.Handle("/admin/kill-switches", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleKillSwitches))).Methods("GET", "PUT")
+	router.Handle("/admin/llm-usage", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetLLMUsage))).Methods("GET")
+	router.Handle("/admin/incidents", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetIncidents))).Methods("GET")
+	router.Handle("/admin/incidents", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleCreateIncident))).Methods("POST")
+	router.Handle("/admin/incidents/{id}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleUpdateIncident))).Methods("PATCH")
+	router.Handle("/admin/session-archive", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetSessionArchive))).Methods("GET")
+	router.Handle("/admin/session-archive", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleArchiveSessions))).Methods("POST")
+	router.Handle("/admin/anonymous-sessions", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handlePruneAnonymousSessions))).Methods("GET")
+	router.Handle("/admin/anonymous-sessions/prune", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handlePruneAnonymousSessions))).Methods("POST")
+	router.Handle("/admin/seasons", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetSeasons))).Methods("GET")
+	router.Handle("/admin/seasons/{number:[0-9]+}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleUpsertSeason))).Methods("PUT")
+	router.Handle("/admin/announcements", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetAdminAnnouncements))).Methods("GET")
+	router.Handle("/admin/announcements", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleCreateAnnouncement))).Methods("POST")
+	router.Handle("/admin/announcements/{id}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleUpdateAnnouncement))).Methods("PATCH")
+	router.Handle("/admin/announcements/{id}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleDeleteAnnouncement))).Methods("DELETE")
+	router.Handle("/admin/campaigns", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetCampaigns))).Methods("GET")
+	router.Handle("/admin/campaigns", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleCreateCampaign))).Methods("POST")
+	router.Handle("/admin/campaigns/{id}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleUpdateCampaign))).Methods("PATCH")
+	router.Handle("/admin/campaigns/{id}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleDeleteCampaign))).Methods("DELETE")
+	router.Handle("/admin/reviews", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetAnkyReviews))).Methods("GET")
+	router.Handle("/admin/reviews/{id}/approve", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleApproveAnkyReview))).Methods("POST")
+	router.Handle("/admin/reviews/{id}/reject", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleRejectAnkyReview))).Methods("POST")
+	router.Handle("/ipfs/pins", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleGetIPFSPins))).Methods("GET")
+	router.Handle("/ipfs/pins/{hash}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handleDeleteIPFSPin))).Methods("DELETE")
+
 	// Privy user routes
 	router.HandleFunc("/privy-users/${id}", makeHTTPHandleFunc(s.handleCreatePrivyUser)).Methods("POST")
 
 	// Writing session routes
 	router.HandleFunc("/writing-session-started", makeHTTPHandleFunc(s.handleWritingSessionStarted)).Methods("POST")
-	router.HandleFunc("/writing-sessions/{id}", makeHTTPHandleFunc(s.handleGetWritingSession)).Methods("GET")
-	router.HandleFunc("/users/{userId}/writing-sessions", makeHTTPHandleFunc(s.handleGetUserWritingSessions)).Methods("GET")
+	router.Handle("/writing-sessions/sync", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleSyncWritingSessions))).Methods("POST")
+	router.Handle("/writing-sessions/{id}", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetWritingSession))).Methods("GET")
+	router.Handle("/writing-sessions/{id}/clock", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetWritingSessionClock))).Methods("GET")
+	router.Handle("/writing-sessions/{id}/replay", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetWritingSessionReplay))).Methods("GET")
+	router.Handle("/writing-sessions/{id}/end", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleWritingSessionEnd))).Methods("POST")
+	router.Handle("/sessions/{id}/handoff", OptionalJWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleCreateSessionHandoff))).Methods("POST", "OPTIONS")
+	router.HandleFunc("/sessions/handoff/redeem", makeHTTPHandleFunc(s.handleRedeemSessionHandoff)).Methods("POST", "OPTIONS")
+	router.Handle(clientSurfacePathSegment+"/sessions/{id}/handoff", OptionalJWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleCreateSessionHandoff))).Methods("POST", "OPTIONS")
+	router.HandleFunc(clientSurfacePathSegment+"/sessions/handoff/redeem", makeHTTPHandleFunc(s.handleRedeemSessionHandoff)).Methods("POST", "OPTIONS")
+	router.Handle("/ws/writing-session/{sessionId}", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleWritingSessionSocket))).Methods("GET")
+	router.Handle("/users/{userId}/writing-sessions", userOnly(s.handleGetUserWritingSessions, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/writing-sessions/search", userOnly(s.handleSearchUserWritingSessions, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/export", userOnly(s.handleExportUserData, utils.ScopeReadProfile)).Methods("GET")
+	router.HandleFunc("/exports/{token}", makeHTTPHandleFunc(s.handleDownloadUserExport)).Methods("GET")
+	router.Handle("/users/{userId}/analytics/focus", userOnly(s.handleGetUserFocusAnalytics, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/streak", userOnly(s.handleGetUserStreak, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/buddy", userOnly(s.handleGetBuddy, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/buddy", userOnly(s.handleEndBuddy, utils.DefaultUserScopes...)).Methods("DELETE")
+	router.Handle("/users/{userId}/buddy/opt-in", userOnly(s.handleBuddyOptIn, utils.DefaultUserScopes...)).Methods("PUT")
+	router.Handle("/users/{userId}/buddy/opt-in", userOnly(s.handleBuddyOptOut, utils.DefaultUserScopes...)).Methods("DELETE")
+	router.Handle("/users/{userId}/buddy/nudge", userOnly(s.handleNudgeBuddy, utils.DefaultUserScopes...)).Methods("POST")
+	router.Handle("/users/{userId}/buddy/nudges", userOnly(s.handleGetBuddyNudges, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/buddy/nudges/read", userOnly(s.handleMarkBuddyNudgesRead, utils.DefaultUserScopes...)).Methods("POST")
+
+	// Daily writing reminder routes
+	router.Handle("/users/{userId}/reminders", userOnly(s.handleGetWritingReminders, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/reminders/read", userOnly(s.handleMarkWritingRemindersRead, utils.DefaultUserScopes...)).Methods("POST")
+	router.Handle("/users/{userId}/reminders/schedule", userOnly(s.handleGetReminderSchedule, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/reminders/opt-out", userOnly(s.handleReminderOptOut, utils.DefaultUserScopes...)).Methods("PUT")
+	router.Handle("/users/{userId}/reminders/opt-out", userOnly(s.handleReminderOptIn, utils.DefaultUserScopes...)).Methods("DELETE")
+
+	// Public research dataset of anonymized writing statistics
+	router.Handle("/users/{userId}/dataset-opt-in", userOnly(s.handleGetDatasetOptIn, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/dataset-opt-in", userOnly(s.handleDatasetOptIn, utils.DefaultUserScopes...)).Methods("PUT")
+	router.Handle("/users/{userId}/dataset-opt-in", userOnly(s.handleDatasetOptOut, utils.DefaultUserScopes...)).Methods("DELETE")
+	router.HandleFunc("/datasets/latest", makeHTTPHandleFunc(s.handleGetLatestDataset)).Methods("GET")
+
+	// What's-new feed
+	router.Handle("/announcements", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetAnnouncements))).Methods("GET")
+	router.Handle("/announcements/read", JWTAuth(utils.DefaultUserScopes...)(makeHTTPHandleFunc(s.handleMarkAnnouncementsRead))).Methods("POST")
+
+	// Campaigns running now, for the clients' banners
+	router.HandleFunc("/campaigns/active", makeHTTPHandleFunc(s.handleGetActiveCampaigns)).Methods("GET")
 
 	// Anky routes
-	router.HandleFunc("/ankys", makeHTTPHandleFunc(s.handleGetAnkys)).Methods("GET")
-	router.HandleFunc("/ankys/{id}", makeHTTPHandleFunc(s.handleGetAnkyByID)).Methods("GET")
-	router.HandleFunc("/users/{userId}/ankys", makeHTTPHandleFunc(s.handleGetAnkysByUserID)).Methods("GET")
+	// Ankys anyone may see are served by /public/ankys/{id}
+	router.Handle("/ankys", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetAnkys))).Methods("GET")
+	router.Handle("/ankys", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleCreateAnky))).Methods("POST")
+	router.Handle("/ankys/{id}", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetAnkyByID))).Methods("GET")
+	router.Handle("/ankys/{id}", JWTAuth(utils.ScopeAdmin)(makeHTTPHandleFunc(s.handlePatchAnky))).Methods("PATCH")
+	router.HandleFunc("/ankys/{id}/status", makeHTTPHandleFunc(s.handleGetAnkyStatus)).Methods("GET")
+	router.HandleFunc(clientSurfacePathSegment+"/ankys/{id}/status", makeHTTPHandleFunc(s.handleGetAnkyStatus)).Methods("GET")
+	router.HandleFunc("/ankys/{id}/market", makeHTTPHandleFunc(s.handleGetAnkyMarket)).Methods("GET")
+	router.HandleFunc("/ankys/{id}/onchain", makeHTTPHandleFunc(s.handleGetAnkyOnchain)).Methods("GET")
+	router.HandleFunc("/ankys/{id}/image", makeHTTPHandleFunc(s.handleGetAnkyImage)).Methods("GET")
+	router.HandleFunc("/ankys/{id}/license", makeHTTPHandleFunc(s.handleGetAnkyLicense)).Methods("GET")
+	router.Handle("/ankys/{id}/license", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleUpdateAnkyLicense))).Methods("PUT")
+	router.Handle("/ankys/{id}/reflections", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetAnkyReflections))).Methods("GET")
+	router.Handle("/ankys/{id}/reflections/canonical", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleSetCanonicalAnkyReflection))).Methods("PUT")
+	router.Handle("/ankys/{id}/regenerate-reflection", JWTAuth(utils.ScopeWriteSessions)(s.idempotent(headerKey)(makeHTTPHandleFunc(s.handleRegenerateAnkyReflection)))).Methods("POST")
+	router.Handle("/ankys/{id}/images", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetAnkyImageVersions))).Methods("GET")
+	router.Handle("/ankys/{id}/images/{version}", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleKeepAnkyImageVersion))).Methods("PUT")
+	router.Handle("/ankys/{id}/image-candidates", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetAnkyImageCandidates))).Methods("GET")
+	router.Handle("/ankys/{id}/choose-image", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleChooseAnkyImage))).Methods("POST")
+	router.Handle("/ankys/{id}/regenerate-image", JWTAuth(utils.ScopeWriteSessions)(s.idempotent(headerKey)(makeHTTPHandleFunc(s.handleRegenerateAnkyImage)))).Methods("POST")
+	router.Handle("/users/{userId}/ankys", userOnly(s.handleGetAnkysByUserID, utils.ScopeReadProfile)).Methods("GET")
 	router.HandleFunc("/anky/onboarding/{userId}", makeHTTPHandleFunc(s.handleProcessUserOnboarding)).Methods("POST")
-	router.HandleFunc("/anky/edit-cast", makeHTTPHandleFunc(s.handleEditCast)).Methods("POST")
-	router.HandleFunc("/anky/simple-prompt", makeHTTPHandleFunc(s.handleSimplePrompt)).Methods("POST")
-	router.HandleFunc("/anky/messages-prompt", makeHTTPHandleFunc(s.handleMessagesPrompt)).Methods("POST")
-	router.HandleFunc("/anky/raw-writing-session", makeHTTPHandleFunc(s.handleRawWritingSession)).Methods("POST")
+	router.Handle("/anky/edit-cast", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleEditCast))).Methods("POST")
+	router.Handle("/anky/simple-prompt", JWTAuth(utils.ScopeWriteSessions)(LLMQuota(s.store)(makeHTTPHandleFunc(s.handleSimplePrompt)))).Methods("POST")
+	router.Handle("/anky/messages-prompt", JWTAuth(utils.ScopeWriteSessions)(LLMQuota(s.store)(makeHTTPHandleFunc(s.handleMessagesPrompt)))).Methods("POST")
+	router.Handle("/anky/raw-writing-session", s.idempotent(rawSessionKey)(makeHTTPHandleFunc(s.handleRawWritingSession))).Methods("POST")
 
-	router.HandleFunc("/anky/process-writing-conversation", makeHTTPHandleFunc(s.handleProcessWritingConversation)).Methods("POST")
+	router.Handle("/anky/process-writing-conversation", JWTAuth(utils.ScopeWriteSessions)(LLMQuota(s.store)(makeHTTPHandleFunc(s.handleProcessWritingConversation)))).Methods("POST")
 	router.HandleFunc("/anky/finished-anky-registration", makeHTTPHandleFunc(s.handleFinishedAnkyRegistration)).Methods("POST")
 
+	// Casts addressed to the Anky account, authenticated by Neynar's signature
+	router.HandleFunc("/farcaster/webhook", makeHTTPHandleFunc(s.handleNeynarWebhook)).Methods("POST")
 	router.Handle("/farcaster/get-new-fid", PrivyAuth(os.Getenv("PRIVY_APP_ID"), os.Getenv("PRIVY_PUBLIC_KEY"))(makeHTTPHandleFunc(s.handleGetNewFID))).Methods("POST")
 	router.Handle("/farcaster/register-new-fid", PrivyAuth(os.Getenv("PRIVY_APP_ID"), os.Getenv("PRIVY_PUBLIC_KEY"))(makeHTTPHandleFunc(s.handleRegisterNewFID))).Methods("POST")
+	// Public routes
+	router.HandleFunc("/public/ankys/slug/{slug}", makeHTTPHandleFunc(s.handleGetPublicAnkyBySlug)).Methods("GET")
+	router.HandleFunc("/public/ankys/{id}", makeHTTPHandleFunc(s.handleGetPublicAnky)).Methods("GET")
+	router.HandleFunc("/oembed", makeHTTPHandleFunc(s.handleOEmbed)).Methods("GET")
+	router.HandleFunc("/leaderboard", makeHTTPHandleFunc(s.handleGetLeaderboard)).Methods("GET")
+	router.HandleFunc("/status", makeHTTPHandleFunc(s.handleGetStatus)).Methods("GET")
+	router.HandleFunc("/health", makeHTTPHandleFunc(s.handleHealth)).Methods("GET")
+	router.HandleFunc("/health/ready", makeHTTPHandleFunc(s.handleReadiness)).Methods("GET")
+	// Callbacks of the image backend, authenticated by the token in their URL
+	router.HandleFunc("/webhooks/images/{provider}", makeHTTPHandleFunc(s.handleImageWebhook)).Methods("POST")
+
+	// Webhooks called when Ankys reach a pipeline status
+	router.Handle("/webhooks", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleRegisterWebhook))).Methods("POST")
+	router.Handle("/webhooks", JWTAuth(utils.ScopeReadProfile)(makeHTTPHandleFunc(s.handleGetWebhooks))).Methods("GET")
+	router.Handle("/webhooks/{id}", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleDeleteWebhook))).Methods("DELETE")
+
 	// newen routes
-	router.HandleFunc("/newen/transactions/{userId}", makeHTTPHandleFunc(s.handleGetUserTransactions)).Methods("GET")
+	router.Handle("/newen/transactions/{userId}", userOnly(s.handleGetUserTransactions, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/newen/spend", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleSpendNewen))).Methods("POST")
 
 	// Badge routes
-	router.HandleFunc("/users/{userId}/badges", makeHTTPHandleFunc(s.handleGetUserBadges)).Methods("GET")
+	router.Handle("/users/{userId}/badges", userOnly(s.handleGetUserBadges, utils.ScopeReadProfile)).Methods("GET")
+
+	// Year in review routes
+	router.Handle("/users/{userId}/journey", userOnly(s.handleGetUserJourney, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/year-in-review", userOnly(s.handleGetYearInReview, utils.ScopeReadProfile)).Methods("GET")
+	router.Handle("/users/{userId}/year-in-review/mint", JWTAuth(utils.ScopeWriteSessions)(makeHTTPHandleFunc(s.handleMintYearInReview))).Methods("POST")
 
 	// frames v2
 	router.HandleFunc("/framesgiving/setup-writing-session", makeHTTPHandleFunc(s.handleFramesV2SetupWritingSession)).Methods("GET")
-	router.HandleFunc("/framesgiving/submit-writing-session", makeHTTPHandleFunc(s.handleFramesV2SubmitWritingSession)).Methods("POST", "OPTIONS")
+	router.Handle("/framesgiving/submit-writing-session", s.idempotent(framesSessionKey)(makeHTTPHandleFunc(s.handleFramesV2SubmitWritingSession))).Methods("POST", "OPTIONS")
 	router.HandleFunc("/framesgiving/generate-anky-image-from-session-long-string", makeHTTPHandleFunc(s.handleFramesV2GenerateAnkyImageFromSessionLongString)).Methods("POST")
 	router.HandleFunc("/framesgiving/fetch-anky-metadata-status", makeHTTPHandleFunc(s.handleFramesV2FetchAnkyMetadataStatus)).Methods("POST")
-	// WebSocket routes: TODO
-
-	log.Println("Server running on port:", s.listenAddr)
-	return http.ListenAndServe(s.listenAddr, router)
+	router.HandleFunc("/framesgiving/status/batch", makeHTTPHandleFunc(s.handleFramesV2BatchStatus)).Methods("POST", "OPTIONS")
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept-Version, Idempotency-Key, X-Anky-Client")
+		w.Header().Set("Access-Control-Expose-Headers", "API-Version, Deprecation, Sunset, Link, Retry-After, X-LLM-Quota-Limit, X-LLM-Quota-Remaining, Idempotent-Replayed")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -139,94 +340,63 @@ func (s *APIServer) handleFramesV2FetchAnkyMetadataStatus(w http.ResponseWriter,
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("❌ Error decoding request body: %v", err)
-		return fmt.Errorf("error decoding request body: %v", err)
+		return Validation("error decoding request body: %v", err)
 	}
 
 	if req.SessionID == "" {
 		log.Println("❌ Missing session_id in request body")
-		return fmt.Errorf("missing session_id in request body")
+		return Validation("missing session_id in request body")
 	}
 	log.Printf("✅ Found session ID: %s", req.SessionID)
 
-	// Build path to metadata file
-	filename := fmt.Sprintf("data/framesgiving/ankys/%s.txt", req.SessionID)
-	log.Printf("🔍 Looking for metadata file: %s", filename)
-
-	// Check if file exists
-	_, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		log.Printf("❌ Metadata file not found for session: %s", req.SessionID)
-		return WriteJSON(w, http.StatusOK, map[string]string{
-			"status": "pending",
-		})
-	}
-
-	// Read file content
-	content, err := os.ReadFile(filename)
+	status, err := s.sessionStatus(r.Context(), req.SessionID)
 	if err != nil {
-		log.Printf("❌ Error reading metadata file: %v", err)
-		return fmt.Errorf("error reading metadata file: %v", err)
+		log.Printf("❌ Error reading metadata for session %s: %v", req.SessionID, err)
+		return fmt.Errorf("error reading metadata: %w", err)
 	}
 
-	// Split content into lines
-	lines := strings.Split(string(content), "\n")
-	if len(lines) < 5 {
-		log.Printf("❌ Invalid metadata file format for session: %s", req.SessionID)
-		return fmt.Errorf("invalid metadata file format")
-	}
-
-	// Extract metadata components
-	tokenName := lines[0]
-	ticker := lines[1]
-	number := lines[2]
-	story := lines[3]
-	ipfsHash := lines[4]
-
-	if ipfsHash == "" {
-		log.Printf("❌ No IPFS hash found in metadata for session: %s", req.SessionID)
-		return WriteJSON(w, http.StatusOK, map[string]string{
-			"status": "pending",
-		})
-	}
-
-	log.Printf("✅ Found metadata: token=%s, ticker=%s, number=%s, ipfsHash=%s",
-		tokenName, ticker, number, ipfsHash)
-
-	return WriteJSON(w, http.StatusOK, map[string]interface{}{
-		"status":     "completed",
-		"ipfs_hash":  ipfsHash,
-		"token_name": tokenName,
-		"ticker":     ticker,
-		"number":     number,
-		"story":      story,
-	})
+	log.Printf("✅ Session %s status: %v", req.SessionID, status["status"])
+	return WriteJSON(w, http.StatusOK, status)
 }
 
 func (s *APIServer) handleFramesV2GenerateAnkyImageFromSessionLongString(w http.ResponseWriter, r *http.Request) error {
 	log.Println("🚀 Starting handleFramesV2GenerateAnkyImageFromSessionLongString endpoint")
+	if err := services.CheckFeature(services.FeatureImageGeneration); err != nil {
+		return err
+	}
 
 	// Parse request body
 	var req struct {
 		SessionLongString string `json:"session_long_string"`
 		Fid               string `json:"fid"`
+		License           string `json:"license"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("❌ Error decoding request body: %v", err)
-		return fmt.Errorf("error decoding request body: %v", err)
+		return Validation("error decoding request body: %v", err)
+	}
+
+	if err := s.enforceFramesCap(w, r, req.Fid); err != nil {
+		return err
+	}
+
+	license, err := s.licenseForSubmission(r.Context(), "", req.License)
+	if err != nil {
+		return err
 	}
 
 	// Create AnkyService instance
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
 		log.Printf("❌ Error creating AnkyService: %v", err)
-		return fmt.Errorf("error creating AnkyService: %v", err)
+		return fmt.Errorf("error creating AnkyService: %w", err)
 	}
 
 	// Call TriggerAnkyMintingProcess
-	if err := ankyService.TriggerAnkyMintingProcess(req.SessionLongString, req.Fid); err != nil {
+	if err := ankyService.TriggerAnkyMintingProcess(r.Context(), req.SessionLongString, req.Fid, license); err != nil {
 		log.Printf("❌ Error triggering anky minting process: %v", err)
-		return fmt.Errorf("error triggering anky minting process: %v", err)
+		return fmt.Errorf("error triggering anky minting process: %w", err)
 	}
 
 	return WriteJSON(w, http.StatusOK, map[string]string{
@@ -242,46 +412,43 @@ func (s *APIServer) handleFramesV2SetupWritingSession(w http.ResponseWriter, r *
 	fid := r.URL.Query().Get("fid")
 	if fid == "" {
 		log.Println("❌ Missing FID query parameter")
-		return fmt.Errorf("missing fid query parameter")
+		return Validation("missing fid query parameter")
 	}
 	log.Printf("✅ Found FID: %s", fid)
 
+	format, err := s.responseFormatFor(r.Context(), "", r.URL.Query().Get("format"))
+	if err != nil {
+		return err
+	}
+
 	// Generate new UUID for writing session
 	sessionID := uuid.New().String()
 	log.Printf("✨ Generated new session ID: %s", sessionID)
 
-	// Read the prompts file
-	log.Println("📖 Reading prompts file")
-	data, err := os.ReadFile("data/framesgiving/upcoming-prompts.txt")
+	parsedFID, err := strconv.Atoi(fid)
 	if err != nil {
-		log.Printf("❌ Error reading prompts file: %v", err)
-		return fmt.Errorf("error reading prompts file: %v", err)
+		log.Printf("❌ Invalid FID query parameter: %s", fid)
+		return Validation("invalid fid query parameter: %s", fid)
 	}
-	log.Println("✅ Successfully read prompts file")
-
-	// Split into lines
-	log.Println("✂️ Splitting prompts into lines")
-	lines := strings.Split(string(data), "\n")
-	log.Printf("📝 Found %d prompt lines", len(lines))
 
-	// Find matching prompt for FID
-	log.Printf("🔎 Searching for prompt matching FID: %s", fid)
-	for _, line := range lines {
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		if parts[0] == fid {
-			log.Println("✨ Found matching prompt, returning response")
-			return WriteJSON(w, http.StatusOK, map[string]interface{}{
-				"prompt":    parts[1],
-				"sessionId": sessionID,
-			})
-		}
+	// A running campaign's prompt comes first, FIDs without a prompt of their
+	// own get the default one
+	log.Printf("🔎 Looking up prompt for FID: %s", fid)
+	prompt := types.DefaultWritingPrompt
+	writingPrompt, err := services.NewCampaignService(s.store).GetPrompt(r.Context(), parsedFID)
+	if err == nil {
+		prompt = writingPrompt.Prompt
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("❌ Error getting prompt: %v", err)
+		return fmt.Errorf("error getting prompt: %w", err)
 	}
 
-	log.Printf("❌ No prompt found for FID %s", fid)
-	return fmt.Errorf("no prompt found for FID %s", fid)
+	log.Println("✨ Found prompt, returning response")
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"prompt":    utils.FormatAIResponse(prompt, format),
+		"sessionId": sessionID,
+		"format":    format,
+	})
 }
 
 func (s *APIServer) handleFramesV2SubmitWritingSession(w http.ResponseWriter, r *http.Request) error {
@@ -292,31 +459,49 @@ func (s *APIServer) handleFramesV2SubmitWritingSession(w http.ResponseWriter, r
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("❌ Error reading request body: %v", err)
-		return fmt.Errorf("error reading request body: %v", err)
+		return Validation("error reading request body: %v", err)
 	}
 
 	// Parse request body into struct
 	var req struct {
 		SessionLongString string `json:"session_long_string"`
+		License           string `json:"license"`
 	}
 	if err := json.Unmarshal(body, &req); err != nil {
 		log.Printf("❌ Error unmarshaling request body: %v", err)
-		return fmt.Errorf("error unmarshaling request body: %v", err)
+		return Validation("error unmarshaling request body: %v", err)
+	}
+
+	// Frames writers are FIDs without an account, so there is no default to fall back to
+	license, err := s.licenseForSubmission(r.Context(), "", req.License)
+	if err != nil {
+		return err
 	}
 
 	log.Println("🔍 Parsing writing session...")
 	parsedSession, err := utils.ParseWritingSession(req.SessionLongString)
 	if err != nil {
 		log.Printf("❌ Error parsing writing session: %v", err)
-		return fmt.Errorf("error parsing writing session: %v", err)
+		return Validation("error parsing writing session: %v", err)
+	}
+	if err := s.enforceFramesCap(w, r, parsedSession.UserID); err != nil {
+		return err
 	}
 
 	_, err = utils.SaveWritingSessionLocally(req.SessionLongString)
 	if err != nil {
 		log.Printf("❌ Error saving writing session: %v", err)
-		return fmt.Errorf("error saving writing session: %v", err)
+		return fmt.Errorf("error saving writing session: %w", err)
 	}
 
+	// Frames writers get listed under their user like app writers
+	writingSession, err := s.persistFramesWritingSession(r.Context(), parsedSession)
+	if err != nil {
+		log.Printf("❌ Error storing writing session: %v", err)
+		return fmt.Errorf("error storing writing session: %w", err)
+	}
+	log.Printf("✅ Stored writing session %s for user %s", writingSession.ID, writingSession.UserID)
+
 	log.Printf("📝 Parsed writing session details:\n"+
 		"UserID: %s\n"+
 		"SessionID: %s\n"+
@@ -335,10 +520,12 @@ func (s *APIServer) handleFramesV2SubmitWritingSession(w http.ResponseWriter, r
 	log.Printf("✅ Found FID: %s", fid)
 	ankyService, err := services.NewAnkyService(s.store)
 	// If session is longer than 480 seconds (8 minutes), trigger minting process
-	if parsedSession.TimeSpent >= 480 {
-		log.Printf("🎯 Writing session qualifies for minting (duration: %d seconds, threshold: 480 seconds)", parsedSession.TimeSpent)
+	if parsedSession.TimeSpent >= types.AnkySessionSeconds {
+		log.Printf("🎯 Writing session qualifies for minting (duration: %d seconds, threshold: %d seconds)", parsedSession.TimeSpent, types.AnkySessionSeconds)
 		// go s.triggerAnkyMinting(parsedSession, fid)
-		go ankyService.TriggerAnkyMintingProcess(req.SessionLongString, fid)
+		s.runInBackground(func(ctx context.Context) {
+			ankyService.TriggerAnkyMintingProcess(ctx, req.SessionLongString, fid, license)
+		})
 	} else {
 		log.Printf("⏱️ Session duration (%d seconds) does not qualify for minting", parsedSession.TimeSpent)
 	}
@@ -347,32 +534,46 @@ func (s *APIServer) handleFramesV2SubmitWritingSession(w http.ResponseWriter, r
 
 	if err != nil {
 		log.Printf("❌ Error creating anky service for long session: %v", err)
-		return fmt.Errorf("error creating anky service: %v", err)
+		return fmt.Errorf("error creating anky service: %w", err)
 	}
 	log.Println("✅ Anky service created successfully")
 
 	// Generate next prompt using LLM
 	log.Println("🤖 Generating next prompt using LLM...")
-	nextPrompt, err := ankyService.GenerateFramesgivingNextWritingPrompt(parsedSession)
+	depth := types.PromptDepthSurface
+	journey, err := services.NewJourneyService(s.store).GetUserJourney(r.Context(), writingSession.UserID)
+	if err != nil {
+		log.Printf("⚠️ Could not get journey of user %s, prompting at the surface: %v", writingSession.UserID, err)
+	} else {
+		depth = journey.Level
+	}
+	var topics *types.PromptTopics
+	if writer, err := s.store.GetUserByID(r.Context(), writingSession.UserID); err != nil {
+		log.Printf("⚠️ Could not get topic preferences of user %s: %v", writingSession.UserID, err)
+	} else {
+		topics = writer.PreferredPromptTopics()
+	}
+	nextPrompt, err := ankyService.GenerateFramesgivingNextWritingPrompt(parsedSession, depth, topics)
 	if err != nil {
 		log.Printf("❌ Error generating next prompt: %v", err)
-		return fmt.Errorf("error generating next prompt: %v", err)
+		return fmt.Errorf("error generating next prompt: %w", err)
 	}
 	log.Printf("✨ Generated next prompt: '%s'", nextPrompt)
 
-	// Update prompts file with new prompt for FID
-	log.Printf("💾 Updating prompts file for FID %s...", fid)
-	err = s.updatePromptsFile(fid, nextPrompt)
-	if err != nil {
-		log.Printf("❌ Error updating prompts file: %v", err)
-		return fmt.Errorf("error updating prompts file: %v", err)
+	// Store the new prompt for the FID's next session
+	log.Printf("💾 Storing next prompt for FID %s...", fid)
+	if err := s.storeNextPrompt(r.Context(), fid, nextPrompt); err != nil {
+		log.Printf("❌ Error storing next prompt: %v", err)
+		return fmt.Errorf("error storing next prompt: %w", err)
 	}
-	log.Printf("✅ Successfully updated prompts file with new prompt for FID %s", fid)
+	log.Printf("✅ Successfully stored new prompt for FID %s", fid)
 
 	log.Printf("🎉 Writing session processed successfully for FID %s", fid)
-	return WriteJSON(w, http.StatusOK, map[string]string{
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"status":  "success",
 		"message": "writing session processed successfully",
+		"focus":   parsedSession.Focus,
+		"user_id": writingSession.UserID,
 	})
 }
 
@@ -394,72 +595,11 @@ func (s *APIServer) triggerAnkyMinting(session *types.WritingSession, fid string
 	// TODO: Implement this function in anky_service.go
 }
 
-/*
-Function: updatePromptsFile
-Purpose: Update the prompts file with new prompt for given FID
-Input: FID and new prompt
-Output: Error if any
-
-Should:
-1. Read existing prompts file
-2. Update or add entry for FID
-3. Write back to file atomically
-4. Handle concurrent access safely
-*/
-func (s *APIServer) updatePromptsFile(fid string, prompt string) error {
-	log.Printf("🔄 Updating prompts file for FID %s with prompt: %s", fid, prompt)
-
-	// Read the prompts file
-	data, err := os.ReadFile("data/framesgiving/upcoming-prompts.txt")
-	if err != nil {
-		log.Printf("❌ Error reading prompts file: %v", err)
-		return fmt.Errorf("error reading prompts file: %v", err)
-	}
-
-	// Split into lines and create a map of existing FIDs
-	lines := strings.Split(string(data), "\n")
-	found := false
-	newLines := make([]string, 0)
-
-	// Check each line and update if FID exists
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) != 2 {
-			log.Printf("⚠️ Skipping malformed line: %s", line)
-			continue
-		}
-		if parts[0] == fid {
-			log.Printf("✅ Found existing FID %s, updating prompt", fid)
-			newLines = append(newLines, fmt.Sprintf("%s %s", fid, prompt))
-			found = true
-		} else {
-			newLines = append(newLines, line)
-		}
-	}
-
-	// If FID wasn't found, add it as a new line
-	if !found {
-		log.Printf("➕ Adding new FID %s with prompt", fid)
-		newLines = append(newLines, fmt.Sprintf("%s %s", fid, prompt))
-	}
-
-	// Write back to file
-	newContent := strings.Join(newLines, "\n") + "\n"
-	err = os.WriteFile("data/framesgiving/upcoming-prompts.txt", []byte(newContent), 0644)
-	if err != nil {
-		log.Printf("❌ Error writing prompts file: %v", err)
-		return fmt.Errorf("error writing prompts file: %v", err)
-	}
-
-	log.Printf("✨ Successfully updated prompts file")
-	return nil
-}
-
 func (s *APIServer) handleRegisterNewFID(w http.ResponseWriter, r *http.Request) error {
 	log.Println("=== Starting handleRegisterNewFID endpoint ===")
+	if err := services.CheckFeature(services.FeatureFIDRegistration); err != nil {
+		return err
+	}
 
 	var req struct {
 		Deadline  int       `json:"deadline"`
@@ -471,16 +611,28 @@ func (s *APIServer) handleRegisterNewFID(w http.ResponseWriter, r *http.Request)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("❌ Failed to decode request body: %v", err)
-		return fmt.Errorf("error decoding request body: %w", err)
+		return Validation("error decoding request body: %v", err)
 	}
 
 	log.Printf("📥 Received request to register new FID with params: %+v", req)
 
+	if err := s.checkCanStartFIDFlow(r.Context(), req.UserID); err != nil {
+		log.Printf("🛑 User %s cannot register a FID: %v", req.UserID, err)
+		return Conflict("%v", err)
+	}
+
 	pendingAnkys, err := s.store.GetAnkysByUserIDAndStatus(r.Context(), req.UserID, "pending_to_cast")
 	if err != nil {
 		log.Printf("❌ Failed to get pending ankys: %v", err)
 		return fmt.Errorf("error getting pending ankys: %w", err)
 	}
+	if len(pendingAnkys) == 0 {
+		return NotFound("no pending ankys found for user %s", req.UserID)
+	}
+
+	// Derive a valid fname from the token name of the user's first Anky
+	fname := utils.SanitizeFname(pendingAnkys[0].TokenName, pendingAnkys[0].ID.String())
+	log.Printf("🔤 Derived fname %s from token name %s", fname, pendingAnkys[0].TokenName)
 
 	// Prepare request to Neynar API
 	neynarReq := struct {
@@ -494,7 +646,7 @@ func (s *APIServer) handleRegisterNewFID(w http.ResponseWriter, r *http.Request)
 		FID:                         req.FID,
 		RequestedUserCustodyAddress: req.Address,
 		Deadline:                    req.Deadline,
-		Fname:                       pendingAnkys[0].TokenName,
+		Fname:                       fname,
 	}
 
 	jsonData, err := json.Marshal(neynarReq)
@@ -565,15 +717,17 @@ func (s *APIServer) handleRegisterNewFID(w http.ResponseWriter, r *http.Request)
 	user.FID = result.Signer.FID
 
 	log.Println("💾 Saving updated user data to database...")
-	if err := s.store.UpdateUser(r.Context(), req.UserID, user); err != nil {
-		log.Printf("❌ Failed to update user: %v", err)
-		return fmt.Errorf("error updating user: %w", err)
+	if err := s.store.LinkFarcasterUser(r.Context(), req.UserID, user.FarcasterUser); err != nil {
+		log.Printf("❌ Failed to link farcaster user: %v", err)
+		return fmt.Errorf("error linking farcaster user: %w", err)
 	}
 
 	log.Printf("✅ Successfully updated user with new Farcaster data: %+v", user)
 
 	log.Println("🚀 Launching goroutine to publish first Anky to Farcaster...")
-	go services.NewFarcasterService().PublishFirstUserAnkyToFarcaster(req.UserID)
+	s.runInBackground(func(ctx context.Context) {
+		services.NewFarcasterService().PublishFirstUserAnkyToFarcaster(req.UserID)
+	})
 
 	log.Println("✅ Registration complete - sending success response")
 	return WriteJSON(w, http.StatusOK, map[string]bool{"success": true})
@@ -581,6 +735,9 @@ func (s *APIServer) handleRegisterNewFID(w http.ResponseWriter, r *http.Request)
 
 func (s *APIServer) handleGetNewFID(w http.ResponseWriter, r *http.Request) error {
 	log.Println("=== Starting handleGetNewFID endpoint ===")
+	if err := services.CheckFeature(services.FeatureFIDRegistration); err != nil {
+		return err
+	}
 
 	// Check total number of FIDs
 	numberOfFids, err := s.store.CountNumberOfFids(context.Background())
@@ -590,26 +747,34 @@ func (s *APIServer) handleGetNewFID(w http.ResponseWriter, r *http.Request) erro
 	}
 	log.Printf("📊 Current total number of FIDs: %d", numberOfFids)
 
-	// Check if we've hit the 504 FID limit
-	if numberOfFids == 504 {
-		log.Println("🛑 Cannot create new FID - reached maximum limit of 504")
-		return WriteJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "the fifth season of anky is complete",
-		})
+	// Check if we've hit the season FID limit
+	if numberOfFids >= seasonFidCap {
+		log.Printf("🛑 Cannot create new FID - reached maximum limit of %d", seasonFidCap)
+		return Conflict("the fifth season of anky is complete")
 	}
 
 	// Parse the incoming request
 	var req struct {
 		UserWalletAddress string    `json:"user_wallet_address"`
 		UserID            uuid.UUID `json:"user_id"`
+		DeviceFingerprint string    `json:"device_fingerprint"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("❌ Failed to parse request body. Error: %v", err)
-		return fmt.Errorf("error decoding request body: %w", err)
+		return Validation("error decoding request body: %v", err)
 	}
 	log.Printf("👉 Processing request for wallet address: %s", req.UserWalletAddress)
 	log.Printf("👉 Processing request for user ID: %s", req.UserID)
+	if !common.IsHexAddress(req.UserWalletAddress) {
+		return Validation("invalid user_wallet_address")
+	}
+	custody := common.HexToAddress(req.UserWalletAddress)
+
+	if err := s.checkCanStartFIDFlow(r.Context(), req.UserID); err != nil {
+		log.Printf("🛑 User %s cannot start the FID flow: %v", req.UserID, err)
+		return Conflict("%v", err)
+	}
 
 	pendingAnkys, err := s.store.GetAnkysByUserIDAndStatus(r.Context(), req.UserID, "pending_to_cast")
 	if err != nil {
@@ -619,12 +784,28 @@ func (s *APIServer) handleGetNewFID(w http.ResponseWriter, r *http.Request) erro
 
 	if len(pendingAnkys) == 0 {
 		log.Println("❌ No pending Ankys found for user - cannot proceed with FID registration")
-		return WriteJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "You need to write your first Anky (8 minutes of writing) before getting a Farcaster ID",
-		})
+		return Validation("You need to write your first Anky (8 minutes of writing) before getting a Farcaster ID")
 	}
 	log.Printf("✅ Found %d pending Ankys for user", len(pendingAnkys))
 
+	// Season slots are finite, keep bots from draining them
+	fidRequest, err := services.NewFIDScoringService(s.store).Evaluate(r.Context(), req.UserID, req.DeviceFingerprint, clientIP(r))
+	if err != nil {
+		log.Printf("❌ Failed to score FID request: %v", err)
+		return fmt.Errorf("error scoring FID request: %w", err)
+	}
+	switch fidRequest.Status {
+	case types.FIDRequestPending:
+		log.Printf("🕵️ FID request for user %s is waiting for manual review", req.UserID)
+		return WriteJSON(w, http.StatusAccepted, map[string]string{
+			"status":     "under_review",
+			"request_id": fidRequest.ID.String(),
+		})
+	case types.FIDRequestRejected:
+		log.Printf("🛑 FID request for user %s rejected with score %.2f", req.UserID, fidRequest.Score)
+		return Forbidden("we could not verify this request, keep writing and try again later")
+	}
+
 	// Set up Neynar API call
 	client := &http.Client{}
 	neynarReq, err := http.NewRequest("GET", "https://api.neynar.com/v2/farcaster/user/fid", nil)
@@ -657,15 +838,23 @@ func (s *APIServer) handleGetNewFID(w http.ResponseWriter, r *http.Request) erro
 	deadline := time.Now().Unix() + 3600
 	log.Printf("⏰ Setting deadline for FID registration: %d (1 hour from now)", deadline)
 
-	// Get nonce (placeholder)
-	nonce := 0 // TODO: Implement actual contract nonce retrieval
-	log.Printf("🔢 Using nonce value: %d", nonce)
+	// Users who registered before need their current nonce, a signature with
+	// a stale one is rejected on chain
+	nonce, err := services.NewIDRegistryService().Nonce(r.Context(), custody)
+	if errors.Is(err, services.ErrIDRegistryNotConfigured) {
+		return newHTTPError(http.StatusServiceUnavailable, CodeFeatureDisabled, "%v", err)
+	}
+	if err != nil {
+		log.Printf("❌ Failed to read the registration nonce of %s. Error: %v", custody.Hex(), err)
+		return fmt.Errorf("error reading registration nonce: %w", err)
+	}
+	log.Printf("🔢 Using nonce value: %s", nonce)
 
 	// Prepare response
 	response := map[string]string{
 		"new_fid":        fmt.Sprintf("%d", neynarResp.FID),
 		"deadline":       fmt.Sprintf("%d", deadline),
-		"nonce":          fmt.Sprintf("%d", nonce),
+		"nonce":          nonce.String(),
 		"address":        req.UserWalletAddress,
 		"number_of_fids": fmt.Sprintf("%d", numberOfFids),
 	}
@@ -698,7 +887,7 @@ func (s *APIServer) handleRegisterPrivyUser(w http.ResponseWriter, r *http.Reque
 	}
 
 	bodyBytes, _ := io.ReadAll(r.Body)
-	log.Printf("[RegisterPrivyUser] Raw request body: %s", string(bodyBytes))
+	log.Printf("[RegisterPrivyUser] Raw request body: %s", logging.Redact(string(bodyBytes)))
 	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -709,7 +898,7 @@ func (s *APIServer) handleRegisterPrivyUser(w http.ResponseWriter, r *http.Reque
 
 	if req.User == nil {
 		log.Println("[RegisterPrivyUser] Missing user data in request")
-		return fmt.Errorf("missing user data")
+		return Validation("missing user data")
 	}
 	log.Printf("[RegisterPrivyUser] Processing user with ID: %s", req.User.UserID)
 
@@ -720,29 +909,50 @@ func (s *APIServer) handleRegisterPrivyUser(w http.ResponseWriter, r *http.Reque
 	}
 	log.Printf("[RegisterPrivyUser] Parsed UUID: %s", userUUID)
 
-	createdAt := time.Unix(req.User.CreatedAt, 0)
-	log.Printf("[RegisterPrivyUser] User creation time: %v", createdAt)
+	// PrivyAuth checked the token of this DID, the rest of the payload is
+	// checked against Privy
+	authDID, _ := r.Context().Value(UserIDKey).(string)
+	if req.User.ID == "" || req.User.ID != authDID {
+		log.Printf("[RegisterPrivyUser] Privy user %s doesn't match the token of %s", req.User.ID, authDID)
+		return Forbidden("the privy user doesn't match the authenticated one")
+	}
+
+	privyUser, err := services.NewPrivyService().VerifyLinkedAccounts(r.Context(), req.User.ID, req.User.LinkedAccounts)
+	var mismatch *services.LinkedAccountMismatchError
+	switch {
+	case errors.As(err, &mismatch):
+		log.Printf("[RegisterPrivyUser] Rejected claims of %s: %v", req.User.ID, err)
+		return newHTTPError(http.StatusForbidden, CodeLinkedAccountMismatch, "%v", err)
+	case errors.Is(err, services.ErrPrivyUserNotFound):
+		return Forbidden("%v", err)
+	case errors.Is(err, services.ErrPrivyNotConfigured):
+		return newHTTPError(http.StatusServiceUnavailable, CodeFeatureDisabled, "%v", err)
+	case err != nil:
+		return err
+	}
+	privyUser.UserID = userUUID
+	log.Printf("[RegisterPrivyUser] Verified %d linked accounts with Privy", len(privyUser.LinkedAccounts))
 
-	log.Printf("[RegisterPrivyUser] Fetching existing user with ID: %s", userUUID)
 	user, err := s.store.GetUserByID(r.Context(), userUUID)
 	if err != nil {
-		log.Printf("[RegisterPrivyUser] Error fetching user: %v", err)
 		return err
 	}
-	log.Printf("[RegisterPrivyUser] Found existing user: %+v", user)
-
-	user.PrivyUser = &types.PrivyUser{
-		DID:              req.User.ID,
-		UserID:           userUUID,
-		CreatedAt:        createdAt,
-		LinkedAccounts:   req.User.LinkedAccounts,
-		HasAcceptedTerms: req.User.HasAcceptedTerms,
-		IsGuest:          req.User.IsGuest,
+	if user.PrivyDID != "" && user.PrivyDID != privyUser.DID {
+		return Conflict("user %s is linked to another privy account", userUUID)
+	}
+	if err := s.store.SavePrivyUser(r.Context(), privyUser); errors.Is(err, storage.ErrPrivyDIDTaken) {
+		return Conflict("%v", err)
+	} else if err != nil {
+		return err
 	}
-	user.PrivyDID = req.User.ID
-	log.Printf("[RegisterPrivyUser] Updated user with Privy details: %+v", user.PrivyUser)
 
-	if err := s.store.UpdateUser(r.Context(), userUUID, user); err != nil {
+	log.Printf("[RegisterPrivyUser] Updating user with ID: %s", userUUID)
+	err = s.updateUserWithRetry(r.Context(), userUUID, func(user *types.User) {
+		user.PrivyUser = privyUser
+		user.PrivyDID = privyUser.DID
+		log.Printf("[RegisterPrivyUser] Updated user with Privy details: %+v", user.PrivyUser)
+	})
+	if err != nil {
 		log.Printf("[RegisterPrivyUser] Error updating user: %v", err)
 		return err
 	}
@@ -762,24 +972,18 @@ func (s *APIServer) handleFinishedAnkyRegistration(w http.ResponseWriter, r *htt
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return fmt.Errorf("error decoding request body: %v", err)
-	}
-
-	// Get existing user
-	user, err := s.store.GetUserByID(r.Context(), req.UserID)
-	if err != nil {
-		return fmt.Errorf("error getting user: %v", err)
+		return Validation("error decoding request body: %v", err)
 	}
 
 	// Update user with new farcaster properties
-	user.FarcasterUser = &types.FarcasterUser{
-		SignerUUID: req.SignerUUID,
-	}
-	user.FID = req.FID
-
-	// Update user in database
-	if err := s.store.UpdateUser(r.Context(), req.UserID, user); err != nil {
-		return fmt.Errorf("error updating user: %v", err)
+	err := s.updateUserWithRetry(r.Context(), req.UserID, func(user *types.User) {
+		user.FarcasterUser = &types.FarcasterUser{
+			SignerUUID: req.SignerUUID,
+		}
+		user.FID = req.FID
+	})
+	if err != nil {
+		return fmt.Errorf("error updating user: %w", err)
 	}
 
 	return WriteJSON(w, http.StatusOK, map[string]string{
@@ -790,11 +994,17 @@ func (s *APIServer) handleFinishedAnkyRegistration(w http.ResponseWriter, r *htt
 func (s *APIServer) handleProcessWritingConversation(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	log.Println("Starting handleProcessWritingConversation...")
+	authUserID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("missing authenticated user")
+	}
 
 	// Define request structure
 	type RequestBody struct {
 		ConversationSoFar []string `json:"conversation_so_far"`
 		WritingString     string   `json:"writing_string"`
+		License           string   `json:"license"`
+		Format            string   `json:"format"`
 	}
 
 	// Parse request body
@@ -805,45 +1015,57 @@ func (s *APIServer) handleProcessWritingConversation(w http.ResponseWriter, r *h
 	}
 	log.Printf("Received %d messages to process", len(req.ConversationSoFar))
 	// Print the conversation so far for debugging
-	log.Printf("Conversation so far: %+v", req.ConversationSoFar)
 	for i, msg := range req.ConversationSoFar {
-		log.Printf("Message %d: %s", i, msg)
+		log.Printf("Message %d: %s", i, logging.Content(msg))
 	}
 
 	// Check the last writing session
+	writerID := authUserID.String()
 	if len(req.ConversationSoFar) > 0 {
 		lastMsg := req.ConversationSoFar[len(req.ConversationSoFar)-1]
 		writingSession, err := utils.ParseWritingSession(lastMsg)
 		if err != nil {
 			log.Printf("Error parsing last writing session: %v", err)
 		} else {
+			sessionUserID, err := uuid.Parse(writingSession.UserID)
+			if err != nil {
+				return Validation("invalid user id in writing session: %q", writingSession.UserID)
+			}
+			if err := authorizeUser(r, sessionUserID); err != nil {
+				return err
+			}
+			writerID = writingSession.UserID
 			// Calculate total session time
 			var totalTime = 8000
 			for _, keystroke := range writingSession.KeyStrokes {
 				totalTime += keystroke.Delay
 			}
-			fmt.Println("Total time for session:", totalTime)
-			fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-			fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-			fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-			fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-			fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-			fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
+			log.Println("Total time for session:", totalTime)
 			// If session was longer than 480 seconds (8 minutes)
 			if totalTime > 480000 { // Convert to milliseconds
 				log.Printf("Long writing session detected (%d ms). Triggering Anky creation", totalTime)
-				go func() {
+				license, err := s.licenseForSubmission(ctx, writingSession.UserID, req.License)
+				if err != nil {
+					return err
+				}
+				// The request context ends with the response, the pipeline runs on the server's
+				s.runInBackground(func(ctx context.Context) {
 					ankyService, err := services.NewAnkyService(s.store)
 					if err != nil {
 						log.Printf("Error creating anky service for long session: %v", err)
 						return
 					}
-					ankyService.ProcessAnkyCreationFromWritingString(ctx, writingSession.RawContent, writingSession.SessionID, writingSession.UserID)
-				}()
+					ankyService.ProcessAnkyCreationFromWritingString(ctx, writingSession.RawContent, writingSession.SessionID, writingSession.UserID, license)
+				})
 			}
 		}
 	}
 
+	format, err := s.responseFormatFor(ctx, writerID, req.Format)
+	if err != nil {
+		return err
+	}
+
 	// Call service to process conversation
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
@@ -851,15 +1073,28 @@ func (s *APIServer) handleProcessWritingConversation(w http.ResponseWriter, r *h
 		return err
 	}
 
-	response, err := ankyService.ReflectBackFromWritingSessionConversation(req.ConversationSoFar, req.WritingString)
+	// Clients may only send the latest turns, the cache fills in the rest
+	header, _, err := utils.ParseSessionHeader(req.WritingString)
+	if err != nil {
+		return Validation("%v", err)
+	}
+	sessionID := header.SessionID
+	conversation := s.conversations.Merge(authUserID, sessionID, req.ConversationSoFar)
+	if len(conversation) != len(req.ConversationSoFar) {
+		log.Printf("Restored %d cached turns for session %s", len(conversation)-len(req.ConversationSoFar), sessionID)
+	}
+
+	response, err := ankyService.ReflectBackFromWritingSessionConversation(conversation, req.WritingString)
 	if err != nil {
 		log.Printf("Error processing writing conversation: %v", err)
 		return err
 	}
 	log.Printf("Successfully generated response of length: %d", len(response))
+	s.conversations.Save(authUserID, sessionID, append(conversation, response))
 
 	return WriteJSON(w, http.StatusOK, map[string]string{
-		"prompt": response,
+		"prompt": utils.FormatAIResponse(response, format),
+		"format": format,
 	})
 }
 
@@ -905,7 +1140,7 @@ func (s *APIServer) handleRegisterAnonymousUser(w http.ResponseWriter, r *http.R
 		return fmt.Errorf("database store is not initialized")
 	}
 
-	if err := s.store.CreateUser(ctx, user); err != nil {
+	if err := s.store.CreateUserWithRelations(ctx, user); err != nil {
 		log.Printf("Error storing user in database: %v", err)
 		return err
 	}
@@ -913,7 +1148,7 @@ func (s *APIServer) handleRegisterAnonymousUser(w http.ResponseWriter, r *http.R
 
 	log.Println("Sending successful response")
 	return WriteJSON(w, http.StatusOK, map[string]interface{}{
-		"user": user,
+		"user": types.NewPrivateUser(user),
 		"jwt":  tokenString,
 	})
 }
@@ -940,13 +1175,14 @@ func (s *APIServer) handleGetUsers(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return err
 	}
-	return WriteJSON(w, http.StatusOK, accounts)
+	return WriteSelectedJSON(w, r, http.StatusOK, types.NewPrivateUsers(accounts), privateUserFields)
 }
 
 // GET /users/{id}
+// The user themselves get their whole account, anyone else their public profile.
 func (s *APIServer) handleGetUserByID(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	id, err := utils.GetUserID(r)
+	id, err := pathUserID(r)
 	if err != nil {
 		return err
 	}
@@ -954,13 +1190,20 @@ func (s *APIServer) handleGetUserByID(w http.ResponseWriter, r *http.Request) er
 	if err != nil {
 		return err
 	}
-	return WriteJSON(w, http.StatusOK, user)
+	// Accounts being deleted are gone to everyone but admins
+	if !utils.HasScopes(authenticatedScopes(r), utils.ScopeAdmin) && s.isUserDeleting(ctx, id) {
+		return NotFound("user not found")
+	}
+	if authorizeUser(r, id) != nil {
+		return WriteSelectedJSON(w, r, http.StatusOK, types.NewPublicUser(user), publicUserFields)
+	}
+	return WriteSelectedJSON(w, r, http.StatusOK, types.NewPrivateUser(user), privateUserFields)
 }
 
 // PUT /users/{id}
 func (s *APIServer) handleUpdateUser(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	id, err := utils.GetUserID(r)
+	id, err := pathUserID(r)
 	if err != nil {
 		return err
 	}
@@ -968,126 +1211,217 @@ func (s *APIServer) handleUpdateUser(w http.ResponseWriter, r *http.Request) err
 	if err := json.NewDecoder(r.Body).Decode(updateUserRequest); err != nil {
 		return err
 	}
-	err = s.store.UpdateUser(ctx, id, updateUserRequest.User)
-	if err != nil {
-		return err
+	if user := updateUserRequest.User; user != nil && user.Settings != nil && user.Settings.DefaultLicense != "" && !types.IsValidLicense(user.Settings.DefaultLicense) {
+		return Validation("invalid default license %q, expected one of %s", user.Settings.DefaultLicense, strings.Join(types.Licenses, ", "))
+	}
+	if user := updateUserRequest.User; user != nil && user.Settings != nil && user.Settings.ResponseFormat != "" && !types.IsValidResponseFormat(user.Settings.ResponseFormat) {
+		return Validation("invalid response format %q, expected one of %s", user.Settings.ResponseFormat, strings.Join(types.ResponseFormats, ", "))
+	}
+	if updateUserRequest.User == nil {
+		return Validation("missing user")
+	}
+	if settings := updateUserRequest.User.Settings; settings != nil {
+		topics, err := types.NormalizePromptTopics(settings.PromptTopics)
+		if err != nil {
+			return Validation("invalid prompt topics: %v", err)
+		}
+		settings.PromptTopics = topics
 	}
-	return WriteJSON(w, http.StatusOK, map[string]int{"updated": 1})
-}
 
-// DELETE /users/{id}
-func (s *APIServer) handleDeleteUser(w http.ResponseWriter, r *http.Request) error {
-	// TODO ::::: IMPLEMENT JWT FOR VERIFICATION THAT THE USER IS THE OWNER OF THE ACCOUNT THAT IS BEING DELETED
-	ctx := r.Context()
-	id, err := utils.GetUserID(r)
+	// Clients never get to see the seed phrase and JWT, so they can't send them back either
+	current, err := s.store.GetUserByID(ctx, id)
 	if err != nil {
 		return err
 	}
+	updateUserRequest.User.SeedPhrase = current.SeedPhrase
+	updateUserRequest.User.JWT = current.JWT
 
-	// Get authenticated user ID from context
-	authenticatedUserID, ok := ctx.Value("userID").(uuid.UUID)
-	if !ok {
-		return fmt.Errorf("unauthorized: no user ID in context")
+	err = s.store.UpdateUser(ctx, id, updateUserRequest.User)
+	if err != nil {
+		return err
 	}
+	return WriteJSON(w, http.StatusOK, map[string]int{"updated": 1, "version": updateUserRequest.User.Version})
+}
 
-	// Check if authenticated user matches requested user ID
-	if authenticatedUserID != id {
-		return fmt.Errorf("unauthorized: cannot delete other users")
+// Attempts of updateUserWithRetry before the conflict goes back to the client
+const userUpdateAttempts = 3
+
+// updateUserWithRetry applies change to the stored user and saves it. When
+// another flow updated the user in between, it reads the user again and
+// reapplies change, so flows touching different fields don't clobber each other.
+func (s *APIServer) updateUserWithRetry(ctx context.Context, userID uuid.UUID, change func(user *types.User)) error {
+	var err error
+	for attempt := 1; attempt <= userUpdateAttempts; attempt++ {
+		var user *types.User
+		user, err = s.store.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		change(user)
+		err = s.store.UpdateUser(ctx, userID, user)
+		if !errors.Is(err, storage.ErrVersionConflict) {
+			return err
+		}
+		log.Printf("⚠️ User %s changed while updating it (attempt %d): %v", userID, attempt, err)
 	}
-
-	return s.store.DeleteUser(ctx, id)
+	return err
 }
 
 func (s *APIServer) handleCreateUserProfile(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println("Starting handleCreateUserProfile...")
+	log.Println("Starting handleCreateUserProfile...")
+	if err := services.CheckFeature(services.FeatureFIDRegistration); err != nil {
+		return err
+	}
 	ctx := r.Context()
 
-	fmt.Println("Attempting to get user ID from request...")
-	userID, err := utils.GetUserID(r)
+	log.Println("Attempting to get user ID from request...")
+	userID, err := pathUserID(r)
 	if err != nil {
-		fmt.Printf("Error getting user ID: %v\n", err)
+		log.Printf("Error getting user ID: %v", err)
 		return err
 	}
-	fmt.Printf("User ID obtained: %s\n", userID)
+	log.Printf("User ID obtained: %s", userID)
 
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
-		fmt.Printf("Error creating anky service: %v\n", err)
-		return fmt.Errorf("error creating anky service: %v", err)
+		log.Printf("Error creating anky service: %v", err)
+		return fmt.Errorf("error creating anky service: %w", err)
 	}
-	fmt.Println("Anky service created successfully")
+	log.Println("Anky service created successfully")
 
-	fmt.Println("Processing onboarding conversation...")
+	log.Println("Processing onboarding conversation...")
 	response, err := ankyService.CreateUserProfile(ctx, userID)
 	if err != nil {
-		fmt.Printf("Error processing onboarding conversation: %v\n", err)
-		return fmt.Errorf("error processing onboarding conversation: %v", err)
+		log.Printf("Error processing onboarding conversation: %v", err)
+		return fmt.Errorf("error processing onboarding conversation: %w", err)
 	}
-	fmt.Printf("Onboarding conversation processed successfully, response: %s\n", response)
+	log.Printf("Onboarding conversation processed successfully, response: %s", logging.Content(response))
 
-	fmt.Println("Sending response...")
+	log.Println("Sending response...")
 	return WriteJSON(w, http.StatusOK, map[string]string{
 		"123": "123",
 	})
 
 }
 
+// GET /newen/transactions/{userId}?limit=50&offset=0
+// Returns the user's newen ledger, most recent first.
 func (s *APIServer) handleGetUserTransactions(w http.ResponseWriter, r *http.Request) error {
-	// Extract user ID and wallet address from URL params
-	vars := mux.Vars(r)
-	userID := vars["userId"]
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
 
-	if userID == "" {
-		return fmt.Errorf("missing required parameters: userId and walletAddress")
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = min(parsedLimit, 200)
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
 	}
 
-	// Create newen service
 	newenService, err := services.NewNewenService(s.store)
 	if err != nil {
-		return fmt.Errorf("error creating newen service: %v", err)
+		return fmt.Errorf("error creating newen service: %w", err)
 	}
-
-	// Process transaction
-	transactions, err := newenService.GetUserTransactions(userID)
+	transactions, err := newenService.GetUserTransactions(r.Context(), userID, limit, offset)
 	if err != nil {
-		return fmt.Errorf("error processing transaction: %v", err)
+		return err
 	}
 
 	return WriteJSON(w, http.StatusOK, transactions)
 }
 
+// POST /newen/spend
+// Debits the authenticated user's newen for a purchase, like buying an anky
+// clanker. Overdrafts are rejected. Clients should send an idempotency_key so
+// retrying a spend returns the first one instead of debiting twice.
+func (s *APIServer) handleSpendNewen(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("authentication required")
+	}
+
+	var req struct {
+		Amount         int    `json:"amount"`
+		Description    string `json:"description"`
+		IdempotencyKey string `json:"idempotency_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Amount <= 0 {
+		return Validation("amount must be positive")
+	}
+	if req.Description == "" {
+		return Validation("description is required")
+	}
+	if len(req.IdempotencyKey) > 255 {
+		return Validation("idempotency_key must be at most 255 characters")
+	}
+
+	newenService, err := services.NewNewenService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating newen service: %w", err)
+	}
+	transaction, balance, replayed, err := newenService.Spend(r.Context(), userID, req.Amount, req.Description, req.IdempotencyKey)
+	if errors.Is(err, storage.ErrInsufficientNewen) {
+		return newHTTPError(http.StatusConflict, CodeInsufficientBalance, "insufficient balance: %d newen available, %d needed", balance, req.Amount)
+	}
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusCreated
+	if replayed {
+		status = http.StatusOK
+	} else {
+		log.Printf("💸 User %s spent %d newen on %q, %d left", userID, req.Amount, req.Description, balance)
+	}
+	return WriteJSON(w, status, map[string]interface{}{
+		"transaction": transaction,
+		"balance":     balance,
+	})
+}
+
 // ***************** PRIVY ROUTES *****************
 
 func (s *APIServer) handleCreatePrivyUser(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
 	// 1. Verify authentication token from request header
-	userId, err := utils.GetUserID(r)
+	userId, err := pathUserID(r)
 	if err != nil {
 		return err
 	}
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return fmt.Errorf("no authorization header provided")
+		return Unauthorized("no authorization header provided")
 	}
 
 	// Extract Bearer token
 	tokenParts := strings.Split(authHeader, " ")
 	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-		return fmt.Errorf("invalid authorization header format")
+		return Unauthorized("invalid authorization header format")
 	}
 	token := tokenParts[1]
 
 	// 2. Validate the token and get user claims
 	_, err = utils.ValidateJWT(token)
 	if err != nil {
-		return fmt.Errorf("invalid token: %v", err)
+		return Unauthorized("invalid token: %v", err)
 	}
 
 	// 3. Decode the request body
 	newPrivyUserRequest := new(types.CreatePrivyUserRequest)
 	if err := json.NewDecoder(r.Body).Decode(newPrivyUserRequest); err != nil {
-		return fmt.Errorf("invalid request body: %v", err)
+		return Validation("invalid request body: %v", err)
 	}
 
 	// 4. Create new PrivyUser with associated user ID
@@ -1100,7 +1434,7 @@ func (s *APIServer) handleCreatePrivyUser(w http.ResponseWriter, r *http.Request
 
 	// 5. Store the PrivyUser in database
 	if err := s.store.CreatePrivyUser(ctx, privyUser); err != nil {
-		return fmt.Errorf("failed to create privy user: %v", err)
+		return fmt.Errorf("failed to create privy user: %w", err)
 	}
 
 	return WriteJSON(w, http.StatusCreated, privyUser)
@@ -1112,186 +1446,335 @@ func (s *APIServer) handleCreatePrivyUser(w http.ResponseWriter, r *http.Request
 func (s *APIServer) handleWritingSessionStarted(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	fmt.Println("Handling writing session started request...")
-	fmt.Println("Parsing request body...")
+	log.Println("Handling writing session started request...")
+	log.Println("Parsing request body...")
 
 	newWritingSessionRequest := new(types.CreateWritingSessionRequest)
 	if err := json.NewDecoder(r.Body).Decode(newWritingSessionRequest); err != nil {
-		fmt.Printf("Error decoding request body: %v\n", err)
+		log.Printf("Error decoding request body: %v", err)
 		return err
 	}
-	fmt.Printf("Decoded writing session request: %+v\n", newWritingSessionRequest)
+	log.Printf("Decoded writing session request: %+v", newWritingSessionRequest)
 
 	// Parse session ID
-	fmt.Printf("Attempting to parse session ID: %s\n", newWritingSessionRequest.SessionID)
+	log.Printf("Attempting to parse session ID: %s", newWritingSessionRequest.SessionID)
 	sessionUUID, err := uuid.Parse(newWritingSessionRequest.SessionID)
 	if err != nil {
-		fmt.Printf("Failed to parse session ID: %v\n", err)
-		return fmt.Errorf("invalid session ID: %v", err)
+		log.Printf("Failed to parse session ID: %v", err)
+		return Validation("invalid session ID: %v", err)
 	}
-	fmt.Printf("Successfully parsed session ID to UUID: %s\n", sessionUUID)
+	log.Printf("Successfully parsed session ID to UUID: %s", sessionUUID)
 
 	// Handle anonymous users with a default UUID
-	fmt.Printf("Processing user ID: %s\n", newWritingSessionRequest.UserID)
+	log.Printf("Processing user ID: %s", newWritingSessionRequest.UserID)
 	var userUUID uuid.UUID
 	if newWritingSessionRequest.UserID == "anonymous" {
-		fmt.Println("Anonymous user detected, using default UUID")
+		log.Println("Anonymous user detected, using default UUID")
 		// Use a specific UUID for anonymous users
-		userUUID = uuid.MustParse("00000000-0000-0000-0000-000000000000") // Anonymous user UUID
+		userUUID = types.AnonymousUserID
 	} else {
-		fmt.Println("Parsing non-anonymous user ID")
+		log.Println("Parsing non-anonymous user ID")
 		userUUID, err = uuid.Parse(newWritingSessionRequest.UserID)
 		if err != nil {
-			fmt.Printf("Failed to parse user ID: %v\n", err)
-			return fmt.Errorf("invalid user ID: %v", err)
+			log.Printf("Failed to parse user ID: %v", err)
+			return Validation("invalid user ID: %v", err)
 		}
 	}
-	fmt.Printf("Final user UUID: %s\n", userUUID)
+	log.Printf("Final user UUID: %s", userUUID)
 
 	// Get last session for user to determine next index
-	fmt.Printf("Fetching previous sessions for user %s\n", userUUID)
+	log.Printf("Fetching previous sessions for user %s", userUUID)
 	userSessions, err := s.store.GetUserWritingSessions(ctx, userUUID, false, 1, 0)
 	if err != nil {
-		fmt.Printf("Error getting user's last session: %v\n", err)
+		log.Printf("Error getting user's last session: %v", err)
 		return err
 	}
-	fmt.Printf("Found %d previous sessions\n", len(userSessions))
+	log.Printf("Found %d previous sessions", len(userSessions))
 
 	sessionIndex := 0
 	if len(userSessions) > 0 {
 		sessionIndex = userSessions[0].SessionIndexForUser + 1
 	}
-	fmt.Printf("New session will have index: %d\n", sessionIndex)
+	log.Printf("New session will have index: %d", sessionIndex)
 
-	fmt.Println("Creating new writing session object...")
+	log.Println("Creating new writing session object...")
 	writingSession := types.NewWritingSession(sessionUUID, userUUID, newWritingSessionRequest.Prompt, sessionIndex, newWritingSessionRequest.IsOnboarding)
-	fmt.Printf("Created new writing session: %+v\n", writingSession)
+	log.Printf("Created new writing session: %+v", writingSession)
 
-	fmt.Println("Attempting to save writing session to database...")
+	log.Println("Attempting to save writing session to database...")
 	if err := s.store.CreateWritingSession(ctx, writingSession); err != nil {
-		fmt.Printf("Error creating writing session: %v\n", err)
+		log.Printf("Error creating writing session: %v", err)
 		return err
 	}
-	fmt.Printf("Successfully created writing session %s in database\n", writingSession.ID)
+	log.Printf("Successfully created writing session %s in database", writingSession.ID)
 
-	fmt.Println("Preparing response...")
-	fmt.Printf("Returning writing session: %+v\n", writingSession)
+	log.Println("Preparing response...")
+	log.Printf("Returning writing session: %+v", writingSession)
 
 	return WriteJSON(w, http.StatusOK, writingSession)
 }
 
 func (s *APIServer) handleGetWritingSession(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	sessionID, err := getSessionID(r)
+	sessionUUID, err := pathWritingSessionID(r)
+	if err != nil {
+		return err
+	}
+
+	session, err := s.store.GetWritingSessionById(ctx, sessionUUID)
 	if err != nil {
 		return err
 	}
+	if err := authorizeUser(r, session.UserID); err != nil {
+		return err
+	}
+	// Old sessions keep their writing in the archive
+	if err := s.archive.Rehydrate(ctx, session); err != nil {
+		return err
+	}
 
-	sessionUUID, err := uuid.Parse(sessionID)
+	return WriteSelectedJSON(w, r, http.StatusOK, session, writingSessionFields)
+}
+
+// Leeway given to a client's timer running ahead of the server's, for the
+// latency of the start and end requests
+const clockDriftTolerance = 5 * time.Second
+
+// GET /writing-sessions/{id}/clock
+// The authoritative time left on the session's timer, counted from when the
+// server recorded its start. Clients resync their countdown with it, ended
+// sessions stop at their ending.
+func (s *APIServer) handleGetWritingSessionClock(w http.ResponseWriter, r *http.Request) error {
+	sessionUUID, err := pathWritingSessionID(r)
 	if err != nil {
-		return fmt.Errorf("invalid session ID format: %v", err)
+		return err
+	}
+
+	session, err := s.store.GetWritingSessionById(r.Context(), sessionUUID)
+	if err != nil {
+		return err
+	}
+	if err := authorizeUser(r, session.UserID); err != nil {
+		return err
+	}
+
+	now := s.store.Clock().Now().UTC()
+	until := now
+	if session.EndingTimestamp != nil && session.EndingTimestamp.Before(now) {
+		until = *session.EndingTimestamp
+	}
+	elapsed := int(until.Sub(session.StartingTimestamp).Seconds())
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	remaining := types.AnkySessionSeconds - elapsed
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return WriteJSON(w, http.StatusOK, types.WritingSessionClock{
+		SessionID:        session.ID,
+		StartedAt:        session.StartingTimestamp,
+		ServerTime:       now,
+		ElapsedSeconds:   elapsed,
+		RemainingSeconds: remaining,
+		AnkyAt:           session.StartingTimestamp.Add(types.AnkySessionSeconds * time.Second),
+		EndedAt:          session.EndingTimestamp,
+	})
+}
+
+// POST /writing-sessions/{id}/end
+// Closes a session started through /writing-session-started. The server
+// decides how long it lasted, how many words it has and the newen it earned;
+// the client only sends the writing and the thread it belongs to.
+func (s *APIServer) handleWritingSessionEnd(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	sessionUUID, err := pathWritingSessionID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(types.CreateWritingSessionEndRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if req.SessionID != uuid.Nil && req.SessionID != sessionUUID {
+		return Validation("session ID %s doesn't match the session being ended", req.SessionID)
 	}
 
 	session, err := s.store.GetWritingSessionById(ctx, sessionUUID)
 	if err != nil {
 		return err
 	}
+	if err := authorizeUser(r, session.UserID); err != nil {
+		return err
+	}
+	if session.EndingTimestamp != nil {
+		return Conflict("writing session %s already ended", sessionUUID)
+	}
+
+	// The client's clock may say the session ended later than now, never trust it past that
+	now := s.store.Clock().Now().UTC()
+	endedAt := req.EndingTimestamp.UTC()
+	if endedAt.IsZero() || endedAt.After(now) {
+		endedAt = now
+	}
+	if endedAt.Before(session.StartingTimestamp) {
+		return Validation("writing session can't end before it started")
+	}
+	timeSpent := int(endedAt.Sub(session.StartingTimestamp).Seconds())
+	// A client claiming more time than the server saw go by ran its timer
+	// ahead, GET /writing-sessions/{id}/clock has the time that counts
+	serverElapsed := int(now.Sub(session.StartingTimestamp).Seconds())
+	if req.TimeSpent > serverElapsed+int(clockDriftTolerance.Seconds()) {
+		return newHTTPError(http.StatusConflict, CodeClockMismatch,
+			"the session lasted %d seconds on the server clock, not %d", serverElapsed, req.TimeSpent)
+	}
+
+	session.EndingTimestamp = &endedAt
+	session.TimeSpent = &timeSpent
+	session.Writing = req.Text
+	session.WordsWritten = len(strings.Fields(req.Text))
+	session.IsOnboarding = session.IsOnboarding || req.IsOnboarding
+	if req.ParentAnkyID != "" {
+		parentAnkyID, err := uuid.Parse(req.ParentAnkyID)
+		if err != nil {
+			return Validation("invalid parent anky ID: %v", err)
+		}
+		session.ParentAnkyID = &parentAnkyID
+	}
+	if req.AnkyResponse != "" {
+		session.AnkyResponse = &req.AnkyResponse
+	}
+	// Without its keystrokes the session stays unverified
+	if req.KeystrokeLog != "" {
+		if err := services.ApplyKeystrokeLog(session, req.KeystrokeLog); err != nil {
+			return Validation("%v", err)
+		}
+	}
+	session.SetAnkyStatus()
+
+	newenService, err := services.NewNewenService(s.store)
+	if err != nil {
+		return fmt.Errorf("error creating newen service: %w", err)
+	}
+	// Unverified sessions, and those with pasted text or scripted keystrokes, earn nothing
+	session.NewenEarned = float64(newenService.CalculateNewenEarned(ctx, session.UserID.String(), services.EarnsNewen(session), endedAt))
+
+	// Granted first: should saving the session fail, ending it again is safe
+	// since a session is only ever rewarded once
+	if _, err := newenService.GrantWritingReward(ctx, session); err != nil {
+		return fmt.Errorf("error granting newen: %w", err)
+	}
+	if err := s.store.UpdateWritingSession(ctx, session); err != nil {
+		return fmt.Errorf("error ending writing session: %w", err)
+	}
+	services.RecordKeystrokeMetrics(ctx, s.store, session)
+	log.Printf("🏁 Writing session %s ended after %d seconds with %d words (anky: %t, verified: %t)", session.ID, *session.TimeSpent, session.WordsWritten, session.IsAnky, session.Verified())
 
 	return WriteJSON(w, http.StatusOK, session)
 }
+
+// POST /writing-sessions/sync
+// Takes a batch of sessions the writer finished offline, with the IDs and
+// vector clocks their device gave them, and says for each whether it was
+// accepted, already known (duplicate) or rejected.
+func (s *APIServer) handleSyncWritingSessions(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("missing authenticated user")
+	}
+
+	req := new(types.SyncWritingSessionsRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if len(req.Sessions) == 0 {
+		return Validation("no sessions to sync")
+	}
+	if len(req.Sessions) > services.MaxSyncBatch {
+		return Validation("at most %d sessions can be synced at once, got %d", services.MaxSyncBatch, len(req.Sessions))
+	}
+
+	results, err := services.NewWritingSyncService(s.store).SyncSessions(r.Context(), userID, req.Sessions)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+	})
+}
+
 func (s *APIServer) handleRawWritingSession(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println("=== Starting handleRawWritingSession endpoint ===")
-	fmt.Printf("🔍 Received %s request with headers: %+v\n", r.Method, r.Header)
+	log.Println("=== Starting handleRawWritingSession endpoint ===")
+	log.Printf("🔍 Received %s request with headers: %+v", r.Method, logging.RedactHeader(r.Header))
 
 	// Read and decode JSON request
 	var requestData struct {
 		WritingString string `json:"writingString"`
 	}
 
-	fmt.Println("👉 Attempting to decode request body...")
+	log.Println("👉 Attempting to decode request body...")
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		fmt.Printf("❌ Failed to decode request body: %v\n", err)
+		log.Printf("❌ Failed to decode request body: %v", err)
 		return err
 	}
 	defer r.Body.Close()
 
-	fmt.Printf("📝 Received writing string (first 50 chars): %s...\n", requestData.WritingString[:min(50, len(requestData.WritingString))])
-
-	// Split the writing string into lines
-	fmt.Println("✂️ Splitting writing string into lines...")
-	lines := strings.Split(requestData.WritingString, "\n")
-	fmt.Printf("📊 Found %d lines in writing string\n", len(lines))
+	log.Printf("📝 Received writing string: %s", logging.Content(requestData.WritingString))
 
-	if len(lines) < 4 {
-		fmt.Printf("❌ Invalid format: Not enough lines (got %d, need at least 4)\n", len(lines))
-		return fmt.Errorf("invalid writing session format: insufficient lines (got %d, need at least 4)", len(lines))
+	// Parse the writing session
+	log.Println("🔍 Parsing writing session...")
+	session, err := utils.ParseWritingSession(requestData.WritingString)
+	if err != nil {
+		log.Printf("❌ Failed to parse writing session: %v", err)
+		return Validation("%v", err)
 	}
+	userId := session.UserID
+	sessionId := session.SessionID
+	prompt := session.Prompt
+	startingTimestamp := session.Timestamp
 
-	// Extract metadata from first 4 lines
-	fmt.Println("🔍 Extracting metadata from first 4 lines...")
-	userId := strings.TrimSpace(lines[0])
-	sessionId := strings.TrimSpace(lines[1])
-	prompt := strings.TrimSpace(lines[2])
-	startingTimestamp := strings.TrimSpace(lines[3])
+	log.Printf("📋 Extracted metadata (format v%d):", session.FormatVersion)
+	log.Printf("👤 User ID: %s", userId)
+	log.Printf("🔑 Session ID: %s", sessionId)
+	log.Printf("💭 Prompt: %s", prompt)
+	log.Printf("⏰ Starting Timestamp: %s", startingTimestamp)
 
-	fmt.Println("📋 Extracted metadata:")
-	fmt.Printf("👤 User ID: %s\n", userId)
-	fmt.Printf("🔑 Session ID: %s\n", sessionId)
-	fmt.Printf("💭 Prompt: %s\n", prompt)
-	fmt.Printf("⏰ Starting Timestamp: %s\n", startingTimestamp)
-
-	// Get writing content (remaining lines)
-	writingContent := strings.Join(lines[4:], "\n")
-	fmt.Printf("📜 Writing content length: %d bytes\n", len(writingContent))
-	fmt.Printf("📖 Preview of writing content: %s...\n", writingContent[:min(100, len(writingContent))])
+	// The keystrokes after the metadata, in the format they were sent in
+	keyStrokeLines := make([]string, 0, len(session.KeyStrokes))
+	for _, keyStroke := range session.KeyStrokes {
+		keyStrokeLines = append(keyStrokeLines, utils.EncodeKeyStroke(session.FormatVersion, keyStroke))
+	}
+	writingContent := strings.Join(keyStrokeLines, "\n")
+	log.Printf("📜 Writing content length: %d bytes", len(writingContent))
 
-	// Create data directory structure if it doesn't exist
-	fmt.Println("📁 Setting up directory structure...")
 	userDir := fmt.Sprintf("data/writing_sessions/%s", userId)
-	if err := os.MkdirAll(userDir, 0755); err != nil {
-		fmt.Printf("❌ Failed to create directory structure: %v\n", err)
-		return err
-	}
-	fmt.Printf("✅ Created/verified directory: %s\n", userDir)
 
 	// Save individual writing session file
-	fmt.Println("💾 Saving individual writing session file...")
+	log.Println("💾 Saving individual writing session file...")
 	sessionFilePath := fmt.Sprintf("%s/%s.txt", userDir, sessionId)
-	if err := os.WriteFile(sessionFilePath, []byte(requestData.WritingString), 0644); err != nil {
-		fmt.Printf("❌ Failed to write session file: %v\n", err)
+	if err := utils.Files.WriteFile(sessionFilePath, []byte(requestData.WritingString), 0644); err != nil {
+		log.Printf("❌ Failed to write session file: %v", err)
 		return err
 	}
-	fmt.Printf("✅ Saved session file to: %s\n", sessionFilePath)
+	log.Printf("✅ Saved session file to: %s", sessionFilePath)
 
-	// Update all_writing_sessions.txt
-	fmt.Println("📝 Updating master sessions list...")
+	// Update all_writing_sessions.txt, one session ID per line
+	log.Println("📝 Updating master sessions list...")
 	allSessionsPath := fmt.Sprintf("%s/all_writing_sessions.txt", userDir)
-	f, err := os.OpenFile(allSessionsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("❌ Failed to open all_writing_sessions.txt: %v\n", err)
-		return err
-	}
-	defer f.Close()
-
-	// Add newline before new session ID if file is not empty
-	fileInfo, err := f.Stat()
-	if err != nil {
-		fmt.Printf("❌ Failed to get file info: %v\n", err)
-		return err
-	}
-
-	if fileInfo.Size() > 0 {
-		if _, err := f.WriteString("\n"); err != nil {
-			fmt.Printf("❌ Failed to write newline: %v\n", err)
-			return err
+	err = utils.Files.Update(allSessionsPath, 0644, func(existing []byte) ([]byte, error) {
+		if len(existing) > 0 {
+			existing = append(existing, '\n')
 		}
-	}
-
-	if _, err := f.WriteString(sessionId); err != nil {
-		fmt.Printf("❌ Failed to write session ID: %v\n", err)
+		return append(existing, sessionId...), nil
+	})
+	if err != nil {
+		log.Printf("❌ Failed to update all_writing_sessions.txt: %v", err)
 		return err
 	}
-	fmt.Println("✅ Successfully updated master sessions list")
+	log.Println("✅ Successfully updated master sessions list")
 
 	response := map[string]interface{}{
 		"userId":            userId,
@@ -1301,54 +1784,51 @@ func (s *APIServer) handleRawWritingSession(w http.ResponseWriter, r *http.Reque
 		"writingContent":    writingContent,
 	}
 
-	fmt.Println("🔄 Preparing response...")
-	fmt.Printf("📦 Response object: %+v\n", response)
+	log.Println("🔄 Preparing response...")
+	log.Printf("📦 Response object: %+v", logging.RedactMap(response))
 
 	err = WriteJSON(w, http.StatusOK, response)
 	if err != nil {
-		fmt.Printf("❌ Failed to write JSON response: %v\n", err)
+		log.Printf("❌ Failed to write JSON response: %v", err)
 		return err
 	}
 
-	fmt.Println("✨ Successfully completed handleRawWritingSession")
+	log.Println("✨ Successfully completed handleRawWritingSession")
 	// Get feedback from Anky about the writing session
 	err = WriteJSON(w, http.StatusOK, response)
 	if err != nil {
-		fmt.Printf("❌ Failed to write JSON response with feedback: %v\n", err)
+		log.Printf("❌ Failed to write JSON response with feedback: %v", err)
 		return err
 	}
 
-	// Parse the writing session
-	fmt.Println("🔍 Parsing writing session...")
-	session, err := utils.ParseWritingSession(writingContent)
-	if err != nil {
-		fmt.Printf("❌ Failed to parse writing session: %v\n", err)
-		return err
-	}
+	s.recordSessionFocus(r.Context(), session.SessionID, session.Focus)
+	s.recordSessionPaste(r.Context(), session.SessionID, session.Paste)
+	s.recordSessionSuspect(r.Context(), session.SessionID, session.Cheat)
 
 	// Create a slice to store the conversation
-	fmt.Println("💬 Creating conversation for reflection...")
+	log.Println("💬 Creating conversation for reflection...")
 	conversation := []string{
-		fmt.Sprintf("The user wrote for %d minutes. Here is what they wrote: %s",
+		fmt.Sprintf("The user wrote for %d minutes, and %s. Here is what they wrote: %s",
 			len(session.KeyStrokes)/60, // Rough estimate of minutes based on keystrokes
+			utils.DescribeFocus(session.Focus),
 			session.RawContent),
 	}
 
 	// Get reflection from Anky service
-	fmt.Println("🤖 Getting reflection from Anky service...")
+	log.Println("🤖 Getting reflection from Anky service...")
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
-		fmt.Printf("❌ Failed to create anky service: %v\n", err)
+		log.Printf("❌ Failed to create anky service: %v", err)
 		return err
 	}
 	reflection, err := ankyService.ReflectBackFromWritingSessionConversation(conversation, requestData.WritingString)
 	if err != nil {
-		fmt.Printf("❌ Failed to get reflection: %v\n", err)
+		log.Printf("❌ Failed to get reflection: %v", err)
 		return err
 	}
 
 	// Add reflection to response
-	fmt.Println("✍️ Adding reflection to response...")
+	log.Println("✍️ Adding reflection to response...")
 	response["reflection"] = reflection
 	return WriteJSON(w, http.StatusOK, "ok, but why?")
 }
@@ -1356,7 +1836,7 @@ func (s *APIServer) handleRawWritingSession(w http.ResponseWriter, r *http.Reque
 func (s *APIServer) handleGetUserWritingSessions(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	userID, err := utils.GetUserID(r)
+	userID, err := pathUserID(r)
 	if err != nil {
 		return err
 	}
@@ -1390,76 +1870,180 @@ func (s *APIServer) handleGetUserWritingSessions(w http.ResponseWriter, r *http.
 		return err
 	}
 
-	return WriteJSON(w, http.StatusOK, userSessions)
+	// Lists only carry the summary, the full writing is on /writing-sessions/{id}
+	for _, session := range userSessions {
+		session.Writing = ""
+	}
+
+	return WriteSelectedJSON(w, r, http.StatusOK, userSessions, writingSessionFields)
+}
+
+const (
+	maxWritingSearchLength  = 200
+	maxWritingSearchResults = 50
+)
+
+// GET /users/{userId}/writing-sessions/search?q=...&limit=20&offset=0
+// The user's sessions whose writing matches q, best match first, each with a
+// snippet of the matching passages between <mark> tags.
+func (s *APIServer) handleSearchUserWritingSessions(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	terms := strings.TrimSpace(r.URL.Query().Get("q"))
+	if terms == "" {
+		return Validation("missing search query q")
+	}
+	if len(terms) > maxWritingSearchLength {
+		return Validation("search query can be at most %d characters", maxWritingSearchLength)
+	}
+
+	limit := 20
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = min(parsedLimit, maxWritingSearchResults)
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	results, err := s.store.SearchUserWritingSessions(ctx, userID, terms, limit, offset)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		session := result.Session
+		// Archived writing is only in the archive, the snippet is cut from there
+		if session.Archived {
+			if err := s.archive.Rehydrate(ctx, session); err != nil {
+				log.Printf("⚠️ Could not restore writing session %s for its search snippet: %v", session.ID, err)
+			} else if result.Snippet, err = s.store.HighlightWriting(ctx, session.Writing, terms); err != nil {
+				log.Printf("⚠️ Could not highlight writing session %s: %v", session.ID, err)
+			}
+		}
+		// Like lists, results only carry the snippet and the summary
+		session.Writing = ""
+	}
+
+	return WriteJSON(w, http.StatusOK, results)
+}
+
+// pathWritingSessionID reads the {id} route variable of writing session routes.
+func pathWritingSessionID(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return uuid.Nil, Validation("invalid session ID: %v", err)
+	}
+	return id, nil
 }
 
 func getSessionID(r *http.Request) (string, error) {
 	sessionID := mux.Vars(r)["sessionId"]
 	if sessionID == "" {
-		return "", fmt.Errorf("no session ID provided")
+		return "", Validation("no session ID provided")
 	}
 	return sessionID, nil
 }
 
+// pathUserID reads the {userId} route variable.
+func pathUserID(r *http.Request) (uuid.UUID, error) {
+	id, err := utils.GetUserID(r)
+	if err != nil {
+		return uuid.Nil, Validation("invalid user ID: %v", err)
+	}
+	return id, nil
+}
+
+// pathAnkyID reads the {id} route variable of anky routes.
+func pathAnkyID(r *http.Request) (uuid.UUID, error) {
+	id, err := utils.GetAnkyID(r)
+	if err != nil {
+		return uuid.Nil, Validation("invalid anky ID: %v", err)
+	}
+	return id, nil
+}
+
 // ***************** ANKY ROUTES *****************
 
 func (s *APIServer) handleProcessUserOnboarding(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println("Starting handleProcessUserOnboarding...")
+	log.Println("Starting handleProcessUserOnboarding...")
 	ctx := r.Context()
 
-	fmt.Println("Attempting to get user ID from request...")
-	userID, err := utils.GetUserID(r)
+	log.Println("Attempting to get user ID from request...")
+	userID, err := pathUserID(r)
 	if err != nil {
-		fmt.Printf("Error getting user ID: %v\n", err)
+		log.Printf("Error getting user ID: %v", err)
 		return err
 	}
-	fmt.Printf("User ID obtained: %s\n", userID)
+	log.Printf("User ID obtained: %s", userID)
 
 	// Parse request body
-	fmt.Println("Decoding request body...")
+	log.Println("Decoding request body...")
 	var onboardingRequest struct {
 		UserWritings    []*types.WritingSession `json:"user_writings"`
 		AnkyReflections []string                `json:"anky_responses"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&onboardingRequest); err != nil {
-		fmt.Printf("Error decoding request body: %v\n", err)
-		return fmt.Errorf("error decoding request body: %v", err)
+		log.Printf("Error decoding request body: %v", err)
+		return Validation("error decoding request body: %v", err)
 	}
-	fmt.Printf("Decoded request body: %+v\n", onboardingRequest)
+	log.Printf("Decoded request body: %+v", onboardingRequest)
 
 	// Validate the lengths
-	fmt.Println("Validating lengths of user writings and anky reflections...")
+	log.Println("Validating lengths of user writings and anky reflections...")
 	if len(onboardingRequest.UserWritings) != len(onboardingRequest.AnkyReflections)+1 {
-		fmt.Println("Invalid number of writings and reflections")
-		return fmt.Errorf("invalid number of writings and reflections")
+		log.Println("Invalid number of writings and reflections")
+		return Validation("invalid number of writings and reflections")
 	}
-	fmt.Println("Validation successful")
+	log.Println("Validation successful")
 
-	fmt.Println("Creating Anky service...")
+	log.Println("Creating Anky service...")
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
-		fmt.Printf("Error creating anky service: %v\n", err)
-		return fmt.Errorf("error creating anky service: %v", err)
+		log.Printf("Error creating anky service: %v", err)
+		return fmt.Errorf("error creating anky service: %w", err)
 	}
-	fmt.Println("Anky service created successfully")
+	log.Println("Anky service created successfully")
 
-	fmt.Println("Processing onboarding conversation...")
+	log.Println("Processing onboarding conversation...")
 	response, err := ankyService.OnboardingConversation(ctx, userID, onboardingRequest.UserWritings, onboardingRequest.AnkyReflections)
 	if err != nil {
-		fmt.Printf("Error processing onboarding conversation: %v\n", err)
-		return fmt.Errorf("error processing onboarding conversation: %v", err)
+		log.Printf("Error processing onboarding conversation: %v", err)
+		return fmt.Errorf("error processing onboarding conversation: %w", err)
 	}
-	fmt.Printf("Onboarding conversation processed successfully, response: %s\n", response)
+	log.Printf("Onboarding conversation processed successfully, response: %s", logging.Content(response))
 
-	fmt.Println("Sending response...")
+	log.Println("Sending response...")
 	return WriteJSON(w, http.StatusOK, map[string]string{
 		"reflection": response,
 	})
 }
 
+// maxAnkysPageSize caps the limit of the Anky lists
+const maxAnkysPageSize = 100
+
+// GET /ankys?sort=recent|market_cap&limit=&offset=
+// Lists the caller's Ankys, everyone's for admins.
 func (s *APIServer) handleGetAnkys(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("missing authenticated user")
+	}
+	var owner *uuid.UUID
+	if !utils.HasScopes(authenticatedScopes(r), utils.ScopeAdmin) {
+		owner = &userID
+	}
 
 	// Get query parameters with defaults
 	limit := 20
@@ -1471,18 +2055,43 @@ func (s *APIServer) handleGetAnkys(w http.ResponseWriter, r *http.Request) error
 			limit = parsedLimit
 		}
 	}
+	if limit > maxAnkysPageSize {
+		limit = maxAnkysPageSize
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
 
-	ankys, err := s.store.GetAnkys(ctx, limit, offset)
+	var ankys []*types.Anky
+	var err error
+	switch sortBy := r.URL.Query().Get("sort"); sortBy {
+	case "", "recent":
+		if owner != nil {
+			ankys, err = s.store.GetAnkysByUserID(ctx, *owner, limit, offset)
+		} else {
+			ankys, err = s.store.GetAnkys(ctx, limit, offset)
+		}
+	case "market_cap":
+		ankys, err = s.store.GetAnkysByMarketCap(ctx, owner, limit, offset)
+	default:
+		return Validation("unknown sort: %s", sortBy)
+	}
 	if err != nil {
 		return err
 	}
+	if err := s.store.AttachAnkyImages(ctx, ankys...); err != nil {
+		return err
+	}
+	withholdAnkys(ankys)
 
-	return WriteJSON(w, http.StatusOK, ankys)
+	return WriteSelectedJSON(w, r, http.StatusOK, ankys, ankyFields)
 }
 
 func (s *APIServer) handleGetAnkyByID(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	ankyID, err := utils.GetAnkyID(r)
+	ankyID, err := pathAnkyID(r)
 	if err != nil {
 		return err
 	}
@@ -1491,14 +2100,28 @@ func (s *APIServer) handleGetAnkyByID(w http.ResponseWriter, r *http.Request) er
 	if err != nil {
 		return err
 	}
+	if err := authorizeUser(r, anky.UserID); err != nil {
+		return err
+	}
+	if err := s.store.AttachAnkyImages(ctx, anky); err != nil {
+		return err
+	}
+
+	return WriteSelectedJSON(w, r, http.StatusOK, anky.Withheld(), ankyFields)
+}
 
-	return WriteJSON(w, http.StatusOK, anky)
+// withholdAnkys replaces the time capsules and the Ankys held for review of
+// the list with what they may show.
+func withholdAnkys(ankys []*types.Anky) {
+	for i, anky := range ankys {
+		ankys[i] = anky.Withheld()
+	}
 }
 
 func (s *APIServer) handleGetAnkysByUserID(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	userID, err := utils.GetUserID(r)
+	userID, err := pathUserID(r)
 	if err != nil {
 		return err
 	}
@@ -1518,8 +2141,12 @@ func (s *APIServer) handleGetAnkysByUserID(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return err
 	}
+	if err := s.store.AttachAnkyImages(ctx, ankys...); err != nil {
+		return err
+	}
+	withholdAnkys(ankys)
 
-	return WriteJSON(w, http.StatusOK, ankys)
+	return WriteSelectedJSON(w, r, http.StatusOK, ankys, ankyFields)
 }
 
 func (s *APIServer) handleEditCast(w http.ResponseWriter, r *http.Request) error {
@@ -1530,19 +2157,31 @@ func (s *APIServer) handleEditCast(w http.ResponseWriter, r *http.Request) error
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&editCastRequest); err != nil {
-		fmt.Printf("Error decoding request body: %v\n", err)
-		return fmt.Errorf("error decoding request body: %v", err)
+		log.Printf("Error decoding request body: %v", err)
+		return Validation("error decoding request body: %v", err)
+	}
+	log.Printf("Decoded request body: %+v", editCastRequest)
+
+	// Only the owner of the FID may edit its casts
+	owner, err := s.store.GetUserByFID(ctx, editCastRequest.UserFid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Forbidden("you can only edit casts of your own fid")
+	}
+	if err != nil {
+		return err
+	}
+	if err := authorizeUser(r, owner.ID); err != nil {
+		return err
 	}
-	fmt.Printf("Decoded request body: %+v\n", editCastRequest)
 
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
-		return fmt.Errorf("error creating anky service: %v", err)
+		return fmt.Errorf("error creating anky service: %w", err)
 	}
 
 	response, err := ankyService.EditCast(ctx, editCastRequest.Text, editCastRequest.UserFid)
 	if err != nil {
-		return fmt.Errorf("error editing cast: %v", err)
+		return fmt.Errorf("error editing cast: %w", err)
 	}
 
 	return WriteJSON(w, http.StatusOK, map[string]string{
@@ -1554,49 +2193,70 @@ func (s *APIServer) handleSimplePrompt(w http.ResponseWriter, r *http.Request) e
 	ctx := r.Context()
 	var singlePromptRequest struct {
 		Prompt string `json:"prompt"`
+		Format string `json:"format"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&singlePromptRequest); err != nil {
-		return fmt.Errorf("error decoding request body: %v", err)
+		return Validation("error decoding request body: %v", err)
+	}
+	log.Printf("Decoded request body: %+v", singlePromptRequest)
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("missing authenticated user")
+	}
+	format, err := s.responseFormatFor(ctx, userID.String(), singlePromptRequest.Format)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Decoded request body: %+v\n", singlePromptRequest)
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
-		return fmt.Errorf("error creating anky service: %v", err)
+		return fmt.Errorf("error creating anky service: %w", err)
 	}
 
 	response, err := ankyService.SimplePrompt(ctx, singlePromptRequest.Prompt)
 	if err != nil {
-		return fmt.Errorf("error processing simple prompt: %v", err)
+		return fmt.Errorf("error processing simple prompt: %w", err)
 	}
 
 	return WriteJSON(w, http.StatusOK, map[string]string{
-		"response": response,
+		"response": utils.FormatAIResponse(response, format),
+		"format":   format,
 	})
 }
 
 func (s *APIServer) handleMessagesPrompt(w http.ResponseWriter, r *http.Request) error {
 	var messagesPromptRequest struct {
 		Messages []string `json:"messages"`
+		Format   string   `json:"format"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&messagesPromptRequest); err != nil {
-		return fmt.Errorf("error decoding request body: %v", err)
+		return Validation("error decoding request body: %v", err)
+	}
+	log.Printf("Decoded request body: %+v", messagesPromptRequest)
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("missing authenticated user")
+	}
+	format, err := s.responseFormatFor(r.Context(), userID.String(), messagesPromptRequest.Format)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Decoded request body: %+v\n", messagesPromptRequest)
 
 	ankyService, err := services.NewAnkyService(s.store)
 	if err != nil {
-		return fmt.Errorf("error creating anky service: %v", err)
+		return fmt.Errorf("error creating anky service: %w", err)
 	}
 
 	response, err := ankyService.MessagesPromptRequest(messagesPromptRequest.Messages)
 	if err != nil {
-		return fmt.Errorf("error processing messages prompt: %v", err)
+		return fmt.Errorf("error processing messages prompt: %w", err)
 	}
 
 	return WriteJSON(w, http.StatusOK, map[string]string{
-		"response": response,
+		"response": utils.FormatAIResponse(response, format),
+		"format":   format,
 	})
 }
 
@@ -1604,7 +2264,7 @@ func (s *APIServer) handleMessagesPrompt(w http.ResponseWriter, r *http.Request)
 
 func (s *APIServer) handleGetUserBadges(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	userID, err := utils.GetUserID(r)
+	userID, err := pathUserID(r)
 	if err != nil {
 		return err
 	}
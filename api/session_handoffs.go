@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+)
+
+var handoffCodePattern = regexp.MustCompile(`^[0-9]{6}$`)
+
+// Frames sessions aren't stored until they are submitted, so frames say
+// which FID the session was set up for.
+type framesHandoffRequest struct {
+	FID int `json:"fid"`
+}
+
+// App sessions are stored when they start, the server knows their user. Only
+// that user may hand them off.
+type mobileHandoffRequest struct{}
+
+type handoffCodeResponse struct {
+	Code      string    `json:"code"`
+	SessionID uuid.UUID `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type handoffRedeemRequest struct {
+	Code string `json:"code"`
+}
+
+// Frames write the redeemed session as the FID it was set up for.
+type framesHandoffRedeemResponse struct {
+	SessionID uuid.UUID `json:"session_id"`
+	FID       int       `json:"fid,omitempty"`
+	Prompt    string    `json:"prompt"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// The app writes the redeemed session as the user who started it.
+type mobileHandoffRedeemResponse struct {
+	SessionID uuid.UUID  `json:"session_id"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	Prompt    string     `json:"prompt"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// POST /sessions/{id}/handoff, /{frames|mobile}/sessions/{id}/handoff
+// Issues a six digit code another device can redeem, within
+// services.SessionHandoffTTL and once, to write the pending session there.
+// Frames send the fid the session was set up for; the app sends no body,
+// its sessions are stored. Requests without a client surface may do either.
+// Stored sessions are only handed off with a token of the user who writes
+// them; frames sessions, which aren't stored yet, need none.
+func (s *APIServer) handleCreateSessionHandoff(w http.ResponseWriter, r *http.Request) error {
+	sessionID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid session ID: %v", err)
+	}
+	surface, err := requestedClientSurface(r)
+	if err != nil {
+		return err
+	}
+	if _, ok := authenticatedUserID(r); !ok && surface == surfaceMobile {
+		return Unauthorized("missing authenticated user")
+	}
+
+	session, err := s.store.GetWritingSessionById(r.Context(), sessionID)
+	switch {
+	case err == nil:
+		if err := authorizeUser(r, session.UserID); err != nil {
+			return err
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+
+	var fid int
+	switch surface {
+	case surfaceFrames:
+		var req framesHandoffRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return Validation("error decoding request body: %v", err)
+		}
+		if req.FID <= 0 {
+			return Validation("fid is required")
+		}
+		fid = req.FID
+	case surfaceMobile:
+		// A fid means a frames client forgot to say so
+		var req mobileHandoffRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			return Validation("error decoding request body: %v", err)
+		}
+	default:
+		var req framesHandoffRequest
+		if err := decodeOptionalBody(r, &req); err != nil {
+			return err
+		}
+		fid = req.FID
+	}
+
+	handoff, err := services.NewSessionHandoffService(s.store).CreateHandoff(r.Context(), sessionID, fid)
+	switch {
+	case errors.Is(err, services.ErrSessionEnded), errors.Is(err, services.ErrHandoffCodesTaken):
+		return Conflict("%v", err)
+	case errors.Is(err, services.ErrHandoffNeedsFID) && surface == surfaceMobile:
+		return NotFound("writing session not found")
+	case errors.Is(err, services.ErrHandoffNeedsFID):
+		return Validation("%v", err)
+	case err != nil:
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, handoffCodeResponse{
+		Code:      handoff.Code,
+		SessionID: handoff.SessionID,
+		ExpiresAt: handoff.ExpiresAt,
+	})
+}
+
+// decodeOptionalBody decodes the body into v, an empty body leaves v as is.
+func decodeOptionalBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return Validation("error decoding request body: %v", err)
+	}
+	return nil
+}
+
+// POST /sessions/handoff/redeem, /{frames|mobile}/sessions/handoff/redeem
+// Claims the session of a handoff code, with its prompt. Codes are single
+// use: the device that redeems one writes the session. Frames get the FID
+// to write it as, the app the user; requests without a client surface get
+// the whole handoff.
+func (s *APIServer) handleRedeemSessionHandoff(w http.ResponseWriter, r *http.Request) error {
+	surface, err := negotiateClientSurface(w, r)
+	if err != nil {
+		return err
+	}
+	var req handoffRedeemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if !handoffCodePattern.MatchString(req.Code) {
+		return Validation("code must be six digits")
+	}
+
+	handoff, err := services.NewSessionHandoffService(s.store).RedeemHandoff(r.Context(), req.Code)
+	if errors.Is(err, storage.ErrHandoffNotFound) {
+		return NotFound("this code is invalid, expired or was already used")
+	}
+	if err != nil {
+		return err
+	}
+
+	switch surface {
+	case surfaceFrames:
+		return WriteJSON(w, http.StatusOK, framesHandoffRedeemResponse{
+			SessionID: handoff.SessionID,
+			FID:       handoff.FID,
+			Prompt:    handoff.Prompt,
+			ExpiresAt: handoff.ExpiresAt,
+		})
+	case surfaceMobile:
+		return WriteJSON(w, http.StatusOK, mobileHandoffRedeemResponse{
+			SessionID: handoff.SessionID,
+			UserID:    handoff.UserID,
+			Prompt:    handoff.Prompt,
+			ExpiresAt: handoff.ExpiresAt,
+		})
+	}
+	return WriteJSON(w, http.StatusOK, handoff)
+}
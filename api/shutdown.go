@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// backgroundCancelGrace is how long canceled jobs get to notice before the
+// database pool is closed under them
+const backgroundCancelGrace = 5 * time.Second
+
+// runInBackground runs job on the server's background context and keeps
+// Shutdown waiting until it returns.
+func (s *APIServer) runInBackground(job func(ctx context.Context)) {
+	s.backgroundJobs.Add(1)
+	s.backgroundJobsRunning.Add(1)
+	go func() {
+		defer s.backgroundJobs.Done()
+		defer s.backgroundJobsRunning.Add(-1)
+		job(s.background)
+	}()
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests and
+// background Anky processing to finish. Jobs still running when ctx is done
+// are canceled. The database pool is closed last.
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	log.Println("🛑 Draining in-flight requests...")
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		log.Printf("⚠️ Requests still running at the shutdown deadline: %v", err)
+	}
+
+	// Hijacked connections are not tracked by http.Server
+	s.writingSessions.closeAll()
+
+	jobsDone := make(chan struct{})
+	go func() {
+		s.backgroundJobs.Wait()
+		s.writingSessions.sockets.Wait()
+		close(jobsDone)
+	}()
+
+	select {
+	case <-jobsDone:
+		log.Println("✅ Background jobs finished")
+	case <-ctx.Done():
+		log.Println("⚠️ Canceling background jobs still running at the shutdown deadline")
+		s.stopBackground()
+		select {
+		case <-jobsDone:
+		case <-time.After(backgroundCancelGrace):
+			log.Println("⚠️ Some background jobs did not stop in time")
+		}
+	}
+	s.stopBackground()
+
+	s.store.Close()
+	log.Println("🔌 Database pool closed")
+	return err
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var (
+	incidentSeverities = map[string]bool{types.IncidentMinor: true, types.IncidentMajor: true, types.IncidentCritical: true}
+	incidentStatuses   = map[string]bool{
+		types.IncidentInvestigating: true,
+		types.IncidentIdentified:    true,
+		types.IncidentMonitoring:    true,
+		types.IncidentResolved:      true,
+	}
+)
+
+type statusResponse struct {
+	*services.StatusReport
+	// Anky processing jobs running on this instance
+	BackgroundJobs int64 `json:"background_jobs"`
+}
+
+// GET /status
+// Summarizes the health of the upstreams and the database, the features
+// switched off, the unresolved incidents and the queue depths, for the
+// public status page.
+func (s *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) error {
+	report := s.status.Report(r.Context())
+	w.Header().Set("Cache-Control", "public, max-age=15")
+	return WriteJSON(w, http.StatusOK, statusResponse{
+		StatusReport:   report,
+		BackgroundJobs: s.backgroundJobsRunning.Load(),
+	})
+}
+
+// GET /admin/incidents
+// Lists every incident, resolved ones included, most recent first.
+func (s *APIServer) handleGetIncidents(w http.ResponseWriter, r *http.Request) error {
+	limit := 50
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	incidents, err := s.store.GetIncidents(r.Context(), limit, offset)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, incidents)
+}
+
+type incidentRequest struct {
+	Title      *string   `json:"title"`
+	Message    *string   `json:"message"`
+	Severity   *string   `json:"severity"`
+	Status     *string   `json:"status"`
+	Components *[]string `json:"components"`
+}
+
+// apply copies the fields present in the request onto the incident.
+func (req *incidentRequest) apply(incident *types.Incident) error {
+	if req.Title != nil {
+		incident.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Message != nil {
+		incident.Message = strings.TrimSpace(*req.Message)
+	}
+	if req.Severity != nil {
+		incident.Severity = *req.Severity
+	}
+	if req.Status != nil {
+		incident.Status = *req.Status
+	}
+	if req.Components != nil {
+		incident.Components = *req.Components
+	}
+
+	if incident.Title == "" || len(incident.Title) > 255 {
+		return Validation("title is required and at most 255 characters")
+	}
+	if !incidentSeverities[incident.Severity] {
+		return Validation("severity must be minor, major or critical")
+	}
+	if !incidentStatuses[incident.Status] {
+		return Validation("status must be investigating, identified, monitoring or resolved")
+	}
+	return nil
+}
+
+// POST /admin/incidents
+// Posts an incident to the status page. Severity is required, status
+// defaults to investigating.
+func (s *APIServer) handleCreateIncident(w http.ResponseWriter, r *http.Request) error {
+	var req incidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	incident := &types.Incident{Status: types.IncidentInvestigating}
+	if err := req.apply(incident); err != nil {
+		return err
+	}
+	if err := s.store.CreateIncident(r.Context(), incident); err != nil {
+		return err
+	}
+	log.Printf("🚨 Incident %s opened (%s): %s", incident.ID, incident.Severity, incident.Title)
+
+	return WriteJSON(w, http.StatusCreated, incident)
+}
+
+// PATCH /admin/incidents/{id}
+// Updates an incident, e.g. to post progress or resolve it. Only the fields
+// present in the body change.
+func (s *APIServer) handleUpdateIncident(w http.ResponseWriter, r *http.Request) error {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid incident id: %v", err)
+	}
+
+	var req incidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+
+	incident, err := s.store.GetIncident(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := req.apply(incident); err != nil {
+		return err
+	}
+	if err := s.store.UpdateIncident(r.Context(), incident); err != nil {
+		return err
+	}
+	log.Printf("🚨 Incident %s is %s: %s", incident.ID, incident.Status, incident.Title)
+
+	return WriteJSON(w, http.StatusOK, incident)
+}
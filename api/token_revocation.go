@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/google/uuid"
+)
+
+// revokedUsers is consulted by JWTAuth for every token. Tokens of accounts
+// that were merged into another one are rejected, and so are those of
+// accounts scheduled for deletion until the deletion is cancelled. Nil until
+// the server runs.
+var revokedUsers *userRevocations
+
+// How long a user found not revoked isn't checked again, so merges and
+// deletions made by another instance are picked up within this delay
+const revocationRecheck = time.Minute
+
+type userRevocations struct {
+	store *storage.PostgresStore
+
+	mu         sync.Mutex
+	revoked    map[uuid.UUID]bool
+	deleting   map[uuid.UUID]bool
+	validUntil map[uuid.UUID]time.Time
+}
+
+func newUserRevocations(store *storage.PostgresStore) *userRevocations {
+	return &userRevocations{
+		store:      store,
+		revoked:    make(map[uuid.UUID]bool),
+		deleting:   make(map[uuid.UUID]bool),
+		validUntil: make(map[uuid.UUID]time.Time),
+	}
+}
+
+// check reports whether the user's tokens were revoked by a merge and
+// whether the account is scheduled for deletion. It fails open: a database
+// error lets the token through rather than locking every user out.
+func (u *userRevocations) check(ctx context.Context, userID uuid.UUID) (revoked bool, deleting bool) {
+	u.mu.Lock()
+	if u.revoked[userID] {
+		u.mu.Unlock()
+		return true, false
+	}
+	if until, ok := u.validUntil[userID]; ok && time.Now().Before(until) {
+		deleting := u.deleting[userID]
+		u.mu.Unlock()
+		return false, deleting
+	}
+	u.mu.Unlock()
+
+	merged, err := u.store.IsUserMerged(ctx, userID)
+	if err != nil {
+		log.Printf("⚠️ Could not check whether the token of user %s was revoked: %v", userID, err)
+		return false, false
+	}
+	scheduled, err := u.store.IsUserDeletionScheduled(ctx, userID)
+	if err != nil {
+		log.Printf("⚠️ Could not check whether user %s is being deleted: %v", userID, err)
+		scheduled = false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if merged {
+		u.revoked[userID] = true
+		delete(u.deleting, userID)
+		delete(u.validUntil, userID)
+		return true, false
+	}
+	if len(u.validUntil) > 100000 {
+		u.validUntil = make(map[uuid.UUID]time.Time)
+		u.deleting = make(map[uuid.UUID]bool)
+	}
+	u.setDeletingLocked(userID, scheduled)
+	u.validUntil[userID] = time.Now().Add(revocationRecheck)
+	return false, scheduled
+}
+
+func (u *userRevocations) revoke(userID uuid.UUID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.revoked[userID] = true
+	delete(u.deleting, userID)
+	delete(u.validUntil, userID)
+}
+
+// setDeleting records a deletion scheduled or cancelled on this instance, so
+// it applies to the user's next request and not a minute later.
+func (u *userRevocations) setDeleting(userID uuid.UUID, deleting bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.setDeletingLocked(userID, deleting)
+	u.validUntil[userID] = time.Now().Add(revocationRecheck)
+}
+
+func (u *userRevocations) setDeletingLocked(userID uuid.UUID, deleting bool) {
+	if deleting {
+		u.deleting[userID] = true
+	} else {
+		delete(u.deleting, userID)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/google/uuid"
+)
+
+func (s *APIServer) accountDeletions() *services.AccountDeletionService {
+	return services.NewAccountDeletionService(s.store, s.archive, s.exports)
+}
+
+// isUserDeleting reports whether the account is scheduled for deletion, in
+// which case it is hidden from public reads. It fails open like JWTAuth.
+func (s *APIServer) isUserDeleting(ctx context.Context, userID uuid.UUID) bool {
+	if revokedUsers != nil {
+		_, deleting := revokedUsers.check(ctx, userID)
+		return deleting
+	}
+	deleting, err := s.store.IsUserDeletionScheduled(ctx, userID)
+	if err != nil {
+		log.Printf("⚠️ Could not check whether user %s is being deleted: %v", userID, err)
+		return false
+	}
+	return deleting
+}
+
+// DELETE /users/{userId}?unpin_ipfs=true
+// Schedules the deletion of the account. During the grace period, which
+// DELETE /users/{userId}/deletion cancels, the account is treated as deleted:
+// its tokens only work to follow and cancel the deletion, and its profile,
+// Ankys and leaderboard entries are hidden, but nothing is removed. Then the
+// account is purged with its sessions, Ankys, badges, newen ledger and files,
+// and with unpin_ipfs its Ankys are unpinned from IPFS, which breaks their NFTs.
+func (s *APIServer) handleDeleteUser(w http.ResponseWriter, r *http.Request) error {
+	// RequireUser already checked the token belongs to the user being deleted
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	unpinIPFS := r.URL.Query().Get("unpin_ipfs") == "true"
+	deletion, err := s.accountDeletions().ScheduleDeletion(r.Context(), userID, unpinIPFS)
+	if err != nil {
+		return err
+	}
+	if revokedUsers != nil {
+		revokedUsers.setDeleting(userID, true)
+	}
+	return WriteJSON(w, http.StatusAccepted, deletion)
+}
+
+// GET /users/{userId}/deletion
+// Returns when the account will be purged, 404 when no deletion is scheduled.
+func (s *APIServer) handleGetUserDeletion(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	deletion, err := s.accountDeletions().UserDeletionStatus(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if deletion == nil {
+		return NotFound("no deletion is scheduled for this account")
+	}
+	return WriteJSON(w, http.StatusOK, deletion)
+}
+
+// DELETE /users/{userId}/deletion
+// Cancels the scheduled deletion of the account while its grace period lasts.
+func (s *APIServer) handleCancelUserDeletion(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	cancelled, err := s.accountDeletions().CancelDeletion(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if revokedUsers != nil {
+		revokedUsers.setDeleting(userID, false)
+	}
+	if !cancelled {
+		return NotFound("no deletion is scheduled for this account")
+	}
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id":   userID,
+		"cancelled": true,
+	})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+var exportTokenPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// GET /users/{userId}/export
+// Starts building a zip of everything the user wrote and answers 202 while it
+// builds; poll until it answers 200 with the download_url, which works
+// without a token until the export expires.
+func (s *APIServer) handleExportUserData(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	export, created, err := s.exports.RequestExport(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if created {
+		s.runInBackground(func(ctx context.Context) {
+			s.exports.BuildExport(ctx, export)
+		})
+	}
+
+	if export.DownloadToken == "" {
+		return WriteJSON(w, http.StatusAccepted, export)
+	}
+	return WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"export":       export,
+		"download_url": "/exports/" + export.DownloadToken,
+	})
+}
+
+// GET /exports/{token}
+// Downloads a ready export. The token is the only credential, so it is long
+// and the export expires.
+func (s *APIServer) handleDownloadUserExport(w http.ResponseWriter, r *http.Request) error {
+	token := mux.Vars(r)["token"]
+	if !exportTokenPattern.MatchString(token) {
+		return NotFound("export not found or expired")
+	}
+
+	export, data, err := s.exports.OpenExport(r.Context(), token)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="anky-export-%s.zip"`, export.CreatedAt.UTC().Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The public API lives under /v1. The same routes are still served without
+// the prefix as legacy aliases until they are sunset.
+const (
+	currentAPIVersion = 1
+	apiVersionPrefix  = "/v1"
+)
+
+var supportedAPIVersions = map[int]bool{1: true}
+
+// unversionedRoutes are operational endpoints that are never versioned
+var unversionedRoutes = map[string]bool{
+	"/debug/vars": true,
+	"/metrics":    true,
+}
+
+// Clients may also ask for a version with "Accept: application/vnd.anky.v1+json"
+var versionedMediaType = regexp.MustCompile(`application/vnd\.anky\.v(\d+)\+json`)
+
+// routeDeprecation describes when a route was deprecated, when it goes away
+// and what replaces it.
+type routeDeprecation struct {
+	DeprecatedAt time.Time
+	Sunset       time.Time
+	Successor    string
+}
+
+// The unversioned aliases were deprecated when /v1 was introduced.
+// LEGACY_ROUTES_SUNSET (YYYY-MM-DD) moves their removal date.
+var (
+	legacyRoutesDeprecatedAt = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	legacyRoutesSunset       = envDate("LEGACY_ROUTES_SUNSET", time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC))
+)
+
+// deprecatedRoutes lists routes slated for removal in every version, keyed
+// by the mux path template without the version prefix.
+var deprecatedRoutes = map[string]routeDeprecation{
+	"/privy-users/${id}": {
+		DeprecatedAt: legacyRoutesDeprecatedAt,
+		Sunset:       legacyRoutesSunset,
+		Successor:    "/user/register-privy-user",
+	},
+	"/framesgiving/fetch-anky-metadata-status": {
+		DeprecatedAt: time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC),
+		Sunset:       legacyRoutesSunset,
+		Successor:    "/ankys/{id}/status",
+	},
+}
+
+// APIVersioning negotiates the API version of each request and emits the
+// Deprecation, Sunset and Link headers of routes slated for removal.
+func APIVersioning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		template, versioned := unversionedPathTemplate(r)
+
+		version, err := requestedAPIVersion(r, versioned)
+		if err != nil {
+			WriteJSON(w, http.StatusNotAcceptable, ApiError{Error: err.Error(), Code: CodeNotAcceptable})
+			return
+		}
+		w.Header().Set("API-Version", strconv.Itoa(version))
+
+		deprecation, deprecated := deprecatedRoutes[template]
+		if !versioned && template != "" && !unversionedRoutes[template] && !deprecated {
+			deprecation = routeDeprecation{
+				DeprecatedAt: legacyRoutesDeprecatedAt,
+				Sunset:       legacyRoutesSunset,
+				Successor:    apiVersionPrefix + r.URL.Path,
+			}
+			deprecated = true
+		}
+		if deprecated {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecation.DeprecatedAt.Unix()))
+			w.Header().Set("Sunset", deprecation.Sunset.Format(http.TimeFormat))
+			if deprecation.Successor != "" {
+				successor := deprecation.Successor
+				if versioned && !strings.HasPrefix(successor, apiVersionPrefix) {
+					successor = apiVersionPrefix + successor
+				}
+				w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+			}
+			log.Printf("[Versioning] Deprecated route %s %s called, sunset %s", r.Method, r.URL.Path, deprecation.Sunset.Format("2006-01-02"))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestedAPIVersion reads the version from the path prefix, the
+// Accept-Version header or a versioned Accept media type, in that order.
+// Unversioned requests without any hint get the current version.
+func requestedAPIVersion(r *http.Request, versioned bool) (int, error) {
+	requested := 0
+	if header := r.Header.Get("Accept-Version"); header != "" {
+		v, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(header), "v"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid Accept-Version header: %s", header)
+		}
+		requested = v
+	} else if match := versionedMediaType.FindStringSubmatch(r.Header.Get("Accept")); match != nil {
+		requested, _ = strconv.Atoi(match[1])
+	}
+
+	if versioned {
+		if requested != 0 && requested != currentAPIVersion {
+			return 0, fmt.Errorf("requested API version %d does not match the %s path", requested, apiVersionPrefix)
+		}
+		return currentAPIVersion, nil
+	}
+	if requested == 0 {
+		return currentAPIVersion, nil
+	}
+	if !supportedAPIVersions[requested] {
+		return 0, fmt.Errorf("unsupported API version %d, supported: %d", requested, currentAPIVersion)
+	}
+	return requested, nil
+}
+
+// unversionedPathTemplate returns the matched route's path template without
+// the version prefix, and whether the request came through the prefix.
+// Routes pinning the client surface share the template of the plain route.
+func unversionedPathTemplate(r *http.Request) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "", false
+	}
+	versioned := strings.HasPrefix(template, apiVersionPrefix+"/")
+	if versioned {
+		template = strings.TrimPrefix(template, apiVersionPrefix)
+	}
+	return strings.TrimPrefix(template, clientSurfacePathSegment), versioned
+}
+
+func envDate(key string, fallback time.Time) time.Time {
+	if value, err := time.Parse("2006-01-02", os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
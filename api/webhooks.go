@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// POST /webhooks
+// Registers a URL to call when the user's Ankys reach a pipeline status,
+// instead of polling /framesgiving/fetch-anky-metadata-status:
+//
+//	{"url": "https://...", "events": ["reflection_completed", "image_generated", "completed"]}
+//
+// Every event is sent when none are given. Admins can add "all_ankys": true
+// to hear of every Anky, frames Ankys included. The response holds the
+// secret the callbacks are signed with, in the X-Anky-Signature header; it
+// isn't shown again.
+func (s *APIServer) handleRegisterWebhook(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("no user ID in context")
+	}
+	var req struct {
+		URL      string   `json:"url"`
+		Events   []string `json:"events"`
+		AllAnkys bool     `json:"all_ankys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return Validation("error decoding request body: %v", err)
+	}
+	if req.AllAnkys && !utils.HasScopes(authenticatedScopes(r), utils.ScopeAdmin) {
+		return Forbidden("only admins can register webhooks for every anky")
+	}
+
+	webhook, err := services.NewWebhookService(s.store).Register(r.Context(), userID, req.URL, req.Events, req.AllAnkys)
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhookURL), errors.Is(err, services.ErrUnknownWebhookEvent):
+		return Validation("%v", err)
+	case errors.Is(err, services.ErrTooManyWebhooks):
+		return Conflict("%v", err)
+	case err != nil:
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, webhook)
+}
+
+// GET /webhooks
+// The user's webhooks with how their last delivery went, without secrets.
+func (s *APIServer) handleGetWebhooks(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("no user ID in context")
+	}
+
+	webhooks, err := s.store.GetWebhooks(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return WriteJSON(w, http.StatusOK, webhooks)
+}
+
+// DELETE /webhooks/{id}
+// Stops the callbacks of one of the user's webhooks.
+func (s *APIServer) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("no user ID in context")
+	}
+	webhookID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return Validation("invalid webhook ID: %v", err)
+	}
+
+	deleted, err := s.store.DeleteWebhook(r.Context(), userID, webhookID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return NotFound("webhook not found")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,614 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 64 << 10
+	wsSendBuffer     = 32
+
+	// Partial writing is copied to the database at most this often, the
+	// keystrokes themselves are appended to disk as they arrive
+	liveProgressInterval = 30 * time.Second
+	liveSessionsDir      = "data/writing_sessions/live"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Same policy as corsMiddleware, any origin may connect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsClientMessage is what the mobile client sends during a session. The
+// session is written as the authenticated user, a user_id sent in start must
+// be theirs.
+//
+//	{"type": "start", "user_id": "...", "prompt": "...", "starting_timestamp": "..."}
+//	{"type": "keystrokes", "data": "h 0.120\ni 0.085"}
+//	{"type": "end"}
+//
+// Keystrokes use the line format of the session long string.
+type wsClientMessage struct {
+	Type              string `json:"type"`
+	UserID            string `json:"user_id,omitempty"`
+	Prompt            string `json:"prompt,omitempty"`
+	StartingTimestamp string `json:"starting_timestamp,omitempty"`
+	Data              string `json:"data,omitempty"`
+}
+
+// wsServerMessage is what the server sends back: "progress" after every batch
+// of keystrokes (and on connect, so clients know where to resume), "anky_status"
+// as the pipeline moves along, and "error".
+type wsServerMessage struct {
+	Type       string      `json:"type"`
+	SessionID  string      `json:"session_id"`
+	Keystrokes *int        `json:"keystrokes,omitempty"`
+	Ended      bool        `json:"ended,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Detail     string      `json:"detail,omitempty"`
+	Anky       *PublicAnky `json:"anky,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// WritingSessionHub keeps track of the sockets open for each writing session
+// and forwards the status updates of the Anky pipeline to them.
+type WritingSessionHub struct {
+	server *APIServer
+
+	mu       sync.Mutex
+	sessions map[string]*liveSession
+	closed   bool
+
+	updates chan services.AnkyStatusUpdate
+	// One per open socket, so Shutdown can wait for their progress to be saved
+	sockets sync.WaitGroup
+}
+
+// liveSession is a writing session that is being streamed by at least one socket.
+type liveSession struct {
+	id      string
+	clients map[*wsClient]bool
+
+	// Guards the session file and the progress counters
+	mu sync.Mutex
+	// User in the header of the session file, empty until it is started
+	owner        string
+	keystrokes   int
+	ended        bool
+	loaded       bool
+	untracked    bool
+	lastProgress time.Time
+}
+
+type wsClient struct {
+	conn    *websocket.Conn
+	session *liveSession
+	send    chan wsServerMessage
+	// The authenticated user the socket was opened by
+	userID string
+}
+
+func newWritingSessionHub(server *APIServer) *WritingSessionHub {
+	h := &WritingSessionHub{
+		server:   server,
+		sessions: make(map[string]*liveSession),
+		updates:  make(chan services.AnkyStatusUpdate, 256),
+	}
+	services.SubscribeAnkyStatus(h.publishStatus)
+	go h.forwardStatusUpdates()
+	return h
+}
+
+func (h *WritingSessionHub) register(sessionID string, userID string, conn *websocket.Conn) (*wsClient, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, fmt.Errorf("server is shutting down")
+	}
+	h.sockets.Add(1)
+
+	session, ok := h.sessions[sessionID]
+	if !ok {
+		session = &liveSession{id: sessionID, clients: make(map[*wsClient]bool)}
+		h.sessions[sessionID] = session
+	}
+	client := &wsClient{conn: conn, session: session, send: make(chan wsServerMessage, wsSendBuffer), userID: userID}
+	session.clients[client] = true
+	return client, nil
+}
+
+func (h *WritingSessionHub) unregister(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	session := client.session
+	if !session.clients[client] {
+		return
+	}
+	delete(session.clients, client)
+	close(client.send)
+	if len(session.clients) == 0 {
+		delete(h.sessions, session.id)
+	}
+}
+
+// broadcast queues the message for every socket of the session. Sockets that
+// can't keep up are dropped instead of stalling the others.
+func (h *WritingSessionHub) broadcast(sessionID string, message wsServerMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	session, ok := h.sessions[sessionID]
+	if !ok {
+		return
+	}
+	for client := range session.clients {
+		select {
+		case client.send <- message:
+		default:
+			log.Printf("⚠️ Dropping slow socket of writing session %s", sessionID)
+			delete(session.clients, client)
+			close(client.send)
+		}
+	}
+	if len(session.clients) == 0 {
+		delete(h.sessions, sessionID)
+	}
+}
+
+// queue sends a message to one socket only.
+func (h *WritingSessionHub) queue(client *wsClient, message wsServerMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !client.session.clients[client] {
+		return
+	}
+	select {
+	case client.send <- message:
+	default:
+		log.Printf("⚠️ Send buffer full for writing session %s, dropping %s", client.session.id, message.Type)
+	}
+}
+
+// closeAll tells every socket to close and refuses new ones.
+func (h *WritingSessionHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sessionID, session := range h.sessions {
+		for client := range session.clients {
+			delete(session.clients, client)
+			close(client.send)
+		}
+		delete(h.sessions, sessionID)
+	}
+}
+
+func (h *WritingSessionHub) hasClients(sessionID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.sessions[sessionID]
+	return ok
+}
+
+// publishStatus is called from the pipeline's goroutine, so it only queues the update.
+func (h *WritingSessionHub) publishStatus(update services.AnkyStatusUpdate) {
+	select {
+	case h.updates <- update:
+	default:
+		log.Printf("⚠️ Status update queue full, dropping %s for session %s", update.Status, update.SessionID)
+	}
+}
+
+// forwardStatusUpdates sends the pipeline's status updates to the sockets
+// of their session, one at a time so they arrive in order.
+func (h *WritingSessionHub) forwardStatusUpdates() {
+	for update := range h.updates {
+		if !h.hasClients(update.SessionID) {
+			continue
+		}
+		message := wsServerMessage{
+			Type:      "anky_status",
+			SessionID: update.SessionID,
+			Status:    update.Status,
+			Detail:    update.Detail,
+		}
+		if update.Status == "completed" || update.Status == "pending_to_cast" || update.Status == "revealed" {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if publicAnky, _, err := h.server.findPublicAnky(ctx, update.SessionID); err == nil {
+				message.Anky = publicAnky
+			}
+			cancel()
+		}
+		h.broadcast(update.SessionID, message)
+	}
+}
+
+// GET /ws/writing-session/{sessionId}
+// Streams the keystrokes of a session while it is being written and pushes
+// the status of the Anky created from it, replacing the polling of
+// /framesgiving/fetch-anky-metadata-status.
+func (s *APIServer) handleWritingSessionSocket(w http.ResponseWriter, r *http.Request) error {
+	sessionUUID, err := uuid.Parse(mux.Vars(r)["sessionId"])
+	if err != nil {
+		return Validation("invalid session ID: %v", err)
+	}
+	sessionID := sessionUUID.String()
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		return Unauthorized("missing authenticated user")
+	}
+	// Checked before upgrading, so nobody else's status stream is sent
+	if err := s.authorizeLiveSession(r, sessionUUID); err != nil {
+		return err
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered the request
+		log.Printf("❌ Error upgrading writing session %s to a websocket: %v", sessionID, err)
+		return nil
+	}
+	log.Printf("🔌 Socket opened for writing session %s", sessionID)
+
+	client, err := s.writingSessions.register(sessionID, userID.String(), conn)
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, err.Error()))
+		conn.Close()
+		return nil
+	}
+	go client.writePump()
+
+	if err := client.session.load(); err != nil {
+		log.Printf("❌ Error loading live writing session %s: %v", sessionID, err)
+	}
+	client.session.mu.Lock()
+	progress := client.session.progressMessage()
+	client.session.mu.Unlock()
+	s.writingSessions.queue(client, progress)
+	if status := s.currentAnkyStatus(r.Context(), sessionID); status != nil {
+		s.writingSessions.queue(client, *status)
+	}
+
+	s.readPump(client)
+	log.Printf("🔌 Socket closed for writing session %s", sessionID)
+	return nil
+}
+
+// authorizeLiveSession checks the caller owns the session: the user of its
+// database record, or of its file for sessions only kept on disk. Sessions
+// that don't exist yet are the caller's to start.
+func (s *APIServer) authorizeLiveSession(r *http.Request, sessionID uuid.UUID) error {
+	session, err := s.store.GetWritingSessionById(r.Context(), sessionID)
+	if err == nil {
+		return authorizeUser(r, session.UserID)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	content, err := utils.Files.ReadFile(filepath.Join(liveSessionsDir, sessionID.String()+".txt"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	header, _, err := utils.ParseSessionHeader(string(content))
+	if err != nil {
+		return err
+	}
+	owner, err := uuid.Parse(header.UserID)
+	if err != nil {
+		return Forbidden("writing session %s belongs to another user", sessionID)
+	}
+	return authorizeUser(r, owner)
+}
+
+// readPump handles the client's messages until the socket closes.
+func (s *APIServer) readPump(client *wsClient) {
+	defer func() {
+		s.writingSessions.unregister(client)
+		client.conn.Close()
+		s.saveLiveProgress(client.session, true)
+		s.writingSessions.sockets.Done()
+	}()
+
+	client.conn.SetReadLimit(wsMaxMessageSize)
+	client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var message wsClientMessage
+		if err := client.conn.ReadJSON(&message); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("⚠️ Unexpected close of writing session %s: %v", client.session.id, err)
+			}
+			return
+		}
+
+		session := client.session
+		var err error
+		switch message.Type {
+		case "start":
+			err = session.start(client.userID, message)
+		case "keystrokes":
+			err = session.appendKeystrokes(client.userID, message.Data)
+		case "end":
+			err = session.end()
+		default:
+			err = fmt.Errorf("unknown message type %q", message.Type)
+		}
+		if err != nil {
+			s.writingSessions.queue(client, wsServerMessage{Type: "error", SessionID: session.id, Error: err.Error()})
+			continue
+		}
+
+		session.mu.Lock()
+		progress := session.progressMessage()
+		session.mu.Unlock()
+		s.writingSessions.broadcast(session.id, progress)
+		s.saveLiveProgress(session, message.Type == "end")
+	}
+}
+
+// writePump sends queued messages and keeps the connection alive with pings.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// currentAnkyStatus returns the status of the session's Anky, if it has one,
+// so clients that reconnect don't miss the updates sent while they were away.
+func (s *APIServer) currentAnkyStatus(ctx context.Context, sessionID string) *wsServerMessage {
+	if sessionUUID, err := uuid.Parse(sessionID); err == nil {
+		if anky, err := s.store.GetAnkyByWritingSessionID(ctx, sessionUUID); err == nil {
+			message := &wsServerMessage{Type: "anky_status", SessionID: sessionID, Status: anky.Status}
+			if anky.Status == "completed" || anky.Status == "pending_to_cast" {
+				if err := s.store.AttachAnkyImages(ctx, anky); err != nil {
+					log.Printf("⚠️ Could not load image collection of anky %s: %v", anky.ID, err)
+				}
+				message.Anky = newPublicAnky(anky)
+			}
+			return message
+		}
+	}
+
+	// Frames sessions only exist as files
+	if _, err := services.ReadFramesAnkyMetadata(sessionID); err != nil {
+		return nil
+	}
+	status, err := framesSessionStatus(sessionID)
+	if err != nil {
+		return nil
+	}
+	message := &wsServerMessage{Type: "anky_status", SessionID: sessionID}
+	message.Status, _ = status["status"].(string)
+	if message.Status == "completed" {
+		if publicAnky, err := readFramesPublicAnky(sessionID); err == nil {
+			message.Anky = publicAnky
+		}
+	}
+	return message
+}
+
+// saveLiveProgress copies the writing so far to the session's database record.
+// Sessions that were never registered through /writing-session-started are
+// only kept on disk.
+func (s *APIServer) saveLiveProgress(session *liveSession, force bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.untracked || session.keystrokes == 0 {
+		return
+	}
+	if !force && time.Since(session.lastProgress) < liveProgressInterval {
+		return
+	}
+	session.lastProgress = time.Now()
+
+	content, err := utils.Files.ReadFile(session.path())
+	if err != nil {
+		log.Printf("❌ Error reading live writing session %s: %v", session.id, err)
+		return
+	}
+	parsed, err := utils.ParseWritingSession(string(content))
+	if err != nil {
+		log.Printf("❌ Error parsing live writing session %s: %v", session.id, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	owner, err := uuid.Parse(parsed.UserID)
+	if err != nil {
+		session.untracked = true
+		return
+	}
+	err = s.store.UpdateWritingSessionProgress(ctx, uuid.MustParse(session.id), owner, parsed.RawContent, len(strings.Fields(parsed.RawContent)), int(parsed.Duration().Seconds()))
+	if err != nil {
+		log.Printf("⚠️ Keeping writing session %s on disk only: %v", session.id, err)
+		session.untracked = true
+		return
+	}
+	s.recordSessionPaste(ctx, session.id, parsed.Paste)
+	s.recordSessionSuspect(ctx, session.id, parsed.Cheat)
+}
+
+func (l *liveSession) path() string {
+	return filepath.Join(liveSessionsDir, l.id+".txt")
+}
+
+// load counts the keystrokes already on disk, so a client that reconnects
+// knows which ones to send again.
+func (l *liveSession) load() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.loaded {
+		return nil
+	}
+	content, err := utils.Files.ReadFile(l.path())
+	if os.IsNotExist(err) {
+		l.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	header, lines, err := utils.ParseSessionHeader(string(content))
+	if err != nil {
+		return err
+	}
+	l.owner = header.UserID
+	for _, line := range lines {
+		if line != "" {
+			l.keystrokes++
+		}
+	}
+	l.loaded = true
+	return nil
+}
+
+// start writes the header of the session long string, as the user the
+// socket was authenticated as.
+func (l *liveSession) start(userID string, message wsClientMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if message.StartingTimestamp == "" {
+		return fmt.Errorf("start needs starting_timestamp")
+	}
+	if message.UserID != "" && message.UserID != userID {
+		return fmt.Errorf("start can only be sent as the authenticated user")
+	}
+
+	existing, err := utils.Files.ReadFile(l.path())
+	if err == nil {
+		// Reconnecting, the header is already there
+		if header, _, err := utils.ParseSessionHeader(string(existing)); err != nil || header.UserID != userID {
+			return fmt.Errorf("session %s belongs to another user", l.id)
+		}
+		l.owner = userID
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Keystrokes come in as they are typed, before the session has an end,
+	// so live sessions are written in the legacy format
+	header := &utils.SessionHeader{
+		FormatVersion: utils.SessionFormatLegacy,
+		UserID:        userID,
+		SessionID:     l.id,
+		Prompt:        message.Prompt,
+		Timestamp:     message.StartingTimestamp,
+	}
+	if err := utils.Files.WriteFile(l.path(), []byte(header.Encode()), 0644); err != nil {
+		return err
+	}
+	l.owner = userID
+	return nil
+}
+
+// appendKeystrokes appends a batch of "<key> <delay>" lines to the session
+// file, only for the user who started it.
+func (l *liveSession) appendKeystrokes(userID string, data string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ended {
+		return fmt.Errorf("session %s already ended", l.id)
+	}
+	if l.owner == "" {
+		return fmt.Errorf("send start before the first keystrokes")
+	}
+	if l.owner != userID {
+		return fmt.Errorf("session %s belongs to another user", l.id)
+	}
+	data = strings.TrimSuffix(data, "\n")
+	if data == "" {
+		return nil
+	}
+
+	err := utils.Files.Append(l.path(), []byte(data+"\n"))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("send start before the first keystrokes")
+	}
+	if err != nil {
+		return fmt.Errorf("error saving keystrokes: %w", err)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		if line != "" {
+			l.keystrokes++
+		}
+	}
+	return nil
+}
+
+func (l *liveSession) end() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := utils.Files.Stat(l.path()); err != nil {
+		return fmt.Errorf("session %s was never started", l.id)
+	}
+	l.ended = true
+	return nil
+}
+
+// progressMessage must be called with l.mu held.
+func (l *liveSession) progressMessage() wsServerMessage {
+	keystrokes := l.keystrokes
+	return wsServerMessage{Type: "progress", SessionID: l.id, Keystrokes: &keystrokes, Ended: l.ended}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+)
+
+// GET /writing-sessions/{id}/replay?format=binary
+// The keystroke timeline of the session with how it was typed: the speed
+// over time, the pauses and the longest one, for the app to animate its
+// playback. ?format=binary serves only the timeline, packed and gzipped as
+// utils.EncodeReplayBinary describes. Sessions only have a replay when their
+// keystrokes were submitted.
+func (s *APIServer) handleGetWritingSessionReplay(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	sessionUUID, err := pathWritingSessionID(r)
+	if err != nil {
+		return err
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "binary" {
+		return Validation("format must be json or binary")
+	}
+
+	session, err := s.store.GetWritingSessionById(ctx, sessionUUID)
+	if err != nil {
+		return err
+	}
+	if err := authorizeUser(r, session.UserID); err != nil {
+		return err
+	}
+
+	// Frames sessions are filed under the writer's FID
+	fid := 0
+	if session.UserID != types.AnonymousUserID {
+		if user, err := s.store.GetUserByID(ctx, session.UserID); err == nil {
+			fid = user.FID
+		}
+	}
+	raw, err := services.ReadRawWritingSession(session.ID, session.UserID, fid)
+	if errors.Is(err, os.ErrNotExist) {
+		return NotFound("no keystrokes were recorded for writing session %s", session.ID)
+	}
+	if err != nil {
+		return err
+	}
+	parsed, err := utils.ParseWritingSession(raw)
+	if err != nil {
+		return err
+	}
+	timeline := utils.NormalizeReplay(parsed.KeyStrokes)
+
+	if format == "binary" {
+		data, err := utils.EncodeReplayBinary(timeline)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", utils.ReplayBinaryContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(data)
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, types.WritingSessionReplay{
+		SessionID:  session.ID,
+		Prompt:     session.Prompt,
+		StartedAt:  session.StartingTimestamp,
+		Keystrokes: timeline,
+		Stats:      utils.ComputeReplayStats(timeline),
+	})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/services"
+)
+
+// GET /users/{userId}/year-in-review?year=2024&refresh=true
+// Aggregate stats plus a narrative of the user's year, rendered on first
+// request and cached afterwards. refresh re-renders it at most once an hour.
+func (s *APIServer) handleGetYearInReview(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	year, err := yearInReviewYear(r)
+	if err != nil {
+		return err
+	}
+	refresh := r.URL.Query().Get("refresh") == "true"
+
+	review, err := services.NewYearInReviewService(s.store, s.archive).GetYearInReview(r.Context(), userID, year, refresh)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, review)
+}
+
+// POST /users/{userId}/year-in-review/mint?year=2024
+// Mints the recap as a special Anky. Only the owner of the recap can mint it.
+func (s *APIServer) handleMintYearInReview(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathUserID(r)
+	if err != nil {
+		return err
+	}
+
+	authUserID, ok := authenticatedUserID(r)
+	if !ok || authUserID != userID {
+		return Forbidden("you can only mint your own year in review")
+	}
+
+	year, err := yearInReviewYear(r)
+	if err != nil {
+		return err
+	}
+
+	service := services.NewYearInReviewService(s.store, s.archive)
+	anky, minted, err := service.MintYearInReview(r.Context(), userID, year)
+	if err != nil {
+		return err
+	}
+	if minted {
+		s.runInBackground(func(ctx context.Context) {
+			service.GenerateYearInReviewArtwork(ctx, anky)
+		})
+	}
+
+	return WriteJSON(w, http.StatusAccepted, anky)
+}
+
+// yearInReviewYear reads the ?year= parameter, defaulting to the current year.
+func yearInReviewYear(r *http.Request) (int, error) {
+	currentYear := time.Now().UTC().Year()
+	yearStr := r.URL.Query().Get("year")
+	if yearStr == "" {
+		return currentYear, nil
+	}
+
+	year, err := strconv.Atoi(yearStr)
+	if err != nil || year < 2024 || year > currentYear {
+		return 0, Validation("invalid year: %s", yearStr)
+	}
+	return year, nil
+}
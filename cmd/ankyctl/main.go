@@ -0,0 +1,292 @@
+// Command ankyctl runs operator tasks against the database for people
+// without direct access to it. It reads the same environment as the server.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ankylat/anky/server/logging"
+	"github.com/ankylat/anky/server/services"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, store *storage.PostgresStore, args []string) error
+}
+
+var commands = map[string]command{
+	"stuck-ankys":           {"[-older-than 30m] [-limit 50]", listStuckAnkys},
+	"retry-anky":            {"<anky-id>...", retryAnkys},
+	"grant-badge":           {"-user <user-id> -name <name> [-description <text>]", grantBadge},
+	"newen-balance":         {"<user-id>", showNewenBalance},
+	"adjust-newen":          {"-user <user-id> -amount <n> -reason <text>", adjustNewen},
+	"rotate-encryption-key": {"[-new-key <base64>] [-dry-run]", rotateEncryptionKey},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: Error loading .env file: %v", err)
+	}
+	logging.Install()
+
+	store, err := storage.NewPostgresStore()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := cmd.run(ctx, store, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ankyctl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ankyctl <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, name := range []string{"stuck-ankys", "retry-anky", "grant-badge", "newen-balance", "adjust-newen", "rotate-encryption-key"} {
+		fmt.Fprintf(os.Stderr, "  %-22s %s\n", name, commands[name].usage)
+	}
+}
+
+func listStuckAnkys(ctx context.Context, store *storage.PostgresStore, args []string) error {
+	flags := flag.NewFlagSet("stuck-ankys", flag.ExitOnError)
+	olderThan := flags.Duration("older-than", 30*time.Minute, "how long the pipeline has not moved")
+	limit := flags.Int("limit", 50, "maximum number of ankys to list")
+	flags.Parse(args)
+
+	ankys, err := store.GetStuckAnkys(ctx, time.Now().UTC().Add(-*olderThan), *limit)
+	if err != nil {
+		return err
+	}
+	if len(ankys) == 0 {
+		fmt.Println("No stuck ankys")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ANKY\tUSER\tSESSION\tSTATUS\tLAST UPDATE")
+	for _, anky := range ankys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", anky.ID, anky.UserID, anky.WritingSessionID, anky.Status, anky.LastUpdatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func retryAnkys(ctx context.Context, store *storage.PostgresStore, args []string) error {
+	if len(args) == 0 {
+		return errors.New("at least one anky ID is required")
+	}
+
+	ankyService, err := services.NewAnkyService(store)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, arg := range args {
+		ankyID, err := uuid.Parse(arg)
+		if err != nil {
+			return fmt.Errorf("invalid anky ID %q", arg)
+		}
+		anky, err := store.GetAnkyByID(ctx, ankyID)
+		if err != nil {
+			return err
+		}
+		if anky.Status == "completed" {
+			fmt.Printf("%s is already completed, skipping\n", anky.ID)
+			continue
+		}
+
+		if err := ankyService.RetryAnkyPipeline(ctx, anky); err != nil {
+			fmt.Printf("%s failed: %v\n", anky.ID, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s is now %s\n", anky.ID, anky.Status)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d retries failed", failed, len(args))
+	}
+	return nil
+}
+
+func grantBadge(ctx context.Context, store *storage.PostgresStore, args []string) error {
+	flags := flag.NewFlagSet("grant-badge", flag.ExitOnError)
+	userID := flags.String("user", "", "user to grant the badge to")
+	name := flags.String("name", "", "badge name")
+	description := flags.String("description", "", "badge description")
+	flags.Parse(args)
+
+	parsedUserID, err := uuid.Parse(*userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID %q", *userID)
+	}
+	if strings.TrimSpace(*name) == "" {
+		return errors.New("a badge name is required")
+	}
+	if _, err := store.GetUserByID(ctx, parsedUserID); err != nil {
+		return err
+	}
+
+	badge := &types.Badge{UserID: parsedUserID.String(), Name: *name, Description: *description}
+	if err := store.CreateBadge(ctx, badge); err != nil {
+		return err
+	}
+	fmt.Printf("Granted badge %q (%s) to %s\n", badge.Name, badge.ID, parsedUserID)
+	return nil
+}
+
+func showNewenBalance(ctx context.Context, store *storage.PostgresStore, args []string) error {
+	if len(args) != 1 {
+		return errors.New("a user ID is required")
+	}
+	userID, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid user ID %q", args[0])
+	}
+
+	balance, err := store.GetNewenBalance(ctx, userID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s has %d newen\n", userID, balance)
+	return nil
+}
+
+func adjustNewen(ctx context.Context, store *storage.PostgresStore, args []string) error {
+	flags := flag.NewFlagSet("adjust-newen", flag.ExitOnError)
+	userID := flags.String("user", "", "user whose balance changes")
+	amount := flags.Int("amount", 0, "newen to add, negative to take away")
+	reason := flags.String("reason", "", "why the balance changes, kept with the adjustment")
+	flags.Parse(args)
+
+	parsedUserID, err := uuid.Parse(*userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID %q", *userID)
+	}
+	if *amount == 0 {
+		return errors.New("a non-zero amount is required")
+	}
+	if strings.TrimSpace(*reason) == "" {
+		return errors.New("a reason is required")
+	}
+	if _, err := store.GetUserByID(ctx, parsedUserID); err != nil {
+		return err
+	}
+
+	newenService, err := services.NewNewenService(store)
+	if err != nil {
+		return err
+	}
+	balance, err := newenService.AdjustUserBalance(ctx, parsedUserID, *amount, *reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Adjusted %s by %d, balance is now %d newen\n", parsedUserID, *amount, balance)
+	return nil
+}
+
+// rotateEncryptionKey re-encrypts every seed phrase from ENCRYPTION_KEY to a
+// new key. Seed phrases the new key already opens are skipped, so it can run
+// again after deploying the new key to catch users created in the meantime.
+func rotateEncryptionKey(ctx context.Context, store *storage.PostgresStore, args []string) error {
+	flags := flag.NewFlagSet("rotate-encryption-key", flag.ExitOnError)
+	encodedNewKey := flags.String("new-key", "", "base64 encoded 32 byte key, generated when empty")
+	dryRun := flags.Bool("dry-run", false, "check every seed phrase opens without writing anything")
+	flags.Parse(args)
+
+	oldKey, err := types.ParseEncryptionKey(os.Getenv("ENCRYPTION_KEY"))
+	if err != nil {
+		return fmt.Errorf("current ENCRYPTION_KEY: %v", err)
+	}
+	if *encodedNewKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		*encodedNewKey = base64.StdEncoding.EncodeToString(key)
+		fmt.Printf("Generated new key, store it before deploying: %s\n", *encodedNewKey)
+	}
+	newKey, err := types.ParseEncryptionKey(*encodedNewKey)
+	if err != nil {
+		return fmt.Errorf("new key: %v", err)
+	}
+
+	const pageSize = 200
+	rotated, skipped, failed := 0, 0, 0
+	for offset := 0; ; offset += pageSize {
+		users, err := store.GetUsers(ctx, pageSize, offset)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.SeedPhrase == "" {
+				continue
+			}
+			if _, err := types.DecryptStringWithKey(user.SeedPhrase, newKey); err == nil {
+				skipped++
+				continue
+			}
+			seedPhrase, err := types.DecryptStringWithKey(user.SeedPhrase, oldKey)
+			if err != nil {
+				fmt.Printf("%s: seed phrase doesn't open with the current key: %v\n", user.ID, err)
+				failed++
+				continue
+			}
+			if *dryRun {
+				rotated++
+				continue
+			}
+			encrypted, err := types.EncryptStringWithKey(seedPhrase, newKey)
+			if err != nil {
+				return err
+			}
+			if err := store.UpdateUserSeedPhrase(ctx, user.ID, encrypted); err != nil {
+				return err
+			}
+			rotated++
+		}
+		if len(users) < pageSize {
+			break
+		}
+	}
+
+	verb := "Re-encrypted"
+	if *dryRun {
+		verb = "Would re-encrypt"
+	}
+	fmt.Printf("%s %d seed phrases, %d already used the new key, %d failed\n", verb, rotated, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d seed phrases could not be decrypted", failed)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RedactedPlaceholder is what sensitive values are replaced with in log output.
+const RedactedPlaceholder = "[REDACTED]"
+
+// defaultSensitiveFields are the field/header/struct names whose values must
+// never reach the logs. Matching is case-insensitive and ignores "_" and "-",
+// so "seed_phrase", "SeedPhrase" and "seed-phrase" are all the same field.
+var defaultSensitiveFields = []string{
+	"authorization",
+	"jwt",
+	"token",
+	"id_token",
+	"privy-id-token",
+	"seed_phrase",
+	"mnemonic",
+	"api_key",
+	"x-api-key",
+	"anky_api_key",
+	"signer_uuid",
+	"signature",
+	"secret",
+	"password",
+	"writing",
+	"writing_string",
+	"session_long_string",
+	"raw_content",
+}
+
+// defaultSensitivePatterns catch secrets that show up inside free-form text,
+// regardless of which field they were attached to.
+var defaultSensitivePatterns = []string{
+	`(?i)bearer\s+[A-Za-z0-9\-_.~+/]+=*`,
+	`eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]*`,
+	`-----BEGIN [A-Z ]+-----[\s\S]*?-----END [A-Z ]+-----`,
+	`(?i)\b0x[a-f0-9]{64}\b`,
+}
+
+// Redactor scrubs sensitive values out of strings, maps and HTTP headers
+// before they are logged.
+type Redactor struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+	kvRegexp *regexp.Regexp
+}
+
+// NewRedactor builds a Redactor from a list of field names and regular
+// expressions. Invalid expressions are reported and skipped.
+func NewRedactor(fields []string, patterns []string) *Redactor {
+	r := &Redactor{fields: make(map[string]bool)}
+
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		r.fields[normalizeField(field)] = true
+		names = append(names, fieldRegexp(field))
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[logging] ignoring invalid redaction pattern %q: %v\n", pattern, err)
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	if len(names) > 0 {
+		// Matches `field: value`, `field=value`, `"field":"value"` and Go's
+		// %+v output (`Field:value`).
+		r.kvRegexp = regexp.MustCompile(`(?i)("?\b(?:` + strings.Join(names, "|") + `)"?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|\[[^\]]*\]|[^\s,})]+)`)
+	}
+
+	return r
+}
+
+// NewRedactorFromEnv builds a Redactor with the default fields and patterns
+// plus anything configured through LOG_REDACT_FIELDS and LOG_REDACT_PATTERNS
+// (comma separated and ";;" separated respectively).
+func NewRedactorFromEnv() *Redactor {
+	fields := append([]string{}, defaultSensitiveFields...)
+	if extra := os.Getenv("LOG_REDACT_FIELDS"); extra != "" {
+		fields = append(fields, strings.Split(extra, ",")...)
+	}
+
+	patterns := append([]string{}, defaultSensitivePatterns...)
+	if extra := os.Getenv("LOG_REDACT_PATTERNS"); extra != "" {
+		patterns = append(patterns, strings.Split(extra, ";;")...)
+	}
+
+	return NewRedactor(fields, patterns)
+}
+
+// IsSensitiveField reports whether values stored under the given name are redacted.
+func (r *Redactor) IsSensitiveField(name string) bool {
+	return r.fields[normalizeField(name)]
+}
+
+// String redacts every sensitive key/value pair and pattern match in s.
+func (r *Redactor) String(s string) string {
+	if r.kvRegexp != nil {
+		s = r.kvRegexp.ReplaceAllString(s, "${1}"+RedactedPlaceholder)
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, RedactedPlaceholder)
+	}
+	return s
+}
+
+// Map returns a copy of m with sensitive fields redacted, recursing into
+// nested maps and slices.
+func (r *Redactor) Map(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if r.IsSensitiveField(k) {
+			out[k] = RedactedPlaceholder
+			continue
+		}
+		out[k] = r.value(v)
+	}
+	return out
+}
+
+func (r *Redactor) value(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return r.Map(val)
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = r.value(item)
+		}
+		return items
+	case string:
+		return r.String(val)
+	default:
+		return v
+	}
+}
+
+// Header returns a copy of h with sensitive headers redacted.
+func (r *Redactor) Header(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, values := range h {
+		if r.IsSensitiveField(k) {
+			out[k] = []string{RedactedPlaceholder}
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i, v := range values {
+			redacted[i] = r.String(v)
+		}
+		out[k] = redacted
+	}
+	return out
+}
+
+// writer applies the redactor to everything written through it.
+type writer struct {
+	out      io.Writer
+	redactor *Redactor
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := w.out.Write([]byte(w.redactor.String(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewWriter wraps out so that everything written to it is redacted first.
+func NewWriter(out io.Writer, redactor *Redactor) io.Writer {
+	return &writer{out: out, redactor: redactor}
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultRedactor = NewRedactorFromEnv()
+)
+
+// Default returns the process wide redactor.
+func Default() *Redactor {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultRedactor
+}
+
+// Install reloads the redaction configuration from the environment and routes
+// the standard logger through it. Call it after the .env file is loaded.
+func Install() {
+	defaultMu.Lock()
+	defaultRedactor = NewRedactorFromEnv()
+	defaultMu.Unlock()
+
+	log.SetOutput(NewWriter(os.Stderr, Default()))
+}
+
+// Redact redacts s with the default redactor.
+func Redact(s string) string {
+	return Default().String(s)
+}
+
+// RedactMap redacts m with the default redactor.
+func RedactMap(m map[string]interface{}) map[string]interface{} {
+	return Default().Map(m)
+}
+
+// RedactHeader redacts h with the default redactor.
+func RedactHeader(h http.Header) http.Header {
+	return Default().Header(h)
+}
+
+// Secret describes a secret without revealing it, e.g. for "is it configured?" logs.
+func Secret(s string) string {
+	if s == "" {
+		return "<empty>"
+	}
+	return fmt.Sprintf("%s (len=%d)", RedactedPlaceholder, len(s))
+}
+
+// Content describes user writing without revealing it.
+func Content(s string) string {
+	return fmt.Sprintf("<%d chars>", len(s))
+}
+
+func normalizeField(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "_", "")
+	return strings.ReplaceAll(name, "-", "")
+}
+
+// fieldRegexp turns a field name into an expression that also matches its
+// snake_case, kebab-case and CamelCase spellings.
+func fieldRegexp(field string) string {
+	normalized := normalizeField(field)
+	parts := make([]string, 0, len(normalized))
+	for _, ch := range normalized {
+		parts = append(parts, regexp.QuoteMeta(string(ch)))
+	}
+	return strings.Join(parts, `[_-]?`)
+}
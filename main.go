@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/ankylat/anky/server/api"
+	"github.com/ankylat/anky/server/logging"
+	"github.com/ankylat/anky/server/services"
 	"github.com/ankylat/anky/server/storage"
 	"github.com/joho/godotenv"
 )
@@ -18,6 +23,9 @@ func main() {
 		log.Println("Continuing with existing environment variables...")
 	}
 
+	// Route the standard logger through the redaction layer
+	logging.Install()
+
 	// Initialize database connection
 	store, err := storage.NewPostgresStore()
 	if err != nil {
@@ -27,6 +35,98 @@ func main() {
 	// Verify database connection
 	log.Println("Successfully connected to database")
 
+	// Periodic jobs stop as soon as the shutdown starts. Each of them runs on
+	// a single instance when several replicas share the database.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	// Render everyone's year in review when the year turns over
+	go services.RunAsLeader(jobsCtx, store, "annual_recap", services.NewYearInReviewService(store, services.NewSessionArchiveService(store)).StartAnnualRecapJob)
+
+	// Publish last month's anonymized research dataset on the first of the month
+	go services.RunAsLeader(jobsCtx, store, "research_dataset", services.NewResearchDatasetService(store).StartDatasetJob)
+
+	// Pin the images of Ankys that fell back to data URI metadata
+	go services.RunAsLeader(jobsCtx, store, "storage_repair", func(ctx context.Context) {
+		services.NewStorageRepairService(store).StartStorageRepairJob(ctx, services.StorageRepairIntervalFromEnv())
+	})
+
+	// Snapshot the price and market cap of the tokens clanker deployed
+	go services.RunAsLeader(jobsCtx, store, "market_snapshot", func(ctx context.Context) {
+		services.NewMarketDataService(store).StartMarketSnapshotJob(ctx, services.MarketSnapshotIntervalFromEnv())
+	})
+
+	// Reveal the NFTs of queued Ankys and confirm their transactions
+	go services.RunAsLeader(jobsCtx, store, "onchain_confirmation", func(ctx context.Context) {
+		services.NewBlockchainService(store).StartConfirmationJob(ctx, services.OnchainConfirmationIntervalFromEnv())
+	})
+
+	// Record the tokens clanker deploys in reply to Anky casts
+	go services.RunAsLeader(jobsCtx, store, "clanker_watch", func(ctx context.Context) {
+		services.NewClankerTokenService(store).StartDeploymentWatchJob(ctx, services.ClankerWatchIntervalFromEnv())
+	})
+
+	// Check that the casts of completed Ankys are still on Farcaster
+	go services.RunAsLeader(jobsCtx, store, "cast_reconciliation", func(ctx context.Context) {
+		services.NewCastReconciliationService(store).StartCastReconciliationJob(ctx, services.CastReconciliationIntervalFromEnv())
+	})
+
+	// Restore test the latest database backup
+	go services.RunAsLeader(jobsCtx, store, "backup_verification", func(ctx context.Context) {
+		services.NewBackupVerificationService(store).StartBackupVerificationJob(ctx, services.BackupVerificationIntervalFromEnv())
+	})
+
+	// Pair writers with accountability buddies and nudge the ones who missed a day
+	go services.RunAsLeader(jobsCtx, store, "writing_buddies", func(ctx context.Context) {
+		services.NewWritingBuddyService(store).StartBuddyJob(ctx, services.BuddyJobIntervalFromEnv())
+	})
+
+	// Remind writers to write around the hour they usually do
+	go services.RunAsLeader(jobsCtx, store, "writing_reminders", func(ctx context.Context) {
+		services.NewWritingReminderService(store).StartReminderJob(ctx, services.ReminderJobIntervalFromEnv())
+	})
+
+	// Move the writing of old sessions to the archive
+	go services.RunAsLeader(jobsCtx, store, "session_archival", func(ctx context.Context) {
+		services.NewSessionArchiveService(store).StartArchivalJob(ctx, services.SessionArchivalIntervalFromEnv())
+	})
+
+	// Forget the responses of idempotent submissions once retries are unlikely
+	go services.RunAsLeader(jobsCtx, store, "idempotency_cleanup", func(ctx context.Context) {
+		services.NewIdempotencyService(store).StartCleanupJob(ctx, services.IdempotencyCleanupIntervalFromEnv())
+	})
+
+	// Reveal the time capsules whose date came
+	go services.RunAsLeader(jobsCtx, store, "time_capsule_reveal", func(ctx context.Context) {
+		services.NewTimeCapsuleService(store).StartRevealJob(ctx, services.TimeCapsuleRevealIntervalFromEnv())
+	})
+
+	// Prune the anonymous sessions older than ANONYMOUS_RETENTION_DAYS
+	go services.RunAsLeader(jobsCtx, store, "anonymous_prune", func(ctx context.Context) {
+		services.NewAnonymousRetentionService(store, services.NewSessionArchiveService(store)).StartPruneJob(ctx, services.AnonymousPruneIntervalFromEnv())
+	})
+
+	// Delete data exports whose download link expired
+	go services.RunAsLeader(jobsCtx, store, "export_cleanup", func(ctx context.Context) {
+		services.NewUserExportService(store, services.NewSessionArchiveService(store)).StartCleanupJob(ctx, services.ExportCleanupIntervalFromEnv())
+	})
+
+	// Purge the accounts whose deletion grace period ended
+	go services.RunAsLeader(jobsCtx, store, "account_purge", func(ctx context.Context) {
+		archive := services.NewSessionArchiveService(store)
+		services.NewAccountDeletionService(store, archive, services.NewUserExportService(store, archive)).StartPurgeJob(ctx, services.AccountPurgeIntervalFromEnv())
+	})
+
+	// Call the webhooks registered for the Ankys this instance makes and purge
+	// the ones that changed from the CDN, on every instance since each one
+	// only hears of its own pipelines
+	go services.NewWebhookService(store).Dispatch(jobsCtx)
+	go services.NewCDNPurgeService().Run(jobsCtx)
+
+	// Go on with the Ankys parked while image generation or casting was
+	// switched off, where the switch is flipped back on
+	go services.NewParkedAnkyService(store).Run(jobsCtx)
+
 	// Initialize API server
 	port := ":8888"
 	server, err := api.NewAPIServer(port, store)
@@ -53,6 +153,19 @@ func main() {
 		log.Fatalf("Server error: %v", err)
 	case <-stop:
 		log.Println("Shutting down server gracefully...")
-		// Add cleanup code here if needed
+		stopJobs()
+
+		// Give writing sessions in the middle of a mint time to finish
+		shutdownTimeout := 30 * time.Second
+		if seconds, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SECONDS")); err == nil && seconds > 0 {
+			shutdownTimeout = time.Duration(seconds) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown finished with error: %v", err)
+		}
+		log.Println("Server stopped")
 	}
 }
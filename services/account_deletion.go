@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const accountPurgeBatch = 20
+
+// AccountDeletionService deletes the accounts their owners ask to delete.
+// Asking only schedules the deletion: for a grace period
+// (ACCOUNT_DELETION_GRACE_DAYS, 30 by default) the account is hidden and
+// its tokens refused, but the owner can cancel it and nothing is lost. Then the purge job removes the account with everything
+// it wrote, in the database and out of it, and unpins its IPFS content when
+// the owner asked for that too.
+type AccountDeletionService struct {
+	store   *storage.PostgresStore
+	archive *SessionArchiveService
+	exports *UserExportService
+}
+
+func NewAccountDeletionService(store *storage.PostgresStore, archive *SessionArchiveService, exports *UserExportService) *AccountDeletionService {
+	return &AccountDeletionService{store: store, archive: archive, exports: exports}
+}
+
+// ScheduleDeletion schedules the purge of the user after the grace period.
+// Asking again keeps the first date.
+func (s *AccountDeletionService) ScheduleDeletion(ctx context.Context, userID uuid.UUID, unpinIPFS bool) (*types.UserDeletion, error) {
+	now := s.store.Clock().Now().UTC()
+	deletion := &types.UserDeletion{
+		UserID:      userID,
+		RequestedAt: now,
+		PurgeAfter:  now.Add(AccountDeletionGraceFromEnv()),
+		UnpinIPFS:   unpinIPFS,
+	}
+	if err := s.store.ScheduleUserDeletion(ctx, deletion); err != nil {
+		return nil, err
+	}
+	log.Printf("🗑️ Deletion of user %s scheduled for %s", userID, deletion.PurgeAfter.Format(time.RFC3339))
+	return deletion, nil
+}
+
+// CancelDeletion unschedules the deletion of the user and reports whether
+// one was scheduled.
+func (s *AccountDeletionService) CancelDeletion(ctx context.Context, userID uuid.UUID) (bool, error) {
+	cancelled, err := s.store.CancelUserDeletion(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if cancelled {
+		log.Printf("↩️ Deletion of user %s cancelled", userID)
+	}
+	return cancelled, nil
+}
+
+// UserDeletionStatus returns the scheduled deletion of the user, or nil.
+func (s *AccountDeletionService) UserDeletionStatus(ctx context.Context, userID uuid.UUID) (*types.UserDeletion, error) {
+	deletion, err := s.store.GetUserDeletion(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return deletion, err
+}
+
+// StartPurgeJob blocks, purging the accounts whose grace period ended every interval.
+func (s *AccountDeletionService) StartPurgeJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.PurgeDueAccounts(ctx); err != nil {
+				log.Printf("❌ Error purging deleted accounts: %v", err)
+			}
+		}
+	}
+}
+
+// PurgeDueAccounts purges the accounts whose grace period ended and returns
+// how many were purged.
+func (s *AccountDeletionService) PurgeDueAccounts(ctx context.Context) (int, error) {
+	deletions, err := s.store.GetDueUserDeletions(ctx, s.store.Clock().Now().UTC(), accountPurgeBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, deletion := range deletions {
+		if ctx.Err() != nil {
+			return purged, ctx.Err()
+		}
+		if err := s.PurgeAccount(ctx, deletion); err != nil {
+			log.Printf("❌ Error purging user %s: %v", deletion.UserID, err)
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("🗑️ Purged %d deleted accounts", purged)
+	}
+	return purged, nil
+}
+
+// PurgeAccount deletes the user for good. The database goes first, in one
+// transaction; what lives outside it is then scrubbed on a best effort
+// basis, failures being logged, since the account can't be purged twice.
+func (s *AccountDeletionService) PurgeAccount(ctx context.Context, deletion *types.UserDeletion) error {
+	footprint, err := s.store.GetUserFootprint(ctx, deletion.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.DeleteUser(ctx, deletion.UserID); err != nil {
+		return err
+	}
+
+	s.archive.DeleteArchives(ctx, footprint.ArchiveKeys)
+	s.exports.DeleteExports(ctx, footprint.ExportKeys)
+	removeUserFiles(deletion.UserID, footprint)
+	if deletion.UnpinIPFS {
+		s.unpinAll(ctx, footprint.IPFSHashes)
+	}
+	log.Printf("🗑️ User %s purged: %d sessions, %d IPFS hashes", deletion.UserID, len(footprint.SessionIDs), len(footprint.IPFSHashes))
+	return nil
+}
+
+// unpinAll unpins the hashes no other Anky points at; identical images are
+// pinned once, so another writer's Anky can share a hash.
+func (s *AccountDeletionService) unpinAll(ctx context.Context, hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+	pinata, err := NewPinataService(s.store)
+	if err != nil {
+		log.Printf("❌ Could not unpin %d IPFS hashes of a deleted account: %v", len(hashes), err)
+		return
+	}
+	for _, hash := range hashes {
+		referenced, err := s.store.IsIPFSHashReferenced(ctx, hash)
+		if err != nil {
+			log.Printf("❌ Error checking references of %s: %v", hash, err)
+			continue
+		}
+		if referenced {
+			continue
+		}
+		if err := pinata.Unpin(ctx, hash); err != nil {
+			log.Printf("❌ Error unpinning %s: %v", hash, err)
+			continue
+		}
+		if _, err := s.store.DeleteIPFSPins(ctx, hash); err != nil {
+			log.Printf("❌ Error forgetting pin %s: %v", hash, err)
+		}
+	}
+}
+
+// removeUserFiles deletes what the handlers saved under data/ for the user:
+// their raw sessions, the framesgiving files kept by user and by FID, and
+// the files of each of their sessions.
+func removeUserFiles(userID uuid.UUID, footprint *types.UserFootprint) {
+	dirs := []string{
+		filepath.Join("data/writing_sessions", userID.String()),
+		filepath.Join("data/framesgiving", userID.String()),
+	}
+	for _, fid := range footprint.FIDs {
+		dirs = append(dirs, filepath.Join("data/framesgiving", strconv.Itoa(fid)))
+	}
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("❌ Error removing %s: %v", dir, err)
+		}
+	}
+
+	removeSessionFiles(footprint.SessionIDs)
+}
+
+// removeSessionFiles deletes the live stream, framesgiving metadata and image
+// collection saved under data/ for each session.
+func removeSessionFiles(sessionIDs []uuid.UUID) {
+	for _, sessionID := range sessionIDs {
+		id := sessionID.String()
+		for _, path := range []string{
+			filepath.Join("data/writing_sessions/live", id+".txt"),
+			framesMetadataPath(id),
+			framesCollectionPath(id),
+		} {
+			if err := utils.Files.Remove(path); err != nil {
+				log.Printf("❌ Error removing %s: %v", path, err)
+			}
+		}
+	}
+}
+
+func AccountDeletionGraceFromEnv() time.Duration {
+	if value := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return 30 * 24 * time.Hour
+}
+
+func AccountPurgeIntervalFromEnv() time.Duration {
+	if value := os.Getenv("ACCOUNT_PURGE_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return time.Hour
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	// Sessions at least this long get a triptych instead of a single image
+	deepDiveMinDuration = 20 * time.Minute
+	triptychPanels      = 3
+)
+
+const triptychPersona = `You are a visual interpretation expert who turns a long writing journey into a triptych: three images meant to be seen side by side, left to right.
+
+You will receive the story written for the user and the description of its main image. Write three image prompts:
+1. The beginning: where the writer started, what they were carrying
+2. The turning point: the moment the writing broke through
+3. The arrival: where the writing left them
+
+All three panels must share the same blue cartoon character as a gentle guide, the same color palette, art style and recurring symbols, so they read as one piece. Keep every scene uplifting, using metaphor instead of literal depictions of anything difficult.
+
+Format: exactly three lines, one prompt per line, no numbering and no additional context or explanation.`
+
+// isDeepDive reports whether the session is long enough for a multi-image collection.
+func isDeepDive(session *utils.WritingSession) bool {
+	return session.Duration() >= deepDiveMinDuration
+}
+
+// generateTriptychPrompts asks the LLM for three coordinated panel prompts.
+func (s *AnkyService) generateTriptychPrompts(llmService *LLMService, story string, imagePrompt string) ([]string, error) {
+	response, err := s.processChatRequest(llmService, types.ChatRequest{
+		Messages: []types.Message{
+			{Role: "system", Content: triptychPersona},
+			{Role: "user", Content: "Story:\n\n" + story + "\n\nMain image:\n\n" + imagePrompt},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error generating triptych prompts: %v", err)
+	}
+
+	var prompts []string
+	for _, line := range strings.Split(response, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			prompts = append(prompts, line)
+		}
+	}
+	if len(prompts) != triptychPanels {
+		return nil, fmt.Errorf("expected %d triptych prompts, got %d", triptychPanels, len(prompts))
+	}
+	return prompts, nil
+}
+
+// generateAnkyCollection generates and pins every panel of the triptych, in
+// order. A panel that can't be pinned keeps its image URL; a panel that can't
+// be generated fails the whole collection so the Anky falls back to one image.
+func (s *AnkyService) generateAnkyCollection(ctx context.Context, llmService *LLMService, sessionID string, story string, imagePrompt string, style imageStyle) ([]*types.AnkyImage, error) {
+	prompts, err := s.generateTriptychPrompts(llmService, story, imagePrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	pinataService, err := NewPinataService(s.store)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Pinata service: %v", err)
+	}
+
+	images := make([]*types.AnkyImage, 0, len(prompts))
+	for i, prompt := range prompts {
+		log.Printf("🖼️ Generating triptych panel %d/%d for session %s", i+1, len(prompts), sessionID)
+		imageURL, err := generateAnkyImageURL(ctx, style, prompt)
+		if err != nil {
+			return nil, fmt.Errorf("error generating triptych panel %d: %v", i+1, err)
+		}
+
+		image := &types.AnkyImage{
+			Position:    i,
+			ImagePrompt: prompt,
+			ImageURL:    imageURL,
+		}
+		if hash, err := pinataService.UploadImageFromURL(imageURL); err != nil {
+			log.Printf("⚠️ Could not pin triptych panel %d for session %s: %v", i+1, sessionID, err)
+		} else {
+			image.ImageIPFSHash = hash
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// saveAnkyCollection attaches the collection to the Anky created for the session.
+func (s *AnkyService) saveAnkyCollection(ctx context.Context, sessionID string, images []*types.AnkyImage) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		log.Printf("⚠️ Not saving collection for session with invalid id %s: %v", sessionID, err)
+		return
+	}
+	anky, err := s.store.GetAnkyByWritingSessionID(ctx, sessionUUID)
+	if err != nil {
+		log.Printf("⚠️ No Anky found to attach the collection of session %s: %v", sessionID, err)
+		return
+	}
+	if err := s.store.SaveAnkyImages(ctx, anky.ID, images); err != nil {
+		log.Printf("❌ Error saving collection of Anky %s: %v", anky.ID, err)
+		return
+	}
+	log.Printf("🖼️ Saved %d image collection for Anky %s", len(images), anky.ID)
+}
+
+// collectionImageURLs returns the URLs of the panels, in order.
+func collectionImageURLs(images []*types.AnkyImage) []string {
+	urls := make([]string, 0, len(images))
+	for _, image := range images {
+		if image.ImageURL != "" {
+			urls = append(urls, image.ImageURL)
+		}
+	}
+	return urls
+}
+
+// framesCollectionPath is where the framesgiving flow keeps a session's image
+// collection, next to its metadata file.
+func framesCollectionPath(sessionID string) string {
+	return fmt.Sprintf("data/framesgiving/ankys/%s.images.json", sessionID)
+}
+
+// ReadFramesAnkyImages returns the session's image collection, or nothing if
+// the session only has one image.
+func ReadFramesAnkyImages(sessionID string) ([]*types.AnkyImage, error) {
+	content, err := utils.Files.ReadFile(framesCollectionPath(sessionID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var images []*types.AnkyImage
+	if err := json.Unmarshal(content, &images); err != nil {
+		return nil, fmt.Errorf("invalid collection file: %v", err)
+	}
+	return images, nil
+}
+
+func WriteFramesAnkyImages(sessionID string, images []*types.AnkyImage) error {
+	content, err := json.Marshal(images)
+	if err != nil {
+		return fmt.Errorf("error encoding collection: %v", err)
+	}
+	if err := utils.Files.WriteFile(framesCollectionPath(sessionID), content, 0644); err != nil {
+		return fmt.Errorf("error writing collection file: %v", err)
+	}
+	return nil
+}
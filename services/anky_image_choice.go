@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// How often the pipeline checks whether the writer chose their image
+const imageChoicePollInterval = 2 * time.Second
+
+var ErrImageAlreadyChosen = errors.New("the image of this anky was already chosen")
+
+// imageChoice is the candidate the pipeline uploads and the version it is
+// recorded on, nil for Ankys that are not stored.
+type imageChoice struct {
+	version   *types.AnkyImageVersion
+	candidate int
+}
+
+// ImageChoiceTimeoutFromEnv is how long the pipeline waits for the writer to
+// choose their image (IMAGE_CHOICE_TIMEOUT_SECONDS, 2 minutes by default)
+// before it keeps the first candidate.
+func ImageChoiceTimeoutFromEnv() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("IMAGE_CHOICE_TIMEOUT_SECONDS")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 2 * time.Minute
+}
+
+// waitForImageChoice stores the candidates of the image backend and waits for
+// the writer to choose one through POST /ankys/{id}/choose-image. When they
+// don't choose in time the first candidate is kept, and so it is right away
+// for Ankys that are not stored and for time capsules, whose writer doesn't
+// see the image before the reveal.
+func (s *AnkyService) waitForImageChoice(ctx context.Context, run *ankyPipelineRun, candidates []string) (*imageChoice, error) {
+	anky := run.anky
+	if anky.ID == uuid.Nil || anky.Sealed() {
+		return &imageChoice{}, nil
+	}
+
+	version := &types.AnkyImageVersion{
+		AnkyID:        anky.ID,
+		ImagePrompt:   anky.ImagePrompt,
+		CandidateURLs: candidates,
+	}
+	if err := s.store.SaveAnkyPipelineImageCandidates(ctx, version); err != nil {
+		log.Printf("⚠️ Could not offer the image candidates of anky %s, keeping the first one: %v", anky.ID, err)
+		return &imageChoice{}, nil
+	}
+	if err := s.setAnkyStatus(ctx, anky, run.sessionID, "choosing_image"); err != nil {
+		return nil, err
+	}
+
+	deadline := time.NewTimer(ImageChoiceTimeoutFromEnv())
+	defer deadline.Stop()
+	ticker := time.NewTicker(imageChoicePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+			chosen, err := s.store.GetAnkyImageVersion(ctx, anky.ID, version.Version)
+			if err != nil {
+				log.Printf("⚠️ Could not check the image choice of anky %s: %v", anky.ID, err)
+				continue
+			}
+			if chosen.ChosenCandidate != nil {
+				s.recordAnkyStatusEvent(ctx, anky.ID, "image_chosen", fmt.Sprintf("candidate %d", *chosen.ChosenCandidate))
+				return &imageChoice{version: chosen, candidate: *chosen.ChosenCandidate}, nil
+			}
+		case <-deadline.C:
+			// The writer may choose right as the time runs out, their choice wins
+			ok, err := s.store.ChooseAnkyPipelineImage(ctx, anky.ID, 0)
+			if err != nil {
+				return nil, err
+			}
+			chosen, err := s.store.GetAnkyImageVersion(ctx, anky.ID, version.Version)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				s.recordAnkyStatusEvent(ctx, anky.ID, "image_chosen", "candidate 0, the writer didn't choose in time")
+			}
+			if chosen.ChosenCandidate == nil {
+				return &imageChoice{}, nil
+			}
+			return &imageChoice{version: chosen, candidate: *chosen.ChosenCandidate}, nil
+		}
+	}
+}
+
+// completeImageChoice records the uploaded image on the version the choice
+// was made on. Failures are logged, the Anky has its image already.
+func (s *AnkyService) completeImageChoice(ctx context.Context, choice *imageChoice, imageURL string) {
+	if choice.version == nil {
+		return
+	}
+	choice.version.ImageURL = imageURL
+	if err := s.store.CompleteAnkyPipelineImage(ctx, choice.version); err != nil {
+		log.Printf("⚠️ Could not record the chosen image of anky %s: %v", choice.version.AnkyID, err)
+	}
+}
+
+// ChooseImage records the candidate the writer chose for the image of an
+// Anky whose pipeline is waiting for it. It returns ErrImageAlreadyChosen
+// when the pipeline isn't waiting anymore.
+func (s *AnkyService) ChooseImage(ctx context.Context, anky *types.Anky, candidate int) (*types.AnkyImageVersion, error) {
+	version, err := s.store.GetAnkyImageVersion(ctx, anky.ID, 1)
+	if err != nil {
+		return nil, err
+	}
+	if version.Source != types.AnkyImageSourcePipeline || version.Status != types.AnkyImageReady || version.ChosenCandidate != nil {
+		return nil, ErrImageAlreadyChosen
+	}
+	if candidate < 0 || candidate >= len(version.CandidateURLs) {
+		return nil, ErrImageCandidateNotFound
+	}
+	ok, err := s.store.ChooseAnkyPipelineImage(ctx, anky.ID, candidate)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrImageAlreadyChosen
+	}
+	version.ChosenCandidate = &candidate
+	return version, nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// Versions of its image an Anky can have, the pipeline's included
+const maxAnkyImageVersions = 10
+
+var (
+	ErrNoImage                = errors.New("this anky has no image yet")
+	ErrTooManyImageVersions   = fmt.Errorf("an anky can have at most %d images", maxAnkyImageVersions)
+	ErrImageVersionNotReady   = errors.New("this image version has no candidates to keep")
+	ErrImageCandidateNotFound = errors.New("this image version has no such candidate")
+)
+
+// StartImageRegeneration stores a new version of the Anky's image, which
+// GenerateImageCandidates then fills with the candidates of the image backend.
+// The Anky keeps its image until the owner keeps one of them.
+func (s *AnkyService) StartImageRegeneration(ctx context.Context, anky *types.Anky) (*types.AnkyImageVersion, error) {
+	if anky.ImagePrompt == "" || anky.ImageURL == "" {
+		return nil, ErrNoImage
+	}
+	for _, capability := range []string{CapabilityImageGeneration, CapabilityCloudinary, CapabilityPinata} {
+		if err := CheckCapability(capability); err != nil {
+			return nil, err
+		}
+	}
+	versions, err := s.store.GetAnkyImageVersions(ctx, anky.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) >= maxAnkyImageVersions {
+		return nil, ErrTooManyImageVersions
+	}
+
+	version := &types.AnkyImageVersion{
+		AnkyID:      anky.ID,
+		ImagePrompt: anky.ImagePrompt,
+	}
+	if err := s.store.StartAnkyImageRegeneration(ctx, version); err != nil {
+		return nil, err
+	}
+	s.recordAnkyStatusEvent(ctx, anky.ID, "image_regenerating", fmt.Sprintf("version %d", version.Version))
+	return version, nil
+}
+
+// GenerateImageCandidates runs the image prompt of the version through the
+// image backend, in the style of the current season's pipeline, and stores the
+// candidates it returns. Failures are stored on the version.
+func (s *AnkyService) GenerateImageCandidates(ctx context.Context, version *types.AnkyImageVersion) error {
+	candidates, err := s.generateImageCandidates(ctx, version.ImagePrompt)
+	if err != nil {
+		s.recordAnkyStatusEvent(ctx, version.AnkyID, "image_regenerating", fmt.Sprintf("version %d failed: %v", version.Version, err))
+		if failErr := s.store.FailAnkyImageVersion(context.WithoutCancel(ctx), version.ID, err.Error()); failErr != nil {
+			log.Printf("❌ Could not mark image version %d of anky %s failed: %v", version.Version, version.AnkyID, failErr)
+		}
+		return err
+	}
+	if err := s.store.SetAnkyImageCandidates(ctx, version.ID, candidates); err != nil {
+		return err
+	}
+	version.Status = types.AnkyImageReady
+	version.CandidateURLs = candidates
+	s.recordAnkyStatusEvent(ctx, version.AnkyID, "image_regenerated", fmt.Sprintf("version %d, %d candidates", version.Version, len(candidates)))
+	return nil
+}
+
+func (s *AnkyService) generateImageCandidates(ctx context.Context, imagePrompt string) ([]string, error) {
+	spec, _, err := s.pipelineSpec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	style := defaultImageStyle
+	for _, stage := range spec.Stages {
+		if stage.Name == types.PipelineStageImage {
+			style = imageStyleFromParams(stage.Params)
+		}
+	}
+
+	job, err := generateImages(ctx, style.prompt(imagePrompt))
+	if err != nil {
+		return nil, err
+	}
+	return job.CandidateURLs, nil
+}
+
+// KeepImageVersion makes the version the Anky's image. A regenerated
+// version is kept with the candidate the owner chose, uploaded to Cloudinary
+// and pinned on IPFS first; a version that was kept before is just made
+// current again. It wraps pgx.ErrNoRows when there's no such version.
+func (s *AnkyService) KeepImageVersion(ctx context.Context, anky *types.Anky, versionNumber int, candidate int) (*types.AnkyImageVersion, error) {
+	version, err := s.store.GetAnkyImageVersion(ctx, anky.ID, versionNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	switch version.Status {
+	case types.AnkyImageReady:
+		if candidate < 0 || candidate >= len(version.CandidateURLs) {
+			return nil, ErrImageCandidateNotFound
+		}
+		imageHandler, err := s.images()
+		if err != nil {
+			return nil, err
+		}
+		publicID := fmt.Sprintf("%s-v%d", anky.WritingSessionID, version.Version)
+		uploadResult, err := uploadImageToCloudinary(imageHandler, version.CandidateURLs[candidate], publicID)
+		if err != nil {
+			return nil, fmt.Errorf("error uploading image to Cloudinary: %w", err)
+		}
+		version.ImageURL = uploadResult.SecureURL
+		version.ChosenCandidate = &candidate
+	case types.AnkyImageKept:
+		if version.Current {
+			return version, nil
+		}
+	default:
+		return nil, ErrImageVersionNotReady
+	}
+
+	if version.ImageIPFSHash == "" {
+		pinataService, err := NewPinataService(s.store)
+		if err != nil {
+			return nil, err
+		}
+		imageIPFSHash, err := pinataService.UploadImageFromURLWithProgress(version.ImageURL, s.uploadProgressRecorder(ctx, anky, "image_regenerating"))
+		if err != nil {
+			return nil, fmt.Errorf("error pinning image: %w", err)
+		}
+		version.ImageIPFSHash = imageIPFSHash
+	}
+
+	if err := s.store.KeepAnkyImageVersion(ctx, version); err != nil {
+		return nil, err
+	}
+	s.recordAnkyStatusEvent(ctx, anky.ID, "image_kept", fmt.Sprintf("version %d", version.Version))
+	s.rewriteImageMetadata(anky, version)
+	return version, nil
+}
+
+// rewriteImageMetadata points the frames metadata file of the Anky at the
+// pinned image of the version. Failures are logged, the image is already
+// kept.
+func (s *AnkyService) rewriteImageMetadata(anky *types.Anky, version *types.AnkyImageVersion) {
+	sessionID := anky.WritingSessionID.String()
+	metadata, err := ReadFramesAnkyMetadata(sessionID)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️ Could not read the metadata file of anky %s: %v", anky.ID, err)
+		return
+	}
+	metadata.IPFSHash = version.ImageIPFSHash
+	metadata.ImageURL = ""
+	metadata.MetadataURI = ""
+	if err := WriteFramesAnkyMetadata(sessionID, metadata); err != nil {
+		log.Printf("⚠️ Could not rewrite the metadata file of anky %s: %v", anky.ID, err)
+	}
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// imageStyle is how the images of Ankys look: the Midjourney style reference
+// put before every prompt and words added after it.
+type imageStyle struct {
+	Reference string
+	Suffix    string
+}
+
+var defaultImageStyle = imageStyle{Reference: "https://s.mj.run/YLJMlMJbo70"}
+
+// ErrPastedWriting is returned for sessions whose keystrokes show pasted
+// text, see utils.DetectPaste. Only typed sessions become Ankys.
+var ErrPastedWriting = errors.New("the writing session has pasted text")
+
+// ErrSuspectWriting is returned for sessions whose keystroke cadence looks
+// scripted, see utils.AnalyzeCadence.
+var ErrSuspectWriting = errors.New("the writing session doesn't look typed by hand")
+
+func (st imageStyle) prompt(prompt string) string {
+	parts := make([]string, 0, 3)
+	// Only Midjourney reads an image URL before the prompt as its style, the
+	// other backends would draw the URL
+	if generator, _ := imageGenerator(); st.Reference != "" && generator.Name() == ImageProviderMidjourney {
+		parts = append(parts, st.Reference)
+	}
+	parts = append(parts, prompt)
+	if st.Suffix != "" {
+		parts = append(parts, st.Suffix)
+	}
+	return strings.Join(parts, " ")
+}
+
+// DefaultPipelineSpec is the pipeline of Ankys when no season configured one:
+// the reflection, a token for clanker, the image, pinning, the safety
+// review, the NFT reveal, the cast and the session summary.
+func DefaultPipelineSpec() types.PipelineSpec {
+	return types.PipelineSpec{Stages: []types.PipelineStage{
+		{Name: types.PipelineStageReflection},
+		{Name: types.PipelineStageToken},
+		{Name: types.PipelineStageImage},
+		{Name: types.PipelineStagePin},
+		{Name: types.PipelineStageReview},
+		{Name: types.PipelineStageOnchain},
+		{Name: types.PipelineStageCast},
+		{Name: types.PipelineStageSummary},
+	}}
+}
+
+// ankyPipelineRun is what the stages of one pipeline run share. What they
+// produce is stored on the Anky.
+type ankyPipelineRun struct {
+	anky      *types.Anky
+	writing   string
+	sessionID string
+	userID    string
+	session   *utils.WritingSession
+	llm       *LLMService
+}
+
+type pipelineStageDef struct {
+	run func(s *AnkyService, ctx context.Context, run *ankyPipelineRun, params map[string]string) error
+	// Stages that must come earlier in the pipeline
+	requires []string
+	// Parameters the stage accepts, all optional
+	params []string
+}
+
+var pipelineStages = map[string]pipelineStageDef{
+	types.PipelineStageReflection: {run: (*AnkyService).reflectionStage},
+	types.PipelineStageToken: {
+		run:      (*AnkyService).tokenStage,
+		requires: []string{types.PipelineStageReflection},
+	},
+	types.PipelineStageImage: {
+		run:      (*AnkyService).imageStage,
+		requires: []string{types.PipelineStageReflection},
+		// style_reference replaces the Midjourney style reference, empty for
+		// none; style is added to every image prompt
+		params: []string{"style_reference", "style"},
+	},
+	types.PipelineStagePin: {
+		run:      (*AnkyService).pinStage,
+		requires: []string{types.PipelineStageImage},
+	},
+	// Holds flagged Ankys for a moderator, see SafetyReviewService
+	types.PipelineStageReview: {
+		run:      (*AnkyService).reviewStage,
+		requires: []string{types.PipelineStageReflection},
+	},
+	types.PipelineStageOnchain: {
+		run:      (*AnkyService).onchainStage,
+		requires: []string{types.PipelineStagePin},
+	},
+	types.PipelineStageCast: {
+		run: (*AnkyService).castStage,
+		// story_reply, true or false, overrides CAST_STORY_REPLY
+		params: []string{"story_reply"},
+	},
+	types.PipelineStageSummary: {run: (*AnkyService).summaryStage},
+}
+
+// ValidatePipelineSpec checks that every stage exists, runs once, comes after
+// the stages it needs and only gets parameters it takes.
+func ValidatePipelineSpec(spec types.PipelineSpec) error {
+	if len(spec.Stages) == 0 {
+		return errors.New("the pipeline has no stages")
+	}
+
+	seen := make(map[string]bool, len(spec.Stages))
+	for _, stage := range spec.Stages {
+		def, ok := pipelineStages[stage.Name]
+		if !ok {
+			names := make([]string, 0, len(pipelineStages))
+			for name := range pipelineStages {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown pipeline stage %q, stages are %s", stage.Name, strings.Join(names, ", "))
+		}
+		if seen[stage.Name] {
+			return fmt.Errorf("pipeline stage %q appears twice", stage.Name)
+		}
+		for _, required := range def.requires {
+			if !seen[required] {
+				return fmt.Errorf("pipeline stage %q must come after %q", stage.Name, required)
+			}
+		}
+		for param := range stage.Params {
+			known := false
+			for _, name := range def.params {
+				known = known || name == param
+			}
+			if !known {
+				return fmt.Errorf("pipeline stage %q has no parameter %q", stage.Name, param)
+			}
+		}
+		seen[stage.Name] = true
+	}
+	return nil
+}
+
+// pipelineSpec returns the pipeline of the current season and its number,
+// the default pipeline and season 0 when no season started yet.
+func (s *AnkyService) pipelineSpec(ctx context.Context) (types.PipelineSpec, int, error) {
+	season, err := s.store.GetCurrentSeason(ctx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DefaultPipelineSpec(), 0, nil
+	}
+	if err != nil {
+		return types.PipelineSpec{}, 0, fmt.Errorf("error getting the current season: %w", err)
+	}
+	if err := ValidatePipelineSpec(season.Pipeline); err != nil {
+		return types.PipelineSpec{}, 0, fmt.Errorf("invalid pipeline for season %d: %w", season.Number, err)
+	}
+	return season.Pipeline, season.Number, nil
+}
+
+func skippedRequirement(def pipelineStageDef, skipped map[string]bool) bool {
+	for _, required := range def.requires {
+		if skipped[required] {
+			return true
+		}
+	}
+	return false
+}
+
+func pipelineStageNames(spec types.PipelineSpec) string {
+	names := make([]string, 0, len(spec.Stages))
+	for _, stage := range spec.Stages {
+		names = append(names, stage.Name)
+	}
+	return strings.Join(names, " → ")
+}
+
+// reflectionStage writes the story the user receives and the description of
+// its image.
+func (s *AnkyService) reflectionStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	story, err := s.generateReflectionStory(run.llm, run.session)
+	if err != nil {
+		return err
+	}
+	if err := validateStory(story); err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+	imagePrompt, err := s.generateImagePrompt(run.llm, story)
+	if err != nil {
+		return err
+	}
+
+	run.anky.AnkyReflection = story
+	run.anky.ImagePrompt = imagePrompt
+	return s.setAnkyStatus(ctx, run.anky, run.sessionID, "reflection_completed")
+}
+
+// tokenStage names the token clanker deploys when the Anky is cast.
+func (s *AnkyService) tokenStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	tokenName, ticker, err := s.generateTokenIdentity(run.llm, run.anky.AnkyReflection, run.anky.ImagePrompt, run.sessionID)
+	if err != nil {
+		return err
+	}
+	if err := validateTokenIdentity(tokenName, ticker); err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+
+	run.anky.TokenName = tokenName
+	run.anky.Ticker = ticker
+	s.assignSlug(ctx, run)
+	return nil
+}
+
+// imageStage generates the image with the image backend and uploads it to
+// Cloudinary. Deep dives get a triptych, its first panel doubles as the main
+// image. With image generation switched off, stored Ankys are left
+// pending_image until ParkedAnkyService resumes them.
+func (s *AnkyService) imageStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	anky := run.anky
+	style := imageStyleFromParams(params)
+	for _, capability := range []string{CapabilityImageGeneration, CapabilityCloudinary} {
+		if err := CheckCapability(capability); err != nil {
+			return err
+		}
+	}
+
+	if err := CheckFeature(FeatureImageGeneration); err != nil && anky.ID != uuid.Nil {
+		s.recordAnkyStatusEvent(ctx, anky.ID, "pending_image", err.Error())
+		if err := s.setAnkyStatus(ctx, anky, run.sessionID, "pending_image"); err != nil {
+			return err
+		}
+		return ErrAnkyParked
+	}
+
+	if err := s.setAnkyStatus(ctx, anky, run.sessionID, "going_to_generate_image"); err != nil {
+		return err
+	}
+
+	if isDeepDive(run.session) {
+		log.Printf("🖼️ Deep-dive session (%s), generating a triptych", run.session.Duration().Round(time.Second))
+		collection, err := s.generateAnkyCollection(ctx, run.llm, run.sessionID, anky.AnkyReflection, anky.ImagePrompt, style)
+		if err == nil {
+			anky.Images = collection
+			anky.ImageURL = collection[0].ImageURL
+			return s.setAnkyStatus(ctx, anky, run.sessionID, "image_generated")
+		}
+		log.Printf("⚠️ Could not generate triptych, falling back to a single image: %v", err)
+	}
+
+	job, err := submitImageJob(ctx, style.prompt(anky.ImagePrompt))
+	if err != nil {
+		log.Printf("Error generating image: %v", err)
+		return err
+	}
+
+	if err := s.setAnkyStatus(ctx, anky, run.sessionID, "generating_image"); err != nil {
+		return err
+	}
+
+	job, err = awaitImageJob(ctx, job)
+	if err != nil {
+		log.Printf("Error waiting for image job: %v", err)
+		return err
+	}
+
+	if err := s.setAnkyStatus(ctx, anky, run.sessionID, "image_generated"); err != nil {
+		return err
+	}
+
+	choice, err := s.waitForImageChoice(ctx, run, job.CandidateURLs)
+	if err != nil {
+		return err
+	}
+	chosenImageURL := job.CandidateURLs[choice.candidate]
+
+	if err := s.setAnkyStatus(ctx, anky, run.sessionID, "uploading_image"); err != nil {
+		return err
+	}
+
+	imageHandler, err := s.images()
+	if err != nil {
+		log.Printf("Error creating ImageHandler: %v", err)
+		return err
+	}
+	uploadResult, err := uploadImageToCloudinary(imageHandler, chosenImageURL, run.sessionID)
+	if err != nil {
+		log.Printf("Error uploading image to Cloudinary: %v", err)
+		return err
+	}
+	log.Printf("Image uploaded to Cloudinary successfully. Public ID: %s, URL: %s", uploadResult.PublicID, uploadResult.SecureURL)
+
+	anky.ImageURL = uploadResult.SecureURL
+	s.completeImageChoice(ctx, choice, anky.ImageURL)
+	return nil
+}
+
+// imageStyleFromParams is the style the parameters of an image stage ask for.
+func imageStyleFromParams(params map[string]string) imageStyle {
+	style := defaultImageStyle
+	if reference, ok := params["style_reference"]; ok {
+		style.Reference = reference
+	}
+	style.Suffix = params["style"]
+	return style
+}
+
+// pinStage pins the image on IPFS and writes the NFT metadata. When Pinata
+// fails the metadata falls back to a data URI until the storage repair job
+// pins the image.
+func (s *AnkyService) pinStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	anky := run.anky
+	if anky.Status != "uploading_image" {
+		if err := s.setAnkyStatus(ctx, anky, run.sessionID, "uploading_image"); err != nil {
+			return err
+		}
+	}
+
+	metadata := &FramesAnkyMetadata{
+		TokenName: anky.TokenName,
+		Ticker:    anky.Ticker,
+		Number:    "0",
+		Story:     anky.AnkyReflection,
+		License:   anky.License,
+	}
+
+	if len(anky.Images) > 0 && anky.Images[0].ImageIPFSHash != "" {
+		anky.ImageIPFSHash = anky.Images[0].ImageIPFSHash
+	} else {
+		var imageIPFSHash string
+		pinataService, err := NewPinataService(s.store)
+		if err == nil {
+			imageIPFSHash, err = pinataService.UploadImageFromURLWithProgress(anky.ImageURL, s.uploadProgressRecorder(ctx, anky, "uploading_image"))
+		}
+		if err != nil {
+			s.recordAnkyStatusEvent(ctx, anky.ID, "uploading_image", fmt.Sprintf("failed: %v", err))
+
+			// Pinata already retried, or isn't configured: keep the NFT
+			// resolvable until the storage repair job pins the image
+			metadataURI, metadataErr := degradedMetadataURI(anky.TokenName, anky.Ticker, anky.AnkyReflection, anky.ImageURL, anky.License)
+			if metadataErr != nil {
+				log.Printf("Error building fallback metadata: %v", metadataErr)
+				return err
+			}
+			anky.StorageDegraded = true
+			anky.MetadataURI = metadataURI
+			s.recordAnkyStatusEvent(ctx, anky.ID, "storage_degraded", "using data URI metadata until the image is pinned")
+		}
+		anky.ImageIPFSHash = imageIPFSHash
+	}
+	log.Printf("Image uploaded to Pinata successfully. IPFS Hash: %s", anky.ImageIPFSHash)
+	if anky.ID != uuid.Nil && anky.ImageIPFSHash != "" {
+		if err := s.store.SetCurrentAnkyImageHash(ctx, anky.ID, anky.ImageIPFSHash); err != nil {
+			log.Printf("⚠️ Could not record the IPFS hash of the image of anky %s: %v", anky.ID, err)
+		}
+	}
+
+	if anky.ImageIPFSHash != "" {
+		metadata.IPFSHash = anky.ImageIPFSHash
+	} else {
+		metadata.ImageURL = anky.ImageURL
+		metadata.MetadataURI = anky.MetadataURI
+	}
+	if err := WriteFramesAnkyMetadata(run.sessionID, metadata); err != nil {
+		log.Printf("❌ Error writing metadata file: %v", err)
+		return err
+	}
+	if len(anky.Images) > 0 {
+		if err := WriteFramesAnkyImages(run.sessionID, anky.Images); err != nil {
+			log.Printf("⚠️ Error writing collection file: %v", err)
+		}
+	}
+
+	return s.setAnkyStatus(ctx, anky, run.sessionID, "image_uploaded")
+}
+
+// onchainStage reveals the Anky's NFT with its pinned metadata. A reveal
+// that can't happen yet, or fails, is left to BlockchainService's
+// confirmation job and doesn't hold the Anky back.
+func (s *AnkyService) onchainStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	if err := CheckCapability(CapabilityOnchain); err != nil {
+		return err
+	}
+	anky := run.anky
+	// Revealed by an earlier run of the pipeline
+	if anky.OnchainStatus != "" && anky.OnchainStatus != types.OnchainStatusQueued {
+		return nil
+	}
+	if err := NewBlockchainService(s.store).RevealAnky(ctx, anky); err != nil {
+		log.Printf("⚠️ Onchain reveal of anky %s queued: %v", anky.ID, err)
+	}
+	return nil
+}
+
+// castStage casts the Anky from the writer's account, followed by its story
+// as a thread of replies when asked. Writers without a signer, or with
+// casting switched off, are left pending_to_cast. Time capsules are never
+// cast before their reveal, see TimeCapsuleService.
+func (s *AnkyService) castStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	anky := run.anky
+	if anky.Sealed() {
+		detail := "not cast"
+		if anky.CastOnReveal {
+			detail = "cast on reveal"
+		}
+		s.recordAnkyStatusEvent(ctx, anky.ID, "sealed", fmt.Sprintf("until %s, %s", anky.RevealAt.UTC().Format(time.RFC3339), detail))
+		return nil
+	}
+	if err := s.setAnkyStatus(ctx, anky, run.sessionID, "casting_to_farcaster"); err != nil {
+		return err
+	}
+	user, err := s.store.GetUserByID(ctx, uuid.MustParse(run.userID))
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		return err
+	}
+
+	// With casting switched off the Anky waits like one without a signer
+	castingErr := CheckFeature(FeatureCasting)
+	if castingErr != nil {
+		s.recordAnkyStatusEvent(ctx, anky.ID, "pending_to_cast", castingErr.Error())
+	}
+	if castingErr != nil || user.FarcasterUser == nil || user.FarcasterUser.SignerUUID == "" {
+		return s.setAnkyStatus(ctx, anky, run.sessionID, "pending_to_cast")
+	}
+
+	castResponse, err := publishAnkyToFarcaster(run.sessionID, run.userID, anky.Ticker, anky.TokenName, user.FarcasterUser.SignerUUID, anky.ImageIPFSHash, collectionImageURLs(anky.Images))
+	if err != nil {
+		log.Printf("Error publishing to Farcaster: %v", err)
+		return err
+	}
+	anky.CastHash = castResponse.Hash
+	if storyReplyEnabled(params) {
+		if replies := replyWithStory(ctx, castResponse, user.FarcasterUser.SignerUUID, anky.AnkyReflection); len(replies) > 0 {
+			s.recordAnkyStatusEvent(ctx, anky.ID, "story_replied", fmt.Sprintf("%d replies under %s", len(replies), castResponse.Hash))
+		}
+	}
+	return s.setAnkyStatus(ctx, anky, run.sessionID, "completed")
+}
+
+// summaryStage gives list endpoints a preview of the now public session.
+func (s *AnkyService) summaryStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	s.summarizeWritingSession(ctx, run.sessionID, run.writing)
+	return nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+const pipelineTestWriting = "the morning light came through the window and i kept writing even when the words ran thin, because the page was the only place where i could hear myself think about the year that is ending"
+
+type pipelineFakes struct {
+	llm        *fakeUpstream
+	midjourney *fakeMidjourney
+	cloudinary *fakeUpstream
+	pinata     *fakeUpstream
+	neynar     *fakeNeynar
+}
+
+// newPipelineTest stores a writer with a signer, a session and its Anky, and
+// points every upstream at fakes. The pipeline writes its frames files in a
+// temporary directory.
+func newPipelineTest(t *testing.T) (*storage.PostgresStore, *pipelineFakes, *types.Anky, string) {
+	t.Helper()
+	store := storagetest.Open(t)
+	fakes := &pipelineFakes{
+		llm:        newFakeLLM(t),
+		midjourney: newFakeMidjourney(t),
+		cloudinary: newFakeCloudinary(t),
+		pinata:     newFakePinata(t),
+		neynar:     newFakeNeynar(t),
+	}
+	useFakeUpstreams(t, fakes.llm, fakes.midjourney, fakes.cloudinary, fakes.pinata, fakes.neynar)
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	ctx := context.Background()
+	user := &types.User{
+		ID:            uuid.New(),
+		FarcasterUser: &types.FarcasterUser{FID: 18350, Username: "writer", SignerUUID: "signer-1"},
+		CreatedAt:     time.Now().UTC(),
+		UpdatedAt:     time.Now().UTC(),
+	}
+	if err := store.CreateUserWithRelations(ctx, user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+
+	session := &types.WritingSession{
+		ID:                uuid.New(),
+		UserID:            user.ID,
+		StartingTimestamp: time.Now().UTC(),
+		Prompt:            "what is alive in you this morning?",
+		Status:            "completed",
+		Writing:           pipelineTestWriting,
+		IsAnky:            true,
+	}
+	if err := store.CreateWritingSession(ctx, session); err != nil {
+		t.Fatalf("creating writing session: %v", err)
+	}
+	anky := &types.Anky{
+		ID:               uuid.New(),
+		UserID:           user.ID,
+		WritingSessionID: session.ID,
+		ChosenPrompt:     session.Prompt,
+		Status:           "created",
+		CreatedAt:        time.Now().UTC(),
+		FID:              user.FID,
+	}
+	if err := store.CreateAnky(ctx, anky); err != nil {
+		t.Fatalf("creating anky: %v", err)
+	}
+	return store, fakes, anky, typedSession(user.ID, session.ID, pipelineTestWriting)
+}
+
+func runTestPipeline(t *testing.T, store *storage.PostgresStore, anky *types.Anky, writing string) (*types.Anky, error) {
+	t.Helper()
+	ankyService, err := NewAnkyService(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	runErr := ankyService.ProcessStoredAnky(ctx, anky, writing)
+
+	stored, err := store.GetAnkyByID(context.Background(), anky.ID)
+	if err != nil {
+		t.Fatalf("reading back anky: %v", err)
+	}
+	return stored, runErr
+}
+
+func TestAnkyPipelineAgainstFakeServices(t *testing.T) {
+	store, fakes, anky, writing := newPipelineTest(t)
+
+	stored, err := runTestPipeline(t, store, anky, writing)
+	if err != nil {
+		t.Fatalf("pipeline: %v", err)
+	}
+	if stored.Status != "completed" {
+		t.Errorf("status = %q, want completed", stored.Status)
+	}
+	if stored.AnkyReflection != fakeLLMReply || stored.TokenName != fakeLLMTokenName || stored.Ticker != fakeLLMTicker {
+		t.Errorf("anky = %q %q %q, want the fake LLM's reflection, token name and ticker", stored.AnkyReflection, stored.TokenName, stored.Ticker)
+	}
+	if stored.ImageURL != fakes.cloudinary.URL+"/anky.png" {
+		t.Errorf("image url = %q, want the Cloudinary upload", stored.ImageURL)
+	}
+	if stored.ImageIPFSHash != fakePinataHash || stored.StorageDegraded {
+		t.Errorf("image hash = %q, degraded = %v, want it pinned", stored.ImageIPFSHash, stored.StorageDegraded)
+	}
+	if stored.CastHash != "0xcast1" {
+		t.Errorf("cast hash = %q, want 0xcast1", stored.CastHash)
+	}
+
+	casts := fakes.neynar.sent()
+	if len(casts) != 1 {
+		t.Fatalf("sent %d casts, want 1", len(casts))
+	}
+	if casts[0]["signer_uuid"] != "signer-1" || casts[0]["idem"] != anky.WritingSessionID.String() {
+		t.Errorf("cast payload = %v, want the writer's signer under the session's idempotency key", casts[0])
+	}
+	if got := fakes.midjourney.count("POST", "/items/images/"); got != 1 {
+		t.Errorf("image jobs submitted = %d, want 1", got)
+	}
+}
+
+func TestAnkyPipelineDegradesWhenPinataIsDown(t *testing.T) {
+	store, fakes, anky, writing := newPipelineTest(t)
+	fakes.pinata.failAlways()
+
+	stored, err := runTestPipeline(t, store, anky, writing)
+	if err != nil {
+		t.Fatalf("pipeline: %v", err)
+	}
+	if got := fakes.pinata.count("POST", "/pinning/pinFileToIPFS"); got != pinataMaxAttempts {
+		t.Errorf("pin attempts = %d, want %d", got, pinataMaxAttempts)
+	}
+	if !stored.StorageDegraded || stored.ImageIPFSHash != "" {
+		t.Errorf("degraded = %v, image hash = %q, want degraded storage without a hash", stored.StorageDegraded, stored.ImageIPFSHash)
+	}
+	if !strings.HasPrefix(stored.MetadataURI, "data:") {
+		t.Errorf("metadata uri = %.40q, want a data URI", stored.MetadataURI)
+	}
+	// The Anky is still cast, from its Cloudinary image
+	if stored.Status != "completed" || len(fakes.neynar.sent()) != 1 {
+		t.Errorf("status = %q with %d casts, want it completed and cast", stored.Status, len(fakes.neynar.sent()))
+	}
+}
+
+func TestAnkyPipelineFailsWhenTheImageJobFails(t *testing.T) {
+	store, fakes, anky, writing := newPipelineTest(t)
+	fakes.midjourney.failJobs = true
+
+	stored, err := runTestPipeline(t, store, anky, writing)
+	if err == nil || !strings.Contains(err.Error(), "image stage failed") {
+		t.Fatalf("pipeline error = %v, want the image stage to fail", err)
+	}
+	if stored.Status != "generating_image" {
+		t.Errorf("status = %q, want it left at generating_image", stored.Status)
+	}
+	if got := fakes.pinata.count("POST", "/pinning/pinFileToIPFS"); got != 0 {
+		t.Errorf("pinned %d times after the image failed", got)
+	}
+	if len(fakes.neynar.sent()) != 0 {
+		t.Error("cast an anky whose image failed")
+	}
+}
+
+func TestAnkyPipelineFailsWhenTheLLMIsDown(t *testing.T) {
+	store, fakes, anky, writing := newPipelineTest(t)
+	fakes.llm.failAlways()
+
+	stored, err := runTestPipeline(t, store, anky, writing)
+	if err == nil || !strings.Contains(err.Error(), "reflection stage failed") {
+		t.Fatalf("pipeline error = %v, want the reflection stage to fail", err)
+	}
+	if stored.Status != "starting_processing" {
+		t.Errorf("status = %q, want it left at starting_processing", stored.Status)
+	}
+	if got := fakes.midjourney.count("POST", "/items/images/"); got != 0 {
+		t.Errorf("submitted %d image jobs without a reflection", got)
+	}
+}
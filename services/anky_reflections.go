@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	// Newen a regeneration costs unless REFLECTION_REGENERATION_COST says otherwise
+	defaultReflectionRegenerationCost = 500
+	// Versions an Anky can have, the pipeline's included
+	maxAnkyReflections = 10
+)
+
+var (
+	ErrNoReflection        = errors.New("this anky has no reflection yet")
+	ErrTooManyReflections  = fmt.Errorf("an anky can have at most %d reflections", maxAnkyReflections)
+	ErrReflectionUnchanged = errors.New("the regenerated reflection is the same as the current one")
+)
+
+// ReflectionRegenerationCost is the newen a user pays to regenerate the
+// reflection of one of their Ankys.
+func ReflectionRegenerationCost() int {
+	if cost, err := strconv.Atoi(os.Getenv("REFLECTION_REGENERATION_COST")); err == nil && cost > 0 {
+		return cost
+	}
+	return defaultReflectionRegenerationCost
+}
+
+// GetAnkyReflections returns every version of the Anky's reflection, oldest
+// first. Ankys that were never regenerated only have the pipeline's.
+func (s *AnkyService) GetAnkyReflections(ctx context.Context, anky *types.Anky) ([]*types.AnkyReflection, error) {
+	reflections, err := s.store.GetAnkyReflections(ctx, anky.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reflections) == 0 && anky.AnkyReflection != "" {
+		reflections = append(reflections, &types.AnkyReflection{
+			AnkyID:     anky.ID,
+			Version:    1,
+			Reflection: anky.AnkyReflection,
+			Source:     types.AnkyReflectionSourcePipeline,
+			Canonical:  true,
+			CreatedAt:  anky.CreatedAt,
+		})
+	}
+	return reflections, nil
+}
+
+// RegenerateReflection reruns the reflection stage on the writing of the
+// Anky, charges the owner ReflectionRegenerationCost newen and stores the
+// result as a new version. The Anky keeps its reflection until the owner
+// makes the new version canonical. The balance is checked before calling the
+// LLM and charged after it succeeds, so failed generations are free; users
+// who can't pay get storage.ErrInsufficientNewen with their balance.
+func (s *AnkyService) RegenerateReflection(ctx context.Context, anky *types.Anky, writing string) (*types.AnkyReflection, int, error) {
+	if anky.AnkyReflection == "" {
+		return nil, 0, ErrNoReflection
+	}
+	reflections, err := s.store.GetAnkyReflections(ctx, anky.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(reflections) >= maxAnkyReflections {
+		return nil, 0, ErrTooManyReflections
+	}
+
+	cost := ReflectionRegenerationCost()
+	balance, err := s.store.GetNewenBalance(ctx, anky.UserID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if balance < cost {
+		return nil, balance, storage.ErrInsufficientNewen
+	}
+
+	parsedSession, err := utils.ParseWritingSession(writing)
+	if err != nil {
+		return nil, balance, fmt.Errorf("error parsing writing session: %v", err)
+	}
+	story, err := s.generateReflectionStory(NewLLMService(), parsedSession)
+	if err != nil {
+		return nil, balance, err
+	}
+	if err := validateStory(story); err != nil {
+		return nil, balance, fmt.Errorf("validation error: %v", err)
+	}
+	if story == anky.AnkyReflection {
+		return nil, balance, ErrReflectionUnchanged
+	}
+
+	spend := &types.NewenTransaction{
+		UserID:      anky.UserID,
+		Amount:      cost,
+		Source:      types.NewenSourceSpend,
+		Description: fmt.Sprintf("reflection of anky %s", anky.ID),
+	}
+	balance, _, err = s.store.CreateNewenDebit(ctx, spend)
+	if err != nil {
+		return nil, balance, err
+	}
+
+	reflection := &types.AnkyReflection{
+		AnkyID:             anky.ID,
+		Reflection:         story,
+		Source:             types.AnkyReflectionSourceRegenerated,
+		NewenTransactionID: &spend.ID,
+	}
+	if err := s.store.AddAnkyReflection(ctx, reflection); err != nil {
+		// The user didn't get what they paid for
+		refund := &types.NewenTransaction{
+			UserID:      anky.UserID,
+			Direction:   types.NewenCredit,
+			Amount:      cost,
+			Source:      types.NewenSourceAdjustment,
+			Description: fmt.Sprintf("refund of transaction %s, the reflection could not be saved", spend.ID),
+		}
+		if refundErr := s.store.CreateNewenTransaction(context.WithoutCancel(ctx), refund); refundErr != nil {
+			log.Printf("❌ Could not refund %d newen to %s for anky %s: %v", cost, anky.UserID, anky.ID, refundErr)
+		}
+		return nil, balance, err
+	}
+
+	s.recordAnkyStatusEvent(ctx, anky.ID, "reflection_regenerated", fmt.Sprintf("version %d", reflection.Version))
+	return reflection, balance, nil
+}
+
+// SetCanonicalReflection makes the version the Anky's reflection and the
+// story of its metadata. It wraps pgx.ErrNoRows when there's no such version.
+func (s *AnkyService) SetCanonicalReflection(ctx context.Context, ankyID uuid.UUID, version int) (*types.AnkyReflection, error) {
+	reflection, err := s.store.SetCanonicalAnkyReflection(ctx, ankyID, version)
+	if err != nil {
+		return nil, err
+	}
+	s.recordAnkyStatusEvent(ctx, ankyID, "reflection_canonical", fmt.Sprintf("version %d", version))
+
+	anky, err := s.store.GetAnkyByID(ctx, ankyID)
+	if err != nil {
+		log.Printf("⚠️ Could not rewrite the metadata of anky %s with reflection %d: %v", ankyID, version, err)
+		return reflection, nil
+	}
+	s.rewriteReflectionMetadata(ctx, anky)
+	return reflection, nil
+}
+
+// rewriteReflectionMetadata puts the Anky's reflection in the metadata this
+// server keeps: the frames metadata file and the data URI fallback. Failures
+// are logged, the reflection is already saved.
+func (s *AnkyService) rewriteReflectionMetadata(ctx context.Context, anky *types.Anky) {
+	sessionID := anky.WritingSessionID.String()
+	if metadata, err := ReadFramesAnkyMetadata(sessionID); err == nil {
+		metadata.Story = anky.AnkyReflection
+		if err := WriteFramesAnkyMetadata(sessionID, metadata); err != nil {
+			log.Printf("⚠️ Could not rewrite the metadata file of anky %s: %v", anky.ID, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("⚠️ Could not read the metadata file of anky %s: %v", anky.ID, err)
+	}
+
+	if anky.StorageDegraded && anky.MetadataURI != "" {
+		metadataURI, err := degradedMetadataURI(anky.TokenName, anky.Ticker, anky.AnkyReflection, anky.ImageURL, anky.License)
+		if err != nil {
+			log.Printf("⚠️ Could not rebuild metadata of anky %s with its new reflection: %v", anky.ID, err)
+			return
+		}
+		anky.MetadataURI = metadataURI
+		anky.LastUpdatedAt = s.store.Clock().Now().UTC()
+		if err := s.store.UpdateAnky(ctx, anky); err != nil {
+			log.Printf("⚠️ Could not store rebuilt metadata of anky %s: %v", anky.ID, err)
+		}
+	}
+}
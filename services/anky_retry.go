@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+// rawWritingSessionPaths lists where the handlers keep the long string of a
+// session: the app's raw writing sessions, the live WebSocket stream and the
+// framesgiving flow, which files sessions under the writer's FID.
+func rawWritingSessionPaths(sessionID uuid.UUID, userID uuid.UUID, fid int) []string {
+	sessionFile := sessionID.String() + ".txt"
+	paths := []string{
+		filepath.Join("data/writing_sessions", userID.String(), sessionFile),
+		filepath.Join("data/writing_sessions/live", sessionFile),
+	}
+	if fid != 0 {
+		paths = append(paths, filepath.Join("data/framesgiving", strconv.Itoa(fid), sessionFile))
+	}
+	return paths
+}
+
+// ReadRawWritingSession returns the long string of a session, with its
+// keystrokes, from wherever it was filed. The error wraps os.ErrNotExist
+// when it is nowhere to be found.
+func ReadRawWritingSession(sessionID uuid.UUID, userID uuid.UUID, fid int) (string, error) {
+	for _, path := range rawWritingSessionPaths(sessionID, userID, fid) {
+		data, err := utils.Files.ReadFile(path)
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("error reading writing session: %v", err)
+		}
+	}
+	return "", fmt.Errorf("no writing session file found for session %s: %w", sessionID, os.ErrNotExist)
+}
+
+// RetryAnkyPipeline runs the whole pipeline again for an Anky that got stuck,
+// from the long string of its writing session.
+func (s *AnkyService) RetryAnkyPipeline(ctx context.Context, anky *types.Anky) error {
+	writing, err := ReadRawWritingSession(anky.WritingSessionID, anky.UserID, anky.FID)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no writing session file found for anky %s", anky.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("🔁 Retrying pipeline of anky %s (was %s)", anky.ID, anky.Status)
+	s.recordAnkyStatusEvent(ctx, anky.ID, "retrying", "previous status: "+anky.Status)
+	return s.runAnkyPipeline(ctx, anky, writing, anky.WritingSessionID.String(), anky.UserID.String())
+}
+
+// ResumeParkedAnky runs the pipeline of an Anky parked while a feature was
+// switched off again from the stage that parked it.
+func (s *AnkyService) ResumeParkedAnky(ctx context.Context, anky *types.Anky, stage string) error {
+	writing, err := ReadRawWritingSession(anky.WritingSessionID, anky.UserID, anky.FID)
+	if err != nil {
+		return err
+	}
+	spec, season, err := s.pipelineSpec(ctx)
+	if err != nil {
+		return err
+	}
+	for i, specStage := range spec.Stages {
+		if specStage.Name != stage {
+			continue
+		}
+		log.Printf("🔁 Resuming the pipeline of anky %s at its %s stage (was %s)", anky.ID, stage, anky.Status)
+		s.recordAnkyStatusEvent(ctx, anky.ID, "resumed", "previous status: "+anky.Status)
+		if i == 0 {
+			return s.runAnkyPipeline(ctx, anky, writing, anky.WritingSessionID.String(), anky.UserID.String())
+		}
+		return s.runAnkyPipelineFrom(ctx, anky, writing, anky.WritingSessionID.String(), anky.UserID.String(), spec.Stages[i-1].Name)
+	}
+	return fmt.Errorf("the season %d pipeline has no %s stage to resume at", season, stage)
+}
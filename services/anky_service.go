@@ -1,22 +1,19 @@
 package services
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
-	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ankylat/anky/server/logging"
 	"github.com/ankylat/anky/server/storage"
 	"github.com/ankylat/anky/server/types"
 	"github.com/ankylat/anky/server/utils"
 	"github.com/google/uuid"
-	"golang.org/x/exp/rand"
 )
 
 // Interfaces in Go serve several important purposes:
@@ -35,174 +32,146 @@ import (
 type AnkyServiceInterface interface {
 	ProcessAnkyCreation(anky *types.Anky, writingSession *types.WritingSession) error
 	GenerateAnkyReflection(session *types.WritingSession) (map[string]string, error)
-	GenerateImageWithMidjourney(prompt string) (string, error)
-	GenerateFramesgivingNextWritingPrompt(session *utils.WritingSession) (string, error)
+	GenerateFramesgivingNextWritingPrompt(session *utils.WritingSession, depth string, topics *types.PromptTopics) (string, error)
 	ReflectBackFromWritingSessionConversation(pastSessions []string, sessionLongString string) (string, error)
-	ProcessAnkyCreationFromWritingString(ctx context.Context, writing string, sessionID string, userID string) error
+	ProcessAnkyCreationFromWritingString(ctx context.Context, writing string, sessionID string, userID string, license string) error
 
-	PollImageStatus(id string) (string, error)
-	CheckImageStatus(id string) (string, error)
-	FetchImageDetails(id string) (*ImageDetails, error)
 	PublishToFarcaster(session *types.WritingSession) (*types.Cast, error)
 	OnboardingConversation(sessions []*types.WritingSession, ankyReflections []*types.AnkyOnboardingResponse) (string, error)
-	TriggerAnkyMintingProcess(writing_long_string string, fid string) error
+	TriggerAnkyMintingProcess(ctx context.Context, writing_long_string string, fid string, license string) error
 }
 
 type AnkyService struct {
-	store        *storage.PostgresStore
+	store     *storage.PostgresStore
+	farcaster *FarcasterService
+
+	// Cloudinary is optional, its client is created on the first upload
+	imageOnce    sync.Once
 	imageHandler *ImageService
-	farcaster    *FarcasterService
+	imageErr     error
 }
 
+// NewAnkyService never fails on a missing integration: what needs one fails
+// with a *CapabilityError when it runs, see CheckCapability.
 func NewAnkyService(store *storage.PostgresStore) (*AnkyService, error) {
-	imageHandler, err := NewImageService()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create image handler: %v", err)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to create arweave service: %v", err)
-	}
-
 	return &AnkyService{
-		store:        store,
-		imageHandler: imageHandler,
-		farcaster:    NewFarcasterService(),
+		store:     store,
+		farcaster: NewFarcasterService(),
 	}, nil
 }
 
-func (s *AnkyService) ProcessAnkyCreationFromWritingString(ctx context.Context, writing string, sessionID string, userID string) error {
-	fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-	fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-	fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-	fmt.Println("((((((((((((((((hereeeee))))))))))")
-	fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-	fmt.Println("((((((((((((((((((((((((((((((((()))))))))))))))))))))))))))))))))")
-
-	anky := &types.Anky{
-		Status: "starting_processing",
-	}
-	s.store.UpdateAnky(ctx, anky)
-
-	// 1. Generate Anky's reflection on the writing
-
-	pinataService, err := NewPinataService()
-	if err != nil {
-		return err
-	}
-
-	anky_processing_response, err := s.GenerateAnkyReflectionFromRawString(writing)
-	if err != nil {
-		return err
-	}
-	fmt.Printf("Reflection: %s\n", anky_processing_response)
-	fmt.Printf("Reflection: %s\n", anky_processing_response)
-	fmt.Printf("Reflection: %s\n", anky_processing_response)
-	fmt.Printf("Reflection: %s\n", anky_processing_response)
-
-	anky.Status = "reflection_completed"
-	s.store.UpdateAnky(ctx, anky)
-	anky.AnkyReflection = anky_processing_response.reflection_to_user
-	anky.ImageIPFSHash = anky_processing_response.image_ipfs_hash
-	anky.Ticker = anky_processing_response.ticker
-	anky.TokenName = anky_processing_response.token_name
-	fmt.Printf("Anky++++++++++++++++++++++++++++++++++++++++: %+v\n", anky)
-
-	anky.Status = "going_to_generate_image"
-	s.store.UpdateAnky(ctx, anky)
-
-	imageID, err := generateImageWithMidjourney("https://s.mj.run/YLJMlMJbo70 " + anky.ImagePrompt)
-
-	if err != nil {
-		log.Printf("Error generating image: %v", err)
-		return err
-	}
-	log.Printf("Image generation response: %s", imageID)
-
-	anky.Status = "generating_image"
-	s.store.UpdateAnky(ctx, anky)
-
-	status, err := pollImageStatus(imageID)
-	if err != nil {
-		log.Printf("Error polling image status: %v", err)
-		return err
-	}
-	log.Printf("Image generation status: %s", status)
-
-	anky.Status = "image_generated"
-	s.store.UpdateAnky(ctx, anky)
+// images returns the Cloudinary client, created the first time it's needed.
+func (s *AnkyService) images() (*ImageService, error) {
+	s.imageOnce.Do(func() {
+		s.imageHandler, s.imageErr = NewImageService()
+	})
+	return s.imageHandler, s.imageErr
+}
 
-	// Fetch the image details from the API
-	imageDetails, err := fetchImageDetails(imageID)
-	if err != nil {
-		log.Printf("Error fetching image details: %v", err)
-		return err
-	}
+func (s *AnkyService) ProcessAnkyCreationFromWritingString(ctx context.Context, writing string, sessionID string, userID string, license string) error {
+	return s.runAnkyPipeline(ctx, &types.Anky{License: license}, writing, sessionID, userID)
+}
 
-	// TODO :::: choose the image with a better strategy
-	if len(imageDetails.UpscaledURLs) == 0 {
-		log.Printf("No upscaled images available")
-		return fmt.Errorf("no upscaled images available")
-	}
+// ProcessStoredAnky runs the pipeline for an Anky that was already stored,
+// e.g. through POST /ankys.
+func (s *AnkyService) ProcessStoredAnky(ctx context.Context, anky *types.Anky, writing string) error {
+	return s.runAnkyPipeline(ctx, anky, writing, anky.WritingSessionID.String(), anky.UserID.String())
+}
 
-	randomIndex := rand.Intn(len(imageDetails.UpscaledURLs))
-	chosenImageURL := imageDetails.UpscaledURLs[randomIndex]
+// runAnkyPipeline turns the writing into the given Anky by running the stages
+// of the current season's pipeline in order, storing every step on it.
+func (s *AnkyService) runAnkyPipeline(ctx context.Context, anky *types.Anky, writing string, sessionID string, userID string) error {
+	return s.runAnkyPipelineFrom(ctx, anky, writing, sessionID, userID, "")
+}
 
-	anky.Status = "uploading_image"
-	s.store.UpdateAnky(ctx, anky)
+// runAnkyPipelineFrom runs the stages that come after resumeAfter, all of
+// them when it is empty. Ankys held for review or parked stop there without
+// failing.
+func (s *AnkyService) runAnkyPipelineFrom(ctx context.Context, anky *types.Anky, writing string, sessionID string, userID string, resumeAfter string) (err error) {
+	defer func() {
+		if err != nil {
+			ankyPipelineFailures.Inc(anky.Status)
+			publishAnkyStatus(sessionID, "failed", err.Error())
+		}
+	}()
 
-	// Upload the generated image to Cloudinary
-	imageHandler, err := NewImageService()
-	if err != nil {
-		log.Printf("Error creating ImageHandler: %v", err)
-		return err
+	if resumeAfter == "" {
+		if err := s.setAnkyStatus(ctx, anky, sessionID, "starting_processing"); err != nil {
+			return err
+		}
 	}
 
-	uploadResult, err := uploadImageToCloudinary(imageHandler, chosenImageURL, sessionID)
+	spec, season, err := s.pipelineSpec(ctx)
 	if err != nil {
-		log.Printf("Error uploading image to Cloudinary: %v", err)
 		return err
 	}
-
-	imageIPFSHash, err := pinataService.UploadImageFromURL(uploadResult.SecureURL)
-	if err != nil {
-		return err
+	stages := spec.Stages
+	if resumeAfter != "" {
+		resumed := false
+		for i, stage := range stages {
+			if stage.Name == resumeAfter {
+				stages, resumed = stages[i+1:], true
+				break
+			}
+		}
+		if !resumed {
+			return fmt.Errorf("the season %d pipeline has no %s stage to resume after", season, resumeAfter)
+		}
 	}
-
-	anky.ImageURL = uploadResult.SecureURL
-	anky.ImageIPFSHash = imageIPFSHash
-
-	log.Printf("Image uploaded to Cloudinary successfully. Public ID: %s, URL: %s", uploadResult.PublicID, uploadResult.SecureURL)
-	log.Printf("Image uploaded to Pinata successfully. IPFS Hash: %s", imageIPFSHash)
-
-	anky.Status = "image_uploaded"
-	s.store.UpdateAnky(ctx, anky)
-
-	// 5. Mark as complete
-	anky.Status = "casting_to_farcaster"
-	s.store.UpdateAnky(ctx, anky)
-	// Get user to check for Farcaster signer UUID
-	user, err := s.store.GetUserByID(ctx, uuid.MustParse(userID))
+	parsedSession, err := utils.ParseWritingSession(writing)
 	if err != nil {
-		log.Printf("Error getting user: %v", err)
-		return err
+		return fmt.Errorf("error parsing writing session: %v", err)
+	}
+	if parsedSession.Paste.Flagged {
+		return ErrPastedWriting
+	}
+	if parsedSession.Cheat.Suspect {
+		return ErrSuspectWriting
+	}
+	run := &ankyPipelineRun{
+		anky:      anky,
+		writing:   writing,
+		sessionID: sessionID,
+		userID:    userID,
+		session:   parsedSession,
+		llm:       NewLLMService(),
+	}
+
+	log.Printf("🧬 Running the season %d pipeline for session %s: %s", season, sessionID, pipelineStageNames(types.PipelineSpec{Stages: stages}))
+	// Stages needing an integration this server lacks are skipped, with the
+	// stages that need them, and the Anky completes without them
+	skipped := make(map[string]bool)
+	for _, stage := range stages {
+		def := pipelineStages[stage.Name]
+		if skippedRequirement(def, skipped) {
+			skipped[stage.Name] = true
+			continue
+		}
+		err := def.run(s, ctx, run, stage.Params)
+		if errors.Is(err, ErrCapabilityUnavailable) {
+			log.Printf("⚠️ Skipping the %s stage of session %s: %v", stage.Name, sessionID, err)
+			s.recordAnkyStatusEvent(ctx, anky.ID, stage.Name+"_skipped", err.Error())
+			skipped[stage.Name] = true
+			continue
+		}
+		if errors.Is(err, ErrAnkyHeldForReview) || errors.Is(err, ErrAnkyRejected) || errors.Is(err, ErrAnkyParked) {
+			log.Printf("🛡️ Pipeline of session %s stopped at the %s stage: %v", sessionID, stage.Name, err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s stage failed: %w", stage.Name, err)
+		}
 	}
 
-	if user.FarcasterUser != nil && user.FarcasterUser.SignerUUID != "" {
-		castResponse, err := publishAnkyToFarcaster(writing, sessionID, userID, anky.Ticker, anky.TokenName, user.FarcasterUser.SignerUUID, anky.ImageIPFSHash)
-		if err != nil {
-			log.Printf("Error publishing to Farcaster: %v", err)
+	// Pipelines without a cast end here
+	if anky.Status != "completed" && anky.Status != "pending_to_cast" {
+		if err := s.setAnkyStatus(ctx, anky, sessionID, "completed"); err != nil {
 			return err
 		}
-
-		anky.CastHash = castResponse.Hash
-		anky.Status = "completed"
-	} else {
-		anky.Status = "pending_to_cast"
 	}
-
-	s.store.UpdateAnky(ctx, anky)
-
+	if len(anky.Images) > 0 {
+		s.saveAnkyCollection(ctx, sessionID, anky.Images)
+	}
 	return nil
 }
 
@@ -241,11 +210,10 @@ func (s *AnkyService) CreateUserProfile(ctx context.Context, userID uuid.UUID) (
 
 	// Update the Anky in our database to store the FID
 	// This creates the link between the user's writing and their Farcaster identity
-	err = s.store.UpdateAnky(ctx, &types.Anky{
-		ID:     lastAnky.ID,
-		FID:    newFid,
-		Status: "fid_linked",
-	})
+	lastAnky.FID = newFid
+	lastAnky.Status = "fid_linked"
+	lastAnky.LastUpdatedAt = s.store.Clock().Now().UTC()
+	err = s.store.UpdateAnky(ctx, lastAnky)
 	if err != nil {
 		log.Printf("Error updating Anky with new FID: %v", err)
 		return "", fmt.Errorf("failed to link FID to Anky: %v", err)
@@ -268,16 +236,8 @@ func (s *AnkyService) LinkAnkyWithFid(ctx context.Context, ankyID uuid.UUID, fid
 	return nil
 }
 
-func (s *AnkyService) GenerateFramesgivingNextWritingPrompt(session *utils.WritingSession) (string, error) {
-	log.Println("🚀 Starting to generate next writing prompt")
-
-	// Create LLM service to analyze writing and generate prompt
-	log.Println("🤖 Creating new LLM service")
-	llmService := NewLLMService()
-
-	// Build system prompt focused on gratitude exploration
-	log.Println("📝 Building system prompt for gratitude exploration")
-	systemPrompt := `You are an AI guide helping users explore gratitude through reflective writing.
+// framesgivingPromptPersona turns a framesgiving session into the next gratitude prompt.
+const framesgivingPromptPersona = `You are an AI guide helping users explore gratitude through reflective writing.
 Your task is to:
 1. Analyze the user's stream of consciousness writing
 2. Identify elements, experiences, relationships or feelings that could connect to gratitude
@@ -289,6 +249,22 @@ Your task is to:
 
 Important: Do not make any explanations to your reply. Just reply with the inquiry. Nothing else. No context. No explanation. Just the question.`
 
+// GenerateFramesgivingNextWritingPrompt asks for the next prompt at the
+// writer's depth level, see promptDepthLevels, within their topic preferences.
+func (s *AnkyService) GenerateFramesgivingNextWritingPrompt(session *utils.WritingSession, depth string, topics *types.PromptTopics) (string, error) {
+	log.Println("🚀 Starting to generate next writing prompt")
+
+	// Create LLM service to analyze writing and generate prompt
+	log.Println("🤖 Creating new LLM service")
+	llmService := NewLLMService()
+
+	// Build system prompt focused on gratitude exploration
+	log.Printf("📝 Building system prompt for gratitude exploration at %s depth", depth)
+	systemPrompt := framesgivingPromptPersona + "\n\n" + promptDepthGuidance(depth)
+	if guidance := promptTopicGuidance(topics); guidance != "" {
+		systemPrompt += "\n\n" + guidance
+	}
+
 	// Create chat request with system instructions and user's writing
 	log.Println("🔧 Creating chat request with system instructions and user content")
 	chatRequest := types.ChatRequest{
@@ -325,46 +301,34 @@ Important: Do not make any explanations to your reply. Just reply with the inqui
 
 func (s *AnkyService) ReflectBackFromWritingSessionConversation(pastSessions []string, sessionLongString string) (string, error) {
 
-	// Split the session string into lines
-	fmt.Printf("sessionLongString is: %v\n", sessionLongString)
-	lines := strings.Split(sessionLongString, "\n")
-	fmt.Printf("lines are: %v\n", lines)
-
-	// Initialize session struct to store parsed data
-	session := []string{}
-
-	if len(lines) < 4 {
-		return "", fmt.Errorf("invalid session data: insufficient lines")
-	}
-
-	// Extract metadata from first 4 lines
-	writingSessionID := lines[0]
-	ankyUserID := lines[1]
-	writingPrompt := lines[2]
-	sessionTimestamp, err := strconv.ParseInt(lines[3], 10, 64)
+	log.Printf("sessionLongString is: %s", logging.Content(sessionLongString))
+	header, lines, err := utils.ParseSessionHeader(sessionLongString)
 	if err != nil {
-		return "", fmt.Errorf("invalid timestamp format: %v", err)
+		return "", fmt.Errorf("invalid session data: %w", err)
+	}
+	if header.StartedAt.IsZero() {
+		return "", fmt.Errorf("invalid timestamp format: %q", header.Timestamp)
 	}
 
-	// Process keystrokes starting from line 4
-	for i := 4; i < len(lines); i++ {
-		if strings.TrimSpace(lines[i]) != "" {
-			session = append(session, lines[i])
+	keystrokes := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			keystrokes++
 		}
 	}
 
-	fmt.Printf("📝 Parsed writing session metadata:\n")
-	fmt.Printf("Session ID: %s\n", writingSessionID)
-	fmt.Printf("User ID: %s\n", ankyUserID)
-	fmt.Printf("Prompt: %s\n", writingPrompt)
-	fmt.Printf("Timestamp: %d\n", sessionTimestamp)
-	fmt.Printf("Keystrokes: %d\n", len(session))
+	log.Printf("📝 Parsed writing session metadata (format v%d):", header.FormatVersion)
+	log.Printf("Session ID: %s", header.SessionID)
+	log.Printf("User ID: %s", header.UserID)
+	log.Printf("Prompt: %s", header.Prompt)
+	log.Printf("Timestamp: %d", header.StartedAt.UnixMilli())
+	log.Printf("Keystrokes: %d", keystrokes)
 
-	fmt.Println("🤖 Creating new LLM service to process the writing...")
+	log.Println("🤖 Creating new LLM service to process the writing...")
 	llmService := NewLLMService()
-	fmt.Println("✅ LLM service created successfully")
+	log.Println("✅ LLM service created successfully")
 
-	fmt.Println("📋 Setting up the initial chat request with system instructions")
+	log.Println("📋 Setting up the initial chat request with system instructions")
 	chatRequest := types.ChatRequest{
 		Messages: []types.Message{
 			{
@@ -387,54 +351,54 @@ func (s *AnkyService) ReflectBackFromWritingSessionConversation(pastSessions []s
 		},
 	}
 
-	fmt.Println("🔄 Starting to process each message in the conversation...")
+	log.Println("🔄 Starting to process each message in the conversation...")
 	for i, content := range pastSessions {
-		fmt.Printf("📌 Processing message #%d\n", i+1)
+		log.Printf("📌 Processing message #%d", i+1)
 		if i%2 == 0 {
-			fmt.Println("👤 Adding assistant message to chat")
+			log.Println("👤 Adding assistant message to chat")
 			chatRequest.Messages = append(chatRequest.Messages, types.Message{
 				Role:    "assistant",
 				Content: content,
 			})
 		} else {
-			fmt.Println("✍️ Processing user's writing session...")
+			log.Println("✍️ Processing user's writing session...")
 			writingSession, err := utils.ParseWritingSession(content)
 			if err != nil {
-				fmt.Printf("❌ Error parsing writing session: %v\n", err)
+				log.Printf("❌ Error parsing writing session: %v", err)
 				return "", err
 			}
 
 			minutes := len(writingSession.KeyStrokes) / 60
-			fmt.Printf("⏱️ User wrote for %d minutes\n", minutes)
+			log.Printf("⏱️ User wrote for %d minutes", minutes)
 
 			contextMsg := fmt.Sprintf("The user wrote for %d minutes. Here is their writing: %s",
 				minutes,
 				writingSession.RawContent)
 
-			fmt.Println("📤 Adding user's writing to chat context")
+			log.Println("📤 Adding user's writing to chat context")
 			chatRequest.Messages = append(chatRequest.Messages, types.Message{
 				Role:    "user",
 				Content: contextMsg,
 			})
 		}
-		fmt.Printf("✅ Successfully processed message #%d\n", i+1)
+		log.Printf("✅ Successfully processed message #%d", i+1)
 	}
 
-	fmt.Println("🚀 Sending chat request to LLM service...")
+	log.Println("🚀 Sending chat request to LLM service...")
 	responseChan, err := llmService.SendChatRequest(chatRequest, false)
 	if err != nil {
-		fmt.Printf("❌ Error sending chat request: %v\n", err)
+		log.Printf("❌ Error sending chat request: %v", err)
 		return "", err
 	}
 
-	fmt.Println("📥 Collecting response from LLM...")
+	log.Println("📥 Collecting response from LLM...")
 	var fullResponse string
 	for response := range responseChan {
 		fullResponse += response
-		fmt.Println("💬 Received response chunk:", response)
+		log.Println("💬 Received response chunk:", logging.Content(response))
 	}
 
-	fmt.Printf("🎉 Completed reflection! Response length: %d characters\n", len(fullResponse))
+	log.Printf("🎉 Completed reflection! Response length: %d characters", len(fullResponse))
 	return fullResponse, nil
 }
 
@@ -444,17 +408,25 @@ type AnkyProcessingResponse struct {
 	image_ipfs_hash    string
 	token_name         string
 	ticker             string
+	images             []*types.AnkyImage
 }
 
-func (s *AnkyService) TriggerAnkyMintingProcess(writing_long_string string, fid string) error {
+func (s *AnkyService) TriggerAnkyMintingProcess(ctx context.Context, writing_long_string string, fid string, license string) error {
 	log.Println("🚀 Starting Anky minting process...")
 	log.Printf("📝 Processing writing session for FID: %s", fid)
 
+	var sessionID string
+	if header, _, err := utils.ParseSessionHeader(writing_long_string); err == nil {
+		sessionID = header.SessionID
+	}
+	publishAnkyStatus(sessionID, "starting_processing", "")
+
 	// Generate reflection and metadata using LLM
 	log.Println("🤖 Generating reflection from writing content...")
-	response, err := s.GenerateAnkyReflectionFromRawString(writing_long_string)
+	response, err := s.GenerateAnkyReflectionFromRawString(ctx, writing_long_string, license)
 	if err != nil {
 		log.Printf("❌ Error generating reflection: %v", err)
+		publishAnkyStatus(sessionID, "failed", err.Error())
 		return fmt.Errorf("error generating reflection: %v", err)
 	}
 
@@ -465,28 +437,13 @@ func (s *AnkyService) TriggerAnkyMintingProcess(writing_long_string string, fid
 	log.Printf("🏷️ Token name: %s", response.token_name)
 	log.Printf("💫 Ticker: %s", response.ticker)
 
+	publishAnkyStatus(sessionID, "completed", "")
 	log.Println("✅ Anky minting process completed successfully")
 	return nil
 }
 
-func (s *AnkyService) GenerateAnkyReflectionFromRawString(writing string) (*AnkyProcessingResponse, error) {
-	log.Println("🚀 Starting integrated LLM processing chain for writing")
-
-	parsedSession, err := utils.ParseWritingSession(writing)
-	if err != nil {
-		log.Printf("❌ Error parsing writing session: %v", err)
-		return nil, fmt.Errorf("error parsing writing session: %v", err)
-	}
-
-	llmService := NewLLMService()
-
-	// Step 1: Generate reflection story
-	log.Println("📖 Step 1: Generating reflection story...")
-	storyRequest := types.ChatRequest{
-		Messages: []types.Message{
-			{
-				Role: "system",
-				Content: `You are a master storyteller who transforms personal writing into powerful, meaningful narratives.
+// reflectionStoryPersona turns a writing session into the story the user receives back.
+const reflectionStoryPersona = `You are a master storyteller who transforms personal writing into powerful, meaningful narratives.
 
 Your task is to generate a short story (max one page) that:
 - Finds the constructive core message in ANY input, no matter how challenging
@@ -506,23 +463,161 @@ If the input contains concerning content:
 
 The story's protagonist is Anky, a curious little girl who explores the world with wonder and helps others find light in darkness. Use her character to create emotional distance and safety when needed.
 
-Format: Deliver only the story - make every word count and keep the energy focused on growth and possibility.`,
+Format: Deliver only the story - make every word count and keep the energy focused on growth and possibility.`
+
+func (s *AnkyService) GenerateAnkyReflectionFromRawString(ctx context.Context, writing string, license string) (*AnkyProcessingResponse, error) {
+	log.Println("🚀 Starting integrated LLM processing chain for writing")
+
+	parsedSession, err := utils.ParseWritingSession(writing)
+	if err != nil {
+		log.Printf("❌ Error parsing writing session: %v", err)
+		return nil, fmt.Errorf("error parsing writing session: %v", err)
+	}
+	if parsedSession.Paste.Flagged {
+		return nil, ErrPastedWriting
+	}
+	if parsedSession.Cheat.Suspect {
+		return nil, ErrSuspectWriting
+	}
+
+	llmService := NewLLMService()
+
+	story, err := s.generateReflectionStory(llmService, parsedSession)
+	if err != nil {
+		return nil, err
+	}
+	imagePrompt, err := s.generateImagePrompt(llmService, story)
+	if err != nil {
+		return nil, err
+	}
+	tokenName, ticker, err := s.generateTokenIdentity(llmService, story, imagePrompt, parsedSession.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate outputs
+	log.Println("✅ Validating outputs...")
+	if err := validateOutputs(story, imagePrompt, tokenName, ticker); err != nil {
+		log.Printf("❌ Validation error: %v", err)
+		return nil, fmt.Errorf("validation error: %v", err)
+	}
+
+	log.Println("🎉 Successfully generated all components!")
+
+	// Image generation is the slow part, don't start it during a shutdown
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Deep dives get a triptych, its first panel doubles as the main image
+	var collection []*types.AnkyImage
+	if isDeepDive(parsedSession) {
+		log.Printf("🖼️ Deep-dive session (%s), generating a triptych", parsedSession.Duration().Round(time.Second))
+		collection, err = s.generateAnkyCollection(ctx, llmService, parsedSession.SessionID, story, imagePrompt, defaultImageStyle)
+		if err != nil {
+			log.Printf("⚠️ Could not generate triptych, falling back to a single image: %v", err)
+			collection = nil
+		}
+	}
+
+	var imageURL string
+	if len(collection) > 0 {
+		imageURL = collection[0].ImageURL
+	} else {
+		imageURL, err = generateAnkyImageURL(ctx, defaultImageStyle, imagePrompt)
+		if err != nil {
+			log.Printf("❌ Error generating Anky image: %v", err)
+			return nil, fmt.Errorf("error generating Anky image: %v", err)
+		}
+	}
+
+	metadata := &FramesAnkyMetadata{
+		TokenName: tokenName,
+		Ticker:    ticker,
+		Number:    "0",
+		Story:     story,
+		License:   license,
+	}
+
+	var ankyImageIpfsHash string
+	if len(collection) > 0 && collection[0].ImageIPFSHash != "" {
+		ankyImageIpfsHash = collection[0].ImageIPFSHash
+	} else {
+		var pinataService *PinataService
+		pinataService, err = NewPinataService(s.store)
+		if err == nil {
+			ankyImageIpfsHash, err = pinataService.UploadImageFromURL(imageURL)
+		}
+	}
+	if err != nil {
+		// Keep the NFT resolvable until the storage repair job manages to pin
+		// it, or for good on servers without Pinata
+		log.Printf("⚠️ Pinning failed for session %s, falling back to data URI metadata: %v", parsedSession.SessionID, err)
+		metadataURI, metadataErr := degradedMetadataURI(tokenName, ticker, story, imageURL, license)
+		if metadataErr != nil {
+			log.Printf("❌ Error building fallback metadata: %v", metadataErr)
+			return nil, fmt.Errorf("error uploading image to Pinata: %v", err)
+		}
+		metadata.ImageURL = imageURL
+		metadata.MetadataURI = metadataURI
+	} else {
+		log.Printf("🖼️ Generated Anky image hash: %s", ankyImageIpfsHash)
+		metadata.IPFSHash = ankyImageIpfsHash
+	}
+
+	// Update NFT metadata
+	if err := WriteFramesAnkyMetadata(parsedSession.SessionID, metadata); err != nil {
+		log.Printf("❌ Error writing metadata file: %v", err)
+		return nil, err
+	}
+	log.Printf("📄 Metadata written to: %s", framesMetadataPath(parsedSession.SessionID))
+
+	if len(collection) > 0 {
+		if err := WriteFramesAnkyImages(parsedSession.SessionID, collection); err != nil {
+			log.Printf("⚠️ Error writing collection file: %v", err)
+		}
+	}
+
+	return &AnkyProcessingResponse{
+		reflection_to_user: story,
+		image_ipfs_hash:    ankyImageIpfsHash,
+		token_name:         tokenName,
+		ticker:             ticker,
+		images:             collection,
+	}, nil
+}
+
+// generateReflectionStory writes the story the user receives back.
+func (s *AnkyService) generateReflectionStory(llmService *LLMService, parsedSession *utils.WritingSession) (string, error) {
+	log.Println("📖 Step 1: Generating reflection story...")
+	storyRequest := types.ChatRequest{
+		Messages: []types.Message{
+			{
+				Role:    "system",
+				Content: reflectionStoryPersona,
 			},
 			{
 				Role:    "user",
 				Content: parsedSession.RawContent,
 			},
+			{
+				Role:    "user",
+				Content: "About the rhythm of this writing (weave it in naturally if it fits, never as a number): " + utils.DescribeFocus(parsedSession.Focus),
+			},
 		},
 	}
 
 	story, err := s.processChatRequest(llmService, storyRequest)
 	if err != nil {
 		log.Printf("❌ Error generating story: %v", err)
-		return nil, fmt.Errorf("error generating story: %v", err)
+		return "", fmt.Errorf("error generating story: %v", err)
 	}
 	log.Printf("✨ Generated reflection story: %s", story)
+	return s.matchWritingLanguage(llmService, storyRequest, parsedSession.RawContent, story), nil
+}
 
-	// Step 2: Generate image description
+// generateImagePrompt describes the image that illustrates the story.
+func (s *AnkyService) generateImagePrompt(llmService *LLMService, story string) (string, error) {
 	log.Println("🎨 Step 2: Generating image description...")
 	imageRequest := types.ChatRequest{
 		Messages: []types.Message{
@@ -565,10 +660,15 @@ Format: Provide only the image prompt, no additional context or explanation.`,
 	imagePrompt, err := s.processChatRequest(llmService, imageRequest)
 	if err != nil {
 		log.Printf("❌ Error generating image prompt: %v", err)
-		return nil, fmt.Errorf("error generating image prompt: %v", err)
+		return "", fmt.Errorf("error generating image prompt: %v", err)
 	}
 	log.Printf("🖼️ Generated image prompt: %s", imagePrompt)
-	// Step 3: Generate token name
+	return imagePrompt, nil
+}
+
+// generateTokenIdentity names the token clanker deploys for the Anky and
+// picks its ticker, transliterated to ASCII.
+func (s *AnkyService) generateTokenIdentity(llmService *LLMService, story string, imagePrompt string, sessionID string) (string, string, error) {
 	log.Println("🏷️ Step 3: Generating token name...")
 	tokenRequest := types.ChatRequest{
 		Messages: []types.Message{
@@ -610,7 +710,7 @@ Example good token names:
 	tokenName, err := s.processChatRequest(llmService, tokenRequest)
 	if err != nil {
 		log.Printf("❌ Error generating token name: %v", err)
-		return nil, fmt.Errorf("error generating token name: %v", err)
+		return "", "", fmt.Errorf("error generating token name: %v", err)
 	}
 	log.Printf("💫 Generated token name: %s", tokenName)
 
@@ -655,61 +755,33 @@ Example good tickers:
 	ticker, err := s.processChatRequest(llmService, tickerRequest)
 	if err != nil {
 		log.Printf("❌ Error generating ticker: %v", err)
-		return nil, fmt.Errorf("error generating ticker: %v", err)
+		return "", "", fmt.Errorf("error generating ticker: %v", err)
 	}
 	log.Printf("🎯 Generated ticker symbol: %s", ticker)
 
-	// Validate outputs
-	log.Println("✅ Validating outputs...")
-	if err := validateOutputs(story, imagePrompt, tokenName, ticker); err != nil {
-		log.Printf("❌ Validation error: %v", err)
-		return nil, fmt.Errorf("validation error: %v", err)
-	}
-
-	log.Println("🎉 Successfully generated all components!")
-
-	ankyImageIpfsHash, err := s.GenerateAnkyFromPrompt(imagePrompt)
-	if err != nil {
-		log.Printf("❌ Error generating Anky image: %v", err)
-		return nil, fmt.Errorf("error generating Anky image: %v", err)
-	}
-	log.Printf("🖼️ Generated Anky image hash: %s", ankyImageIpfsHash)
-
-	// Update NFT metadata
-	// Create metadata string in required format
-	metadataContent := fmt.Sprintf("%s\n%s\n%d\n%s\n%s", tokenName, ticker, 0000000, story, ankyImageIpfsHash)
-
-	// Create directory if it doesn't exist
-	err = os.MkdirAll("data/framesgiving/ankys", 0755)
-	if err != nil {
-		log.Printf("❌ Error creating directory: %v", err)
-		return nil, fmt.Errorf("error creating directory: %v", err)
-	}
-
-	// Write metadata to file
-	filename := fmt.Sprintf("data/framesgiving/ankys/%s.txt", parsedSession.SessionID)
-	err = os.WriteFile(filename, []byte(metadataContent), 0644)
-	if err != nil {
-		log.Printf("❌ Error writing metadata file: %v", err)
-		return nil, fmt.Errorf("error writing metadata file: %v", err)
-	}
-	log.Printf("📄 Metadata written to: %s", filename)
-
-	return &AnkyProcessingResponse{
-		reflection_to_user: story,
-		image_ipfs_hash:    ankyImageIpfsHash,
-		token_name:         tokenName,
-		ticker:             ticker,
-	}, nil
+	// Markets only accept ASCII tickers, transliterate whatever the LLM produced
+	ticker = utils.SanitizeTicker(ticker, sessionID)
+	log.Printf("🔤 Sanitized ticker symbol: %s", ticker)
+	return tokenName, ticker, nil
 }
 
 // Helper function to validate outputs
 func validateOutputs(story, imagePrompt, tokenName, ticker string) error {
+	if err := validateStory(story); err != nil {
+		return err
+	}
+	return validateTokenIdentity(tokenName, ticker)
+}
+
+func validateStory(story string) error {
 	// Validate story length (approximate one page ~ 3000 characters)
 	if len(story) > 3000 {
 		return fmt.Errorf("story exceeds maximum length")
 	}
+	return nil
+}
 
+func validateTokenIdentity(tokenName, ticker string) error {
 	// Validate token name has exactly three words
 	words := strings.Fields(tokenName)
 	if len(words) != 3 {
@@ -717,11 +789,11 @@ func validateOutputs(story, imagePrompt, tokenName, ticker string) error {
 	}
 
 	// Validate ticker length and format
-	if len(ticker) > 24 {
-		return fmt.Errorf("ticker exceeds 24 characters")
+	if len(ticker) > utils.MaxTickerLength {
+		return fmt.Errorf("ticker exceeds %d characters", utils.MaxTickerLength)
 	}
-	if ticker != strings.ToUpper(ticker) {
-		return fmt.Errorf("ticker must be uppercase")
+	if !utils.ValidTicker(ticker) {
+		return fmt.Errorf("ticker must be uppercase ASCII letters and digits")
 	}
 
 	return nil
@@ -786,198 +858,53 @@ func (s *AnkyService) MessagesPromptRequest(messages []string) (string, error) {
 	return fullResponse, nil
 }
 
-func generateImageWithMidjourney(prompt string) (string, error) {
-	data := map[string]interface{}{
-		"prompt": prompt,
-	}
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling data: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", "http://localhost:8055/items/images/", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("IMAGINE_API_TOKEN"))
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var responseData struct {
-		Data struct {
-			ID string `json:"id"`
-		} `json:"data"`
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&responseData)
-	if err != nil {
-		return "", fmt.Errorf("error decoding response: %v", err)
-	}
-
-	return responseData.Data.ID, nil
-}
-
-func pollImageStatus(id string) (string, error) {
-	fmt.Println("Starting pollImageStatus for id:", id)
-	for {
-		fmt.Println("Checking image status for id:", id)
-		status, err := checkImageStatus(id)
-		if err != nil {
-			fmt.Println("Error checking image status:", err)
-			return "", err
-		}
-
-		fmt.Println("Current status for id", id, ":", status)
-
-		if status == "completed" {
-			fmt.Println("Image generation completed for id:", id)
-			return status, nil
-		}
-
-		if status == "failed" {
-			fmt.Println("Image generation failed for id:", id)
-			return status, fmt.Errorf("image generation failed")
-		}
-
-		fmt.Println("Waiting 5 seconds before next status check for id:", id)
-		time.Sleep(5 * time.Second)
-	}
-}
-
-func checkImageStatus(id string) (string, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:8055/items/images/%s", id), nil)
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("IMAGINE_API_TOKEN"))
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var responseData struct {
-		Data struct {
-			Status string `json:"status"`
-			URL    string `json:"url"`
-		} `json:"data"`
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&responseData)
-	if err != nil {
-		return "", fmt.Errorf("error decoding response: %v", err)
-	}
-
-	return responseData.Data.Status, nil
-}
+func (s *AnkyService) GenerateAnkyFromPrompt(ctx context.Context, prompt string) (string, error) {
+	log.Println("Starting GenerateAnkyFromPrompt service")
 
-func fetchImageDetails(id string) (*ImageDetails, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:8055/items/images/%s", id), nil)
+	imageURL, err := generateAnkyImageURL(ctx, defaultImageStyle, prompt)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("IMAGINE_API_TOKEN"))
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	pinataService, err := NewPinataService(s.store)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var responseData struct {
-		Data ImageDetails `json:"data"`
+		log.Printf("❌ Error creating Pinata service: %v", err)
+		return "", fmt.Errorf("error creating Pinata service: %v", err)
 	}
-
-	err = json.NewDecoder(resp.Body).Decode(&responseData)
+	ipfsHash, err := pinataService.UploadImageFromURL(imageURL)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding response: %v", err)
+		log.Printf("❌ Error uploading image to Pinata: %v", err)
+		return "", fmt.Errorf("error uploading image to Pinata: %v", err)
 	}
 
-	return &responseData.Data, nil
-}
-
-type ImageDetails struct {
-	Status       string   `json:"status"`
-	URL          string   `json:"url"`
-	UpscaledURLs []string `json:"upscaled_urls"`
+	return ipfsHash, nil
 }
 
-func (s *AnkyService) GenerateAnkyFromPrompt(prompt string) (string, error) {
-	log.Println("Starting GenerateAnkyFromPrompt service")
-
-	// Generate image using Midjourney
-	log.Println("Generating image with Midjourney")
-	imageID, err := generateImageWithMidjourney("https://s.mj.run/YLJMlMJbo70 " + prompt)
+// generateAnkyImageURL generates the image in the given style with the
+// configured backend and returns its Cloudinary URL.
+func generateAnkyImageURL(ctx context.Context, style imageStyle, prompt string) (string, error) {
+	job, err := generateImages(ctx, style.prompt(prompt))
 	if err != nil {
 		log.Printf("Failed to generate image: %v", err)
-		return "", fmt.Errorf("failed to generate image: %v", err)
+		return "", fmt.Errorf("failed to generate image: %w", err)
 	}
-	log.Printf("Generated image ID: %s", imageID)
-
-	// Poll for image completion
-	log.Println("Polling for image completion")
-	status, err := pollImageStatus(imageID)
-	if err != nil {
-		log.Printf("Error polling image status: %v", err)
-		return "", fmt.Errorf("error polling image status: %v", err)
-	}
-	log.Printf("Image status: %s", status)
-
-	if status != "completed" {
-		log.Println("Image generation failed")
-		return "", fmt.Errorf("image generation failed")
-	}
-
-	// Fetch final image details
-	log.Println("Fetching image details")
-	imageDetails, err := fetchImageDetails(imageID)
-	if err != nil {
-		log.Printf("Error fetching image details: %v", err)
-		return "", fmt.Errorf("error fetching image details: %v", err)
-	}
-	log.Printf("Retrieved image URL: %s", imageDetails.URL)
+	log.Printf("Retrieved image URL: %s", job.URL)
 
 	// Upload to Cloudinary
 	log.Println("Uploading to Cloudinary")
 	imageHandler, err := NewImageService()
 	if err != nil {
 		log.Printf("Error creating ImageHandler: %v", err)
-		return "", fmt.Errorf("error creating ImageHandler: %v", err)
+		return "", fmt.Errorf("error creating ImageHandler: %w", err)
 	}
-	uploadResult, err := uploadImageToCloudinary(imageHandler, imageDetails.URL, uuid.New().String())
+	uploadResult, err := uploadImageToCloudinary(imageHandler, job.URL, uuid.New().String())
 	if err != nil {
 		log.Printf("Error uploading to Cloudinary: %v", err)
 		return "", fmt.Errorf("error uploading to Cloudinary: %v", err)
 	}
 	log.Printf("Successfully uploaded to Cloudinary. URL: %s", uploadResult.SecureURL)
 
-	pinataService, err := NewPinataService()
-	if err != nil {
-		log.Printf("❌ Error creating Pinata service: %v", err)
-		return "", fmt.Errorf("error creating Pinata service: %v", err)
-	}
-	ipfsHash, err := pinataService.UploadImageFromURL(uploadResult.SecureURL)
-	if err != nil {
-		log.Printf("❌ Error uploading image to Pinata: %v", err)
-		return "", fmt.Errorf("error uploading image to Pinata: %v", err)
-	}
-
-	return ipfsHash, nil
+	return uploadResult.SecureURL, nil
 }
 
 func (s *AnkyService) EditCast(ctx context.Context, text string, userFid int) (string, error) {
@@ -985,6 +912,26 @@ func (s *AnkyService) EditCast(ctx context.Context, text string, userFid int) (s
 	return "", nil
 }
 
+// GenerateCastReply writes Anky's answer to a cast addressed to it, short
+// enough to fit in a cast.
+func (s *AnkyService) GenerateCastReply(ctx context.Context, authorUsername string, text string) (string, error) {
+	systemPrompt := `You are Anky, a companion for daily stream-of-consciousness writing, talking with people on Farcaster. Answer the cast you are given warmly and briefly, in the language it is written in, in at most 280 characters. Invite inquiry rather than giving advice, never use hashtags and reply only with the text of your cast.`
+	request := types.ChatRequest{
+		Messages: []types.Message{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: fmt.Sprintf("@%s wrote: %s", authorUsername, text)},
+		},
+	}
+	reply, err := s.processChatRequest(NewLLMService(), request)
+	if err != nil {
+		return "", fmt.Errorf("error generating cast reply: %w", err)
+	}
+	if reply == "" {
+		return "", fmt.Errorf("the LLM returned an empty cast reply")
+	}
+	return reply, nil
+}
+
 func (s *AnkyService) OnboardingConversation(ctx context.Context, userId uuid.UUID, sessions []*types.WritingSession, ankyReflections []string) (string, error) {
 	log.Printf("Starting onboarding conversation for attempt #%d", len(sessions))
 
@@ -1017,6 +964,12 @@ Key Guidelines:
 
 Remember: Your response will be the only feedback they see after their writing session. Make it meaningful and motivating. Make it short and concise, less than 88 characters.`
 
+	if user, err := s.store.GetUserByID(ctx, userId); err != nil {
+		log.Printf("⚠️ Could not get topic preferences of user %s: %v", userId, err)
+	} else if guidance := promptTopicGuidance(user.PreferredPromptTopics()); guidance != "" {
+		systemPrompt += "\n\n" + guidance
+	}
+
 	// Build conversation history with progression context
 	messages := []types.Message{
 		{
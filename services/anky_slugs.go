@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+// Attempts at drawing a number no other Anky with the same name has
+const ankySlugAttempts = 5
+
+var ErrSlugsTaken = errors.New("could not draw a free slug")
+
+// AssignAnkySlug names the Anky of the session after its token name, with a
+// random four digit number so Ankys sharing a name stay apart:
+// wisdom-light-dancing-0421. An Anky keeps the first slug it gets, so share
+// links never break; that slug is returned.
+func AssignAnkySlug(ctx context.Context, store *storage.PostgresStore, sessionID uuid.UUID, tokenName string) (string, error) {
+	base := utils.SlugBase(tokenName)
+	for attempt := 0; attempt < ankySlugAttempts; attempt++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(10_000))
+		if err != nil {
+			return "", fmt.Errorf("error drawing slug number: %w", err)
+		}
+		slug, err := store.CreateAnkySlug(ctx, sessionID, fmt.Sprintf("%s-%04d", base, n.Int64()))
+		if errors.Is(err, storage.ErrSlugTaken) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return slug, nil
+	}
+	return "", ErrSlugsTaken
+}
+
+// assignSlug gives the Anky of the pipeline run its slug. Share links fall
+// back to the session ID, so failing to get one doesn't fail the pipeline.
+func (s *AnkyService) assignSlug(ctx context.Context, run *ankyPipelineRun) {
+	sessionID, err := uuid.Parse(run.sessionID)
+	if err != nil {
+		return
+	}
+	slug, err := AssignAnkySlug(ctx, s.store, sessionID, run.anky.TokenName)
+	if err != nil {
+		log.Printf("⚠️ Could not give session %s a slug: %v", run.sessionID, err)
+		return
+	}
+	log.Printf("🔗 Session %s is now %s", run.sessionID, slug)
+}
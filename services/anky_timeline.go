@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// recordAnkyStatusEvent appends an entry to the Anky's status timeline. The
+// timeline is informational, so failures are logged instead of aborting the
+// pipeline. Events that change what the public routes serve purge the Anky
+// from the CDN.
+func (s *AnkyService) recordAnkyStatusEvent(ctx context.Context, ankyID uuid.UUID, status string, detail string) {
+	if ankyID == uuid.Nil {
+		return
+	}
+	err := s.store.CreateAnkyStatusEvent(ctx, &types.AnkyStatusEvent{
+		AnkyID: ankyID,
+		Status: status,
+		Detail: detail,
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to record status event %s for anky %s: %v", status, ankyID, err)
+	}
+	if cdnPurgeEvents[status] {
+		queueAnkyPurge(ankyID)
+	}
+}
+
+// AnkyStatusUpdate is a step of the pipeline that turns a writing session into an Anky.
+type AnkyStatusUpdate struct {
+	SessionID string `json:"session_id"`
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+var statusSubscribers struct {
+	mu  sync.RWMutex
+	fns []func(AnkyStatusUpdate)
+}
+
+// SubscribeAnkyStatus calls fn with every status update of the pipeline. fn
+// runs on the pipeline's goroutine, so it must not block.
+func SubscribeAnkyStatus(fn func(AnkyStatusUpdate)) {
+	statusSubscribers.mu.Lock()
+	defer statusSubscribers.mu.Unlock()
+	statusSubscribers.fns = append(statusSubscribers.fns, fn)
+}
+
+func publishAnkyStatus(sessionID string, status string, detail string) {
+	if sessionID == "" {
+		return
+	}
+	update := AnkyStatusUpdate{SessionID: sessionID, Status: status, Detail: detail}
+
+	statusSubscribers.mu.RLock()
+	defer statusSubscribers.mu.RUnlock()
+	for _, fn := range statusSubscribers.fns {
+		fn(update)
+	}
+}
+
+// setAnkyStatus stores the Anky's new status and lets the writer know about
+// it. It fails once ctx is done, so the pipeline stops between steps when
+// the server shuts down.
+func (s *AnkyService) setAnkyStatus(ctx context.Context, anky *types.Anky, sessionID string, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := s.store.Clock().Now().UTC()
+	if anky.Status != "" && !anky.LastUpdatedAt.IsZero() {
+		ankyPipelineStageDuration.Observe(now.Sub(anky.LastUpdatedAt).Seconds(), anky.Status)
+	}
+	ankyPipelineStages.Inc(status)
+	anky.Status = status
+	anky.LastUpdatedAt = now
+	if err := s.store.UpdateAnky(ctx, anky); errors.Is(err, storage.ErrVersionConflict) {
+		log.Printf("⚠️ Anky %s changed while its pipeline ran, status %s not stored: %v", anky.ID, status, err)
+	}
+	publishAnkyStatus(sessionID, status, "")
+	return nil
+}
+
+// uploadProgressRecorder returns an UploadProgressFunc that adds a timeline
+// entry every time the upload crosses another quarter of the file, and sends
+// it to the writer with the live status updates. The stored timeline is
+// served by GET /ankys/{id}/status.
+func (s *AnkyService) uploadProgressRecorder(ctx context.Context, anky *types.Anky, status string) UploadProgressFunc {
+	var mu sync.Mutex
+	lastQuarter := -1
+
+	return func(uploaded int64, total int64) {
+		if total <= 0 {
+			return
+		}
+		quarter := int(uploaded * 4 / total)
+
+		mu.Lock()
+		if quarter <= lastQuarter {
+			mu.Unlock()
+			return
+		}
+		lastQuarter = quarter
+		mu.Unlock()
+
+		detail := fmt.Sprintf("%d%% (%d/%d bytes)", quarter*25, uploaded, total)
+		s.recordAnkyStatusEvent(ctx, anky.ID, status, detail)
+		publishAnkyStatus(anky.WritingSessionID.String(), status, detail)
+	}
+}
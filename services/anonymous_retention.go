@@ -0,0 +1,193 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+const (
+	anonymousPruneBatch       = 500
+	defaultAnonymousRetention = 90 * 24 * time.Hour
+)
+
+// AnonymousRetentionService prunes the sessions everyone writes without an
+// account, which all pile up under the zero UUID. Sessions older than
+// ANONYMOUS_RETENTION_DAYS are deleted with their Ankys, or first written to
+// the archive as gzipped JSON bundles when ANONYMOUS_RETENTION_ACTION is
+// archive. The job only runs when ANONYMOUS_RETENTION_DAYS is set.
+type AnonymousRetentionService struct {
+	store   *storage.PostgresStore
+	archive *SessionArchiveService
+	maxAge  time.Duration
+	action  string
+	enabled bool
+}
+
+func NewAnonymousRetentionService(store *storage.PostgresStore, archive *SessionArchiveService) *AnonymousRetentionService {
+	s := &AnonymousRetentionService{store: store, archive: archive, maxAge: defaultAnonymousRetention}
+	if days, err := strconv.Atoi(os.Getenv("ANONYMOUS_RETENTION_DAYS")); err == nil && days > 0 {
+		s.maxAge = time.Duration(days) * 24 * time.Hour
+		s.enabled = true
+	}
+	switch action := os.Getenv("ANONYMOUS_RETENTION_ACTION"); action {
+	case types.AnonymousRetentionDelete, types.AnonymousRetentionArchive:
+		s.action = action
+	case "":
+		s.action = types.AnonymousRetentionDelete
+	default:
+		log.Printf("⚠️ Unknown ANONYMOUS_RETENTION_ACTION %q, deleting old anonymous sessions", action)
+		s.action = types.AnonymousRetentionDelete
+	}
+	return s
+}
+
+// StartPruneJob blocks, pruning old anonymous sessions every interval. It
+// returns right away when no retention is configured.
+func (s *AnonymousRetentionService) StartPruneJob(ctx context.Context, interval time.Duration) {
+	if !s.enabled {
+		log.Println("⚠️ ANONYMOUS_RETENTION_DAYS is not set, anonymous sessions are kept")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Prune(ctx, s.maxAge, false); err != nil {
+				log.Printf("❌ Error pruning anonymous sessions: %v", err)
+			}
+		}
+	}
+}
+
+// Prune removes the anonymous sessions older than maxAge and reports what
+// went. A dry run only reports what would go.
+func (s *AnonymousRetentionService) Prune(ctx context.Context, maxAge time.Duration, dryRun bool) (*types.AnonymousPruneReport, error) {
+	now := s.store.Clock().Now().UTC()
+	report := &types.AnonymousPruneReport{
+		StartedBefore: now.Add(-maxAge),
+		Action:        s.action,
+		DryRun:        dryRun,
+		StartedAt:     now,
+	}
+	defer func() { report.FinishedAt = s.store.Clock().Now().UTC() }()
+
+	if dryRun {
+		var err error
+		report.Sessions, report.Ankys, report.OldestSession, err = s.store.CountAnonymousSessions(ctx, report.StartedBefore)
+		return report, err
+	}
+	if s.action == types.AnonymousRetentionArchive && s.archive.blobs == nil {
+		return nil, ErrArchiveDisabled
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		sessions, err := s.store.GetAnonymousSessions(ctx, report.StartedBefore, anonymousPruneBatch)
+		if err != nil {
+			return report, err
+		}
+		if len(sessions) == 0 {
+			break
+		}
+		if report.OldestSession == nil {
+			report.OldestSession = &sessions[0].StartingTimestamp
+		}
+
+		if s.action == types.AnonymousRetentionArchive {
+			key, err := s.archiveBundle(ctx, report.StartedBefore, sessions)
+			if err != nil {
+				return report, err
+			}
+			report.ArchiveKeys = append(report.ArchiveKeys, key)
+		}
+
+		ids := make([]uuid.UUID, 0, len(sessions))
+		archiveKeys := make([]string, 0)
+		for _, session := range sessions {
+			ids = append(ids, session.ID)
+			if session.ArchiveKey != nil {
+				archiveKeys = append(archiveKeys, *session.ArchiveKey)
+			}
+		}
+		ankys, err := s.store.DeleteAnonymousSessions(ctx, ids)
+		if err != nil {
+			return report, err
+		}
+		s.archive.DeleteArchives(ctx, archiveKeys)
+		removeSessionFiles(ids)
+		report.Sessions += len(ids)
+		report.Ankys += ankys
+	}
+
+	if report.Sessions > 0 {
+		log.Printf("🧹 Pruned %d anonymous sessions and %d ankys that started before %s (%s)",
+			report.Sessions, report.Ankys, report.StartedBefore.Format(time.RFC3339), s.action)
+	}
+	return report, nil
+}
+
+// archiveBundle writes the sessions, with their writing, to one gzipped JSON
+// bundle in the archive and returns its key.
+func (s *AnonymousRetentionService) archiveBundle(ctx context.Context, startedBefore time.Time, sessions []*types.WritingSession) (string, error) {
+	for _, session := range sessions {
+		if err := s.archive.Rehydrate(ctx, session); err != nil {
+			return "", err
+		}
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(sessions); err != nil {
+		return "", fmt.Errorf("error encoding anonymous sessions: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("error compressing anonymous sessions: %w", err)
+	}
+
+	key := fmt.Sprintf("anonymous/%s/%s.json.gz", startedBefore.Format("2006-01-02"), sessions[0].ID)
+	if err := s.archive.blobs.Put(ctx, key, buf.Bytes()); err != nil {
+		return "", fmt.Errorf("error storing anonymous session bundle: %w", err)
+	}
+	return key, nil
+}
+
+// ParseRetentionDays reads an older_than_days value, falling back to the
+// configured retention when it is empty.
+func (s *AnonymousRetentionService) ParseRetentionDays(value string) (time.Duration, error) {
+	if value == "" {
+		return s.maxAge, nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return 0, errors.New("older_than_days must be a positive number of days")
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+func AnonymousPruneIntervalFromEnv() time.Duration {
+	if value := os.Getenv("ANONYMOUS_PRUNE_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 24 * time.Hour
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+const backupRestoreTimeout = 30 * time.Minute
+
+// BackupVerificationService restores the newest logical backup in BACKUP_DIR
+// (plain pg_dump output, .sql or .sql.gz) into a scratch schema and compares
+// its row counts with the live tables. A backup fails verification when it
+// doesn't restore, is older than BACKUP_MAX_AGE_HOURS, or a table's counts
+// differ by more than BACKUP_ROW_TOLERANCE_PERCENT in either direction: a
+// backup far behind the live table misses data, a live table far behind its
+// backup lost data.
+type BackupVerificationService struct {
+	store     *storage.PostgresStore
+	dir       string
+	maxAge    time.Duration
+	tolerance float64
+}
+
+func NewBackupVerificationService(store *storage.PostgresStore) *BackupVerificationService {
+	s := &BackupVerificationService{
+		store:     store,
+		dir:       os.Getenv("BACKUP_DIR"),
+		maxAge:    26 * time.Hour,
+		tolerance: 0.1,
+	}
+	if hours, err := strconv.Atoi(os.Getenv("BACKUP_MAX_AGE_HOURS")); err == nil && hours > 0 {
+		s.maxAge = time.Duration(hours) * time.Hour
+	}
+	if percent, err := strconv.ParseFloat(os.Getenv("BACKUP_ROW_TOLERANCE_PERCENT"), 64); err == nil && percent >= 0 {
+		s.tolerance = percent / 100
+	}
+	return s
+}
+
+// StartBackupVerificationJob blocks, verifying the latest backup every
+// interval. It returns right away when BACKUP_DIR is not set.
+func (s *BackupVerificationService) StartBackupVerificationJob(ctx context.Context, interval time.Duration) {
+	if s.dir == "" {
+		log.Println("⚠️ BACKUP_DIR is not set, backups won't be verified")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.VerifyLatestBackup(ctx)
+		}
+	}
+}
+
+// VerifyLatestBackup runs a restore test and records its outcome, which it
+// returns whether the backup passed or not.
+func (s *BackupVerificationService) VerifyLatestBackup(ctx context.Context) (*types.BackupVerification, error) {
+	verification := &types.BackupVerification{
+		Status:    types.BackupVerificationFailed,
+		Checks:    []*types.BackupTableCheck{},
+		StartedAt: s.store.Clock().Now().UTC(),
+	}
+	if err := s.verify(ctx, verification); err != nil {
+		verification.Error = err.Error()
+	}
+	verification.FinishedAt = s.store.Clock().Now().UTC()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err := s.store.CreateBackupVerification(ctx, verification); err != nil {
+		return nil, err
+	}
+
+	if verification.Status == types.BackupVerificationPassed {
+		log.Printf("🗄️ Backup %s verified", verification.BackupFile)
+	} else {
+		log.Printf("🚨 Backup verification failed for %q: %s", verification.BackupFile, verification.Error)
+	}
+	return verification, nil
+}
+
+func (s *BackupVerificationService) verify(ctx context.Context, verification *types.BackupVerification) error {
+	if s.dir == "" {
+		return errors.New("BACKUP_DIR is not set")
+	}
+	path, info, err := latestBackup(s.dir)
+	if err != nil {
+		return err
+	}
+	takenAt := info.ModTime().UTC()
+	verification.BackupFile = filepath.Base(path)
+	verification.BackupTakenAt = &takenAt
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var dump io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error opening compressed backup: %v", err)
+		}
+		defer gz.Close()
+		dump = gz
+	}
+
+	restoreCtx, cancel := context.WithTimeout(ctx, backupRestoreTimeout)
+	defer cancel()
+	restored, err := s.store.RestoreBackup(restoreCtx, dump)
+	if err != nil {
+		return fmt.Errorf("backup doesn't restore: %v", err)
+	}
+	live, err := s.store.CountRows(ctx, storage.BackupVerifiedTables)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, table := range storage.BackupVerifiedTables {
+		check := s.checkTable(table, restored, live[table])
+		verification.Checks = append(verification.Checks, check)
+		if !check.OK {
+			problems = append(problems, table+": "+check.Detail)
+		}
+	}
+	if age := time.Since(takenAt); age > s.maxAge {
+		problems = append(problems, fmt.Sprintf("latest backup is %s old", age.Round(time.Minute)))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	verification.Status = types.BackupVerificationPassed
+	return nil
+}
+
+func (s *BackupVerificationService) checkTable(table string, restored map[string]int64, liveRows int64) *types.BackupTableCheck {
+	backupRows, ok := restored[table]
+	check := &types.BackupTableCheck{Table: table, BackupRows: backupRows, LiveRows: liveRows}
+	switch {
+	case !ok:
+		check.Detail = "table is missing from the backup"
+	case float64(backupRows) < float64(liveRows)*(1-s.tolerance):
+		check.Detail = fmt.Sprintf("backup has %d fewer rows than the live table", liveRows-backupRows)
+	case float64(liveRows) < float64(backupRows)*(1-s.tolerance):
+		check.Detail = fmt.Sprintf("live table has %d fewer rows than the backup", backupRows-liveRows)
+	default:
+		check.OK = true
+	}
+	return check
+}
+
+// latestBackup returns the most recently modified dump in dir.
+func latestBackup(dir string) (string, os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading backup directory: %v", err)
+	}
+
+	var latestPath string
+	var latest os.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".sql") || strings.HasSuffix(name, ".sql.gz")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if latest == nil || info.ModTime().After(latest.ModTime()) {
+			latestPath, latest = filepath.Join(dir, name), info
+		}
+	}
+	if latest == nil {
+		return "", nil, fmt.Errorf("no backups found in %s", dir)
+	}
+	return latestPath, latest, nil
+}
+
+func BackupVerificationIntervalFromEnv() time.Duration {
+	if value := os.Getenv("BACKUP_VERIFICATION_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 24 * time.Hour
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Farcaster hubs only accept messages hashed with BLAKE3, which none of our
+// dependencies implement. This is the plain hash mode of the reference
+// implementation, enough to hash messages; it makes no attempt at speed.
+
+const (
+	blake3ChunkLen = 1024
+	blake3BlockLen = 64
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3MsgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+// blake3Sum256 returns the 32 byte BLAKE3 hash of data.
+func blake3Sum256(data []byte) [32]byte {
+	out := blake3Node(data, 0).compress(blake3Root)
+	var sum [32]byte
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(sum[i*4:], out[i])
+	}
+	return sum
+}
+
+// blake3Output is a compression left pending until it is known whether it is
+// the root of the tree.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o blake3Output) compress(extraFlags uint32) [16]uint32 {
+	return blake3Compress(o.cv, o.block, o.counter, o.blockLen, o.flags|extraFlags)
+}
+
+func (o blake3Output) chainingValue() [8]uint32 {
+	var cv [8]uint32
+	out := o.compress(0)
+	copy(cv[:], out[:8])
+	return cv
+}
+
+// blake3Node hashes data as the subtree starting at chunk counter. The left
+// subtree gets the largest power of two number of chunks that leaves some
+// input for the right one.
+func blake3Node(data []byte, counter uint64) blake3Output {
+	if len(data) <= blake3ChunkLen {
+		return blake3Chunk(data, counter)
+	}
+
+	chunks := uint64((len(data) + blake3ChunkLen - 1) / blake3ChunkLen)
+	leftChunks := uint64(1) << (63 - bits.LeadingZeros64(chunks-1))
+	split := int(leftChunks) * blake3ChunkLen
+
+	left := blake3Node(data[:split], counter).chainingValue()
+	right := blake3Node(data[split:], counter+leftChunks).chainingValue()
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return blake3Output{cv: blake3IV, block: block, blockLen: blake3BlockLen, flags: blake3Parent}
+}
+
+func blake3Chunk(data []byte, counter uint64) blake3Output {
+	cv := blake3IV
+	flags := uint32(blake3ChunkStart)
+	for len(data) > blake3BlockLen {
+		cv = blake3Output{cv: cv, block: blake3Words(data[:blake3BlockLen]), counter: counter, blockLen: blake3BlockLen, flags: flags}.chainingValue()
+		data = data[blake3BlockLen:]
+		flags = 0
+	}
+	return blake3Output{cv: cv, block: blake3Words(data), counter: counter, blockLen: uint32(len(data)), flags: flags | blake3ChunkEnd}
+}
+
+// blake3Words reads a block of up to 64 bytes, zero padded.
+func blake3Words(block []byte) [16]uint32 {
+	var padded [blake3BlockLen]byte
+	copy(padded[:], block)
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(padded[i*4:])
+	}
+	return words
+}
+
+func blake3Compress(cv [8]uint32, block [16]uint32, counter uint64, blockLen uint32, flags uint32) [16]uint32 {
+	state := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := block
+	for round := 0; round < 7; round++ {
+		blake3G(&state, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&state, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&state, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&state, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&state, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&state, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&state, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&state, 3, 4, 9, 14, m[14], m[15])
+
+		var permuted [16]uint32
+		for i, j := range blake3MsgPermutation {
+			permuted[i] = m[j]
+		}
+		m = permuted
+	}
+	for i := 0; i < 8; i++ {
+		state[i] ^= state[i+8]
+		state[i+8] ^= cv[i]
+	}
+	return state
+}
+
+func blake3G(s *[16]uint32, a, b, c, d int, x, y uint32) {
+	s[a] = s[a] + s[b] + x
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] = s[a] + s[b] + y
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	blockchainCallTimeout       = 30 * time.Second
+	onchainBatch                = 50
+	defaultOnchainConfirmations = 3
+)
+
+// revealAnky mints the Anky to its writer with the metadata pinned on IPFS;
+// the Transfer it emits carries the token ID.
+const ankyNFTABI = `[
+	{"type":"function","name":"revealAnky","stateMutability":"nonpayable","inputs":[{"name":"writer","type":"address"},{"name":"sessionId","type":"string"},{"name":"metadataIpfsHash","type":"string"}],"outputs":[{"name":"tokenId","type":"uint256"}]},
+	{"type":"event","name":"Transfer","anonymous":false,"inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"tokenId","type":"uint256","indexed":true}]}
+]`
+
+var transferEventTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// BlockchainService reveals the NFTs of Ankys on the Anky NFT contract at
+// ANKY_NFT_CONTRACT_ADDRESS, through the Base node at BASE_RPC_URL, signing
+// with ANKY_MINTER_PRIVATE_KEY. Transactions are tracked on the Anky until
+// ONCHAIN_CONFIRMATIONS blocks confirm them.
+type BlockchainService struct {
+	store         *storage.PostgresStore
+	rpcURL        string
+	contract      common.Address
+	confirmations uint64
+}
+
+func NewBlockchainService(store *storage.PostgresStore) *BlockchainService {
+	confirmations := uint64(defaultOnchainConfirmations)
+	if value, err := strconv.ParseUint(os.Getenv("ONCHAIN_CONFIRMATIONS"), 10, 64); err == nil && value > 0 {
+		confirmations = value
+	}
+	return &BlockchainService{
+		store:         store,
+		rpcURL:        os.Getenv("BASE_RPC_URL"),
+		contract:      AnkyNFTContractAddress(),
+		confirmations: confirmations,
+	}
+}
+
+// AnkyNFTContractAddress is the contract Ankys are revealed on, the zero
+// address when none is configured.
+func AnkyNFTContractAddress() common.Address {
+	address := os.Getenv("ANKY_NFT_CONTRACT_ADDRESS")
+	if !common.IsHexAddress(address) {
+		return common.Address{}
+	}
+	return common.HexToAddress(address)
+}
+
+func minterKey() (*ecdsa.PrivateKey, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(os.Getenv("ANKY_MINTER_PRIVATE_KEY")), "0x"))
+	if err != nil {
+		return nil, &CapabilityError{Capability: CapabilityOnchain, Reason: fmt.Sprintf("invalid ANKY_MINTER_PRIVATE_KEY: %v", err)}
+	}
+	return key, nil
+}
+
+func (s *BlockchainService) dial(ctx context.Context) (*ethclient.Client, error) {
+	if err := CheckCapability(CapabilityOnchain); err != nil {
+		return nil, err
+	}
+	if s.contract == (common.Address{}) {
+		return nil, &CapabilityError{Capability: CapabilityOnchain, Reason: "ANKY_NFT_CONTRACT_ADDRESS is not an address"}
+	}
+	client, err := ethclient.DialContext(ctx, s.rpcURL)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the Base node: %w", err)
+	}
+	return client, nil
+}
+
+// RevealAnky reveals the Anky's NFT, or queues it for the confirmation job
+// when it can't be revealed yet: time capsules wait for their reveal and
+// Ankys whose image isn't pinned for the storage repair job. A submission
+// that fails leaves the Anky queued, the job tries again.
+func (s *BlockchainService) RevealAnky(ctx context.Context, anky *types.Anky) error {
+	ankyService := &AnkyService{store: s.store}
+	if anky.Sealed() || anky.ImageIPFSHash == "" {
+		reason := "waiting for the image to be pinned"
+		if anky.Sealed() {
+			reason = fmt.Sprintf("sealed until %s", anky.RevealAt.UTC().Format(time.RFC3339))
+		}
+		if anky.OnchainStatus != types.OnchainStatusQueued {
+			anky.OnchainStatus = types.OnchainStatusQueued
+			if err := s.store.UpdateAnkyOnchain(ctx, anky); err != nil {
+				return err
+			}
+			ankyService.recordAnkyStatusEvent(ctx, anky.ID, "onchain_queued", reason)
+		}
+		return nil
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := s.submit(ctx, client, anky); err != nil {
+		if anky.OnchainStatus != types.OnchainStatusQueued {
+			anky.OnchainStatus = types.OnchainStatusQueued
+			if updateErr := s.store.UpdateAnkyOnchain(ctx, anky); updateErr != nil {
+				log.Printf("❌ Error queueing the onchain reveal of anky %s: %v", anky.ID, updateErr)
+			}
+		}
+		ankyService.recordAnkyStatusEvent(ctx, anky.ID, "onchain_queued", fmt.Sprintf("reveal failed: %v", err))
+		return err
+	}
+	return nil
+}
+
+// submit pins the NFT metadata and sends the reveal transaction. The token
+// goes to the writer's wallet, or their Farcaster custody address.
+func (s *BlockchainService) submit(ctx context.Context, client *ethclient.Client, anky *types.Anky) error {
+	user, err := s.store.GetUserByID(ctx, anky.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	var owner string
+	if common.IsHexAddress(user.WalletAddress) {
+		owner = user.WalletAddress
+	} else if user.FarcasterUser != nil && common.IsHexAddress(user.FarcasterUser.CustodyAddress) {
+		owner = user.FarcasterUser.CustodyAddress
+	} else {
+		return fmt.Errorf("user %s has no wallet to receive the anky", anky.UserID)
+	}
+
+	if anky.MetadataIPFSHash == "" {
+		pinataService, err := NewPinataService(s.store)
+		if err != nil {
+			return err
+		}
+		metadata := NewAnkyNFTMetadata(anky.TokenName, anky.Ticker, anky.AnkyReflection, "ipfs://"+anky.ImageIPFSHash, anky.License)
+		metadataHash, err := pinataService.UploadJSONMetadata(metadata)
+		if err != nil {
+			return fmt.Errorf("error pinning metadata: %w", err)
+		}
+		anky.MetadataIPFSHash = metadataHash
+	}
+
+	key, err := minterKey()
+	if err != nil {
+		return err
+	}
+	parsed, err := abi.JSON(strings.NewReader(ankyNFTABI))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, blockchainCallTimeout)
+	defer cancel()
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting the chain ID: %w", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(key, chainID)
+	if err != nil {
+		return err
+	}
+	auth.Context = ctx
+
+	contract := bind.NewBoundContract(s.contract, parsed, client, client, client)
+	tx, err := contract.Transact(auth, "revealAnky", common.HexToAddress(owner), anky.WritingSessionID.String(), anky.MetadataIPFSHash)
+	if err != nil {
+		return fmt.Errorf("error sending revealAnky to %s: %w", s.contract.Hex(), err)
+	}
+
+	anky.OnchainStatus = types.OnchainStatusPending
+	anky.OnchainTxHash = tx.Hash().Hex()
+	if err := s.store.UpdateAnkyOnchain(ctx, anky); err != nil {
+		return fmt.Errorf("error recording transaction %s: %w", anky.OnchainTxHash, err)
+	}
+	ankyService := &AnkyService{store: s.store}
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "onchain_pending", anky.OnchainTxHash)
+	log.Printf("⛓️ Anky %s revealed to %s in transaction %s", anky.ID, owner, anky.OnchainTxHash)
+	return nil
+}
+
+// StartConfirmationJob blocks, checking reveal transactions and submitting
+// queued reveals every interval.
+func (s *BlockchainService) StartConfirmationJob(ctx context.Context, interval time.Duration) {
+	if err := CheckCapability(CapabilityOnchain); err != nil {
+		log.Printf("⚠️ Not revealing ankys onchain: %v", err)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ProcessOnchain(ctx); err != nil {
+				log.Printf("❌ Error processing onchain reveals: %v", err)
+			}
+		}
+	}
+}
+
+// ProcessOnchain submits the queued reveals that became possible and
+// records the outcome of pending transactions.
+func (s *BlockchainService) ProcessOnchain(ctx context.Context) error {
+	ankys, err := s.store.GetAnkysForOnchain(ctx, onchainBatch)
+	if err != nil {
+		return err
+	}
+	if len(ankys) == 0 {
+		return nil
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, anky := range ankys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch anky.OnchainStatus {
+		case types.OnchainStatusQueued:
+			if err := s.submit(ctx, client, anky); err != nil {
+				log.Printf("⚠️ Could not reveal anky %s onchain: %v", anky.ID, err)
+			}
+		case types.OnchainStatusPending:
+			if err := s.confirm(ctx, client, anky); err != nil {
+				log.Printf("⚠️ Could not check transaction %s of anky %s: %v", anky.OnchainTxHash, anky.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// confirm records the transaction as failed when it reverted, or as
+// confirmed with its token ID once enough blocks are on top of it.
+// Transactions not mined yet are left pending.
+func (s *BlockchainService) confirm(ctx context.Context, client *ethclient.Client, anky *types.Anky) error {
+	ctx, cancel := context.WithTimeout(ctx, blockchainCallTimeout)
+	defer cancel()
+
+	receipt, err := client.TransactionReceipt(ctx, common.HexToHash(anky.OnchainTxHash))
+	if errors.Is(err, ethereum.NotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ankyService := &AnkyService{store: s.store}
+	blockNumber := receipt.BlockNumber.Int64()
+	anky.OnchainBlockNumber = &blockNumber
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		anky.OnchainStatus = types.OnchainStatusFailed
+		if err := s.store.UpdateAnkyOnchain(ctx, anky); err != nil {
+			return err
+		}
+		ankyService.recordAnkyStatusEvent(ctx, anky.ID, "onchain_failed", fmt.Sprintf("transaction %s reverted in block %d", anky.OnchainTxHash, blockNumber))
+		log.Printf("🚩 Onchain reveal of anky %s reverted", anky.ID)
+		return nil
+	}
+
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	if head+1 < receipt.BlockNumber.Uint64()+s.confirmations {
+		return nil
+	}
+
+	for _, entry := range receipt.Logs {
+		if entry.Address == s.contract && len(entry.Topics) == 4 && entry.Topics[0] == transferEventTopic {
+			anky.TokenID = new(big.Int).SetBytes(entry.Topics[3].Bytes()).String()
+		}
+	}
+	now := s.store.Clock().Now().UTC()
+	anky.OnchainStatus = types.OnchainStatusConfirmed
+	anky.OnchainConfirmedAt = &now
+	if err := s.store.UpdateAnkyOnchain(ctx, anky); err != nil {
+		return err
+	}
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "onchain_confirmed", fmt.Sprintf("token %s in block %d", anky.TokenID, blockNumber))
+	log.Printf("✅ Anky %s confirmed onchain as token %s", anky.ID, anky.TokenID)
+	return nil
+}
+
+func OnchainConfirmationIntervalFromEnv() time.Duration {
+	if value := os.Getenv("ONCHAIN_CONFIRMATION_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return time.Minute
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+// CampaignService applies the campaigns running at a given time to writing
+// rewards and prompts. Overlapping campaigns don't stack: the highest newen
+// multiplier wins, and the prompt of the latest campaign that has one.
+type CampaignService struct {
+	store *storage.PostgresStore
+}
+
+func NewCampaignService(store *storage.PostgresStore) *CampaignService {
+	return &CampaignService{store: store}
+}
+
+// ActiveCampaigns returns the campaigns running now, latest start first.
+func (s *CampaignService) ActiveCampaigns(ctx context.Context) ([]*types.Campaign, error) {
+	return s.store.GetActiveCampaigns(ctx, s.store.Clock().Now())
+}
+
+// ApplyNewenMultiplier returns the reward multiplied by the campaigns running
+// at the given time, and the campaign that multiplied it if any.
+func (s *CampaignService) ApplyNewenMultiplier(ctx context.Context, reward int, at time.Time) (int, *types.Campaign, error) {
+	campaigns, err := s.store.GetActiveCampaigns(ctx, at)
+	if err != nil {
+		return reward, nil, err
+	}
+	var best *types.Campaign
+	for _, campaign := range campaigns {
+		if campaign.NewenMultiplier > 1 && (best == nil || campaign.NewenMultiplier > best.NewenMultiplier) {
+			best = campaign
+		}
+	}
+	if best == nil {
+		return reward, nil, nil
+	}
+	return int(math.Round(float64(reward) * best.NewenMultiplier)), best, nil
+}
+
+// GetPrompt returns the prompt the FID is shown now: the prompt of a running
+// campaign, else its own or the default one. pgx.ErrNoRows is wrapped when
+// there is none of those.
+func (s *CampaignService) GetPrompt(ctx context.Context, fid int) (*types.WritingPrompt, error) {
+	campaigns, err := s.ActiveCampaigns(ctx)
+	if err != nil {
+		// The scheduled prompts still work without campaigns
+		log.Printf("⚠️ Could not get active campaigns for the prompt of fid %d: %v", fid, err)
+	}
+	for _, campaign := range campaigns {
+		if campaign.Prompt == "" {
+			continue
+		}
+		return &types.WritingPrompt{
+			FID:       fid,
+			Prompt:    campaign.Prompt,
+			Source:    types.PromptSourceCampaign,
+			CreatedAt: campaign.StartsAt,
+			UpdatedAt: campaign.UpdatedAt,
+		}, nil
+	}
+
+	return s.store.GetPromptOrDefault(ctx, fid)
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+)
+
+// Optional integrations. The server starts without them; what needs one
+// fails with a *CapabilityError while the rest keeps working, e.g. Ankys
+// get their reflection without image credentials.
+const (
+	CapabilityImageGeneration = "image_generation"
+	CapabilityCloudinary      = "cloudinary"
+	CapabilityPinata          = "pinata"
+	CapabilityNeynar          = "neynar"
+	CapabilityOnchain         = "onchain"
+	CapabilityModeration      = "moderation"
+)
+
+// capabilityEnv is the environment variable each capability needs. Image
+// generation needs the API key of the backend IMAGE_PROVIDER selects.
+var capabilityEnv = map[string]string{
+	CapabilityImageGeneration: "IMAGE_API_KEY",
+	CapabilityCloudinary:      "CLOUDINARY_URL",
+	CapabilityPinata:          "PINATA_JWT",
+	CapabilityNeynar:          "NEYNAR_API_KEY",
+	CapabilityOnchain:         "ANKY_MINTER_PRIVATE_KEY",
+	CapabilityModeration:      "OPENAI_API_KEY",
+}
+
+// capabilityExtraEnv are the other variables a capability can't go without.
+var capabilityExtraEnv = map[string][]string{
+	CapabilityOnchain: {"BASE_RPC_URL", "ANKY_NFT_CONTRACT_ADDRESS"},
+}
+
+var ErrCapabilityUnavailable = errors.New("integration not configured")
+
+// CapabilityError is returned by what needs an integration this server
+// isn't configured for.
+type CapabilityError struct {
+	Capability string
+	Reason     string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("%s is not available on this server: %s", e.Capability, e.Reason)
+}
+
+func (e *CapabilityError) Is(target error) bool {
+	return target == ErrCapabilityUnavailable
+}
+
+// CapabilityStatus says whether an integration is configured.
+type CapabilityStatus struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// CheckCapability returns a *CapabilityError when the integration isn't
+// configured. It only looks at the configuration, not at whether the
+// upstream answers; that is what the circuit breakers report.
+func CheckCapability(capability string) error {
+	env, ok := capabilityEnv[capability]
+	if !ok {
+		return fmt.Errorf("unknown capability %q", capability)
+	}
+	value := strings.TrimSpace(os.Getenv(env))
+	if capability == CapabilityImageGeneration {
+		_, config := imageGenerator()
+		value = strings.TrimSpace(config.APIKey)
+		env = fmt.Sprintf("%s (or %s for %s)", env, config.APIKeyEnv, config.Provider)
+	}
+	if value == "" {
+		return &CapabilityError{Capability: capability, Reason: env + " is not set"}
+	}
+	for _, extra := range capabilityExtraEnv[capability] {
+		if strings.TrimSpace(os.Getenv(extra)) == "" {
+			return &CapabilityError{Capability: capability, Reason: extra + " is not set"}
+		}
+	}
+	if capability == CapabilityCloudinary {
+		if _, err := cloudinary.NewFromURL(value); err != nil {
+			return &CapabilityError{Capability: capability, Reason: fmt.Sprintf("invalid %s: %v", env, err)}
+		}
+	}
+	return nil
+}
+
+// Capabilities reports every optional integration, sorted by name.
+func Capabilities() []CapabilityStatus {
+	statuses := make([]CapabilityStatus, 0, len(capabilityEnv))
+	for capability := range capabilityEnv {
+		status := CapabilityStatus{Name: capability, Available: true}
+		var capabilityErr *CapabilityError
+		if err := CheckCapability(capability); errors.As(err, &capabilityErr) {
+			status.Available = false
+			status.Reason = capabilityErr.Reason
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
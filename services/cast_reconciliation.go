@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// What the reconciler does with an Anky whose cast no longer resolves
+const (
+	CastActionFlag      = "flag"
+	CastActionRecast    = "recast"
+	CastActionUnpublish = "unpublish"
+)
+
+const castReconciliationBatch = 100
+
+// CastReconciliationService checks that the casts of completed Ankys still
+// resolve on Farcaster. Casts can be deleted by their author or dropped by the
+// hubs without the pipeline ever hearing about it. Missing casts are always
+// flagged; CAST_RECONCILIATION_ACTION decides whether they are also re-cast
+// with the writer's signer or the Anky is marked unpublished.
+type CastReconciliationService struct {
+	store  *storage.PostgresStore
+	action string
+}
+
+func NewCastReconciliationService(store *storage.PostgresStore) *CastReconciliationService {
+	action := os.Getenv("CAST_RECONCILIATION_ACTION")
+	switch action {
+	case CastActionFlag, CastActionRecast, CastActionUnpublish:
+	case "":
+		action = CastActionFlag
+	default:
+		log.Printf("⚠️ Unknown CAST_RECONCILIATION_ACTION %q, only flagging missing casts", action)
+		action = CastActionFlag
+	}
+	return &CastReconciliationService{store: store, action: action}
+}
+
+// StartCastReconciliationJob blocks, running a reconciliation pass every interval.
+func (s *CastReconciliationService) StartCastReconciliationJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ReconcileCasts(ctx)
+		}
+	}
+}
+
+// ReconcileCasts checks the batch of cast Ankys that went the longest without
+// a check. Lookups that fail for any reason other than the cast being gone are
+// left for the next pass.
+func (s *CastReconciliationService) ReconcileCasts(ctx context.Context) {
+	ankys, err := s.store.GetAnkysForCastReconciliation(ctx, castReconciliationBatch)
+	if err != nil {
+		log.Printf("❌ Error getting ankys for cast reconciliation: %v", err)
+		return
+	}
+
+	neynarService := NewNeynarService()
+	missing := 0
+	for _, anky := range ankys {
+		if ctx.Err() != nil {
+			return
+		}
+
+		exists, err := neynarService.CastExists(ctx, anky.CastHash)
+		if err != nil {
+			log.Printf("⚠️ Could not look up cast %s of anky %s: %v", anky.CastHash, anky.ID, err)
+			continue
+		}
+		if exists {
+			if anky.CastMissingAt != nil {
+				log.Printf("✅ Cast %s of anky %s resolves again", anky.CastHash, anky.ID)
+			}
+			if err := s.store.MarkAnkyCastChecked(ctx, anky.ID, false); err != nil {
+				log.Printf("❌ Error marking cast of anky %s checked: %v", anky.ID, err)
+			}
+			continue
+		}
+
+		missing++
+		s.handleMissingCast(ctx, anky)
+	}
+	log.Printf("🔎 Reconciled %d cast ankys, %d casts missing", len(ankys), missing)
+}
+
+func (s *CastReconciliationService) handleMissingCast(ctx context.Context, anky *types.Anky) {
+	ankyService := &AnkyService{store: s.store}
+	log.Printf("🚩 Cast %s of anky %s no longer resolves", anky.CastHash, anky.ID)
+
+	// Only record the timeline entry the first time the cast is found missing
+	if anky.CastMissingAt == nil {
+		ankyService.recordAnkyStatusEvent(ctx, anky.ID, "cast_missing", anky.CastHash)
+	}
+	if err := s.store.MarkAnkyCastChecked(ctx, anky.ID, true); err != nil {
+		log.Printf("❌ Error flagging missing cast of anky %s: %v", anky.ID, err)
+		return
+	}
+
+	switch s.action {
+	case CastActionRecast:
+		if err := s.recast(ctx, anky); err != nil {
+			log.Printf("❌ Error re-casting anky %s: %v", anky.ID, err)
+		}
+	case CastActionUnpublish:
+		sessionID := anky.WritingSessionID.String()
+		if err := ankyService.setAnkyStatus(ctx, anky, sessionID, "unpublished"); err != nil {
+			log.Printf("❌ Error unpublishing anky %s: %v", anky.ID, err)
+			return
+		}
+		ankyService.recordAnkyStatusEvent(ctx, anky.ID, "unpublished", fmt.Sprintf("cast %s is missing", anky.CastHash))
+	}
+}
+
+// recast casts the Anky again with the writer's signer and clears the flag.
+// The idempotency key is derived from the missing cast, so a pass that dies
+// halfway can't cast the same Anky twice. The cast doesn't mention clanker,
+// which would deploy the Anky's token a second time.
+func (s *CastReconciliationService) recast(ctx context.Context, anky *types.Anky) error {
+	user, err := s.store.GetUserByID(ctx, anky.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %v", err)
+	}
+	if user.FarcasterUser == nil || user.FarcasterUser.SignerUUID == "" {
+		return fmt.Errorf("user %s has no Farcaster signer", anky.UserID)
+	}
+
+	images, err := s.store.GetAnkyImagesByAnkyIDs(ctx, []uuid.UUID{anky.ID})
+	if err != nil {
+		return fmt.Errorf("error getting anky images: %v", err)
+	}
+
+	sessionID := anky.WritingSessionID.String()
+	previousHash := anky.CastHash
+	cast, err := NewFarcasterPublisher().PublishCast(ctx, CastRequest{
+		SignerUUID:     user.FarcasterUser.SignerUUID,
+		Text:           ankyCastText(sessionID, "", ""),
+		ChannelID:      "anky",
+		IdempotencyKey: "recast-" + previousHash,
+		SessionID:      sessionID,
+		ImageURLs:      castImageURLs(anky.ImageIPFSHash, collectionImageURLs(images[anky.ID])),
+	})
+	if err != nil {
+		return err
+	}
+	if storyReplyEnabled(nil) {
+		replyWithStory(ctx, cast, user.FarcasterUser.SignerUUID, anky.AnkyReflection)
+	}
+
+	anky.CastHash = cast.Hash
+	anky.LastUpdatedAt = s.store.Clock().Now().UTC()
+	if err := s.store.UpdateAnky(ctx, anky); err != nil {
+		return fmt.Errorf("error updating anky: %v", err)
+	}
+	if err := s.store.MarkAnkyCastChecked(ctx, anky.ID, false); err != nil {
+		return err
+	}
+
+	ankyService := &AnkyService{store: s.store}
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "recast", fmt.Sprintf("%s -> %s", previousHash, cast.Hash))
+	log.Printf("📣 Anky %s re-cast as %s", anky.ID, cast.Hash)
+	return nil
+}
+
+func CastReconciliationIntervalFromEnv() time.Duration {
+	if value := os.Getenv("CAST_RECONCILIATION_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 6 * time.Hour
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRecastDoesNotDeployTheTokenAgain(t *testing.T) {
+	store, fakes, anky, _ := newPipelineTest(t)
+	anky.Ticker, anky.TokenName, anky.CastHash = "DREAM", "Wisdom Light Dancing", "0xgone"
+
+	if err := NewCastReconciliationService(store).recast(context.Background(), anky); err != nil {
+		t.Fatalf("recast: %v", err)
+	}
+	casts := fakes.neynar.sent()
+	if len(casts) != 1 {
+		t.Fatalf("sent %d casts, want 1", len(casts))
+	}
+	text, _ := casts[0]["text"].(string)
+	if strings.Contains(text, "@clanker") || strings.Contains(text, "$DREAM") {
+		t.Errorf("recast text %q asks clanker for the token again", text)
+	}
+	if casts[0]["idem"] != "recast-0xgone" {
+		t.Errorf("idem = %v, want it derived from the missing cast", casts[0]["idem"])
+	}
+}
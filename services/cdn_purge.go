@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Responses the CDN may cache are tagged with surrogate keys, so everything
+// served about an Anky can be purged at once when it changes.
+const SurrogateKeyHeader = "Surrogate-Key"
+
+// AnkySurrogateKey tags the public image and metadata of an Anky.
+func AnkySurrogateKey(ankyID string) string {
+	return "anky-" + ankyID
+}
+
+// Timeline events after which what the public routes serve about an Anky is
+// stale: its image, story, license, token or whether it is shown at all.
+var cdnPurgeEvents = map[string]bool{
+	"image_chosen":           true,
+	"image_kept":             true,
+	"image_regenerated":      true,
+	"reflection_canonical":   true,
+	"reflection_regenerated": true,
+	"license_changed":        true,
+	"revealed":               true,
+	"sealed":                 true,
+	"unpublished":            true,
+	"storage_repaired":       true,
+	"token_deployed":         true,
+	"recast":                 true,
+	"onchain_confirmed":      true,
+	"completed":              true,
+	"review_rejected":        true,
+}
+
+var cdnPurgeClient = &http.Client{Timeout: 10 * time.Second}
+
+// Purges waiting to be sent, dropped when the CDN falls this far behind
+var cdnPurges = make(chan uuid.UUID, 256)
+
+// CDNPurgeService purges the cached responses of Ankys that changed from the
+// CDN in front of the public routes, through Fastly's purge by surrogate key.
+// It does nothing unless FASTLY_API_TOKEN and FASTLY_SERVICE_ID are set.
+type CDNPurgeService struct {
+	token     string
+	serviceID string
+	apiURL    string
+}
+
+func NewCDNPurgeService() *CDNPurgeService {
+	return &CDNPurgeService{
+		token:     strings.TrimSpace(os.Getenv("FASTLY_API_TOKEN")),
+		serviceID: strings.TrimSpace(os.Getenv("FASTLY_SERVICE_ID")),
+		apiURL:    "https://api.fastly.com",
+	}
+}
+
+// Enabled says whether a CDN to purge is configured.
+func (s *CDNPurgeService) Enabled() bool {
+	return s.token != "" && s.serviceID != ""
+}
+
+// queueAnkyPurge asks for the Anky to be purged from the CDN without waiting
+// for it.
+func queueAnkyPurge(ankyID uuid.UUID) {
+	select {
+	case cdnPurges <- ankyID:
+	default:
+		log.Printf("⚠️ CDN purge queue full, dropping purge of anky %s", ankyID)
+	}
+}
+
+// Run blocks, sending the queued purges until ctx is done. Purges are
+// queued on the instance where the Anky changed, so every instance runs it.
+func (s *CDNPurgeService) Run(ctx context.Context) {
+	if !s.Enabled() {
+		log.Println("⚠️ FASTLY_API_TOKEN or FASTLY_SERVICE_ID is not set, public routes are not purged from the CDN")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ankyID := <-cdnPurges:
+			if !s.Enabled() {
+				continue
+			}
+			if err := s.Purge(ctx, AnkySurrogateKey(ankyID.String())); err != nil {
+				log.Printf("❌ Error purging anky %s from the CDN: %v", ankyID, err)
+			}
+		}
+	}
+}
+
+// Purge drops every cached response tagged with one of the keys. Responses
+// are marked stale rather than removed, so the CDN can still serve them
+// while it fetches the new ones.
+func (s *CDNPurgeService) Purge(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	endpoint := fmt.Sprintf("%s/service/%s/purge", s.apiURL, url.PathEscape(s.serviceID))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", s.token)
+	req.Header.Set("Fastly-Soft-Purge", "1")
+	req.Header.Set(SurrogateKeyHeader, strings.Join(keys, " "))
+
+	resp, err := cdnPurgeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("purge answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	log.Printf("🧽 Purged %s from the CDN", strings.Join(keys, ", "))
+	return nil
+}
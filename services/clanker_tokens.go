@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// FID of the @clanker account, which replies with the token it deployed
+	defaultClankerFID   = 874542
+	clankerWatchBatch   = 100
+	defaultClankerWatch = 72 * time.Hour
+)
+
+var contractAddressPattern = regexp.MustCompile(`0x[0-9a-fA-F]{40}`)
+
+// ClankerTokenService finds the tokens clanker deploys for cast Ankys. The
+// cast tags @clanker with the ticker and name, and clanker replies with the
+// contract of the token; its reply is looked up through Neynar. Ankys are
+// watched for CLANKER_WATCH_HOURS after they were created, CLANKER_FID
+// overrides the account whose replies count.
+type ClankerTokenService struct {
+	store      *storage.PostgresStore
+	clankerFID int
+	window     time.Duration
+}
+
+func NewClankerTokenService(store *storage.PostgresStore) *ClankerTokenService {
+	clankerFID := defaultClankerFID
+	if fid, err := strconv.Atoi(os.Getenv("CLANKER_FID")); err == nil && fid > 0 {
+		clankerFID = fid
+	}
+	window := defaultClankerWatch
+	if hours, err := strconv.Atoi(os.Getenv("CLANKER_WATCH_HOURS")); err == nil && hours > 0 {
+		window = time.Duration(hours) * time.Hour
+	}
+	return &ClankerTokenService{store: store, clankerFID: clankerFID, window: window}
+}
+
+// StartDeploymentWatchJob blocks, looking for the tokens of recently cast
+// Ankys every interval.
+func (s *ClankerTokenService) StartDeploymentWatchJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.WatchDeployments(ctx)
+		}
+	}
+}
+
+// WatchDeployments checks the replies to the casts of the Ankys whose token
+// wasn't found yet. Lookups that fail are left for the next pass.
+func (s *ClankerTokenService) WatchDeployments(ctx context.Context) {
+	if err := CheckCapability(CapabilityNeynar); err != nil {
+		return
+	}
+	since := s.store.Clock().Now().Add(-s.window)
+	ankys, err := s.store.GetAnkysAwaitingToken(ctx, since, clankerWatchBatch)
+	if err != nil {
+		log.Printf("❌ Error getting ankys awaiting their token: %v", err)
+		return
+	}
+
+	neynarService := NewNeynarService()
+	found := 0
+	for _, anky := range ankys {
+		if ctx.Err() != nil {
+			return
+		}
+		replies, err := neynarService.CastReplies(ctx, anky.CastHash)
+		if err != nil {
+			log.Printf("⚠️ Could not get the replies to cast %s of anky %s: %v", anky.CastHash, anky.ID, err)
+			continue
+		}
+		address := s.deployedToken(replies)
+		if err := s.store.MarkAnkyTokenChecked(ctx, anky.ID, address); err != nil {
+			log.Printf("❌ Error recording the token check of anky %s: %v", anky.ID, err)
+			continue
+		}
+		if address == "" {
+			continue
+		}
+
+		found++
+		ankyService := &AnkyService{store: s.store}
+		ankyService.recordAnkyStatusEvent(ctx, anky.ID, "token_deployed", address)
+		log.Printf("🪙 Clanker deployed $%s for anky %s at %s", anky.Ticker, anky.ID, address)
+	}
+	if found > 0 {
+		log.Printf("🪙 Found %d clanker tokens out of %d ankys awaiting one", found, len(ankys))
+	}
+}
+
+// deployedToken returns the contract address in clanker's reply, empty when
+// clanker didn't reply or replied without one, e.g. to refuse the ticker.
+func (s *ClankerTokenService) deployedToken(replies []types.Cast) string {
+	for _, reply := range replies {
+		if reply.Author.FID != s.clankerFID {
+			continue
+		}
+		texts := []string{reply.Text}
+		for _, embed := range reply.Embeds {
+			texts = append(texts, embed.URL)
+		}
+		if address := contractAddressPattern.FindString(strings.Join(texts, " ")); address != "" {
+			return strings.ToLower(address)
+		}
+	}
+	return ""
+}
+
+func ClankerWatchIntervalFromEnv() time.Duration {
+	if value := os.Getenv("CLANKER_WATCH_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 5 * time.Minute
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+// ConversationCache remembers the turns of the companion chat for a while,
+// keyed by the authenticated user and the writing session ID, so the companion keeps the whole conversation
+// even when a client only sends its latest turns. Turns alternate the same
+// way the clients send them: even positions are the companion's prompts and
+// odd positions are the user's writing sessions.
+type ConversationCache struct {
+	mu            sync.Mutex
+	conversations map[string]*cachedConversation
+	ttl           time.Duration
+	clock         utils.Clock
+}
+
+type cachedConversation struct {
+	turns     []string
+	expiresAt time.Time
+}
+
+func NewConversationCache(ttl time.Duration) *ConversationCache {
+	c := &ConversationCache{
+		conversations: make(map[string]*cachedConversation),
+		ttl:           ttl,
+		clock:         utils.SystemClock,
+	}
+	go c.cleanup()
+	return c
+}
+
+// conversationKey scopes the session ID, which clients choose, to the user
+// asking so nobody reads or overwrites another user's conversation.
+func conversationKey(userID uuid.UUID, sessionID string) string {
+	return userID.String() + "/" + sessionID
+}
+
+// Merge combines what the client sent with what the user has cached for the
+// session.
+// Clients that truncate the history send a tail of the conversation, so the
+// longest overlap between the end of the cached turns and the start of the
+// incoming ones is stitched together. Without any overlap, a client history
+// at least as long as the cached one wins, anything shorter is treated as new
+// turns continuing the cached conversation.
+func (c *ConversationCache) Merge(userID uuid.UUID, sessionID string, incoming []string) []string {
+	if sessionID == "" {
+		return incoming
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.conversations[conversationKey(userID, sessionID)]
+	if !ok || c.clock.Now().After(cached.expiresAt) {
+		return incoming
+	}
+	turns := cached.turns
+
+	for overlap := min(len(turns), len(incoming)); overlap > 0; overlap-- {
+		if slices.Equal(turns[len(turns)-overlap:], incoming[:overlap]) {
+			return append(append([]string(nil), turns...), incoming[overlap:]...)
+		}
+	}
+	if len(incoming) >= len(turns) {
+		return incoming
+	}
+	return append(append([]string(nil), turns...), incoming...)
+}
+
+// Save replaces the user's cached conversation for the session and restarts
+// its TTL.
+func (c *ConversationCache) Save(userID uuid.UUID, sessionID string, turns []string) {
+	if sessionID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conversations[conversationKey(userID, sessionID)] = &cachedConversation{
+		turns:     append([]string(nil), turns...),
+		expiresAt: c.clock.Now().Add(c.ttl),
+	}
+}
+
+// cleanup drops expired conversations so abandoned sessions don't pile up
+func (c *ConversationCache) cleanup() {
+	for {
+		time.Sleep(5 * time.Minute)
+		c.mu.Lock()
+		now := c.clock.Now()
+		for key, conversation := range c.conversations {
+			if now.After(conversation.expiresAt) {
+				delete(c.conversations, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+func TestConversationCacheKeepsUsersApart(t *testing.T) {
+	cache := &ConversationCache{conversations: make(map[string]*cachedConversation), ttl: time.Hour, clock: utils.SystemClock}
+	writer, other := uuid.New(), uuid.New()
+	sessionID := uuid.NewString()
+	cache.Save(writer, sessionID, []string{"prompt", "writing", "reply"})
+
+	if got := cache.Merge(other, sessionID, []string{"injected"}); !slices.Equal(got, []string{"injected"}) {
+		t.Errorf("another user merged into the writer's conversation: %q", got)
+	}
+	cache.Save(other, sessionID, []string{"overwritten"})
+
+	got := cache.Merge(writer, sessionID, []string{"reply", "more writing"})
+	want := []string{"prompt", "writing", "reply", "more writing"}
+	if !slices.Equal(got, want) {
+		t.Errorf("writer's conversation = %q, want %q", got, want)
+	}
+}
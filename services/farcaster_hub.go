@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Farcaster timestamps count seconds from 2021-01-01T00:00:00Z
+const farcasterEpoch = 1609459200
+
+// Values of the hub protobuf enums we use
+const (
+	hubMessageTypeCastAdd = 1
+	hubNetworkMainnet     = 1
+	hubHashSchemeBlake3   = 1
+	hubSignatureEd25519   = 1
+)
+
+// HubPublisher submits casts straight to a Farcaster hub, signed with an app
+// key registered for FID. It can only cast as that account, which is the one
+// behind the Neynar signer in SignerUUID.
+type HubPublisher struct {
+	hubURL     string
+	fid        uint64
+	signerUUID string
+	key        ed25519.PrivateKey
+}
+
+// NewHubPublisherFromEnv reads FARCASTER_HUB_URL, FARCASTER_HUB_FID and
+// FARCASTER_HUB_SIGNER_KEY (the hex ed25519 private key). The key stands in
+// for the Neynar signer in FARCASTER_HUB_SIGNER_UUID, ANKY_SIGNER_UUID by
+// default. It returns nil when no hub is configured.
+func NewHubPublisherFromEnv() (*HubPublisher, error) {
+	hubURL := strings.TrimSuffix(os.Getenv("FARCASTER_HUB_URL"), "/")
+	if hubURL == "" {
+		return nil, nil
+	}
+
+	fid, err := strconv.ParseUint(os.Getenv("FARCASTER_HUB_FID"), 10, 64)
+	if err != nil || fid == 0 {
+		return nil, fmt.Errorf("invalid FARCASTER_HUB_FID: %q", os.Getenv("FARCASTER_HUB_FID"))
+	}
+	key, err := hex.DecodeString(strings.TrimPrefix(os.Getenv("FARCASTER_HUB_SIGNER_KEY"), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FARCASTER_HUB_SIGNER_KEY: %v", err)
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		key = ed25519.NewKeyFromSeed(key)
+	case ed25519.PrivateKeySize:
+	default:
+		return nil, fmt.Errorf("FARCASTER_HUB_SIGNER_KEY must be %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(key))
+	}
+	signerUUID := os.Getenv("FARCASTER_HUB_SIGNER_UUID")
+	if signerUUID == "" {
+		signerUUID = os.Getenv("ANKY_SIGNER_UUID")
+	}
+
+	return &HubPublisher{hubURL: hubURL, fid: fid, signerUUID: signerUUID, key: ed25519.PrivateKey(key)}, nil
+}
+
+// CanSign reports whether the hub key may cast on behalf of the Neynar signer.
+func (p *HubPublisher) CanSign(signerUUID string) bool {
+	return signerUUID != "" && signerUUID == p.signerUUID
+}
+
+func (p *HubPublisher) PublishCast(ctx context.Context, cast CastRequest) (*types.Cast, error) {
+	if err := CheckFeature(FeatureCasting); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	embeds := castEmbedURLs(cast.SessionID, cast.ImageURLs)
+	// Replies hang off their parent cast instead of the channel
+	var parentHash []byte
+	parentURL := ""
+	if cast.ParentHash != "" {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(cast.ParentHash, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent cast hash %q: %v", cast.ParentHash, err)
+		}
+		parentHash = decoded
+	} else if cast.ChannelID != "" {
+		parentURL = channelParentURL(cast.ChannelID)
+	}
+	message := p.signMessage(encodeCastAddData(p.fid, uint32(now.Unix()-farcasterEpoch), cast.Text, parentHash, parentURL, embeds))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.hubURL+"/v1/submitMessage", bytes.NewReader(message.encoded))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := hubHTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("hub rejected cast: status %d, body: %s", res.StatusCode, string(body))
+	}
+
+	hash := "0x" + hex.EncodeToString(message.hash)
+	log.Printf("🛰️ Cast %s submitted to hub %s", hash, p.hubURL)
+
+	published := &types.Cast{
+		Object:     "cast",
+		Hash:       hash,
+		ThreadHash: hash,
+		ParentURL:  parentURL,
+		Author:     types.Author{Object: "user", FID: int(p.fid)},
+		Text:       cast.Text,
+		Timestamp:  now.Format(time.RFC3339),
+	}
+	if cast.ParentHash != "" {
+		published.ParentHash = &cast.ParentHash
+		published.ThreadHash = cast.ParentHash
+	}
+	for _, embedURL := range embeds {
+		published.Embeds = append(published.Embeds, types.Embed{URL: embedURL})
+	}
+	return published, nil
+}
+
+// channelParentURL is the parent URL hubs file a channel's casts under.
+func channelParentURL(channelID string) string {
+	return "https://warpcast.com/~/channel/" + channelID
+}
+
+type hubMessage struct {
+	hash    []byte
+	encoded []byte
+}
+
+// signMessage wraps MessageData into a Message: hashed with BLAKE3 truncated
+// to 20 bytes, and that hash signed with the app key. The data also goes in
+// data_bytes so the hub checks the hash against the exact bytes we signed.
+func (p *HubPublisher) signMessage(data []byte) hubMessage {
+	sum := blake3Sum256(data)
+	hash := sum[:20]
+	signature := ed25519.Sign(p.key, hash)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, data)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, hash)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, hubHashSchemeBlake3)
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, signature)
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	b = protowire.AppendVarint(b, hubSignatureEd25519)
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendBytes(b, p.key.Public().(ed25519.PublicKey))
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	b = protowire.AppendBytes(b, data)
+	return hubMessage{hash: hash, encoded: b}
+}
+
+// encodeCastAddData encodes the MessageData of a CastAdd as defined by the
+// hub protobufs, fields in the order protobuf encoders write them. A
+// parentHash is a cast of the same fid.
+func encodeCastAddData(fid uint64, timestamp uint32, text string, parentHash []byte, parentURL string, embeds []string) []byte {
+	var body []byte
+	if len(parentHash) > 0 {
+		var castID []byte
+		castID = protowire.AppendTag(castID, 1, protowire.VarintType)
+		castID = protowire.AppendVarint(castID, fid)
+		castID = protowire.AppendTag(castID, 2, protowire.BytesType)
+		castID = protowire.AppendBytes(castID, parentHash)
+		body = protowire.AppendTag(body, 3, protowire.BytesType)
+		body = protowire.AppendBytes(body, castID)
+	}
+	body = protowire.AppendTag(body, 4, protowire.BytesType)
+	body = protowire.AppendString(body, text)
+	for _, embedURL := range embeds {
+		var embed []byte
+		embed = protowire.AppendTag(embed, 1, protowire.BytesType)
+		embed = protowire.AppendString(embed, embedURL)
+		body = protowire.AppendTag(body, 6, protowire.BytesType)
+		body = protowire.AppendBytes(body, embed)
+	}
+	if parentURL != "" {
+		body = protowire.AppendTag(body, 7, protowire.BytesType)
+		body = protowire.AppendString(body, parentURL)
+	}
+
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.VarintType)
+	data = protowire.AppendVarint(data, hubMessageTypeCastAdd)
+	data = protowire.AppendTag(data, 2, protowire.VarintType)
+	data = protowire.AppendVarint(data, fid)
+	data = protowire.AppendTag(data, 3, protowire.VarintType)
+	data = protowire.AppendVarint(data, uint64(timestamp))
+	data = protowire.AppendTag(data, 4, protowire.VarintType)
+	data = protowire.AppendVarint(data, hubNetworkMainnet)
+	data = protowire.AppendTag(data, 5, protowire.BytesType)
+	data = protowire.AppendBytes(data, body)
+	return data
+}
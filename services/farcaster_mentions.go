@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// Header with the hex HMAC-SHA512 of the body, keyed by the webhook secret
+	NeynarSignatureHeader = "X-Neynar-Signature"
+	// Casts of one author Anky answers a day, so nobody can run up the LLM bill
+	maxMentionRepliesPerDay = 5
+)
+
+var (
+	ErrNeynarWebhookRejected = errors.New("neynar webhook rejected")
+	ErrInvalidNeynarWebhook  = errors.New("invalid neynar webhook body")
+)
+
+// neynarWebhookEvent is the body of a Neynar webhook delivery.
+type neynarWebhookEvent struct {
+	Type string     `json:"type"`
+	Data types.Cast `json:"data"`
+}
+
+// FarcasterMentionService records the casts addressed to the Anky account,
+// received through the Neynar webhook, and has Anky answer them when
+// FARCASTER_AUTO_REPLY is on.
+type FarcasterMentionService struct {
+	store *storage.PostgresStore
+	// FID of the Anky account, ANKY_FID
+	ankyFID    int
+	signerUUID string
+	secret     string
+	autoReply  bool
+}
+
+func NewFarcasterMentionService(store *storage.PostgresStore) *FarcasterMentionService {
+	ankyFID, _ := strconv.Atoi(os.Getenv("ANKY_FID"))
+	autoReply, _ := strconv.ParseBool(os.Getenv("FARCASTER_AUTO_REPLY"))
+	return &FarcasterMentionService{
+		store:      store,
+		ankyFID:    ankyFID,
+		signerUUID: os.Getenv("ANKY_SIGNER_UUID"),
+		secret:     os.Getenv("NEYNAR_WEBHOOK_SECRET"),
+		autoReply:  autoReply,
+	}
+}
+
+// VerifyNeynarWebhook checks the signature Neynar sent with the body.
+func VerifyNeynarWebhook(secret string, body []byte, signature string) error {
+	if secret == "" {
+		return fmt.Errorf("%w: NEYNAR_WEBHOOK_SECRET is not set", ErrNeynarWebhookRejected)
+	}
+	sent, err := hex.DecodeString(signature)
+	if err != nil || len(sent) == 0 {
+		return fmt.Errorf("%w: missing or malformed signature", ErrNeynarWebhookRejected)
+	}
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(sent, mac.Sum(nil)) {
+		return fmt.Errorf("%w: invalid signature", ErrNeynarWebhookRejected)
+	}
+	return nil
+}
+
+// HandleWebhook verifies a delivery and records the cast when it is a new
+// cast.created mentioning Anky or replying to one of its casts. It returns
+// the mention to answer, nil when there is nothing to do.
+func (s *FarcasterMentionService) HandleWebhook(ctx context.Context, body []byte, signature string) (*types.FarcasterMention, error) {
+	if err := VerifyNeynarWebhook(s.secret, body, signature); err != nil {
+		return nil, err
+	}
+	var event neynarWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNeynarWebhook, err)
+	}
+	if event.Type != "cast.created" || s.ankyFID == 0 || event.Data.Author.FID == s.ankyFID {
+		return nil, nil
+	}
+
+	cast := event.Data
+	mention := &types.FarcasterMention{
+		CastHash:       cast.Hash,
+		AuthorFID:      cast.Author.FID,
+		AuthorUsername: cast.Author.Username,
+		Text:           cast.Text,
+		ThreadHash:     cast.ThreadHash,
+	}
+	if cast.ParentHash != nil {
+		mention.ParentHash = *cast.ParentHash
+	}
+	switch {
+	case cast.ParentHash != nil && cast.ParentAuthor.FID == s.ankyFID:
+		mention.Kind = types.FarcasterMentionReply
+	case mentionsFID(cast.MentionedProfiles, s.ankyFID):
+		mention.Kind = types.FarcasterMentionMention
+	default:
+		return nil, nil
+	}
+	if mention.CastHash == "" {
+		return nil, fmt.Errorf("%w: cast without a hash", ErrInvalidNeynarWebhook)
+	}
+
+	created, err := s.store.CreateFarcasterMention(ctx, mention)
+	if err != nil || !created {
+		return nil, err
+	}
+	log.Printf("💬 @%s (FID %d) sent Anky a %s: %s", mention.AuthorUsername, mention.AuthorFID, mention.Kind, mention.CastHash)
+	if !s.autoReply {
+		return nil, nil
+	}
+	return mention, nil
+}
+
+func mentionsFID(profiles []types.Author, fid int) bool {
+	for _, profile := range profiles {
+		if profile.FID == fid {
+			return true
+		}
+	}
+	return false
+}
+
+// Reply has Anky answer the mention from its own account, unless it already
+// answered the author enough today. The reply is claimed before it is
+// generated, so concurrent mentions of one author share the daily limit. How
+// it went is recorded on the mention.
+func (s *FarcasterMentionService) Reply(ctx context.Context, mention *types.FarcasterMention) error {
+	since := s.store.Clock().Now().Add(-24 * time.Hour)
+	claimed, err := s.store.ClaimFarcasterMentionReply(ctx, mention.CastHash, mention.AuthorFID, since, maxMentionRepliesPerDay)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("🤐 Not answering @%s again today, Anky already replied %d times", mention.AuthorUsername, maxMentionRepliesPerDay)
+		return s.store.RecordFarcasterMentionReply(ctx, mention.CastHash, "", "daily reply limit reached")
+	}
+
+	cast, err := s.reply(ctx, mention)
+	if err != nil {
+		if recordErr := s.store.RecordFarcasterMentionReply(ctx, mention.CastHash, "", err.Error()); recordErr != nil {
+			log.Printf("❌ Error recording failed reply to %s: %v", mention.CastHash, recordErr)
+		}
+		return err
+	}
+	log.Printf("💬 Anky replied to %s as %s", mention.CastHash, cast.Hash)
+	return s.store.RecordFarcasterMentionReply(ctx, mention.CastHash, cast.Hash, "")
+}
+
+func (s *FarcasterMentionService) reply(ctx context.Context, mention *types.FarcasterMention) (*types.Cast, error) {
+	ankyService, err := NewAnkyService(s.store)
+	if err != nil {
+		return nil, err
+	}
+	text, err := ankyService.GenerateCastReply(ctx, mention.AuthorUsername, mention.Text)
+	if err != nil {
+		return nil, err
+	}
+	parts := splitCastText(strings.TrimSpace(text), 1)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty reply")
+	}
+	return NewFarcasterPublisher().PublishCast(ctx, CastRequest{
+		SignerUUID:     s.signerUUID,
+		Text:           parts[0],
+		ParentHash:     mention.CastHash,
+		IdempotencyKey: "reply-" + mention.CastHash,
+	})
+}
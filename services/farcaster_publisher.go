@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// CastRequest is an Anky's cast, whichever way it ends up published.
+type CastRequest struct {
+	SignerUUID string
+	Text       string
+	ChannelID  string
+	// Hash of the cast this one replies to, cast by the same signer
+	ParentHash string
+	// Neynar casts once per key; hubs have no such thing
+	IdempotencyKey string
+	SessionID      string
+	ImageURLs      []string
+}
+
+// FarcasterPublisher publishes casts to Farcaster.
+type FarcasterPublisher interface {
+	PublishCast(ctx context.Context, cast CastRequest) (*types.Cast, error)
+}
+
+// NewFarcasterPublisher casts through Neynar. When a hub signer is
+// configured, casts the hub can sign for go straight to the hub while
+// Neynar's circuit breaker is open.
+func NewFarcasterPublisher() FarcasterPublisher {
+	neynar := &neynarPublisher{service: NewNeynarService(), apiKey: os.Getenv("NEYNAR_API_KEY")}
+	hub, err := NewHubPublisherFromEnv()
+	if err != nil {
+		log.Printf("⚠️ Farcaster hub fallback disabled: %v", err)
+		return neynar
+	}
+	if hub == nil {
+		return neynar
+	}
+	return &fallbackPublisher{primary: neynar, fallback: hub, breaker: neynarHTTP}
+}
+
+type neynarPublisher struct {
+	service *NeynarService
+	apiKey  string
+}
+
+func (p *neynarPublisher) PublishCast(ctx context.Context, cast CastRequest) (*types.Cast, error) {
+	if cast.ParentHash != "" {
+		return p.service.CreateCastReply(p.apiKey, cast.SignerUUID, cast.ParentHash, cast.Text, cast.IdempotencyKey)
+	}
+	return p.service.WriteCast(p.apiKey, cast.SignerUUID, cast.Text, cast.ChannelID, cast.ParentHash, cast.IdempotencyKey, cast.SessionID, cast.ImageURLs...)
+}
+
+type fallbackPublisher struct {
+	primary  FarcasterPublisher
+	fallback *HubPublisher
+	breaker  *ResilientClient
+}
+
+func (p *fallbackPublisher) PublishCast(ctx context.Context, cast CastRequest) (*types.Cast, error) {
+	if !p.fallback.CanSign(cast.SignerUUID) {
+		return p.primary.PublishCast(ctx, cast)
+	}
+	if p.breaker.CircuitOpen() {
+		log.Printf("🛰️ Neynar circuit is open, casting session %s through the hub", cast.SessionID)
+		return p.fallback.PublishCast(ctx, cast)
+	}
+
+	published, err := p.primary.PublishCast(ctx, cast)
+	if errors.Is(err, ErrCircuitOpen) {
+		log.Printf("🛰️ Neynar circuit opened while casting session %s, retrying through the hub", cast.SessionID)
+		return p.fallback.PublishCast(ctx, cast)
+	}
+	return published, err
+}
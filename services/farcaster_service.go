@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/ankylat/anky/server/logging"
 	"github.com/ankylat/anky/server/storage"
 	"github.com/ankylat/anky/server/types"
 	"github.com/ankylat/anky/server/utils"
@@ -29,7 +30,7 @@ func NewFarcasterService() *FarcasterService {
 
 func (s *FarcasterService) GetLandingFeed() (map[string]interface{}, error) {
 	log.Println("GetLandingFeed: Starting")
-	url := "https://api.neynar.com/v2/farcaster/feed/trending"
+	url := neynarAPIURL + "/feed/trending"
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		log.Printf("GetLandingFeed: Failed to create request: %v", err)
@@ -41,7 +42,7 @@ func (s *FarcasterService) GetLandingFeed() (map[string]interface{}, error) {
 	req.Header.Add("api_key", s.apiKey)
 
 	log.Println("GetLandingFeed: Sending request")
-	res, err := http.DefaultClient.Do(req)
+	res, err := neynarHTTP.Do(req)
 	if err != nil {
 		log.Printf("GetLandingFeed: Failed to send request: %v", err)
 		return nil, fmt.Errorf("failed to send request: %v", err)
@@ -69,7 +70,7 @@ func (s *FarcasterService) GetLandingFeed() (map[string]interface{}, error) {
 
 func (s *FarcasterService) GetLandingFeedForUser(fid int) (map[string]interface{}, error) {
 	log.Printf("GetLandingFeedForUser: Starting with FID %d", fid)
-	url := fmt.Sprintf("https://api.neynar.com/v2/farcaster/feed/user/%d", fid)
+	url := fmt.Sprintf(neynarAPIURL+"/feed/user/%d", fid)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		log.Printf("GetLandingFeedForUser: Failed to create request: %v", err)
@@ -81,7 +82,7 @@ func (s *FarcasterService) GetLandingFeedForUser(fid int) (map[string]interface{
 	req.Header.Add("api_key", s.apiKey)
 
 	log.Println("GetLandingFeedForUser: Sending request")
-	res, err := http.DefaultClient.Do(req)
+	res, err := neynarHTTP.Do(req)
 	if err != nil {
 		log.Printf("GetLandingFeedForUser: Failed to send request: %v", err)
 		return nil, fmt.Errorf("failed to send request: %v", err)
@@ -109,7 +110,7 @@ func (s *FarcasterService) GetLandingFeedForUser(fid int) (map[string]interface{
 
 func (s *FarcasterService) GetUserByFid(fid int) (map[string]interface{}, error) {
 	log.Printf("GetUserByFid: Starting with FID %d", fid)
-	url := fmt.Sprintf("https://api.neynar.com/v2/farcaster/user/bulk?fids=%d", fid)
+	url := fmt.Sprintf(neynarAPIURL+"/user/bulk?fids=%d", fid)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		log.Printf("GetUserByFid: Failed to create request: %v", err)
@@ -121,7 +122,7 @@ func (s *FarcasterService) GetUserByFid(fid int) (map[string]interface{}, error)
 	req.Header.Add("api_key", s.apiKey)
 
 	log.Println("GetUserByFid: Sending request")
-	res, err := http.DefaultClient.Do(req)
+	res, err := neynarHTTP.Do(req)
 	if err != nil {
 		log.Printf("GetUserByFid: Failed to send request: %v", err)
 		return nil, fmt.Errorf("failed to send request: %v", err)
@@ -169,8 +170,8 @@ func (s *FarcasterService) GetUserByFid(fid int) (map[string]interface{}, error)
 }
 
 func (s *FarcasterService) CreateCast(signerUUID, text string) (map[string]interface{}, error) {
-	log.Printf("CreateCast: Starting with signerUUID %s and text %s", signerUUID, text)
-	url := "https://api.neynar.com/v2/farcaster/cast"
+	log.Printf("CreateCast: Starting with signerUUID %s and text %s", logging.Secret(signerUUID), text)
+	url := neynarAPIURL + "/cast"
 	payload := map[string]interface{}{
 		"signer_uuid": signerUUID,
 		"text":        text,
@@ -180,13 +181,13 @@ func (s *FarcasterService) CreateCast(signerUUID, text string) (map[string]inter
 
 func (s *FarcasterService) GetUserCasts(fid int, cursor string, limit int) (map[string]interface{}, error) {
 	log.Printf("GetUserCasts: Starting with FID %d, cursor %s, limit %d", fid, cursor, limit)
-	url := fmt.Sprintf("https://api.neynar.com/v2/farcaster/casts?fid=%d&cursor=%s&limit=%d", fid, cursor, limit)
+	url := fmt.Sprintf(neynarAPIURL+"/casts?fid=%d&cursor=%s&limit=%d", fid, cursor, limit)
 	return s.makeRequest("GET", url, nil)
 }
 
 func (s *FarcasterService) CreateCastReaction(signerUUID, targetCastHash, reactionType string) (map[string]interface{}, error) {
-	log.Printf("CreateCastReaction: Starting with signerUUID %s, targetCastHash %s, reactionType %s", signerUUID, targetCastHash, reactionType)
-	url := "https://api.neynar.com/v2/farcaster/reaction"
+	log.Printf("CreateCastReaction: Starting with signerUUID %s, targetCastHash %s, reactionType %s", logging.Secret(signerUUID), targetCastHash, reactionType)
+	url := neynarAPIURL + "/reaction"
 	payload := map[string]interface{}{
 		"signer_uuid":      signerUUID,
 		"target_cast_hash": targetCastHash,
@@ -197,7 +198,7 @@ func (s *FarcasterService) CreateCastReaction(signerUUID, targetCastHash, reacti
 
 func (s *FarcasterService) GetCastByHash(hash string) (map[string]interface{}, error) {
 	log.Printf("GetCastByHash: Starting with hash %s", hash)
-	url := fmt.Sprintf("https://api.neynar.com/v2/farcaster/cast?identifier=%s&type=hash", hash)
+	url := fmt.Sprintf(neynarAPIURL+"/cast?identifier=%s&type=hash", hash)
 	return s.makeRequest("GET", url, nil)
 }
 
@@ -228,8 +229,7 @@ func (s *FarcasterService) makeRequest(method, url string, payload interface{})
 	}
 
 	log.Println("makeRequest: Sending request")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := neynarHTTP.Do(req)
 	if err != nil {
 		log.Printf("makeRequest: Failed to send request: %v", err)
 		return nil, fmt.Errorf("failed to send request: %v", err)
@@ -254,50 +254,64 @@ func (s *FarcasterService) makeRequest(method, url string, payload interface{})
 	return result, nil
 }
 
-func publishAnkyToFarcaster(writing string, sessionID string, userID string, ticker string, token_name string, userSignerUUID string, imageIPFSHash string) (*types.Cast, error) {
-	log.Printf("Publishing to Farcaster for session ID: %s", sessionID)
-	fmt.Println("Publishing to Farcaster for session ID:", sessionID)
+// ankyCastText is the text of an Anky's cast, which asks clanker to deploy its
+// token. Ankys of seasons without tokens are cast without it.
+func ankyCastText(sessionID string, ticker string, tokenName string) string {
+	if ticker == "" {
+		return utils.TranslateToTheAnkyverse(sessionID)
+	}
+	return utils.TranslateToTheAnkyverse(sessionID) + "\n\n@clanker $" + ticker + " \"" + tokenName + "\""
+}
 
-	neynarService := NewNeynarService()
-	fmt.Println("NeynarService initialized:", neynarService)
+// castImageURLs puts the pinned image ahead of the generator's URLs, which
+// may expire while the gateway's doesn't.
+func castImageURLs(imageIPFSHash string, imageURLs []string) []string {
+	if imageIPFSHash == "" {
+		return imageURLs
+	}
+	return append([]string{IPFSGatewayURL(imageIPFSHash)}, imageURLs...)
+}
 
-	sessionIdOnTheAnkyverse := utils.TranslateToTheAnkyverse(sessionID)
-	castText := sessionIdOnTheAnkyverse + "\n\n@clanker $" + ticker + " \"" + token_name + "\""
+func publishAnkyToFarcaster(sessionID string, userID string, ticker string, token_name string, userSignerUUID string, imageIPFSHash string, imageURLs []string) (*types.Cast, error) {
+	log.Printf("Publishing to Farcaster for session ID: %s", sessionID)
 
-	fmt.Println("Cast Text prepared:", castText)
+	castText := ankyCastText(sessionID, ticker, token_name)
 
 	apiKey := os.Getenv("NEYNAR_API_KEY")
 	signerUUID := os.Getenv("ANKY_SIGNER_UUID")
 	channelID := "anky"
 	idempotencyKey := sessionID
 
-	log.Printf("API Key: %s", apiKey)
-	log.Printf("Signer UUID: %s", signerUUID)
+	log.Printf("API Key: %s", logging.Secret(apiKey))
+	log.Printf("Signer UUID: %s", logging.Secret(signerUUID))
 	log.Printf("Channel ID: %s", channelID)
 	log.Printf("idempotencyKey: %s", idempotencyKey)
 	log.Printf("Cast Text: %s", castText)
 
-	fmt.Println("API Key:", apiKey)
-	fmt.Println("Signer UUID:", signerUUID)
-	fmt.Println("Channel ID:", channelID)
-	fmt.Println("idempotencyKey:", idempotencyKey)
-	fmt.Println("Cast Text:", castText)
-
-	castResponse, err := neynarService.WriteCast(apiKey, userSignerUUID, castText, channelID, idempotencyKey, sessionID)
+	castResponse, err := NewFarcasterPublisher().PublishCast(context.Background(), CastRequest{
+		SignerUUID:     userSignerUUID,
+		Text:           castText,
+		ChannelID:      channelID,
+		IdempotencyKey: idempotencyKey,
+		SessionID:      sessionID,
+		ImageURLs:      castImageURLs(imageIPFSHash, imageURLs),
+	})
 	if err != nil {
 		log.Printf("Error publishing to Farcaster: %v", err)
-		fmt.Println("Error publishing to Farcaster:", err)
 		return nil, err
 	}
 
 	log.Printf("Farcaster publishing completed for session ID: %s", sessionID)
-	fmt.Println("Farcaster publishing completed for session ID:", sessionID)
 
 	return castResponse, nil
 }
 
 func (s *FarcasterService) PublishFirstUserAnkyToFarcaster(userId uuid.UUID) {
 	log.Printf("🚀 Starting to publish first Anky to Farcaster for user ID: %s", userId)
+	if err := CheckFeature(FeatureCasting); err != nil {
+		log.Printf("🛑 Leaving the Ankys of user %s pending: %v", userId, err)
+		return
+	}
 
 	// Create context
 	ctx := context.Background()
@@ -335,7 +349,7 @@ func (s *FarcasterService) PublishFirstUserAnkyToFarcaster(userId uuid.UUID) {
 		log.Printf("⚠️ User %s does not have Farcaster credentials configured", userId)
 		return
 	}
-	log.Printf("🔑 Found Farcaster credentials for user. Signer UUID: %s", user.FarcasterUser.SignerUUID)
+	log.Printf("🔑 Found Farcaster credentials for user. Signer UUID: %s", logging.Secret(user.FarcasterUser.SignerUUID))
 
 	// Prepare cast text
 	log.Println("📝 Preparing cast text...")
@@ -343,25 +357,32 @@ func (s *FarcasterService) PublishFirstUserAnkyToFarcaster(userId uuid.UUID) {
 	castText := translatedAnkySessionID + "@clanker $" + pendingAnkys[0].Ticker + " \"" + pendingAnkys[0].TokenName + "\""
 	log.Printf("✍️ Generated cast text: %s", castText)
 
+	if err := store.AttachAnkyImages(ctx, pendingAnkys...); err != nil {
+		log.Printf("⚠️ Failed to load image collections, casting single images: %v", err)
+	}
+
 	// Cast each pending anky
 	log.Printf("🎭 Starting to process %d pending Ankys", len(pendingAnkys))
 	for i, anky := range pendingAnkys {
 		log.Printf("📣 Publishing Anky %d/%d (ID: %s) to Farcaster", i+1, len(pendingAnkys), anky.ID)
 
 		castResponse, err := publishAnkyToFarcaster(
-			castText,
 			anky.WritingSessionID.String(),
 			userId.String(),
 			anky.Ticker,
 			anky.TokenName,
 			user.FarcasterUser.SignerUUID,
 			anky.ImageIPFSHash,
+			collectionImageURLs(anky.Images),
 		)
 		if err != nil {
 			log.Printf("❌ Failed to publish Anky %s to Farcaster: %v", anky.ID, err)
 			continue
 		}
 		log.Printf("✅ Successfully published Anky to Farcaster. Cast hash: %s", castResponse.Hash)
+		if storyReplyEnabled(nil) {
+			replyWithStory(ctx, castResponse, user.FarcasterUser.SignerUUID, anky.AnkyReflection)
+		}
 
 		// Update anky status
 		log.Printf("📝 Updating Anky %s status to completed", anky.ID)
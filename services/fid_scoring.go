@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	defaultFIDAcceptThreshold = 0.6
+	defaultFIDReviewThreshold = 0.35
+)
+
+// FIDRequestSignals is everything the scorers get to look at for one FID request.
+type FIDRequestSignals struct {
+	User *types.User
+	// Only the verified ones, see types.WritingSession.Verified
+	Sessions          []*types.WritingSession
+	DeviceFingerprint string
+	IPAddress         string
+}
+
+// FIDScorer scores one aspect of a FID request between 0 (bot) and 1 (human).
+type FIDScorer interface {
+	Name() string
+	Score(ctx context.Context, signals *FIDRequestSignals) (float64, error)
+}
+
+// WeightedFIDScorer is a scorer and how much it counts toward the final score.
+type WeightedFIDScorer struct {
+	Scorer FIDScorer
+	Weight float64
+}
+
+// FIDScoringService decides whether a user gets one of the season's FIDs
+// right away, goes to the manual review queue, or is rejected.
+type FIDScoringService struct {
+	store           *storage.PostgresStore
+	scorers         []WeightedFIDScorer
+	acceptThreshold float64
+	reviewThreshold float64
+}
+
+// NewFIDScoringService uses the default scorers unless others are given.
+// Thresholds come from FID_SCORE_ACCEPT_THRESHOLD and FID_SCORE_REVIEW_THRESHOLD.
+func NewFIDScoringService(store *storage.PostgresStore, scorers ...WeightedFIDScorer) *FIDScoringService {
+	if len(scorers) == 0 {
+		scorers = DefaultFIDScorers(store)
+	}
+	return &FIDScoringService{
+		store:           store,
+		scorers:         scorers,
+		acceptThreshold: envFloat("FID_SCORE_ACCEPT_THRESHOLD", defaultFIDAcceptThreshold),
+		reviewThreshold: envFloat("FID_SCORE_REVIEW_THRESHOLD", defaultFIDReviewThreshold),
+	}
+}
+
+func DefaultFIDScorers(store *storage.PostgresStore) []WeightedFIDScorer {
+	return []WeightedFIDScorer{
+		{Scorer: AccountAgeScorer{}, Weight: 0.2},
+		{Scorer: SessionHistoryScorer{}, Weight: 0.3},
+		{Scorer: DeviceFingerprintScorer{store: store}, Weight: 0.25},
+		{Scorer: WritingQualityScorer{}, Weight: 0.25},
+		{Scorer: KeystrokeCadenceScorer{}, Weight: 0.25},
+	}
+}
+
+// Evaluate scores the request and records it. Users that were already accepted
+// or approved, or are waiting for review, are not re-scored; users the scoring
+// rejected are scored again since their history may have grown. A moderator's
+// verdict stands, the scoring never overrides a reviewed request.
+func (s *FIDScoringService) Evaluate(ctx context.Context, userID uuid.UUID, deviceFingerprint string, ipAddress string) (*types.FIDRequest, error) {
+	previous, err := s.store.GetLatestFIDRequestByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if previous != nil && (previous.Status != types.FIDRequestRejected || previous.ReviewedAt != nil) {
+		return previous, nil
+	}
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	sessions, err := s.store.GetUserWritingSessions(ctx, userID, false, 50, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error getting writing sessions: %w", err)
+	}
+	sessions = verifiedSessions(sessions)
+
+	signals := &FIDRequestSignals{
+		User:              user,
+		Sessions:          sessions,
+		DeviceFingerprint: deviceFingerprint,
+		IPAddress:         ipAddress,
+	}
+
+	request := &types.FIDRequest{
+		UserID:            userID,
+		DeviceFingerprint: deviceFingerprint,
+		IPAddress:         ipAddress,
+		Signals:           make(map[string]float64, len(s.scorers)),
+	}
+
+	var total, weights float64
+	for _, weighted := range s.scorers {
+		score, err := weighted.Scorer.Score(ctx, signals)
+		if err != nil {
+			return nil, fmt.Errorf("error running %s scorer: %w", weighted.Scorer.Name(), err)
+		}
+		score = math.Max(0, math.Min(1, score))
+		request.Signals[weighted.Scorer.Name()] = score
+		total += score * weighted.Weight
+		weights += weighted.Weight
+	}
+	if weights > 0 {
+		request.Score = total / weights
+	}
+
+	// Users with sessions that don't look typed by hand never get a FID
+	// without a moderator looking at them
+	suspect := 0
+	for _, session := range sessions {
+		if session.Suspect {
+			suspect++
+		}
+	}
+
+	switch {
+	case request.Score >= s.acceptThreshold && suspect == 0:
+		request.Decision, request.Status = types.FIDDecisionAccept, types.FIDRequestAccepted
+	case request.Score >= s.reviewThreshold:
+		request.Decision, request.Status = types.FIDDecisionReview, types.FIDRequestPending
+	default:
+		request.Decision, request.Status = types.FIDDecisionReject, types.FIDRequestRejected
+	}
+
+	if err := s.store.CreateFIDRequest(ctx, request); err != nil {
+		return nil, err
+	}
+	log.Printf("🛡️ FID request for user %s scored %.2f (%s): %v", userID, request.Score, request.Decision, request.Signals)
+	return request, nil
+}
+
+// verifiedSessions keeps the sessions that came with their keystroke log,
+// only they count toward a FID.
+func verifiedSessions(sessions []*types.WritingSession) []*types.WritingSession {
+	verified := make([]*types.WritingSession, 0, len(sessions))
+	for _, session := range sessions {
+		if session.Verified() {
+			verified = append(verified, session)
+		}
+	}
+	return verified
+}
+
+// AccountAgeScorer trusts accounts more the longer they have existed, up to a week.
+type AccountAgeScorer struct{}
+
+func (AccountAgeScorer) Name() string { return "account_age" }
+
+func (AccountAgeScorer) Score(ctx context.Context, signals *FIDRequestSignals) (float64, error) {
+	age := time.Since(signals.User.CreatedAt)
+	return age.Hours() / (7 * 24), nil
+}
+
+// SessionHistoryScorer rewards users that came back to write more than once.
+// Suspect sessions don't count as Ankys.
+type SessionHistoryScorer struct{}
+
+func (SessionHistoryScorer) Name() string { return "session_history" }
+
+func (SessionHistoryScorer) Score(ctx context.Context, signals *FIDRequestSignals) (float64, error) {
+	ankys := 0
+	days := make(map[string]bool)
+	for _, session := range signals.Sessions {
+		if session.IsAnky && !session.Suspect {
+			ankys++
+		}
+		days[session.StartingTimestamp.UTC().Format("2006-01-02")] = true
+	}
+	return 0.5*math.Min(float64(ankys)/2, 1) + 0.5*math.Min(float64(len(days))/3, 1), nil
+}
+
+// DeviceFingerprintScorer distrusts devices that already requested FIDs for other users.
+type DeviceFingerprintScorer struct {
+	store *storage.PostgresStore
+}
+
+func (DeviceFingerprintScorer) Name() string { return "device_fingerprint" }
+
+func (d DeviceFingerprintScorer) Score(ctx context.Context, signals *FIDRequestSignals) (float64, error) {
+	if signals.DeviceFingerprint == "" {
+		return 0.5, nil
+	}
+	others, err := d.store.CountUsersSharingFingerprint(ctx, signals.DeviceFingerprint, signals.User.ID)
+	if err != nil {
+		return 0, err
+	}
+	switch others {
+	case 0:
+		return 1, nil
+	case 1:
+		return 0.5, nil
+	default:
+		return 0, nil
+	}
+}
+
+// WritingQualityScorer looks for the marks of scripted writing: tiny
+// vocabularies, repeated sessions and keyboard mashing.
+type WritingQualityScorer struct{}
+
+func (WritingQualityScorer) Name() string { return "writing_quality" }
+
+func (WritingQualityScorer) Score(ctx context.Context, signals *FIDRequestSignals) (float64, error) {
+	var words []string
+	seen := make(map[string]bool)
+	duplicates := 0
+	for _, session := range signals.Sessions {
+		writing := strings.TrimSpace(session.Writing)
+		if writing == "" {
+			continue
+		}
+		if seen[writing] {
+			duplicates++
+		}
+		seen[writing] = true
+		words = append(words, strings.Fields(strings.ToLower(writing))...)
+	}
+	if len(words) == 0 {
+		return 0, nil
+	}
+
+	unique := make(map[string]bool)
+	totalLength := 0
+	for _, word := range words {
+		unique[word] = true
+		totalLength += len(word)
+	}
+
+	// Real writing reuses words but not that much, and averages 3-8 letters a word
+	vocabulary := math.Min(float64(len(unique))/float64(len(words))/0.3, 1)
+	averageLength := float64(totalLength) / float64(len(words))
+	wordShape := 1.0
+	if averageLength < 3 || averageLength > 8 {
+		wordShape = 0.3
+	}
+	repetition := 1 - math.Min(float64(duplicates)/float64(len(seen)), 1)
+
+	return (vocabulary + wordShape + repetition) / 3, nil
+}
+
+// KeystrokeCadenceScorer distrusts users whose sessions don't look typed by
+// hand, see utils.AnalyzeCadence.
+type KeystrokeCadenceScorer struct{}
+
+func (KeystrokeCadenceScorer) Name() string { return "keystroke_cadence" }
+
+func (KeystrokeCadenceScorer) Score(ctx context.Context, signals *FIDRequestSignals) (float64, error) {
+	analyzed, suspect := 0, 0
+	for _, session := range signals.Sessions {
+		if session.CheatMetrics == nil {
+			continue
+		}
+		analyzed++
+		if session.Suspect {
+			suspect++
+		}
+	}
+	if analyzed == 0 {
+		return 0.5, nil
+	}
+	// A few suspect sessions weigh as much as all of them
+	return 1 - math.Min(float64(suspect)/float64(analyzed)*2, 1), nil
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return fallback
+}
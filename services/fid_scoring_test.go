@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+func TestEvaluateKeepsTheModeratorsVerdict(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	user := &types.User{ID: uuid.New(), CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC()}
+	if err := store.CreateUserWithRelations(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	scoring := NewFIDScoringService(store)
+
+	// Rejected by the scoring alone, the user is scored again
+	scored := &types.FIDRequest{UserID: user.ID, Decision: types.FIDDecisionReject, Status: types.FIDRequestRejected, Signals: map[string]float64{}}
+	if err := store.CreateFIDRequest(ctx, scored); err != nil {
+		t.Fatal(err)
+	}
+	request, err := scoring.Evaluate(ctx, user.ID, "device-1", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if request.ID == scored.ID {
+		t.Fatal("a request the scoring rejected wasn't scored again")
+	}
+
+	// Rejected by a moderator, the verdict stands
+	if request.Status != types.FIDRequestPending {
+		request = &types.FIDRequest{UserID: user.ID, Decision: types.FIDDecisionReview, Status: types.FIDRequestPending, Signals: map[string]float64{}}
+		if err := store.CreateFIDRequest(ctx, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.ReviewFIDRequest(ctx, request.ID, types.FIDRequestRejected, "not typed by hand"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := scoring.Evaluate(ctx, user.ID, "device-1", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if again.ID != request.ID || again.Status != types.FIDRequestRejected {
+		t.Errorf("request = %s %s, want the reviewed request %s to stand", again.ID, again.Status, request.ID)
+	}
+}
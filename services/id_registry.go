@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Farcaster's IdGateway on Optimism, which registers FIDs in the ID Registry
+// and checks the signatures of registerFor against its nonces.
+const defaultIDGatewayAddress = "0x00000000Fc25870C6eD6b6c7E41Fb078b7656f69"
+
+const idRegistryCallTimeout = 10 * time.Second
+
+// Only the function we call, the contracts' ABIs are much larger
+const noncesABI = `[{"type":"function","name":"nonces","stateMutability":"view","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}]`
+
+var ErrIDRegistryNotConfigured = errors.New("OPTIMISM_RPC_URL is not set, FID registration nonces can't be read")
+
+// IDRegistryService reads the registration state of custody addresses from
+// Farcaster's contracts, through the Optimism node at OPTIMISM_RPC_URL.
+// FARCASTER_ID_GATEWAY_ADDRESS overrides the contract the nonces are read
+// from.
+type IDRegistryService struct {
+	rpcURL  string
+	gateway common.Address
+}
+
+func NewIDRegistryService() *IDRegistryService {
+	gateway := os.Getenv("FARCASTER_ID_GATEWAY_ADDRESS")
+	if !common.IsHexAddress(gateway) {
+		gateway = defaultIDGatewayAddress
+	}
+	return &IDRegistryService{
+		rpcURL:  os.Getenv("OPTIMISM_RPC_URL"),
+		gateway: common.HexToAddress(gateway),
+	}
+}
+
+// Nonce returns the nonce the next registration signed by the custody
+// address must carry. It goes up with every registration, so a signature
+// made with a stale one is rejected on chain.
+func (s *IDRegistryService) Nonce(ctx context.Context, custody common.Address) (*big.Int, error) {
+	if s.rpcURL == "" {
+		return nil, ErrIDRegistryNotConfigured
+	}
+	ctx, cancel := context.WithTimeout(ctx, idRegistryCallTimeout)
+	defer cancel()
+
+	client, err := ethclient.DialContext(ctx, s.rpcURL)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the Optimism node: %w", err)
+	}
+	defer client.Close()
+
+	parsed, err := abi.JSON(strings.NewReader(noncesABI))
+	if err != nil {
+		return nil, err
+	}
+	input, err := parsed.Pack("nonces", custody)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding nonces call: %w", err)
+	}
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &s.gateway, Data: input}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error calling nonces on %s: %w", s.gateway.Hex(), err)
+	}
+
+	var nonce *big.Int
+	if err := parsed.UnpackIntoInterface(&nonce, "nonces", output); err != nil {
+		return nil, fmt.Errorf("error decoding nonce of %s: %w", custody.Hex(), err)
+	}
+	return nonce, nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// How long a response is replayed to retries
+	IdempotencyKeyTTL = 24 * time.Hour
+	// A request still running after this is taken to have died with its server
+	idempotencyAbandonedAfter = 5 * time.Minute
+)
+
+var (
+	ErrIdempotencyKeyReused = errors.New("this idempotency key was already used for a different request")
+	ErrRequestInProgress    = errors.New("a request with this idempotency key is still being processed")
+)
+
+// IdempotencyService remembers the response to each request sent with an
+// idempotency key, so retries get the original response instead of running
+// the request again.
+type IdempotencyService struct {
+	store *storage.PostgresStore
+}
+
+func NewIdempotencyService(store *storage.PostgresStore) *IdempotencyService {
+	return &IdempotencyService{store: store}
+}
+
+// Claim starts a request with the key. It returns nil when the request should
+// run, or the completed record whose response should be replayed. It fails
+// with ErrRequestInProgress while the first request runs, and with
+// ErrIdempotencyKeyReused when the key was used for a different body.
+func (s *IdempotencyService) Claim(ctx context.Context, route string, key string, body []byte) (*types.IdempotencyRecord, error) {
+	hash := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(hash[:])
+
+	now := s.store.Clock().Now()
+	record, err := s.store.ClaimIdempotencyKey(ctx, route, key, requestHash, now.Add(-idempotencyAbandonedAfter), now.Add(-IdempotencyKeyTTL))
+	if err != nil || record == nil {
+		return nil, err
+	}
+	if record.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if record.CompletedAt == nil {
+		return nil, ErrRequestInProgress
+	}
+	return record, nil
+}
+
+// Complete stores the response replayed for the key.
+func (s *IdempotencyService) Complete(ctx context.Context, route string, key string, statusCode int, contentType string, response []byte) error {
+	return s.store.CompleteIdempotencyKey(ctx, route, key, statusCode, contentType, response)
+}
+
+// Release lets the key be used again, for requests that failed.
+func (s *IdempotencyService) Release(ctx context.Context, route string, key string) error {
+	return s.store.ReleaseIdempotencyKey(ctx, route, key)
+}
+
+// StartCleanupJob blocks, deleting keys older than IdempotencyKeyTTL every
+// interval.
+func (s *IdempotencyService) StartCleanupJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.store.DeleteIdempotencyKeysBefore(ctx, s.store.Clock().Now().Add(-IdempotencyKeyTTL))
+			if err != nil {
+				log.Printf("❌ Error deleting expired idempotency keys: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("🧹 Deleted %d expired idempotency keys", deleted)
+			}
+		}
+	}
+}
+
+func IdempotencyCleanupIntervalFromEnv() time.Duration {
+	if value := os.Getenv("IDEMPOTENCY_CLEANUP_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return time.Hour
+}
@@ -0,0 +1,579 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Image backends IMAGE_PROVIDER can select
+const (
+	// Midjourney through the Directus instance of imagine
+	ImageProviderMidjourney = "midjourney"
+	// SDXL, or any other text to image model, on Replicate
+	ImageProviderReplicate = "replicate"
+	// DALL·E
+	ImageProviderOpenAI = "openai"
+)
+
+// Statuses of an ImageJob
+const (
+	ImageJobPending   = "pending"
+	ImageJobCompleted = "completed"
+	ImageJobFailed    = "failed"
+)
+
+var ErrImageWebhookRejected = errors.New("image webhook rejected")
+
+// ImageJob is one prompt being turned into images by a backend.
+type ImageJob struct {
+	ID     string
+	Status string
+	// The image a single picture is made from: Midjourney's grid, the first
+	// image of the others
+	URL string
+	// The candidates the writer chooses among, Midjourney's upscales
+	CandidateURLs []string
+	Error         string
+}
+
+// ImageGenerator turns prompts into images on one backend. Jobs are
+// asynchronous: Submit starts one and Status reports on it, or the backend
+// calls POST /webhooks/images/{provider} when it is done. Backends that
+// answer right away return a finished job from Submit.
+type ImageGenerator interface {
+	Name() string
+	Submit(ctx context.Context, prompt string) (*ImageJob, error)
+	Status(ctx context.Context, jobID string) (*ImageJob, error)
+	// ParseWebhook reads the job out of the body of a completion callback
+	ParseWebhook(body []byte) (*ImageJob, error)
+}
+
+// ImageGeneratorConfig is read from the environment:
+//
+//	IMAGE_PROVIDER        midjourney (default), replicate or openai
+//	IMAGE_MODEL           Replicate model version or OpenAI model
+//	IMAGE_BASE_URL        API root, the imagine Directus instance by default
+//	IMAGE_API_KEY         falls back to IMAGINE_API_TOKEN, REPLICATE_API_TOKEN or OPENAI_API_KEY
+//	IMAGE_CANDIDATES      images per prompt on Replicate and OpenAI, 4 by default
+//	IMAGE_WEBHOOK_URL     public URL of POST /webhooks/images/{provider}, unset to only poll
+//	IMAGE_WEBHOOK_SECRET  token the webhook URL carries, required with IMAGE_WEBHOOK_URL
+//	IMAGE_POLL_SECONDS    between status checks, 5 by default and 30 with webhooks
+type ImageGeneratorConfig struct {
+	Provider string
+	Model    string
+	BaseURL  string
+	APIKey   string
+	// The variable APIKey falls back to, for the errors
+	APIKeyEnv     string
+	Candidates    int
+	WebhookURL    string
+	WebhookSecret string
+	PollInterval  time.Duration
+}
+
+func ImageGeneratorConfigFromEnv() ImageGeneratorConfig {
+	config := ImageGeneratorConfig{
+		Provider:      strings.ToLower(os.Getenv("IMAGE_PROVIDER")),
+		Model:         os.Getenv("IMAGE_MODEL"),
+		BaseURL:       strings.TrimSuffix(os.Getenv("IMAGE_BASE_URL"), "/"),
+		APIKey:        os.Getenv("IMAGE_API_KEY"),
+		Candidates:    4,
+		WebhookURL:    os.Getenv("IMAGE_WEBHOOK_URL"),
+		WebhookSecret: os.Getenv("IMAGE_WEBHOOK_SECRET"),
+		PollInterval:  5 * time.Second,
+	}
+	if value := os.Getenv("IMAGE_CANDIDATES"); value != "" {
+		if candidates, err := strconv.Atoi(value); err == nil && candidates > 0 {
+			config.Candidates = candidates
+		}
+	}
+	if config.WebhookURL != "" && config.WebhookSecret == "" {
+		log.Printf("⚠️ IMAGE_WEBHOOK_URL is set without IMAGE_WEBHOOK_SECRET, polling image jobs instead")
+		config.WebhookURL = ""
+	}
+	if config.WebhookURL != "" {
+		// Polling only catches the callbacks that reached another instance
+		config.PollInterval = 30 * time.Second
+	}
+	if value := os.Getenv("IMAGE_POLL_SECONDS"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.PollInterval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	switch config.Provider {
+	case ImageProviderReplicate:
+		// stability-ai/sdxl
+		config.withDefaults("7762fd07cf82c948538e41f63f77d685e02b063e37e496e96eefd46c929f9bdc", "https://api.replicate.com/v1", "REPLICATE_API_TOKEN")
+	case ImageProviderOpenAI:
+		config.withDefaults("dall-e-3", "https://api.openai.com/v1", "OPENAI_API_KEY")
+	default:
+		if config.Provider != "" && config.Provider != ImageProviderMidjourney {
+			log.Printf("⚠️ Unknown IMAGE_PROVIDER %q, using midjourney", config.Provider)
+		}
+		config.Provider = ImageProviderMidjourney
+		config.withDefaults("", "http://localhost:8055", "IMAGINE_API_TOKEN")
+	}
+	return config
+}
+
+func (c *ImageGeneratorConfig) withDefaults(model string, baseURL string, apiKeyEnv string) {
+	if c.Model == "" {
+		c.Model = model
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = baseURL
+	}
+	c.APIKeyEnv = apiKeyEnv
+	if c.APIKey == "" {
+		c.APIKey = os.Getenv(apiKeyEnv)
+	}
+}
+
+// callbackURL is where the backend reports finished jobs, "" without
+// webhooks.
+func (c ImageGeneratorConfig) callbackURL() string {
+	if c.WebhookURL == "" {
+		return ""
+	}
+	callback := strings.TrimSuffix(c.WebhookURL, "/") + "/" + c.Provider
+	return callback + "?token=" + url.QueryEscape(c.WebhookSecret)
+}
+
+// NewImageGenerator builds the generator the config selects.
+func NewImageGenerator(config ImageGeneratorConfig) ImageGenerator {
+	switch config.Provider {
+	case ImageProviderReplicate:
+		return &replicateImageGenerator{config: config}
+	case ImageProviderOpenAI:
+		return &openAIImageGenerator{config: config}
+	default:
+		return &midjourneyImageGenerator{config: config}
+	}
+}
+
+var (
+	defaultImageGeneratorOnce sync.Once
+	defaultImageGenerator     ImageGenerator
+	defaultImageConfig        ImageGeneratorConfig
+)
+
+// imageGenerator is built on first use, after main has loaded .env.
+func imageGenerator() (ImageGenerator, ImageGeneratorConfig) {
+	defaultImageGeneratorOnce.Do(func() {
+		defaultImageConfig = ImageGeneratorConfigFromEnv()
+		webhooks := "polling"
+		if defaultImageConfig.WebhookURL != "" {
+			webhooks = "webhooks"
+		}
+		log.Printf("🖼️ Using %s image generator at %s with %s", defaultImageConfig.Provider, defaultImageConfig.BaseURL, webhooks)
+		defaultImageGenerator = NewImageGenerator(defaultImageConfig)
+	})
+	return defaultImageGenerator, defaultImageConfig
+}
+
+// submitImageJob starts generating images for the prompt on the configured
+// backend.
+func submitImageJob(ctx context.Context, prompt string) (*ImageJob, error) {
+	if err := CheckFeature(FeatureImageGeneration); err != nil {
+		return nil, err
+	}
+	if err := CheckCapability(CapabilityImageGeneration); err != nil {
+		return nil, err
+	}
+
+	generator, _ := imageGenerator()
+	job, err := generator.Submit(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("error submitting image job to %s: %w", generator.Name(), err)
+	}
+	log.Printf("🖼️ Submitted %s image job %s", generator.Name(), job.ID)
+	return job, nil
+}
+
+// awaitImageJob waits until the job is done, woken up by its webhook or by
+// polling the backend, and returns it completed with at least one image.
+func awaitImageJob(ctx context.Context, job *ImageJob) (*ImageJob, error) {
+	generator, config := imageGenerator()
+	provider := generator.Name()
+	started := time.Now()
+
+	if job.Status == ImageJobPending {
+		completions := watchImageJob(job.ID)
+		defer unwatchImageJob(job.ID)
+		ticker := time.NewTicker(config.PollInterval)
+		defer ticker.Stop()
+
+		for job.Status == ImageJobPending {
+			select {
+			case <-ctx.Done():
+				imageJobDuration.Observe(time.Since(started).Seconds(), provider, "canceled")
+				return nil, ctx.Err()
+			case completed := <-completions:
+				job = completed
+			case <-ticker.C:
+				imageJobPolls.Inc(provider)
+				status, err := generator.Status(ctx, job.ID)
+				if err != nil {
+					imageJobDuration.Observe(time.Since(started).Seconds(), provider, "error")
+					return nil, fmt.Errorf("error checking image job %s: %w", job.ID, err)
+				}
+				job = status
+			}
+		}
+	}
+
+	if job.Status == ImageJobFailed {
+		imageJobDuration.Observe(time.Since(started).Seconds(), provider, "failed")
+		if job.Error != "" {
+			return nil, fmt.Errorf("image generation failed: %s", job.Error)
+		}
+		return nil, fmt.Errorf("image generation failed")
+	}
+	if len(job.CandidateURLs) == 0 {
+		imageJobDuration.Observe(time.Since(started).Seconds(), provider, "failed")
+		return nil, fmt.Errorf("no upscaled images available")
+	}
+	if job.URL == "" {
+		job.URL = job.CandidateURLs[0]
+	}
+	imageJobDuration.Observe(time.Since(started).Seconds(), provider, "completed")
+	return job, nil
+}
+
+// generateImages runs the prompt through the configured backend and waits for
+// its images.
+func generateImages(ctx context.Context, prompt string) (*ImageJob, error) {
+	job, err := submitImageJob(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return awaitImageJob(ctx, job)
+}
+
+// imageJobWatchers are the jobs this instance waits on, so their webhook
+// wakes the wait up instead of the next poll.
+var imageJobWatchers = struct {
+	sync.Mutex
+	jobs map[string]chan *ImageJob
+}{jobs: make(map[string]chan *ImageJob)}
+
+func watchImageJob(jobID string) <-chan *ImageJob {
+	imageJobWatchers.Lock()
+	defer imageJobWatchers.Unlock()
+	completions := make(chan *ImageJob, 1)
+	imageJobWatchers.jobs[jobID] = completions
+	return completions
+}
+
+func unwatchImageJob(jobID string) {
+	imageJobWatchers.Lock()
+	defer imageJobWatchers.Unlock()
+	delete(imageJobWatchers.jobs, jobID)
+}
+
+// HandleImageWebhook hands the job a backend reports on to whoever waits for
+// it on this instance. Callbacks for another provider or without the webhook
+// secret wrap ErrImageWebhookRejected. Jobs nobody waits on here are
+// ignored, the instance that submitted them finds out by polling.
+func HandleImageWebhook(provider string, token string, body []byte) (*ImageJob, error) {
+	generator, config := imageGenerator()
+	if config.WebhookURL == "" || provider != generator.Name() {
+		return nil, fmt.Errorf("%w: no webhooks expected from %s", ErrImageWebhookRejected, provider)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.WebhookSecret)) != 1 {
+		return nil, fmt.Errorf("%w: invalid token", ErrImageWebhookRejected)
+	}
+
+	job, err := generator.ParseWebhook(body)
+	if err != nil {
+		imageJobWebhooks.Inc(provider, "invalid")
+		return nil, err
+	}
+	if job.Status == ImageJobPending {
+		imageJobWebhooks.Inc(provider, "pending")
+		return job, nil
+	}
+
+	imageJobWatchers.Lock()
+	completions, ok := imageJobWatchers.jobs[job.ID]
+	imageJobWatchers.Unlock()
+	if !ok {
+		imageJobWebhooks.Inc(provider, "unwatched")
+		return job, nil
+	}
+	select {
+	case completions <- job:
+	default:
+		// A duplicate callback, the first one already woke the wait up
+	}
+	imageJobWebhooks.Inc(provider, "delivered")
+	return job, nil
+}
+
+// sendImageRequest sends a JSON request to the image backend and decodes a
+// 2xx response into out.
+func sendImageRequest(ctx context.Context, method string, url string, headers map[string]string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshaling data: %v", err)
+		}
+		reqBody = bytes.NewReader(jsonData)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := imageGeneratorHTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
+
+// midjourneyImageGenerator queues prompts as items of the images collection
+// of the imagine Directus instance, which runs them through Midjourney. For
+// webhooks, a Directus flow posts the updated item to the callback URL.
+type midjourneyImageGenerator struct {
+	config ImageGeneratorConfig
+}
+
+type midjourneyImage struct {
+	ID           string   `json:"id"`
+	Status       string   `json:"status"`
+	URL          string   `json:"url"`
+	UpscaledURLs []string `json:"upscaled_urls"`
+	Error        string   `json:"error"`
+}
+
+func (i midjourneyImage) job() *ImageJob {
+	job := &ImageJob{
+		ID:            i.ID,
+		Status:        ImageJobPending,
+		URL:           i.URL,
+		CandidateURLs: i.UpscaledURLs,
+		Error:         i.Error,
+	}
+	switch i.Status {
+	case "completed":
+		job.Status = ImageJobCompleted
+	case "failed":
+		job.Status = ImageJobFailed
+	}
+	return job
+}
+
+func (g *midjourneyImageGenerator) Name() string { return ImageProviderMidjourney }
+
+func (g *midjourneyImageGenerator) headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + g.config.APIKey}
+}
+
+func (g *midjourneyImageGenerator) Submit(ctx context.Context, prompt string) (*ImageJob, error) {
+	var resp struct {
+		Data midjourneyImage `json:"data"`
+	}
+	body := map[string]interface{}{"prompt": prompt}
+	if err := sendImageRequest(ctx, "POST", g.config.BaseURL+"/items/images/", g.headers(), body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data.job(), nil
+}
+
+func (g *midjourneyImageGenerator) Status(ctx context.Context, jobID string) (*ImageJob, error) {
+	var resp struct {
+		Data midjourneyImage `json:"data"`
+	}
+	if err := sendImageRequest(ctx, "GET", g.config.BaseURL+"/items/images/"+url.PathEscape(jobID), g.headers(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data.job(), nil
+}
+
+// ParseWebhook takes the item as the Directus API returns it, wrapped in
+// data or not.
+func (g *midjourneyImageGenerator) ParseWebhook(body []byte) (*ImageJob, error) {
+	var wrapped struct {
+		Data *midjourneyImage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, fmt.Errorf("error decoding webhook: %w", err)
+	}
+	image := wrapped.Data
+	if image == nil {
+		image = &midjourneyImage{}
+		if err := json.Unmarshal(body, image); err != nil {
+			return nil, fmt.Errorf("error decoding webhook: %w", err)
+		}
+	}
+	if image.ID == "" {
+		return nil, fmt.Errorf("webhook has no image id")
+	}
+	return image.job(), nil
+}
+
+// replicateImageGenerator runs predictions of a Replicate model version,
+// SDXL by default. Its outputs are all candidates.
+type replicateImageGenerator struct {
+	config ImageGeneratorConfig
+}
+
+type replicatePrediction struct {
+	ID     string      `json:"id"`
+	Status string      `json:"status"`
+	Output interface{} `json:"output"`
+	Error  interface{} `json:"error"`
+}
+
+func (p replicatePrediction) job() *ImageJob {
+	job := &ImageJob{ID: p.ID, Status: ImageJobPending}
+	switch p.Status {
+	case "succeeded":
+		job.Status = ImageJobCompleted
+	case "failed", "canceled":
+		job.Status = ImageJobFailed
+		job.Error = p.Status
+		if p.Error != nil {
+			job.Error = fmt.Sprint(p.Error)
+		}
+	}
+	// Models return either a list of files or a single one
+	switch output := p.Output.(type) {
+	case string:
+		job.CandidateURLs = []string{output}
+	case []interface{}:
+		for _, item := range output {
+			if imageURL, ok := item.(string); ok {
+				job.CandidateURLs = append(job.CandidateURLs, imageURL)
+			}
+		}
+	}
+	return job
+}
+
+func (g *replicateImageGenerator) Name() string { return ImageProviderReplicate }
+
+func (g *replicateImageGenerator) headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + g.config.APIKey}
+}
+
+func (g *replicateImageGenerator) Submit(ctx context.Context, prompt string) (*ImageJob, error) {
+	body := map[string]interface{}{
+		"version": g.config.Model,
+		"input": map[string]interface{}{
+			"prompt":      prompt,
+			"num_outputs": g.config.Candidates,
+		},
+	}
+	if callback := g.config.callbackURL(); callback != "" {
+		body["webhook"] = callback
+		body["webhook_events_filter"] = []string{"completed"}
+	}
+	var prediction replicatePrediction
+	if err := sendImageRequest(ctx, "POST", g.config.BaseURL+"/predictions", g.headers(), body, &prediction); err != nil {
+		return nil, err
+	}
+	return prediction.job(), nil
+}
+
+func (g *replicateImageGenerator) Status(ctx context.Context, jobID string) (*ImageJob, error) {
+	var prediction replicatePrediction
+	if err := sendImageRequest(ctx, "GET", g.config.BaseURL+"/predictions/"+url.PathEscape(jobID), g.headers(), nil, &prediction); err != nil {
+		return nil, err
+	}
+	return prediction.job(), nil
+}
+
+// ParseWebhook takes the prediction Replicate posts when it finishes.
+func (g *replicateImageGenerator) ParseWebhook(body []byte) (*ImageJob, error) {
+	var prediction replicatePrediction
+	if err := json.Unmarshal(body, &prediction); err != nil {
+		return nil, fmt.Errorf("error decoding webhook: %w", err)
+	}
+	if prediction.ID == "" {
+		return nil, fmt.Errorf("webhook has no prediction id")
+	}
+	return prediction.job(), nil
+}
+
+// openAIImageGenerator asks DALL·E for one image per candidate, DALL·E 3
+// makes a single one per request. The API answers with the images, so jobs
+// are finished when Submit returns. Its URLs expire after an hour.
+type openAIImageGenerator struct {
+	config ImageGeneratorConfig
+}
+
+func (g *openAIImageGenerator) Name() string { return ImageProviderOpenAI }
+
+func (g *openAIImageGenerator) Submit(ctx context.Context, prompt string) (*ImageJob, error) {
+	job := &ImageJob{ID: uuid.New().String(), Status: ImageJobCompleted}
+	headers := map[string]string{"Authorization": "Bearer " + g.config.APIKey}
+	body := map[string]interface{}{
+		"model":  g.config.Model,
+		"prompt": prompt,
+		"n":      1,
+		"size":   "1024x1024",
+	}
+	for len(job.CandidateURLs) < g.config.Candidates {
+		var resp struct {
+			Data []struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		}
+		if err := sendImageRequest(ctx, "POST", g.config.BaseURL+"/images/generations", headers, body, &resp); err != nil {
+			if len(job.CandidateURLs) > 0 {
+				log.Printf("⚠️ DALL·E stopped after %d of %d candidates: %v", len(job.CandidateURLs), g.config.Candidates, err)
+				break
+			}
+			return nil, err
+		}
+		if len(resp.Data) == 0 || resp.Data[0].URL == "" {
+			return nil, fmt.Errorf("no image in the response")
+		}
+		job.CandidateURLs = append(job.CandidateURLs, resp.Data[0].URL)
+	}
+	job.URL = job.CandidateURLs[0]
+	return job, nil
+}
+
+func (g *openAIImageGenerator) Status(ctx context.Context, jobID string) (*ImageJob, error) {
+	return nil, fmt.Errorf("openai image jobs are finished when submitted, %s is unknown", jobID)
+}
+
+func (g *openAIImageGenerator) ParseWebhook(body []byte) (*ImageJob, error) {
+	return nil, fmt.Errorf("%w: openai sends no image webhooks", ErrImageWebhookRejected)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMidjourneyGeneratorAgainstFake(t *testing.T) {
+	midjourney := newFakeMidjourney(t)
+	previous := imageGeneratorHTTP
+	imageGeneratorHTTP = newTestResilientClient(UpstreamImageGenerator, 3)
+	t.Cleanup(func() { imageGeneratorHTTP = previous })
+
+	generator := NewImageGenerator(ImageGeneratorConfig{Provider: ImageProviderMidjourney, BaseURL: midjourney.URL, APIKey: "test-image-key"})
+	ctx := context.Background()
+
+	// The imagine POST may have started a job before failing, it isn't retried
+	midjourney.fail(1)
+	if _, err := generator.Submit(ctx, "a blue creature by a window"); err == nil {
+		t.Fatal("Submit succeeded against a failing backend")
+	}
+	if got := midjourney.count("POST", "/items/images/"); got != 1 {
+		t.Errorf("imagine POSTs = %d, want 1", got)
+	}
+
+	job, err := generator.Submit(ctx, "a blue creature by a window")
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if job.ID != "job-1" || job.Status != ImageJobPending {
+		t.Fatalf("submitted job = %+v, want job-1 pending", job)
+	}
+
+	// Status checks are retried
+	midjourney.fail(1)
+
+	job, err = generator.Status(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if job.Status != ImageJobCompleted || len(job.CandidateURLs) != 2 {
+		t.Fatalf("job = %+v, want completed with two candidates", job)
+	}
+
+	midjourney.failJobs = true
+	job, err = generator.Status(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if job.Status != ImageJobFailed || !strings.Contains(job.Error, "banned prompt") {
+		t.Errorf("job = %+v, want failed with the backend's error", job)
+	}
+}
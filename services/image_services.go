@@ -20,6 +20,9 @@ type ImageService struct {
 }
 
 func NewImageService() (*ImageService, error) {
+	if err := CheckCapability(CapabilityCloudinary); err != nil {
+		return nil, err
+	}
 	cld, err := cloudinary.NewFromURL(os.Getenv("CLOUDINARY_URL"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Cloudinary: %v", err)
@@ -33,7 +36,7 @@ func (s *ImageService) UploadImage(c *gin.Context) {
 	file, header, err := c.Request.FormFile("image")
 	if err != nil {
 		log.Printf("Error getting file: %v", err)
-		fmt.Println("Error getting file:", err)
+		log.Println("Error getting file:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
@@ -49,14 +52,14 @@ func (s *ImageService) UploadImage(c *gin.Context) {
 	resp, err := s.Cld.Upload.Upload(s.Ctx, file, uploadParams)
 	if err != nil {
 		log.Printf("Error uploading file: %v", err)
-		fmt.Println("Error uploading file:", err)
+		log.Println("Error uploading file:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image"})
 		return
 	}
 
-	fmt.Println("Image uploaded successfully")
-	fmt.Println("Public ID:", resp.PublicID)
-	fmt.Println("URL:", resp.SecureURL)
+	log.Println("Image uploaded successfully")
+	log.Println("Public ID:", resp.PublicID)
+	log.Println("URL:", resp.SecureURL)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Image uploaded successfully",
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/jackc/pgx/v4"
+)
+
+// Cloudinary transformation of each export size. Full keeps the original
+// dimensions and only lets Cloudinary pick the format and quality.
+var imageVariantTransformations = map[string]string{
+	types.ImageSizeThumbnail: "c_fill,g_auto,w_256,h_256/q_auto",
+	types.ImageSizeCard:      "c_limit,w_800,h_800/q_auto",
+	types.ImageSizeFull:      "q_auto",
+}
+
+// Version segment of a Cloudinary delivery URL, e.g. v1712345678
+var cloudinaryVersionSegment = regexp.MustCompile(`^v\d+$`)
+
+var ErrImageNotOnCloudinary = errors.New("image is not hosted on Cloudinary")
+
+type ImageVariantService struct {
+	store *storage.PostgresStore
+}
+
+func NewImageVariantService(store *storage.PostgresStore) *ImageVariantService {
+	return &ImageVariantService{store: store}
+}
+
+// GetAnkyImageVariant returns the Anky's image in the given size. The first
+// request for a size has Cloudinary derive it and stores its signed URL, later
+// ones are served from the database.
+func (s *ImageVariantService) GetAnkyImageVariant(ctx context.Context, anky *types.Anky, size string) (*types.AnkyImageVariant, error) {
+	transformation, ok := imageVariantTransformations[size]
+	if !ok {
+		return nil, fmt.Errorf("unknown image size: %s", size)
+	}
+
+	cached, err := s.store.GetAnkyImageVariant(ctx, anky.ID, size)
+	if err == nil {
+		return cached, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	publicID, err := cloudinaryPublicID(anky.ImageURL)
+	if err != nil {
+		return nil, err
+	}
+	imageService, err := NewImageService()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🖼️ Generating %s image of anky %s", size, anky.ID)
+	result, err := imageService.Cld.Upload.Explicit(ctx, uploader.ExplicitParams{
+		PublicID: publicID,
+		Type:     api.Upload,
+		Eager:    transformation,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error generating %s image: %w", size, err)
+	}
+	if result.Error.Message != "" {
+		return nil, fmt.Errorf("error generating %s image: %s", size, result.Error.Message)
+	}
+
+	asset, err := imageService.Cld.Image(publicID)
+	if err != nil {
+		return nil, err
+	}
+	asset.Transformation = transformation
+	asset.Config.URL.SignURL = true
+	signedURL, err := asset.String()
+	if err != nil {
+		return nil, fmt.Errorf("error signing %s image URL: %w", size, err)
+	}
+
+	variant := &types.AnkyImageVariant{
+		AnkyID:    anky.ID,
+		Size:      size,
+		URL:       signedURL,
+		CreatedAt: s.store.Clock().Now().UTC(),
+	}
+	if len(result.Eager) > 0 {
+		variant.Width = result.Eager[0].Width
+		variant.Height = result.Eager[0].Height
+	}
+	if err := s.store.SaveAnkyImageVariant(ctx, variant); err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+// cloudinaryPublicID extracts the public ID from a Cloudinary delivery URL
+// like https://res.cloudinary.com/<cloud>/image/upload/v1712345678/<public id>.png
+func cloudinaryPublicID(imageURL string) (string, error) {
+	parsed, err := url.Parse(imageURL)
+	if err != nil || !strings.HasSuffix(parsed.Host, "cloudinary.com") {
+		return "", ErrImageNotOnCloudinary
+	}
+	_, rest, found := strings.Cut(parsed.Path, "/upload/")
+	if !found {
+		return "", ErrImageNotOnCloudinary
+	}
+
+	// Anything before the version is a transformation or signature
+	segments := strings.Split(rest, "/")
+	for i, segment := range segments {
+		if cloudinaryVersionSegment.MatchString(segment) {
+			segments = segments[i+1:]
+			break
+		}
+	}
+	publicID := strings.Join(segments, "/")
+	publicID = strings.TrimSuffix(publicID, path.Ext(publicID))
+	if publicID == "" {
+		return "", ErrImageNotOnCloudinary
+	}
+	return publicID, nil
+}
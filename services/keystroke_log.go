@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+)
+
+// ApplyKeystrokeLog verifies the session with its keystroke log: the text
+// becomes the one the keystrokes typed, the session lasts no longer than they
+// say, and it gets their focus, paste and cadence metrics. The log must be of
+// the same session and user.
+func ApplyKeystrokeLog(session *types.WritingSession, keystrokeLog string) error {
+	parsed, err := utils.ParseWritingSession(keystrokeLog)
+	if err != nil {
+		return fmt.Errorf("invalid keystroke log: %w", err)
+	}
+	if parsed.SessionID != session.ID.String() {
+		return fmt.Errorf("the keystroke log is of session %s, not %s", parsed.SessionID, session.ID)
+	}
+	if parsed.UserID != session.UserID.String() {
+		return fmt.Errorf("the keystroke log is of another user")
+	}
+
+	session.Writing = parsed.RawContent
+	session.WordsWritten = len(strings.Fields(parsed.RawContent))
+	if session.TimeSpent == nil || parsed.TimeSpent < *session.TimeSpent {
+		timeSpent := parsed.TimeSpent
+		session.TimeSpent = &timeSpent
+	}
+
+	focus, paste, cheat := parsed.Focus, parsed.Paste, parsed.Cheat
+	session.FocusScore = &focus.Score
+	session.FocusMetrics = &focus
+	session.PasteFlagged = paste.Flagged
+	session.PasteMetrics = &paste
+	session.Suspect = cheat.Suspect
+	session.SuspectScore = &cheat.Score
+	session.CheatMetrics = &cheat
+	return nil
+}
+
+// EarnsNewen reports whether the session may be rewarded: verified, long
+// enough for an Anky and typed by hand.
+func EarnsNewen(session *types.WritingSession) bool {
+	return session.Verified() && session.IsAnky && !session.PasteFlagged && !session.Suspect
+}
+
+// RecordKeystrokeMetrics stores the metrics ApplyKeystrokeLog put on the
+// session, which must already be stored. Failing to store them is only
+// logged; the Anky pipeline checks the keystrokes again.
+func RecordKeystrokeMetrics(ctx context.Context, store *storage.PostgresStore, session *types.WritingSession) {
+	if !session.Verified() {
+		return
+	}
+	if session.FocusMetrics != nil {
+		if err := store.UpdateWritingSessionFocus(ctx, session.ID, session.FocusMetrics); err != nil {
+			log.Printf("⚠️ Could not store focus score for session %s: %v", session.ID, err)
+		}
+	}
+	if session.PasteMetrics != nil {
+		if err := store.UpdateWritingSessionPaste(ctx, session.ID, session.PasteMetrics); err != nil {
+			log.Printf("⚠️ Could not store paste metrics for session %s: %v", session.ID, err)
+		}
+	}
+	if err := store.UpdateWritingSessionSuspect(ctx, session.ID, session.CheatMetrics); err != nil {
+		log.Printf("⚠️ Could not store cheat metrics for session %s: %v", session.ID, err)
+	}
+	if session.Suspect {
+		log.Printf("🕵️ Session %s is suspect, scored %d: %s", session.ID, session.CheatMetrics.Score, strings.Join(session.CheatMetrics.Reasons, ", "))
+	}
+}
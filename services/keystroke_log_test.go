@@ -0,0 +1,85 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// ankyLengthWriting takes typedSession longer than an Anky to type.
+var ankyLengthWriting = strings.Repeat("i sat with the quiet of the morning and let the words come without asking where they were going. ", 27)
+
+func TestApplyKeystrokeLogVerifiesTheSession(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+	claimed := 3600
+	session := &types.WritingSession{ID: sessionID, UserID: userID, Writing: "whatever the client says", TimeSpent: &claimed}
+
+	if err := ApplyKeystrokeLog(session, typedSession(userID, sessionID, pipelineTestWriting)); err != nil {
+		t.Fatalf("ApplyKeystrokeLog: %v", err)
+	}
+	if !session.Verified() {
+		t.Fatal("session isn't verified after applying its keystroke log")
+	}
+	if session.Writing != pipelineTestWriting {
+		t.Errorf("writing = %q, want the text the keystrokes typed", session.Writing)
+	}
+	if *session.TimeSpent >= claimed || *session.TimeSpent == 0 {
+		t.Errorf("time spent = %d, want the keystrokes' duration instead of the claimed %d", *session.TimeSpent, claimed)
+	}
+	if session.Suspect || session.PasteFlagged {
+		t.Errorf("typed session flagged: suspect %v, paste %v", session.Suspect, session.PasteFlagged)
+	}
+	session.SetAnkyStatus()
+	if session.IsAnky || EarnsNewen(session) {
+		t.Error("a session of a minute became an Anky")
+	}
+}
+
+func TestApplyKeystrokeLogRejectsAnotherSessionsLog(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+	session := &types.WritingSession{ID: sessionID, UserID: userID}
+
+	if err := ApplyKeystrokeLog(session, typedSession(userID, uuid.New(), pipelineTestWriting)); err == nil {
+		t.Error("applied the keystroke log of another session")
+	}
+	if err := ApplyKeystrokeLog(session, typedSession(uuid.New(), sessionID, pipelineTestWriting)); err == nil {
+		t.Error("applied the keystroke log of another user")
+	}
+	if session.Verified() {
+		t.Error("a rejected keystroke log verified the session")
+	}
+}
+
+func TestUnverifiedSessionsNeverBecomeAnkys(t *testing.T) {
+	timeSpent := 600
+	session := &types.WritingSession{ID: uuid.New(), UserID: uuid.New(), TimeSpent: &timeSpent}
+	session.SetAnkyStatus()
+	if session.IsAnky || EarnsNewen(session) {
+		t.Error("a session without keystrokes became an Anky")
+	}
+
+	userID, sessionID := uuid.New(), uuid.New()
+	session = &types.WritingSession{ID: sessionID, UserID: userID, TimeSpent: &timeSpent}
+	if err := ApplyKeystrokeLog(session, typedSession(userID, sessionID, ankyLengthWriting)); err != nil {
+		t.Fatalf("ApplyKeystrokeLog: %v", err)
+	}
+	session.SetAnkyStatus()
+	if !session.IsAnky || !EarnsNewen(session) {
+		t.Errorf("verified session of %d seconds isn't a rewarded Anky", *session.TimeSpent)
+	}
+}
+
+func TestFIDScoringIgnoresUnverifiedSessions(t *testing.T) {
+	timeSpent := 600
+	cheat := types.CheatMetrics{}
+	sessions := []*types.WritingSession{
+		{ID: uuid.New(), IsAnky: true, TimeSpent: &timeSpent},
+		{ID: uuid.New(), IsAnky: true, TimeSpent: &timeSpent, CheatMetrics: &cheat},
+	}
+	verified := verifiedSessions(sessions)
+	if len(verified) != 1 || verified[0] != sessions[1] {
+		t.Fatalf("verified sessions = %v, want only the one with cheat metrics", verified)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Features that can be switched off while their upstream misbehaves
+const (
+	FeatureCasting         = "casting"
+	FeatureImageGeneration = "image_generation"
+	FeatureFIDRegistration = "fid_registration"
+)
+
+var featureNames = map[string]string{
+	FeatureCasting:         "casting to Farcaster",
+	FeatureImageGeneration: "image generation",
+	FeatureFIDRegistration: "FID registration",
+}
+
+var ErrFeatureDisabled = errors.New("feature temporarily disabled")
+
+// featureResumed hears of the features switched back on, see
+// ParkedAnkyService.
+var featureResumed = make(chan string, len(featureNames))
+
+// FeatureDisabledError is returned by the stages of a switched off feature.
+type FeatureDisabledError struct {
+	Feature string
+}
+
+func (e *FeatureDisabledError) Error() string {
+	return fmt.Sprintf("%s is temporarily disabled, please try again later", featureNames[e.Feature])
+}
+
+func (e *FeatureDisabledError) Is(target error) bool {
+	return target == ErrFeatureDisabled
+}
+
+// KILL_SWITCHES lists the features that start switched off, comma separated,
+// e.g. "casting,image_generation". Admins can flip them at runtime, which
+// lasts until the next restart.
+var killSwitches = struct {
+	once     sync.Once
+	mu       sync.RWMutex
+	disabled map[string]bool
+}{}
+
+func loadKillSwitches() {
+	killSwitches.once.Do(func() {
+		killSwitches.disabled = make(map[string]bool)
+		for _, feature := range strings.Split(os.Getenv("KILL_SWITCHES"), ",") {
+			feature = strings.TrimSpace(feature)
+			if feature == "" {
+				continue
+			}
+			if _, ok := featureNames[feature]; !ok {
+				log.Printf("⚠️ Ignoring unknown feature %q in KILL_SWITCHES", feature)
+				continue
+			}
+			killSwitches.disabled[feature] = true
+			log.Printf("🛑 %s is disabled", featureNames[feature])
+		}
+	})
+}
+
+// CheckFeature returns a *FeatureDisabledError when the feature is switched off.
+func CheckFeature(feature string) error {
+	loadKillSwitches()
+	killSwitches.mu.RLock()
+	defer killSwitches.mu.RUnlock()
+
+	if killSwitches.disabled[feature] {
+		return &FeatureDisabledError{Feature: feature}
+	}
+	return nil
+}
+
+// KillSwitches returns whether each feature is switched off.
+func KillSwitches() map[string]bool {
+	loadKillSwitches()
+	killSwitches.mu.RLock()
+	defer killSwitches.mu.RUnlock()
+
+	switches := make(map[string]bool, len(featureNames))
+	for feature := range featureNames {
+		switches[feature] = killSwitches.disabled[feature]
+	}
+	return switches
+}
+
+// SetKillSwitches switches the given features off (true) or back on (false).
+// Unknown features are rejected before anything changes.
+func SetKillSwitches(switches map[string]bool) error {
+	var unknown []string
+	for feature := range switches {
+		if _, ok := featureNames[feature]; !ok {
+			unknown = append(unknown, feature)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown features: %s", strings.Join(unknown, ", "))
+	}
+
+	loadKillSwitches()
+	killSwitches.mu.Lock()
+	defer killSwitches.mu.Unlock()
+	for feature, disabled := range switches {
+		if killSwitches.disabled[feature] != disabled {
+			log.Printf("🛑 %s disabled: %t", featureNames[feature], disabled)
+			if !disabled {
+				select {
+				case featureResumed <- feature:
+				default:
+					// The feature is already waiting to be resumed
+				}
+			}
+		}
+		killSwitches.disabled[feature] = disabled
+	}
+	return nil
+}
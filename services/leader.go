@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+)
+
+const (
+	// How often an instance that isn't running a job tries to take it over
+	leaderRetryInterval = time.Minute
+	// How often the running instance checks it still holds the job's lock
+	leaderCheckInterval = 30 * time.Second
+)
+
+// RunAsLeader runs job on one instance at a time when several replicas share
+// the database. The instance holding the job's advisory lock runs it; the
+// others wait and take over when that instance goes away. job must block
+// until its context is done, like the Start...Job functions. A job that
+// returns on its own is not started again.
+func RunAsLeader(ctx context.Context, store *storage.PostgresStore, name string, job func(ctx context.Context)) {
+	for {
+		lock, err := store.TryAdvisoryLock(ctx, "job:"+name)
+		if err != nil {
+			log.Printf("⚠️ Could not try to lead job %s: %v", name, err)
+		}
+		if lock != nil {
+			log.Printf("👑 Running job %s on this instance", name)
+			finished := leadJob(ctx, lock, job)
+			lock.Release()
+			if finished {
+				return
+			}
+			log.Printf("⚠️ Lost the lock of job %s, stopped it", name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaderRetryInterval):
+		}
+	}
+}
+
+// leadJob runs job until it returns or the lock is lost. It reports whether
+// the job is done for good, as opposed to stopped because of the lock.
+func leadJob(ctx context.Context, lock *storage.AdvisoryLock, job func(ctx context.Context)) bool {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		job(jobCtx)
+	}()
+
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return true
+		case <-ticker.C:
+			checkCtx, cancelCheck := context.WithTimeout(ctx, 5*time.Second)
+			err := lock.Check(checkCtx)
+			cancelCheck()
+			if err != nil && ctx.Err() == nil {
+				cancel()
+				<-done
+				return false
+			}
+		}
+	}
+}
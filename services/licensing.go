@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// ErrLicenseNarrowing is returned when a relicense would take rights back from
+// people who already rely on a Creative Commons grant.
+var ErrLicenseNarrowing = errors.New("creative commons licenses can't be revoked, an anky can only move to a more permissive license")
+
+// RelicenseAnky switches a published Anky to a new license on behalf of its
+// owner and records the change in the audit trail. It returns nil when the
+// Anky already has that license.
+func (s *AnkyService) RelicenseAnky(ctx context.Context, anky *types.Anky, userID uuid.UUID, license string) (*types.AnkyLicenseChange, error) {
+	if !types.IsValidLicense(license) {
+		return nil, fmt.Errorf("invalid license %q", license)
+	}
+	if anky.License == license {
+		return nil, nil
+	}
+	if !types.CanRelicense(anky.License, license) {
+		return nil, ErrLicenseNarrowing
+	}
+
+	change := &types.AnkyLicenseChange{
+		AnkyID:     anky.ID,
+		UserID:     userID,
+		NewLicense: license,
+	}
+	if err := s.store.UpdateAnkyLicense(ctx, change); err != nil {
+		return nil, err
+	}
+	anky.License = license
+	s.recordAnkyStatusEvent(ctx, anky.ID, "license_changed", fmt.Sprintf("%s -> %s", change.PreviousLicense, change.NewLicense))
+
+	// The data URI fallback is the only metadata this server builds, so it is
+	// the only copy that needs the new license
+	if anky.StorageDegraded && anky.MetadataURI != "" {
+		metadataURI, err := degradedMetadataURI(anky.TokenName, anky.Ticker, anky.AnkyReflection, anky.ImageURL, anky.License)
+		if err != nil {
+			log.Printf("⚠️ Could not rebuild metadata of anky %s with its new license: %v", anky.ID, err)
+			return change, nil
+		}
+		anky.MetadataURI = metadataURI
+		anky.LastUpdatedAt = s.store.Clock().Now().UTC()
+		if err := s.store.UpdateAnky(ctx, anky); err != nil {
+			log.Printf("⚠️ Could not store rebuilt metadata of anky %s: %v", anky.ID, err)
+		}
+	}
+	return change, nil
+}
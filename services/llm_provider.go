@@ -0,0 +1,400 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// LLM backends LLM_PROVIDER can select
+const (
+	LLMProviderOllama    = "ollama"
+	LLMProviderOpenAI    = "openai"
+	LLMProviderAnthropic = "anthropic"
+)
+
+// LLMProvider sends a chat to one LLM backend and returns the whole reply.
+type LLMProvider interface {
+	Name() string
+	// Model used when a request doesn't name one
+	DefaultModel() string
+	Complete(ctx context.Context, req LLMCompletionRequest) (*LLMCompletion, error)
+}
+
+type LLMCompletionRequest struct {
+	// Empty uses the provider's configured model
+	Model    string
+	Messages []types.Message
+	// Asks the backend to answer with a JSON object
+	JSON bool
+}
+
+type LLMCompletion struct {
+	Content string
+	Model   string
+	Usage   LLMTokenUsage
+}
+
+type LLMTokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// LLMConfig is read from the environment:
+//
+//	LLM_PROVIDER         ollama (default), openai or anthropic
+//	LLM_MODEL            model used when a request doesn't name one
+//	LLM_BASE_URL         API root, e.g. an OpenAI compatible server
+//	LLM_API_KEY          falls back to OPENAI_API_KEY or ANTHROPIC_API_KEY
+//	LLM_TIMEOUT_SECONDS  per request, 120 by default
+//	LLM_MAX_TOKENS       reply limit, required by Anthropic, 2048 by default
+type LLMConfig struct {
+	Provider  string
+	Model     string
+	BaseURL   string
+	APIKey    string
+	Timeout   time.Duration
+	MaxTokens int
+}
+
+func LLMConfigFromEnv() LLMConfig {
+	config := LLMConfig{
+		Provider:  strings.ToLower(os.Getenv("LLM_PROVIDER")),
+		Model:     os.Getenv("LLM_MODEL"),
+		BaseURL:   strings.TrimSuffix(os.Getenv("LLM_BASE_URL"), "/"),
+		APIKey:    os.Getenv("LLM_API_KEY"),
+		Timeout:   120 * time.Second,
+		MaxTokens: 2048,
+	}
+	if value := os.Getenv("LLM_TIMEOUT_SECONDS"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.Timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if value := os.Getenv("LLM_MAX_TOKENS"); value != "" {
+		if maxTokens, err := strconv.Atoi(value); err == nil && maxTokens > 0 {
+			config.MaxTokens = maxTokens
+		}
+	}
+
+	switch config.Provider {
+	case LLMProviderOpenAI:
+		config.withDefaults("gpt-4o-mini", "https://api.openai.com/v1", os.Getenv("OPENAI_API_KEY"))
+	case LLMProviderAnthropic:
+		config.withDefaults("claude-3-5-haiku-latest", "https://api.anthropic.com/v1", os.Getenv("ANTHROPIC_API_KEY"))
+	default:
+		if config.Provider != "" && config.Provider != LLMProviderOllama {
+			log.Printf("⚠️ Unknown LLM_PROVIDER %q, using ollama", config.Provider)
+		}
+		config.Provider = LLMProviderOllama
+		config.withDefaults("llama3.2", "http://localhost:11434", "")
+	}
+	return config
+}
+
+func (c *LLMConfig) withDefaults(model string, baseURL string, apiKey string) {
+	if c.Model == "" {
+		c.Model = model
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = baseURL
+	}
+	if c.APIKey == "" {
+		c.APIKey = apiKey
+	}
+}
+
+// NewLLMProvider builds the provider the config selects.
+func NewLLMProvider(config LLMConfig) LLMProvider {
+	client := &http.Client{Timeout: config.Timeout}
+	switch config.Provider {
+	case LLMProviderOpenAI:
+		return &openAIProvider{config: config, client: client}
+	case LLMProviderAnthropic:
+		return &anthropicProvider{config: config, client: client}
+	default:
+		return &ollamaProvider{config: config, client: client}
+	}
+}
+
+var (
+	defaultProviderOnce sync.Once
+	defaultProvider     LLMProvider
+)
+
+// defaultLLMProvider is built on first use, after main has loaded .env.
+func defaultLLMProvider() LLMProvider {
+	defaultProviderOnce.Do(func() {
+		config := LLMConfigFromEnv()
+		log.Printf("🧠 Using %s LLM provider with model %s at %s", config.Provider, config.Model, config.BaseURL)
+		defaultProvider = NewLLMProvider(config)
+	})
+	return defaultProvider
+}
+
+// postLLMJSON posts body to url and decodes a 200 response into out.
+func postLLMJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}, out interface{}) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return nil
+}
+
+func modelOrDefault(requested string, config LLMConfig) string {
+	if requested != "" {
+		return requested
+	}
+	return config.Model
+}
+
+type ollamaProvider struct {
+	config LLMConfig
+	client *http.Client
+}
+
+func (p *ollamaProvider) Name() string { return LLMProviderOllama }
+
+func (p *ollamaProvider) DefaultModel() string { return p.config.Model }
+
+func (p *ollamaProvider) Complete(ctx context.Context, req LLMCompletionRequest) (*LLMCompletion, error) {
+	llmRequest := types.LLMRequest{
+		Model:    modelOrDefault(req.Model, p.config),
+		Messages: req.Messages,
+		Stream:   false,
+	}
+	if req.JSON {
+		llmRequest.Format = "json"
+	}
+
+	var resp struct {
+		Model           string        `json:"model"`
+		Message         types.Message `json:"message"`
+		PromptEvalCount int           `json:"prompt_eval_count"`
+		EvalCount       int           `json:"eval_count"`
+	}
+	if err := postLLMJSON(ctx, p.client, p.config.BaseURL+"/api/chat", nil, llmRequest, &resp); err != nil {
+		return nil, err
+	}
+	return &LLMCompletion{
+		Content: resp.Message.Content,
+		Model:   resp.Model,
+		Usage:   LLMTokenUsage{PromptTokens: resp.PromptEvalCount, CompletionTokens: resp.EvalCount},
+	}, nil
+}
+
+// openAIProvider talks to the OpenAI chat completions API or any server
+// compatible with it, like vLLM or LM Studio.
+type openAIProvider struct {
+	config LLMConfig
+	client *http.Client
+}
+
+func (p *openAIProvider) Name() string { return LLMProviderOpenAI }
+
+func (p *openAIProvider) DefaultModel() string { return p.config.Model }
+
+func (p *openAIProvider) Complete(ctx context.Context, req LLMCompletionRequest) (*LLMCompletion, error) {
+	body := map[string]interface{}{
+		"model":      modelOrDefault(req.Model, p.config),
+		"messages":   req.Messages,
+		"max_tokens": p.config.MaxTokens,
+	}
+	if req.JSON {
+		body["response_format"] = map[string]string{"type": "json_object"}
+	}
+	headers := map[string]string{}
+	if p.config.APIKey != "" {
+		headers["Authorization"] = "Bearer " + p.config.APIKey
+	}
+
+	var resp struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message types.Message `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := postLLMJSON(ctx, p.client, p.config.BaseURL+"/chat/completions", headers, body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in response")
+	}
+	return &LLMCompletion{
+		Content: resp.Choices[0].Message.Content,
+		Model:   resp.Model,
+		Usage:   LLMTokenUsage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens},
+	}, nil
+}
+
+type anthropicProvider struct {
+	config LLMConfig
+	client *http.Client
+}
+
+func (p *anthropicProvider) Name() string { return LLMProviderAnthropic }
+
+func (p *anthropicProvider) DefaultModel() string { return p.config.Model }
+
+func (p *anthropicProvider) Complete(ctx context.Context, req LLMCompletionRequest) (*LLMCompletion, error) {
+	// The messages API takes the system prompt apart from the conversation
+	var system []string
+	messages := make([]types.Message, 0, len(req.Messages))
+	for _, message := range req.Messages {
+		if message.Role == "system" {
+			system = append(system, message.Content)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	if req.JSON {
+		system = append(system, "Answer with a single JSON object and nothing else.")
+	}
+
+	body := map[string]interface{}{
+		"model":      modelOrDefault(req.Model, p.config),
+		"messages":   messages,
+		"max_tokens": p.config.MaxTokens,
+	}
+	if len(system) > 0 {
+		body["system"] = strings.Join(system, "\n\n")
+	}
+	headers := map[string]string{
+		"x-api-key":         p.config.APIKey,
+		"anthropic-version": "2023-06-01",
+	}
+
+	var resp struct {
+		Model   string `json:"model"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := postLLMJSON(ctx, p.client, p.config.BaseURL+"/messages", headers, body, &resp); err != nil {
+		return nil, err
+	}
+
+	var content strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	return &LLMCompletion{
+		Content: content.String(),
+		Model:   resp.Model,
+		Usage:   LLMTokenUsage{PromptTokens: resp.Usage.InputTokens, CompletionTokens: resp.Usage.OutputTokens},
+	}, nil
+}
+
+// LLMUsage is the token usage of one provider and model since the server started.
+type LLMUsage struct {
+	Provider         string `json:"provider"`
+	Model            string `json:"model"`
+	Requests         int    `json:"requests"`
+	Failures         int    `json:"failures"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+var llmUsage = struct {
+	mu     sync.Mutex
+	totals map[string]*LLMUsage
+	// Requests in a row that failed, across providers, and when the last did
+	consecutiveFailures int
+	lastFailureAt       time.Time
+}{totals: make(map[string]*LLMUsage)}
+
+func recordLLMUsage(provider string, model string, usage LLMTokenUsage, failed bool) {
+	llmUsage.mu.Lock()
+	defer llmUsage.mu.Unlock()
+
+	key := provider + "/" + model
+	total, ok := llmUsage.totals[key]
+	if !ok {
+		total = &LLMUsage{Provider: provider, Model: model}
+		llmUsage.totals[key] = total
+	}
+	total.Requests++
+	if failed {
+		total.Failures++
+		llmUsage.consecutiveFailures++
+		llmUsage.lastFailureAt = time.Now()
+	} else {
+		llmUsage.consecutiveFailures = 0
+	}
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+}
+
+// LLMUsageTotals returns the usage of every provider and model used so far.
+func LLMUsageTotals() []LLMUsage {
+	llmUsage.mu.Lock()
+	defer llmUsage.mu.Unlock()
+
+	totals := make([]LLMUsage, 0, len(llmUsage.totals))
+	for _, total := range llmUsage.totals {
+		totals = append(totals, *total)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Provider != totals[j].Provider {
+			return totals[i].Provider < totals[j].Provider
+		}
+		return totals[i].Model < totals[j].Model
+	})
+	return totals
+}
+
+// LLMConsecutiveFailures returns how many LLM requests in a row failed, and
+// when the last of them did.
+func LLMConsecutiveFailures() (int, time.Time) {
+	llmUsage.mu.Lock()
+	defer llmUsage.mu.Unlock()
+	return llmUsage.consecutiveFailures, llmUsage.lastFailureAt
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+func TestOpenAIProviderAgainstFake(t *testing.T) {
+	llm := newFakeLLM(t)
+	service := NewLLMServiceWithProvider(NewLLMProvider(LLMConfig{
+		Provider:  LLMProviderOpenAI,
+		Model:     "fake-model",
+		BaseURL:   llm.URL,
+		APIKey:    "test-llm-key",
+		MaxTokens: 100,
+	}))
+	request := types.ChatRequest{Messages: []types.Message{{Role: "user", Content: "write me a reflection"}}}
+
+	completion, err := service.Complete(context.Background(), request, false)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if completion.Content != fakeLLMReply {
+		t.Errorf("content = %q, want %q", completion.Content, fakeLLMReply)
+	}
+	if completion.Usage.PromptTokens != 10 || completion.Usage.CompletionTokens != 5 {
+		t.Errorf("usage = %+v, want 10 prompt and 5 completion tokens", completion.Usage)
+	}
+
+	llm.failAlways()
+	if _, err := service.Complete(context.Background(), request, false); err == nil {
+		t.Fatal("Complete succeeded while the provider answered 503")
+	}
+}
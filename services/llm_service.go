@@ -1,163 +1,77 @@
 package services
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
+	"context"
+	"log"
+	"time"
 
+	"github.com/ankylat/anky/server/logging"
 	"github.com/ankylat/anky/server/types"
 )
 
+// LLMService sends prompts to the LLM provider configured through the
+// environment, see LLMConfig.
 type LLMService struct {
-	client *http.Client
+	provider LLMProvider
 }
 
 func NewLLMService() *LLMService {
 	return &LLMService{
-		client: &http.Client{},
+		provider: defaultLLMProvider(),
 	}
 }
 
-func (s *LLMService) SendSimpleRequest(prompt string) (<-chan string, error) {
-	fmt.Println("=== SendSimpleRequest START ===")
-	fmt.Println("Input prompt:", prompt)
+// NewLLMServiceWithProvider uses the given provider instead of the configured one.
+func NewLLMServiceWithProvider(provider LLMProvider) *LLMService {
+	return &LLMService{provider: provider}
+}
 
-	llmRequest := types.LLMRequest{
-		Model:  "llama3.2",
-		Prompt: prompt,
-	}
-	fmt.Println("Created LLMRequest object:", llmRequest)
+// Complete sends the chat and returns the whole reply, counting its tokens
+// toward the provider's usage.
+func (s *LLMService) Complete(ctx context.Context, chatRequest types.ChatRequest, jsonFormatting bool) (*LLMCompletion, error) {
+	log.Printf("Sending %d messages to %s", len(chatRequest.Messages), s.provider.Name())
 
-	jsonData, err := json.Marshal(llmRequest)
-	if err != nil {
-		fmt.Println("ERROR: Failed to marshal LLMRequest:", err)
-		return nil, err
-	}
-	fmt.Println("Successfully marshaled request to JSON:", string(jsonData))
-
-	req, err := http.NewRequest("POST", "http://localhost:11434/api/generate", bytes.NewBuffer(jsonData))
+	started := time.Now()
+	completion, err := s.provider.Complete(ctx, LLMCompletionRequest{
+		Model:    chatRequest.Model,
+		Messages: chatRequest.Messages,
+		JSON:     jsonFormatting,
+	})
 	if err != nil {
-		fmt.Println("ERROR: Failed to create HTTP request:", err)
+		model := chatRequest.Model
+		if model == "" {
+			model = s.provider.DefaultModel()
+		}
+		llmRequestDuration.Observe(time.Since(started).Seconds(), s.provider.Name(), model, "error")
+		recordLLMUsage(s.provider.Name(), model, LLMTokenUsage{}, true)
+		log.Println("ERROR: LLM request failed:", err)
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	fmt.Println("Created HTTP request with headers:", req.Header)
-
-	fmt.Println("Sending HTTP request...")
-	resp, err := s.client.Do(req)
-	if err != nil {
-		fmt.Println("ERROR: Failed to send HTTP request:", err)
-		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
-	}
-	fmt.Println("Received response with status:", resp.Status)
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("ERROR: Unexpected status code:", resp.StatusCode)
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
 
-	fmt.Println("Creating response channel...")
-	responseChan := make(chan string)
-
-	go func() {
-		fmt.Println("Starting goroutine to process response...")
-		defer func() {
-			fmt.Println("Closing response body and channel...")
-			resp.Body.Close()
-			close(responseChan)
-		}()
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Println("ERROR: Failed to read response body:", err)
-			return
-		}
-		fmt.Println("Successfully read response body:", string(body))
-
-		// Parse the JSON response to get just the "response" field
-		var llmResponse struct {
-			Response string `json:"response"`
-		}
-		if err := json.Unmarshal(body, &llmResponse); err != nil {
-			fmt.Println("ERROR: Failed to unmarshal response:", err)
-			return
-		}
-
-		fmt.Println("Sending response through channel...")
-		responseChan <- llmResponse.Response
-		fmt.Println("Response sent through channel")
-	}()
+	llmRequestDuration.Observe(time.Since(started).Seconds(), s.provider.Name(), completion.Model, "ok")
+	recordLLMUsage(s.provider.Name(), completion.Model, completion.Usage, false)
+	log.Printf("🧠 %s %s used %d prompt and %d completion tokens", s.provider.Name(), completion.Model, completion.Usage.PromptTokens, completion.Usage.CompletionTokens)
+	log.Println("LLM response:", logging.Content(completion.Content))
+	return completion, nil
+}
 
-	fmt.Println("=== SendSimpleRequest END ===")
-	return responseChan, nil
+func (s *LLMService) SendSimpleRequest(prompt string) (<-chan string, error) {
+	log.Println("Input prompt:", logging.Content(prompt))
+	return s.SendChatRequest(types.ChatRequest{
+		Messages: []types.Message{{Role: "user", Content: prompt}},
+	}, false)
 }
 
+// SendChatRequest returns the reply through a channel, which is closed once
+// the whole reply was sent.
 func (s *LLMService) SendChatRequest(chatRequest types.ChatRequest, jsonFormatting bool) (<-chan string, error) {
-	fmt.Println("SendChatRequest called with:", chatRequest)
-
-	llmRequest := types.LLMRequest{
-		Model:    "llama3.2",
-		Messages: chatRequest.Messages,
-		Stream:   false,
-	}
-	if jsonFormatting {
-		llmRequest.Format = "json"
-	}
-	fmt.Printf("Created LLMRequest: %+v\n", llmRequest)
-
-	jsonData, err := json.Marshal(llmRequest)
-	if err != nil {
-		fmt.Println("Error marshaling LLMRequest:", err)
-		return nil, err
-	}
-	fmt.Println("Marshaled LLMRequest to JSON:", string(jsonData))
-
-	req, err := http.NewRequest("POST", "http://localhost:11434/api/chat", bytes.NewBuffer(jsonData))
+	completion, err := s.Complete(context.Background(), chatRequest, jsonFormatting)
 	if err != nil {
-		fmt.Println("Error creating HTTP request:", err)
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	fmt.Println("Created HTTP request:", req)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		fmt.Println("Error sending HTTP request:", err)
-		return nil, err
-	}
-	fmt.Println("Received HTTP response:", resp.Status)
-
-	responseChan := make(chan string)
-	fmt.Println("Created response channel")
-
-	go func() {
-		fmt.Println("Started goroutine to process response")
-		defer resp.Body.Close()
-		defer close(responseChan)
-		fmt.Println("Deferred response body close and channel close")
-
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			fmt.Println("Scanned new line from response body")
-			var streamResponse types.StreamResponse
-			if err := json.Unmarshal(scanner.Bytes(), &streamResponse); err != nil {
-				fmt.Println("Error unmarshaling stream response:", err)
-				continue
-			}
-			fmt.Printf("Unmarshaled stream response: %+v\n", streamResponse)
-			responseChan <- streamResponse.Message.Content
-			fmt.Println("Sent message content to response channel")
-		}
-
-		if err := scanner.Err(); err != nil {
-			fmt.Println("Error reading stream:", err)
-		}
-		fmt.Println("Finished processing response")
-	}()
 
-	fmt.Println("Returning response channel")
+	responseChan := make(chan string, 1)
+	responseChan <- completion.Content
+	close(responseChan)
 	return responseChan, nil
 }
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// Clanker deploys on Base
+	marketChainID    = "base"
+	marketDataSource = "dexscreener"
+
+	defaultMarketDataTTL = 5 * time.Minute
+	marketSnapshotBatch  = 200
+)
+
+var dexScreenerAPIURL = "https://api.dexscreener.com"
+
+// ErrTokenNotFound means no market lists the Anky's token yet, usually
+// because clanker hasn't deployed it or its reply wasn't seen yet.
+var ErrTokenNotFound = errors.New("token not found")
+
+// MarketDataService fetches the price and market cap of the tokens clanker
+// deploys for Ankys. Every fetch is stored as a snapshot, and the latest
+// snapshot doubles as the cache until it is older than MARKET_DATA_TTL_SECONDS.
+type MarketDataService struct {
+	store  *storage.PostgresStore
+	client *http.Client
+	ttl    time.Duration
+}
+
+func NewMarketDataService(store *storage.PostgresStore) *MarketDataService {
+	ttl := defaultMarketDataTTL
+	if value := os.Getenv("MARKET_DATA_TTL_SECONDS"); value != "" {
+		if parsed, err := time.ParseDuration(value + "s"); err == nil && parsed > 0 {
+			ttl = parsed
+		}
+	}
+	return &MarketDataService{
+		store:  store,
+		client: &http.Client{Timeout: 15 * time.Second},
+		ttl:    ttl,
+	}
+}
+
+// GetMarket returns the latest market data of the Anky's token, refreshing it
+// if the cached snapshot is stale. A stale snapshot is still returned when the
+// indexer can't be reached. Snapshots of another token than the one clanker
+// deployed are never returned.
+func (s *MarketDataService) GetMarket(ctx context.Context, anky *types.Anky) (*types.AnkyMarketSnapshot, error) {
+	if anky.TokenAddress == "" {
+		return nil, ErrTokenNotFound
+	}
+	snapshots, err := s.store.GetAnkyMarketSnapshots(ctx, anky.ID, 1)
+	if err != nil {
+		return nil, err
+	}
+	var latest *types.AnkyMarketSnapshot
+	if len(snapshots) > 0 && strings.EqualFold(snapshots[0].TokenAddress, anky.TokenAddress) {
+		latest = snapshots[0]
+		if time.Since(latest.CapturedAt) < s.ttl {
+			return latest, nil
+		}
+	}
+
+	snapshot, err := s.RefreshMarket(ctx, anky)
+	if err != nil {
+		if latest != nil && !errors.Is(err, ErrTokenNotFound) {
+			log.Printf("⚠️ Serving stale market data for anky %s: %v", anky.ID, err)
+			return latest, nil
+		}
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// RefreshMarket fetches and stores a new snapshot of the token clanker
+// deployed for the Anky. Tokens are only ever found by the contract address
+// of clanker's reply: anyone can deploy a token with the Anky's ticker and
+// name.
+func (s *MarketDataService) RefreshMarket(ctx context.Context, anky *types.Anky) (*types.AnkyMarketSnapshot, error) {
+	if anky.TokenAddress == "" {
+		return nil, ErrTokenNotFound
+	}
+	pair, err := s.pairByToken(anky.TokenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &types.AnkyMarketSnapshot{
+		AnkyID:       anky.ID,
+		TokenAddress: pair.BaseToken.Address,
+		ChainID:      pair.ChainID,
+		PriceUSD:     pair.priceUSD(),
+		MarketCapUSD: pair.MarketCap,
+		LiquidityUSD: pair.Liquidity.USD,
+		Volume24hUSD: pair.Volume.H24,
+		Source:       marketDataSource,
+	}
+	if snapshot.MarketCapUSD == 0 {
+		snapshot.MarketCapUSD = pair.FDV
+	}
+	if err := s.store.CreateAnkyMarketSnapshot(ctx, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// StartMarketSnapshotJob blocks, snapshotting the market of recently cast Ankys every interval.
+func (s *MarketDataService) StartMarketSnapshotJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.SnapshotMarkets(ctx)
+		}
+	}
+}
+
+func (s *MarketDataService) SnapshotMarkets(ctx context.Context) {
+	ankys, err := s.store.GetAnkysWithToken(ctx, marketSnapshotBatch)
+	if err != nil {
+		log.Printf("❌ Error getting ankys with a token for market snapshots: %v", err)
+		return
+	}
+
+	captured := 0
+	for _, anky := range ankys {
+		if _, err := s.RefreshMarket(ctx, anky); err != nil {
+			if !errors.Is(err, ErrTokenNotFound) {
+				log.Printf("⚠️ Could not snapshot market of anky %s: %v", anky.ID, err)
+			}
+			continue
+		}
+		captured++
+	}
+	log.Printf("📈 Captured %d market snapshots out of %d ankys with a token", captured, len(ankys))
+}
+
+func MarketSnapshotIntervalFromEnv() time.Duration {
+	if value := os.Getenv("MARKET_SNAPSHOT_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 15 * time.Minute
+}
+
+type dexScreenerPair struct {
+	ChainID   string `json:"chainId"`
+	PriceUSD  string `json:"priceUsd"`
+	BaseToken struct {
+		Address string `json:"address"`
+		Name    string `json:"name"`
+		Symbol  string `json:"symbol"`
+	} `json:"baseToken"`
+	Liquidity struct {
+		USD float64 `json:"usd"`
+	} `json:"liquidity"`
+	Volume struct {
+		H24 float64 `json:"h24"`
+	} `json:"volume"`
+	FDV       float64 `json:"fdv"`
+	MarketCap float64 `json:"marketCap"`
+}
+
+func (p *dexScreenerPair) priceUSD() float64 {
+	price, _ := strconv.ParseFloat(p.PriceUSD, 64)
+	return price
+}
+
+// pairByToken returns the most liquid Base pair of a known token.
+func (s *MarketDataService) pairByToken(tokenAddress string) (*dexScreenerPair, error) {
+	var pairs []*dexScreenerPair
+	if err := s.get(fmt.Sprintf("/tokens/v1/%s/%s", marketChainID, url.PathEscape(tokenAddress)), &pairs); err != nil {
+		return nil, err
+	}
+	return mostLiquidPair(pairs, func(pair *dexScreenerPair) bool {
+		return strings.EqualFold(pair.BaseToken.Address, tokenAddress)
+	})
+}
+
+func mostLiquidPair(pairs []*dexScreenerPair, matches func(*dexScreenerPair) bool) (*dexScreenerPair, error) {
+	var best *dexScreenerPair
+	for _, pair := range pairs {
+		if pair.ChainID != marketChainID || !matches(pair) {
+			continue
+		}
+		if best == nil || pair.Liquidity.USD > best.Liquidity.USD {
+			best = pair
+		}
+	}
+	if best == nil {
+		return nil, ErrTokenNotFound
+	}
+	return best, nil
+}
+
+func (s *MarketDataService) get(path string, v any) error {
+	resp, err := s.client.Get(dexScreenerAPIURL + path)
+	if err != nil {
+		return fmt.Errorf("error fetching market data: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status fetching market data: %d, body: %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding market data: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+const clankerTokenAddress = "0x1bc0c42215582d5a085795f4badbac3ff36d1bcb"
+
+// newFakeDexScreener lists pairs of the clanker token and of an impostor
+// deployed with the same ticker and name, more liquid than the real one.
+func newFakeDexScreener(t *testing.T) *fakeUpstream {
+	t.Helper()
+	dex := newFakeUpstream(t, func(f *fakeUpstream, w http.ResponseWriter, r *http.Request) {
+		pair := func(chain string, address string, liquidity float64, marketCap float64) map[string]interface{} {
+			return map[string]interface{}{
+				"chainId":   chain,
+				"priceUsd":  "0.0012",
+				"baseToken": map[string]string{"address": address, "name": "Wisdom Light Dancing", "symbol": "DREAM"},
+				"liquidity": map[string]float64{"usd": liquidity},
+				"volume":    map[string]float64{"h24": 10},
+				"marketCap": marketCap,
+			}
+		}
+		switch {
+		case strings.EqualFold(r.URL.Path, "/tokens/v1/base/"+clankerTokenAddress):
+			writeFakeJSON(w, []interface{}{
+				pair("base", "0xImpostor", 90000, 9000000),
+				pair("ethereum", clankerTokenAddress, 50000, 5000000),
+				pair("base", strings.ToUpper(clankerTokenAddress), 1000, 120000),
+				pair("base", clankerTokenAddress, 3000, 125000),
+			})
+		case strings.HasPrefix(r.URL.Path, "/tokens/v1/base/"):
+			writeFakeJSON(w, []interface{}{})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	previous := dexScreenerAPIURL
+	dexScreenerAPIURL = dex.URL
+	t.Cleanup(func() { dexScreenerAPIURL = previous })
+	return dex
+}
+
+func TestPairByTokenOnlyMatchesTheClankerContract(t *testing.T) {
+	newFakeDexScreener(t)
+	s := NewMarketDataService(nil)
+
+	pair, err := s.pairByToken(clankerTokenAddress)
+	if err != nil {
+		t.Fatalf("pairByToken: %v", err)
+	}
+	if !strings.EqualFold(pair.BaseToken.Address, clankerTokenAddress) || pair.ChainID != marketChainID || pair.Liquidity.USD != 3000 {
+		t.Errorf("pair = %s on %s with %v liquidity, want the most liquid Base pair of the clanker token", pair.BaseToken.Address, pair.ChainID, pair.Liquidity.USD)
+	}
+
+	if _, err := s.pairByToken("0x0000000000000000000000000000000000000001"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("unlisted token error = %v, want ErrTokenNotFound", err)
+	}
+}
+
+func TestMarketNeedsTheClankerContract(t *testing.T) {
+	dex := newFakeDexScreener(t)
+	s := NewMarketDataService(nil)
+
+	// The ticker and name alone are never looked up
+	anky := &types.Anky{ID: uuid.New(), Ticker: "DREAM", TokenName: "Wisdom Light Dancing", CastHash: "0xcast1"}
+	if _, err := s.RefreshMarket(context.Background(), anky); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("RefreshMarket error = %v, want ErrTokenNotFound", err)
+	}
+	if _, err := s.GetMarket(context.Background(), anky); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("GetMarket error = %v, want ErrTokenNotFound", err)
+	}
+	if got := dex.count("GET", "/latest/dex/search"); got != 0 {
+		t.Errorf("searched the indexer %d times", got)
+	}
+}
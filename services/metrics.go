@@ -0,0 +1,46 @@
+package services
+
+import "github.com/ankylat/anky/server/metrics"
+
+// Metrics of the minting pipeline, its upstreams and the session archive, served on /metrics
+var (
+	llmRequestDuration = metrics.NewHistogramVec("anky_llm_request_duration_seconds",
+		"Duration of LLM completions by provider, model and outcome.",
+		metrics.DurationBuckets, "provider", "model", "outcome")
+	imageJobDuration = metrics.NewHistogramVec("anky_image_job_duration_seconds",
+		"Time spent waiting for image jobs until they were done, by provider and outcome.",
+		metrics.LongDurationBuckets, "provider", "outcome")
+	imageJobPolls = metrics.NewCounterVec("anky_image_job_polls_total",
+		"Status checks sent to the image backend while waiting for jobs, by provider.", "provider")
+	imageJobWebhooks = metrics.NewCounterVec("anky_image_job_webhooks_total",
+		"Completion callbacks received from the image backend, by provider and outcome.", "provider", "outcome")
+	pinataUploadBytes = metrics.NewHistogramVec("anky_pinata_upload_bytes",
+		"Size of the files and metadata uploaded to Pinata, by upload method and outcome.",
+		metrics.SizeBuckets, "method", "outcome")
+	pinataReusedPins = metrics.NewCounterVec("anky_pinata_reused_pins_total",
+		"Uploads skipped because the content was already pinned.")
+	ankyPipelineStages = metrics.NewCounterVec("anky_pipeline_stage_total",
+		"Ankys that reached each pipeline status.", "stage")
+	ankyPipelineStageDuration = metrics.NewHistogramVec("anky_pipeline_stage_duration_seconds",
+		"Time Ankys spent in each pipeline status before moving on.",
+		metrics.LongDurationBuckets, "stage")
+	ankyPipelineFailures = metrics.NewCounterVec("anky_pipeline_failures_total",
+		"Pipeline runs that failed, by the last status they reached.", "stage")
+
+	sessionArchivals = metrics.NewCounterVec("anky_session_archivals_total",
+		"Writing sessions moved to the archive, by outcome.", "outcome")
+	sessionArchiveBytes = metrics.NewCounterVec("anky_session_archive_bytes_total",
+		"Writing moved to the archive, raw and compressed.", "kind")
+	sessionArchivalRunDuration = metrics.NewHistogramVec("anky_session_archival_run_duration_seconds",
+		"Duration of the runs of the session archival job.",
+		metrics.LongDurationBuckets)
+	sessionRehydrations = metrics.NewCounterVec("anky_session_rehydrations_total",
+		"Archived writing sessions read back from the archive, by outcome.", "outcome")
+)
+
+func outcomeLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
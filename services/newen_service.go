@@ -1,19 +1,26 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"time"
 
 	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
 )
 
 // NewenServiceInterface defines the contract for Newen-related operations
 type NewenServiceInterface interface {
-	CalculateNewenEarned(userID string, isValidAnky bool) int
+	CalculateNewenEarned(ctx context.Context, userID string, isValidAnky bool, endedAt time.Time) int
+	GrantWritingReward(ctx context.Context, session *types.WritingSession) (bool, error)
 	ProcessTransaction(userID string, walletAddress string, amount int) (bool, error)
 	GetUserBalance(userID string) (int, error)
 	UpdateUserBalance(userID string, newBalance int) error
-	GetUserTransactions(userID string) ([]NewenTransaction, error)
+	GetUserTransactions(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]*types.NewenTransaction, error)
 }
 
 type NewenService struct {
@@ -22,13 +29,6 @@ type NewenService struct {
 	userLastWrite    map[string]time.Time
 }
 
-type NewenTransaction struct {
-	Hash      string    `json:"hash"`
-	Amount    int       `json:"amount"`
-	Timestamp time.Time `json:"timestamp"`
-	Details   string    `json:"details"`
-}
-
 func NewNewenService(store *storage.PostgresStore) (*NewenService, error) {
 	return &NewenService{
 		store:            store,
@@ -37,72 +37,137 @@ func NewNewenService(store *storage.PostgresStore) (*NewenService, error) {
 	}, nil
 }
 
-func (s *NewenService) CalculateNewenEarned(userID string, isValidAnky bool) int {
+// CalculateNewenEarned returns the newen a session that ended at endedAt
+// earns, multiplied by the campaign running then if any.
+func (s *NewenService) CalculateNewenEarned(ctx context.Context, userID string, isValidAnky bool, endedAt time.Time) int {
 	if !isValidAnky {
 		return 0
 	}
 
 	newenEarned := s.fixedNewenReward
+	multiplied, campaign, err := NewCampaignService(s.store).ApplyNewenMultiplier(ctx, newenEarned, endedAt)
+	if err != nil {
+		// Campaigns only ever add to the reward, so it's paid without one
+		log.Printf("⚠️ Could not apply campaigns to the newen of user %s: %v", userID, err)
+	} else if campaign != nil {
+		log.Printf("🎉 Campaign %q multiplied the newen of user %s by %g", campaign.Name, userID, campaign.NewenMultiplier)
+		newenEarned = multiplied
+	}
 
 	// Update last write time
-	s.userLastWrite[userID] = time.Now()
+	s.userLastWrite[userID] = s.store.Clock().Now()
 
 	return newenEarned
 }
 
+// GrantWritingReward credits the newen the session earned. A session is only
+// ever rewarded once, so reporting its end again doesn't pay twice; the result
+// says whether this call paid it.
+func (s *NewenService) GrantWritingReward(ctx context.Context, session *types.WritingSession) (bool, error) {
+	amount := int(math.Round(session.NewenEarned))
+	if amount <= 0 {
+		return false, nil
+	}
+	sessionID := session.ID
+	return s.store.CreateNewenWritingReward(ctx, &types.NewenTransaction{
+		UserID:           session.UserID,
+		Amount:           amount,
+		Description:      "writing session",
+		WritingSessionID: &sessionID,
+	})
+}
+
+// ProcessTransaction spends amount of the user's newen. Users can't spend
+// more than their balance.
 func (s *NewenService) ProcessTransaction(userID string, walletAddress string, amount int) (bool, error) {
-	userBalance, err := s.GetUserBalance(userID)
+	parsedUserID, err := uuid.Parse(userID)
 	if err != nil {
-		return false, fmt.Errorf("error getting user balance: %v", err)
+		return false, fmt.Errorf("invalid user ID: %v", err)
+	}
+	if amount <= 0 {
+		return false, fmt.Errorf("amount must be positive")
 	}
 
-	if userBalance < amount {
+	_, _, err = s.store.CreateNewenDebit(context.Background(), &types.NewenTransaction{
+		UserID:      parsedUserID,
+		Amount:      amount,
+		Source:      types.NewenSourceSpend,
+		Description: fmt.Sprintf("sent to %s", walletAddress),
+	})
+	if errors.Is(err, storage.ErrInsufficientNewen) {
 		return false, fmt.Errorf("insufficient balance")
 	}
-
-	// Update user balance
-	if err := s.UpdateUserBalance(userID, userBalance-amount); err != nil {
-		return false, fmt.Errorf("error updating user balance: %v", err)
+	if err != nil {
+		return false, fmt.Errorf("error recording transaction: %v", err)
 	}
-
 	return true, nil
 }
 
+// Spend debits amount of the user's newen for a purchase and returns the
+// recorded transaction with the balance left. Overdrafts fail with
+// storage.ErrInsufficientNewen. Spending again with an idempotency key the
+// user already used returns the first spend instead, with replayed set.
+func (s *NewenService) Spend(ctx context.Context, userID uuid.UUID, amount int, description string, idempotencyKey string) (transaction *types.NewenTransaction, balance int, replayed bool, err error) {
+	if amount <= 0 {
+		return nil, 0, false, fmt.Errorf("amount must be positive")
+	}
+	transaction = &types.NewenTransaction{
+		UserID:         userID,
+		Amount:         amount,
+		Source:         types.NewenSourceSpend,
+		Description:    description,
+		IdempotencyKey: idempotencyKey,
+	}
+	balance, replayed, err = s.store.CreateNewenDebit(ctx, transaction)
+	if err != nil {
+		return nil, balance, false, err
+	}
+	return transaction, balance, replayed, nil
+}
+
 func (s *NewenService) GetUserBalance(userID string) (int, error) {
-	// TODO: Implement logic to fetch user balance from database using store
-	return 0, nil
+	parsedUserID, err := uuid.Parse(userID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user ID: %v", err)
+	}
+	return s.store.GetNewenBalance(context.Background(), parsedUserID)
 }
 
+// UpdateUserBalance records the difference to the new balance as an adjustment.
 func (s *NewenService) UpdateUserBalance(userID string, newBalance int) error {
-	// TODO: Implement logic to update user balance in database using store
-	return nil
+	balance, err := s.GetUserBalance(userID)
+	if err != nil {
+		return err
+	}
+	if balance == newBalance {
+		return nil
+	}
+	_, err = s.AdjustUserBalance(context.Background(), uuid.MustParse(userID), newBalance-balance, "balance update")
+	return err
 }
 
-func (s *NewenService) GetUserTransactions(userID string) ([]NewenTransaction, error) {
-	// TODO: Replace with actual database query using store
-	fmt.Printf("Fetching transactions for user: %s\n", userID)
-
-	now := time.Now()
-	transactions := []NewenTransaction{
-		{
-			Hash:      "0x7d3c8f6e9a2b1d4e5c8f7a9b3d2e1f4c5d6e8a7b",
-			Amount:    2675,
-			Details:   "PoW",
-			Timestamp: now,
-		},
-		{
-			Hash:      "0x2a1b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b",
-			Amount:    2675,
-			Details:   "PoW",
-			Timestamp: now.AddDate(0, 0, -1),
-		},
-		{
-			Hash:      "0xf1e2d3c4b5a6978685746352413f2e1d0c9b8a7b",
-			Amount:    -200,
-			Details:   "buy anky clanker",
-			Timestamp: now.AddDate(0, 0, -2),
-		},
+// AdjustUserBalance adds amount, which may be negative, to the user's balance
+// and returns the new balance.
+func (s *NewenService) AdjustUserBalance(ctx context.Context, userID uuid.UUID, amount int, reason string) (int, error) {
+	direction := types.NewenCredit
+	if amount < 0 {
+		direction = types.NewenDebit
+		amount = -amount
 	}
+	err := s.store.CreateNewenTransaction(ctx, &types.NewenTransaction{
+		UserID:      userID,
+		Direction:   direction,
+		Amount:      amount,
+		Source:      types.NewenSourceAdjustment,
+		Description: reason,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return s.store.GetNewenBalance(ctx, userID)
+}
 
-	return transactions, nil
+// GetUserTransactions returns a page of the user's newen ledger, most recent first.
+func (s *NewenService) GetUserTransactions(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]*types.NewenTransaction, error) {
+	return s.store.GetNewenTransactions(ctx, userID, limit, offset)
 }
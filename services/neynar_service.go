@@ -11,10 +11,15 @@ import (
 
 	"context"
 
+	"github.com/ankylat/anky/server/logging"
 	"github.com/ankylat/anky/server/types"
 	"github.com/joho/godotenv"
 )
 
+// neynarAPIURL is the root of the Neynar Farcaster API, a variable so tests
+// can point it at a fake.
+var neynarAPIURL = "https://api.neynar.com/v2/farcaster"
+
 type NeynarService struct {
 	apiKey string
 }
@@ -151,7 +156,7 @@ func NewNeynarService() *NeynarService {
 	if apiKey == "" {
 		log.Printf("Warning: NEYNAR_API_KEY not found in environment variables")
 	} else {
-		log.Printf("Initializing NeynarService with API key: %s", apiKey)
+		log.Printf("Initializing NeynarService with API key: %s", logging.Secret(apiKey))
 	}
 	return &NeynarService{
 		apiKey: apiKey,
@@ -159,7 +164,7 @@ func NewNeynarService() *NeynarService {
 }
 
 func (s *NeynarService) FetchUserCasts(fid int) ([]Cast, error) {
-	url := fmt.Sprintf("https://api.neynar.com/v2/farcaster/feed/user/casts?fid=%d&viewer_fid=16098&limit=5&include_replies=false", fid)
+	url := fmt.Sprintf(neynarAPIURL+"/feed/user/casts?fid=%d&viewer_fid=16098&limit=5&include_replies=false", fid)
 	log.Printf("Fetching casts for FID %d from URL: %s", fid, url)
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -170,7 +175,7 @@ func (s *NeynarService) FetchUserCasts(fid int) ([]Cast, error) {
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("api_key", s.apiKey)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := neynarHTTP.Do(req)
 	if err != nil {
 		log.Printf("Error sending request: %v", err)
 		return nil, err
@@ -195,22 +200,54 @@ func (s *NeynarService) FetchUserCasts(fid int) ([]Cast, error) {
 	return neynarResponse.Casts, nil
 }
 
-func (s *NeynarService) WriteCast(apiKey, signerUUID, cast_text, channelID, idem, sessionId string) (*types.Cast, error) {
+// Farcaster casts carry at most this many embeds
+const maxCastEmbeds = 2
+
+// castEmbedURLs is the Anky's frame followed by the given image URLs for as
+// long as the embed limit allows. Casts without a session, like the story
+// replies, only embed the images.
+func castEmbedURLs(sessionID string, imageURLs []string) []string {
+	embeds := []string{}
+	if sessionID != "" {
+		embeds = append(embeds, fmt.Sprintf("https://farcaster.anky.bot/anky/%s", sessionID))
+	}
+	for _, imageURL := range imageURLs {
+		if len(embeds) == maxCastEmbeds {
+			break
+		}
+		embeds = append(embeds, imageURL)
+	}
+	return embeds
+}
+
+// WriteCast casts with the Anky's frame as the first embed, followed by the
+// given image URLs for as long as the embed limit allows. The frame renders
+// the whole collection, so cut panels are still one tap away. A parentHash
+// makes the cast a reply, which lives in its parent's channel.
+func (s *NeynarService) WriteCast(apiKey, signerUUID, cast_text, channelID, parentHash, idem, sessionId string, imageURLs ...string) (*types.Cast, error) {
 	log.Println("Starting WriteCast function")
+	if err := CheckFeature(FeatureCasting); err != nil {
+		return nil, err
+	}
 
-	url := "https://api.neynar.com/v2/farcaster/cast"
+	url := neynarAPIURL + "/cast"
 	log.Printf("URL: %s", url)
 
+	embeds := []map[string]string{}
+	for _, embedURL := range castEmbedURLs(sessionId, imageURLs) {
+		embeds = append(embeds, map[string]string{"url": embedURL})
+	}
+
 	payload := map[string]interface{}{
 		"signer_uuid": signerUUID,
 		"text":        cast_text,
-		"channel_id":  channelID,
 		"idem":        idem,
-		"embeds": []map[string]string{
-			{
-				"url": fmt.Sprintf("https://farcaster.anky.bot/anky/%s", sessionId),
-			},
-		},
+		"embeds":      embeds,
+	}
+	if parentHash != "" {
+		payload["parent"] = parentHash
+	} else {
+		payload["channel_id"] = channelID
 	}
 
 	payloadBytes, err := json.Marshal(payload)
@@ -218,7 +255,7 @@ func (s *NeynarService) WriteCast(apiKey, signerUUID, cast_text, channelID, idem
 		log.Printf("Error marshaling payload: %v", err)
 		return nil, fmt.Errorf("error marshaling payload: %v", err)
 	}
-	log.Printf("Payload: %s", string(payloadBytes))
+	log.Printf("Payload: %s", logging.Redact(string(payloadBytes)))
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
 	if err != nil {
@@ -230,12 +267,16 @@ func (s *NeynarService) WriteCast(apiKey, signerUUID, cast_text, channelID, idem
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("api_key", apiKey)
 	req.Header.Add("content-type", "application/json")
-	log.Printf("Request headers: %v", req.Header)
+	// Neynar drops casts repeating an idem, so the cast may be retried
+	if idem != "" {
+		req.Header.Add("Idempotency-Key", idem)
+	}
+	log.Printf("Request headers: %v", logging.RedactHeader(req.Header))
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := neynarHTTP.Do(req)
 	if err != nil {
 		log.Printf("Error sending request: %v", err)
-		return nil, fmt.Errorf("error sending request: %v", err)
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 	defer res.Body.Close()
 	log.Println("Request sent successfully")
@@ -264,7 +305,82 @@ func (s *NeynarService) WriteCast(apiKey, signerUUID, cast_text, channelID, idem
 	return response.Cast, nil
 }
 
+// CreateCastReply casts text as a reply to the cast with parentHash, in the
+// parent's thread and channel, without embeds.
+func (s *NeynarService) CreateCastReply(apiKey, signerUUID, parentHash, text, idem string) (*types.Cast, error) {
+	if parentHash == "" {
+		return nil, fmt.Errorf("a reply needs the hash of its parent cast")
+	}
+	return s.WriteCast(apiKey, signerUUID, text, "", parentHash, idem, "")
+}
+
+// CastExists reports whether a cast hash still resolves on Farcaster. Neynar
+// answers 404 for casts that were deleted or never made it to the hubs.
+func (s *NeynarService) CastExists(ctx context.Context, hash string) (bool, error) {
+	url := fmt.Sprintf(neynarAPIURL+"/cast?identifier=%s&type=hash", hash)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("api_key", s.apiKey)
+
+	res, err := neynarHTTP.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return false, fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, string(body))
+	}
+}
+
+// CastReplies returns the direct replies to the cast with the given hash.
+func (s *NeynarService) CastReplies(ctx context.Context, hash string) ([]types.Cast, error) {
+	url := fmt.Sprintf(neynarAPIURL+"/cast/conversation?identifier=%s&type=hash&reply_depth=1&limit=50", hash)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("api_key", s.apiKey)
+
+	res, err := neynarHTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, string(body))
+	}
+	var response struct {
+		Conversation struct {
+			Cast struct {
+				DirectReplies []types.Cast `json:"direct_replies"`
+			} `json:"cast"`
+		} `json:"conversation"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding conversation: %v", err)
+	}
+	return response.Conversation.Cast.DirectReplies, nil
+}
+
 func (s *NeynarService) CreateNewFid(ctx context.Context) (int, error) {
+	if err := CheckFeature(FeatureFIDRegistration); err != nil {
+		return 0, err
+	}
 	url := "https://farcaster.anky.bot/create-new-fid"
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -275,7 +391,7 @@ func (s *NeynarService) CreateNewFid(ctx context.Context) (int, error) {
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("ANKY_API_KEY", s.apiKey)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := neynarHTTP.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("error sending request: %v", err)
 	}
@@ -313,7 +429,7 @@ func (s *NeynarService) LinkAnkyWithFid(ctx context.Context, ankyID string, fid
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("ANKY_API_KEY", s.apiKey)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := neynarHTTP.Do(req)
 	if err != nil {
 		return fmt.Errorf("error sending request: %v", err)
 	}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestWriteCastAgainstFakeNeynar(t *testing.T) {
+	neynar := newFakeNeynar(t)
+	previousURL, previousClient := neynarAPIURL, neynarHTTP
+	neynarAPIURL, neynarHTTP = neynar.URL, newTestResilientClient(UpstreamNeynar, 3)
+	t.Cleanup(func() { neynarAPIURL, neynarHTTP = previousURL, previousClient })
+
+	service := &NeynarService{apiKey: "test-neynar-key"}
+
+	// A 503 is retried under the same idempotency key
+	neynar.fail(1)
+	cast, err := service.WriteCast("test-neynar-key", "signer-1", "my anky", "anky", "", "session-1", "session-1", "https://example.com/anky.png")
+	if err != nil {
+		t.Fatalf("WriteCast: %v", err)
+	}
+	if cast.Hash != "0xcast1" {
+		t.Errorf("hash = %q, want 0xcast1", cast.Hash)
+	}
+	if got := neynar.count("POST", "/cast"); got != 2 {
+		t.Errorf("cast requests = %d, want 2", got)
+	}
+
+	reply, err := service.CreateCastReply("test-neynar-key", "signer-1", cast.Hash, "the story", "session-1-1")
+	if err != nil {
+		t.Fatalf("CreateCastReply: %v", err)
+	}
+
+	sent := neynar.sent()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d casts, want 2", len(sent))
+	}
+	if sent[0]["channel_id"] != "anky" || sent[0]["idem"] != "session-1" || sent[0]["parent"] != nil {
+		t.Errorf("cast payload = %v, want it in the anky channel under its idempotency key", sent[0])
+	}
+	if embeds, _ := sent[0]["embeds"].([]interface{}); len(embeds) == 0 {
+		t.Errorf("cast payload has no embeds: %v", sent[0])
+	}
+	if sent[1]["parent"] != cast.Hash || sent[1]["channel_id"] != nil {
+		t.Errorf("reply payload = %v, want it under %s", sent[1], cast.Hash)
+	}
+	if reply.Hash != "0xcast2" {
+		t.Errorf("reply hash = %q, want 0xcast2", reply.Hash)
+	}
+
+	neynar.failAlways()
+	if _, err := service.WriteCast("test-neynar-key", "signer-1", "my anky", "anky", "", "session-2", "session-2"); err == nil {
+		t.Fatal("WriteCast succeeded while Neynar answered 503")
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+)
+
+const (
+	thumbnailMaxSide = 256
+	thumbnailQuality = 70
+	// Source images above this size are not worth downloading for a thumbnail
+	thumbnailMaxSourceBytes = 20 << 20
+)
+
+type NFTAttribute struct {
+	TraitType string `json:"trait_type"`
+	Value     string `json:"value"`
+}
+
+// AnkyNFTMetadata follows the ERC-721 metadata JSON schema. The license is
+// repeated as an attribute so marketplaces that only show traits display it.
+type AnkyNFTMetadata struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Image       string         `json:"image"`
+	License     string         `json:"license"`
+	LicenseURL  string         `json:"license_url,omitempty"`
+	Attributes  []NFTAttribute `json:"attributes"`
+}
+
+func NewAnkyNFTMetadata(tokenName string, ticker string, story string, image string, license string) *AnkyNFTMetadata {
+	if license == "" {
+		license = types.DefaultLicense
+	}
+	return &AnkyNFTMetadata{
+		Name:        tokenName,
+		Description: story,
+		Image:       image,
+		License:     license,
+		LicenseURL:  types.LicenseURL(license),
+		Attributes: []NFTAttribute{
+			{TraitType: "ticker", Value: ticker},
+			{TraitType: "license", Value: license},
+		},
+	}
+}
+
+// DataURI encodes the metadata as a base64 JSON data URI, resolvable without IPFS.
+func (m *AnkyNFTMetadata) DataURI() (string, error) {
+	jsonData, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+	return "data:application/json;base64," + base64.StdEncoding.EncodeToString(jsonData), nil
+}
+
+// degradedMetadataURI builds the fallback metadata used when pinning failed:
+// the story plus a compressed thumbnail, both embedded as data URIs. If the
+// thumbnail cannot be built, the image points at imageURL instead.
+func degradedMetadataURI(tokenName string, ticker string, story string, imageURL string, license string) (string, error) {
+	image := imageURL
+	thumbnail, err := thumbnailDataURI(imageURL)
+	if err != nil {
+		log.Printf("⚠️ Could not build thumbnail for degraded metadata, linking the image instead: %v", err)
+	} else {
+		image = thumbnail
+	}
+
+	metadata := NewAnkyNFTMetadata(tokenName, ticker, story, image, license)
+	metadata.Attributes = append(metadata.Attributes, NFTAttribute{TraitType: "storage", Value: "degraded"})
+	return metadata.DataURI()
+}
+
+// thumbnailDataURI downloads the image and returns it as a small JPEG data URI.
+func thumbnailDataURI(imageURL string) (string, error) {
+	if imageURL == "" {
+		return "", fmt.Errorf("no image url")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(imageURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+
+	img, _, err := image.Decode(io.LimitReader(resp.Body, thumbnailMaxSourceBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, downscale(img, thumbnailMaxSide), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return "", fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// downscale shrinks img so its longest side is at most maxSide, averaging the
+// source pixels that fall into each destination pixel.
+func downscale(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+
+	dstWidth, dstHeight := maxSide, height*maxSide/width
+	if height > width {
+		dstWidth, dstHeight = width*maxSide/height, maxSide
+	}
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := bounds.Min.Y + (y+1)*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := bounds.Min.X + (x+1)*width/dstWidth
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// framesMetadataPath is where the framesgiving flow keeps the metadata of each session.
+func framesMetadataPath(sessionID string) string {
+	return fmt.Sprintf("data/framesgiving/ankys/%s.txt", sessionID)
+}
+
+// FramesAnkyMetadata is the content of a framesgiving metadata file. The first
+// five lines are always present; ImageURL and MetadataURI are only set when
+// pinning failed and the session fell back to data URI metadata, and the
+// eighth line holds the license of sessions submitted with one.
+type FramesAnkyMetadata struct {
+	TokenName   string
+	Ticker      string
+	Number      string
+	Story       string
+	IPFSHash    string
+	ImageURL    string
+	MetadataURI string
+	License     string
+}
+
+// Degraded reports whether the session is waiting for its image to be pinned.
+func (m *FramesAnkyMetadata) Degraded() bool {
+	return m.IPFSHash == "" && m.MetadataURI != ""
+}
+
+func ReadFramesAnkyMetadata(sessionID string) (*FramesAnkyMetadata, error) {
+	content, err := utils.Files.ReadFile(framesMetadataPath(sessionID))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	if len(lines) < 5 {
+		return nil, fmt.Errorf("invalid metadata file format")
+	}
+
+	metadata := &FramesAnkyMetadata{
+		TokenName: lines[0],
+		Ticker:    lines[1],
+		Number:    lines[2],
+		Story:     lines[3],
+		IPFSHash:  lines[4],
+	}
+	if len(lines) >= 7 {
+		metadata.ImageURL = lines[5]
+		metadata.MetadataURI = lines[6]
+	}
+	metadata.License = types.DefaultLicense
+	if len(lines) >= 8 && types.IsValidLicense(lines[7]) {
+		metadata.License = lines[7]
+	}
+	return metadata, nil
+}
+
+func WriteFramesAnkyMetadata(sessionID string, metadata *FramesAnkyMetadata) error {
+	content := fmt.Sprintf("%s\n%s\n%s\n%s\n%s", metadata.TokenName, metadata.Ticker, metadata.Number, metadata.Story, metadata.IPFSHash)
+	if metadata.MetadataURI != "" || metadata.License != "" {
+		content += fmt.Sprintf("\n%s\n%s", metadata.ImageURL, metadata.MetadataURI)
+	}
+	if metadata.License != "" {
+		content += "\n" + metadata.License
+	}
+
+	if err := utils.Files.WriteFile(framesMetadataPath(sessionID), []byte(content), 0644); err != nil {
+		return fmt.Errorf("error writing metadata file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"log"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+// parkedAnkysBatch is how many parked Ankys are read at a time.
+const parkedAnkysBatch = 50
+
+// parkedStages are the status the Ankys waiting for each feature are left
+// in, and the stage their pipeline goes on from.
+var parkedStages = map[string]struct {
+	status string
+	stage  string
+}{
+	FeatureImageGeneration: {status: "pending_image", stage: types.PipelineStageImage},
+	FeatureCasting:         {status: "pending_to_cast", stage: types.PipelineStageCast},
+}
+
+// ParkedAnkyService goes on with the Ankys whose pipeline waited while a
+// feature was switched off, once it is switched back on: pending_image Ankys
+// get their image, pending_to_cast ones are cast.
+type ParkedAnkyService struct {
+	store *storage.PostgresStore
+}
+
+func NewParkedAnkyService(store *storage.PostgresStore) *ParkedAnkyService {
+	return &ParkedAnkyService{store: store}
+}
+
+// Run resumes the parked Ankys of every feature switched back on until ctx
+// is done. Kill switches are flipped on one instance, so it runs on every
+// instance.
+func (s *ParkedAnkyService) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case feature := <-featureResumed:
+			resumed, err := s.ResumeParkedAnkys(ctx, feature)
+			if err != nil {
+				log.Printf("❌ Error resuming the ankys waiting for %s: %v", featureNames[feature], err)
+			}
+			if resumed > 0 {
+				log.Printf("🔁 Resumed %d ankys waiting for %s", resumed, featureNames[feature])
+			}
+		}
+	}
+}
+
+// ResumeParkedAnkys goes on with the pipeline of the Ankys parked for the
+// feature, until it is switched off again. Ankys that park again, like the
+// ones of writers without a signer, wait for the next time.
+func (s *ParkedAnkyService) ResumeParkedAnkys(ctx context.Context, feature string) (int, error) {
+	parked, ok := parkedStages[feature]
+	if !ok {
+		return 0, nil
+	}
+	ankyService, err := NewAnkyService(s.store)
+	if err != nil {
+		return 0, err
+	}
+
+	started := s.store.Clock().Now().UTC()
+	resumed := 0
+	var last *types.Anky
+	for {
+		ankys, err := s.store.GetParkedAnkys(ctx, parked.status, started, last, parkedAnkysBatch)
+		if err != nil {
+			return resumed, err
+		}
+		if len(ankys) == 0 {
+			return resumed, nil
+		}
+		// The pipeline moves the Ankys along, the page ends where they were
+		cursor := *ankys[len(ankys)-1]
+		last = &cursor
+		for _, anky := range ankys {
+			if err := CheckFeature(feature); err != nil {
+				return resumed, nil
+			}
+			if err := ankyService.ResumeParkedAnky(ctx, anky, parked.stage); err != nil {
+				log.Printf("❌ Error resuming anky %s: %v", anky.ID, err)
+				continue
+			}
+			resumed++
+		}
+	}
+}
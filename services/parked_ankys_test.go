@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParkedAnkyGoesOnWhenImageGenerationIsBack(t *testing.T) {
+	store, fakes, anky, writing := newPipelineTest(t)
+	path := filepath.Join("data/writing_sessions", anky.UserID.String(), anky.WritingSessionID.String()+".txt")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(writing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetKillSwitches(map[string]bool{FeatureImageGeneration: true}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetKillSwitches(map[string]bool{FeatureImageGeneration: false}) })
+
+	stored, err := runTestPipeline(t, store, anky, writing)
+	if err != nil {
+		t.Fatalf("pipeline: %v", err)
+	}
+	if stored.Status != "pending_image" {
+		t.Fatalf("status = %q, want pending_image", stored.Status)
+	}
+	if got := fakes.midjourney.count("POST", "/items/images/"); got != 0 {
+		t.Errorf("submitted %d image jobs while image generation was off", got)
+	}
+
+	if err := SetKillSwitches(map[string]bool{FeatureImageGeneration: false}); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := NewParkedAnkyService(store).ResumeParkedAnkys(context.Background(), FeatureImageGeneration)
+	if err != nil {
+		t.Fatalf("ResumeParkedAnkys: %v", err)
+	}
+	if resumed != 1 {
+		t.Errorf("resumed %d ankys, want 1", resumed)
+	}
+
+	stored, err = store.GetAnkyByID(context.Background(), anky.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "completed" || stored.ImageURL == "" || len(fakes.neynar.sent()) != 1 {
+		t.Errorf("status = %q, image = %q, %d casts, want it completed with its image and cast", stored.Status, stored.ImageURL, len(fakes.neynar.sent()))
+	}
+}
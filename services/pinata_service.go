@@ -2,37 +2,102 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgx/v4"
 )
 
+// UploadProgressFunc is called while an upload is in flight with the number of
+// bytes acknowledged so far and the total size of the file.
+type UploadProgressFunc func(uploaded int64, total int64)
+
+const (
+	// Files larger than this are sent through Pinata's resumable (tus) endpoint
+	// in chunks instead of a single pinFileToIPFS request.
+	pinataResumableThreshold = 8 * 1024 * 1024
+	pinataChunkSize          = 4 * 1024 * 1024
+	pinataMaxAttempts        = 5
+)
+
+// The Pinata APIs and the wait before retrying an upload, variables so tests
+// can point them at a fake
+var (
+	pinataAPIURL           = "https://anky.pinata.cloud"
+	pinataUploadsURL       = "https://uploads.pinata.cloud/v3/files"
+	pinataFilesURL         = "https://api.pinata.cloud/v3/files/public"
+	pinataUnpinURL         = "https://api.pinata.cloud/pinning/unpin"
+	pinataRetryBaseBackoff = time.Second
+)
+
+// Gateway image URLs are built with unless IPFS_GATEWAY_URL says otherwise
+const defaultIPFSGatewayURL = "https://gateway.pinata.cloud/ipfs"
+
+// IPFSGatewayURL is where browsers and Farcaster clients can load the pinned
+// content with the given hash, which may carry an ipfs:// prefix.
+func IPFSGatewayURL(ipfsHash string) string {
+	gateway := strings.TrimSuffix(os.Getenv("IPFS_GATEWAY_URL"), "/")
+	if gateway == "" {
+		gateway = defaultIPFSGatewayURL
+	}
+	return gateway + "/" + strings.TrimPrefix(ipfsHash, "ipfs://")
+}
+
 type PinataService struct {
-	jwt         string
-	apiEndpoint string
-	
+	jwt              string
+	apiEndpoint      string
+	uploadsEndpoint  string
+	filesEndpoint    string
+	unpinEndpoint    string
+	client           *ResilientClient
+	retryBaseBackoff time.Duration
+	// Pins are recorded here so identical content is only uploaded once. Nil
+	// turns deduplication off.
+	store *storage.PostgresStore
 }
 
-func NewPinataService() (*PinataService, error) {
-	// Get JWT from environment
-	jwt := os.Getenv("PINATA_JWT")
-	if jwt == "" {
-		return nil, fmt.Errorf("PINATA_JWT not found in environment")
+func NewPinataService(store *storage.PostgresStore) (*PinataService, error) {
+	if err := CheckCapability(CapabilityPinata); err != nil {
+		return nil, err
 	}
+	jwt := os.Getenv("PINATA_JWT")
 
 	return &PinataService{
-		jwt:         jwt,
-		apiEndpoint: "https://anky.pinata.cloud",
+		jwt:              jwt,
+		apiEndpoint:      pinataAPIURL,
+		uploadsEndpoint:  pinataUploadsURL,
+		filesEndpoint:    pinataFilesURL,
+		unpinEndpoint:    pinataUnpinURL,
+		client:           pinataHTTP,
+		retryBaseBackoff: pinataRetryBaseBackoff,
+		store:            store,
 	}, nil
 }
 
-
-
 func (s *PinataService) UploadImageFromURL(imageURL string) (string, error) {
+	return s.UploadImageFromURLWithProgress(imageURL, nil)
+}
+
+// UploadImageFromURLWithProgress downloads the image and pins it, reporting
+// upload progress through onProgress.
+func (s *PinataService) UploadImageFromURLWithProgress(imageURL string, onProgress UploadProgressFunc) (string, error) {
 	log.Printf("Starting Pinata upload process for image URL: %s", imageURL)
 
 	// Download image from URL
@@ -48,130 +113,389 @@ func (s *PinataService) UploadImageFromURL(imageURL string) (string, error) {
 		return "", fmt.Errorf("failed to read image data: %v", err)
 	}
 
-	// Create multipart form data
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", "image")
+	ipfsHash, err := s.UploadFile("image", imageData, onProgress)
 	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %v", err)
+		return "", err
 	}
 
-	// Write image data to form
-	if _, err := part.Write(imageData); err != nil {
-		return "", fmt.Errorf("failed to write image data: %v", err)
-	}
-	writer.Close()
+	log.Printf("Successfully uploaded to IPFS with hash: %s", ipfsHash)
+	return ipfsHash, nil
+}
+
+func (s *PinataService) UploadJSONMetadata(metadata interface{}) (string, error) {
+	log.Printf("Starting Pinata upload process for metadata")
 
-	// Create upload request
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/pinning/pinFileToIPFS", s.apiEndpoint), body)
+	// Convert metadata to JSON
+	jsonData, err := json.Marshal(metadata)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return "", fmt.Errorf("failed to marshal metadata: %v", err)
 	}
 
-	// Set headers
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if ipfsHash, ok := s.pinnedHash(jsonData); ok {
+		pinataReusedPins.Inc()
+		return ipfsHash, nil
+	}
 
-	// Send request
-	client := &http.Client{}
-	resp, err = client.Do(req)
+	var ipfsHash string
+	err = s.withRetries("pinJSONToIPFS", func() error {
+		// Create request
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/pinning/pinJSONToIPFS", s.apiEndpoint), bytes.NewBuffer(jsonData))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+
+		// Set headers
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
+		req.Header.Set("Content-Type", "application/json")
+
+		ipfsHash, err = s.doPinRequest(req)
+		return err
+	})
+	pinataUploadBytes.Observe(float64(len(jsonData)), "json", outcomeLabel(err))
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	s.recordPin(jsonData, "metadata.json", ipfsHash)
 
-	// Parse response
-	var result struct {
-		IpfsHash string `json:"IpfsHash"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
-	}
+	log.Printf("Successfully uploaded metadata to IPFS with hash: %s", ipfsHash)
+	return ipfsHash, nil
+}
 
-	log.Printf("Successfully uploaded to IPFS with hash: %s", result.IpfsHash)
-	return result.IpfsHash, nil
+func (s *PinataService) UploadTXTFile(file_long_string string) (string, error) {
+	return s.UploadTXTFileWithProgress(file_long_string, nil)
 }
 
-func (s *PinataService) UploadJSONMetadata(metadata interface{}) (string, error) {
-	log.Printf("Starting Pinata upload process for metadata")
+// UploadTXTFileWithProgress pins a text file, reporting upload progress through onProgress.
+func (s *PinataService) UploadTXTFileWithProgress(file_long_string string, onProgress UploadProgressFunc) (string, error) {
+	log.Printf("Starting Pinata upload process for text file")
 
-	// Convert metadata to JSON
-	jsonData, err := json.Marshal(metadata)
+	ipfsHash, err := s.UploadFile("content.txt", []byte(file_long_string), onProgress)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal metadata: %v", err)
+		return "", err
 	}
 
-	// Create request
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/pinning/pinJSONToIPFS", s.apiEndpoint), bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+	log.Printf("Successfully uploaded text file to IPFS with hash: %s", ipfsHash)
+	return ipfsHash, nil
+}
+
+// UploadFile pins data under the given file name. Small files go through a
+// single pinFileToIPFS request, retried when it failed before Pinata got it;
+// large files are uploaded in chunks through the resumable endpoint so that
+// a network blip only costs the chunk in flight.
+func (s *PinataService) UploadFile(name string, data []byte, onProgress UploadProgressFunc) (string, error) {
+	if onProgress == nil {
+		onProgress = func(int64, int64) {}
 	}
 
-	// Set headers
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
-	req.Header.Set("Content-Type", "application/json")
+	if ipfsHash, ok := s.pinnedHash(data); ok {
+		pinataReusedPins.Inc()
+		onProgress(int64(len(data)), int64(len(data)))
+		return ipfsHash, nil
+	}
 
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if len(data) > pinataResumableThreshold {
+		log.Printf("📦 File %s is %d bytes, using resumable upload", name, len(data))
+		ipfsHash, err := s.uploadResumable(name, data, onProgress)
+		pinataUploadBytes.Observe(float64(len(data)), "resumable", outcomeLabel(err))
+		if err != nil {
+			return "", err
+		}
+		s.recordPin(data, name, ipfsHash)
+		return ipfsHash, nil
+	}
+
+	var ipfsHash string
+	err := s.withRetries("pinFileToIPFS", func() error {
+		// Create multipart form data
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			return fmt.Errorf("failed to create form file: %v", err)
+		}
+		if _, err := part.Write(data); err != nil {
+			return fmt.Errorf("failed to write file data: %v", err)
+		}
+		writer.Close()
+
+		// Create upload request, counting bytes as they leave
+		total := int64(body.Len())
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/pinning/pinFileToIPFS", s.apiEndpoint), &progressReader{
+			reader:     body,
+			total:      total,
+			onProgress: onProgress,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.ContentLength = total
+
+		// Set headers
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		ipfsHash, err = s.doPinRequest(req)
+		return err
+	})
+	pinataUploadBytes.Observe(float64(len(data)), "file", outcomeLabel(err))
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	s.recordPin(data, name, ipfsHash)
+	return ipfsHash, nil
+}
 
-	// Parse response
-	var result struct {
-		IpfsHash string `json:"IpfsHash"`
+// pinnedHash returns the IPFS hash data was already pinned under, if any.
+// Lookup failures only cost a duplicate upload.
+func (s *PinataService) pinnedHash(data []byte) (string, bool) {
+	if s.store == nil {
+		return "", false
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+	contentHash := contentHash(data)
+	pin, err := s.store.GetIPFSPinByContentHash(context.Background(), contentHash)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("⚠️ Could not look up pin of content %s: %v", contentHash, err)
+		}
+		return "", false
 	}
+	if err := s.store.MarkIPFSPinReused(context.Background(), contentHash); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+	log.Printf("♻️ Content %s is already pinned as %s, skipping upload", contentHash, pin.IPFSHash)
+	return pin.IPFSHash, true
+}
 
-	log.Printf("Successfully uploaded metadata to IPFS with hash: %s", result.IpfsHash)
-	return result.IpfsHash, nil
+func (s *PinataService) recordPin(data []byte, name string, ipfsHash string) {
+	if s.store == nil || ipfsHash == "" {
+		return
+	}
+	pin := &types.IPFSPin{
+		ContentHash: contentHash(data),
+		IPFSHash:    ipfsHash,
+		Name:        name,
+		SizeBytes:   int64(len(data)),
+		CreatedAt:   s.store.Clock().Now().UTC(),
+	}
+	if err := s.store.SaveIPFSPin(context.Background(), pin); err != nil {
+		log.Printf("⚠️ Could not record pin %s: %v", ipfsHash, err)
+	}
 }
 
-func (s *PinataService) UploadTXTFile(file_long_string string) (string, error) {
-	log.Printf("Starting Pinata upload process for text file")
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
 
-	// Create form data
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
+// Unpin removes the IPFS hash from Pinata. Content that is no longer pinned
+// counts as unpinned.
+func (s *PinataService) Unpin(ctx context.Context, ipfsHash string) error {
+	return s.withRetries("unpin", func() error {
+		req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/%s", s.unpinEndpoint, ipfsHash), nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			body, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("unexpected status unpinning: %d, body: %s", resp.StatusCode, string(body))
+		}
+		return nil
+	})
+}
 
-	// Create file field
-	fw, err := w.CreateFormFile("file", "content.txt")
+// uploadResumable implements the client side of the tus protocol exposed by
+// Pinata's uploads endpoint. When a chunk fails, the server is asked for the
+// offset it has acknowledged and the upload resumes from there.
+func (s *PinataService) uploadResumable(name string, data []byte, onProgress UploadProgressFunc) (string, error) {
+	total := int64(len(data))
+
+	var uploadURL string
+	err := s.withRetries("create resumable upload", func() error {
+		req, err := http.NewRequest("POST", s.uploadsEndpoint, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Length", strconv.FormatInt(total, 10))
+		req.Header.Set("Upload-Metadata", fmt.Sprintf("filename %s,network %s",
+			base64.StdEncoding.EncodeToString([]byte(name)),
+			base64.StdEncoding.EncodeToString([]byte("public"))))
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			body, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("unexpected status creating upload: %d, body: %s", resp.StatusCode, string(body))
+		}
+
+		uploadURL = resp.Header.Get("Location")
+		if uploadURL == "" {
+			return fmt.Errorf("pinata did not return an upload location")
+		}
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %v", err)
+		return "", err
 	}
-
-	// Write string content to file field
-	if _, err := fw.Write([]byte(file_long_string)); err != nil {
-		return "", fmt.Errorf("failed to write file content: %v", err)
+	log.Printf("📤 Created resumable upload at %s", uploadURL)
+
+	var offset int64
+	failures := 0
+	for offset < total {
+		end := offset + pinataChunkSize
+		if end > total {
+			end = total
+		}
+
+		newOffset, err := s.patchChunk(uploadURL, offset, data[offset:end])
+		if err != nil {
+			failures++
+			if failures >= pinataMaxAttempts {
+				return "", fmt.Errorf("resumable upload failed at offset %d: %v", offset, err)
+			}
+			log.Printf("⚠️ Chunk at offset %d failed (%v), asking server for resume offset", offset, err)
+			time.Sleep(s.backoff(failures))
+
+			serverOffset, headErr := s.uploadOffset(uploadURL)
+			if headErr != nil {
+				log.Printf("⚠️ Could not fetch resume offset: %v", headErr)
+				continue
+			}
+			offset = serverOffset
+			onProgress(offset, total)
+			continue
+		}
+
+		failures = 0
+		offset = newOffset
+		onProgress(offset, total)
 	}
 
-	// Close multipart writer
-	w.Close()
+	uploadID := uploadURL[strings.LastIndex(uploadURL, "/")+1:]
+	return s.fileCID(uploadID)
+}
+
+func (s *PinataService) patchChunk(uploadURL string, offset int64, chunk []byte) (int64, error) {
+	req, err := http.NewRequest("PATCH", uploadURL, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
 
-	// Create request
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/pinning/pinFileToIPFS", s.apiEndpoint), &b)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return 0, fmt.Errorf("failed to send chunk: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("unexpected status uploading chunk: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	// Set headers
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+func (s *PinataService) uploadOffset(uploadURL string) (int64, error) {
+	req, err := http.NewRequest("HEAD", uploadURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
-	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Tus-Resumable", "1.0.0")
 
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+func (s *PinataService) fileCID(fileID string) (string, error) {
+	var cid string
+	err := s.withRetries("fetch uploaded file", func() error {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", s.filesEndpoint, fileID), nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt))
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		var result struct {
+			Data struct {
+				CID string `json:"cid"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+		if result.Data.CID == "" {
+			return fmt.Errorf("uploaded file %s has no cid yet", fileID)
+		}
+		cid = result.Data.CID
+		return nil
+	})
+	return cid, err
+}
+
+// errPinNotRetried marks a pin request that isn't sent again: Pinata got all
+// of it and may have pinned the content even though the request failed, or
+// it refused the request for good.
+var errPinNotRetried = errors.New("not retried")
+
+// doPinRequest sends a pin request, which pins the content again every time
+// it is sent. Failures are only retried when Pinata can't have pinned
+// anything: the request didn't leave whole, or Pinata answered that it was
+// unavailable or rate limiting.
+func (s *PinataService) doPinRequest(req *http.Request) (string, error) {
+	var sent atomic.Bool
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				sent.Store(true)
+			}
+		},
+	}))
+
+	resp, err := s.client.Do(req)
+	if err != nil && sent.Load() {
+		return "", fmt.Errorf("pinata may have pinned it, %w: %w", errPinNotRetried, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("pinata request failed with status: %d", resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("pinata request failed with status: %d, body: %s", resp.StatusCode, string(body))
+		if resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusTooManyRequests {
+			return "", fmt.Errorf("%w: %w", errPinNotRetried, err)
+		}
+		return "", err
 	}
 
 	// Parse response
@@ -181,7 +505,44 @@ func (s *PinataService) UploadTXTFile(file_long_string string) (string, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", fmt.Errorf("failed to decode response: %v", err)
 	}
-
-	log.Printf("Successfully uploaded text file to IPFS with hash: %s", result.IpfsHash)
 	return result.IpfsHash, nil
 }
+
+func (s *PinataService) withRetries(operation string, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= pinataMaxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrCircuitOpen) || errors.Is(err, errPinNotRetried) {
+			return fmt.Errorf("pinata %s failed: %w", operation, err)
+		}
+		if attempt < pinataMaxAttempts {
+			log.Printf("⚠️ Pinata %s attempt %d/%d failed: %v", operation, attempt, pinataMaxAttempts, err)
+			time.Sleep(s.backoff(attempt))
+		}
+	}
+	return fmt.Errorf("pinata %s failed after %d attempts: %w", operation, pinataMaxAttempts, err)
+}
+
+func (s *PinataService) backoff(attempt int) time.Duration {
+	return s.retryBaseBackoff * time.Duration(1<<uint(attempt-1))
+}
+
+// progressReader reports how many bytes of the request body have been read by
+// the HTTP client.
+type progressReader struct {
+	reader     io.Reader
+	read       int64
+	total      int64
+	onProgress UploadProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.onProgress(r.read, r.total)
+	}
+	return n, err
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPinataService(t *testing.T, pinata *fakeUpstream) *PinataService {
+	t.Helper()
+	return &PinataService{
+		jwt:              "test-pinata-jwt",
+		apiEndpoint:      pinata.URL,
+		uploadsEndpoint:  pinata.URL,
+		filesEndpoint:    pinata.URL,
+		unpinEndpoint:    pinata.URL,
+		client:           newTestResilientClient(UpstreamPinata, 1),
+		retryBaseBackoff: time.Millisecond,
+	}
+}
+
+func TestPinataRetriesFailedUploads(t *testing.T) {
+	pinata := newFakePinata(t)
+	service := newTestPinataService(t, pinata)
+
+	pinata.fail(2)
+	hash, err := service.UploadFile("content.txt", []byte("the writing"), nil)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if hash != fakePinataHash {
+		t.Errorf("hash = %q, want %q", hash, fakePinataHash)
+	}
+	if got := pinata.count("POST", "/pinning/pinFileToIPFS"); got != 3 {
+		t.Errorf("pinFileToIPFS requests = %d, want 3", got)
+	}
+
+	hash, err = service.UploadJSONMetadata(map[string]string{"name": "anky"})
+	if err != nil || hash != fakePinataHash {
+		t.Fatalf("UploadJSONMetadata = %q, %v", hash, err)
+	}
+}
+
+func TestPinataGivesUpAfterMaxAttempts(t *testing.T) {
+	pinata := newFakePinata(t)
+	service := newTestPinataService(t, pinata)
+
+	pinata.failAlways()
+	_, err := service.UploadFile("content.txt", []byte("the writing"), nil)
+	if err == nil || !strings.Contains(err.Error(), "after 5 attempts") {
+		t.Fatalf("UploadFile error = %v, want it to give up after %d attempts", err, pinataMaxAttempts)
+	}
+	if got := pinata.count("POST", "/pinning/pinFileToIPFS"); got != pinataMaxAttempts {
+		t.Errorf("pinFileToIPFS requests = %d, want %d", got, pinataMaxAttempts)
+	}
+}
+
+func TestPinataStopsOnOpenBreaker(t *testing.T) {
+	pinata := newFakePinata(t)
+	service := newTestPinataService(t, pinata)
+	service.client = NewResilientClient(UpstreamPinata, ResilientConfig{
+		Timeout:          time.Second,
+		MaxAttempts:      1,
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+	})
+
+	pinata.failAlways()
+	_, err := service.UploadFile("content.txt", []byte("the writing"), nil)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("UploadFile error = %v, want the open breaker", err)
+	}
+	if got := pinata.count("POST", "/pinning/pinFileToIPFS"); got != 2 {
+		t.Errorf("pinFileToIPFS requests = %d, want 2 before the breaker opened", got)
+	}
+}
+
+func TestPinataDoesNotResendAPinThatTimedOut(t *testing.T) {
+	pinata := newFakeUpstream(t, func(f *fakeUpstream, w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		// Pinata got the whole file and answers too late
+		time.Sleep(200 * time.Millisecond)
+		writeFakeJSON(w, map[string]string{"IpfsHash": fakePinataHash})
+	})
+	service := newTestPinataService(t, pinata)
+	service.client = NewResilientClient(UpstreamPinata, ResilientConfig{
+		Timeout:          50 * time.Millisecond,
+		MaxAttempts:      1,
+		FailureThreshold: 20,
+		Cooldown:         time.Second,
+	})
+
+	_, err := service.UploadFile("content.txt", []byte("the writing"), nil)
+	if !errors.Is(err, errPinNotRetried) {
+		t.Fatalf("UploadFile error = %v, want it not retried", err)
+	}
+	if got := pinata.count("POST", "/pinning/pinFileToIPFS"); got != 1 {
+		t.Errorf("pinFileToIPFS requests = %d, want the timed out one only", got)
+	}
+}
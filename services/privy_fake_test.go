@@ -0,0 +1,112 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// fakePrivyServer answers the calls PrivyService makes to Privy's server API
+// the way Privy does: it checks the app credentials, returns 404 for unknown
+// DIDs and encodes users like Privy's documented payload, which
+// TestFakePrivyServerMatchesPrivysPayload holds it to.
+type fakePrivyServer struct {
+	*httptest.Server
+	appID     string
+	appSecret string
+
+	mu    sync.Mutex
+	users map[string]*types.PrivyUser
+}
+
+func newFakePrivyServer(t *testing.T, appID string, appSecret string) *fakePrivyServer {
+	f := &fakePrivyServer{appID: appID, appSecret: appSecret, users: make(map[string]*types.PrivyUser)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/", f.handleGetUser)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// addUser makes Privy know the user, replacing any user with the same DID.
+func (f *fakePrivyServer) addUser(user *types.PrivyUser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[user.DID] = user
+}
+
+// service returns a PrivyService that calls this server with its credentials.
+func (f *fakePrivyServer) service() *PrivyService {
+	return NewPrivyServiceWithURL(f.URL, f.appID, f.appSecret)
+}
+
+func (f *fakePrivyServer) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writePrivyError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	appID, appSecret, ok := r.BasicAuth()
+	if !ok || r.Header.Get("privy-app-id") != appID ||
+		subtle.ConstantTimeCompare([]byte(appID), []byte(f.appID)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(appSecret), []byte(f.appSecret)) != 1 {
+		writePrivyError(w, http.StatusUnauthorized, "Invalid app ID or app secret.")
+		return
+	}
+
+	did := strings.TrimPrefix(r.URL.Path, "/api/v1/users/")
+	f.mu.Lock()
+	user, ok := f.users[did]
+	f.mu.Unlock()
+	if !ok {
+		writePrivyError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	accounts := make([]map[string]interface{}, 0, len(user.LinkedAccounts))
+	for _, account := range user.LinkedAccounts {
+		fields := map[string]interface{}{
+			"type":               account.Type,
+			"verified_at":        account.VerifiedAt,
+			"first_verified_at":  account.FirstVerifiedAt,
+			"latest_verified_at": account.LatestVerifiedAt,
+		}
+		set := func(key string, value interface{}, present bool) {
+			if present {
+				fields[key] = value
+			}
+		}
+		set("address", account.Address, account.Address != "")
+		set("chain_type", account.ChainType, account.ChainType != "")
+		set("fid", account.FID, account.FID != 0)
+		set("owner_address", account.OwnerAddress, account.OwnerAddress != "")
+		set("username", account.Username, account.Username != "")
+		set("display_name", account.DisplayName, account.DisplayName != "")
+		set("bio", account.Bio, account.Bio != "")
+		set("profile_picture", account.ProfilePicture, account.ProfilePicture != "")
+		set("profile_picture_url", account.ProfilePictureURL, account.ProfilePictureURL != "")
+		accounts = append(accounts, fields)
+	}
+	writePrivyJSON(w, http.StatusOK, map[string]interface{}{
+		"id":                 user.DID,
+		"created_at":         user.CreatedAt.Unix(),
+		"linked_accounts":    accounts,
+		"has_accepted_terms": user.HasAcceptedTerms,
+		"is_guest":           user.IsGuest,
+	})
+}
+
+func writePrivyError(w http.ResponseWriter, status int, message string) {
+	writePrivyJSON(w, status, map[string]string{"error": message})
+}
+
+func writePrivyJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+const defaultPrivyAPIURL = "https://auth.privy.io"
+
+var (
+	ErrPrivyNotConfigured = errors.New("privy verification is not configured")
+	ErrPrivyUserNotFound  = errors.New("privy user not found")
+)
+
+// LinkedAccountMismatchError lists the linked accounts a client claimed that
+// Privy doesn't have on the user.
+type LinkedAccountMismatchError struct {
+	Accounts []string
+}
+
+func (e *LinkedAccountMismatchError) Error() string {
+	return "linked accounts not on the privy user: " + strings.Join(e.Accounts, ", ")
+}
+
+// PrivyService reads users from Privy's server API, authenticated with the
+// app secret, so what clients say about their Privy account can be checked.
+type PrivyService struct {
+	apiURL    string
+	appID     string
+	appSecret string
+}
+
+// NewPrivyService reads PRIVY_APP_ID and PRIVY_APP_SECRET. PRIVY_API_URL
+// replaces Privy's API server, for staging.
+func NewPrivyService() *PrivyService {
+	apiURL := strings.TrimSuffix(os.Getenv("PRIVY_API_URL"), "/")
+	if apiURL == "" {
+		apiURL = defaultPrivyAPIURL
+	}
+	return NewPrivyServiceWithURL(apiURL, os.Getenv("PRIVY_APP_ID"), os.Getenv("PRIVY_APP_SECRET"))
+}
+
+func NewPrivyServiceWithURL(apiURL string, appID string, appSecret string) *PrivyService {
+	return &PrivyService{apiURL: strings.TrimSuffix(apiURL, "/"), appID: appID, appSecret: appSecret}
+}
+
+// privyAPIUser is a user as Privy's server API returns it.
+type privyAPIUser struct {
+	ID               string                `json:"id"`
+	CreatedAt        int64                 `json:"created_at"`
+	LinkedAccounts   []types.LinkedAccount `json:"linked_accounts"`
+	HasAcceptedTerms bool                  `json:"has_accepted_terms"`
+	IsGuest          bool                  `json:"is_guest"`
+}
+
+// GetUser fetches the user with the DID from Privy. It returns
+// ErrPrivyUserNotFound when Privy doesn't know the DID.
+func (s *PrivyService) GetUser(ctx context.Context, did string) (*types.PrivyUser, error) {
+	if s.appID == "" || s.appSecret == "" {
+		return nil, ErrPrivyNotConfigured
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.apiURL+"/api/v1/users/"+url.PathEscape(did), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating privy request: %w", err)
+	}
+	req.SetBasicAuth(s.appID, s.appSecret)
+	req.Header.Set("privy-app-id", s.appID)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := privyHTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching privy user: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading privy response: %w", err)
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrPrivyUserNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("privy returned status %d: %s", res.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var user privyAPIUser
+	if err := json.Unmarshal(body, &user); err != nil {
+		return nil, fmt.Errorf("error decoding privy user: %w", err)
+	}
+	if user.ID != did {
+		return nil, fmt.Errorf("privy returned user %q for %q", user.ID, did)
+	}
+	return &types.PrivyUser{
+		DID:              user.ID,
+		CreatedAt:        time.Unix(user.CreatedAt, 0).UTC(),
+		LinkedAccounts:   user.LinkedAccounts,
+		HasAcceptedTerms: user.HasAcceptedTerms,
+		IsGuest:          user.IsGuest,
+	}, nil
+}
+
+// VerifyLinkedAccounts fetches the Privy user and checks that every linked
+// account the client claimed is on it. It returns the user as Privy has it,
+// whose linked accounts replace the claimed ones, or a
+// *LinkedAccountMismatchError.
+func (s *PrivyService) VerifyLinkedAccounts(ctx context.Context, did string, claimed []types.LinkedAccount) (*types.PrivyUser, error) {
+	user, err := s.GetUser(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if err := ReconcileLinkedAccounts(claimed, user.LinkedAccounts); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ReconcileLinkedAccounts returns a *LinkedAccountMismatchError naming the
+// claimed accounts that aren't among the verified ones. Accounts are matched
+// by type and identity, the rest of what clients send about them is ignored.
+func ReconcileLinkedAccounts(claimed []types.LinkedAccount, verified []types.LinkedAccount) error {
+	known := make(map[string]bool, len(verified))
+	for _, account := range verified {
+		known[linkedAccountKey(account)] = true
+	}
+
+	var missing []string
+	for _, account := range claimed {
+		if key := linkedAccountKey(account); !known[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &LinkedAccountMismatchError{Accounts: missing}
+	}
+	return nil
+}
+
+// linkedAccountKey identifies an account: Farcaster accounts by FID, wallets
+// and emails by address, the rest by username.
+func linkedAccountKey(account types.LinkedAccount) string {
+	switch {
+	case account.FID != 0:
+		return account.Type + ":" + strconv.Itoa(account.FID)
+	case account.Address != "":
+		return account.Type + ":" + strings.ToLower(account.Address)
+	default:
+		return account.Type + ":" + strings.ToLower(account.Username)
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+const privyTestDID = "did:privy:cfbsvtqo2c22202mo08ttfn5"
+
+// privyFixture is a user as Privy's documentation shows GET /api/v1/users/{did}
+// returning it.
+func privyFixture(t *testing.T) []byte {
+	t.Helper()
+	payload, err := os.ReadFile("testdata/privy_user.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// privyFixtureServer serves the fixture for every user.
+func privyFixtureServer(t *testing.T) *httptest.Server {
+	payload := privyFixture(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(payload)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPrivyServiceDecodesPrivysPayload(t *testing.T) {
+	server := privyFixtureServer(t)
+	user, err := NewPrivyServiceWithURL(server.URL, "app", "secret").GetUser(context.Background(), privyTestDID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.DID != privyTestDID || user.CreatedAt.Unix() != 1674788927 || !user.HasAcceptedTerms || user.IsGuest {
+		t.Errorf("user = %+v, want the fixture's", user)
+	}
+	if len(user.LinkedAccounts) != 3 {
+		t.Fatalf("decoded %d linked accounts, want 3", len(user.LinkedAccounts))
+	}
+	farcaster := user.LinkedAccounts[2]
+	if farcaster.Type != "farcaster" || farcaster.FID != 18350 || farcaster.Username != "jpfraneto" || farcaster.OwnerAddress == "" {
+		t.Errorf("farcaster account = %+v, want the fixture's", farcaster)
+	}
+	if wallet := user.LinkedAccounts[1]; wallet.ChainType != "ethereum" || wallet.Address == "" {
+		t.Errorf("wallet account = %+v, want the fixture's", wallet)
+	}
+}
+
+// The fake must answer the way Privy does, or tests against it prove nothing:
+// every field it sends is in Privy's payload, with the same value.
+func TestFakePrivyServerMatchesPrivysPayload(t *testing.T) {
+	server := privyFixtureServer(t)
+	user, err := NewPrivyServiceWithURL(server.URL, "app", "secret").GetUser(context.Background(), privyTestDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakePrivyServer(t, "app", "secret")
+	fake.addUser(user)
+
+	req, err := http.NewRequest(http.MethodGet, fake.URL+"/api/v1/users/"+privyTestDID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("app", "secret")
+	req.Header.Set("privy-app-id", "app")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var faked, documented map[string]interface{}
+	if err := json.Unmarshal(body, &faked); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(privyFixture(t), &documented); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range faked {
+		if key == "linked_accounts" {
+			continue
+		}
+		if !reflect.DeepEqual(value, documented[key]) {
+			t.Errorf("%s = %v, Privy sends %v", key, value, documented[key])
+		}
+	}
+	fakedAccounts := faked["linked_accounts"].([]interface{})
+	documentedAccounts := documented["linked_accounts"].([]interface{})
+	if len(fakedAccounts) != len(documentedAccounts) {
+		t.Fatalf("fake sent %d linked accounts, Privy %d", len(fakedAccounts), len(documentedAccounts))
+	}
+	for i := range fakedAccounts {
+		documentedAccount := documentedAccounts[i].(map[string]interface{})
+		for key, value := range fakedAccounts[i].(map[string]interface{}) {
+			if !reflect.DeepEqual(value, documentedAccount[key]) {
+				t.Errorf("linked account %d %s = %v, Privy sends %v", i, key, value, documentedAccount[key])
+			}
+		}
+	}
+}
+
+func TestPrivyServiceAgainstFake(t *testing.T) {
+	fake := newFakePrivyServer(t, "app", "secret")
+	fake.addUser(&types.PrivyUser{
+		DID:            privyTestDID,
+		LinkedAccounts: []types.LinkedAccount{{Type: "farcaster", FID: 18350, Username: "jpfraneto"}},
+	})
+	ctx := context.Background()
+
+	user, err := fake.service().VerifyLinkedAccounts(ctx, privyTestDID, []types.LinkedAccount{{Type: "farcaster", FID: 18350}})
+	if err != nil {
+		t.Fatalf("VerifyLinkedAccounts: %v", err)
+	}
+	if user.LinkedAccounts[0].Username != "jpfraneto" {
+		t.Errorf("linked accounts = %+v, want Privy's", user.LinkedAccounts)
+	}
+
+	var mismatch *LinkedAccountMismatchError
+	_, err = fake.service().VerifyLinkedAccounts(ctx, privyTestDID, []types.LinkedAccount{{Type: "farcaster", FID: 1}})
+	if !errors.As(err, &mismatch) || len(mismatch.Accounts) != 1 || mismatch.Accounts[0] != "farcaster:1" {
+		t.Errorf("claiming another FID: %v, want a mismatch on farcaster:1", err)
+	}
+
+	if _, err := fake.service().GetUser(ctx, "did:privy:nobody"); !errors.Is(err, ErrPrivyUserNotFound) {
+		t.Errorf("unknown DID: %v, want ErrPrivyUserNotFound", err)
+	}
+	if _, err := NewPrivyServiceWithURL(fake.URL, "app", "wrong").GetUser(ctx, privyTestDID); err == nil || errors.Is(err, ErrPrivyUserNotFound) {
+		t.Errorf("wrong secret: %v, want Privy's 401", err)
+	}
+	if _, err := NewPrivyServiceWithURL(fake.URL, "", "").GetUser(ctx, privyTestDID); !errors.Is(err, ErrPrivyNotConfigured) {
+		t.Errorf("no credentials: %v, want ErrPrivyNotConfigured", err)
+	}
+}
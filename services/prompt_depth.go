@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// promptDepthLevel is what a user needs to reach a depth level.
+type promptDepthLevel struct {
+	Name        string
+	Sessions    int
+	Reflections int
+	// Added to the system prompt of the next-prompt generation
+	Guidance string
+}
+
+// promptDepthLevels go from the shallowest to the deepest. A writer moves on
+// once they wrote enough sessions and engaged with enough of Anky's
+// reflections, so sheer volume alone doesn't take them deeper.
+var promptDepthLevels = []promptDepthLevel{
+	{
+		Name: types.PromptDepthSurface,
+		Guidance: `Depth level: surface. The writer is new to this practice. Stay close to what they wrote about:
+their days, the people around them, what they notice. Keep the question gentle and easy to answer.`,
+	},
+	{
+		Name:        types.PromptDepthShadow,
+		Sessions:    7,
+		Reflections: 3,
+		Guidance: `Depth level: shadow. The writer has built a practice. Invite them towards what their writing circles
+around without naming: the resentment, the fear, the part of them they would rather not look at. Stay kind, never accusatory.`,
+	},
+	{
+		Name:        types.PromptDepthIntegration,
+		Sessions:    30,
+		Reflections: 12,
+		Guidance: `Depth level: integration. The writer has looked at their shadows for a while. Ask how what they found
+can live alongside gratitude: what it taught them, what it protects, how they would hold it with compassion.`,
+	},
+}
+
+type JourneyService struct {
+	store *storage.PostgresStore
+}
+
+func NewJourneyService(store *storage.PostgresStore) *JourneyService {
+	return &JourneyService{store: store}
+}
+
+// GetUserJourney returns the user's depth level and what is left to reach the next one.
+func (s *JourneyService) GetUserJourney(ctx context.Context, userID uuid.UUID) (*types.UserJourney, error) {
+	journey, err := s.store.GetUserJourneyCounts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := 0
+	for i, level := range promptDepthLevels {
+		if journey.SessionCount >= level.Sessions && journey.ReflectionCount >= level.Reflections {
+			current = i
+		}
+	}
+	journey.Level = promptDepthLevels[current].Name
+
+	if current+1 < len(promptDepthLevels) {
+		next := promptDepthLevels[current+1]
+		journey.NextLevel = next.Name
+		journey.SessionsToNextLevel = max(next.Sessions-journey.SessionCount, 0)
+		journey.ReflectionsToNextLevel = max(next.Reflections-journey.ReflectionCount, 0)
+	}
+	return journey, nil
+}
+
+// promptDepthGuidance returns the system prompt addition for a depth level,
+// falling back to the surface for unknown levels.
+func promptDepthGuidance(depth string) string {
+	for _, level := range promptDepthLevels {
+		if level.Name == depth {
+			return level.Guidance
+		}
+	}
+	return promptDepthLevels[0].Guidance
+}
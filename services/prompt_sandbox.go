@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+)
+
+// Sandbox modes map to the persona used in production when none is given.
+const (
+	SandboxModeReflection = "reflection"
+	SandboxModePrompt     = "prompt"
+)
+
+const defaultSandboxTemplate = "{{.Writing}}"
+
+// PromptSandboxRequest describes a persona/template run against a sample
+// writing session. Nothing about it is persisted.
+type PromptSandboxRequest struct {
+	Mode     string `json:"mode"`
+	Persona  string `json:"persona"`
+	Template string `json:"template"`
+	// Raw session string in the same format the clients submit
+	SessionLongString string `json:"session_long_string"`
+	// Plain writing, used when no session string is given
+	Writing string `json:"writing"`
+	Prompt  string `json:"prompt"`
+	// Model to try instead of the configured one
+	Model string `json:"model"`
+}
+
+type PromptSandboxResult struct {
+	Mode        string `json:"mode"`
+	Persona     string `json:"persona"`
+	UserMessage string `json:"user_message"`
+	Output      string `json:"output"`
+	DurationMs  int64  `json:"duration_ms"`
+
+	Model string        `json:"model"`
+	Usage LLMTokenUsage `json:"usage"`
+}
+
+// sandboxTemplateData is what a template can reference, e.g. {{.Prompt}}.
+type sandboxTemplateData struct {
+	Writing   string
+	Prompt    string
+	TimeSpent int
+	WordCount int
+}
+
+// RunPromptSandbox renders the template with the sample session and sends it
+// through the same LLM configuration production uses.
+func (s *AnkyService) RunPromptSandbox(req PromptSandboxRequest) (*PromptSandboxResult, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = SandboxModeReflection
+	}
+
+	persona := req.Persona
+	if strings.TrimSpace(persona) == "" {
+		switch mode {
+		case SandboxModeReflection:
+			persona = reflectionStoryPersona
+		case SandboxModePrompt:
+			persona = framesgivingPromptPersona
+		default:
+			return nil, fmt.Errorf("unknown sandbox mode: %s", mode)
+		}
+	}
+
+	data := sandboxTemplateData{Writing: req.Writing, Prompt: req.Prompt}
+	if req.SessionLongString != "" {
+		session, err := utils.ParseWritingSession(req.SessionLongString)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing writing session: %v", err)
+		}
+		data.Writing = session.RawContent
+		data.Prompt = session.Prompt
+		data.TimeSpent = session.TimeSpent
+	}
+	if strings.TrimSpace(data.Writing) == "" {
+		return nil, fmt.Errorf("a sample writing session is required")
+	}
+	data.WordCount = len(strings.Fields(data.Writing))
+
+	templateText := req.Template
+	if templateText == "" {
+		templateText = defaultSandboxTemplate
+	}
+	tmpl, err := template.New("sandbox").Option("missingkey=error").Parse(templateText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	var userMessage bytes.Buffer
+	if err := tmpl.Execute(&userMessage, data); err != nil {
+		return nil, fmt.Errorf("error rendering template: %v", err)
+	}
+
+	log.Printf("🧪 Running prompt sandbox in %s mode", mode)
+	start := time.Now()
+	completion, err := NewLLMService().Complete(context.Background(), types.ChatRequest{
+		Messages: []types.Message{
+			{Role: "system", Content: persona},
+			{Role: "user", Content: userMessage.String()},
+		},
+		Model: req.Model,
+	}, false)
+	if err != nil {
+		return nil, fmt.Errorf("error running sandbox prompt: %v", err)
+	}
+
+	return &PromptSandboxResult{
+		Mode:        mode,
+		Persona:     persona,
+		UserMessage: userMessage.String(),
+		Output:      strings.TrimSpace(completion.Content),
+		DurationMs:  time.Since(start).Milliseconds(),
+		Model:       completion.Model,
+		Usage:       completion.Usage,
+	}, nil
+}
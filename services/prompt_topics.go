@@ -0,0 +1,27 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// promptTopicGuidance turns the writer's topic preferences into instructions
+// for the language model, empty when they have none. Topics to avoid are
+// never raised by Anky, but the writer is still met where they are when they
+// bring one up themselves.
+func promptTopicGuidance(topics *types.PromptTopics) string {
+	if topics == nil || (len(topics.Explore) == 0 && len(topics.Avoid) == 0) {
+		return ""
+	}
+
+	var guidance strings.Builder
+	guidance.WriteString("Topic preferences of the writer, treat them as constraints:")
+	if len(topics.Explore) > 0 {
+		guidance.WriteString("\n- They want to explore: " + strings.Join(topics.Explore, ", ") + ". Lean towards these whenever their writing leaves room for it.")
+	}
+	if len(topics.Avoid) > 0 {
+		guidance.WriteString("\n- They asked not to be led towards: " + strings.Join(topics.Avoid, ", ") + ". Never bring these up yourself. If their writing touches on one, acknowledge it gently without inviting them further into it.")
+	}
+	return guidance.String()
+}
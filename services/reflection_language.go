@@ -0,0 +1,37 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+)
+
+// matchWritingLanguage checks that the reflection is in the language the
+// session was written in. When it isn't, the reflection is asked for once
+// more with an explicit instruction, and that second answer is kept whatever
+// its language, so a wrong guess of the detector costs one LLM call at most.
+// Reflections are kept as they are when either language can't be told.
+func (s *AnkyService) matchWritingLanguage(llmService *LLMService, request types.ChatRequest, writing string, reflection string) string {
+	writingLanguage := utils.DetectLanguage(writing)
+	reflectionLanguage := utils.DetectLanguage(reflection)
+	if writingLanguage == "" || reflectionLanguage == "" || writingLanguage == reflectionLanguage {
+		return reflection
+	}
+	log.Printf("🌐 Reflection came back in %s for a session written in %s, asking again", utils.LanguageName(reflectionLanguage), utils.LanguageName(writingLanguage))
+
+	request.Messages = append(append([]types.Message{}, request.Messages...), types.Message{
+		Role:    "user",
+		Content: fmt.Sprintf("The writing is in %s. Write the whole reflection in %s, do not translate the writing into any other language.", utils.LanguageName(writingLanguage), utils.LanguageName(writingLanguage)),
+	})
+	regenerated, err := s.processChatRequest(llmService, request)
+	if err != nil || regenerated == "" {
+		log.Printf("⚠️ Could not regenerate the reflection in %s, keeping the first one: %v", utils.LanguageName(writingLanguage), err)
+		return reflection
+	}
+	if language := utils.DetectLanguage(regenerated); language != "" && language != writingLanguage {
+		log.Printf("⚠️ Regenerated reflection is still in %s, keeping it anyway", utils.LanguageName(language))
+	}
+	return regenerated
+}
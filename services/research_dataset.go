@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+// Bumped when the shape of types.ResearchDatasetStats changes
+const researchDatasetVersion = 1
+
+var ErrTooFewContributors = errors.New("too few contributors to publish an anonymous dataset")
+
+// ResearchDatasetService publishes, every month, the anonymized statistics
+// of the sessions written by the users who opted in to the research dataset.
+// Months with fewer than DATASET_MIN_CONTRIBUTORS contributors aren't
+// published, their numbers could point at someone.
+type ResearchDatasetService struct {
+	store           *storage.PostgresStore
+	minContributors int
+}
+
+func NewResearchDatasetService(store *storage.PostgresStore) *ResearchDatasetService {
+	s := &ResearchDatasetService{store: store, minContributors: 10}
+	if min, err := strconv.Atoi(os.Getenv("DATASET_MIN_CONTRIBUTORS")); err == nil && min > 0 {
+		s.minContributors = min
+	}
+	return s
+}
+
+// StartDatasetJob blocks, publishing last month's dataset on the first of
+// every month (UTC). It catches up on last month straight away if it isn't
+// published yet.
+func (s *ResearchDatasetService) StartDatasetJob(ctx context.Context) {
+	now := s.store.Clock().Now().UTC()
+	s.publishLogged(ctx, monthStart(now).AddDate(0, -1, 0))
+
+	for {
+		now := s.store.Clock().Now().UTC()
+		next := monthStart(now).AddDate(0, 1, 0)
+		timer := time.NewTimer(next.Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.publishLogged(ctx, next.AddDate(0, -1, 0))
+		}
+	}
+}
+
+func (s *ResearchDatasetService) publishLogged(ctx context.Context, month time.Time) {
+	if _, err := s.PublishMonth(ctx, month); errors.Is(err, ErrTooFewContributors) {
+		log.Printf("📊 Research dataset of %s not published: %v", month.Format("2006-01"), err)
+	} else if err != nil {
+		log.Printf("❌ Error publishing research dataset of %s: %v", month.Format("2006-01"), err)
+	}
+}
+
+// PublishMonth aggregates the month starting at month, uploads the dataset
+// to IPFS and records its CID. A month already published is left alone and
+// nil is returned for it.
+func (s *ResearchDatasetService) PublishMonth(ctx context.Context, month time.Time) (*types.ResearchDataset, error) {
+	from := monthStart(month)
+	to := from.AddDate(0, 1, 0)
+	published, err := s.store.ResearchDatasetExists(ctx, from)
+	if err != nil || published {
+		return nil, err
+	}
+
+	stats, err := s.store.GetResearchDatasetStats(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Contributors < s.minContributors {
+		return nil, fmt.Errorf("%w: %d of %d", ErrTooFewContributors, stats.Contributors, s.minContributors)
+	}
+	stats.Version = researchDatasetVersion
+	stats.PeriodStart = from.Format("2006-01-02")
+	stats.PeriodEnd = to.Format("2006-01-02")
+
+	pinata, err := NewPinataService(s.store)
+	if err != nil {
+		return nil, err
+	}
+	ipfsHash, err := pinata.UploadJSONMetadata(stats)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading research dataset: %w", err)
+	}
+
+	dataset := &types.ResearchDataset{
+		PeriodStart:  from,
+		PeriodEnd:    to,
+		IPFSHash:     ipfsHash,
+		Contributors: stats.Contributors,
+		Sessions:     stats.Sessions,
+	}
+	if _, err := s.store.CreateResearchDataset(ctx, dataset); err != nil {
+		return nil, err
+	}
+	log.Printf("📊 Research dataset of %s published as %s: %d sessions of %d contributors",
+		from.Format("2006-01"), ipfsHash, stats.Sessions, stats.Contributors)
+	return dataset, nil
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/utils"
+)
+
+// Upstreams the minting pipeline calls over HTTP
+const (
+	UpstreamNeynar         = "neynar"
+	UpstreamPinata         = "pinata"
+	UpstreamImageGenerator = "image_generator"
+	UpstreamHub            = "farcaster_hub"
+	UpstreamPrivy          = "privy"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Every caller of an upstream shares its client, so the breaker trips on the
+// failures of the whole instance and not only of one service value.
+var (
+	neynarHTTP = NewResilientClient(UpstreamNeynar, resilientConfigFromEnv(UpstreamNeynar, ResilientConfig{
+		Timeout:          15 * time.Second,
+		MaxAttempts:      3,
+		BaseBackoff:      500 * time.Millisecond,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}))
+	// Pinata uploads retry whole operations themselves, so requests are only
+	// tried once here
+	pinataHTTP = NewResilientClient(UpstreamPinata, resilientConfigFromEnv(UpstreamPinata, ResilientConfig{
+		Timeout:          2 * time.Minute,
+		MaxAttempts:      1,
+		BaseBackoff:      time.Second,
+		FailureThreshold: 5,
+		Cooldown:         time.Minute,
+	}))
+	// Shared by the image backends, DALL·E answers with the image itself
+	imageGeneratorHTTP = NewResilientClient(UpstreamImageGenerator, resilientConfigFromEnv(UpstreamImageGenerator, ResilientConfig{
+		Timeout:          90 * time.Second,
+		MaxAttempts:      3,
+		BaseBackoff:      time.Second,
+		FailureThreshold: 5,
+		Cooldown:         time.Minute,
+	}))
+	hubHTTP = NewResilientClient(UpstreamHub, resilientConfigFromEnv(UpstreamHub, ResilientConfig{
+		Timeout:          15 * time.Second,
+		MaxAttempts:      3,
+		BaseBackoff:      500 * time.Millisecond,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}))
+	privyHTTP = NewResilientClient(UpstreamPrivy, resilientConfigFromEnv(UpstreamPrivy, ResilientConfig{
+		Timeout:          10 * time.Second,
+		MaxAttempts:      3,
+		BaseBackoff:      500 * time.Millisecond,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}))
+)
+
+type ResilientConfig struct {
+	// Timeout of a single attempt, response body included
+	Timeout     time.Duration
+	MaxAttempts int
+	// Wait before the second attempt, doubled after every further failure
+	BaseBackoff time.Duration
+	// Consecutive failed attempts that open the breaker
+	FailureThreshold int
+	// How long an open breaker rejects calls before letting one through
+	Cooldown time.Duration
+}
+
+// resilientConfigFromEnv overrides the defaults of an upstream with
+// <UPSTREAM>_HTTP_TIMEOUT_SECONDS, <UPSTREAM>_HTTP_MAX_ATTEMPTS,
+// <UPSTREAM>_HTTP_BREAKER_THRESHOLD and <UPSTREAM>_HTTP_BREAKER_COOLDOWN_SECONDS.
+func resilientConfigFromEnv(upstream string, config ResilientConfig) ResilientConfig {
+	prefix := strings.ToUpper(upstream) + "_HTTP_"
+	envInt := func(key string) (int, bool) {
+		value := os.Getenv(prefix + key)
+		if value == "" {
+			return 0, false
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			log.Printf("⚠️ Ignoring invalid %s%s: %q", prefix, key, value)
+			return 0, false
+		}
+		return n, true
+	}
+
+	if seconds, ok := envInt("TIMEOUT_SECONDS"); ok {
+		config.Timeout = time.Duration(seconds) * time.Second
+	}
+	if attempts, ok := envInt("MAX_ATTEMPTS"); ok {
+		config.MaxAttempts = attempts
+	}
+	if threshold, ok := envInt("BREAKER_THRESHOLD"); ok {
+		config.FailureThreshold = threshold
+	}
+	if seconds, ok := envInt("BREAKER_COOLDOWN_SECONDS"); ok {
+		config.Cooldown = time.Duration(seconds) * time.Second
+	}
+	return config
+}
+
+// ResilientClient sends requests to one upstream with a timeout per attempt,
+// exponential backoff between attempts and a circuit breaker, so a slow or
+// failing dependency makes callers fail fast instead of piling up.
+type ResilientClient struct {
+	upstream string
+	config   ResilientConfig
+	client   *http.Client
+	breaker  *circuitBreaker
+}
+
+func NewResilientClient(upstream string, config ResilientConfig) *ResilientClient {
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	return &ResilientClient{
+		upstream: upstream,
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		breaker:  &circuitBreaker{upstream: upstream, threshold: config.FailureThreshold, cooldown: config.Cooldown, clock: utils.SystemClock},
+	}
+}
+
+// Do sends req, retrying network errors, 429s and 5xx responses of
+// idempotent requests. Other requests, and the ones whose body can't be
+// replayed, are sent once. The last response is returned as is when every
+// attempt got one, like http.Client.Do would.
+func (c *ResilientClient) Do(req *http.Request) (*http.Response, error) {
+	attempts := c.config.MaxAttempts
+	if !idempotent(req) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		attempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		trial, err := c.breaker.allow()
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := c.client.Do(attemptReq)
+		if err != nil && req.Context().Err() != nil {
+			// The caller gave up, that says nothing about the upstream. A
+			// trial cut short lets the next call try again.
+			if trial {
+				c.breaker.abandonTrial()
+			}
+			return nil, err
+		}
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			c.breaker.record(true)
+			return resp, nil
+		}
+		c.breaker.record(false)
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			if attempt == attempts {
+				return resp, nil
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		if attempt == attempts {
+			break
+		}
+
+		backoff := c.config.BaseBackoff * time.Duration(1<<uint(attempt-1))
+		log.Printf("⚠️ %s %s %s attempt %d/%d failed (%v), retrying in %s", c.upstream, req.Method, req.URL.Path, attempt, attempts, lastErr, backoff)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+	}
+	return nil, fmt.Errorf("%s request failed after %d attempts: %w", c.upstream, attempts, lastErr)
+}
+
+// CircuitOpen reports whether calls to the upstream are currently rejected.
+func (c *ResilientClient) CircuitOpen() bool {
+	return c.breaker.open()
+}
+
+// ConsecutiveFailures returns how many attempts in a row failed since the
+// upstream last answered.
+func (c *ResilientClient) ConsecutiveFailures() int {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	return c.breaker.failures
+}
+
+// idempotent reports whether sending req twice does no more than sending it
+// once: its method is, or it carries an idempotency key like net/http
+// requires to replay it.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// circuitBreaker opens after threshold consecutive failures and rejects calls
+// until the cooldown is over. Then a single trial call goes through: its
+// success closes the breaker, its failure opens it for another cooldown.
+type circuitBreaker struct {
+	upstream  string
+	threshold int
+	cooldown  time.Duration
+	clock     utils.Clock
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trialSent bool
+}
+
+// allow rejects calls while the breaker is open. trial is true for the one
+// call let through after the cooldown.
+func (b *circuitBreaker) allow() (trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold || b.threshold <= 0 {
+		return false, nil
+	}
+	if b.clock.Now().Before(b.openUntil) || b.trialSent {
+		return false, fmt.Errorf("%s: %w", b.upstream, ErrCircuitOpen)
+	}
+	b.trialSent = true
+	return true, nil
+}
+
+// abandonTrial forgets a trial call that ended without a verdict on the
+// upstream, so the next call is let through as the trial.
+func (b *circuitBreaker) abandonTrial() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialSent = false
+}
+
+func (b *circuitBreaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold || b.threshold <= 0 {
+		return false
+	}
+	return b.clock.Now().Before(b.openUntil) || b.trialSent
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		if b.failures >= b.threshold && b.threshold > 0 {
+			log.Printf("✅ %s circuit breaker closed", b.upstream)
+		}
+		b.failures = 0
+		b.trialSent = false
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold && b.threshold > 0 {
+		if b.failures == b.threshold {
+			log.Printf("🔌 %s circuit breaker opened after %d consecutive failures", b.upstream, b.failures)
+		}
+		b.openUntil = b.clock.Now().Add(b.cooldown)
+		b.trialSent = false
+	}
+}
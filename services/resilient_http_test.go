@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/utils"
+)
+
+func TestResilientClientOnlyRetriesIdempotentRequests(t *testing.T) {
+	upstream := newFakeUpstream(t, func(f *fakeUpstream, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	client := newTestResilientClient("test", 3)
+
+	send := func(method string, idempotencyKey string) {
+		t.Helper()
+		upstream.fail(1)
+		req, err := http.NewRequest(method, upstream.URL+"/thing", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		resp.Body.Close()
+	}
+
+	send("GET", "")
+	if got := upstream.count("GET", "/thing"); got != 2 {
+		t.Errorf("GET attempts = %d, want 2", got)
+	}
+	send("POST", "")
+	if got := upstream.count("POST", "/thing"); got != 1 {
+		t.Errorf("POST attempts = %d, want 1", got)
+	}
+	send("POST", "key-1")
+	if got := upstream.count("POST", "/thing"); got != 3 {
+		t.Errorf("POST attempts with an idempotency key = %d, want 2", got-1)
+	}
+}
+
+func TestCancelledTrialLetsTheNextCallThrough(t *testing.T) {
+	block := make(chan struct{})
+	upstream := newFakeUpstream(t, func(f *fakeUpstream, w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-block
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	t.Cleanup(func() { close(block) })
+
+	clock := utils.NewFixedClock(time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC))
+	client := NewResilientClient("test", ResilientConfig{Timeout: 5 * time.Second, MaxAttempts: 1, FailureThreshold: 1, Cooldown: time.Minute})
+	client.breaker.clock = clock
+
+	get := func(ctx context.Context, path string) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", upstream.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	upstream.fail(1)
+	get(context.Background(), "/fast")
+	if !client.CircuitOpen() {
+		t.Fatal("breaker didn't open")
+	}
+	clock.Advance(2 * time.Minute)
+
+	// The trial call's caller gives up before the upstream answers
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := get(ctx, "/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("trial error = %v, want the deadline", err)
+	}
+	if err := get(context.Background(), "/fast"); err != nil {
+		t.Fatalf("call after a cancelled trial: %v", err)
+	}
+	if client.CircuitOpen() {
+		t.Error("breaker still open after a successful trial")
+	}
+}
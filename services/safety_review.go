@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/metrics"
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	// ErrAnkyHeldForReview stops the pipeline of an Anky waiting for a
+	// moderator, it goes on once they approve it.
+	ErrAnkyHeldForReview = errors.New("the anky is held for review")
+	// ErrAnkyRejected stops the pipeline of an Anky a moderator rejected.
+	ErrAnkyRejected = errors.New("the anky was rejected by a moderator")
+	// ErrAnkyParked stops the pipeline of an Anky waiting for a feature that
+	// is switched off, see ParkedAnkyService.
+	ErrAnkyParked = errors.New("the anky waits for a feature that is switched off")
+)
+
+var (
+	ankyReviewsRequested = metrics.NewCounterVec("anky_reviews_requested_total",
+		"Ankys held for review, by reason.", "reason")
+	ankyReviewLatency = metrics.NewHistogramVec("anky_review_latency_seconds",
+		"Time Ankys waited in the review queue until a moderator decided, by verdict.",
+		metrics.LongDurationBuckets, "verdict")
+)
+
+var moderationClient = &http.Client{Timeout: 30 * time.Second}
+
+// SafetyReviewService holds back the Ankys of writing that reads as a
+// crisis, or that moderation flags with its image, until a moderator
+// approves them. The crisis phrases are always looked for; moderation runs
+// when OPENAI_API_KEY is set. REVIEW_SLA_MINUTES is how long reviews should
+// wait at most, an hour by default.
+type SafetyReviewService struct {
+	store *storage.PostgresStore
+	sla   time.Duration
+}
+
+func NewSafetyReviewService(store *storage.PostgresStore) *SafetyReviewService {
+	sla := time.Hour
+	if minutes, err := strconv.Atoi(os.Getenv("REVIEW_SLA_MINUTES")); err == nil && minutes > 0 {
+		sla = time.Duration(minutes) * time.Minute
+	}
+	return &SafetyReviewService{store: store, sla: sla}
+}
+
+// safetyFlags is what the checks found, no reasons when nothing.
+type safetyFlags struct {
+	reasons []string
+	details []string
+}
+
+func (f *safetyFlags) add(reason string, detail string) {
+	found := false
+	for _, existing := range f.reasons {
+		found = found || existing == reason
+	}
+	if !found {
+		f.reasons = append(f.reasons, reason)
+	}
+	f.details = append(f.details, detail)
+}
+
+// Check looks for crisis phrases in the writing and, when moderation is
+// configured, has the writing, the reflection and the image moderated. A
+// moderation that fails is logged and leaves the crisis check alone.
+func (s *SafetyReviewService) Check(ctx context.Context, writing string, reflection string, imageURL string) *safetyFlags {
+	flags := &safetyFlags{}
+	if phrases := utils.DetectCrisis(writing); len(phrases) > 0 {
+		flags.add(types.ReviewReasonCrisis, "crisis phrases: "+strings.Join(phrases, ", "))
+	}
+	if err := CheckCapability(CapabilityModeration); err != nil {
+		return flags
+	}
+	if err := s.moderate(ctx, writing+"\n\n"+reflection, imageURL, flags); err != nil {
+		log.Printf("⚠️ Could not moderate the anky, only the crisis check applies: %v", err)
+	}
+	return flags
+}
+
+type moderationInput struct {
+	Type     string              `json:"type"`
+	Text     string              `json:"text,omitempty"`
+	ImageURL *moderationImageURL `json:"image_url,omitempty"`
+}
+
+type moderationImageURL struct {
+	URL string `json:"url"`
+}
+
+type moderationResponse struct {
+	Results []struct {
+		Flagged                   bool                `json:"flagged"`
+		Categories                map[string]bool     `json:"categories"`
+		CategoryAppliedInputTypes map[string][]string `json:"category_applied_input_types"`
+	} `json:"results"`
+}
+
+// moderate sends the text and image to OpenAI's moderation. Sexual images
+// are flagged as NSFW, self-harm as a crisis and any other category as
+// moderation.
+func (s *SafetyReviewService) moderate(ctx context.Context, text string, imageURL string, flags *safetyFlags) error {
+	input := []moderationInput{{Type: "text", Text: text}}
+	if imageURL != "" {
+		input = append(input, moderationInput{Type: "image_url", ImageURL: &moderationImageURL{URL: imageURL}})
+	}
+	request := map[string]interface{}{"model": "omni-moderation-latest", "input": input}
+	headers := map[string]string{"Authorization": "Bearer " + os.Getenv("OPENAI_API_KEY")}
+
+	var response moderationResponse
+	if err := postLLMJSON(ctx, moderationClient, "https://api.openai.com/v1/moderations", headers, request, &response); err != nil {
+		return err
+	}
+	for _, result := range response.Results {
+		if !result.Flagged {
+			continue
+		}
+		categories := make([]string, 0, len(result.Categories))
+		for category, flagged := range result.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+		sort.Strings(categories)
+		for _, category := range categories {
+			appliesTo := strings.Join(result.CategoryAppliedInputTypes[category], ", ")
+			detail := fmt.Sprintf("moderation flagged %s (%s)", category, appliesTo)
+			switch {
+			case strings.HasPrefix(category, "sexual") && strings.Contains(appliesTo, "image"):
+				flags.add(types.ReviewReasonNSFWImage, detail)
+			case strings.HasPrefix(category, "self-harm"):
+				flags.add(types.ReviewReasonCrisis, detail)
+			default:
+				flags.add(types.ReviewReasonModeration, detail)
+			}
+		}
+	}
+	return nil
+}
+
+// Queue returns a page of the reviews with the status, with how the pending
+// ones keep up with the SLA.
+func (s *SafetyReviewService) Queue(ctx context.Context, status string, limit int, offset int) (*types.AnkyReviewQueue, error) {
+	now := s.store.Clock().Now()
+	pending, breaching, oldest, err := s.store.CountPendingAnkyReviews(ctx, now.Add(-s.sla))
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := s.store.GetAnkyReviews(ctx, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	queue := &types.AnkyReviewQueue{
+		Pending:      pending,
+		SLASeconds:   int(s.sla.Seconds()),
+		BreachingSLA: breaching,
+		Reviews:      reviews,
+	}
+	if oldest != nil {
+		queue.OldestPendingSeconds = int(now.Sub(*oldest).Seconds())
+	}
+	return queue, nil
+}
+
+// Decide records the moderator's verdict and returns the Anky it was on.
+// Rejected Ankys stay rejected; approved ones are left for the caller to
+// resume, see AnkyService.ResumeReviewedAnky.
+func (s *SafetyReviewService) Decide(ctx context.Context, reviewID uuid.UUID, verdict string, reviewerID uuid.UUID, note string) (*types.AnkyReview, *types.Anky, error) {
+	review, err := s.store.DecideAnkyReview(ctx, reviewID, verdict, reviewerID, note)
+	if err != nil {
+		return nil, nil, err
+	}
+	if review.ReviewedAt != nil {
+		ankyReviewLatency.Observe(review.ReviewedAt.Sub(review.CreatedAt).Seconds(), verdict)
+	}
+	anky, err := s.store.GetAnkyByID(ctx, review.AnkyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ankyService := &AnkyService{store: s.store}
+	detail := fmt.Sprintf("by %s", reviewerID)
+	if note != "" {
+		detail += ": " + note
+	}
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "review_"+verdict, detail)
+	if verdict == types.ReviewRejected {
+		if err := ankyService.setAnkyStatus(ctx, anky, anky.WritingSessionID.String(), types.AnkyStatusRejected); err != nil {
+			return nil, nil, err
+		}
+	}
+	log.Printf("🛡️ Review %s of anky %s %s by %s", review.ID, anky.ID, verdict, reviewerID)
+	return review, anky, nil
+}
+
+// reviewStage holds the Anky for a moderator when the safety checks flag it,
+// before it is revealed onchain or cast. Ankys a moderator approved go on,
+// rejected ones stop, and so do the ones still waiting.
+func (s *AnkyService) reviewStage(ctx context.Context, run *ankyPipelineRun, params map[string]string) error {
+	anky := run.anky
+	review, err := s.store.GetLatestAnkyReview(ctx, anky.ID)
+	switch {
+	case err == nil && review.Status == types.ReviewApproved:
+		return nil
+	case err == nil && review.Status == types.ReviewRejected:
+		return ErrAnkyRejected
+	case err == nil:
+		return ErrAnkyHeldForReview
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+
+	flags := NewSafetyReviewService(s.store).Check(ctx, run.session.RawContent, anky.AnkyReflection, anky.ImageURL)
+	if len(flags.reasons) == 0 {
+		return nil
+	}
+	// Ankys that aren't stored can't wait in the queue
+	if anky.ID == uuid.Nil {
+		log.Printf("⚠️ Session %s was flagged for review (%s) but its anky isn't stored, stopping it", run.sessionID, strings.Join(flags.reasons, ", "))
+		return ErrAnkyRejected
+	}
+
+	review = &types.AnkyReview{AnkyID: anky.ID, Reasons: flags.reasons, Detail: strings.Join(flags.details, "; ")}
+	if err := s.store.CreateAnkyReview(ctx, review); err != nil {
+		return err
+	}
+	for _, reason := range flags.reasons {
+		ankyReviewsRequested.Inc(reason)
+	}
+	s.recordAnkyStatusEvent(ctx, anky.ID, "review_requested", strings.Join(flags.reasons, ", "))
+	log.Printf("🛡️ Anky %s held for review: %s", anky.ID, review.Detail)
+	if err := s.setAnkyStatus(ctx, anky, run.sessionID, types.AnkyStatusInReview); err != nil {
+		return err
+	}
+	return ErrAnkyHeldForReview
+}
+
+// ResumeReviewedAnky runs the stages after the review for an Anky a
+// moderator approved, from the long string of its writing session.
+func (s *AnkyService) ResumeReviewedAnky(ctx context.Context, anky *types.Anky) error {
+	writing, err := ReadRawWritingSession(anky.WritingSessionID, anky.UserID, anky.FID)
+	if err != nil {
+		return err
+	}
+	log.Printf("🛡️ Resuming the pipeline of approved anky %s", anky.ID)
+	return s.runAnkyPipelineFrom(ctx, anky, writing, anky.WritingSessionID.String(), anky.UserID.String(), types.PipelineStageReview)
+}
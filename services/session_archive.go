@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	sessionArchiveBatchSize = 200
+	// Sessions archived per run at most, the rest wait for the next run
+	sessionArchiveMaxPerRun = 5000
+)
+
+var (
+	ErrArchiveDisabled = errors.New("the session archive is not configured, set ARCHIVE_DIR")
+	errSessionChanged  = errors.New("writing changed while it was archived")
+)
+
+// SessionArchiveService moves the writing of sessions that ended more than
+// SESSION_ARCHIVE_AFTER_MONTHS ago (12 by default) out of Postgres, gzipped,
+// into the blob store under ARCHIVE_DIR. The rest of the session stays in
+// Postgres flagged as archived, and Rehydrate reads the writing back.
+type SessionArchiveService struct {
+	store       *storage.PostgresStore
+	blobs       BlobStore
+	afterMonths int
+}
+
+func NewSessionArchiveService(store *storage.PostgresStore) *SessionArchiveService {
+	s := &SessionArchiveService{store: store, afterMonths: 12}
+	if dir := os.Getenv("ARCHIVE_DIR"); dir != "" {
+		s.blobs = NewFileBlobStore(dir)
+	}
+	if months, err := strconv.Atoi(os.Getenv("SESSION_ARCHIVE_AFTER_MONTHS")); err == nil && months > 0 {
+		s.afterMonths = months
+	}
+	return s
+}
+
+// cutoff is the time before which ended sessions are archived.
+func (s *SessionArchiveService) cutoff() time.Time {
+	return s.store.Clock().Now().AddDate(0, -s.afterMonths, 0)
+}
+
+// StartArchivalJob blocks, archiving old sessions every interval. It returns
+// right away when ARCHIVE_DIR is not set.
+func (s *SessionArchiveService) StartArchivalJob(ctx context.Context, interval time.Duration) {
+	if s.blobs == nil {
+		log.Println("⚠️ ARCHIVE_DIR is not set, old writing sessions won't be archived")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.ArchiveOldSessions(ctx); err != nil {
+				log.Printf("❌ Error archiving writing sessions: %v", err)
+			}
+		}
+	}
+}
+
+// ArchiveOldSessions archives up to sessionArchiveMaxPerRun sessions, oldest
+// first. Sessions that fail stay in Postgres and are retried on the next run.
+func (s *SessionArchiveService) ArchiveOldSessions(ctx context.Context) (*types.SessionArchivalRun, error) {
+	if s.blobs == nil {
+		return nil, ErrArchiveDisabled
+	}
+
+	run := &types.SessionArchivalRun{EndedBefore: s.cutoff(), StartedAt: s.store.Clock().Now()}
+	defer func() {
+		run.FinishedAt = s.store.Clock().Now()
+		sessionArchivalRunDuration.Observe(run.FinishedAt.Sub(run.StartedAt).Seconds())
+	}()
+
+	for run.Archived < sessionArchiveMaxPerRun {
+		sessions, err := s.store.GetSessionsToArchive(ctx, run.EndedBefore, min(sessionArchiveBatchSize, sessionArchiveMaxPerRun-run.Archived))
+		if err != nil {
+			return run, err
+		}
+
+		archived := 0
+		for _, session := range sessions {
+			if err := ctx.Err(); err != nil {
+				return run, err
+			}
+			compressed, err := s.archiveSession(ctx, session)
+			switch {
+			case errors.Is(err, errSessionChanged):
+				sessionArchivals.Inc("changed")
+			case err != nil:
+				log.Printf("❌ Error archiving writing session %s: %v", session.ID, err)
+				sessionArchivals.Inc("error")
+				run.Failed++
+			default:
+				sessionArchivals.Inc("ok")
+				sessionArchiveBytes.Add(float64(len(session.Writing)), "raw")
+				sessionArchiveBytes.Add(float64(compressed), "compressed")
+				run.RawBytes += int64(len(session.Writing))
+				run.CompressedBytes += int64(compressed)
+				run.Archived++
+				archived++
+			}
+		}
+		// A batch without progress would be selected again as is
+		if len(sessions) < sessionArchiveBatchSize || archived == 0 {
+			break
+		}
+	}
+
+	if run.Archived > 0 || run.Failed > 0 {
+		log.Printf("🧊 Archived %d writing sessions (%d bytes, %d compressed), %d failed", run.Archived, run.RawBytes, run.CompressedBytes, run.Failed)
+	}
+	return run, nil
+}
+
+// archiveSession stores the gzipped writing of the session, then empties it
+// in Postgres. It returns the compressed size.
+func (s *SessionArchiveService) archiveSession(ctx context.Context, session *types.WritingSession) (int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(session.Writing)); err != nil {
+		return 0, fmt.Errorf("error compressing writing: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("error compressing writing: %w", err)
+	}
+
+	key := sessionArchiveKey(session)
+	if err := s.blobs.Put(ctx, key, buf.Bytes()); err != nil {
+		return 0, fmt.Errorf("error storing archive: %w", err)
+	}
+
+	marked, err := s.store.MarkWritingSessionArchived(ctx, session.ID, key, session.Writing)
+	if err != nil {
+		return 0, err
+	}
+	if !marked {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("⚠️ Could not delete the stale archive %s: %v", key, err)
+		}
+		return 0, errSessionChanged
+	}
+	return buf.Len(), nil
+}
+
+// sessionArchiveKey groups archives by the month the session ended in.
+func sessionArchiveKey(session *types.WritingSession) string {
+	month := "unknown"
+	if session.EndingTimestamp != nil {
+		month = session.EndingTimestamp.UTC().Format("2006-01")
+	}
+	return fmt.Sprintf("writing-sessions/%s/%s.txt.gz", month, session.ID)
+}
+
+// Rehydrate fills in the writing of an archived session from the archive, and
+// does nothing for sessions that aren't archived.
+func (s *SessionArchiveService) Rehydrate(ctx context.Context, session *types.WritingSession) (err error) {
+	if !session.Archived {
+		return nil
+	}
+	defer func() { sessionRehydrations.Inc(outcomeLabel(err)) }()
+
+	if s.blobs == nil {
+		return ErrArchiveDisabled
+	}
+	if session.ArchiveKey == nil {
+		return fmt.Errorf("archived writing session %s has no archive key", session.ID)
+	}
+
+	data, err := s.blobs.Get(ctx, *session.ArchiveKey)
+	if err != nil {
+		return fmt.Errorf("error reading archive of writing session %s: %w", session.ID, err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error decompressing archive of writing session %s: %w", session.ID, err)
+	}
+	writing, err := io.ReadAll(gz)
+	if err != nil {
+		return fmt.Errorf("error decompressing archive of writing session %s: %w", session.ID, err)
+	}
+	if session.ArchiveChecksum != nil && storage.WritingChecksum(string(writing)) != *session.ArchiveChecksum {
+		return fmt.Errorf("archive of writing session %s doesn't match its checksum", session.ID)
+	}
+
+	session.Writing = string(writing)
+	return nil
+}
+
+// DeleteArchives removes archived writing, for writers who delete their
+// account. Failures are logged, not returned.
+func (s *SessionArchiveService) DeleteArchives(ctx context.Context, keys []string) {
+	if s.blobs == nil {
+		if len(keys) > 0 {
+			log.Printf("⚠️ ARCHIVE_DIR is not set, %d archived sessions were not deleted", len(keys))
+		}
+		return
+	}
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("❌ Error deleting archive %s: %v", key, err)
+		}
+	}
+}
+
+func (s *SessionArchiveService) Stats(ctx context.Context) (*types.SessionArchiveStats, error) {
+	return s.store.GetSessionArchiveStats(ctx, s.cutoff())
+}
+
+func SessionArchivalIntervalFromEnv() time.Duration {
+	if value := os.Getenv("SESSION_ARCHIVAL_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 24 * time.Hour
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// How long a handoff code can be redeemed
+	SessionHandoffTTL = 10 * time.Minute
+	// Attempts at drawing a code that isn't live for another session
+	handoffCodeAttempts = 5
+)
+
+var (
+	ErrSessionEnded      = errors.New("this writing session already ended")
+	ErrHandoffNeedsFID   = errors.New("sessions that weren't started on this server need the fid they were set up for")
+	ErrHandoffCodesTaken = errors.New("could not draw a free handoff code, try again")
+)
+
+// SessionHandoffService moves a pending session to another device: the
+// device that set it up gets a six digit code, the one the writer wants to
+// write on redeems it for the session and its prompt.
+type SessionHandoffService struct {
+	store *storage.PostgresStore
+}
+
+func NewSessionHandoffService(store *storage.PostgresStore) *SessionHandoffService {
+	return &SessionHandoffService{store: store}
+}
+
+// CreateHandoff issues a code for the session, replacing any code it had.
+// Sessions started on this server hand off their prompt and user, as long as
+// they haven't ended; frames sessions, which only exist once submitted, hand
+// off the prompt of the FID they were set up for.
+func (s *SessionHandoffService) CreateHandoff(ctx context.Context, sessionID uuid.UUID, fid int) (*types.SessionHandoff, error) {
+	handoff := &types.SessionHandoff{SessionID: sessionID, FID: fid}
+
+	session, err := s.store.GetWritingSessionById(ctx, sessionID)
+	switch {
+	case err == nil:
+		if session.EndingTimestamp != nil {
+			return nil, ErrSessionEnded
+		}
+		// Anonymous sessions belong to the nil user, which isn't stored
+		if session.UserID != uuid.Nil {
+			handoff.UserID = &session.UserID
+		}
+		handoff.Prompt = session.Prompt
+	case errors.Is(err, pgx.ErrNoRows):
+		if fid <= 0 {
+			return nil, ErrHandoffNeedsFID
+		}
+		handoff.Prompt = types.DefaultWritingPrompt
+		prompt, err := NewCampaignService(s.store).GetPrompt(ctx, fid)
+		if err == nil {
+			handoff.Prompt = prompt.Prompt
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("error getting prompt: %w", err)
+		}
+	default:
+		return nil, err
+	}
+
+	for attempt := 0; attempt < handoffCodeAttempts; attempt++ {
+		code, err := newHandoffCode()
+		if err != nil {
+			return nil, err
+		}
+		handoff.Code = code
+		handoff.CreatedAt = s.store.Clock().Now().UTC()
+		handoff.ExpiresAt = handoff.CreatedAt.Add(SessionHandoffTTL)
+
+		err = s.store.CreateSessionHandoff(ctx, handoff)
+		if errors.Is(err, storage.ErrHandoffCodeTaken) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("📲 Handoff code issued for session %s, valid until %s", sessionID, handoff.ExpiresAt.Format(time.RFC3339))
+		return handoff, nil
+	}
+	return nil, ErrHandoffCodesTaken
+}
+
+// RedeemHandoff claims the session of the code. A code works once and only
+// until it expires; other codes get storage.ErrHandoffNotFound.
+func (s *SessionHandoffService) RedeemHandoff(ctx context.Context, code string) (*types.SessionHandoff, error) {
+	handoff, err := s.store.RedeemSessionHandoff(ctx, code, s.store.Clock().Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("📲 Session %s handed off to another device", handoff.SessionID)
+	return handoff, nil
+}
+
+// newHandoffCode draws a random six digit code, leading zeros included.
+func newHandoffCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", fmt.Errorf("error drawing handoff code: %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+)
+
+// Statuses of a component and of the whole service on the status page
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusDown        = "down"
+	StatusMajorOutage = "major_outage"
+)
+
+// Components shown on the status page besides the HTTP upstreams
+const (
+	ComponentLLM      = "llm"
+	ComponentDatabase = "database"
+)
+
+const (
+	// How long the status report is reused, so a busy status page doesn't
+	// turn into database load
+	statusReportTTL = 10 * time.Second
+	// LLM failures older than this no longer count against it
+	llmFailureWindow = 10 * time.Minute
+	// LLM requests failing in a row before it is reported down
+	llmDownThreshold = 5
+)
+
+type ComponentStatus struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Degradation is a part of the product that currently works worse than usual.
+type Degradation struct {
+	Component string `json:"component,omitempty"`
+	Feature   string `json:"feature,omitempty"`
+	Message   string `json:"message"`
+}
+
+// StatusReport is what the public status page renders.
+type StatusReport struct {
+	Status       string             `json:"status"`
+	Components   []ComponentStatus  `json:"components"`
+	Degradations []Degradation      `json:"degradations"`
+	Incidents    []*types.Incident  `json:"incidents"`
+	Queues       *types.QueueDepths `json:"queues,omitempty"`
+	Capabilities []CapabilityStatus `json:"capabilities"`
+	GeneratedAt  time.Time          `json:"generated_at"`
+}
+
+type StatusService struct {
+	store *storage.PostgresStore
+
+	mu       sync.Mutex
+	cached   *StatusReport
+	cachedAt time.Time
+}
+
+func NewStatusService(store *storage.PostgresStore) *StatusService {
+	return &StatusService{store: store}
+}
+
+// Report summarizes the health of every upstream and of the database, the
+// features switched off, the unresolved incidents and the pipeline queues.
+// It never fails: what can't be read is reported as down.
+func (s *StatusService) Report(ctx context.Context) *StatusReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.store.Clock().Now()
+	if s.cached != nil && now.Sub(s.cachedAt) < statusReportTTL {
+		return s.cached
+	}
+
+	report := &StatusReport{
+		Components:   upstreamStatuses(now),
+		Degradations: []Degradation{},
+		Incidents:    []*types.Incident{},
+		Capabilities: Capabilities(),
+		GeneratedAt:  now,
+	}
+
+	database := ComponentStatus{Name: ComponentDatabase, Status: StatusOperational}
+	if err := s.store.Ping(ctx); err != nil {
+		log.Printf("⚠️ Status check could not reach the database: %v", err)
+		database.Status = StatusDown
+		database.Message = "the database is not responding"
+	} else {
+		if incidents, err := s.store.GetActiveIncidents(ctx); err != nil {
+			log.Printf("⚠️ Status check could not load incidents: %v", err)
+		} else {
+			report.Incidents = incidents
+		}
+		if queues, err := s.store.GetQueueDepths(ctx); err != nil {
+			log.Printf("⚠️ Status check could not count the queues: %v", err)
+		} else {
+			report.Queues = queues
+		}
+	}
+	report.Components = append(report.Components, database)
+
+	for _, component := range report.Components {
+		if component.Status != StatusOperational {
+			report.Degradations = append(report.Degradations, Degradation{Component: component.Name, Message: component.Message})
+		}
+	}
+	for _, feature := range disabledFeatures() {
+		report.Degradations = append(report.Degradations, Degradation{
+			Feature: feature,
+			Message: fmt.Sprintf("%s is temporarily disabled", featureNames[feature]),
+		})
+	}
+
+	for _, capability := range report.Capabilities {
+		if !capability.Available {
+			report.Degradations = append(report.Degradations, Degradation{
+				Component: capability.Name,
+				Message:   fmt.Sprintf("%s is not configured on this server", capability.Name),
+			})
+		}
+	}
+
+	report.Status = overallStatus(report)
+	s.cached = report
+	s.cachedAt = now
+	return report
+}
+
+// upstreamStatuses reads the circuit breakers of the HTTP upstreams and the
+// recent failures of the LLM.
+func upstreamStatuses(now time.Time) []ComponentStatus {
+	upstreams := []*ResilientClient{neynarHTTP, pinataHTTP, imageGeneratorHTTP, hubHTTP, privyHTTP}
+	statuses := make([]ComponentStatus, 0, len(upstreams)+1)
+	for _, upstream := range upstreams {
+		status := ComponentStatus{Name: upstream.upstream, Status: StatusOperational}
+		switch failures := upstream.ConsecutiveFailures(); {
+		case upstream.CircuitOpen():
+			status.Status = StatusDown
+			status.Message = fmt.Sprintf("%s is failing, calls are paused", upstream.upstream)
+		case failures > 0:
+			status.Status = StatusDegraded
+			status.Message = fmt.Sprintf("the last %d calls to %s failed", failures, upstream.upstream)
+		}
+		statuses = append(statuses, status)
+	}
+
+	llm := ComponentStatus{Name: ComponentLLM, Status: StatusOperational}
+	if failures, lastFailureAt := LLMConsecutiveFailures(); failures > 0 && now.Sub(lastFailureAt) < llmFailureWindow {
+		llm.Status = StatusDegraded
+		if failures >= llmDownThreshold {
+			llm.Status = StatusDown
+		}
+		llm.Message = fmt.Sprintf("the last %d LLM requests failed", failures)
+	}
+	return append(statuses, llm)
+}
+
+func disabledFeatures() []string {
+	var disabled []string
+	for feature, off := range KillSwitches() {
+		if off {
+			disabled = append(disabled, feature)
+		}
+	}
+	sort.Strings(disabled)
+	return disabled
+}
+
+// overallStatus is a major outage when the database is down or an incident
+// is critical, degraded when anything else is off, and operational otherwise.
+func overallStatus(report *StatusReport) string {
+	for _, component := range report.Components {
+		if component.Name == ComponentDatabase && component.Status == StatusDown {
+			return StatusMajorOutage
+		}
+	}
+	for _, incident := range report.Incidents {
+		if incident.Severity == types.IncidentCritical {
+			return StatusMajorOutage
+		}
+	}
+	if len(report.Degradations) > 0 || len(report.Incidents) > 0 {
+		return StatusDegraded
+	}
+	return StatusOperational
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+)
+
+const storageRepairBatchSize = 50
+
+// StorageRepairService pins the images of Ankys that fell back to data URI
+// metadata because IPFS pinning failed, upgrading them to regular storage.
+type StorageRepairService struct {
+	store *storage.PostgresStore
+}
+
+func NewStorageRepairService(store *storage.PostgresStore) *StorageRepairService {
+	return &StorageRepairService{store: store}
+}
+
+// StartStorageRepairJob blocks, running a repair pass every interval.
+func (s *StorageRepairService) StartStorageRepairJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RepairDegradedAnkys(ctx)
+			s.RepairDegradedFramesMetadata()
+		}
+	}
+}
+
+// RepairDegradedAnkys retries pinning for degraded Ankys in the database.
+func (s *StorageRepairService) RepairDegradedAnkys(ctx context.Context) {
+	ankys, err := s.store.GetDegradedAnkys(ctx, storageRepairBatchSize)
+	if err != nil {
+		log.Printf("❌ Error getting degraded ankys: %v", err)
+		return
+	}
+	if len(ankys) == 0 {
+		return
+	}
+
+	pinataService, err := NewPinataService(s.store)
+	if err != nil {
+		log.Printf("❌ Error creating Pinata service: %v", err)
+		return
+	}
+	ankyService := &AnkyService{store: s.store}
+
+	log.Printf("🔧 Repairing storage of %d degraded ankys", len(ankys))
+	for _, anky := range ankys {
+		ipfsHash, err := pinataService.UploadImageFromURL(anky.ImageURL)
+		if err != nil {
+			log.Printf("⚠️ Anky %s is still degraded: %v", anky.ID, err)
+			continue
+		}
+
+		anky.ImageIPFSHash = ipfsHash
+		anky.StorageDegraded = false
+		anky.MetadataURI = ""
+		anky.LastUpdatedAt = s.store.Clock().Now().UTC()
+		if err := s.store.UpdateAnky(ctx, anky); err != nil {
+			log.Printf("❌ Error updating repaired anky %s: %v", anky.ID, err)
+			continue
+		}
+		ankyService.recordAnkyStatusEvent(ctx, anky.ID, "storage_repaired", ipfsHash)
+		log.Printf("✅ Anky %s pinned with hash %s", anky.ID, ipfsHash)
+	}
+}
+
+// RepairDegradedFramesMetadata retries pinning for framesgiving sessions whose
+// metadata file fell back to data URIs.
+func (s *StorageRepairService) RepairDegradedFramesMetadata() {
+	files, err := filepath.Glob(framesMetadataPath("*"))
+	if err != nil {
+		log.Printf("❌ Error listing frames metadata files: %v", err)
+		return
+	}
+
+	var pinataService *PinataService
+	for _, file := range files {
+		sessionID := strings.TrimSuffix(filepath.Base(file), ".txt")
+		metadata, err := ReadFramesAnkyMetadata(sessionID)
+		if err != nil || !metadata.Degraded() {
+			continue
+		}
+
+		if pinataService == nil {
+			if pinataService, err = NewPinataService(s.store); err != nil {
+				log.Printf("❌ Error creating Pinata service: %v", err)
+				return
+			}
+		}
+
+		ipfsHash, err := pinataService.UploadImageFromURL(metadata.ImageURL)
+		if err != nil {
+			log.Printf("⚠️ Frames session %s is still degraded: %v", sessionID, err)
+			continue
+		}
+
+		metadata.IPFSHash = ipfsHash
+		metadata.ImageURL = ""
+		metadata.MetadataURI = ""
+		if err := WriteFramesAnkyMetadata(sessionID, metadata); err != nil {
+			log.Printf("❌ Error writing repaired metadata for session %s: %v", sessionID, err)
+			continue
+		}
+		log.Printf("✅ Frames session %s pinned with hash %s", sessionID, ipfsHash)
+	}
+}
+
+// StorageRepairIntervalFromEnv reads STORAGE_REPAIR_INTERVAL_MINUTES, defaulting to an hour.
+func StorageRepairIntervalFromEnv() time.Duration {
+	if value := os.Getenv("STORAGE_REPAIR_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return time.Hour
+}
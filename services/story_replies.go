@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// Farcaster rejects casts with longer text
+	maxCastTextBytes = 320
+	// Longer stories are cut, the frame in the Anky's cast has all of it
+	maxStoryReplies = 6
+)
+
+// storyReplyEnabled reports whether Ankys' casts are followed by their story:
+// the story_reply parameter of the cast stage when set, CAST_STORY_REPLY
+// otherwise.
+func storyReplyEnabled(params map[string]string) bool {
+	if value, ok := params["story_reply"]; ok {
+		if enabled, err := strconv.ParseBool(value); err == nil {
+			return enabled
+		}
+	}
+	enabled, _ := strconv.ParseBool(os.Getenv("CAST_STORY_REPLY"))
+	return enabled
+}
+
+// replyWithStory posts the story under the Anky's cast, split into as many
+// casts as it takes, each replying to the one before so the thread reads in
+// order. The Anky is cast either way, so failures are only logged; it
+// returns the replies that made it.
+func replyWithStory(ctx context.Context, parent *types.Cast, signerUUID string, story string) []*types.Cast {
+	if parent == nil || parent.Hash == "" {
+		return nil
+	}
+	publisher := NewFarcasterPublisher()
+	replies := []*types.Cast{}
+	replyTo := parent.Hash
+	for _, text := range splitCastText(story, maxStoryReplies) {
+		reply, err := publisher.PublishCast(ctx, CastRequest{
+			SignerUUID:     signerUUID,
+			Text:           text,
+			ParentHash:     replyTo,
+			IdempotencyKey: "story-" + replyTo,
+		})
+		if err != nil {
+			log.Printf("⚠️ Error replying to cast %s with its story: %v", replyTo, err)
+			break
+		}
+		replies = append(replies, reply)
+		replyTo = reply.Hash
+	}
+	if len(replies) > 0 {
+		log.Printf("📖 Replied to cast %s with its story in %d casts", parent.Hash, len(replies))
+	}
+	return replies
+}
+
+// splitCastText splits text into at most maxParts casts, between words where
+// it can. Text that doesn't fit is cut with an ellipsis.
+func splitCastText(text string, maxParts int) []string {
+	const ellipsis = "…"
+	parts := []string{}
+	rest := strings.TrimSpace(text)
+	for rest != "" && len(parts) < maxParts {
+		if len(rest) <= maxCastTextBytes {
+			parts = append(parts, rest)
+			return parts
+		}
+		limit := maxCastTextBytes
+		if len(parts) == maxParts-1 {
+			limit -= len(ellipsis)
+		}
+		cut := strings.LastIndexAny(rest[:limit+1], " \n\t")
+		if cut <= 0 {
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(rest[cut]) {
+				cut--
+			}
+		}
+		part := strings.TrimSpace(rest[:cut])
+		rest = strings.TrimSpace(rest[cut:])
+		if len(parts) == maxParts-1 {
+			part += ellipsis
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
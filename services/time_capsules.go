@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+const (
+	// Furthest a time capsule can be sealed for
+	maxTimeCapsuleDelay    = 10 * 365 * 24 * time.Hour
+	timeCapsuleRevealBatch = 100
+)
+
+var (
+	ErrRevealInPast    = errors.New("reveal_at must be in the future")
+	ErrRevealTooFar    = errors.New("reveal_at can be at most ten years away")
+	ErrCastNeedsReveal = errors.New("cast_on_reveal needs a reveal_at")
+)
+
+// ValidateTimeCapsule checks the reveal a writer chose for their Anky.
+func ValidateTimeCapsule(now time.Time, revealAt *time.Time, castOnReveal bool) error {
+	if revealAt == nil {
+		if castOnReveal {
+			return ErrCastNeedsReveal
+		}
+		return nil
+	}
+	if !revealAt.After(now) {
+		return ErrRevealInPast
+	}
+	if revealAt.Sub(now) > maxTimeCapsuleDelay {
+		return ErrRevealTooFar
+	}
+	return nil
+}
+
+// TimeCapsuleService reveals the Ankys writers sealed until a date of their
+// choosing. Their pipeline ran when they were written; the reveal only makes
+// the reflection and image visible, casts them when the writer asked for it
+// and lets the writer's open sockets know.
+type TimeCapsuleService struct {
+	store *storage.PostgresStore
+}
+
+func NewTimeCapsuleService(store *storage.PostgresStore) *TimeCapsuleService {
+	return &TimeCapsuleService{store: store}
+}
+
+// StartRevealJob blocks, revealing the time capsules that are due every interval.
+func (s *TimeCapsuleService) StartRevealJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.RevealDueAnkys(ctx); err != nil {
+				log.Printf("❌ Error revealing time capsules: %v", err)
+			}
+		}
+	}
+}
+
+// RevealDueAnkys reveals the time capsules whose reveal time passed and
+// returns how many were revealed. Capsules whose pipeline hasn't finished
+// wait for it.
+func (s *TimeCapsuleService) RevealDueAnkys(ctx context.Context) (int, error) {
+	ankys, err := s.store.GetAnkysToReveal(ctx, s.store.Clock().Now().UTC(), timeCapsuleRevealBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	revealed := 0
+	for _, anky := range ankys {
+		if ctx.Err() != nil {
+			return revealed, ctx.Err()
+		}
+		ok, err := s.reveal(ctx, anky)
+		if err != nil {
+			log.Printf("❌ Error revealing anky %s: %v", anky.ID, err)
+			continue
+		}
+		if ok {
+			revealed++
+		}
+	}
+	if revealed > 0 {
+		log.Printf("⏳ Revealed %d time capsules", revealed)
+	}
+	return revealed, nil
+}
+
+// reveal marks the Anky revealed, casts it if the writer asked to and
+// publishes the reveal. It returns false when another pass revealed it first.
+func (s *TimeCapsuleService) reveal(ctx context.Context, anky *types.Anky) (bool, error) {
+	ankyService := &AnkyService{store: s.store}
+	sessionID := anky.WritingSessionID.String()
+
+	now := s.store.Clock().Now().UTC()
+	ok, err := s.store.MarkAnkyRevealed(ctx, anky.ID, now)
+	if err != nil || !ok {
+		return false, err
+	}
+	anky.RevealedAt = &now
+	anky.LastUpdatedAt = now
+	anky.Version++
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "revealed", fmt.Sprintf("sealed until %s", anky.RevealAt.UTC().Format(time.RFC3339)))
+	log.Printf("🎁 Anky %s revealed", anky.ID)
+
+	// Already revealed: a cast that fails leaves the Anky pending_to_cast
+	// like any other, instead of sealing it again. Ankys held for review are
+	// cast by their pipeline once approved.
+	if anky.CastOnReveal && anky.CastHash == "" && !anky.HeldForReview() {
+		if err := s.castRevealed(ctx, ankyService, anky); err != nil {
+			log.Printf("❌ Error casting revealed anky %s: %v", anky.ID, err)
+			ankyService.recordAnkyStatusEvent(ctx, anky.ID, "pending_to_cast", fmt.Sprintf("cast on reveal failed: %v", err))
+			if err := ankyService.setAnkyStatus(ctx, anky, sessionID, "pending_to_cast"); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	publishAnkyStatus(sessionID, "revealed", "")
+	return true, nil
+}
+
+// castRevealed casts the Anky from the writer's account, the cast the
+// pipeline held back when it was sealed.
+func (s *TimeCapsuleService) castRevealed(ctx context.Context, ankyService *AnkyService, anky *types.Anky) error {
+	if err := CheckFeature(FeatureCasting); err != nil {
+		return err
+	}
+	user, err := s.store.GetUserByID(ctx, anky.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %v", err)
+	}
+	if user.FarcasterUser == nil || user.FarcasterUser.SignerUUID == "" {
+		return fmt.Errorf("user %s has no Farcaster signer", anky.UserID)
+	}
+
+	images, err := s.store.GetAnkyImagesByAnkyIDs(ctx, []uuid.UUID{anky.ID})
+	if err != nil {
+		return fmt.Errorf("error getting anky images: %v", err)
+	}
+
+	sessionID := anky.WritingSessionID.String()
+	cast, err := NewFarcasterPublisher().PublishCast(ctx, CastRequest{
+		SignerUUID:     user.FarcasterUser.SignerUUID,
+		Text:           ankyCastText(sessionID, anky.Ticker, anky.TokenName),
+		ChannelID:      "anky",
+		IdempotencyKey: sessionID,
+		SessionID:      sessionID,
+		ImageURLs:      castImageURLs(anky.ImageIPFSHash, collectionImageURLs(images[anky.ID])),
+	})
+	if err != nil {
+		return err
+	}
+	if storyReplyEnabled(nil) {
+		replyWithStory(ctx, cast, user.FarcasterUser.SignerUUID, anky.AnkyReflection)
+	}
+
+	anky.CastHash = cast.Hash
+	if err := ankyService.setAnkyStatus(ctx, anky, sessionID, "completed"); err != nil {
+		return err
+	}
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "cast_on_reveal", cast.Hash)
+	log.Printf("📣 Revealed anky %s cast as %s", anky.ID, cast.Hash)
+	return nil
+}
+
+func TimeCapsuleRevealIntervalFromEnv() time.Duration {
+	if value := os.Getenv("TIME_CAPSULE_REVEAL_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 5 * time.Minute
+}
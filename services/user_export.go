@@ -0,0 +1,321 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// How long the download link of an export works
+	userExportTTL = 7 * 24 * time.Hour
+	// Pending exports older than this died with the server that built them
+	userExportStaleAfter = time.Hour
+	// Rows read per query while assembling an export
+	userExportPageSize     = 200
+	userExportCleanupBatch = 100
+)
+
+// UserExportService builds zips of everything a user wrote so they can take
+// it with them: their profile, writing sessions, Ankys, badges and newen
+// transactions as JSON, plus every session as plain text. Zips go to the
+// blob store under EXPORT_DIR (data/exports by default).
+type UserExportService struct {
+	store   *storage.PostgresStore
+	archive *SessionArchiveService
+	blobs   BlobStore
+}
+
+func NewUserExportService(store *storage.PostgresStore, archive *SessionArchiveService) *UserExportService {
+	dir := os.Getenv("EXPORT_DIR")
+	if dir == "" {
+		dir = "data/exports"
+	}
+	return &UserExportService{store: store, archive: archive, blobs: NewFileBlobStore(dir)}
+}
+
+// RequestExport returns the user's export that is being built or can be
+// downloaded. When there is none, it creates a pending one and returns true:
+// the caller builds it with BuildExport.
+func (s *UserExportService) RequestExport(ctx context.Context, userID uuid.UUID) (*types.UserExport, bool, error) {
+	latest, err := s.store.GetLatestUserExport(ctx, userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+	if latest != nil {
+		now := s.store.Clock().Now()
+		switch {
+		case latest.Status == types.UserExportPending && now.Sub(latest.CreatedAt) < userExportStaleAfter:
+			return latest, false, nil
+		case latest.Status == types.UserExportReady && latest.ExpiresAt != nil && latest.ExpiresAt.After(now):
+			return latest, false, nil
+		}
+	}
+
+	export := &types.UserExport{UserID: userID}
+	if err := s.store.CreateUserExport(ctx, export); err != nil {
+		return nil, false, err
+	}
+	log.Printf("📦 Export %s of user %s requested", export.ID, userID)
+	return export, true, nil
+}
+
+// BuildExport assembles the zip of the pending export and marks it ready, or
+// failed with the reason.
+func (s *UserExportService) BuildExport(ctx context.Context, export *types.UserExport) {
+	err := s.buildExport(ctx, export)
+	if err != nil {
+		log.Printf("❌ Export %s of user %s failed: %v", export.ID, export.UserID, err)
+		if err := s.store.FailUserExport(context.WithoutCancel(ctx), export.ID, err.Error()); err != nil {
+			log.Printf("❌ Error marking export %s failed: %v", export.ID, err)
+		}
+		return
+	}
+	log.Printf("📦 Export %s of user %s ready, %d bytes", export.ID, export.UserID, export.SizeBytes)
+}
+
+func (s *UserExportService) buildExport(ctx context.Context, export *types.UserExport) error {
+	user, err := s.store.GetUserByID(ctx, export.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+	sessions, err := s.exportSessions(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	ankys, err := s.exportAnkys(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	badges, err := s.store.GetUserBadges(ctx, export.UserID)
+	if err != nil {
+		return fmt.Errorf("error getting badges: %w", err)
+	}
+	transactions, err := s.exportNewenTransactions(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"user.json", user},
+		{"writing_sessions.json", sessions},
+		{"ankys.json", ankys},
+		{"badges.json", badges},
+		{"newen_transactions.json", transactions},
+	}
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding %s: %w", file.name, err)
+		}
+		if err := writeZipFile(archive, file.name, data); err != nil {
+			return err
+		}
+	}
+	for _, session := range sessions {
+		if err := writeZipFile(archive, sessionTextFileName(session), []byte(sessionText(session))); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("error closing export zip: %w", err)
+	}
+
+	export.BlobKey = fmt.Sprintf("%s/%s.zip", export.UserID, export.ID)
+	if err := s.blobs.Put(ctx, export.BlobKey, buf.Bytes()); err != nil {
+		return fmt.Errorf("error storing export zip: %w", err)
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("error generating download token: %w", err)
+	}
+	now := s.store.Clock().Now().UTC()
+	expiresAt := now.Add(userExportTTL)
+	export.DownloadToken = hex.EncodeToString(token)
+	export.SizeBytes = int64(buf.Len())
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+	return s.store.CompleteUserExport(ctx, export)
+}
+
+// exportSessions reads every session of the user, oldest first, with the
+// writing of archived ones read back from the archive.
+func (s *UserExportService) exportSessions(ctx context.Context, userID uuid.UUID) ([]*types.WritingSession, error) {
+	sessions := make([]*types.WritingSession, 0)
+	for offset := 0; ; offset += userExportPageSize {
+		page, err := s.store.GetUserWritingSessions(ctx, userID, false, userExportPageSize, offset)
+		if err != nil {
+			return nil, fmt.Errorf("error getting writing sessions: %w", err)
+		}
+		for _, session := range page {
+			if err := s.archive.Rehydrate(ctx, session); err != nil {
+				return nil, err
+			}
+		}
+		sessions = append(sessions, page...)
+		if len(page) < userExportPageSize {
+			break
+		}
+	}
+	// Pages come newest first
+	for i, j := 0, len(sessions)-1; i < j; i, j = i+1, j-1 {
+		sessions[i], sessions[j] = sessions[j], sessions[i]
+	}
+	return sessions, nil
+}
+
+func (s *UserExportService) exportAnkys(ctx context.Context, userID uuid.UUID) ([]*types.Anky, error) {
+	ankys := make([]*types.Anky, 0)
+	for offset := 0; ; offset += userExportPageSize {
+		page, err := s.store.GetAnkysByUserID(ctx, userID, userExportPageSize, offset)
+		if err != nil {
+			return nil, fmt.Errorf("error getting ankys: %w", err)
+		}
+		if err := s.store.AttachAnkyImages(ctx, page...); err != nil {
+			return nil, fmt.Errorf("error getting anky images: %w", err)
+		}
+		ankys = append(ankys, page...)
+		if len(page) < userExportPageSize {
+			return ankys, nil
+		}
+	}
+}
+
+func (s *UserExportService) exportNewenTransactions(ctx context.Context, userID uuid.UUID) ([]*types.NewenTransaction, error) {
+	transactions := make([]*types.NewenTransaction, 0)
+	for offset := 0; ; offset += userExportPageSize {
+		page, err := s.store.GetNewenTransactions(ctx, userID, userExportPageSize, offset)
+		if err != nil {
+			return nil, fmt.Errorf("error getting newen transactions: %w", err)
+		}
+		transactions = append(transactions, page...)
+		if len(page) < userExportPageSize {
+			return transactions, nil
+		}
+	}
+}
+
+// OpenExport returns the zip of the ready export with the download token,
+// wrapping pgx.ErrNoRows when there is none or it expired.
+func (s *UserExportService) OpenExport(ctx context.Context, token string) (*types.UserExport, []byte, error) {
+	export, err := s.store.GetUserExportByToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := s.blobs.Get(ctx, export.BlobKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading export %s: %w", export.ID, err)
+	}
+	return export, data, nil
+}
+
+// DeleteExports removes the zips of a user who deletes their account.
+// Failures are logged, not returned.
+func (s *UserExportService) DeleteExports(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("❌ Error deleting export %s: %v", key, err)
+		}
+	}
+}
+
+// StartCleanupJob blocks, deleting expired exports every interval.
+func (s *UserExportService) StartCleanupJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpiredExports(ctx); err != nil {
+				log.Printf("❌ Error deleting expired exports: %v", err)
+			}
+		}
+	}
+}
+
+// DeleteExpiredExports deletes the zips and rows of expired exports and
+// returns how many were deleted.
+func (s *UserExportService) DeleteExpiredExports(ctx context.Context) (int, error) {
+	exports, err := s.store.GetExpiredUserExports(ctx, s.store.Clock().Now(), userExportCleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, export := range exports {
+		if export.BlobKey != "" {
+			if err := s.blobs.Delete(ctx, export.BlobKey); err != nil {
+				log.Printf("❌ Error deleting export %s: %v", export.ID, err)
+				continue
+			}
+		}
+		if err := s.store.DeleteUserExport(ctx, export.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("🧹 Deleted %d expired exports", deleted)
+	}
+	return deleted, nil
+}
+
+func writeZipFile(archive *zip.Writer, name string, data []byte) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("error adding %s to export: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("error writing %s to export: %w", name, err)
+	}
+	return nil
+}
+
+// sessionTextFileName names the plain text file of a session so the files
+// sort by when they were written.
+func sessionTextFileName(session *types.WritingSession) string {
+	return fmt.Sprintf("sessions/%s_%s.txt", session.StartingTimestamp.UTC().Format("2006-01-02_150405"), session.ID)
+}
+
+// sessionText is the session as someone would read it: when it was written,
+// the prompt and the writing.
+func sessionText(session *types.WritingSession) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", session.StartingTimestamp.UTC().Format(time.RFC1123))
+	if session.Prompt != "" {
+		fmt.Fprintf(&b, "\n%s\n", session.Prompt)
+	}
+	fmt.Fprintf(&b, "\n%s\n", session.Writing)
+	return b.String()
+}
+
+func ExportCleanupIntervalFromEnv() time.Duration {
+	if value := os.Getenv("EXPORT_CLEANUP_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 6 * time.Hour
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	maxWebhooksPerUser = 5
+	// Failed deliveries in a row after which a webhook is disabled
+	webhookMaxFailures = 25
+	webhookAttempts    = 3
+	// Wait before the second attempt, doubled after every further failure
+	webhookBaseBackoff = 2 * time.Second
+	// Header carrying t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	WebhookSignatureHeader = "X-Anky-Signature"
+)
+
+var (
+	ErrInvalidWebhookURL   = errors.New("webhook URLs must be public https URLs")
+	ErrTooManyWebhooks     = fmt.Errorf("you can register up to %d webhooks", maxWebhooksPerUser)
+	ErrUnknownWebhookEvent = errors.New("unknown webhook event")
+)
+
+// WebhookEvents are the pipeline statuses webhooks can be called for.
+var WebhookEvents = []string{types.WebhookEventReflectionCompleted, types.WebhookEventImageGenerated, types.WebhookEventCompleted}
+
+// webhookClient doesn't follow redirects nor connect to private addresses,
+// so a registered URL can't reach the services next to the server.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: denyPrivateAddresses}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func denyPrivateAddresses(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// WebhookService registers webhooks and calls them when the Ankys this
+// instance makes reach one of their events.
+type WebhookService struct {
+	store *storage.PostgresStore
+}
+
+func NewWebhookService(store *storage.PostgresStore) *WebhookService {
+	return &WebhookService{store: store}
+}
+
+// Register stores a webhook of the user for the events, all of them when
+// none are given, and returns it with the secret its callbacks are signed
+// with. Only admins may ask for the callbacks of every Anky, which the
+// caller checks.
+func (s *WebhookService) Register(ctx context.Context, userID uuid.UUID, rawURL string, events []string, allAnkys bool) (*types.Webhook, error) {
+	if err := validateWebhookURL(rawURL); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		events = WebhookEvents
+	}
+	for _, event := range events {
+		if !isWebhookEvent(event) {
+			return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnknownWebhookEvent, event, strings.Join(WebhookEvents, ", "))
+		}
+	}
+	count, err := s.store.CountWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxWebhooksPerUser {
+		return nil, ErrTooManyWebhooks
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error generating webhook secret: %w", err)
+	}
+	webhook := &types.Webhook{
+		UserID:   userID,
+		URL:      rawURL,
+		Secret:   "whsec_" + hex.EncodeToString(secret),
+		Events:   events,
+		AllAnkys: allAnkys,
+	}
+	if err := s.store.CreateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	log.Printf("🪝 User %s registered webhook %s for %s", userID, webhook.ID, strings.Join(events, ", "))
+	return webhook, nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" || parsed.User != nil {
+		return ErrInvalidWebhookURL
+	}
+	host := parsed.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return ErrInvalidWebhookURL
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return ErrInvalidWebhookURL
+	}
+	return nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatch blocks, calling the webhooks registered for the status updates of
+// the pipeline until ctx is done. Each webhook is called on its own
+// goroutine, so a slow one doesn't hold the others back; callbacks still
+// being retried when ctx is done are dropped.
+func (s *WebhookService) Dispatch(ctx context.Context) {
+	updates := make(chan AnkyStatusUpdate, 256)
+	SubscribeAnkyStatus(func(update AnkyStatusUpdate) {
+		if !isWebhookEvent(update.Status) {
+			return
+		}
+		select {
+		case updates <- update:
+		default:
+			log.Printf("⚠️ Webhook queue full, dropping %s for session %s", update.Status, update.SessionID)
+		}
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-updates:
+			if err := s.dispatchUpdate(ctx, update); err != nil {
+				log.Printf("❌ Error calling webhooks for %s of session %s: %v", update.Status, update.SessionID, err)
+			}
+		}
+	}
+}
+
+func (s *WebhookService) dispatchUpdate(ctx context.Context, update AnkyStatusUpdate) error {
+	payload := types.WebhookPayload{
+		ID:         s.store.IDs().NewID(),
+		Event:      update.Status,
+		SessionID:  update.SessionID,
+		OccurredAt: s.store.Clock().Now().UTC(),
+	}
+	// Frames Ankys aren't stored, only the webhooks for every Anky hear of them
+	var ownerID *uuid.UUID
+	if sessionID, err := uuid.Parse(update.SessionID); err == nil {
+		anky, err := s.store.GetAnkyByWritingSessionID(ctx, sessionID)
+		switch {
+		case err == nil:
+			ownerID = &anky.UserID
+			payload.AnkyID = &anky.ID
+			payload.ImageURL = anky.Withheld().ImageURL
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+	}
+
+	webhooks, err := s.store.GetWebhooksForEvent(ctx, update.Status, ownerID)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		go s.deliver(ctx, webhook, body)
+	}
+	return nil
+}
+
+// deliver posts the body to the webhook, retrying failures, and records how
+// it went.
+func (s *WebhookService) deliver(ctx context.Context, webhook *types.Webhook, body []byte) {
+	var lastErr error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if lastErr = s.post(ctx, webhook, body); lastErr == nil {
+			break
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(webhookBaseBackoff * time.Duration(1<<uint(attempt-1))):
+		}
+	}
+
+	deliveryErr := ""
+	if lastErr != nil {
+		deliveryErr = lastErr.Error()
+		log.Printf("⚠️ Webhook %s failed after %d attempts: %v", webhook.ID, webhookAttempts, lastErr)
+		if webhook.ConsecutiveFailures+1 >= webhookMaxFailures {
+			log.Printf("🪝 Disabling webhook %s after %d failed deliveries in a row", webhook.ID, webhookMaxFailures)
+		}
+	}
+	if err := s.store.RecordWebhookDelivery(context.WithoutCancel(ctx), webhook.ID, deliveryErr, webhookMaxFailures); err != nil {
+		log.Printf("❌ Error recording delivery of webhook %s: %v", webhook.ID, err)
+	}
+}
+
+func (s *WebhookService) post(ctx context.Context, webhook *types.Webhook, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, s.store.Clock().Now(), body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook is the signature header of a callback sent at t. Receivers
+// recompute the HMAC over "<t>.<body>" with their secret, compare it in
+// constant time and reject old timestamps.
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	timestamp := t.Unix()
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// Writers further apart than this are never paired, so buddies write
+	// around the same hours
+	buddyMaxOffsetDiff = 3 * time.Hour
+	// How many of the next writers, in timezone order, a writer is compared with
+	buddyMatchWindow = 10
+	// Missed days in a row a writer is nudged for, after that they're left alone
+	buddyMaxMissedDays = 3
+	// How often a writer may nudge their buddy by hand
+	buddyNudgeCooldown = 12 * time.Hour
+	// Shown instead of buddies without a Farcaster name
+	buddyDefaultName = "a fellow writer"
+	buddyDateLayout  = "2006-01-02"
+)
+
+var (
+	ErrNoBuddy         = errors.New("you don't have a writing buddy yet")
+	ErrNudgeTooSoon    = errors.New("you already nudged your buddy recently")
+	ErrBuddyWroteToday = errors.New("your buddy already wrote today")
+)
+
+// WritingBuddyService pairs opted in writers with an accountability buddy of
+// a similar timezone and streak, and nudges writers who miss a day.
+type WritingBuddyService struct {
+	store *storage.PostgresStore
+}
+
+func NewWritingBuddyService(store *storage.PostgresStore) *WritingBuddyService {
+	return &WritingBuddyService{store: store}
+}
+
+// GetBuddy returns how the writer's buddy is doing, nil when they have none.
+func (s *WritingBuddyService) GetBuddy(ctx context.Context, userID uuid.UUID) (*types.WritingBuddy, error) {
+	pair, err := s.store.GetActiveBuddyPair(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	streak, err := s.store.GetUserWritingStreak(ctx, pair.BuddyID)
+	if err != nil {
+		return nil, err
+	}
+	name, err := s.store.GetBuddyName(ctx, pair.BuddyID)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = buddyDefaultName
+	}
+
+	return &types.WritingBuddy{
+		PairID:          pair.PairID,
+		Name:            name,
+		PairedAt:        pair.PairedAt,
+		WrittenToday:    streak.WrittenToday,
+		CurrentStreak:   streak.Current,
+		LastWrittenDate: streak.LastWrittenDate,
+	}, nil
+}
+
+// Nudge reminds the writer's buddy to write today. It fails when the buddy
+// already wrote or the writer nudged them less than buddyNudgeCooldown ago.
+func (s *WritingBuddyService) Nudge(ctx context.Context, userID uuid.UUID) (*types.BuddyNudge, error) {
+	pair, err := s.store.GetActiveBuddyPair(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoBuddy
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := s.store.GetLastBuddyNudgeFrom(ctx, userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if last != nil && s.store.Clock().Now().Sub(last.CreatedAt) < buddyNudgeCooldown {
+		return nil, ErrNudgeTooSoon
+	}
+
+	streak, err := s.store.GetUserWritingStreak(ctx, pair.BuddyID)
+	if err != nil {
+		return nil, err
+	}
+	if streak.WrittenToday {
+		return nil, ErrBuddyWroteToday
+	}
+
+	nudge := &types.BuddyNudge{UserID: pair.BuddyID, FromUserID: &userID, Kind: types.BuddyNudgeFromBuddy}
+	if _, err := s.store.CreateBuddyNudge(ctx, nudge); err != nil {
+		return nil, err
+	}
+	log.Printf("👋 User %s nudged their buddy %s", userID, pair.BuddyID)
+	return nudge, nil
+}
+
+// StartBuddyJob blocks, pairing waiting writers and nudging the ones who
+// missed a day every interval.
+func (s *WritingBuddyService) StartBuddyJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.MatchBuddies(ctx); err != nil {
+				log.Printf("❌ Error matching writing buddies: %v", err)
+			}
+			if _, err := s.NudgeMissedDays(ctx); err != nil {
+				log.Printf("❌ Error nudging writing buddies: %v", err)
+			}
+		}
+	}
+}
+
+// buddyCandidate is a waiting writer as the matching sees them.
+type buddyCandidate struct {
+	UserID uuid.UUID
+	Offset time.Duration
+	Streak int
+}
+
+// MatchBuddies pairs the opted in writers who are waiting for a buddy and
+// returns how many pairs were made. Writers are never paired with someone
+// they were paired with before.
+func (s *WritingBuddyService) MatchBuddies(ctx context.Context) (int, error) {
+	waiting, err := s.store.GetBuddyCandidates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(waiting) < 2 {
+		return 0, nil
+	}
+
+	now := s.store.Clock().Now()
+	candidates := make([]buddyCandidate, 0, len(waiting))
+	userIDs := make([]uuid.UUID, 0, len(waiting))
+	for _, candidate := range waiting {
+		streak, err := s.store.GetUserWritingStreak(ctx, candidate.UserID)
+		if err != nil {
+			log.Printf("⚠️ Skipping buddy candidate %s: %v", candidate.UserID, err)
+			continue
+		}
+		candidates = append(candidates, buddyCandidate{
+			UserID: candidate.UserID,
+			Offset: timezoneOffset(candidate.Timezone, now),
+			Streak: streak.Current,
+		})
+		userIDs = append(userIDs, candidate.UserID)
+	}
+
+	past, err := s.store.GetPastBuddies(ctx, userIDs)
+	if err != nil {
+		return 0, err
+	}
+	pairedBefore := func(a, b uuid.UUID) bool {
+		for _, buddy := range past[a] {
+			if buddy == b {
+				return true
+			}
+		}
+		return false
+	}
+
+	paired := 0
+	for _, pair := range pairBuddyCandidates(candidates, pairedBefore) {
+		_, err := s.store.CreateBuddyPair(ctx, pair[0], pair[1])
+		if errors.Is(err, storage.ErrAlreadyPaired) {
+			continue
+		}
+		if err != nil {
+			return paired, err
+		}
+		paired++
+	}
+	if paired > 0 {
+		log.Printf("🤝 Paired %d writing buddies, %d writers still waiting", paired, len(candidates)-2*paired)
+	}
+	return paired, nil
+}
+
+// pairBuddyCandidates pairs each writer, in timezone order, with the closest
+// of the next buddyMatchWindow writers: an hour of timezone difference weighs
+// as much as two days of streak difference.
+func pairBuddyCandidates(candidates []buddyCandidate, pairedBefore func(a, b uuid.UUID) bool) [][2]uuid.UUID {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Offset != candidates[j].Offset {
+			return candidates[i].Offset < candidates[j].Offset
+		}
+		return candidates[i].Streak < candidates[j].Streak
+	})
+
+	taken := make([]bool, len(candidates))
+	pairs := make([][2]uuid.UUID, 0, len(candidates)/2)
+	for i := range candidates {
+		if taken[i] {
+			continue
+		}
+		best, bestCost := -1, 0.0
+		for j := i + 1; j < len(candidates) && j <= i+buddyMatchWindow; j++ {
+			if taken[j] {
+				continue
+			}
+			offsetDiff := candidates[j].Offset - candidates[i].Offset
+			if offsetDiff > buddyMaxOffsetDiff {
+				break
+			}
+			if pairedBefore(candidates[i].UserID, candidates[j].UserID) {
+				continue
+			}
+			streakDiff := candidates[j].Streak - candidates[i].Streak
+			cost := 2*offsetDiff.Hours() + float64(max(streakDiff, -streakDiff))
+			if best == -1 || cost < bestCost {
+				best, bestCost = j, cost
+			}
+		}
+		if best == -1 {
+			continue
+		}
+		taken[i], taken[best] = true, true
+		pairs = append(pairs, [2]uuid.UUID{candidates[i].UserID, candidates[best].UserID})
+	}
+	return pairs
+}
+
+// NudgeMissedDays nudges every paired writer who didn't write yesterday, in
+// their own timezone, on behalf of their buddy. Each missed day is nudged
+// once, and writers who stayed away buddyMaxMissedDays days in a row are no
+// longer nudged. It returns how many nudges were sent.
+func (s *WritingBuddyService) NudgeMissedDays(ctx context.Context) (int, error) {
+	pairs, err := s.store.GetActiveBuddyPairs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.store.Clock().Now()
+	nudged := 0
+	for _, pair := range pairs {
+		if err := ctx.Err(); err != nil {
+			return nudged, err
+		}
+
+		streak, err := s.store.GetUserWritingStreak(ctx, pair.UserID)
+		if err != nil {
+			log.Printf("⚠️ Could not get the streak of %s to nudge them: %v", pair.UserID, err)
+			continue
+		}
+		missedDate, ok := missedBuddyDay(streak, pair.PairedAt, now)
+		if !ok {
+			continue
+		}
+
+		buddyID := pair.BuddyID
+		created, err := s.store.CreateBuddyNudge(ctx, &types.BuddyNudge{
+			UserID:     pair.UserID,
+			FromUserID: &buddyID,
+			Kind:       types.BuddyNudgeMissedDay,
+			MissedDate: missedDate,
+		})
+		if err != nil {
+			log.Printf("❌ Error nudging %s for missing %s: %v", pair.UserID, missedDate, err)
+			continue
+		}
+		if created {
+			nudged++
+		}
+	}
+	if nudged > 0 {
+		log.Printf("👋 Nudged %d writers who missed a day", nudged)
+	}
+	return nudged, nil
+}
+
+// missedBuddyDay returns yesterday's date in the writer's timezone when they
+// didn't write that day, were already paired, and haven't been away for more
+// than buddyMaxMissedDays days.
+func missedBuddyDay(streak *types.WritingStreak, pairedAt time.Time, now time.Time) (string, bool) {
+	location, err := time.LoadLocation(streak.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	yesterday := today.AddDate(0, 0, -1)
+
+	// Away since the later of their last session and the day they were paired
+	pairedLocal := pairedAt.In(location)
+	since := time.Date(pairedLocal.Year(), pairedLocal.Month(), pairedLocal.Day(), 0, 0, 0, 0, time.UTC)
+	if streak.LastWrittenDate != "" {
+		lastWritten, err := time.Parse(buddyDateLayout, streak.LastWrittenDate)
+		if err == nil && lastWritten.After(since) {
+			since = lastWritten
+		}
+	}
+	if !since.Before(yesterday) {
+		return "", false
+	}
+	if missed := int(yesterday.Sub(since).Hours() / 24); missed > buddyMaxMissedDays {
+		return "", false
+	}
+	return yesterday.Format(buddyDateLayout), true
+}
+
+// timezoneOffset returns the UTC offset of the IANA timezone at t, zero
+// when the timezone is unknown.
+func timezoneOffset(timezone string, t time.Time) time.Duration {
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" {
+		return 0
+	}
+	_, offset := t.In(location).Zone()
+	return time.Duration(offset) * time.Second
+}
+
+func BuddyJobIntervalFromEnv() time.Duration {
+	if value := os.Getenv("BUDDY_JOB_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return time.Hour
+}
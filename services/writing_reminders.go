@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+const (
+	// Sessions older than this don't shape the schedule, and writers who
+	// haven't finished one since aren't reminded
+	reminderHistoryWindow = 60 * 24 * time.Hour
+	// A session counts half as much as one started this much later
+	reminderHalfLife = 14 * 24 * time.Hour
+	// Recent sessions needed before the typical hour is learned
+	reminderMinSessions = 5
+	// Local hour writers are reminded around until then
+	reminderDefaultHour = 9
+	// How long before the typical hour the reminder is sent
+	reminderLead = 30 * time.Minute
+	// Reminders the job couldn't send this long after their time are skipped
+	// for the day rather than sent at an odd hour
+	reminderSendWindow = 2 * time.Hour
+	reminderDateLayout = "2006-01-02"
+)
+
+// WritingReminderService reminds writers to write every day at the hour
+// they usually do, unless they already wrote or opted out.
+type WritingReminderService struct {
+	store *storage.PostgresStore
+}
+
+func NewWritingReminderService(store *storage.PostgresStore) *WritingReminderService {
+	return &WritingReminderService{store: store}
+}
+
+// GetSchedule predicts when the writer is reminded next, from their recent
+// sessions.
+func (s *WritingReminderService) GetSchedule(ctx context.Context, userID uuid.UUID) (*types.ReminderSchedule, error) {
+	now := s.store.Clock().Now()
+	candidate, err := s.store.GetReminderCandidate(ctx, userID, now.Add(-reminderHistoryWindow))
+	if err != nil {
+		return nil, err
+	}
+	optedOut, err := s.store.IsOptedOutOfReminders(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	location, timezone := reminderLocation(candidate.Timezone)
+	schedule := computeReminderSchedule(candidate.Starts, location, now)
+	schedule.Timezone = timezone
+	schedule.Enabled = !optedOut
+	if schedule.Enabled {
+		next := nextReminderAt(schedule, candidate.Starts, now.In(location))
+		schedule.NextReminderAt = &next
+	}
+	return schedule, nil
+}
+
+// StartReminderJob blocks, sending the reminders that are due every interval.
+func (s *WritingReminderService) StartReminderJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.SendDueReminders(ctx); err != nil {
+				log.Printf("❌ Error sending writing reminders: %v", err)
+			}
+		}
+	}
+}
+
+// SendDueReminders reminds every writer whose reminder time of today passed
+// less than reminderSendWindow ago and who hasn't written today, in their own
+// timezone. Writers who didn't finish a session in reminderHistoryWindow or
+// opted out aren't reminded. It returns how many reminders were sent.
+func (s *WritingReminderService) SendDueReminders(ctx context.Context) (int, error) {
+	now := s.store.Clock().Now()
+	candidates, err := s.store.GetReminderCandidates(ctx, now.Add(-reminderHistoryWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, candidate := range candidates {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+
+		location, _ := reminderLocation(candidate.Timezone)
+		local := now.In(location)
+		if wroteOn(candidate.Starts, local) {
+			continue
+		}
+		schedule := computeReminderSchedule(candidate.Starts, location, now)
+		remindAt := reminderTimeOn(schedule, local)
+		if local.Before(remindAt) || local.Sub(remindAt) > reminderSendWindow {
+			continue
+		}
+
+		created, err := s.store.CreateWritingReminder(ctx, &types.WritingReminder{
+			UserID:       candidate.UserID,
+			ReminderDate: local.Format(reminderDateLayout),
+			ScheduledFor: remindAt,
+			Learned:      schedule.Learned,
+		})
+		if err != nil {
+			log.Printf("❌ Error reminding %s to write: %v", candidate.UserID, err)
+			continue
+		}
+		if created {
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("⏰ Reminded %d writers to write", sent)
+	}
+	return sent, nil
+}
+
+// computeReminderSchedule finds the local hour the writer starts most of
+// their sessions, weighing recent sessions more and counting the
+// neighbouring hours half, so 20:55 and 21:05 back each other up.
+func computeReminderSchedule(starts []time.Time, location *time.Location, now time.Time) *types.ReminderSchedule {
+	schedule := &types.ReminderSchedule{TypicalHour: reminderDefaultHour, SessionsConsidered: len(starts)}
+
+	var weights [24]float64
+	total := 0.0
+	for _, start := range starts {
+		age := now.Sub(start)
+		if age < 0 {
+			age = 0
+		}
+		weight := math.Pow(0.5, float64(age)/float64(reminderHalfLife))
+		weights[start.In(location).Hour()] += weight
+		total += weight
+	}
+
+	if len(starts) >= reminderMinSessions && total > 0 {
+		best, bestScore := 0, -1.0
+		for hour := range weights {
+			score := weights[hour] + 0.5*(weights[(hour+23)%24]+weights[(hour+1)%24])
+			if score > bestScore {
+				best, bestScore = hour, score
+			}
+		}
+		schedule.TypicalHour = best
+		schedule.Learned = true
+		near := weights[(best+23)%24] + weights[best] + weights[(best+1)%24]
+		schedule.Confidence = math.Round(near/total*100) / 100
+	}
+
+	remindAt := time.Date(2000, 1, 1, schedule.TypicalHour, 0, 0, 0, time.UTC).Add(-reminderLead)
+	schedule.ReminderTime = remindAt.Format("15:04")
+	return schedule
+}
+
+// reminderTimeOn is when the writer is reminded on the local day of t.
+func reminderTimeOn(schedule *types.ReminderSchedule, t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), schedule.TypicalHour, 0, 0, 0, t.Location()).Add(-reminderLead)
+}
+
+// nextReminderAt is today's reminder while it's still ahead and the writer
+// hasn't written today, tomorrow's otherwise.
+func nextReminderAt(schedule *types.ReminderSchedule, starts []time.Time, local time.Time) time.Time {
+	today := reminderTimeOn(schedule, local)
+	if local.Before(today) && !wroteOn(starts, local) {
+		return today
+	}
+	return reminderTimeOn(schedule, local.AddDate(0, 0, 1))
+}
+
+// wroteOn reports whether any of the starts falls on the local day of t.
+func wroteOn(starts []time.Time, t time.Time) bool {
+	day := t.Format(reminderDateLayout)
+	for _, start := range starts {
+		if start.In(t.Location()).Format(reminderDateLayout) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// reminderLocation loads the writer's timezone, UTC when it is unknown.
+func reminderLocation(timezone string) (*time.Location, string) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" {
+		return time.UTC, "UTC"
+	}
+	return location, timezone
+}
+
+func ReminderJobIntervalFromEnv() time.Duration {
+	if value := os.Getenv("REMINDER_JOB_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := time.ParseDuration(value + "m"); err == nil && minutes > 0 {
+			return minutes
+		}
+	}
+	return 15 * time.Minute
+}
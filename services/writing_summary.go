@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// Summaries are previews, anything longer than this is cut at a word boundary
+const maxSummaryLength = 280
+
+const writingSummaryPersona = `You write the preview of a stream of consciousness writing session that is shown in a gallery.
+
+Summarize what the writing is about in one or two sentences, at most 40 words. Write in the third person ("the writer..."), in the same language the writing is in. Do not quote the writing, do not include names, places or any detail that could identify the writer. Reply only with the summary.`
+
+// SummarizeWriting asks the LLM for a one to two sentence preview of the writing.
+func (s *AnkyService) SummarizeWriting(writing string) (string, error) {
+	if strings.TrimSpace(writing) == "" {
+		return "", fmt.Errorf("nothing to summarize")
+	}
+
+	summary, err := s.processChatRequest(NewLLMService(), types.ChatRequest{
+		Messages: []types.Message{
+			{Role: "system", Content: writingSummaryPersona},
+			{Role: "user", Content: writing},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error generating summary: %v", err)
+	}
+
+	summary = strings.Join(strings.Fields(summary), " ")
+	if summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	if len(summary) > maxSummaryLength {
+		cut := strings.LastIndex(summary[:maxSummaryLength], " ")
+		if cut <= 0 {
+			cut = maxSummaryLength
+		}
+		summary = summary[:cut] + "…"
+	}
+	return summary, nil
+}
+
+// summarizeWritingSession stores the summary of a session once it became an
+// Anky. Failing here never fails the pipeline, the session just has no preview.
+func (s *AnkyService) summarizeWritingSession(ctx context.Context, sessionID string, writing string) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		log.Printf("⚠️ Not summarizing session with invalid id %s: %v", sessionID, err)
+		return
+	}
+
+	summary, err := s.SummarizeWriting(writing)
+	if err != nil {
+		log.Printf("⚠️ Could not summarize writing session %s: %v", sessionID, err)
+		return
+	}
+	if err := s.store.UpdateWritingSessionSummary(ctx, id, summary); err != nil {
+		log.Printf("⚠️ Could not store summary of writing session %s: %v", sessionID, err)
+		return
+	}
+	log.Printf("📝 Stored summary for writing session %s", sessionID)
+}
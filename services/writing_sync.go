@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// MaxSyncBatch is how many sessions a client may sync in one request.
+const MaxSyncBatch = 50
+
+// WritingSyncService ingests the sessions mobile clients wrote offline.
+type WritingSyncService struct {
+	store *storage.PostgresStore
+}
+
+func NewWritingSyncService(store *storage.PostgresStore) *WritingSyncService {
+	return &WritingSyncService{store: store}
+}
+
+// SyncSessions stores the writer's offline sessions one by one and reports
+// what happened to each. A session that fails never stops the rest of the
+// batch, and sending the batch again only yields duplicates.
+func (s *WritingSyncService) SyncSessions(ctx context.Context, userID uuid.UUID, sessions []types.SyncedWritingSession) ([]types.WritingSessionSyncResult, error) {
+	newenService, err := NewNewenService(s.store)
+	if err != nil {
+		return nil, fmt.Errorf("error creating newen service: %w", err)
+	}
+
+	results := make([]types.WritingSessionSyncResult, 0, len(sessions))
+	seen := make(map[uuid.UUID]bool, len(sessions))
+	for _, synced := range sessions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result := types.WritingSessionSyncResult{ID: synced.ID}
+		if seen[synced.ID] {
+			result.Status = types.SyncDuplicate
+			results = append(results, result)
+			continue
+		}
+		seen[synced.ID] = true
+
+		session, err := s.syncedWritingSession(userID, synced)
+		if err != nil {
+			result.Status, result.Reason = types.SyncRejected, err.Error()
+			results = append(results, result)
+			continue
+		}
+		// Unverified sessions, and those with pasted text or scripted keystrokes, earn nothing
+		session.NewenEarned = float64(newenService.CalculateNewenEarned(ctx, userID.String(), EarnsNewen(session), *session.EndingTimestamp))
+
+		result.Status, err = s.store.SyncWritingSession(ctx, session, synced.Clock)
+		switch {
+		case errors.Is(err, storage.ErrSessionNotOwned), errors.Is(err, storage.ErrSyncConflict):
+			result.Status, result.Reason = types.SyncRejected, err.Error()
+		case err != nil:
+			return nil, fmt.Errorf("error syncing writing session %s: %w", synced.ID, err)
+		default:
+			if result.Status == types.SyncAccepted {
+				RecordKeystrokeMetrics(ctx, s.store, session)
+			}
+			// Granting is idempotent, a duplicate makes up for a grant that failed last time
+			if _, err := newenService.GrantWritingReward(ctx, session); err != nil {
+				log.Printf("⚠️ Could not grant newen of synced session %s: %v", session.ID, err)
+			}
+		}
+		results = append(results, result)
+	}
+
+	log.Printf("🔄 Synced %d offline sessions of user %s", len(sessions), userID)
+	return results, nil
+}
+
+// syncedWritingSession validates an offline session and derives what the
+// server decides about it, just like ending a session online does. Only
+// sessions synced with their keystroke log can become Ankys and earn newen.
+func (s *WritingSyncService) syncedWritingSession(userID uuid.UUID, synced types.SyncedWritingSession) (*types.WritingSession, error) {
+	if synced.ID == uuid.Nil {
+		return nil, fmt.Errorf("missing session id")
+	}
+	if len(synced.Clock) == 0 {
+		return nil, fmt.Errorf("missing vector clock")
+	}
+	for device := range synced.Clock {
+		if device == "" {
+			return nil, fmt.Errorf("vector clock has an empty device id")
+		}
+	}
+	if synced.StartingTimestamp.IsZero() || synced.EndingTimestamp.IsZero() {
+		return nil, fmt.Errorf("starting and ending timestamps are required")
+	}
+
+	// Postgres keeps microseconds, comparing with a stored copy needs the same precision
+	startedAt := synced.StartingTimestamp.UTC().Truncate(time.Microsecond)
+	endedAt := synced.EndingTimestamp.UTC().Truncate(time.Microsecond)
+	if now := s.store.Clock().Now().UTC(); endedAt.After(now) {
+		return nil, fmt.Errorf("session can't end in the future")
+	}
+	if endedAt.Before(startedAt) {
+		return nil, fmt.Errorf("session can't end before it started")
+	}
+	timeSpent := int(endedAt.Sub(startedAt).Seconds())
+
+	session := &types.WritingSession{
+		ID:                synced.ID,
+		UserID:            userID,
+		StartingTimestamp: startedAt,
+		EndingTimestamp:   &endedAt,
+		Prompt:            synced.Prompt,
+		Writing:           synced.Text,
+		WordsWritten:      len(strings.Fields(synced.Text)),
+		TimeSpent:         &timeSpent,
+		IsOnboarding:      synced.IsOnboarding,
+		ParentAnkyID:      synced.ParentAnkyID,
+	}
+	if synced.AnkyResponse != "" {
+		session.AnkyResponse = &synced.AnkyResponse
+	}
+	// The timestamps are the client's word, without the keystrokes to back
+	// them the session stays unverified
+	if synced.KeystrokeLog != "" {
+		if err := ApplyKeystrokeLog(session, synced.KeystrokeLog); err != nil {
+			return nil, err
+		}
+	}
+	session.SetAnkyStatus()
+	return session, nil
+}
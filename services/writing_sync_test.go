@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+func TestSyncOnlyRewardsVerifiedSessions(t *testing.T) {
+	store, _, anky, _ := newPipelineTest(t)
+	userID := anky.UserID
+	service := NewWritingSyncService(store)
+	ctx := context.Background()
+
+	startedAt := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	unverified := types.SyncedWritingSession{
+		ID:                uuid.New(),
+		StartingTimestamp: startedAt,
+		EndingTimestamp:   startedAt.Add(10 * time.Minute),
+		Text:              ankyLengthWriting,
+		Clock:             types.VectorClock{"phone": 1},
+	}
+	verifiedID := uuid.New()
+	verified := types.SyncedWritingSession{
+		ID:                verifiedID,
+		StartingTimestamp: startedAt,
+		EndingTimestamp:   startedAt.Add(10 * time.Minute),
+		Clock:             types.VectorClock{"phone": 2},
+		KeystrokeLog:      typedSession(userID, verifiedID, ankyLengthWriting),
+	}
+
+	results, err := service.SyncSessions(ctx, userID, []types.SyncedWritingSession{unverified, verified})
+	if err != nil {
+		t.Fatalf("SyncSessions: %v", err)
+	}
+	for _, result := range results {
+		if result.Status != types.SyncAccepted {
+			t.Fatalf("session %s %s: %s", result.ID, result.Status, result.Reason)
+		}
+	}
+
+	stored, err := store.GetWritingSessionById(ctx, unverified.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.IsAnky || stored.NewenEarned != 0 || stored.Verified() {
+		t.Errorf("unverified session stored as anky %v with %g newen", stored.IsAnky, stored.NewenEarned)
+	}
+	stored, err = store.GetWritingSessionById(ctx, verifiedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.IsAnky || stored.NewenEarned == 0 || !stored.Verified() {
+		t.Errorf("verified session stored as anky %v with %g newen, verified %v", stored.IsAnky, stored.NewenEarned, stored.Verified())
+	}
+
+	balance, err := store.GetNewenBalance(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != int(stored.NewenEarned) {
+		t.Errorf("balance = %d, want only the verified session's %g newen", balance, stored.NewenEarned)
+	}
+}
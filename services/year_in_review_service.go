@@ -0,0 +1,386 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// A recap for the running year is regenerated at most once a day.
+	yearInReviewCurrentYearTTL = 24 * time.Hour
+	// Every render is an LLM call, so asking for a refresh re-renders a recap
+	// at most once an hour.
+	yearInReviewRefreshInterval = time.Hour
+	// Caps how much writing is sent to the LLM when building the narrative.
+	yearInReviewExcerptChars = 600
+	yearInReviewPromptChars  = 12000
+)
+
+type YearInReviewService struct {
+	store   *storage.PostgresStore
+	archive *SessionArchiveService
+}
+
+func NewYearInReviewService(store *storage.PostgresStore, archive *SessionArchiveService) *YearInReviewService {
+	return &YearInReviewService{store: store, archive: archive}
+}
+
+// GetYearInReview returns the cached recap for the user and year, rendering
+// it first if it does not exist yet, is stale, or refresh is set and it was
+// rendered more than yearInReviewRefreshInterval ago.
+func (s *YearInReviewService) GetYearInReview(ctx context.Context, userID uuid.UUID, year int, refresh bool) (*types.YearInReview, error) {
+	cached, err := s.store.GetYearInReview(ctx, userID, year)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	now := s.store.Clock().Now().UTC()
+	if cached != nil && !yearInReviewIsStale(cached, now) &&
+		(!refresh || now.Sub(cached.GeneratedAt) < yearInReviewRefreshInterval) {
+		return cached, nil
+	}
+
+	log.Printf("📅 Rendering year in review %d for user %s", year, userID)
+	from, to := yearBounds(year)
+	sessions, err := s.store.GetUserWritingSessionsBetween(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := computeYearInReviewStats(sessions)
+	narrative, err := s.generateNarrative(ctx, year, stats, sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	review := &types.YearInReview{
+		UserID:      userID,
+		Year:        year,
+		Stats:       stats,
+		Narrative:   narrative,
+		GeneratedAt: s.store.Clock().Now().UTC(),
+	}
+	if cached != nil {
+		review.AnkyID = cached.AnkyID
+	}
+
+	if err := s.store.SaveYearInReview(ctx, review); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// MintYearInReview turns the recap into a special Anky whose reflection is the
+// narrative. Calling it again returns the Anky that was already minted, and
+// minted is false; only a newly minted Anky needs GenerateYearInReviewArtwork.
+func (s *YearInReviewService) MintYearInReview(ctx context.Context, userID uuid.UUID, year int) (anky *types.Anky, minted bool, err error) {
+	review, err := s.GetYearInReview(ctx, userID, year, false)
+	if err != nil {
+		return nil, false, err
+	}
+	if review.AnkyID != nil {
+		anky, err := s.store.GetAnkyByID(ctx, *review.AnkyID)
+		return anky, false, err
+	}
+	if review.Stats.TotalSessions == 0 {
+		return nil, false, fmt.Errorf("there is no writing in %d to mint", year)
+	}
+
+	// ankys.writing_session_id is required, so the recap hangs off the
+	// longest session of the year
+	from, to := yearBounds(year)
+	sessions, err := s.store.GetUserWritingSessionsBetween(ctx, userID, from, to)
+	if err != nil {
+		return nil, false, err
+	}
+	longest := sessions[0]
+	for _, session := range sessions[1:] {
+		if sessionSeconds(session) > sessionSeconds(longest) {
+			longest = session
+		}
+	}
+
+	anky = types.NewAnky(longest.ID, fmt.Sprintf("year in review %d", year), userID)
+	anky.AnkyReflection = review.Narrative
+	anky.TokenName = fmt.Sprintf("My %d with Anky", year)
+	anky.Ticker = fmt.Sprintf("ANKY%d", year)
+	anky.Status = "year_in_review_pending"
+	anky.YearInReview = true
+	anky.LastUpdatedAt = anky.CreatedAt
+	if user, err := s.store.GetUserByID(ctx, userID); err == nil {
+		anky.License = user.PreferredLicense()
+	}
+	ankyID, created, err := s.store.MintYearInReviewAnky(ctx, userID, year, anky)
+	if err != nil {
+		return nil, false, err
+	}
+	if !created {
+		// Another request minted it first
+		anky, err := s.store.GetAnkyByID(ctx, ankyID)
+		return anky, false, err
+	}
+	return anky, true, nil
+}
+
+// GenerateYearInReviewArtwork paints the artwork of a year in review Anky
+// MintYearInReview just minted and completes it, or marks it failed. It takes
+// long, so callers run it in the background.
+func (s *YearInReviewService) GenerateYearInReviewArtwork(ctx context.Context, anky *types.Anky) {
+	ankyService, err := NewAnkyService(s.store)
+	if err != nil {
+		log.Printf("❌ Error creating anky service for year in review %s: %v", anky.ID, err)
+		return
+	}
+
+	imagePrompt, err := ankyService.SimplePrompt(ctx, fmt.Sprintf(`Write a single midjourney prompt (max 60 words, no explanations) for a painting of Anky, a blue-skinned being with purple hair and golden eyes, that captures this person's year of writing:
+
+%s`, anky.AnkyReflection))
+	if err != nil {
+		s.failYearInReviewArtwork(ctx, ankyService, anky, err)
+		return
+	}
+	anky.ImagePrompt = strings.TrimSpace(imagePrompt)
+
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "generating_image", "year in review artwork")
+	ipfsHash, err := ankyService.GenerateAnkyFromPrompt(ctx, anky.ImagePrompt)
+	if err != nil {
+		s.failYearInReviewArtwork(ctx, ankyService, anky, err)
+		return
+	}
+
+	anky.ImageIPFSHash = ipfsHash
+	anky.Status = "completed"
+	anky.LastUpdatedAt = s.store.Clock().Now().UTC()
+	if err := s.store.UpdateAnky(ctx, anky); err != nil {
+		log.Printf("❌ Error updating year in review anky %s: %v", anky.ID, err)
+		return
+	}
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "completed", "")
+	log.Printf("✅ Year in review anky %s minted", anky.ID)
+}
+
+func (s *YearInReviewService) failYearInReviewArtwork(ctx context.Context, ankyService *AnkyService, anky *types.Anky, cause error) {
+	log.Printf("❌ Error generating year in review artwork for anky %s: %v", anky.ID, cause)
+	anky.Status = "failed"
+	anky.LastUpdatedAt = s.store.Clock().Now().UTC()
+	if err := s.store.UpdateAnky(ctx, anky); err != nil {
+		log.Printf("❌ Error updating year in review anky %s: %v", anky.ID, err)
+	}
+	ankyService.recordAnkyStatusEvent(ctx, anky.ID, "failed", cause.Error())
+}
+
+// GenerateAnnualRecaps renders the recap of every user who wrote during the
+// given year. Recaps that are already up to date are left alone.
+func (s *YearInReviewService) GenerateAnnualRecaps(ctx context.Context, year int) error {
+	from, to := yearBounds(year)
+	userIDs, err := s.store.GetUserIDsWithSessionsBetween(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("📅 Generating %d year in review recaps for %d", len(userIDs), year)
+	failed := 0
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := s.GetYearInReview(ctx, userID, year, false); err != nil {
+			log.Printf("❌ Error generating year in review %d for user %s: %v", year, userID, err)
+			failed++
+		}
+	}
+	log.Printf("✅ Year in review recaps for %d done (%d failed)", year, failed)
+	return nil
+}
+
+// StartAnnualRecapJob blocks, rendering last year's recaps every January 1st
+// (UTC). If the server starts during January it catches up straight away.
+func (s *YearInReviewService) StartAnnualRecapJob(ctx context.Context) {
+	now := s.store.Clock().Now().UTC()
+	if now.Month() == time.January {
+		if err := s.GenerateAnnualRecaps(ctx, now.Year()-1); err != nil {
+			log.Printf("❌ Error generating annual recaps: %v", err)
+		}
+	}
+
+	for {
+		now := s.store.Clock().Now().UTC()
+		next := time.Date(now.Year()+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+		timer := time.NewTimer(next.Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			if err := s.GenerateAnnualRecaps(ctx, next.Year()-1); err != nil {
+				log.Printf("❌ Error generating annual recaps: %v", err)
+			}
+		}
+	}
+}
+
+func yearBounds(year int) (time.Time, time.Time) {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(1, 0, 0)
+}
+
+// yearInReviewIsStale reports whether a cached recap may be missing writing:
+// the running year expires daily, a finished year only if it was rendered
+// before the year was over.
+func yearInReviewIsStale(review *types.YearInReview, now time.Time) bool {
+	_, end := yearBounds(review.Year)
+	if now.Before(end) {
+		return now.Sub(review.GeneratedAt) > yearInReviewCurrentYearTTL
+	}
+	return review.GeneratedAt.Before(end)
+}
+
+func sessionSeconds(session *types.WritingSession) int {
+	if session.TimeSpent != nil {
+		return *session.TimeSpent
+	}
+	if session.EndingTimestamp != nil {
+		return int(session.EndingTimestamp.Sub(session.StartingTimestamp).Seconds())
+	}
+	return 0
+}
+
+func computeYearInReviewStats(sessions []*types.WritingSession) types.YearInReviewStats {
+	stats := types.YearInReviewStats{}
+	days := make(map[string]bool)
+	months := make(map[time.Month]int)
+
+	for _, session := range sessions {
+		stats.TotalSessions++
+		if session.IsAnky {
+			stats.TotalAnkys++
+		}
+
+		words := session.WordsWritten
+		if words == 0 {
+			words = len(strings.Fields(session.Writing))
+		}
+		stats.TotalWords += words
+
+		seconds := sessionSeconds(session)
+		stats.TotalSecondsWriting += seconds
+		if seconds > stats.LongestSessionSeconds {
+			stats.LongestSessionSeconds = seconds
+		}
+
+		stats.NewenEarned += session.NewenEarned
+		days[session.StartingTimestamp.UTC().Format("2006-01-02")] = true
+		months[session.StartingTimestamp.UTC().Month()]++
+	}
+
+	stats.ActiveDays = len(days)
+	stats.LongestStreakDays = longestDayStreak(days)
+
+	bestMonth, bestCount := time.Month(0), 0
+	for month, count := range months {
+		if count > bestCount || (count == bestCount && month < bestMonth) {
+			bestMonth, bestCount = month, count
+		}
+	}
+	if bestCount > 0 {
+		stats.MostActiveMonth = bestMonth.String()
+	}
+
+	return stats
+}
+
+func longestDayStreak(days map[string]bool) int {
+	dates := make([]time.Time, 0, len(days))
+	for day := range days {
+		date, err := time.Parse("2006-01-02", day)
+		if err == nil {
+			dates = append(dates, date)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	longest, current := 0, 0
+	for i, date := range dates {
+		if i > 0 && date.Sub(dates[i-1]) == 24*time.Hour {
+			current++
+		} else {
+			current = 1
+		}
+		if current > longest {
+			longest = current
+		}
+	}
+	return longest
+}
+
+// yearInReviewExcerpt trims the writing to yearInReviewExcerptChars
+// characters, without cutting one in half.
+func yearInReviewExcerpt(writing string) string {
+	writing = strings.TrimSpace(writing)
+	runes := []rune(writing)
+	if len(runes) <= yearInReviewExcerptChars {
+		return writing
+	}
+	return string(runes[:yearInReviewExcerptChars]) + "..."
+}
+
+// generateNarrative has the LLM write the recap from excerpts of the year's
+// sessions. The writing of archived sessions is read back from the archive
+// for the excerpts that fit in the prompt.
+func (s *YearInReviewService) generateNarrative(ctx context.Context, year int, stats types.YearInReviewStats, sessions []*types.WritingSession) (string, error) {
+	if stats.TotalSessions == 0 {
+		return fmt.Sprintf("%d was a quiet year. The page is still here, waiting for you.", year), nil
+	}
+
+	var excerpts strings.Builder
+	for _, session := range sessions {
+		if err := s.archive.Rehydrate(ctx, session); err != nil {
+			log.Printf("⚠️ Could not read back archived session %s for the year in review: %v", session.ID, err)
+			continue
+		}
+		writing := yearInReviewExcerpt(session.Writing)
+		if writing == "" {
+			continue
+		}
+		entry := fmt.Sprintf("[%s] %s\n\n", session.StartingTimestamp.UTC().Format("January 2"), writing)
+		if excerpts.Len()+len(entry) > yearInReviewPromptChars {
+			break
+		}
+		excerpts.WriteString(entry)
+	}
+
+	systemPrompt := `You are Anky, a companion that has been reading this person's stream of consciousness writing for a whole year. Write a warm, honest narrative (max 250 words, second person) about the themes that kept coming back, how they changed over the months and what seems to be asking for attention next. Do not quote the writing verbatim and do not list statistics. Reply only with the narrative.`
+
+	userContent := fmt.Sprintf(`Year: %d
+Sessions: %d (%d full ankys) across %d days, longest streak %d days, most active in %s.
+
+Excerpts:
+%s`, year, stats.TotalSessions, stats.TotalAnkys, stats.ActiveDays, stats.LongestStreakDays, stats.MostActiveMonth, excerpts.String())
+
+	llmService := NewLLMService()
+	responseChan, err := llmService.SendChatRequest(types.ChatRequest{
+		Messages: []types.Message{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: userContent},
+		},
+	}, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate year in review narrative: %v", err)
+	}
+
+	var fullResponse string
+	for partialResponse := range responseChan {
+		fullResponse += partialResponse
+	}
+	return strings.TrimSpace(fullResponse), nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+func TestYearInReviewExcerptKeepsWholeCharacters(t *testing.T) {
+	writing := strings.Repeat("ñ", yearInReviewExcerptChars-1) + "🙂🙂"
+	excerpt := yearInReviewExcerpt(writing)
+	if !utf8.ValidString(excerpt) {
+		t.Fatalf("excerpt is not valid UTF-8: %q", excerpt[len(excerpt)-12:])
+	}
+	if want := strings.Repeat("ñ", yearInReviewExcerptChars-1) + "🙂..."; excerpt != want {
+		t.Errorf("excerpt ends %q, want it cut after %d characters", excerpt[len(excerpt)-12:], yearInReviewExcerptChars)
+	}
+	if short := yearInReviewExcerpt("  the morning light  "); short != "the morning light" {
+		t.Errorf("short excerpt = %q", short)
+	}
+}
+
+func TestYearInReviewRefreshIsThrottled(t *testing.T) {
+	store := storagetest.Open(t)
+	llm := newFakeLLM(t)
+	useFakeUpstreams(t, llm, newFakeMidjourney(t), newFakeCloudinary(t), newFakePinata(t), newFakeNeynar(t))
+	clock := utils.NewFixedClock(time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC))
+	store.SetClock(clock)
+	ctx := context.Background()
+
+	user := &types.User{ID: uuid.New(), CreatedAt: clock.Now(), UpdatedAt: clock.Now()}
+	if err := store.CreateUserWithRelations(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	session := &types.WritingSession{
+		ID:                uuid.New(),
+		UserID:            user.ID,
+		StartingTimestamp: clock.Now().Add(-24 * time.Hour),
+		Prompt:            "what is alive in you this morning?",
+		Status:            "completed",
+		Writing:           pipelineTestWriting,
+	}
+	if err := store.CreateWritingSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewYearInReviewService(store, NewSessionArchiveService(store))
+	renders := func() int { return llm.count("POST", "/chat/completions") }
+	if _, err := s.GetYearInReview(ctx, user.ID, 2026, false); err != nil {
+		t.Fatalf("first render: %v", err)
+	}
+	rendered := renders()
+	if rendered == 0 {
+		t.Fatal("the recap was not rendered")
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := s.GetYearInReview(ctx, user.ID, 2026, true); err != nil {
+		t.Fatal(err)
+	}
+	if renders() != rendered {
+		t.Errorf("refreshing a minute later rendered the recap again")
+	}
+
+	clock.Advance(yearInReviewRefreshInterval)
+	if _, err := s.GetYearInReview(ctx, user.ID, 2026, true); err != nil {
+		t.Fatal(err)
+	}
+	if renders() == rendered {
+		t.Errorf("refreshing after %s didn't render the recap again", yearInReviewRefreshInterval)
+	}
+}
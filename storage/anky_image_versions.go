@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrImageRegenerationRunning is returned when the Anky's image is already
+// being regenerated.
+var ErrImageRegenerationRunning = errors.New("this anky's image is already being regenerated")
+
+const ankyImageVersionColumns = `id, anky_id, version, source, status, image_prompt, candidate_urls, chosen_candidate,
+	image_url, image_ipfs_hash, current, error, created_at, completed_at`
+
+func scanAnkyImageVersion(row pgx.Row) (*types.AnkyImageVersion, error) {
+	version := new(types.AnkyImageVersion)
+	var candidates []byte
+	var imageURL, imageIPFSHash, versionErr *string
+	err := row.Scan(
+		&version.ID,
+		&version.AnkyID,
+		&version.Version,
+		&version.Source,
+		&version.Status,
+		&version.ImagePrompt,
+		&candidates,
+		&version.ChosenCandidate,
+		&imageURL,
+		&imageIPFSHash,
+		&version.Current,
+		&versionErr,
+		&version.CreatedAt,
+		&version.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(candidates, &version.CandidateURLs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal candidate urls: %w", err)
+	}
+	if imageURL != nil {
+		version.ImageURL = *imageURL
+	}
+	if imageIPFSHash != nil {
+		version.ImageIPFSHash = *imageIPFSHash
+	}
+	if versionErr != nil {
+		version.Error = *versionErr
+	}
+	return version, nil
+}
+
+// StartAnkyImageRegeneration stores a new generating version of the Anky's
+// image and fills in its ID, version and creation time. The first time an
+// Anky is regenerated, the image it has is kept as the current version 1.
+// It returns ErrImageRegenerationRunning while another version generates.
+func (s *PostgresStore) StartAnkyImageRegeneration(ctx context.Context, version *types.AnkyImageVersion) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin anky image regeneration: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	// The Anky's row serializes concurrent regenerations, so versions don't collide
+	var imageURL, imageIPFSHash, imagePrompt string
+	var createdAt time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(image_url, ''), COALESCE(image_ipfs_hash, ''), COALESCE(image_prompt, ''), created_at
+		FROM ankys WHERE id = $1 FOR UPDATE
+	`, version.AnkyID).Scan(&imageURL, &imageIPFSHash, &imagePrompt, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("anky %s not found: %w", version.AnkyID, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock anky: %w", classifyQueryError(ctx, err))
+	}
+
+	var latest int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM anky_image_versions WHERE anky_id = $1`, version.AnkyID).Scan(&latest); err != nil {
+		return fmt.Errorf("failed to get latest anky image version: %w", classifyQueryError(ctx, err))
+	}
+	if latest == 0 && imageURL != "" {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO anky_image_versions (id, anky_id, version, source, status, image_prompt, image_url, image_ipfs_hash, current, created_at, completed_at)
+			VALUES ($1, $2, 1, $3, $4, $5, $6, $7, TRUE, $8, $8)
+		`, s.IDs().NewID(), version.AnkyID, types.AnkyImageSourcePipeline, types.AnkyImageKept, imagePrompt, imageURL, imageIPFSHash, createdAt)
+		if err != nil {
+			return fmt.Errorf("failed to keep the original anky image: %w", classifyQueryError(ctx, err))
+		}
+		latest = 1
+	}
+
+	version.ID = s.IDs().NewID()
+	version.Version = latest + 1
+	version.Source = types.AnkyImageSourceRegenerated
+	version.Status = types.AnkyImageGenerating
+	version.CandidateURLs = []string{}
+	version.Current = false
+	if version.CreatedAt.IsZero() {
+		version.CreatedAt = s.Clock().Now().UTC()
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO anky_image_versions (id, anky_id, version, source, status, image_prompt, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, version.ID, version.AnkyID, version.Version, version.Source, version.Status, version.ImagePrompt, version.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrImageRegenerationRunning
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert anky image version: %w", classifyQueryError(ctx, err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit anky image regeneration: %w", classifyQueryError(ctx, err))
+	}
+	return nil
+}
+
+// SetAnkyImageCandidates stores the images the image backend made for the
+// version, which the owner can then choose from.
+func (s *PostgresStore) SetAnkyImageCandidates(ctx context.Context, versionID uuid.UUID, candidateURLs []string) error {
+	candidates, err := json.Marshal(candidateURLs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal candidate urls: %w", err)
+	}
+	_, err = s.db.Exec(ctx, `UPDATE anky_image_versions SET status = $2, candidate_urls = $3 WHERE id = $1`,
+		versionID, types.AnkyImageReady, candidates)
+	if err != nil {
+		return fmt.Errorf("failed to set anky image candidates: %w", err)
+	}
+	return nil
+}
+
+// FailAnkyImageVersion marks the version failed with the reason.
+func (s *PostgresStore) FailAnkyImageVersion(ctx context.Context, versionID uuid.UUID, reason string) error {
+	_, err := s.db.Exec(ctx, `UPDATE anky_image_versions SET status = $2, error = $3, completed_at = $4 WHERE id = $1`,
+		versionID, types.AnkyImageFailed, reason, s.Clock().Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to fail anky image version: %w", err)
+	}
+	return nil
+}
+
+// GetAnkyImageVersions returns every stored version of the Anky's image,
+// oldest first. Ankys that were never regenerated have none.
+func (s *PostgresStore) GetAnkyImageVersions(ctx context.Context, ankyID uuid.UUID) ([]*types.AnkyImageVersion, error) {
+	query := `SELECT ` + ankyImageVersionColumns + ` FROM anky_image_versions WHERE anky_id = $1 ORDER BY version ASC`
+	rows, err := s.db.Query(ctx, query, ankyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky image versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make([]*types.AnkyImageVersion, 0)
+	for rows.Next() {
+		version, err := scanAnkyImageVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky image version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// GetAnkyImageVersion returns the version of the Anky's image, wrapping
+// pgx.ErrNoRows when there is no such version.
+func (s *PostgresStore) GetAnkyImageVersion(ctx context.Context, ankyID uuid.UUID, version int) (*types.AnkyImageVersion, error) {
+	query := `SELECT ` + ankyImageVersionColumns + ` FROM anky_image_versions WHERE anky_id = $1 AND version = $2`
+	imageVersion, err := scanAnkyImageVersion(s.db.QueryRow(ctx, query, ankyID, version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky image version: %w", err)
+	}
+	return imageVersion, nil
+}
+
+// KeepAnkyImageVersion makes the version the Anky's image, with the image
+// URL and IPFS hash it was uploaded and pinned to, and bumps the Anky's
+// version. The Anky's pinned image replaces any data URI fallback, and its
+// resized variants, made from the old image, are dropped.
+func (s *PostgresStore) KeepAnkyImageVersion(ctx context.Context, version *types.AnkyImageVersion) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin keeping anky image: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	now := s.Clock().Now().UTC()
+	if version.CompletedAt == nil {
+		version.CompletedAt = &now
+	}
+
+	// Unset first, the partial unique index allows one current version per Anky
+	if _, err := tx.Exec(ctx, `UPDATE anky_image_versions SET current = FALSE WHERE anky_id = $1 AND current AND id <> $2`, version.AnkyID, version.ID); err != nil {
+		return fmt.Errorf("failed to unset current anky image: %w", classifyQueryError(ctx, err))
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE anky_image_versions SET status = $2, chosen_candidate = $3, image_url = $4, image_ipfs_hash = $5,
+			current = TRUE, error = NULL, completed_at = $6
+		WHERE id = $1
+	`, version.ID, types.AnkyImageKept, version.ChosenCandidate, version.ImageURL, version.ImageIPFSHash, version.CompletedAt)
+	if err != nil {
+		return fmt.Errorf("failed to keep anky image version: %w", classifyQueryError(ctx, err))
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE ankys SET image_url = $2, image_ipfs_hash = $3, storage_degraded = FALSE, metadata_uri = NULL,
+			last_updated_at = $4, version = version + 1
+		WHERE id = $1
+	`, version.AnkyID, version.ImageURL, version.ImageIPFSHash, now)
+	if err != nil {
+		return fmt.Errorf("failed to update anky image: %w", classifyQueryError(ctx, err))
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM anky_image_variants WHERE anky_id = $1`, version.AnkyID); err != nil {
+		return fmt.Errorf("failed to drop anky image variants: %w", classifyQueryError(ctx, err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit kept anky image: %w", classifyQueryError(ctx, err))
+	}
+	version.Status = types.AnkyImageKept
+	version.Current = true
+	version.Error = ""
+	return nil
+}
+
+// SaveAnkyPipelineImageCandidates stores the upscaled images the pipeline
+// made for the Anky as its first image version, waiting for the writer to
+// choose one. A rerun of the pipeline starts the choice over.
+func (s *PostgresStore) SaveAnkyPipelineImageCandidates(ctx context.Context, version *types.AnkyImageVersion) error {
+	candidates, err := json.Marshal(version.CandidateURLs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal candidate urls: %w", err)
+	}
+	version.Version = 1
+	version.Source = types.AnkyImageSourcePipeline
+	version.Status = types.AnkyImageReady
+	version.ChosenCandidate = nil
+	if version.CreatedAt.IsZero() {
+		version.CreatedAt = s.Clock().Now().UTC()
+	}
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO anky_image_versions (id, anky_id, version, source, status, image_prompt, candidate_urls, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (anky_id, version) DO UPDATE SET
+			status = EXCLUDED.status, image_prompt = EXCLUDED.image_prompt, candidate_urls = EXCLUDED.candidate_urls,
+			chosen_candidate = NULL, image_url = NULL, image_ipfs_hash = NULL, current = FALSE, error = NULL,
+			created_at = EXCLUDED.created_at, completed_at = NULL
+		RETURNING id
+	`, s.IDs().NewID(), version.AnkyID, version.Version, version.Source, version.Status, version.ImagePrompt, candidates, version.CreatedAt).Scan(&version.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save anky image candidates: %w", err)
+	}
+	return nil
+}
+
+// ChooseAnkyPipelineImage records the candidate of the pipeline's image the
+// writer chose. It reports false when there is nothing left to choose: the
+// pipeline has no candidates waiting or one was chosen already.
+func (s *PostgresStore) ChooseAnkyPipelineImage(ctx context.Context, ankyID uuid.UUID, candidate int) (bool, error) {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE anky_image_versions SET chosen_candidate = $3
+		WHERE anky_id = $1 AND version = 1 AND source = $2 AND status = 'ready' AND chosen_candidate IS NULL
+			AND jsonb_array_length(candidate_urls) > $3
+	`, ankyID, types.AnkyImageSourcePipeline, candidate)
+	if err != nil {
+		return false, fmt.Errorf("failed to choose anky image: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CompleteAnkyPipelineImage marks the pipeline's image version kept and
+// current once the chosen candidate was uploaded. The Anky itself is saved by
+// the pipeline.
+func (s *PostgresStore) CompleteAnkyPipelineImage(ctx context.Context, version *types.AnkyImageVersion) error {
+	now := s.Clock().Now().UTC()
+	_, err := s.db.Exec(ctx, `
+		UPDATE anky_image_versions SET status = $2, chosen_candidate = $3, image_url = $4, current = TRUE, completed_at = $5
+		WHERE id = $1
+	`, version.ID, types.AnkyImageKept, version.ChosenCandidate, version.ImageURL, now)
+	if err != nil {
+		return fmt.Errorf("failed to complete anky image: %w", err)
+	}
+	version.Status = types.AnkyImageKept
+	version.Current = true
+	version.CompletedAt = &now
+	return nil
+}
+
+// SetCurrentAnkyImageHash records the IPFS hash the Anky's current image
+// was pinned to.
+func (s *PostgresStore) SetCurrentAnkyImageHash(ctx context.Context, ankyID uuid.UUID, imageIPFSHash string) error {
+	_, err := s.db.Exec(ctx, `UPDATE anky_image_versions SET image_ipfs_hash = $2 WHERE anky_id = $1 AND current`, ankyID, imageIPFSHash)
+	if err != nil {
+		return fmt.Errorf("failed to set anky image hash: %w", err)
+	}
+	return nil
+}
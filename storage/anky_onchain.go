@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// UpdateAnkyOnchain stores the on-chain fields of the Anky, leaving the rest
+// of the row and its version alone so it never races the pipeline's updates.
+func (s *PostgresStore) UpdateAnkyOnchain(ctx context.Context, anky *types.Anky) error {
+	query := `
+		UPDATE ankys SET
+			onchain_status = $2,
+			onchain_tx_hash = $3,
+			metadata_ipfs_hash = $4,
+			token_id = $5,
+			onchain_block_number = $6,
+			onchain_confirmed_at = $7
+		WHERE id = $1`
+	_, err := s.db.Exec(ctx, query,
+		anky.ID,
+		anky.OnchainStatus,
+		anky.OnchainTxHash,
+		anky.MetadataIPFSHash,
+		anky.TokenID,
+		anky.OnchainBlockNumber,
+		anky.OnchainConfirmedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update anky onchain state: %w", err)
+	}
+	return nil
+}
+
+// GetAnkysForOnchain returns the Ankys the confirmation job has work for:
+// pending transactions, and queued Ankys whose image is pinned and that
+// aren't sealed time capsules. The ones waiting longest come first.
+func (s *PostgresStore) GetAnkysForOnchain(ctx context.Context, limit int) ([]*types.Anky, error) {
+	query := `
+		SELECT ` + ankyColumns + ` FROM ankys
+		WHERE onchain_status = 'pending'
+			OR (onchain_status = 'queued' AND image_ipfs_hash <> '' AND (reveal_at IS NULL OR revealed_at IS NOT NULL))
+		ORDER BY last_updated_at ASC
+		LIMIT $1`
+	rows, err := s.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys for onchain: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
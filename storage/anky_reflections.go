@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const ankyReflectionColumns = `id, anky_id, version, reflection, source, canonical, newen_transaction_id, created_at`
+
+func scanAnkyReflection(row pgx.Row) (*types.AnkyReflection, error) {
+	reflection := new(types.AnkyReflection)
+	err := row.Scan(
+		&reflection.ID,
+		&reflection.AnkyID,
+		&reflection.Version,
+		&reflection.Reflection,
+		&reflection.Source,
+		&reflection.Canonical,
+		&reflection.NewenTransactionID,
+		&reflection.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return reflection, nil
+}
+
+// AddAnkyReflection stores a new, not canonical, version of the Anky's
+// reflection and fills in its ID, version and creation time. The first time
+// an Anky gets a second version, the reflection it has is kept as the
+// canonical version 1.
+func (s *PostgresStore) AddAnkyReflection(ctx context.Context, reflection *types.AnkyReflection) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin adding anky reflection: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	// The Anky's row serializes concurrent regenerations, so versions don't collide
+	var current string
+	var createdAt time.Time
+	err = tx.QueryRow(ctx, `SELECT anky_reflection, created_at FROM ankys WHERE id = $1 FOR UPDATE`, reflection.AnkyID).Scan(&current, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("anky %s not found: %w", reflection.AnkyID, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock anky: %w", classifyQueryError(ctx, err))
+	}
+
+	var latest int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM anky_reflections WHERE anky_id = $1`, reflection.AnkyID).Scan(&latest); err != nil {
+		return fmt.Errorf("failed to get latest anky reflection: %w", classifyQueryError(ctx, err))
+	}
+	if latest == 0 {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO anky_reflections (id, anky_id, version, reflection, source, canonical, created_at)
+			VALUES ($1, $2, 1, $3, $4, TRUE, $5)
+		`, s.IDs().NewID(), reflection.AnkyID, current, types.AnkyReflectionSourcePipeline, createdAt)
+		if err != nil {
+			return fmt.Errorf("failed to keep the original anky reflection: %w", classifyQueryError(ctx, err))
+		}
+		latest = 1
+	}
+
+	reflection.ID = s.IDs().NewID()
+	reflection.Version = latest + 1
+	reflection.Canonical = false
+	if reflection.CreatedAt.IsZero() {
+		reflection.CreatedAt = s.Clock().Now().UTC()
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO anky_reflections (`+ankyReflectionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`,
+		reflection.ID,
+		reflection.AnkyID,
+		reflection.Version,
+		reflection.Reflection,
+		reflection.Source,
+		reflection.Canonical,
+		reflection.NewenTransactionID,
+		reflection.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert anky reflection: %w", classifyQueryError(ctx, err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit anky reflection: %w", classifyQueryError(ctx, err))
+	}
+	return nil
+}
+
+// GetAnkyReflections returns every stored version of the Anky's reflection,
+// oldest first. Ankys that were never regenerated have none.
+func (s *PostgresStore) GetAnkyReflections(ctx context.Context, ankyID uuid.UUID) ([]*types.AnkyReflection, error) {
+	query := `SELECT ` + ankyReflectionColumns + ` FROM anky_reflections WHERE anky_id = $1 ORDER BY version ASC`
+	rows, err := s.db.Query(ctx, query, ankyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky reflections: %w", err)
+	}
+	defer rows.Close()
+
+	reflections := make([]*types.AnkyReflection, 0)
+	for rows.Next() {
+		reflection, err := scanAnkyReflection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky reflection: %w", err)
+		}
+		reflections = append(reflections, reflection)
+	}
+	return reflections, rows.Err()
+}
+
+// SetCanonicalAnkyReflection makes the version the Anky's reflection and
+// bumps the Anky's version. It wraps pgx.ErrNoRows when the Anky has no such
+// version.
+func (s *PostgresStore) SetCanonicalAnkyReflection(ctx context.Context, ankyID uuid.UUID, version int) (*types.AnkyReflection, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin setting canonical reflection: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	query := `SELECT ` + ankyReflectionColumns + ` FROM anky_reflections WHERE anky_id = $1 AND version = $2 FOR UPDATE`
+	reflection, err := scanAnkyReflection(tx.QueryRow(ctx, query, ankyID, version))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("anky %s has no reflection version %d: %w", ankyID, version, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky reflection: %w", classifyQueryError(ctx, err))
+	}
+
+	// Unset first, the partial unique index allows one canonical version per Anky
+	if _, err := tx.Exec(ctx, `UPDATE anky_reflections SET canonical = FALSE WHERE anky_id = $1 AND canonical AND version <> $2`, ankyID, version); err != nil {
+		return nil, fmt.Errorf("failed to unset canonical reflection: %w", classifyQueryError(ctx, err))
+	}
+	if _, err := tx.Exec(ctx, `UPDATE anky_reflections SET canonical = TRUE WHERE id = $1`, reflection.ID); err != nil {
+		return nil, fmt.Errorf("failed to set canonical reflection: %w", classifyQueryError(ctx, err))
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE ankys SET anky_reflection = $2, last_updated_at = $3, version = version + 1
+		WHERE id = $1
+	`, ankyID, reflection.Reflection, s.Clock().Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to update anky reflection: %w", classifyQueryError(ctx, err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit canonical reflection: %w", classifyQueryError(ctx, err))
+	}
+	reflection.Canonical = true
+	return reflection, nil
+}
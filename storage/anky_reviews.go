@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrReviewDecided = errors.New("this review already has a verdict")
+
+const ankyReviewColumns = `id, anky_id, reasons, detail, status, reviewer_id, note, created_at, reviewed_at`
+
+func scanAnkyReview(row pgx.Row) (*types.AnkyReview, error) {
+	review := new(types.AnkyReview)
+	if err := row.Scan(
+		&review.ID,
+		&review.AnkyID,
+		&review.Reasons,
+		&review.Detail,
+		&review.Status,
+		&review.ReviewerID,
+		&review.Note,
+		&review.CreatedAt,
+		&review.ReviewedAt,
+	); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// CreateAnkyReview puts the Anky in the review queue.
+func (s *PostgresStore) CreateAnkyReview(ctx context.Context, review *types.AnkyReview) error {
+	review.ID = s.IDs().NewID()
+	review.Status = types.ReviewPending
+	review.CreatedAt = s.Clock().Now()
+
+	query := `
+		INSERT INTO anky_reviews (id, anky_id, reasons, detail, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.db.Exec(ctx, query, review.ID, review.AnkyID, review.Reasons, review.Detail, review.Status, review.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create anky review: %w", err)
+	}
+	return nil
+}
+
+// GetAnkyReview returns the review, wrapping pgx.ErrNoRows when there is
+// none.
+func (s *PostgresStore) GetAnkyReview(ctx context.Context, id uuid.UUID) (*types.AnkyReview, error) {
+	query := `SELECT ` + ankyReviewColumns + ` FROM anky_reviews WHERE id = $1`
+	review, err := scanAnkyReview(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky review: %w", err)
+	}
+	return review, nil
+}
+
+// GetLatestAnkyReview returns the Anky's most recent review, wrapping
+// pgx.ErrNoRows when it was never held.
+func (s *PostgresStore) GetLatestAnkyReview(ctx context.Context, ankyID uuid.UUID) (*types.AnkyReview, error) {
+	query := `SELECT ` + ankyReviewColumns + ` FROM anky_reviews WHERE anky_id = $1 ORDER BY created_at DESC LIMIT 1`
+	review, err := scanAnkyReview(s.db.QueryRow(ctx, query, ankyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review of anky %s: %w", ankyID, err)
+	}
+	return review, nil
+}
+
+// GetAnkyReviews returns a page of the reviews with the status, pending ones
+// oldest first so the queue is worked in order, decided ones most recent
+// first.
+func (s *PostgresStore) GetAnkyReviews(ctx context.Context, status string, limit int, offset int) ([]*types.AnkyReview, error) {
+	order := "reviewed_at DESC"
+	if status == types.ReviewPending {
+		order = "created_at ASC"
+	}
+	query := `SELECT ` + ankyReviewColumns + ` FROM anky_reviews WHERE status = $1 ORDER BY ` + order + ` LIMIT $2 OFFSET $3`
+	rows, err := s.db.Query(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := []*types.AnkyReview{}
+	for rows.Next() {
+		review, err := scanAnkyReview(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky review: %w", err)
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+// CountPendingAnkyReviews counts the reviews waiting for a verdict and those
+// of them created before the SLA cutoff, and returns when the oldest one was
+// created.
+func (s *PostgresStore) CountPendingAnkyReviews(ctx context.Context, slaCutoff time.Time) (int, int, *time.Time, error) {
+	query := `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE created_at < $2), MIN(created_at)
+		FROM anky_reviews
+		WHERE status = $1`
+	var pending, breaching int
+	var oldest *time.Time
+	if err := s.db.QueryRow(ctx, query, types.ReviewPending, slaCutoff).Scan(&pending, &breaching, &oldest); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to count pending anky reviews: %w", err)
+	}
+	return pending, breaching, oldest, nil
+}
+
+// DecideAnkyReview records the moderator's verdict on a pending review. It
+// returns ErrReviewDecided when someone gave one first.
+func (s *PostgresStore) DecideAnkyReview(ctx context.Context, id uuid.UUID, status string, reviewerID uuid.UUID, note string) (*types.AnkyReview, error) {
+	query := `
+		UPDATE anky_reviews SET status = $2, reviewer_id = $3, note = $4, reviewed_at = $5
+		WHERE id = $1 AND status = $6
+		RETURNING ` + ankyReviewColumns
+	review, err := scanAnkyReview(s.db.QueryRow(ctx, query, id, status, reviewerID, note, s.Clock().Now(), types.ReviewPending))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Tell a missing review from a decided one
+		if _, err := s.GetAnkyReview(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrReviewDecided
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decide anky review: %w", err)
+	}
+	return review, nil
+}
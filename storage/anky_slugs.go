@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+)
+
+// ErrSlugTaken is returned when another Anky already has the slug.
+var ErrSlugTaken = errors.New("this slug is taken")
+
+// CreateAnkySlug gives the Anky of the session the slug and returns the slug
+// it ends up with: a session that already has one keeps it. A slug another
+// session has gets ErrSlugTaken.
+func (s *PostgresStore) CreateAnkySlug(ctx context.Context, sessionID uuid.UUID, slug string) (string, error) {
+	query := `
+		INSERT INTO anky_slugs (slug, session_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (session_id) DO NOTHING`
+	tag, err := s.db.Exec(ctx, query, slug, sessionID, s.Clock().Now().UTC())
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return "", ErrSlugTaken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create anky slug: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return s.GetAnkySlug(ctx, sessionID)
+	}
+	return slug, nil
+}
+
+// GetAnkySlug returns the slug of the Anky of the session, wrapping
+// pgx.ErrNoRows when it has none.
+func (s *PostgresStore) GetAnkySlug(ctx context.Context, sessionID uuid.UUID) (string, error) {
+	var slug string
+	if err := s.db.QueryRow(ctx, `SELECT slug FROM anky_slugs WHERE session_id = $1`, sessionID).Scan(&slug); err != nil {
+		return "", fmt.Errorf("failed to get anky slug: %w", err)
+	}
+	return slug, nil
+}
+
+// GetSessionIDBySlug returns the writing session of the Anky with the slug,
+// wrapping pgx.ErrNoRows when no Anky has it.
+func (s *PostgresStore) GetSessionIDBySlug(ctx context.Context, slug string) (uuid.UUID, error) {
+	var sessionID uuid.UUID
+	if err := s.db.QueryRow(ctx, `SELECT session_id FROM anky_slugs WHERE slug = $1`, slug).Scan(&sessionID); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get anky by slug: %w", err)
+	}
+	return sessionID, nil
+}
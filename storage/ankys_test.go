@@ -0,0 +1,131 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+func TestASessionBecomesOneAnky(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	first := createTestAnky(t, store, &types.Anky{})
+
+	second := types.NewAnky(first.WritingSessionID, first.ChosenPrompt, first.UserID)
+	if err := store.CreateAnky(ctx, second); !errors.Is(err, storage.ErrSessionHasAnky) {
+		t.Fatalf("second anky error = %v, want ErrSessionHasAnky", err)
+	}
+
+	// The year in review Anky hangs off a session that has its own
+	recap := types.NewAnky(first.WritingSessionID, "year in review 2026", first.UserID)
+	recap.YearInReview = true
+	if err := store.CreateAnky(ctx, recap); err != nil {
+		t.Fatalf("creating year in review anky: %v", err)
+	}
+
+	got, err := store.GetAnkyByWritingSessionID(ctx, first.WritingSessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != first.ID || got.YearInReview {
+		t.Errorf("the session's anky is %s, want %s and not the year in review one", got.ID, first.ID)
+	}
+	stored, err := store.GetAnkyByID(ctx, recap.ID)
+	if err != nil || !stored.YearInReview {
+		t.Errorf("year in review anky read back as %+v, %v", stored, err)
+	}
+}
+
+// UpdateAnky once bound the FID where the ID belonged, so it updated nothing.
+func TestUpdateAnkyWritesTheFIDToTheAnkyItIsGiven(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	anky := createTestAnky(t, store, &types.Anky{FID: 18350})
+	other := createTestAnky(t, store, &types.Anky{FID: 18350})
+
+	stored, err := store.GetAnkyByID(ctx, anky.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.FID = 20001
+	if err := store.UpdateAnky(ctx, stored); err != nil {
+		t.Fatalf("updating anky: %v", err)
+	}
+
+	got, err := store.GetAnkyByID(ctx, anky.ID)
+	if err != nil || got.FID != 20001 {
+		t.Fatalf("anky read back as %+v, %v, want fid 20001", got, err)
+	}
+	untouched, err := store.GetAnkyByID(ctx, other.ID)
+	if err != nil || untouched.FID != 18350 || untouched.Version != 1 {
+		t.Errorf("other anky read back as %+v, %v, want it untouched", untouched, err)
+	}
+}
+
+func TestConcurrentYearInReviewMintsCreateOneAnky(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	first := createTestAnky(t, store, &types.Anky{})
+	review := &types.YearInReview{UserID: first.UserID, Year: 2026, Narrative: "a year of mornings", GeneratedAt: time.Now().UTC()}
+	if err := store.SaveYearInReview(ctx, review); err != nil {
+		t.Fatal(err)
+	}
+
+	const mints = 5
+	ids := make(chan uuid.UUID, mints)
+	created := make(chan bool, mints)
+	var wg sync.WaitGroup
+	for i := 0; i < mints; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			anky := types.NewAnky(first.WritingSessionID, "year in review 2026", first.UserID)
+			anky.YearInReview = true
+			id, ok, err := store.MintYearInReviewAnky(ctx, first.UserID, 2026, anky)
+			if err != nil {
+				t.Errorf("minting: %v", err)
+				return
+			}
+			ids <- id
+			created <- ok
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	close(created)
+
+	minted := 0
+	for ok := range created {
+		if ok {
+			minted++
+		}
+	}
+	if minted != 1 {
+		t.Errorf("%d mints created an anky, want 1", minted)
+	}
+	var ankyID uuid.UUID
+	for id := range ids {
+		if ankyID == uuid.Nil {
+			ankyID = id
+		} else if id != ankyID {
+			t.Errorf("mints returned %s and %s, want the same anky", ankyID, id)
+		}
+	}
+
+	// Re-rendering the recap keeps it linked to its Anky
+	review.AnkyID = nil
+	if err := store.SaveYearInReview(ctx, review); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetYearInReview(ctx, first.UserID, 2026)
+	if err != nil || stored.AnkyID == nil || *stored.AnkyID != ankyID {
+		t.Errorf("year in review read back as %+v, %v, want it linked to %s", stored, err, ankyID)
+	}
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const announcementColumns = `id, kind, title, body, url, published_at, expires_at, created_at, updated_at`
+
+func scanAnnouncement(row pgx.Row, extra ...interface{}) (*types.Announcement, error) {
+	announcement := new(types.Announcement)
+	dest := []interface{}{
+		&announcement.ID,
+		&announcement.Kind,
+		&announcement.Title,
+		&announcement.Body,
+		&announcement.URL,
+		&announcement.PublishedAt,
+		&announcement.ExpiresAt,
+		&announcement.CreatedAt,
+		&announcement.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return announcement, nil
+}
+
+// CreateAnnouncement stores the announcement, published now unless it
+// already has a publish time.
+func (s *PostgresStore) CreateAnnouncement(ctx context.Context, announcement *types.Announcement) error {
+	now := s.Clock().Now()
+	announcement.ID = s.IDs().NewID()
+	announcement.CreatedAt = now
+	announcement.UpdatedAt = now
+	if announcement.PublishedAt.IsZero() {
+		announcement.PublishedAt = now
+	}
+
+	query := `
+		INSERT INTO announcements (` + announcementColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.db.Exec(ctx, query,
+		announcement.ID, announcement.Kind, announcement.Title, announcement.Body, announcement.URL,
+		announcement.PublishedAt, announcement.ExpiresAt, announcement.CreatedAt, announcement.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create announcement: %w", err)
+	}
+	return nil
+}
+
+// UpdateAnnouncement saves every field of the announcement but its ID and
+// creation time.
+func (s *PostgresStore) UpdateAnnouncement(ctx context.Context, announcement *types.Announcement) error {
+	announcement.UpdatedAt = s.Clock().Now()
+	query := `
+		UPDATE announcements SET
+			kind = $2,
+			title = $3,
+			body = $4,
+			url = $5,
+			published_at = $6,
+			expires_at = $7,
+			updated_at = $8
+		WHERE id = $1
+		RETURNING ` + announcementColumns
+	updated, err := scanAnnouncement(s.db.QueryRow(ctx, query,
+		announcement.ID, announcement.Kind, announcement.Title, announcement.Body, announcement.URL,
+		announcement.PublishedAt, announcement.ExpiresAt, announcement.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to update announcement: %w", err)
+	}
+	*announcement = *updated
+	return nil
+}
+
+// GetAnnouncement returns the announcement, wrapping pgx.ErrNoRows when there
+// is none.
+func (s *PostgresStore) GetAnnouncement(ctx context.Context, id uuid.UUID) (*types.Announcement, error) {
+	query := `SELECT ` + announcementColumns + ` FROM announcements WHERE id = $1`
+	announcement, err := scanAnnouncement(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get announcement: %w", err)
+	}
+	return announcement, nil
+}
+
+// DeleteAnnouncement deletes the announcement and reports whether it existed.
+func (s *PostgresStore) DeleteAnnouncement(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM announcements WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete announcement: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetAnnouncements returns a page of every announcement, scheduled and
+// expired ones included, most recently published first.
+func (s *PostgresStore) GetAnnouncements(ctx context.Context, limit int, offset int) ([]*types.Announcement, error) {
+	query := `SELECT ` + announcementColumns + ` FROM announcements ORDER BY published_at DESC LIMIT $1 OFFSET $2`
+	rows, err := s.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get announcements: %w", err)
+	}
+	defer rows.Close()
+
+	announcements := []*types.Announcement{}
+	for rows.Next() {
+		announcement, err := scanAnnouncement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan announcement: %w", err)
+		}
+		announcements = append(announcements, announcement)
+	}
+	return announcements, rows.Err()
+}
+
+// GetPublishedAnnouncements returns the announcements shown now that were
+// published after since, most recent first, with whether the user read them.
+func (s *PostgresStore) GetPublishedAnnouncements(ctx context.Context, userID uuid.UUID, since time.Time, limit int) ([]*types.Announcement, error) {
+	query := `
+		SELECT ` + announcementColumns + `,
+			EXISTS (SELECT 1 FROM announcement_reads r WHERE r.announcement_id = a.id AND r.user_id = $1)
+		FROM announcements a
+		WHERE published_at > $2 AND published_at <= $3 AND (expires_at IS NULL OR expires_at > $3)
+		ORDER BY published_at DESC
+		LIMIT $4`
+	rows, err := s.db.Query(ctx, query, userID, since, s.Clock().Now(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get announcements: %w", err)
+	}
+	defer rows.Close()
+
+	announcements := []*types.Announcement{}
+	for rows.Next() {
+		var read bool
+		announcement, err := scanAnnouncement(rows, &read)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan announcement: %w", err)
+		}
+		announcement.Read = read
+		announcements = append(announcements, announcement)
+	}
+	return announcements, rows.Err()
+}
+
+// MarkAnnouncementsRead marks the given announcements read by the user, every
+// one shown now when ids is empty, and returns how many weren't read yet.
+func (s *PostgresStore) MarkAnnouncementsRead(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (int64, error) {
+	now := s.Clock().Now()
+	query := `
+		INSERT INTO announcement_reads (user_id, announcement_id, read_at)
+		SELECT $1, id, $2
+		FROM announcements
+		WHERE published_at <= $2 AND (expires_at IS NULL OR expires_at > $2)
+			AND (cardinality($3::UUID[]) = 0 OR id = ANY($3))
+		ON CONFLICT (user_id, announcement_id) DO NOTHING`
+	if ids == nil {
+		ids = []uuid.UUID{}
+	}
+	tag, err := s.db.Exec(ctx, query, userID, now, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark announcements read: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
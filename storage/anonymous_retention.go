@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// CountAnonymousSessions counts the sessions of the anonymous user that
+// started before the given time and the Ankys made from them, and returns
+// when the oldest one started.
+func (s *PostgresStore) CountAnonymousSessions(ctx context.Context, startedBefore time.Time) (int, int, *time.Time, error) {
+	query := `
+		SELECT
+			COUNT(*),
+			(SELECT COUNT(*) FROM ankys a JOIN writing_sessions w ON w.id = a.writing_session_id
+				WHERE w.user_id = $1 AND w.starting_timestamp < $2),
+			MIN(starting_timestamp)
+		FROM writing_sessions
+		WHERE user_id = $1 AND starting_timestamp < $2`
+	var sessions, ankys int
+	var oldest *time.Time
+	if err := s.db.QueryRow(ctx, query, types.AnonymousUserID, startedBefore).Scan(&sessions, &ankys, &oldest); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to count anonymous sessions: %w", err)
+	}
+	return sessions, ankys, oldest, nil
+}
+
+// GetAnonymousSessions returns up to limit sessions of the anonymous user
+// that started before the given time, oldest first.
+func (s *PostgresStore) GetAnonymousSessions(ctx context.Context, startedBefore time.Time, limit int) ([]*types.WritingSession, error) {
+	query := `
+		SELECT ` + writingSessionColumns + ` FROM writing_sessions
+		WHERE user_id = $1 AND starting_timestamp < $2
+		ORDER BY starting_timestamp
+		LIMIT $3`
+	rows, err := s.db.Query(ctx, query, types.AnonymousUserID, startedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anonymous sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]*types.WritingSession, 0)
+	for rows.Next() {
+		session, err := scanIntoWritingSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteAnonymousSessions deletes the given sessions of the anonymous user
+// and the Ankys made from them in one transaction, and returns how many
+// Ankys went with them. Sessions of anyone else are left alone.
+func (s *PostgresStore) DeleteAnonymousSessions(ctx context.Context, sessionIDs []uuid.UUID) (int, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin deleting anonymous sessions: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	// Sessions and Ankys point at each other, so the links are cut first
+	sessions := `SELECT id FROM writing_sessions WHERE user_id = $1 AND id = ANY($2)`
+	if _, err := tx.Exec(ctx, `UPDATE writing_sessions SET anky_id = NULL WHERE id IN (`+sessions+`)`, types.AnonymousUserID, sessionIDs); err != nil {
+		return 0, fmt.Errorf("failed to unlink anonymous sessions: %w", classifyQueryError(ctx, err))
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM ankys WHERE writing_session_id IN (`+sessions+`)`, types.AnonymousUserID, sessionIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete ankys of anonymous sessions: %w", classifyQueryError(ctx, err))
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM writing_sessions WHERE user_id = $1 AND id = ANY($2)`, types.AnonymousUserID, sessionIDs); err != nil {
+		return 0, fmt.Errorf("failed to delete anonymous sessions: %w", classifyQueryError(ctx, err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit anonymous session deletion: %w", classifyQueryError(ctx, err))
+	}
+	return int(tag.RowsAffected()), nil
+}
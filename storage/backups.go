@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgx/v4"
+)
+
+// Schema backups are restored into. It only exists inside the restore's
+// transaction, which is always rolled back.
+const backupScratchSchema = "backup_verification"
+
+// BackupVerifiedTables are the tables a restore test loads and counts.
+var BackupVerifiedTables = []string{"users", "writing_sessions", "ankys"}
+
+// Data section of a plain pg_dump, e.g. COPY public.users (id, fid) FROM stdin;
+var copyStatementPattern = regexp.MustCompile(`^COPY (?:public\.)?"?(\w+)"? \((.*)\) FROM stdin;$`)
+
+// RestoreBackup loads the rows of BackupVerifiedTables from a plain format
+// pg_dump into scratch copies of the live tables and returns how many rows
+// each got. Nothing it loads outlives the call.
+func (s *PostgresStore) RestoreBackup(ctx context.Context, dump io.Reader) (map[string]int64, error) {
+	reader := bufio.NewReaderSize(dump, 64*1024)
+	if magic, err := reader.Peek(5); err == nil && string(magic) == "PGDMP" {
+		return nil, errors.New("custom format dumps can't be verified, take backups with pg_dump --format=plain")
+	}
+
+	conn, err := s.db.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin restore: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	// A restore takes longer than any regular query
+	if _, err := tx.Exec(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `CREATE SCHEMA `+backupScratchSchema); err != nil {
+		return nil, fmt.Errorf("failed to create scratch schema: %w", err)
+	}
+	verified := make(map[string]bool)
+	for _, table := range BackupVerifiedTables {
+		query := fmt.Sprintf(`CREATE TABLE %s.%s (LIKE public.%s INCLUDING DEFAULTS)`, backupScratchSchema, table, table)
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to create scratch %s: %w", table, err)
+		}
+		verified[table] = true
+	}
+
+	restored := make(map[string]int64)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+
+		match := copyStatementPattern.FindStringSubmatch(trimLineEnd(line))
+		if match == nil {
+			continue
+		}
+		block := &copyBlockReader{reader: reader}
+		if !verified[match[1]] {
+			if _, err := io.Copy(io.Discard, block); err != nil {
+				return nil, fmt.Errorf("failed to read backup: %w", err)
+			}
+			continue
+		}
+
+		copySQL := fmt.Sprintf(`COPY %s.%s (%s) FROM STDIN`, backupScratchSchema, match[1], match[2])
+		tag, err := tx.Conn().PgConn().CopyFrom(ctx, block, copySQL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", match[1], err)
+		}
+		restored[match[1]] = tag.RowsAffected()
+	}
+	return restored, nil
+}
+
+// copyBlockReader reads the rows of one COPY block, up to its \. terminator.
+type copyBlockReader struct {
+	reader *bufio.Reader
+	buf    []byte
+	done   bool
+}
+
+func (r *copyBlockReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		line, err := r.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if bytes.Equal(bytes.TrimRight(line, "\r\n"), []byte(`\.`)) {
+			r.done = true
+			return 0, io.EOF
+		}
+		if err == io.EOF {
+			if len(line) == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			r.done = true
+		}
+		r.buf = line
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func trimLineEnd(line string) string {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// CountRows returns the live row count of each of the tables.
+func (s *PostgresStore) CountRows(ctx context.Context, tables []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, table := range tables {
+		var count int64
+		if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM `+pgx.Identifier{table}.Sanitize()).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", table, err)
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
+
+func (s *PostgresStore) CreateBackupVerification(ctx context.Context, verification *types.BackupVerification) error {
+	checks, err := json.Marshal(verification.Checks)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO backup_verifications (backup_file, backup_taken_at, status, checks, error, started_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+	err = s.db.QueryRow(ctx, query,
+		verification.BackupFile,
+		verification.BackupTakenAt,
+		verification.Status,
+		checks,
+		verification.Error,
+		verification.StartedAt,
+		verification.FinishedAt,
+	).Scan(&verification.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create backup verification: %w", err)
+	}
+	return nil
+}
+
+const backupVerificationColumns = `id, backup_file, backup_taken_at, status, checks, error, started_at, finished_at`
+
+// GetBackupVerifications returns the most recent verifications first.
+func (s *PostgresStore) GetBackupVerifications(ctx context.Context, limit int, offset int) ([]*types.BackupVerification, error) {
+	query := `SELECT ` + backupVerificationColumns + ` FROM backup_verifications ORDER BY started_at DESC LIMIT $1 OFFSET $2`
+	rows, err := s.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup verifications: %w", err)
+	}
+	defer rows.Close()
+
+	verifications := []*types.BackupVerification{}
+	for rows.Next() {
+		verification := new(types.BackupVerification)
+		var checks []byte
+		err := rows.Scan(
+			&verification.ID,
+			&verification.BackupFile,
+			&verification.BackupTakenAt,
+			&verification.Status,
+			&checks,
+			&verification.Error,
+			&verification.StartedAt,
+			&verification.FinishedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan backup verification: %w", err)
+		}
+		if err := json.Unmarshal(checks, &verification.Checks); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal backup checks: %w", err)
+		}
+		verifications = append(verifications, verification)
+	}
+	return verifications, rows.Err()
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+)
+
+// ErrAlreadyPaired is returned when either writer got a buddy in the meantime.
+var ErrAlreadyPaired = errors.New("writer already has a buddy")
+
+// BuddyCandidate is an opted in writer waiting for a buddy.
+type BuddyCandidate struct {
+	UserID uuid.UUID
+	// IANA name from the user's metadata, empty when unknown
+	Timezone string
+}
+
+const buddyPairColumns = `pair_id, user_id, buddy_id, paired_at, ended_at`
+
+func (s *PostgresStore) OptInToBuddies(ctx context.Context, userID uuid.UUID) error {
+	query := `INSERT INTO buddy_opt_ins (user_id, opted_in_at) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING`
+	if _, err := s.db.Exec(ctx, query, userID, s.Clock().Now()); err != nil {
+		return fmt.Errorf("failed to opt in to buddies: %w", err)
+	}
+	return nil
+}
+
+// OptOutOfBuddies withdraws the writer from pairing and ends their current
+// pairing, if any, in one transaction.
+func (s *PostgresStore) OptOutOfBuddies(ctx context.Context, userID uuid.UUID) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return classifyQueryError(ctx, err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, `DELETE FROM buddy_opt_ins WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to opt out of buddies: %w", classifyQueryError(ctx, err))
+	}
+	if _, err := tx.Exec(ctx, endBuddyPairQuery, userID, s.Clock().Now()); err != nil {
+		return fmt.Errorf("failed to end buddy pairing: %w", classifyQueryError(ctx, err))
+	}
+	return classifyQueryError(ctx, tx.Commit(ctx))
+}
+
+func (s *PostgresStore) IsOptedInToBuddies(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var optedIn bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM buddy_opt_ins WHERE user_id = $1)`, userID).Scan(&optedIn)
+	if err != nil {
+		return false, fmt.Errorf("failed to check buddy opt in: %w", err)
+	}
+	return optedIn, nil
+}
+
+// GetBuddyCandidates returns the opted in writers without a buddy, the ones
+// waiting the longest first.
+func (s *PostgresStore) GetBuddyCandidates(ctx context.Context) ([]BuddyCandidate, error) {
+	query := `
+		SELECT o.user_id, COALESCE(m.timezone, '')
+		FROM buddy_opt_ins o
+		JOIN users u ON u.id = o.user_id
+		LEFT JOIN user_metadata m ON m.id = u.metadata_id
+		WHERE NOT EXISTS (SELECT 1 FROM writing_buddies b WHERE b.user_id = o.user_id AND b.ended_at IS NULL)
+		ORDER BY o.opted_in_at ASC`
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get buddy candidates: %w", err)
+	}
+	defer rows.Close()
+
+	candidates := []BuddyCandidate{}
+	for rows.Next() {
+		var candidate BuddyCandidate
+		if err := rows.Scan(&candidate.UserID, &candidate.Timezone); err != nil {
+			return nil, fmt.Errorf("failed to scan buddy candidate: %w", err)
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// GetPastBuddies returns everyone each of the writers was ever paired with.
+func (s *PostgresStore) GetPastBuddies(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	rows, err := s.db.Query(ctx, `SELECT user_id, buddy_id FROM writing_buddies WHERE user_id = ANY($1)`, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get past buddies: %w", err)
+	}
+	defer rows.Close()
+
+	past := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		var userID, buddyID uuid.UUID
+		if err := rows.Scan(&userID, &buddyID); err != nil {
+			return nil, fmt.Errorf("failed to scan past buddy: %w", err)
+		}
+		past[userID] = append(past[userID], buddyID)
+	}
+	return past, rows.Err()
+}
+
+// CreateBuddyPair pairs the two writers. It fails with ErrAlreadyPaired when
+// either of them already has a buddy.
+func (s *PostgresStore) CreateBuddyPair(ctx context.Context, userID uuid.UUID, buddyID uuid.UUID) (uuid.UUID, error) {
+	pairID := s.IDs().NewID()
+	query := `
+		INSERT INTO writing_buddies (pair_id, user_id, buddy_id, paired_at)
+		VALUES ($1, $2, $3, $4), ($1, $3, $2, $4)`
+	_, err := s.db.Exec(ctx, query, pairID, userID, buddyID, s.Clock().Now())
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return uuid.Nil, ErrAlreadyPaired
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to create buddy pair: %w", err)
+	}
+	return pairID, nil
+}
+
+// GetActiveBuddyPair returns the writer's side of their current pairing,
+// wrapping pgx.ErrNoRows when they have no buddy.
+func (s *PostgresStore) GetActiveBuddyPair(ctx context.Context, userID uuid.UUID) (*types.BuddyPair, error) {
+	query := `SELECT ` + buddyPairColumns + ` FROM writing_buddies WHERE user_id = $1 AND ended_at IS NULL`
+	pair := new(types.BuddyPair)
+	err := s.db.QueryRow(ctx, query, userID).Scan(&pair.PairID, &pair.UserID, &pair.BuddyID, &pair.PairedAt, &pair.EndedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get buddy pair: %w", err)
+	}
+	return pair, nil
+}
+
+// GetActiveBuddyPairs returns both sides of every current pairing.
+func (s *PostgresStore) GetActiveBuddyPairs(ctx context.Context) ([]*types.BuddyPair, error) {
+	query := `SELECT ` + buddyPairColumns + ` FROM writing_buddies WHERE ended_at IS NULL ORDER BY paired_at ASC`
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get buddy pairs: %w", err)
+	}
+	defer rows.Close()
+
+	pairs := []*types.BuddyPair{}
+	for rows.Next() {
+		pair := new(types.BuddyPair)
+		if err := rows.Scan(&pair.PairID, &pair.UserID, &pair.BuddyID, &pair.PairedAt, &pair.EndedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan buddy pair: %w", err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
+
+// Ends both sides of the pairing the writer is in
+const endBuddyPairQuery = `
+	UPDATE writing_buddies SET ended_at = $2
+	WHERE ended_at IS NULL AND pair_id IN (SELECT pair_id FROM writing_buddies WHERE user_id = $1 AND ended_at IS NULL)`
+
+// EndBuddyPair ends the writer's current pairing and reports whether they had one.
+func (s *PostgresStore) EndBuddyPair(ctx context.Context, userID uuid.UUID) (bool, error) {
+	tag, err := s.db.Exec(ctx, endBuddyPairQuery, userID, s.Clock().Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to end buddy pairing: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetBuddyName returns the Farcaster display name or username of the writer,
+// empty when they have neither.
+func (s *PostgresStore) GetBuddyName(ctx context.Context, userID uuid.UUID) (string, error) {
+	query := `
+		SELECT COALESCE(NULLIF(f.display_name, ''), NULLIF(f.username, ''), '')
+		FROM users u
+		LEFT JOIN farcaster_users f ON f.id = u.farcaster_user_id
+		WHERE u.id = $1`
+	var name string
+	if err := s.db.QueryRow(ctx, query, userID).Scan(&name); err != nil {
+		return "", fmt.Errorf("failed to get buddy name: %w", err)
+	}
+	return name, nil
+}
+
+// CreateBuddyNudge records a nudge. A writer is nudged for missing a day only
+// once, the result says whether this call recorded it.
+func (s *PostgresStore) CreateBuddyNudge(ctx context.Context, nudge *types.BuddyNudge) (bool, error) {
+	nudge.ID = s.IDs().NewID()
+	nudge.CreatedAt = s.Clock().Now()
+	query := `
+		INSERT INTO buddy_nudges (id, user_id, from_user_id, kind, missed_date, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::DATE, $6)
+		ON CONFLICT (user_id, missed_date) WHERE missed_date IS NOT NULL DO NOTHING`
+	tag, err := s.db.Exec(ctx, query, nudge.ID, nudge.UserID, nudge.FromUserID, nudge.Kind, nudge.MissedDate, nudge.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create buddy nudge: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetLastBuddyNudgeFrom returns when the writer last nudged their buddy by
+// hand, wrapping pgx.ErrNoRows when they never did.
+func (s *PostgresStore) GetLastBuddyNudgeFrom(ctx context.Context, fromUserID uuid.UUID) (*types.BuddyNudge, error) {
+	query := `
+		SELECT id, user_id, from_user_id, kind, COALESCE(to_char(missed_date, 'YYYY-MM-DD'), ''), created_at, read_at
+		FROM buddy_nudges
+		WHERE from_user_id = $1 AND kind = $2
+		ORDER BY created_at DESC
+		LIMIT 1`
+	nudge := new(types.BuddyNudge)
+	err := s.db.QueryRow(ctx, query, fromUserID, types.BuddyNudgeFromBuddy).Scan(
+		&nudge.ID, &nudge.UserID, &nudge.FromUserID, &nudge.Kind, &nudge.MissedDate, &nudge.CreatedAt, &nudge.ReadAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last buddy nudge: %w", err)
+	}
+	return nudge, nil
+}
+
+// GetBuddyNudges returns the writer's most recent nudges first.
+func (s *PostgresStore) GetBuddyNudges(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int) ([]*types.BuddyNudge, error) {
+	query := `
+		SELECT id, user_id, from_user_id, kind, COALESCE(to_char(missed_date, 'YYYY-MM-DD'), ''), created_at, read_at
+		FROM buddy_nudges
+		WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC
+		LIMIT $3`
+	rows, err := s.db.Query(ctx, query, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get buddy nudges: %w", err)
+	}
+	defer rows.Close()
+
+	nudges := []*types.BuddyNudge{}
+	for rows.Next() {
+		nudge := new(types.BuddyNudge)
+		if err := rows.Scan(&nudge.ID, &nudge.UserID, &nudge.FromUserID, &nudge.Kind, &nudge.MissedDate, &nudge.CreatedAt, &nudge.ReadAt); err != nil {
+			return nil, fmt.Errorf("failed to scan buddy nudge: %w", err)
+		}
+		nudges = append(nudges, nudge)
+	}
+	return nudges, rows.Err()
+}
+
+// MarkBuddyNudgesRead marks every unread nudge of the writer read and
+// returns how many there were.
+func (s *PostgresStore) MarkBuddyNudgesRead(ctx context.Context, userID uuid.UUID) (int64, error) {
+	tag, err := s.db.Exec(ctx, `UPDATE buddy_nudges SET read_at = $2 WHERE user_id = $1 AND read_at IS NULL`, userID, s.Clock().Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark buddy nudges read: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
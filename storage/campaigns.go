@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const campaignColumns = `id, name, description, banner_text, banner_url, newen_multiplier, prompt, starts_at, ends_at, created_at, updated_at`
+
+func scanCampaign(row pgx.Row) (*types.Campaign, error) {
+	campaign := new(types.Campaign)
+	if err := row.Scan(
+		&campaign.ID,
+		&campaign.Name,
+		&campaign.Description,
+		&campaign.BannerText,
+		&campaign.BannerURL,
+		&campaign.NewenMultiplier,
+		&campaign.Prompt,
+		&campaign.StartsAt,
+		&campaign.EndsAt,
+		&campaign.CreatedAt,
+		&campaign.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// CreateCampaign stores a new campaign.
+func (s *PostgresStore) CreateCampaign(ctx context.Context, campaign *types.Campaign) error {
+	now := s.Clock().Now()
+	campaign.ID = s.IDs().NewID()
+	campaign.CreatedAt = now
+	campaign.UpdatedAt = now
+
+	query := `
+		INSERT INTO campaigns (` + campaignColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err := s.db.Exec(ctx, query,
+		campaign.ID, campaign.Name, campaign.Description, campaign.BannerText, campaign.BannerURL,
+		campaign.NewenMultiplier, campaign.Prompt, campaign.StartsAt, campaign.EndsAt,
+		campaign.CreatedAt, campaign.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create campaign: %w", err)
+	}
+	return nil
+}
+
+// UpdateCampaign saves every field of the campaign but its ID and creation
+// time.
+func (s *PostgresStore) UpdateCampaign(ctx context.Context, campaign *types.Campaign) error {
+	campaign.UpdatedAt = s.Clock().Now()
+	query := `
+		UPDATE campaigns SET
+			name = $2,
+			description = $3,
+			banner_text = $4,
+			banner_url = $5,
+			newen_multiplier = $6,
+			prompt = $7,
+			starts_at = $8,
+			ends_at = $9,
+			updated_at = $10
+		WHERE id = $1
+		RETURNING ` + campaignColumns
+	updated, err := scanCampaign(s.db.QueryRow(ctx, query,
+		campaign.ID, campaign.Name, campaign.Description, campaign.BannerText, campaign.BannerURL,
+		campaign.NewenMultiplier, campaign.Prompt, campaign.StartsAt, campaign.EndsAt, campaign.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to update campaign: %w", err)
+	}
+	*campaign = *updated
+	return nil
+}
+
+// GetCampaign returns the campaign, wrapping pgx.ErrNoRows when there is
+// none.
+func (s *PostgresStore) GetCampaign(ctx context.Context, id uuid.UUID) (*types.Campaign, error) {
+	query := `SELECT ` + campaignColumns + ` FROM campaigns WHERE id = $1`
+	campaign, err := scanCampaign(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign: %w", err)
+	}
+	return campaign, nil
+}
+
+// DeleteCampaign deletes the campaign and reports whether it existed.
+func (s *PostgresStore) DeleteCampaign(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM campaigns WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete campaign: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetCampaigns returns a page of every campaign, past and upcoming ones
+// included, latest start first.
+func (s *PostgresStore) GetCampaigns(ctx context.Context, limit int, offset int) ([]*types.Campaign, error) {
+	query := `SELECT ` + campaignColumns + ` FROM campaigns ORDER BY starts_at DESC LIMIT $1 OFFSET $2`
+	return s.queryCampaigns(ctx, query, limit, offset)
+}
+
+// GetActiveCampaigns returns the campaigns running at the given time, latest
+// start first.
+func (s *PostgresStore) GetActiveCampaigns(ctx context.Context, at time.Time) ([]*types.Campaign, error) {
+	query := `SELECT ` + campaignColumns + ` FROM campaigns WHERE starts_at <= $1 AND ends_at > $1 ORDER BY starts_at DESC`
+	return s.queryCampaigns(ctx, query, at)
+}
+
+func (s *PostgresStore) queryCampaigns(ctx context.Context, query string, args ...interface{}) ([]*types.Campaign, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaigns: %w", err)
+	}
+	defer rows.Close()
+
+	campaigns := []*types.Campaign{}
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan campaign: %w", err)
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, rows.Err()
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// GetAnkysAwaitingToken returns the cast Ankys with a ticker whose token
+// wasn't found yet, created after since, the ones checked longest ago first.
+func (s *PostgresStore) GetAnkysAwaitingToken(ctx context.Context, since time.Time, limit int) ([]*types.Anky, error) {
+	query := `
+		SELECT ` + ankyColumns + ` FROM ankys
+		WHERE token_address = '' AND cast_hash <> '' AND ticker <> '' AND created_at >= $1
+		ORDER BY token_checked_at ASC NULLS FIRST
+		LIMIT $2`
+	rows, err := s.db.Query(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys awaiting their token: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// MarkAnkyTokenChecked records a look for the Anky's token, and the token
+// when one was found.
+func (s *PostgresStore) MarkAnkyTokenChecked(ctx context.Context, ankyID uuid.UUID, tokenAddress string) error {
+	query := `
+		UPDATE ankys SET
+			token_checked_at = NOW(),
+			token_address = CASE WHEN $2 <> '' THEN $2 ELSE token_address END,
+			token_deployed_at = CASE WHEN $2 <> '' THEN COALESCE(token_deployed_at, NOW()) ELSE token_deployed_at END
+		WHERE id = $1`
+	if _, err := s.db.Exec(ctx, query, ankyID, tokenAddress); err != nil {
+		return fmt.Errorf("failed to mark anky token checked: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
+	"github.com/google/uuid"
+)
+
+var testNow = time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+
+func TestMemoryStoreStampsWithItsClockAndIDs(t *testing.T) {
+	store := storage.NewMemoryTestStorage()
+	store.SetClock(utils.NewFixedClock(testNow))
+	store.SetIDGenerator(utils.NewSequentialIDs())
+	ctx := context.Background()
+
+	user := &types.User{}
+	if err := store.CreateUserWithRelations(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	anky := &types.Anky{UserID: user.ID}
+	if err := store.CreateAnky(ctx, anky); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID.String() != "00000000-0000-0000-0000-000000000001" || anky.ID.String() != "00000000-0000-0000-0000-000000000002" {
+		t.Errorf("ids = %s, %s, want the generator's first two", user.ID, anky.ID)
+	}
+	if !anky.CreatedAt.Equal(testNow) || !anky.LastUpdatedAt.Equal(testNow) {
+		t.Errorf("anky stamped %v / %v, want %v", anky.CreatedAt, anky.LastUpdatedAt, testNow)
+	}
+}
+
+func TestPostgresStoreStampsWithItsClockAndIDs(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	anky := createTestAnky(t, store, &types.Anky{Status: "completed"})
+
+	clock := utils.NewFixedClock(testNow)
+	store.SetClock(clock)
+	store.SetIDGenerator(utils.NewSequentialIDs())
+	if store.Clock() != clock {
+		t.Fatal("Clock is not the one set")
+	}
+
+	snapshot := &types.AnkyMarketSnapshot{AnkyID: anky.ID, TokenAddress: "0xtoken", ChainID: "8453", Source: "test"}
+	if err := store.CreateAnkyMarketSnapshot(ctx, snapshot); err != nil {
+		t.Fatalf("creating snapshot: %v", err)
+	}
+	clock.Advance(time.Hour)
+	if err := store.CreateAnkyMarketSnapshot(ctx, &types.AnkyMarketSnapshot{AnkyID: anky.ID, TokenAddress: "0xtoken", ChainID: "8453", Source: "test"}); err != nil {
+		t.Fatalf("creating snapshot: %v", err)
+	}
+
+	snapshots, err := store.GetAnkyMarketSnapshots(ctx, anky.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("got %d snapshots, want 2", len(snapshots))
+	}
+	byID := map[uuid.UUID]time.Time{}
+	for _, s := range snapshots {
+		byID[s.ID] = s.CapturedAt.UTC()
+	}
+	first, second := uuid.MustParse("00000000-0000-0000-0000-000000000001"), uuid.MustParse("00000000-0000-0000-0000-000000000002")
+	if !byID[first].Equal(testNow) || !byID[second].Equal(testNow.Add(time.Hour)) {
+		t.Errorf("snapshots = %v, want the sequential IDs captured at the fixed clock's times", byID)
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// CreateFarcasterMention records a cast addressed to Anky. Neynar retries
+// webhooks, so a cast is recorded once; the result says whether this call
+// recorded it.
+func (s *PostgresStore) CreateFarcasterMention(ctx context.Context, mention *types.FarcasterMention) (bool, error) {
+	mention.ReceivedAt = s.Clock().Now()
+	query := `
+		INSERT INTO farcaster_mentions (cast_hash, author_fid, author_username, text, kind, parent_hash, thread_hash, received_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+		ON CONFLICT (cast_hash) DO NOTHING`
+	tag, err := s.db.Exec(ctx, query,
+		mention.CastHash, mention.AuthorFID, mention.AuthorUsername, mention.Text, mention.Kind,
+		mention.ParentHash, mention.ThreadHash, mention.ReceivedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create farcaster mention: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ClaimFarcasterMentionReply reserves one of the author's replies for the
+// cast, unless Anky already answered or is answering maxReplies of their
+// casts received since the given time. Replies that failed don't count. The
+// author's claims are serialized, so a burst of mentions can't go over the
+// limit. It reports whether the reply was claimed.
+func (s *PostgresStore) ClaimFarcasterMentionReply(ctx context.Context, castHash string, authorFID int, since time.Time, maxReplies int) (bool, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin claiming farcaster reply: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, fmt.Sprintf("farcaster-replies-%d", authorFID)); err != nil {
+		return false, fmt.Errorf("failed to lock farcaster replies: %w", classifyQueryError(ctx, err))
+	}
+	query := `
+		UPDATE farcaster_mentions SET reply_claimed_at = $4
+		WHERE cast_hash = $1 AND reply_claimed_at IS NULL AND (
+			SELECT COUNT(*) FROM farcaster_mentions
+			WHERE author_fid = $2 AND received_at >= $3
+				AND reply_claimed_at IS NOT NULL AND (reply_hash IS NOT NULL OR reply_error IS NULL)
+		) < $5`
+	tag, err := tx.Exec(ctx, query, castHash, authorFID, since, s.Clock().Now(), maxReplies)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim farcaster reply: %w", classifyQueryError(ctx, err))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit farcaster reply claim: %w", classifyQueryError(ctx, err))
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordFarcasterMentionReply stores Anky's reply to the cast, or why it
+// couldn't reply when replyErr isn't empty, which gives back a claimed reply.
+func (s *PostgresStore) RecordFarcasterMentionReply(ctx context.Context, castHash string, replyHash string, replyErr string) error {
+	query := `
+		UPDATE farcaster_mentions SET
+			reply_hash = NULLIF($2, ''),
+			replied_at = CASE WHEN $2 = '' THEN NULL ELSE $3::TIMESTAMPTZ END,
+			reply_error = NULLIF($4, '')
+		WHERE cast_hash = $1`
+	if _, err := s.db.Exec(ctx, query, castHash, replyHash, s.Clock().Now(), replyErr); err != nil {
+		return fmt.Errorf("failed to record farcaster mention reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+func TestConcurrentMentionsShareTheDailyReplyLimit(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+
+	const maxReplies = 2
+	authorFID := int(time.Now().UnixNano() % 1_000_000_000)
+	since := store.Clock().Now().Add(-24 * time.Hour)
+	var hashes []string
+	for i := 0; i < 6; i++ {
+		mention := &types.FarcasterMention{
+			CastHash:  fmt.Sprintf("0x%s", uuid.NewString()),
+			AuthorFID: authorFID,
+			Text:      "@anky what do you see?",
+			Kind:      types.FarcasterMentionMention,
+		}
+		if _, err := store.CreateFarcasterMention(ctx, mention); err != nil {
+			t.Fatalf("creating mention: %v", err)
+		}
+		hashes = append(hashes, mention.CastHash)
+	}
+
+	var wg sync.WaitGroup
+	claims := make(chan string, len(hashes))
+	for _, hash := range hashes {
+		wg.Add(1)
+		go func(hash string) {
+			defer wg.Done()
+			claimed, err := store.ClaimFarcasterMentionReply(ctx, hash, authorFID, since, maxReplies)
+			if err != nil {
+				t.Errorf("claiming: %v", err)
+				return
+			}
+			if claimed {
+				claims <- hash
+			}
+		}(hash)
+	}
+	wg.Wait()
+	close(claims)
+
+	var claimed []string
+	for hash := range claims {
+		claimed = append(claimed, hash)
+	}
+	if len(claimed) != maxReplies {
+		t.Fatalf("%d replies were claimed, want %d", len(claimed), maxReplies)
+	}
+
+	// A reply that failed gives its slot back
+	if err := store.RecordFarcasterMentionReply(ctx, claimed[0], "", "neynar is down"); err != nil {
+		t.Fatalf("recording failed reply: %v", err)
+	}
+	released := 0
+	for _, hash := range hashes {
+		ok, err := store.ClaimFarcasterMentionReply(ctx, hash, authorFID, since, maxReplies)
+		if err != nil {
+			t.Fatalf("claiming: %v", err)
+		}
+		if ok {
+			released++
+		}
+	}
+	if released != 1 {
+		t.Errorf("%d replies were claimed after a failure, want 1", released)
+	}
+}
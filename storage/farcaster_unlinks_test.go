@@ -0,0 +1,61 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+)
+
+func TestConcurrentUnlinksStayWithinTheLimit(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	user := createTestUser(t, store, false)
+
+	const maxUnlinks = 1
+	fid := int(time.Now().UnixNano() % 1_000_000_000)
+	if err := store.LinkFarcasterUser(ctx, user.ID, &types.FarcasterUser{FID: fid, Username: "writer"}); err != nil {
+		t.Fatalf("linking: %v", err)
+	}
+
+	const attempts = 5
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	unlinked, refused := 0, 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, _, err := store.UnlinkFarcasterUser(ctx, user.ID, "lost signer", maxUnlinks)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, storage.ErrUnlinkLimitReached):
+				refused++
+			case err != nil:
+				t.Errorf("unlinking: %v", err)
+			case got == fid:
+				unlinked++
+			}
+		}()
+	}
+	wg.Wait()
+	if unlinked != 1 {
+		t.Errorf("%d unlinks removed the FID, want 1", unlinked)
+	}
+	if refused != attempts-1 {
+		t.Errorf("%d unlinks were refused, want %d", refused, attempts-1)
+	}
+
+	if err := store.LinkFarcasterUser(ctx, user.ID, &types.FarcasterUser{FID: fid + 1, Username: "writer"}); err != nil {
+		t.Fatalf("linking again: %v", err)
+	}
+	if _, unlinks, err := store.UnlinkFarcasterUser(ctx, user.ID, "lost signer", maxUnlinks); !errors.Is(err, storage.ErrUnlinkLimitReached) || unlinks != maxUnlinks {
+		t.Errorf("unlinking past the limit = (%d, %v), want (%d, ErrUnlinkLimitReached)", unlinks, err, maxUnlinks)
+	}
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ConsumeFramesSubmission counts a frames submission of the FID for the UTC
+// day, unless the FID already submitted dailyLimit times that day. It returns
+// the submissions made that day and whether this one was allowed.
+func (s *PostgresStore) ConsumeFramesSubmission(ctx context.Context, fid int, dailyLimit int) (int, bool, error) {
+	day := s.Clock().Now().UTC().Format("2006-01-02")
+	query := `
+		INSERT INTO frames_usage (fid, day, submissions)
+		VALUES ($1, $2::date, 1)
+		ON CONFLICT (fid, day) DO UPDATE SET submissions = frames_usage.submissions + 1
+		WHERE frames_usage.submissions < $3
+		RETURNING submissions`
+	var submissions int
+	err := s.db.QueryRow(ctx, query, fid, day, dailyLimit).Scan(&submissions)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return dailyLimit, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to record frames submission: %w", err)
+	}
+	return submissions, true, nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgx/v4"
+)
+
+// ClaimIdempotencyKey records that a request with the key started. It returns
+// nil when the caller claimed the key and should handle the request, or the
+// record of the request that holds it. Keys whose request started before
+// abandonedBefore without completing, or completed before expiredBefore, are
+// claimed again.
+func (s *PostgresStore) ClaimIdempotencyKey(ctx context.Context, route string, key string, requestHash string, abandonedBefore time.Time, expiredBefore time.Time) (*types.IdempotencyRecord, error) {
+	query := `
+		INSERT INTO idempotency_keys (route, key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (route, key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			created_at = EXCLUDED.created_at,
+			status_code = NULL,
+			content_type = NULL,
+			response = NULL,
+			completed_at = NULL
+		WHERE (idempotency_keys.completed_at IS NULL AND idempotency_keys.created_at < $5)
+			OR idempotency_keys.completed_at < $6
+		RETURNING route`
+	var claimed string
+	err := s.db.QueryRow(ctx, query, route, key, requestHash, s.Clock().Now(), abandonedBefore, expiredBefore).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+
+	record := &types.IdempotencyRecord{Route: route, Key: key}
+	var statusCode *int
+	var contentType *string
+	err = s.db.QueryRow(ctx, `
+		SELECT request_hash, status_code, content_type, response, created_at, completed_at
+		FROM idempotency_keys WHERE route = $1 AND key = $2`, route, key).Scan(
+		&record.RequestHash,
+		&statusCode,
+		&contentType,
+		&record.Response,
+		&record.CreatedAt,
+		&record.CompletedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	if statusCode != nil {
+		record.StatusCode = *statusCode
+	}
+	if contentType != nil {
+		record.ContentType = *contentType
+	}
+	return record, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for the key.
+func (s *PostgresStore) CompleteIdempotencyKey(ctx context.Context, route string, key string, statusCode int, contentType string, response []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, content_type = $2, response = $3, completed_at = $4
+		WHERE route = $5 AND key = $6`
+	_, err := s.db.Exec(ctx, query, statusCode, contentType, response, s.Clock().Now(), route, key)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a key whose request failed, so a retry runs again.
+func (s *PostgresStore) ReleaseIdempotencyKey(ctx context.Context, route string, key string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE route = $1 AND key = $2 AND completed_at IS NULL`, route, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteIdempotencyKeysBefore deletes the keys claimed before the given time
+// and returns how many were deleted.
+func (s *PostgresStore) DeleteIdempotencyKeysBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete idempotency keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
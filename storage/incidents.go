@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const incidentColumns = `id, title, message, severity, status, components, started_at, updated_at, resolved_at`
+
+func scanIncident(row pgx.Row) (*types.Incident, error) {
+	incident := new(types.Incident)
+	err := row.Scan(
+		&incident.ID,
+		&incident.Title,
+		&incident.Message,
+		&incident.Severity,
+		&incident.Status,
+		&incident.Components,
+		&incident.StartedAt,
+		&incident.UpdatedAt,
+		&incident.ResolvedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
+
+func (s *PostgresStore) CreateIncident(ctx context.Context, incident *types.Incident) error {
+	now := s.Clock().Now()
+	incident.ID = s.IDs().NewID()
+	incident.StartedAt = now
+	incident.UpdatedAt = now
+	if incident.Status == types.IncidentResolved {
+		incident.ResolvedAt = &now
+	}
+	if incident.Components == nil {
+		incident.Components = []string{}
+	}
+
+	query := `
+		INSERT INTO incidents (` + incidentColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.db.Exec(ctx, query,
+		incident.ID, incident.Title, incident.Message, incident.Severity, incident.Status,
+		incident.Components, incident.StartedAt, incident.UpdatedAt, incident.ResolvedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create incident: %w", err)
+	}
+	return nil
+}
+
+// UpdateIncident saves the incident's title, message, severity, status and
+// components. Moving it to resolved stamps resolved_at, moving it out of
+// resolved clears it again.
+func (s *PostgresStore) UpdateIncident(ctx context.Context, incident *types.Incident) error {
+	incident.UpdatedAt = s.Clock().Now()
+	query := `
+		UPDATE incidents SET
+			title = $2,
+			message = $3,
+			severity = $4,
+			status = $5,
+			components = $6,
+			updated_at = $7,
+			resolved_at = CASE WHEN $5 = 'resolved' THEN COALESCE(resolved_at, $7) END
+		WHERE id = $1
+		RETURNING ` + incidentColumns
+	updated, err := scanIncident(s.db.QueryRow(ctx, query,
+		incident.ID, incident.Title, incident.Message, incident.Severity, incident.Status,
+		incident.Components, incident.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to update incident: %w", err)
+	}
+	*incident = *updated
+	return nil
+}
+
+// GetIncident returns the incident, wrapping pgx.ErrNoRows when there is none.
+func (s *PostgresStore) GetIncident(ctx context.Context, id uuid.UUID) (*types.Incident, error) {
+	query := `SELECT ` + incidentColumns + ` FROM incidents WHERE id = $1`
+	incident, err := scanIncident(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get incident: %w", err)
+	}
+	return incident, nil
+}
+
+// GetActiveIncidents returns the unresolved incidents, most recent first.
+func (s *PostgresStore) GetActiveIncidents(ctx context.Context) ([]*types.Incident, error) {
+	query := `SELECT ` + incidentColumns + ` FROM incidents WHERE resolved_at IS NULL ORDER BY started_at DESC`
+	return s.queryIncidents(ctx, query)
+}
+
+// GetIncidents returns a page of every incident, most recent first.
+func (s *PostgresStore) GetIncidents(ctx context.Context, limit int, offset int) ([]*types.Incident, error) {
+	query := `SELECT ` + incidentColumns + ` FROM incidents ORDER BY started_at DESC LIMIT $1 OFFSET $2`
+	return s.queryIncidents(ctx, query, limit, offset)
+}
+
+func (s *PostgresStore) queryIncidents(ctx context.Context, query string, args ...interface{}) ([]*types.Incident, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get incidents: %w", err)
+	}
+	defer rows.Close()
+
+	incidents := []*types.Incident{}
+	for rows.Next() {
+		incident, err := scanIncident(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan incident: %w", err)
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, rows.Err()
+}
+
+// Ping checks that the database answers, under the usual query timeout.
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	return classifyQueryError(ctx, s.db.pool.Ping(ctx))
+}
+
+// GetQueueDepths counts the Ankys still in the pipeline or waiting to be
+// cast, and the FID requests waiting for a review.
+func (s *PostgresStore) GetQueueDepths(ctx context.Context) (*types.QueueDepths, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM ankys WHERE status NOT IN ('completed', 'pending_to_cast', 'unpublished')),
+			(SELECT COUNT(*) FROM ankys WHERE status = 'pending_to_cast'),
+			(SELECT COUNT(*) FROM fid_requests WHERE status = $1)`
+	depths := new(types.QueueDepths)
+	err := s.db.QueryRow(ctx, query, types.FIDRequestPending).Scan(
+		&depths.AnkysProcessing,
+		&depths.AnkysAwaitingCast,
+		&depths.FIDRequestsReview,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue depths: %w", err)
+	}
+	return depths, nil
+}
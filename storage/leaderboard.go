@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+// leaderboardScores selects user_id and value for each metric, counting only
+// what happened at or after $1.
+var leaderboardScores = map[string]string{
+	types.LeaderboardMetricWords: `
+		SELECT user_id, SUM(words_written)::BIGINT AS value
+		FROM writing_sessions
+		WHERE user_id IS NOT NULL AND ending_timestamp >= $1
+		GROUP BY user_id`,
+	types.LeaderboardMetricSessions: `
+		SELECT user_id, COUNT(*)::BIGINT AS value
+		FROM writing_sessions
+		WHERE user_id IS NOT NULL AND ending_timestamp >= $1
+		GROUP BY user_id`,
+	types.LeaderboardMetricNewen: `
+		SELECT user_id, SUM(amount)::BIGINT AS value
+		FROM newen_transactions
+		WHERE source = 'writing_reward' AND created_at >= $1
+		GROUP BY user_id`,
+}
+
+// GetLeaderboard ranks writers by metric over the period, best first. Writers
+// who opted out in their settings, or whose account is being deleted, are
+// left out and don't take up a rank.
+func (s *PostgresStore) GetLeaderboard(ctx context.Context, metric string, period string, limit int, offset int) ([]*types.LeaderboardEntry, error) {
+	scores, ok := leaderboardScores[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown leaderboard metric %q", metric)
+	}
+	var since time.Time
+	switch period {
+	case types.LeaderboardPeriodDay:
+		since = s.Clock().Now().UTC().Add(-24 * time.Hour)
+	case types.LeaderboardPeriodWeek:
+		since = s.Clock().Now().UTC().AddDate(0, 0, -7)
+	case types.LeaderboardPeriodAll:
+	default:
+		return nil, fmt.Errorf("unknown leaderboard period %q", period)
+	}
+
+	query := `
+		WITH scores AS (` + scores + `)
+		SELECT
+			RANK() OVER (ORDER BY sc.value DESC),
+			u.id,
+			COALESCE(NULLIF(u.settings->>'username', ''), fu.username, ''),
+			COALESCE(NULLIF(u.settings->>'display_name', ''), fu.display_name, ''),
+			COALESCE(NULLIF(u.settings->>'profile_picture', ''), fu.pfp_url, ''),
+			sc.value
+		FROM scores sc
+		JOIN users u ON u.id = sc.user_id
+		LEFT JOIN farcaster_users fu ON fu.id = u.farcaster_user_id
+		WHERE sc.value > 0 AND COALESCE((u.settings->>'hide_from_leaderboard')::BOOLEAN, FALSE) = FALSE
+			AND NOT EXISTS (SELECT 1 FROM user_deletions d WHERE d.user_id = u.id)
+		ORDER BY sc.value DESC, u.id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.Query(ctx, query, since, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get leaderboard: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*types.LeaderboardEntry{}
+	for rows.Next() {
+		entry := new(types.LeaderboardEntry)
+		if err := rows.Scan(&entry.Rank, &entry.UserID, &entry.Username, &entry.DisplayName, &entry.ProfilePicture, &entry.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan leaderboard entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
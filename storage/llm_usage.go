@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// ConsumeLLMRequest counts one LLM request of inputBytes against the user's
+// usage of the UTC day, unless the user already made dailyLimit requests that
+// day. It returns the requests made that day and whether this one was allowed.
+func (s *PostgresStore) ConsumeLLMRequest(ctx context.Context, userID uuid.UUID, inputBytes int64, dailyLimit int) (int, bool, error) {
+	day := s.Clock().Now().UTC().Format("2006-01-02")
+	query := `
+		INSERT INTO llm_usage (user_id, day, requests, input_bytes)
+		VALUES ($1, $2::date, 1, $3)
+		ON CONFLICT (user_id, day) DO UPDATE SET
+			requests = llm_usage.requests + 1,
+			input_bytes = llm_usage.input_bytes + EXCLUDED.input_bytes
+		WHERE llm_usage.requests < $4
+		RETURNING requests`
+	var requests int
+	err := s.db.QueryRow(ctx, query, userID, day, inputBytes, dailyLimit).Scan(&requests)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return dailyLimit, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to record llm usage: %w", err)
+	}
+	return requests, true, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// errLockLost is returned by Check once the connection the lock was taken on
+// is gone, and with it the lock.
+var errLockLost = errors.New("the connection holding the advisory lock was lost")
+
+// lockConn is the one connection every advisory lock of the instance is held
+// on. It lives outside the pool, so leader jobs don't keep the connections
+// requests need for as long as they run.
+type lockConn struct {
+	config *pgx.ConnConfig
+
+	// pgx connections aren't safe for concurrent use
+	mu   sync.Mutex
+	conn *pgx.Conn
+	// Bumped on every new connection, locks taken on an older one are gone
+	generation int
+	// Session locks are reentrant, so the ones held are tracked to not take
+	// a lock twice
+	held map[string]bool
+}
+
+func newLockConn(config *pgx.ConnConfig) *lockConn {
+	return &lockConn{config: config, held: make(map[string]bool)}
+}
+
+// connect must be called with l.mu held.
+func (l *lockConn) connect(ctx context.Context) (*pgx.Conn, error) {
+	if l.conn != nil && !l.conn.IsClosed() {
+		return l.conn, nil
+	}
+	conn, err := pgx.ConnectConfig(ctx, l.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect for advisory locks: %w", err)
+	}
+	l.conn = conn
+	l.generation++
+	l.held = make(map[string]bool)
+	return conn, nil
+}
+
+// drop closes the connection, releasing every lock held on it. It must be
+// called with l.mu held.
+func (l *lockConn) drop() {
+	if l.conn != nil {
+		l.conn.Close(context.Background())
+		l.conn = nil
+	}
+	l.held = make(map[string]bool)
+}
+
+func (l *lockConn) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.drop()
+}
+
+// AdvisoryLock is a session level Postgres advisory lock. Every lock of the
+// instance is held on one connection of its own, so Postgres releases them by
+// itself when the instance holding them dies.
+type AdvisoryLock struct {
+	locks      *lockConn
+	name       string
+	generation int
+}
+
+// TryAdvisoryLock takes the advisory lock called name without waiting. It
+// returns nil when another connection already holds it.
+func (s *PostgresStore) TryAdvisoryLock(ctx context.Context, name string) (*AdvisoryLock, error) {
+	l := s.locks
+	if l == nil {
+		return nil, fmt.Errorf("advisory locks need a database connection")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.held[name] {
+		return nil, nil
+	}
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lockCtx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	var locked bool
+	err = conn.QueryRow(lockCtx, `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`, name).Scan(&locked)
+	if err != nil {
+		// A query cut short leaves the connection unusable
+		l.drop()
+		return nil, fmt.Errorf("failed to take advisory lock %s: %w", name, classifyQueryError(lockCtx, err))
+	}
+	if !locked {
+		return nil, nil
+	}
+	l.held[name] = true
+	return &AdvisoryLock{locks: l, name: name, generation: l.generation}, nil
+}
+
+// Check fails once the connection holding the lock is gone, and with it the lock.
+func (lock *AdvisoryLock) Check(ctx context.Context) error {
+	l := lock.locks
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil || l.generation != lock.generation {
+		return errLockLost
+	}
+	if err := l.conn.Ping(ctx); err != nil {
+		l.drop()
+		return err
+	}
+	return nil
+}
+
+// Release unlocks, unless the lock was already lost with its connection.
+func (lock *AdvisoryLock) Release() {
+	l := lock.locks
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil || l.generation != lock.generation {
+		return
+	}
+	delete(l.held, lock.name)
+	_, err := l.conn.Exec(context.Background(), `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, lock.name)
+	if err != nil {
+		// The lock may still be held; dropping the connection releases it,
+		// and the other jobs find out they lost theirs when they check
+		l.drop()
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
 	"github.com/google/uuid"
 )
 
@@ -19,6 +19,8 @@ type MemoryTestStorage struct {
 	sessions   map[uuid.UUID]*types.WritingSession
 	ankys      map[uuid.UUID]*types.Anky
 	badges     map[uuid.UUID]*types.Badge
+	clock      utils.Clock
+	ids        utils.IDGenerator
 }
 
 // NewMemoryTestStorage creates a new test storage instance
@@ -30,16 +32,28 @@ func NewMemoryTestStorage() *MemoryTestStorage {
 		sessions:   make(map[uuid.UUID]*types.WritingSession),
 		ankys:      make(map[uuid.UUID]*types.Anky),
 		badges:     make(map[uuid.UUID]*types.Badge),
+		clock:      utils.SystemClock,
+		ids:        utils.RandomIDs,
 	}
 }
 
-// CreateUser implements Storage interface for testing
-func (s *MemoryTestStorage) CreateUser(ctx context.Context, user *types.User) error {
+// SetClock pins the time records are stamped with.
+func (s *MemoryTestStorage) SetClock(clock utils.Clock) {
+	s.clock = clock
+}
+
+// SetIDGenerator makes the IDs of new records predictable.
+func (s *MemoryTestStorage) SetIDGenerator(ids utils.IDGenerator) {
+	s.ids = ids
+}
+
+// CreateUserWithRelations implements Storage interface for testing
+func (s *MemoryTestStorage) CreateUserWithRelations(ctx context.Context, user *types.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if user.ID == uuid.Nil {
-		user.ID = uuid.New()
+		user.ID = s.ids.NewID()
 	}
 
 	s.users[user.ID] = user
@@ -64,7 +78,7 @@ func (s *MemoryTestStorage) CreateWritingSession(ctx context.Context, session *t
 	defer s.mu.Unlock()
 
 	if session.ID == uuid.Nil {
-		session.ID = uuid.New()
+		session.ID = s.ids.NewID()
 	}
 
 	s.sessions[session.ID] = session
@@ -102,14 +116,14 @@ func (s *MemoryTestStorage) CreateAnky(ctx context.Context, anky *types.Anky) er
 	defer s.mu.Unlock()
 
 	if anky.ID == uuid.Nil {
-		anky.ID = uuid.New()
+		anky.ID = s.ids.NewID()
 	}
 
 	if anky.CreatedAt.IsZero() {
-		anky.CreatedAt = time.Now()
+		anky.CreatedAt = s.clock.Now()
 	}
 	if anky.LastUpdatedAt.IsZero() {
-		anky.LastUpdatedAt = time.Now()
+		anky.LastUpdatedAt = s.clock.Now()
 	}
 
 	s.ankys[anky.ID] = anky
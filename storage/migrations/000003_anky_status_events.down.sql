@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_anky_status_events_anky_id;
+DROP TABLE IF EXISTS anky_status_events;
@@ -0,0 +1,9 @@
+CREATE TABLE anky_status_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    status VARCHAR(50) NOT NULL,
+    detail TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_anky_status_events_anky_id ON anky_status_events(anky_id, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE ankys DROP COLUMN IF EXISTS token_name;
+ALTER TABLE ankys DROP COLUMN IF EXISTS ticker;
+ALTER TABLE ankys DROP COLUMN IF EXISTS fid;
@@ -0,0 +1,3 @@
+ALTER TABLE ankys ADD COLUMN fid INTEGER DEFAULT 0;
+ALTER TABLE ankys ADD COLUMN ticker VARCHAR(255);
+ALTER TABLE ankys ADD COLUMN token_name VARCHAR(255);
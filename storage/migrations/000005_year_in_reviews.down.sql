@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_writing_sessions_user_id_starting_timestamp;
+DROP TABLE IF EXISTS year_in_reviews;
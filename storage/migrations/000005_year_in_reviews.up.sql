@@ -0,0 +1,11 @@
+CREATE TABLE year_in_reviews (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    year INTEGER NOT NULL,
+    stats JSONB NOT NULL DEFAULT '{}',
+    narrative TEXT,
+    anky_id UUID REFERENCES ankys(id) ON DELETE SET NULL,
+    generated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, year)
+);
+
+CREATE INDEX idx_writing_sessions_user_id_starting_timestamp ON writing_sessions(user_id, starting_timestamp);
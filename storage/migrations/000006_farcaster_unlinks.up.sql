@@ -0,0 +1,11 @@
+-- FIDs registered through Anky and later unlinked from their user. They keep
+-- counting toward the season cap because the FID itself is not reclaimed.
+CREATE TABLE farcaster_unlinks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fid INTEGER NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_farcaster_unlinks_user_id ON farcaster_unlinks(user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_ankys_storage_degraded;
+ALTER TABLE ankys DROP COLUMN IF EXISTS metadata_uri;
+ALTER TABLE ankys DROP COLUMN IF EXISTS storage_degraded;
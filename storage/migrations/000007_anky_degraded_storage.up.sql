@@ -0,0 +1,6 @@
+-- Set when IPFS pinning failed and the Anky fell back to data URI metadata.
+-- The storage repair job pins these later and clears the flag.
+ALTER TABLE ankys ADD COLUMN storage_degraded BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE ankys ADD COLUMN metadata_uri TEXT;
+
+CREATE INDEX idx_ankys_storage_degraded ON ankys(storage_degraded) WHERE storage_degraded;
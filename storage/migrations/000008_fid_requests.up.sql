@@ -0,0 +1,19 @@
+-- Every scored FID request. Borderline ones wait here with status 'pending'
+-- until an admin approves or rejects them.
+CREATE TABLE fid_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_fingerprint VARCHAR(255),
+    ip_address VARCHAR(64),
+    score DOUBLE PRECISION NOT NULL,
+    decision VARCHAR(20) NOT NULL,
+    signals JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL,
+    reviewer_note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_fid_requests_user_id ON fid_requests(user_id);
+CREATE INDEX idx_fid_requests_status ON fid_requests(status);
+CREATE INDEX idx_fid_requests_device_fingerprint ON fid_requests(device_fingerprint);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_writing_sessions_focus;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS focus_metrics;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS focus_score;
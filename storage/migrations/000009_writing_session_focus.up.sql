@@ -0,0 +1,4 @@
+ALTER TABLE writing_sessions ADD COLUMN focus_score INTEGER;
+ALTER TABLE writing_sessions ADD COLUMN focus_metrics JSONB;
+
+CREATE INDEX idx_writing_sessions_focus ON writing_sessions(user_id, starting_timestamp) WHERE focus_score IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS summary;
@@ -0,0 +1 @@
+ALTER TABLE writing_sessions ADD COLUMN summary TEXT;
@@ -0,0 +1,10 @@
+-- Ordered image collection of an Anky, used for the triptych of deep-dive sessions
+CREATE TABLE anky_images (
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    image_prompt TEXT NOT NULL,
+    image_url TEXT,
+    image_ipfs_hash TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (anky_id, position)
+);
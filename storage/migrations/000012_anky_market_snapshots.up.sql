@@ -0,0 +1,15 @@
+-- Price and market cap of the token clanker deployed for each Anky, over time
+CREATE TABLE anky_market_snapshots (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    token_address VARCHAR(42) NOT NULL,
+    chain_id VARCHAR(20) NOT NULL,
+    price_usd DOUBLE PRECISION NOT NULL,
+    market_cap_usd DOUBLE PRECISION,
+    liquidity_usd DOUBLE PRECISION,
+    volume_24h_usd DOUBLE PRECISION,
+    source VARCHAR(50) NOT NULL,
+    captured_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_anky_market_snapshots_anky_id ON anky_market_snapshots(anky_id, captured_at DESC);
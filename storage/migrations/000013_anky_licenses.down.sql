@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS anky_license_changes;
+
+ALTER TABLE ankys DROP COLUMN IF EXISTS license;
@@ -0,0 +1,13 @@
+ALTER TABLE ankys ADD COLUMN license VARCHAR(32) NOT NULL DEFAULT 'all-rights-reserved';
+
+-- Audit trail of every license change made after an Anky was published
+CREATE TABLE anky_license_changes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    previous_license VARCHAR(32) NOT NULL,
+    new_license VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_anky_license_changes_anky_id ON anky_license_changes(anky_id, created_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_ankys_cast_checked_at;
+
+ALTER TABLE ankys DROP COLUMN IF EXISTS cast_missing_at;
+ALTER TABLE ankys DROP COLUMN IF EXISTS cast_checked_at;
@@ -0,0 +1,5 @@
+-- Set by the cast reconciler, which checks that completed Ankys' casts still resolve
+ALTER TABLE ankys ADD COLUMN cast_checked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ankys ADD COLUMN cast_missing_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_ankys_cast_checked_at ON ankys(cast_checked_at NULLS FIRST) WHERE cast_hash <> '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS prompt_history;
+DROP TABLE IF EXISTS prompts;
@@ -0,0 +1,23 @@
+-- Upcoming writing prompt of each frames FID. FID 0 is never assigned on
+-- Farcaster, its row is the prompt everyone without one of their own gets.
+CREATE TABLE prompts (
+    fid INTEGER PRIMARY KEY,
+    prompt TEXT NOT NULL,
+    source VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Every prompt ever set, including the current ones
+CREATE TABLE prompt_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    fid INTEGER NOT NULL,
+    prompt TEXT NOT NULL,
+    source VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_prompt_history_fid ON prompt_history(fid, created_at DESC);
+
+INSERT INTO prompts (fid, prompt, source) VALUES (0, 'what are you grateful for today?', 'default');
+INSERT INTO prompt_history (fid, prompt, source) VALUES (0, 'what are you grateful for today?', 'default');
@@ -0,0 +1,10 @@
+-- Manual changes to newen balances, a balance is what was earned writing plus these
+CREATE TABLE newen_adjustments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_newen_adjustments_user_id ON newen_adjustments(user_id, created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE ankys DROP COLUMN IF EXISTS version;
+ALTER TABLE users DROP COLUMN IF EXISTS version;
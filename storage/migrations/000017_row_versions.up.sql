@@ -0,0 +1,3 @@
+-- Bumped by every update, updates only apply to the version they were read at
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE ankys ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,13 @@
+-- Result of every restore test of the latest logical backup
+CREATE TABLE backup_verifications (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    backup_file TEXT NOT NULL DEFAULT '',
+    backup_taken_at TIMESTAMP WITH TIME ZONE,
+    status VARCHAR(20) NOT NULL,
+    checks JSONB NOT NULL DEFAULT '[]',
+    error TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_backup_verifications_started_at ON backup_verifications(started_at DESC);
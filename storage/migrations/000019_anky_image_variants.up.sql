@@ -0,0 +1,10 @@
+-- Resized copies of each Anky's image, generated on first request
+CREATE TABLE anky_image_variants (
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    size VARCHAR(20) NOT NULL,
+    url TEXT NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (anky_id, size)
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS ipfs_pins;
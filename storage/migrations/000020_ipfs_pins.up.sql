@@ -0,0 +1,14 @@
+-- Everything pinned to IPFS through Pinata, keyed by the SHA-256 of the
+-- uploaded bytes so identical content is only pinned once
+CREATE TABLE ipfs_pins (
+    content_hash CHAR(64) PRIMARY KEY,
+    ipfs_hash TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    reuse_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_ipfs_pins_ipfs_hash ON ipfs_pins(ipfs_hash);
+CREATE INDEX idx_ipfs_pins_created_at ON ipfs_pins(created_at DESC);
@@ -0,0 +1,19 @@
+CREATE TABLE newen_adjustments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_newen_adjustments_user_id ON newen_adjustments(user_id, created_at);
+
+-- Writing rewards are still in writing_sessions.newen_earned, everything else
+-- becomes an adjustment
+INSERT INTO newen_adjustments (id, user_id, amount, reason, created_at)
+SELECT id, user_id, CASE direction WHEN 'credit' THEN amount ELSE -amount END,
+    CASE WHEN description = '' THEN source ELSE description END, created_at
+FROM newen_transactions
+WHERE source <> 'writing_reward';
+
+DROP TABLE IF EXISTS newen_transactions;
@@ -0,0 +1,30 @@
+-- Ledger of every newen credit and debit; a balance is the sum of its entries
+CREATE TABLE newen_transactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    direction VARCHAR(6) NOT NULL CHECK (direction IN ('credit', 'debit')),
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    source VARCHAR(30) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    writing_session_id UUID REFERENCES writing_sessions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_newen_transactions_user_id ON newen_transactions(user_id, created_at DESC);
+
+-- A writing session is rewarded once, however many times its end is reported
+CREATE UNIQUE INDEX idx_newen_transactions_writing_reward
+    ON newen_transactions(writing_session_id) WHERE source = 'writing_reward';
+
+-- Balances used to be the newen_earned of writing sessions plus manual adjustments
+INSERT INTO newen_transactions (user_id, direction, amount, source, writing_session_id, created_at)
+SELECT user_id, 'credit', ROUND(newen_earned)::INTEGER, 'writing_reward', id, COALESCE(ending_timestamp, starting_timestamp)
+FROM writing_sessions
+WHERE user_id IS NOT NULL AND ROUND(newen_earned) > 0;
+
+INSERT INTO newen_transactions (id, user_id, direction, amount, source, description, created_at)
+SELECT id, user_id, CASE WHEN amount > 0 THEN 'credit' ELSE 'debit' END, ABS(amount), 'adjustment', reason, created_at
+FROM newen_adjustments
+WHERE amount <> 0;
+
+DROP TABLE newen_adjustments;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_newen_transactions_idempotency_key;
+ALTER TABLE newen_transactions DROP COLUMN IF EXISTS idempotency_key;
@@ -0,0 +1,5 @@
+-- Clients send a key with every spend so a retried request can't debit twice
+ALTER TABLE newen_transactions ADD COLUMN idempotency_key TEXT;
+
+CREATE UNIQUE INDEX idx_newen_transactions_idempotency_key
+    ON newen_transactions(user_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,8 @@
+-- LLM gateway calls per user and UTC day, checked against the daily quota
+CREATE TABLE llm_usage (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    input_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_newen_transactions_writing_reward_created_at;
+DROP INDEX IF EXISTS idx_writing_sessions_ending_timestamp;
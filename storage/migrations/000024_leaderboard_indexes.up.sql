@@ -0,0 +1,3 @@
+-- Day and week leaderboards only aggregate recent sessions and rewards
+CREATE INDEX IF NOT EXISTS idx_writing_sessions_ending_timestamp ON writing_sessions(ending_timestamp) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_newen_transactions_writing_reward_created_at ON newen_transactions(created_at) WHERE source = 'writing_reward';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS writing_session_sync_clocks;
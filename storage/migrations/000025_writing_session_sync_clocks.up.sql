@@ -0,0 +1,6 @@
+-- Vector clock of the latest copy of each session synced from an offline client
+CREATE TABLE writing_session_sync_clocks (
+    session_id UUID PRIMARY KEY REFERENCES writing_sessions(id) ON DELETE CASCADE,
+    clock JSONB NOT NULL DEFAULT '{}',
+    synced_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
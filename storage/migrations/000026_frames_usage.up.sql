@@ -0,0 +1,7 @@
+-- Sessions each frames FID submitted per UTC day, checked against the daily cap
+CREATE TABLE frames_usage (
+    fid INTEGER NOT NULL,
+    day DATE NOT NULL,
+    submissions INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (fid, day)
+);
@@ -0,0 +1,11 @@
+-- Anonymous accounts merged into a registered one. Their tokens stop being
+-- accepted, so the old account ID can't be used anymore.
+CREATE TABLE user_merges (
+    anonymous_user_id UUID PRIMARY KEY,
+    merged_into UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    writing_sessions INTEGER NOT NULL DEFAULT 0,
+    ankys INTEGER NOT NULL DEFAULT 0,
+    badges INTEGER NOT NULL DEFAULT 0,
+    newen_transactions INTEGER NOT NULL DEFAULT 0,
+    merged_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,15 @@
+-- Incidents admins post to the public status page while something is broken.
+CREATE TABLE incidents (
+    id UUID PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    severity VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    -- Upstreams or features the incident affects, e.g. {neynar,casting}
+    components TEXT[] NOT NULL DEFAULT '{}',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_incidents_active ON incidents (started_at DESC) WHERE resolved_at IS NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS buddy_nudges;
+DROP TABLE IF EXISTS writing_buddies;
+DROP TABLE IF EXISTS buddy_opt_ins;
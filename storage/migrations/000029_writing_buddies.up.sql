@@ -0,0 +1,34 @@
+-- Writers who asked to be paired with an accountability buddy
+CREATE TABLE buddy_opt_ins (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    opted_in_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- One row per writer of each pairing, so both sides look their buddy up the
+-- same way. Ended pairings are kept so the same two writers aren't paired again.
+CREATE TABLE writing_buddies (
+    pair_id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    buddy_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    paired_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (pair_id, user_id)
+);
+
+CREATE UNIQUE INDEX idx_writing_buddies_active ON writing_buddies (user_id) WHERE ended_at IS NULL;
+CREATE INDEX idx_writing_buddies_buddy ON writing_buddies (buddy_id);
+
+-- Nudges sent to a writer, by their buddy or by the job when they missed a day
+CREATE TABLE buddy_nudges (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    from_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    kind VARCHAR(20) NOT NULL,
+    -- Local date the writer missed, for nudges sent by the job
+    missed_date DATE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    read_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_buddy_nudges_missed ON buddy_nudges (user_id, missed_date) WHERE missed_date IS NOT NULL;
+CREATE INDEX idx_buddy_nudges_unread ON buddy_nudges (user_id, created_at DESC) WHERE read_at IS NULL;
@@ -0,0 +1,6 @@
+-- Rehydrate archived sessions before rolling back, their writing is only in the archive
+DROP INDEX IF EXISTS idx_writing_sessions_archivable;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS archive_checksum;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS archive_key;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS archived;
@@ -0,0 +1,8 @@
+-- Writing of archived sessions lives gzipped in the archive under archive_key,
+-- writing is emptied and archive_checksum is the SHA-256 of the original text
+ALTER TABLE writing_sessions ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE writing_sessions ADD COLUMN archived_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE writing_sessions ADD COLUMN archive_key TEXT;
+ALTER TABLE writing_sessions ADD COLUMN archive_checksum TEXT;
+
+CREATE INDEX idx_writing_sessions_archivable ON writing_sessions(ending_timestamp) WHERE NOT archived AND ending_timestamp IS NOT NULL;
@@ -0,0 +1,15 @@
+-- Responses of session submissions by idempotency key, replayed to clients
+-- that retry. status_code is NULL while the first request is still running.
+CREATE TABLE idempotency_keys (
+    route VARCHAR(100) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    status_code INTEGER,
+    content_type TEXT,
+    response BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (route, key)
+);
+
+CREATE INDEX idx_idempotency_keys_created ON idempotency_keys (created_at);
@@ -0,0 +1,11 @@
+-- Seasons of Anky and the pipeline their Ankys go through
+CREATE TABLE seasons (
+    number INTEGER PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    pipeline JSONB NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_seasons_starts_at ON seasons (starts_at DESC);
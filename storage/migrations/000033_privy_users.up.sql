@@ -0,0 +1,15 @@
+-- Privy accounts of users, created with the user or linked afterwards
+CREATE TABLE IF NOT EXISTS privy_users (
+    did VARCHAR(255) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_privy_users_user_id ON privy_users (user_id);
+
+-- Users load their Privy account from here, so the ones created before it
+-- existed get theirs from users.privy_did
+INSERT INTO privy_users (did, user_id, created_at)
+SELECT privy_did, id, created_at FROM users
+WHERE COALESCE(privy_did, '') <> ''
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,15 @@
+-- Every version of an Anky's reflection: the one the pipeline wrote and the
+-- ones its owner regenerated. The canonical version is the Anky's reflection.
+CREATE TABLE anky_reflections (
+    id UUID PRIMARY KEY,
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    reflection TEXT NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    canonical BOOLEAN NOT NULL DEFAULT FALSE,
+    newen_transaction_id UUID,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (anky_id, version)
+);
+
+CREATE UNIQUE INDEX idx_anky_reflections_canonical ON anky_reflections (anky_id) WHERE canonical;
@@ -0,0 +1,35 @@
+-- Only one account per Privy user fits the old table, the latest verified
+ALTER TABLE linked_accounts RENAME TO linked_accounts_new;
+ALTER INDEX IF EXISTS idx_linked_accounts_privy_user_id RENAME TO idx_linked_accounts_new_privy_user_id;
+
+CREATE TABLE linked_accounts (
+    privy_user_id VARCHAR(255) PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    address VARCHAR(255),
+    chain_type VARCHAR(50),
+    fid INTEGER,
+    owner_address VARCHAR(255),
+    username VARCHAR(255),
+    display_name VARCHAR(255),
+    bio TEXT,
+    profile_picture VARCHAR(255),
+    profile_picture_url VARCHAR(255),
+    verified_at BIGINT,
+    first_verified_at BIGINT,
+    latest_verified_at BIGINT
+);
+
+CREATE INDEX idx_linked_accounts_privy_user_id ON linked_accounts (privy_user_id);
+
+INSERT INTO linked_accounts (
+    privy_user_id, type, address, chain_type, fid, owner_address, username,
+    display_name, bio, profile_picture, profile_picture_url, verified_at,
+    first_verified_at, latest_verified_at
+)
+SELECT DISTINCT ON (n.privy_user_id) n.privy_user_id, n.type, n.address, n.chain_type,
+    n.fid, n.owner_address, n.username, n.display_name, n.bio, n.profile_picture,
+    n.profile_picture_url, n.verified_at, n.first_verified_at, n.latest_verified_at
+FROM linked_accounts_new n
+ORDER BY n.privy_user_id, n.latest_verified_at DESC NULLS LAST;
+
+DROP TABLE linked_accounts_new;
@@ -0,0 +1,40 @@
+-- Linked accounts were keyed by the Privy user, so a user could only have
+-- one. They are now the accounts Privy's server API reports for the user,
+-- any number of them, and are replaced whenever the user is verified again.
+-- The account each Privy user already has is kept.
+ALTER TABLE linked_accounts RENAME TO linked_accounts_old;
+ALTER INDEX IF EXISTS idx_linked_accounts_privy_user_id RENAME TO idx_linked_accounts_old_privy_user_id;
+
+CREATE TABLE linked_accounts (
+    id UUID PRIMARY KEY,
+    privy_user_id VARCHAR(255) NOT NULL REFERENCES privy_users(did) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    address VARCHAR(255),
+    chain_type VARCHAR(50),
+    fid INTEGER,
+    owner_address VARCHAR(255),
+    username VARCHAR(255),
+    display_name VARCHAR(255),
+    bio TEXT,
+    profile_picture VARCHAR(255),
+    profile_picture_url VARCHAR(255),
+    verified_at BIGINT,
+    first_verified_at BIGINT,
+    latest_verified_at BIGINT
+);
+
+CREATE INDEX idx_linked_accounts_privy_user_id ON linked_accounts (privy_user_id);
+
+-- Accounts of Privy users that aren't stored can't reference them
+INSERT INTO linked_accounts (
+    id, privy_user_id, type, address, chain_type, fid, owner_address, username,
+    display_name, bio, profile_picture, profile_picture_url, verified_at,
+    first_verified_at, latest_verified_at
+)
+SELECT uuid_generate_v4(), o.privy_user_id, o.type, o.address, o.chain_type, o.fid,
+    o.owner_address, o.username, o.display_name, o.bio, o.profile_picture,
+    o.profile_picture_url, o.verified_at, o.first_verified_at, o.latest_verified_at
+FROM linked_accounts_old o
+WHERE EXISTS (SELECT 1 FROM privy_users p WHERE p.did = o.privy_user_id);
+
+DROP TABLE linked_accounts_old;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_ankys_reveal_at;
+
+ALTER TABLE ankys DROP COLUMN IF EXISTS cast_on_reveal;
+ALTER TABLE ankys DROP COLUMN IF EXISTS revealed_at;
+ALTER TABLE ankys DROP COLUMN IF EXISTS reveal_at;
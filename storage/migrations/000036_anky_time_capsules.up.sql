@@ -0,0 +1,7 @@
+-- Time capsules: the reflection and image of the Anky are withheld until
+-- reveal_at, when the reveal job sets revealed_at and casts it if asked to
+ALTER TABLE ankys ADD COLUMN reveal_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ankys ADD COLUMN revealed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ankys ADD COLUMN cast_on_reveal BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX idx_ankys_reveal_at ON ankys(reveal_at) WHERE reveal_at IS NOT NULL AND revealed_at IS NULL;
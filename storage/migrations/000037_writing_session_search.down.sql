@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_writing_sessions_search;
+DROP TRIGGER IF EXISTS writing_sessions_search_update ON writing_sessions;
+DROP FUNCTION IF EXISTS writing_sessions_search_update();
+
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS writing_search;
@@ -0,0 +1,22 @@
+-- Full-text index of the writing of each session. The 'simple' configuration
+-- doesn't stem, writers write in every language. Archiving empties the
+-- writing but keeps the index, so archived sessions can still be found;
+-- sessions archived before this migration aren't indexed.
+ALTER TABLE writing_sessions ADD COLUMN writing_search TSVECTOR;
+
+CREATE FUNCTION writing_sessions_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    IF NOT NEW.archived THEN
+        NEW.writing_search := to_tsvector('simple', COALESCE(NEW.writing, ''));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER writing_sessions_search_update
+    BEFORE INSERT OR UPDATE OF writing, archived ON writing_sessions
+    FOR EACH ROW EXECUTE FUNCTION writing_sessions_search_update();
+
+UPDATE writing_sessions SET writing_search = to_tsvector('simple', COALESCE(writing, '')) WHERE NOT archived;
+
+CREATE INDEX idx_writing_sessions_search ON writing_sessions USING GIN (writing_search);
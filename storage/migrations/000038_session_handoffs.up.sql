@@ -0,0 +1,18 @@
+-- Short-lived codes that move a pending session and its prompt to another
+-- device. Frames sessions aren't stored until they are submitted, so
+-- session_id has no foreign key; they carry the FID instead of a user.
+CREATE TABLE session_handoffs (
+    id UUID PRIMARY KEY,
+    code VARCHAR(6) NOT NULL,
+    session_id UUID NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    fid INTEGER,
+    prompt TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    redeemed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- A code is only ever live for one session
+CREATE UNIQUE INDEX idx_session_handoffs_code ON session_handoffs(code) WHERE redeemed_at IS NULL;
+CREATE INDEX idx_session_handoffs_session_id ON session_handoffs(session_id);
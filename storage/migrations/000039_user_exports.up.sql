@@ -0,0 +1,17 @@
+-- Archives of everything a user wrote, built in the background and kept in
+-- the blob store under EXPORT_DIR until they expire
+CREATE TABLE user_exports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL,
+    blob_key TEXT,
+    download_token VARCHAR(64) UNIQUE,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_user_exports_user_id ON user_exports(user_id, created_at DESC);
+CREATE INDEX idx_user_exports_expires_at ON user_exports(expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,11 @@
+-- Accounts their owner asked to delete. Nothing is removed until purge_after,
+-- so the owner can change their mind; the purge then removes the account and
+-- everything it wrote, which takes this row with it.
+CREATE TABLE user_deletions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    requested_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    purge_after TIMESTAMP WITH TIME ZONE NOT NULL,
+    unpin_ipfs BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX idx_user_deletions_purge_after ON user_deletions(purge_after);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS anky_slugs;
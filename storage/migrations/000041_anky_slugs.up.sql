@@ -0,0 +1,19 @@
+-- Readable names for share links, like wisdom-light-dancing-0421. They are
+-- keyed by writing session so frames Ankys, which only exist as files, get
+-- one too.
+CREATE TABLE anky_slugs (
+    slug VARCHAR(80) PRIMARY KEY,
+    session_id UUID NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Ankys named before slugs existed are numbered in the order they were made
+INSERT INTO anky_slugs (slug, session_id, created_at)
+SELECT base || '-' || LPAD(ROW_NUMBER() OVER (PARTITION BY base ORDER BY created_at, id)::TEXT, 4, '0'), writing_session_id, created_at
+FROM (
+    SELECT DISTINCT ON (writing_session_id) id, writing_session_id, created_at,
+        COALESCE(NULLIF(LEFT(TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(token_name), '[^a-z0-9]+', '-', 'g')), 60), ''), 'anky') AS base
+    FROM ankys
+    WHERE writing_session_id IS NOT NULL AND COALESCE(token_name, '') <> ''
+    ORDER BY writing_session_id, created_at
+) named;
@@ -0,0 +1,24 @@
+-- Every image an Anky had: the one the pipeline made and the ones its owner
+-- regenerated. A regeneration keeps Midjourney's upscaled candidates until
+-- the owner keeps one; the current version is the Anky's image.
+CREATE TABLE anky_image_versions (
+    id UUID PRIMARY KEY,
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    image_prompt TEXT NOT NULL DEFAULT '',
+    candidate_urls JSONB NOT NULL DEFAULT '[]',
+    chosen_candidate INTEGER,
+    image_url TEXT,
+    image_ipfs_hash TEXT,
+    current BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (anky_id, version)
+);
+
+CREATE UNIQUE INDEX idx_anky_image_versions_current ON anky_image_versions (anky_id) WHERE current;
+-- One regeneration at a time per Anky
+CREATE UNIQUE INDEX idx_anky_image_versions_generating ON anky_image_versions (anky_id) WHERE status = 'generating';
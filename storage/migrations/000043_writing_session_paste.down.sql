@@ -0,0 +1,2 @@
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS paste_metrics;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS paste_flagged;
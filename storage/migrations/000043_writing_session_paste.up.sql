@@ -0,0 +1,3 @@
+-- Sessions whose keystrokes show pasted text don't earn newen or become Ankys
+ALTER TABLE writing_sessions ADD COLUMN paste_flagged BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE writing_sessions ADD COLUMN paste_metrics JSONB;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS writing_reminders;
+DROP TABLE IF EXISTS reminder_opt_outs;
@@ -0,0 +1,20 @@
+-- Writers who turned their daily writing reminder off
+CREATE TABLE reminder_opt_outs (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    opted_out_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Reminders sent by the reminder job, at most one per writer and local day
+CREATE TABLE writing_reminders (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reminder_date DATE NOT NULL,
+    -- When the writer's schedule said to remind them, learned from their sessions or the default
+    scheduled_for TIMESTAMP WITH TIME ZONE NOT NULL,
+    learned BOOLEAN NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    read_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_writing_reminders_day ON writing_reminders (user_id, reminder_date);
+CREATE INDEX idx_writing_reminders_unread ON writing_reminders (user_id, created_at DESC) WHERE read_at IS NULL;
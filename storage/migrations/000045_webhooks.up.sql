@@ -0,0 +1,20 @@
+-- URLs called with a signed payload when Ankys reach a pipeline status
+CREATE TABLE webhooks (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    -- Key of the HMAC-SHA256 signature of every callback
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    -- Called for every Anky instead of only the owner's, registered by admins for the frames frontend
+    all_ankys BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_delivery_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    -- Set after too many failed deliveries in a row, disabled webhooks aren't called
+    disabled_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_webhooks_user ON webhooks (user_id);
+CREATE INDEX idx_webhooks_all_ankys ON webhooks (all_ankys) WHERE all_ankys AND disabled_at IS NULL;
@@ -0,0 +1,25 @@
+-- In-app messages admins post to the what's-new feed
+CREATE TABLE announcements (
+    id UUID PRIMARY KEY,
+    -- season, feature or downtime
+    kind VARCHAR(20) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    -- Where the client links to for more, if anywhere
+    url TEXT NOT NULL DEFAULT '',
+    -- Shown from then on, so announcements can be scheduled ahead
+    published_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- No longer shown after, e.g. once planned downtime is over
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_announcements_published ON announcements (published_at DESC);
+
+CREATE TABLE announcement_reads (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    announcement_id UUID NOT NULL REFERENCES announcements(id) ON DELETE CASCADE,
+    read_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, announcement_id)
+);
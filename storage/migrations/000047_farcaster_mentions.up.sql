@@ -0,0 +1,18 @@
+-- Casts mentioning the Anky account or replying to its casts, from the Neynar webhook
+CREATE TABLE farcaster_mentions (
+    cast_hash TEXT PRIMARY KEY,
+    author_fid INTEGER NOT NULL,
+    author_username TEXT NOT NULL DEFAULT '',
+    text TEXT NOT NULL,
+    -- mention or reply
+    kind VARCHAR(20) NOT NULL,
+    parent_hash TEXT,
+    thread_hash TEXT NOT NULL DEFAULT '',
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    -- Anky's answer, when it replied
+    reply_hash TEXT,
+    replied_at TIMESTAMP WITH TIME ZONE,
+    reply_error TEXT
+);
+
+CREATE INDEX idx_farcaster_mentions_author ON farcaster_mentions (author_fid, received_at DESC);
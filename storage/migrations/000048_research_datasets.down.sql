@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS research_datasets;
+DROP TABLE IF EXISTS dataset_opt_ins;
@@ -0,0 +1,17 @@
+-- Writers who agreed to contribute anonymized statistics of their sessions
+-- to the public research dataset, from the moment they opted in
+CREATE TABLE dataset_opt_ins (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    opted_in_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Monthly datasets published on IPFS, they hold no user or writing
+CREATE TABLE research_datasets (
+    id UUID PRIMARY KEY,
+    period_start DATE NOT NULL UNIQUE,
+    period_end DATE NOT NULL,
+    ipfs_hash TEXT NOT NULL,
+    contributors INTEGER NOT NULL,
+    sessions INTEGER NOT NULL,
+    published_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
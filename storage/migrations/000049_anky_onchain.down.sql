@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_ankys_onchain_open;
+
+ALTER TABLE ankys DROP COLUMN IF EXISTS onchain_confirmed_at;
+ALTER TABLE ankys DROP COLUMN IF EXISTS onchain_block_number;
+ALTER TABLE ankys DROP COLUMN IF EXISTS token_id;
+ALTER TABLE ankys DROP COLUMN IF EXISTS metadata_ipfs_hash;
+ALTER TABLE ankys DROP COLUMN IF EXISTS onchain_tx_hash;
+ALTER TABLE ankys DROP COLUMN IF EXISTS onchain_status;
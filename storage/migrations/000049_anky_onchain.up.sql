@@ -0,0 +1,10 @@
+-- The NFT the Anky NFT contract reveals for an Anky, tracked from the
+-- transaction until it has enough confirmations
+ALTER TABLE ankys ADD COLUMN onchain_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE ankys ADD COLUMN onchain_tx_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE ankys ADD COLUMN metadata_ipfs_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE ankys ADD COLUMN token_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE ankys ADD COLUMN onchain_block_number BIGINT;
+ALTER TABLE ankys ADD COLUMN onchain_confirmed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_ankys_onchain_open ON ankys(last_updated_at) WHERE onchain_status IN ('queued', 'pending');
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_ankys_awaiting_token;
+
+ALTER TABLE ankys DROP COLUMN IF EXISTS token_checked_at;
+ALTER TABLE ankys DROP COLUMN IF EXISTS token_deployed_at;
+ALTER TABLE ankys DROP COLUMN IF EXISTS token_address;
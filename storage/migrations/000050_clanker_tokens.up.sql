@@ -0,0 +1,7 @@
+-- Contract of the token clanker deployed in reply to the Anky's cast, found
+-- by the clanker watcher, which records when it last looked
+ALTER TABLE ankys ADD COLUMN token_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE ankys ADD COLUMN token_deployed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ankys ADD COLUMN token_checked_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_ankys_awaiting_token ON ankys(created_at) WHERE token_address = '' AND cast_hash <> '';
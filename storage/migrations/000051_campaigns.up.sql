@@ -0,0 +1,21 @@
+-- Time-boxed events like double newen weekends or special prompts
+CREATE TABLE campaigns (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- What clients show on the banner while the campaign runs
+    banner_text VARCHAR(255) NOT NULL DEFAULT '',
+    banner_url TEXT NOT NULL DEFAULT '',
+    -- Writing rewards are multiplied by it, the highest one wins when campaigns overlap
+    newen_multiplier DOUBLE PRECISION NOT NULL DEFAULT 1,
+    -- Replaces everyone's prompt when set, the latest campaign's wins
+    prompt TEXT NOT NULL DEFAULT '',
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (ends_at > starts_at),
+    CHECK (newen_multiplier > 0)
+);
+
+CREATE INDEX idx_campaigns_window ON campaigns (starts_at, ends_at);
@@ -0,0 +1,18 @@
+-- Ankys the safety review held before they were made public
+CREATE TABLE anky_reviews (
+    id UUID PRIMARY KEY,
+    anky_id UUID NOT NULL REFERENCES ankys(id) ON DELETE CASCADE,
+    -- crisis, moderation or nsfw_image
+    reasons TEXT[] NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    -- pending until a moderator approves or rejects it
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reviewer_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- An Anky waits in the queue once
+CREATE UNIQUE INDEX idx_anky_reviews_pending ON anky_reviews (anky_id) WHERE status = 'pending';
+CREATE INDEX idx_anky_reviews_status ON anky_reviews (status, created_at);
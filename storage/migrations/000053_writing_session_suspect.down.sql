@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_writing_sessions_suspect;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS cheat_metrics;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS suspect_score;
+ALTER TABLE writing_sessions DROP COLUMN IF EXISTS suspect;
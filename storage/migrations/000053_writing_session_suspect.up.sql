@@ -0,0 +1,6 @@
+-- Sessions whose keystrokes look scripted don't earn newen, become Ankys or count toward a FID
+ALTER TABLE writing_sessions ADD COLUMN suspect BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE writing_sessions ADD COLUMN suspect_score INTEGER;
+ALTER TABLE writing_sessions ADD COLUMN cheat_metrics JSONB;
+
+CREATE INDEX idx_writing_sessions_suspect ON writing_sessions (suspect_score DESC, starting_timestamp DESC) WHERE suspect;
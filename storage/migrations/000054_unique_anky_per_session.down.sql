@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_ankys_year_in_review_session_id;
+DROP INDEX IF EXISTS idx_ankys_writing_session_id;
+CREATE INDEX idx_ankys_writing_session_id ON ankys(writing_session_id);
+ALTER TABLE ankys DROP COLUMN IF EXISTS year_in_review;
@@ -0,0 +1,21 @@
+-- A session becomes one Anky. Year in review Ankys hang off the longest session
+-- of the year, which usually has its own Anky, so they are told apart.
+ALTER TABLE ankys ADD COLUMN year_in_review BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE ankys SET year_in_review = TRUE WHERE id IN (SELECT anky_id FROM year_in_reviews WHERE anky_id IS NOT NULL);
+
+-- Ankys created twice for a session by concurrent requests lose their link to
+-- it, but the one the session links to, or else the first
+WITH ranked AS (
+    SELECT a.id, ROW_NUMBER() OVER (
+        PARTITION BY a.writing_session_id
+        ORDER BY (ws.anky_id IS NOT DISTINCT FROM a.id) DESC, a.created_at, a.id
+    ) AS n
+    FROM ankys a
+    LEFT JOIN writing_sessions ws ON ws.id = a.writing_session_id
+    WHERE a.writing_session_id IS NOT NULL AND NOT a.year_in_review
+)
+UPDATE ankys SET writing_session_id = NULL FROM ranked WHERE ankys.id = ranked.id AND ranked.n > 1;
+
+DROP INDEX IF EXISTS idx_ankys_writing_session_id;
+CREATE UNIQUE INDEX idx_ankys_writing_session_id ON ankys(writing_session_id) WHERE NOT year_in_review;
+CREATE INDEX idx_ankys_year_in_review_session_id ON ankys(writing_session_id) WHERE year_in_review;
@@ -0,0 +1 @@
+ALTER TABLE farcaster_mentions DROP COLUMN IF EXISTS reply_claimed_at;
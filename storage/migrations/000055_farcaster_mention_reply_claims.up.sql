@@ -0,0 +1,4 @@
+-- When a reply to the cast was claimed against the author's daily limit, set before it is generated
+ALTER TABLE farcaster_mentions ADD COLUMN reply_claimed_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE farcaster_mentions SET reply_claimed_at = replied_at WHERE reply_hash IS NOT NULL;
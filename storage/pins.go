@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgx/v4"
+)
+
+const ipfsPinColumns = `content_hash, ipfs_hash, name, size_bytes, reuse_count, created_at, last_used_at`
+
+func scanIPFSPin(row pgx.Row) (*types.IPFSPin, error) {
+	pin := new(types.IPFSPin)
+	err := row.Scan(
+		&pin.ContentHash,
+		&pin.IPFSHash,
+		&pin.Name,
+		&pin.SizeBytes,
+		&pin.ReuseCount,
+		&pin.CreatedAt,
+		&pin.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pin, nil
+}
+
+func (s *PostgresStore) GetIPFSPinByContentHash(ctx context.Context, contentHash string) (*types.IPFSPin, error) {
+	query := `SELECT ` + ipfsPinColumns + ` FROM ipfs_pins WHERE content_hash = $1`
+	return scanIPFSPin(s.db.QueryRow(ctx, query, contentHash))
+}
+
+// SaveIPFSPin records a new pin. Pinning the same content again replaces the
+// IPFS hash it points to.
+func (s *PostgresStore) SaveIPFSPin(ctx context.Context, pin *types.IPFSPin) error {
+	query := `
+		INSERT INTO ipfs_pins (content_hash, ipfs_hash, name, size_bytes, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (content_hash) DO UPDATE SET
+			ipfs_hash = EXCLUDED.ipfs_hash,
+			name = EXCLUDED.name,
+			last_used_at = EXCLUDED.last_used_at`
+	_, err := s.db.Exec(ctx, query, pin.ContentHash, pin.IPFSHash, pin.Name, pin.SizeBytes, pin.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save ipfs pin: %w", err)
+	}
+	return nil
+}
+
+// MarkIPFSPinReused counts an upload that was skipped because the content
+// was already pinned.
+func (s *PostgresStore) MarkIPFSPinReused(ctx context.Context, contentHash string) error {
+	query := `UPDATE ipfs_pins SET reuse_count = reuse_count + 1, last_used_at = NOW() WHERE content_hash = $1`
+	if _, err := s.db.Exec(ctx, query, contentHash); err != nil {
+		return fmt.Errorf("failed to mark ipfs pin reused: %w", err)
+	}
+	return nil
+}
+
+// GetIPFSPins returns the most recent pins first.
+func (s *PostgresStore) GetIPFSPins(ctx context.Context, limit int, offset int) ([]*types.IPFSPin, error) {
+	query := `SELECT ` + ipfsPinColumns + ` FROM ipfs_pins ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	rows, err := s.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ipfs pins: %w", err)
+	}
+	defer rows.Close()
+
+	pins := []*types.IPFSPin{}
+	for rows.Next() {
+		pin, err := scanIPFSPin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ipfs pin: %w", err)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, rows.Err()
+}
+
+// DeleteIPFSPins forgets every pin of the IPFS hash and returns how many there were.
+func (s *PostgresStore) DeleteIPFSPins(ctx context.Context, ipfsHash string) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM ipfs_pins WHERE ipfs_hash = $1`, ipfsHash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete ipfs pins: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// IsIPFSHashReferenced reports whether an Anky still points at the IPFS
+// hash, as its image, one of its collection images, one of its image
+// versions or its metadata, or a published research dataset is the hash.
+func (s *PostgresStore) IsIPFSHashReferenced(ctx context.Context, ipfsHash string) (bool, error) {
+	query := `
+		SELECT EXISTS (SELECT 1 FROM ankys WHERE image_ipfs_hash = $1 OR metadata_ipfs_hash = $1 OR metadata_uri LIKE '%' || $1)
+			OR EXISTS (SELECT 1 FROM anky_images WHERE image_ipfs_hash = $1)
+			OR EXISTS (SELECT 1 FROM anky_image_versions WHERE image_ipfs_hash = $1)
+			OR EXISTS (SELECT 1 FROM research_datasets WHERE ipfs_hash = $1)`
+	var referenced bool
+	if err := s.db.QueryRow(ctx, query, ipfsHash).Scan(&referenced); err != nil {
+		return false, fmt.Errorf("failed to check ipfs hash references: %w", err)
+	}
+	return referenced, nil
+}
@@ -0,0 +1,59 @@
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+)
+
+func TestIPFSHashesOfImageVersionsMetadataAndDatasets(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+
+	anky := createTestAnky(t, store, &types.Anky{ImageURL: "https://example.com/anky.png", ImageIPFSHash: "QmAnkyImage"})
+	if err := store.StartAnkyImageRegeneration(ctx, &types.AnkyImageVersion{AnkyID: anky.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetCurrentAnkyImageHash(ctx, anky.ID, "QmVersionImage"); err != nil {
+		t.Fatal(err)
+	}
+	anky.MetadataIPFSHash = "QmMetadata"
+	if err := store.UpdateAnkyOnchain(ctx, anky); err != nil {
+		t.Fatal(err)
+	}
+	dataset := &types.ResearchDataset{
+		PeriodStart: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		IPFSHash:    "QmDataset",
+	}
+	if _, err := store.CreateResearchDataset(ctx, dataset); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hash := range []string{"QmAnkyImage", "QmVersionImage", "QmMetadata", "QmDataset"} {
+		referenced, err := store.IsIPFSHashReferenced(ctx, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !referenced {
+			t.Errorf("%s isn't referenced", hash)
+		}
+	}
+	if referenced, err := store.IsIPFSHashReferenced(ctx, "QmNobodys"); err != nil || referenced {
+		t.Errorf("unknown hash referenced = %v, %v", referenced, err)
+	}
+
+	footprint, err := store.GetUserFootprint(ctx, anky.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{"QmAnkyImage", "QmVersionImage", "QmMetadata"} {
+		if !slices.Contains(footprint.IPFSHashes, hash) {
+			t.Errorf("footprint %v is missing %s", footprint.IPFSHashes, hash)
+		}
+	}
+}
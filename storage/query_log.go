@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryLog records the queries run on behalf of one request, for the query
+// diagnostics of development builds. Queries inside transactions are not
+// recorded.
+type QueryLog struct {
+	mu      sync.Mutex
+	queries []LoggedQuery
+}
+
+type LoggedQuery struct {
+	// The SQL with its whitespace collapsed, so the same query always reads the same
+	SQL      string
+	Duration time.Duration
+}
+
+type queryLogKey struct{}
+
+// WithQueryLog returns a context whose queries are recorded in the returned log.
+func WithQueryLog(ctx context.Context) (context.Context, *QueryLog) {
+	queryLog := &QueryLog{}
+	return context.WithValue(ctx, queryLogKey{}, queryLog), queryLog
+}
+
+// Queries returns the queries recorded so far, in the order they ran.
+func (l *QueryLog) Queries() []LoggedQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]LoggedQuery(nil), l.queries...)
+}
+
+func recordQuery(ctx context.Context, sql string, started time.Time) {
+	queryLog, ok := ctx.Value(queryLogKey{}).(*QueryLog)
+	if !ok {
+		return
+	}
+	query := LoggedQuery{SQL: strings.Join(strings.Fields(sql), " "), Duration: time.Since(started)}
+
+	queryLog.mu.Lock()
+	defer queryLog.mu.Unlock()
+	queryLog.queries = append(queryLog.queries, query)
+}
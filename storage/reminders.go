@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// ReminderCandidate is a writer the reminder job may remind, with when they
+// started the sessions they finished recently.
+type ReminderCandidate struct {
+	UserID uuid.UUID
+	// IANA name from the user's metadata, empty when unknown
+	Timezone string
+	Starts   []time.Time
+}
+
+const writingReminderColumns = `id, user_id, to_char(reminder_date, 'YYYY-MM-DD'), scheduled_for, learned, created_at, read_at`
+
+func (s *PostgresStore) OptOutOfReminders(ctx context.Context, userID uuid.UUID) error {
+	query := `INSERT INTO reminder_opt_outs (user_id, opted_out_at) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING`
+	if _, err := s.db.Exec(ctx, query, userID, s.Clock().Now()); err != nil {
+		return fmt.Errorf("failed to opt out of reminders: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) OptInToReminders(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM reminder_opt_outs WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to opt in to reminders: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) IsOptedOutOfReminders(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var optedOut bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM reminder_opt_outs WHERE user_id = $1)`, userID).Scan(&optedOut)
+	if err != nil {
+		return false, fmt.Errorf("failed to check reminder opt out: %w", err)
+	}
+	return optedOut, nil
+}
+
+// Sessions finished since $1, with the timezone of their writer
+const reminderStartsQuery = `
+	SELECT s.user_id, COALESCE(m.timezone, ''), s.starting_timestamp
+	FROM writing_sessions s
+	JOIN users u ON u.id = s.user_id
+	LEFT JOIN user_metadata m ON m.id = u.metadata_id
+	WHERE s.user_id IS NOT NULL AND s.ending_timestamp >= $1`
+
+// GetReminderCandidates returns every writer who finished a session since
+// the given time and didn't opt out of reminders.
+func (s *PostgresStore) GetReminderCandidates(ctx context.Context, since time.Time) ([]*ReminderCandidate, error) {
+	query := reminderStartsQuery + `
+		AND NOT EXISTS (SELECT 1 FROM reminder_opt_outs o WHERE o.user_id = s.user_id)
+		ORDER BY s.user_id, s.starting_timestamp`
+	return s.getReminderCandidates(ctx, query, since)
+}
+
+// GetReminderCandidate returns the writer's sessions finished since the
+// given time, whether they opted out of reminders or not.
+func (s *PostgresStore) GetReminderCandidate(ctx context.Context, userID uuid.UUID, since time.Time) (*ReminderCandidate, error) {
+	candidates, err := s.getReminderCandidates(ctx, reminderStartsQuery+` AND s.user_id = $2 ORDER BY s.starting_timestamp`, since, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) > 0 {
+		return candidates[0], nil
+	}
+
+	candidate := &ReminderCandidate{UserID: userID}
+	query := `
+		SELECT COALESCE(m.timezone, '')
+		FROM users u
+		LEFT JOIN user_metadata m ON m.id = u.metadata_id
+		WHERE u.id = $1`
+	if err := s.db.QueryRow(ctx, query, userID).Scan(&candidate.Timezone); err != nil {
+		return nil, fmt.Errorf("failed to get user timezone: %w", err)
+	}
+	return candidate, nil
+}
+
+func (s *PostgresStore) getReminderCandidates(ctx context.Context, query string, args ...interface{}) ([]*ReminderCandidate, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reminder candidates: %w", err)
+	}
+	defer rows.Close()
+
+	candidates := []*ReminderCandidate{}
+	var current *ReminderCandidate
+	for rows.Next() {
+		var userID uuid.UUID
+		var timezone string
+		var start time.Time
+		if err := rows.Scan(&userID, &timezone, &start); err != nil {
+			return nil, fmt.Errorf("failed to scan reminder candidate: %w", err)
+		}
+		if current == nil || current.UserID != userID {
+			current = &ReminderCandidate{UserID: userID, Timezone: timezone}
+			candidates = append(candidates, current)
+		}
+		current.Starts = append(current.Starts, start)
+	}
+	return candidates, rows.Err()
+}
+
+// CreateWritingReminder records a reminder. A writer is reminded once a
+// day, the result says whether this call recorded it.
+func (s *PostgresStore) CreateWritingReminder(ctx context.Context, reminder *types.WritingReminder) (bool, error) {
+	reminder.ID = s.IDs().NewID()
+	reminder.CreatedAt = s.Clock().Now()
+	query := `
+		INSERT INTO writing_reminders (id, user_id, reminder_date, scheduled_for, learned, created_at)
+		VALUES ($1, $2, $3::DATE, $4, $5, $6)
+		ON CONFLICT (user_id, reminder_date) DO NOTHING`
+	tag, err := s.db.Exec(ctx, query, reminder.ID, reminder.UserID, reminder.ReminderDate, reminder.ScheduledFor, reminder.Learned, reminder.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create writing reminder: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetWritingReminders returns the writer's most recent reminders first.
+func (s *PostgresStore) GetWritingReminders(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int) ([]*types.WritingReminder, error) {
+	query := `
+		SELECT ` + writingReminderColumns + `
+		FROM writing_reminders
+		WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC
+		LIMIT $3`
+	rows, err := s.db.Query(ctx, query, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writing reminders: %w", err)
+	}
+	defer rows.Close()
+
+	reminders := []*types.WritingReminder{}
+	for rows.Next() {
+		reminder := new(types.WritingReminder)
+		if err := rows.Scan(&reminder.ID, &reminder.UserID, &reminder.ReminderDate, &reminder.ScheduledFor, &reminder.Learned, &reminder.CreatedAt, &reminder.ReadAt); err != nil {
+			return nil, fmt.Errorf("failed to scan writing reminder: %w", err)
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, rows.Err()
+}
+
+// MarkWritingRemindersRead marks every unread reminder of the writer read
+// and returns how many there were.
+func (s *PostgresStore) MarkWritingRemindersRead(ctx context.Context, userID uuid.UUID) (int64, error) {
+	tag, err := s.db.Exec(ctx, `UPDATE writing_reminders SET read_at = $2 WHERE user_id = $1 AND read_at IS NULL`, userID, s.Clock().Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark writing reminders read: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
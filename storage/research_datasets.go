@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// contributedSessions are the sessions of opted in writers that ended in
+// [$1, $2), written after they opted in. Onboarding sessions aren't writing.
+const contributedSessions = `
+	WITH contributed AS (
+		SELECT ws.user_id, ws.starting_timestamp, ws.is_anky, ws.focus_score,
+			COALESCE(ws.time_spent, 0) AS seconds, COALESCE(ws.words_written, 0) AS words
+		FROM writing_sessions ws
+		JOIN dataset_opt_ins o ON o.user_id = ws.user_id
+		WHERE ws.ending_timestamp >= $1 AND ws.ending_timestamp < $2
+			AND ws.starting_timestamp >= o.opted_in_at
+			AND NOT COALESCE(ws.is_onboarding, FALSE)
+	)`
+
+// Session lengths are bucketed by minute up to the eight minutes of an Anky,
+// word counts by hundred up to a thousand.
+const (
+	datasetDurationBuckets = 8
+	datasetWordBuckets     = 10
+)
+
+func (s *PostgresStore) OptInToResearchDataset(ctx context.Context, userID uuid.UUID) error {
+	query := `INSERT INTO dataset_opt_ins (user_id, opted_in_at) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING`
+	if _, err := s.db.Exec(ctx, query, userID, s.Clock().Now()); err != nil {
+		return fmt.Errorf("failed to opt in to research dataset: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) OptOutOfResearchDataset(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM dataset_opt_ins WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to opt out of research dataset: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) IsOptedInToResearchDataset(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var optedIn bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM dataset_opt_ins WHERE user_id = $1)`, userID).Scan(&optedIn)
+	if err != nil {
+		return false, fmt.Errorf("failed to check research dataset opt in: %w", err)
+	}
+	return optedIn, nil
+}
+
+// GetResearchDatasetStats aggregates the sessions contributed between from
+// and to. Only counts leave the database.
+func (s *PostgresStore) GetResearchDatasetStats(ctx context.Context, from, to time.Time) (*types.ResearchDatasetStats, error) {
+	stats := &types.ResearchDatasetStats{}
+	query := contributedSessions + `
+		SELECT COUNT(DISTINCT user_id), COUNT(*), COUNT(*) FILTER (WHERE is_anky),
+			COALESCE(SUM(words), 0), COALESCE(SUM(seconds), 0),
+			COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY seconds), 0),
+			AVG(focus_score)
+		FROM contributed`
+	err := s.db.QueryRow(ctx, query, from, to).Scan(&stats.Contributors, &stats.Sessions, &stats.Ankys,
+		&stats.TotalWords, &stats.TotalSecondsWriting, &stats.MedianSessionSeconds, &stats.AverageFocusScore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate research dataset: %w", err)
+	}
+
+	durations, err := s.countContributedSessionsBy(ctx, from, to, fmt.Sprintf("LEAST(seconds / 60, %d)", datasetDurationBuckets))
+	if err != nil {
+		return nil, err
+	}
+	for bucket := 0; bucket <= datasetDurationBuckets; bucket++ {
+		label := fmt.Sprintf("%d-%d min", bucket, bucket+1)
+		if bucket == datasetDurationBuckets {
+			label = fmt.Sprintf("%d+ min", bucket)
+		}
+		stats.SessionsByDuration = append(stats.SessionsByDuration, types.DatasetBucket{Range: label, Count: durations[bucket]})
+	}
+
+	words, err := s.countContributedSessionsBy(ctx, from, to, fmt.Sprintf("LEAST(words / 100, %d)", datasetWordBuckets))
+	if err != nil {
+		return nil, err
+	}
+	for bucket := 0; bucket <= datasetWordBuckets; bucket++ {
+		label := fmt.Sprintf("%d-%d words", bucket*100, bucket*100+99)
+		if bucket == datasetWordBuckets {
+			label = fmt.Sprintf("%d+ words", bucket*100)
+		}
+		stats.SessionsByWords = append(stats.SessionsByWords, types.DatasetBucket{Range: label, Count: words[bucket]})
+	}
+
+	hours, err := s.countContributedSessionsBy(ctx, from, to, "EXTRACT(HOUR FROM starting_timestamp AT TIME ZONE 'UTC')")
+	if err != nil {
+		return nil, err
+	}
+	for hour := range stats.SessionsByHour {
+		stats.SessionsByHour[hour] = hours[hour]
+	}
+
+	weekdays, err := s.countContributedSessionsBy(ctx, from, to, "EXTRACT(ISODOW FROM starting_timestamp AT TIME ZONE 'UTC') - 1")
+	if err != nil {
+		return nil, err
+	}
+	for weekday := range stats.SessionsByWeekday {
+		stats.SessionsByWeekday[weekday] = weekdays[weekday]
+	}
+	return stats, nil
+}
+
+// countContributedSessionsBy counts the contributed sessions by the integer
+// the expression, one of the constants above, gives each of them.
+func (s *PostgresStore) countContributedSessionsBy(ctx context.Context, from, to time.Time, expression string) (map[int]int, error) {
+	query := contributedSessions + `
+		SELECT (` + expression + `)::INTEGER AS bucket, COUNT(*)
+		FROM contributed
+		GROUP BY bucket`
+	rows, err := s.db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count research dataset sessions: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var bucket, count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan research dataset bucket: %w", err)
+		}
+		counts[bucket] = count
+	}
+	return counts, rows.Err()
+}
+
+const researchDatasetColumns = `id, period_start, period_end, ipfs_hash, contributors, sessions, published_at`
+
+func scanResearchDataset(row pgx.Row) (*types.ResearchDataset, error) {
+	dataset := &types.ResearchDataset{}
+	err := row.Scan(&dataset.ID, &dataset.PeriodStart, &dataset.PeriodEnd, &dataset.IPFSHash,
+		&dataset.Contributors, &dataset.Sessions, &dataset.PublishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return dataset, nil
+}
+
+// CreateResearchDataset records a published dataset. A period is published
+// once; the result says whether this call recorded it.
+func (s *PostgresStore) CreateResearchDataset(ctx context.Context, dataset *types.ResearchDataset) (bool, error) {
+	dataset.ID = s.IDs().NewID()
+	dataset.PublishedAt = s.Clock().Now()
+	query := `
+		INSERT INTO research_datasets (` + researchDatasetColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (period_start) DO NOTHING`
+	tag, err := s.db.Exec(ctx, query, dataset.ID, dataset.PeriodStart, dataset.PeriodEnd, dataset.IPFSHash,
+		dataset.Contributors, dataset.Sessions, dataset.PublishedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create research dataset: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) ResearchDatasetExists(ctx context.Context, periodStart time.Time) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM research_datasets WHERE period_start = $1)`, periodStart).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check research dataset: %w", err)
+	}
+	return exists, nil
+}
+
+// GetLatestResearchDataset returns the dataset of the most recent period,
+// pgx.ErrNoRows before the first one is published.
+func (s *PostgresStore) GetLatestResearchDataset(ctx context.Context) (*types.ResearchDataset, error) {
+	query := `SELECT ` + researchDatasetColumns + ` FROM research_datasets ORDER BY period_start DESC LIMIT 1`
+	return scanResearchDataset(s.db.QueryRow(ctx, query))
+}
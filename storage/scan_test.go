@@ -0,0 +1,237 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// The stores read rows through explicit column lists; these tests write every
+// field with a value of its own and read it back, so a column scanned into
+// the wrong field shows.
+
+func TestUserRoundTrip(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	user := &types.User{
+		ID:            uuid.New(),
+		SeedPhrase:    "seed phrase",
+		WalletAddress: "0xwallet",
+		JWT:           "jwt",
+		CreatedAt:     now,
+		UpdatedAt:     now.Add(time.Second),
+		Settings:      &types.UserSettings{Language: "es", Username: "writer"},
+		FarcasterUser: &types.FarcasterUser{
+			FID:            18350,
+			Username:       "writer",
+			DisplayName:    "The Writer",
+			ProfilePicture: "https://example.com/pfp.png",
+			CustodyAddress: "0xcustody",
+			Bio:            "writes every morning",
+			FollowerCount:  21,
+			FollowingCount: 34,
+			SignerUUID:     "signer-1",
+		},
+		UserMetadata: &types.UserMetadata{
+			DeviceID:           "device-1",
+			Platform:           "ios",
+			DeviceModel:        "iPhone",
+			OSVersion:          "18.1",
+			AppVersion:         "2.3.0",
+			ScreenWidth:        390,
+			ScreenHeight:       844,
+			Locale:             "es-CL",
+			Timezone:           "America/Santiago",
+			CreatedAt:          now,
+			LastActive:         now.Add(time.Minute),
+			UserAgent:          "anky/2.3.0",
+			InstallationSource: "testflight",
+		},
+		PrivyUser: &types.PrivyUser{DID: "did:privy:writer", CreatedAt: now},
+	}
+	if err := store.CreateUserWithRelations(ctx, user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+
+	got, err := store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("reading user: %v", err)
+	}
+	if got.ID != user.ID || got.PrivyDID != "did:privy:writer" || got.FID != 18350 || got.SeedPhrase != "seed phrase" ||
+		got.WalletAddress != "0xwallet" || got.JWT != "jwt" || got.IsAnonymous || got.Version != 1 {
+		t.Errorf("user = %+v, want what was stored", got)
+	}
+	if !got.CreatedAt.Equal(now) || !got.UpdatedAt.Equal(now.Add(time.Second)) {
+		t.Errorf("created %v, updated %v, want %v and a second later", got.CreatedAt, got.UpdatedAt, now)
+	}
+	if got.Settings == nil || got.Settings.Language != "es" || got.Settings.Username != "writer" {
+		t.Errorf("settings = %+v", got.Settings)
+	}
+	if got.FarcasterUser == nil || *got.FarcasterUser != *user.FarcasterUser {
+		t.Errorf("farcaster user = %+v, want %+v", got.FarcasterUser, user.FarcasterUser)
+	}
+	if got.UserMetadata == nil {
+		t.Fatal("no user metadata")
+	}
+	gotMetadata, wantMetadata := *got.UserMetadata, *user.UserMetadata
+	if !gotMetadata.CreatedAt.Equal(wantMetadata.CreatedAt) || !gotMetadata.LastActive.Equal(wantMetadata.LastActive) {
+		t.Errorf("metadata times = %v / %v, want %v / %v", gotMetadata.CreatedAt, gotMetadata.LastActive, wantMetadata.CreatedAt, wantMetadata.LastActive)
+	}
+	gotMetadata.CreatedAt, gotMetadata.LastActive = wantMetadata.CreatedAt, wantMetadata.LastActive
+	if gotMetadata != wantMetadata {
+		t.Errorf("metadata = %+v, want %+v", gotMetadata, wantMetadata)
+	}
+	if got.PrivyUser == nil || got.PrivyUser.DID != "did:privy:writer" || got.PrivyUser.UserID != user.ID {
+		t.Errorf("privy user = %+v", got.PrivyUser)
+	}
+}
+
+func TestWritingSessionRoundTrip(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	user := createTestUser(t, store, false)
+	parent := createTestAnky(t, store, &types.Anky{})
+
+	startedAt := time.Now().UTC().Truncate(time.Microsecond)
+	endedAt := startedAt.Add(8 * time.Minute)
+	timeSpent := 480
+	response := "keep going"
+	session := &types.WritingSession{
+		ID:                  uuid.New(),
+		UserID:              user.ID,
+		SessionIndexForUser: 3,
+		StartingTimestamp:   startedAt,
+		Prompt:              "what is alive in you this morning?",
+		Status:              "active",
+		Writing:             "the morning light",
+		ParentAnkyID:        &parent.ID,
+		AnkyResponse:        &response,
+		IsOnboarding:        true,
+	}
+	if err := store.CreateWritingSession(ctx, session); err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	session.Status = "completed"
+	session.Writing = "the morning light came through the window"
+	session.WordsWritten = 7
+	session.NewenEarned = 2.5
+	session.TimeSpent = &timeSpent
+	session.EndingTimestamp = &endedAt
+	session.IsAnky = true
+	session.AnkyID = &parent.ID
+	if err := store.UpdateWritingSession(ctx, session); err != nil {
+		t.Fatalf("updating session: %v", err)
+	}
+	focus := &types.FocusMetrics{Score: 81, Label: "flowing", LongestFlowSeconds: 200, PauseCount: 4, MedianDelayMs: 140}
+	paste := &types.PasteMetrics{Flagged: true, TotalCharacters: 300, PastedCharacters: 120, LargestPaste: 100}
+	cheat := &types.CheatMetrics{Score: 40, Suspect: true, Reasons: []string{"pasted"}, PastedShare: 0.4}
+	if err := store.UpdateWritingSessionFocus(ctx, session.ID, focus); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateWritingSessionPaste(ctx, session.ID, paste); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateWritingSessionSuspect(ctx, session.ID, cheat); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetWritingSessionById(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("reading session: %v", err)
+	}
+	if got.ID != session.ID || got.UserID != user.ID || got.SessionIndexForUser != 3 || got.Prompt != session.Prompt ||
+		got.Status != "completed" || got.Writing != session.Writing || got.WordsWritten != 7 || got.NewenEarned != 2.5 ||
+		!got.IsAnky || !got.IsOnboarding {
+		t.Errorf("session = %+v, want what was stored", got)
+	}
+	if !got.StartingTimestamp.Equal(startedAt) || got.EndingTimestamp == nil || !got.EndingTimestamp.Equal(endedAt) {
+		t.Errorf("started %v, ended %v, want %v and %v", got.StartingTimestamp, got.EndingTimestamp, startedAt, endedAt)
+	}
+	if got.TimeSpent == nil || *got.TimeSpent != timeSpent {
+		t.Errorf("time spent = %v, want %d", got.TimeSpent, timeSpent)
+	}
+	if got.ParentAnkyID == nil || *got.ParentAnkyID != parent.ID || got.AnkyID == nil || *got.AnkyID != parent.ID {
+		t.Errorf("parent anky %v, anky %v, want %s", got.ParentAnkyID, got.AnkyID, parent.ID)
+	}
+	if got.AnkyResponse == nil || *got.AnkyResponse != response {
+		t.Errorf("anky response = %v, want %q", got.AnkyResponse, response)
+	}
+	if got.FocusScore == nil || *got.FocusScore != 81 || got.FocusMetrics == nil || got.FocusMetrics.Label != "flowing" || got.FocusMetrics.PauseCount != 4 {
+		t.Errorf("focus = %v %+v", got.FocusScore, got.FocusMetrics)
+	}
+	if !got.PasteFlagged || got.PasteMetrics == nil || got.PasteMetrics.PastedCharacters != 120 {
+		t.Errorf("paste = %v %+v", got.PasteFlagged, got.PasteMetrics)
+	}
+	if !got.Suspect || got.SuspectScore == nil || *got.SuspectScore != 40 || got.CheatMetrics == nil || len(got.CheatMetrics.Reasons) != 1 {
+		t.Errorf("cheat = %v %v %+v", got.Suspect, got.SuspectScore, got.CheatMetrics)
+	}
+	if got.Archived || got.ArchivedAt != nil {
+		t.Errorf("archived = %v at %v, want it in the database", got.Archived, got.ArchivedAt)
+	}
+}
+
+func TestAnkyRoundTripThroughUpdateAnky(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	anky := createTestAnky(t, store, &types.Anky{Status: "starting_processing", FID: 18350})
+
+	stored, err := store.GetAnkyByID(ctx, anky.ID)
+	if err != nil {
+		t.Fatalf("reading anky: %v", err)
+	}
+	stored.AnkyReflection = "you wrote about the light"
+	stored.ImagePrompt = "a blue being by the window"
+	stored.FollowUpPrompt = "what did the light show you?"
+	stored.ImageURL = "https://example.com/anky.png"
+	stored.ImageIPFSHash = "QmImage"
+	stored.Status = "completed"
+	stored.CastHash = "0xcast"
+	stored.LastUpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	stored.Ticker = "DREAM"
+	stored.TokenName = "Wisdom Light Dancing"
+	stored.StorageDegraded = true
+	stored.MetadataURI = "ipfs://QmMetadata"
+	if err := store.UpdateAnky(ctx, stored); err != nil {
+		t.Fatalf("updating anky: %v", err)
+	}
+
+	got, err := store.GetAnkyByID(ctx, anky.ID)
+	if err != nil {
+		t.Fatalf("reading anky back: %v", err)
+	}
+	if got.ID != anky.ID || got.FID != 18350 {
+		t.Errorf("id %s, fid %d, want %s and 18350", got.ID, got.FID, anky.ID)
+	}
+	if got.UserID != anky.UserID || got.WritingSessionID != anky.WritingSessionID || got.ChosenPrompt != anky.ChosenPrompt {
+		t.Errorf("user %s, session %s, prompt %q, want them unchanged", got.UserID, got.WritingSessionID, got.ChosenPrompt)
+	}
+	if got.AnkyReflection != stored.AnkyReflection || got.ImagePrompt != stored.ImagePrompt || got.FollowUpPrompt != stored.FollowUpPrompt ||
+		got.ImageURL != stored.ImageURL || got.ImageIPFSHash != stored.ImageIPFSHash || got.Status != "completed" ||
+		got.CastHash != "0xcast" || got.Ticker != "DREAM" || got.TokenName != stored.TokenName || !got.StorageDegraded ||
+		got.MetadataURI != stored.MetadataURI || got.License != types.DefaultLicense {
+		t.Errorf("anky = %+v, want what was stored", got)
+	}
+	if !got.LastUpdatedAt.Equal(stored.LastUpdatedAt) {
+		t.Errorf("last updated %v, want %v", got.LastUpdatedAt, stored.LastUpdatedAt)
+	}
+	if got.Version != stored.Version || got.Version < 2 {
+		t.Errorf("version = %d, UpdateAnky returned %d", got.Version, stored.Version)
+	}
+
+	// An update from the version before is refused
+	stale := *got
+	stale.Version--
+	stale.Status = "failed"
+	if err := store.UpdateAnky(ctx, &stale); !errors.Is(err, storage.ErrVersionConflict) {
+		t.Errorf("stale update error = %v, want a version conflict", err)
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgx/v4"
+)
+
+const seasonColumns = `number, name, pipeline, starts_at, created_at, updated_at`
+
+func scanSeason(row pgx.Row) (*types.Season, error) {
+	season := new(types.Season)
+	var pipeline []byte
+	err := row.Scan(
+		&season.Number,
+		&season.Name,
+		&pipeline,
+		&season.StartsAt,
+		&season.CreatedAt,
+		&season.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(pipeline, &season.Pipeline); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pipeline of season %d: %w", season.Number, err)
+	}
+	return season, nil
+}
+
+// UpsertSeason creates the season or replaces its name, pipeline and start.
+func (s *PostgresStore) UpsertSeason(ctx context.Context, season *types.Season) error {
+	pipeline, err := json.Marshal(season.Pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to marshal season pipeline: %w", err)
+	}
+
+	now := s.Clock().Now()
+	query := `
+		INSERT INTO seasons (number, name, pipeline, starts_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (number) DO UPDATE SET
+			name = EXCLUDED.name,
+			pipeline = EXCLUDED.pipeline,
+			starts_at = EXCLUDED.starts_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING ` + seasonColumns
+	saved, err := scanSeason(s.db.QueryRow(ctx, query, season.Number, season.Name, pipeline, season.StartsAt, now))
+	if err != nil {
+		return fmt.Errorf("failed to save season: %w", err)
+	}
+	*season = *saved
+	return nil
+}
+
+func (s *PostgresStore) GetSeasons(ctx context.Context) ([]*types.Season, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+seasonColumns+` FROM seasons ORDER BY starts_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get seasons: %w", err)
+	}
+	defer rows.Close()
+
+	seasons := make([]*types.Season, 0)
+	for rows.Next() {
+		season, err := scanSeason(rows)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, season)
+	}
+	return seasons, rows.Err()
+}
+
+// GetCurrentSeason returns the last season that started, pgx.ErrNoRows when
+// none did.
+func (s *PostgresStore) GetCurrentSeason(ctx context.Context) (*types.Season, error) {
+	query := `SELECT ` + seasonColumns + ` FROM seasons WHERE starts_at <= $1 ORDER BY starts_at DESC LIMIT 1`
+	return scanSeason(s.db.QueryRow(ctx, query, s.Clock().Now()))
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+// WritingChecksum is the checksum kept for archived writing, to verify it
+// when it's rehydrated.
+func WritingChecksum(writing string) string {
+	sum := sha256.Sum256([]byte(writing))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetSessionsToArchive returns up to limit ended sessions, oldest first, that
+// ended before the given time and still have their writing.
+func (s *PostgresStore) GetSessionsToArchive(ctx context.Context, endedBefore time.Time, limit int) ([]*types.WritingSession, error) {
+	query := `
+		SELECT ` + writingSessionColumns + ` FROM writing_sessions
+		WHERE NOT archived AND ending_timestamp IS NOT NULL AND ending_timestamp < $1 AND writing <> ''
+		ORDER BY ending_timestamp
+		LIMIT $2`
+	rows, err := s.db.Query(ctx, query, endedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions to archive: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]*types.WritingSession, 0)
+	for rows.Next() {
+		session, err := scanIntoWritingSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// MarkWritingSessionArchived empties the writing of a session whose writing
+// was stored under archiveKey. It reports false, and leaves the session alone,
+// when the writing changed since it was read for archiving.
+func (s *PostgresStore) MarkWritingSessionArchived(ctx context.Context, sessionID uuid.UUID, archiveKey string, writing string) (bool, error) {
+	query := `
+		UPDATE writing_sessions
+		SET archived = TRUE, archived_at = $1, archive_key = $2, archive_checksum = $3, writing = ''
+		WHERE id = $4 AND NOT archived AND writing = $5`
+	tag, err := s.db.Exec(ctx, query, s.Clock().Now(), archiveKey, WritingChecksum(writing), sessionID, writing)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark writing session archived: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetUserArchiveKeys returns the archive keys of the user's archived sessions.
+func (s *PostgresStore) GetUserArchiveKeys(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	rows, err := s.db.Query(ctx, `SELECT archive_key FROM writing_sessions WHERE user_id = $1 AND archived AND archive_key IS NOT NULL`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archive keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresStore) GetSessionArchiveStats(ctx context.Context, endedBefore time.Time) (*types.SessionArchiveStats, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE archived),
+			COUNT(*) FILTER (WHERE NOT archived AND ending_timestamp IS NOT NULL AND ending_timestamp < $1 AND writing <> ''),
+			MAX(archived_at)
+		FROM writing_sessions`
+	stats := new(types.SessionArchiveStats)
+	err := s.db.QueryRow(ctx, query, endedBefore).Scan(&stats.ArchivedSessions, &stats.PendingSessions, &stats.LastArchivedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session archive stats: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	// ErrHandoffCodeTaken is returned when the code is live for another session.
+	ErrHandoffCodeTaken = errors.New("handoff code already in use")
+	// ErrHandoffNotFound is returned for codes that are unknown, expired or
+	// already redeemed, which callers shouldn't be able to tell apart.
+	ErrHandoffNotFound = errors.New("handoff code not found")
+)
+
+const sessionHandoffColumns = `id, code, session_id, user_id, fid, prompt, created_at, expires_at, redeemed_at`
+
+func scanSessionHandoff(row pgx.Row) (*types.SessionHandoff, error) {
+	handoff := new(types.SessionHandoff)
+	var fid *int
+	err := row.Scan(
+		&handoff.ID,
+		&handoff.Code,
+		&handoff.SessionID,
+		&handoff.UserID,
+		&fid,
+		&handoff.Prompt,
+		&handoff.CreatedAt,
+		&handoff.ExpiresAt,
+		&handoff.RedeemedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if fid != nil {
+		handoff.FID = *fid
+	}
+	return handoff, nil
+}
+
+// CreateSessionHandoff stores the handoff and fills in its ID and creation
+// time. Codes the session had and codes that expired are dropped in the same
+// transaction, so a session has one live code and expired codes can be
+// handed out again. It returns ErrHandoffCodeTaken when the code is live.
+func (s *PostgresStore) CreateSessionHandoff(ctx context.Context, handoff *types.SessionHandoff) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin creating session handoff: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	handoff.ID = s.IDs().NewID()
+	if handoff.CreatedAt.IsZero() {
+		handoff.CreatedAt = s.Clock().Now().UTC()
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM session_handoffs
+		WHERE redeemed_at IS NULL AND (session_id = $1 OR expires_at <= $2)
+	`, handoff.SessionID, handoff.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to drop stale session handoffs: %w", classifyQueryError(ctx, err))
+	}
+
+	var fid *int
+	if handoff.FID != 0 {
+		fid = &handoff.FID
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO session_handoffs (`+sessionHandoffColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`,
+		handoff.ID,
+		handoff.Code,
+		handoff.SessionID,
+		handoff.UserID,
+		fid,
+		handoff.Prompt,
+		handoff.CreatedAt,
+		handoff.ExpiresAt,
+		handoff.RedeemedAt,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrHandoffCodeTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create session handoff: %w", classifyQueryError(ctx, err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit session handoff: %w", classifyQueryError(ctx, err))
+	}
+	return nil
+}
+
+// RedeemSessionHandoff marks the live handoff with the code redeemed at the
+// given time and returns it. Each code can be redeemed once; codes that are
+// unknown, expired or used get ErrHandoffNotFound.
+func (s *PostgresStore) RedeemSessionHandoff(ctx context.Context, code string, at time.Time) (*types.SessionHandoff, error) {
+	query := `
+		UPDATE session_handoffs SET redeemed_at = $2
+		WHERE code = $1 AND redeemed_at IS NULL AND expires_at > $2
+		RETURNING ` + sessionHandoffColumns
+	handoff, err := scanSessionHandoff(s.db.QueryRow(ctx, query, code, at))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrHandoffNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem session handoff: %w", err)
+	}
+	return handoff, nil
+}
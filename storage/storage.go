@@ -3,16 +3,20 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/ankylat/anky/server/types"
+	"github.com/ankylat/anky/server/utils"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	_ "github.com/lib/pq"
@@ -23,7 +27,7 @@ type Storage interface {
 	// User operations
 	GetUsers(ctx context.Context) ([]*types.User, error)
 	GetUserByID(ctx context.Context, userID uuid.UUID) (*types.User, error)
-	CreateUser(ctx context.Context, user *types.User) error
+	CreateUserWithRelations(ctx context.Context, user *types.User) error
 	UpdateUser(ctx context.Context, userID uuid.UUID, user *types.User) error
 	DeleteUser(ctx context.Context, userID uuid.UUID) error
 
@@ -48,7 +52,46 @@ type Storage interface {
 }
 
 type PostgresStore struct {
-	db *pgxpool.Pool
+	db    *timeoutDB
+	locks *lockConn
+	clock utils.Clock
+	ids   utils.IDGenerator
+}
+
+// Clock is the clock the store stamps records with. Services built on the
+// store read the time from it too.
+func (s *PostgresStore) Clock() utils.Clock {
+	if s == nil || s.clock == nil {
+		return utils.SystemClock
+	}
+	return s.clock
+}
+
+// IDs generates the IDs of the records the store and its services create.
+func (s *PostgresStore) IDs() utils.IDGenerator {
+	if s == nil || s.ids == nil {
+		return utils.RandomIDs
+	}
+	return s.ids
+}
+
+// SetClock replaces the system clock, for tests.
+func (s *PostgresStore) SetClock(clock utils.Clock) {
+	s.clock = clock
+}
+
+// SetIDGenerator replaces random UUIDs, for tests.
+func (s *PostgresStore) SetIDGenerator(ids utils.IDGenerator) {
+	s.ids = ids
+}
+
+// Close waits for the queries in flight and closes every connection of the
+// pool, and the one holding the advisory locks.
+func (s *PostgresStore) Close() {
+	if s.locks != nil {
+		s.locks.close()
+	}
+	s.db.Close()
 }
 
 func NewPostgresStore() (*PostgresStore, error) {
@@ -56,25 +99,42 @@ func NewPostgresStore() (*PostgresStore, error) {
 	if connStr == "" {
 		return nil, fmt.Errorf("DATABASE_URL is not set")
 	}
+	return OpenPostgresStore(connStr, "storage/migrations")
+}
+
+// OpenPostgresStore connects to the database at connStr and migrates it with
+// the migrations in migrationsDir.
+func OpenPostgresStore(connStr string, migrationsDir string) (*PostgresStore, error) {
+	config, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing database URL: %w", err)
+	}
+
+	// The server side statement_timeout is a backstop in case the client side
+	// cancellation never reaches Postgres
+	queryTimeout := queryTimeoutFromEnv()
+	config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt((queryTimeout + time.Second).Milliseconds(), 10)
 
 	// Connect to database
-	db, err := pgxpool.Connect(context.Background(), connStr)
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
+	db := &timeoutDB{pool: pool, timeout: queryTimeout}
+	log.Printf("⏱️ Storage query timeout set to %s", queryTimeout)
 
 	// Run migrations
-	if err := runMigrations(connStr); err != nil {
+	if err := runMigrations(connStr, migrationsDir); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	return &PostgresStore{db: db}, nil
+	return &PostgresStore{db: db, locks: newLockConn(config.ConnConfig.Copy()), clock: utils.SystemClock, ids: utils.RandomIDs}, nil
 }
 
-func runMigrations(connStr string) error {
+func runMigrations(connStr string, migrationsDir string) error {
 	m, err := migrate.New(
-		"file://storage/migrations",
+		"file://"+migrationsDir,
 		connStr,
 	)
 	if err != nil {
@@ -91,11 +151,30 @@ func runMigrations(connStr string) error {
 
 // ******************** User operations ********************
 
+// selectUsers reads users with their Farcaster account, metadata and Privy
+// DID, in the order scanIntoUser scans them. Queries append their WHERE
+// clause, on the u alias.
+const selectUsers = `
+	SELECT
+		u.id, u.privy_did, u.fid, u.settings, u.seed_phrase, u.wallet_address,
+		u.created_at, u.updated_at, u.jwt, u.is_anonymous, u.version,
+		f.id, COALESCE(f.fid, 0), COALESCE(f.username, ''), COALESCE(f.display_name, ''),
+		COALESCE(f.pfp_url, ''), COALESCE(f.custody_address, ''), COALESCE(f.bio, ''),
+		COALESCE(f.follower_count, 0), COALESCE(f.following_count, 0), COALESCE(f.signer_uuid, ''),
+		m.id, COALESCE(m.device_id, ''), COALESCE(m.platform, ''), COALESCE(m.device_model, ''),
+		COALESCE(m.os_version, ''), COALESCE(m.app_version, ''), COALESCE(m.screen_width, 0),
+		COALESCE(m.screen_height, 0), COALESCE(m.locale, ''), COALESCE(m.timezone, ''),
+		m.created_at, m.last_active, COALESCE(m.user_agent, ''), COALESCE(m.installation_source, ''),
+		p.did, p.created_at
+	FROM users u
+	LEFT JOIN farcaster_users f ON f.id = u.farcaster_user_id
+	LEFT JOIN user_metadata m ON m.id = u.metadata_id
+	LEFT JOIN privy_users p ON p.did = u.privy_did
+`
+
 func (s *PostgresStore) GetUsers(ctx context.Context, limit int, offset int) ([]*types.User, error) {
-	query := `
-        SELECT id, privy_did, fid, settings, seed_phrase, wallet_address, jwt, created_at, updated_at 
-        FROM users 
-        ORDER BY created_at DESC
+	query := selectUsers + `
+        ORDER BY u.created_at DESC
         LIMIT $1 OFFSET $2
     `
 	rows, err := s.db.Query(ctx, query, limit, offset)
@@ -123,7 +202,7 @@ func (s *PostgresStore) GetUsers(ctx context.Context, limit int, offset int) ([]
 func (s *PostgresStore) GetUserByID(ctx context.Context, userID uuid.UUID) (*types.User, error) {
 	log.Printf("[DB] Getting user with ID: %s", userID)
 
-	query := `SELECT * FROM users WHERE id = $1`
+	query := selectUsers + `WHERE u.id = $1`
 	log.Printf("[DB] Executing query: %s with ID: %s", query, userID)
 
 	row := s.db.QueryRow(ctx, query, userID)
@@ -135,16 +214,64 @@ func (s *PostgresStore) GetUserByID(ctx context.Context, userID uuid.UUID) (*typ
 		return nil, err
 	}
 
-	log.Printf("[DB] Successfully scanned user: %+v", user)
+	log.Printf("[DB] Successfully scanned user: %s", user.ID)
 	return user, nil
 }
 
-func (s *PostgresStore) CreateUser(ctx context.Context, user *types.User) error {
-	query := `
-		INSERT INTO users (id, privy_did, fid, settings, seed_phrase, wallet_address, jwt, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`
-	_, err := s.db.Exec(ctx, query,
+// GetUserByFID returns the oldest account linked to the FID, wrapping pgx.ErrNoRows when there is none.
+func (s *PostgresStore) GetUserByFID(ctx context.Context, fid int) (*types.User, error) {
+	query := selectUsers + `WHERE u.fid = $1 ORDER BY u.created_at ASC LIMIT 1`
+	return scanIntoUser(s.db.QueryRow(ctx, query, fid))
+}
+
+// CreateUserWithRelations inserts the user together with its user_metadata
+// row and, when set, its Farcaster account and Privy DID, all or nothing.
+// The user points at the metadata and Farcaster rows through metadata_id and
+// farcaster_user_id.
+func (s *PostgresStore) CreateUserWithRelations(ctx context.Context, user *types.User) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin user creation: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	var farcasterUserID *uuid.UUID
+	if farcasterUser := user.FarcasterUser; farcasterUser != nil {
+		id := s.IDs().NewID()
+		_, err := tx.Exec(ctx, `
+			INSERT INTO farcaster_users (id, fid, username, display_name, pfp_url, custody_address, bio, follower_count, following_count, signer_uuid)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`,
+			id,
+			farcasterUser.FID,
+			farcasterUser.Username,
+			farcasterUser.DisplayName,
+			farcasterUser.ProfilePicture,
+			farcasterUser.CustodyAddress,
+			farcasterUser.Bio,
+			farcasterUser.FollowerCount,
+			farcasterUser.FollowingCount,
+			farcasterUser.SignerUUID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert farcaster user: %w", classifyQueryError(ctx, err))
+		}
+		farcasterUserID = &id
+		if user.FID == 0 {
+			user.FID = farcasterUser.FID
+		}
+	}
+	if user.PrivyUser != nil && user.PrivyDID == "" {
+		user.PrivyDID = user.PrivyUser.DID
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO users (id, privy_did, fid, settings, seed_phrase, wallet_address, jwt, created_at, updated_at, farcaster_user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`,
 		user.ID,
 		user.PrivyDID,
 		user.FID,
@@ -154,12 +281,75 @@ func (s *PostgresStore) CreateUser(ctx context.Context, user *types.User) error
 		user.JWT,
 		user.CreatedAt,
 		user.UpdatedAt,
+		farcasterUserID,
 	)
-	return err
+	if err != nil {
+		return fmt.Errorf("failed to insert user: %w", classifyQueryError(ctx, err))
+	}
+
+	// user_metadata references the user, so the user is pointed at it afterwards
+	if user.UserMetadata == nil {
+		user.UserMetadata = &types.UserMetadata{}
+	}
+	metadata := user.UserMetadata
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = user.CreatedAt
+	}
+	if metadata.LastActive.IsZero() {
+		metadata.LastActive = metadata.CreatedAt
+	}
+	_, err = tx.Exec(ctx, `
+		WITH inserted AS (
+			INSERT INTO user_metadata (
+				id, user_id, device_id, platform, device_model, os_version, app_version,
+				screen_width, screen_height, locale, timezone, created_at, last_active,
+				user_agent, installation_source
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			RETURNING id
+		)
+		UPDATE users SET metadata_id = (SELECT id FROM inserted) WHERE id = $2
+	`,
+		s.IDs().NewID(),
+		user.ID,
+		metadata.DeviceID,
+		metadata.Platform,
+		metadata.DeviceModel,
+		metadata.OSVersion,
+		metadata.AppVersion,
+		metadata.ScreenWidth,
+		metadata.ScreenHeight,
+		metadata.Locale,
+		metadata.Timezone,
+		metadata.CreatedAt,
+		metadata.LastActive,
+		metadata.UserAgent,
+		metadata.InstallationSource,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert user metadata: %w", classifyQueryError(ctx, err))
+	}
+
+	if privyUser := user.PrivyUser; privyUser != nil {
+		privyUser.UserID = user.ID
+		if privyUser.CreatedAt.IsZero() {
+			privyUser.CreatedAt = user.CreatedAt
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO privy_users (did, user_id, created_at) VALUES ($1, $2, $3)`,
+			privyUser.DID, privyUser.UserID, privyUser.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert privy user: %w", classifyQueryError(ctx, err))
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit user creation: %w", classifyQueryError(ctx, err))
+	}
+	user.Version = 1
+	return nil
 }
 
 func (s *PostgresStore) GetAnkysByUserIDAndStatus(ctx context.Context, userID uuid.UUID, status string) ([]*types.Anky, error) {
-	query := `SELECT * FROM ankys WHERE user_id = $1 AND status = $2`
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE user_id = $1 AND status = $2`
 	rows, err := s.db.Query(ctx, query, userID, status)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get ankys by user ID and status: %w", err)
@@ -182,14 +372,101 @@ func (s *PostgresStore) GetAnkysByUserIDAndStatus(ctx context.Context, userID uu
 	return ankys, nil
 }
 
+// CountNumberOfFids counts every FID registered this season, including the
+// ones that were unlinked afterwards.
 func (s *PostgresStore) CountNumberOfFids(ctx context.Context) (int, error) {
-	query := `SELECT COUNT(*) FROM farcaster_users`
+	query := `SELECT (SELECT COUNT(*) FROM farcaster_users) + (SELECT COUNT(*) FROM farcaster_unlinks)`
 	row := s.db.QueryRow(ctx, query)
 	var count int
 	err := row.Scan(&count)
 	return count, err
 }
 
+// LinkFarcasterUser stores the Farcaster account and points the user at it.
+func (s *PostgresStore) LinkFarcasterUser(ctx context.Context, userID uuid.UUID, farcasterUser *types.FarcasterUser) error {
+	query := `
+		WITH linked AS (
+			INSERT INTO farcaster_users (fid, username, display_name, pfp_url, custody_address, bio, signer_uuid)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		)
+		UPDATE users
+		SET fid = $1, farcaster_user_id = (SELECT id FROM linked), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $8
+	`
+	_, err := s.db.Exec(ctx, query,
+		farcasterUser.FID,
+		farcasterUser.Username,
+		farcasterUser.DisplayName,
+		farcasterUser.ProfilePicture,
+		farcasterUser.CustodyAddress,
+		farcasterUser.Bio,
+		farcasterUser.SignerUUID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to link farcaster user: %w", err)
+	}
+	return nil
+}
+
+// ErrUnlinkLimitReached is returned by UnlinkFarcasterUser when the user
+// already unlinked as many Farcaster accounts as they are allowed to.
+var ErrUnlinkLimitReached = errors.New("farcaster unlink limit reached")
+
+// UnlinkFarcasterUser detaches the Farcaster account from the user, deletes
+// its farcaster_users row and records the unlink, unless the user already
+// has maxUnlinks recorded. The count is taken under the user's row lock, so
+// concurrent unlinks can't go over it. It returns the FID that was unlinked,
+// or 0 if the user had none, and how many unlinks the user now has.
+func (s *PostgresStore) UnlinkFarcasterUser(ctx context.Context, userID uuid.UUID, reason string, maxUnlinks int) (fid int, unlinks int, err error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin unlinking farcaster user: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	var farcasterUserID *uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT COALESCE(fid, 0), farcaster_user_id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&fid, &farcasterUserID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to lock user: %w", classifyQueryError(ctx, err))
+	}
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM farcaster_unlinks WHERE user_id = $1`, userID).Scan(&unlinks)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count farcaster unlinks: %w", classifyQueryError(ctx, err))
+	}
+	if unlinks >= maxUnlinks {
+		return 0, unlinks, ErrUnlinkLimitReached
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET fid = 0, farcaster_user_id = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, userID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to unlink farcaster user: %w", classifyQueryError(ctx, err))
+	}
+	if farcasterUserID != nil {
+		if _, err := tx.Exec(ctx, `DELETE FROM farcaster_users WHERE id = $1`, *farcasterUserID); err != nil {
+			return 0, 0, fmt.Errorf("failed to delete farcaster user: %w", classifyQueryError(ctx, err))
+		}
+	}
+	if fid > 0 {
+		_, err = tx.Exec(ctx, `INSERT INTO farcaster_unlinks (user_id, fid, reason) VALUES ($1, $2, $3)`, userID, fid, reason)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to record farcaster unlink: %w", classifyQueryError(ctx, err))
+		}
+		unlinks++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit farcaster unlink: %w", classifyQueryError(ctx, err))
+	}
+	return fid, unlinks, nil
+}
+
+// UpdateUser saves the user if it is still at user.Version, which it then
+// bumps. It returns a *VersionConflictError when the user changed since it
+// was read.
 func (s *PostgresStore) UpdateUser(ctx context.Context, userID uuid.UUID, user *types.User) error {
 	log.Printf("[DB] Updating user %s", userID)
 
@@ -218,10 +495,13 @@ func (s *PostgresStore) UpdateUser(ctx context.Context, userID uuid.UUID, user *
 			wallet_address = $5, 
 			jwt = $6, 
 			updated_at = CURRENT_TIMESTAMP,
-			is_anonymous = false
-		WHERE id = $7
+			is_anonymous = false,
+			version = version + 1
+		WHERE id = $7 AND ($8 = 0 OR version = $8)
+		RETURNING version
 	`
-	_, err = s.db.Exec(ctx, query,
+	var version int
+	err = s.db.QueryRow(ctx, query,
 		user.PrivyDID,
 		user.FID,
 		settingsJSON,
@@ -229,21 +509,77 @@ func (s *PostgresStore) UpdateUser(ctx context.Context, userID uuid.UUID, user *
 		user.WalletAddress,
 		user.JWT,
 		userID,
-	)
-
+		user.Version,
+	).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = s.versionConflict(ctx, "users", "user", userID, user.Version)
+	}
 	if err != nil {
 		log.Printf("[DB] Update error: %v", err)
 		return err
 	}
+	user.Version = version
 
 	log.Printf("[DB] Successfully updated user")
 	return nil
 }
 
+// UpdateUserSeedPhrase replaces the user's encrypted seed phrase, used when the encryption key is rotated.
+// It bumps the version so updates read before can't write the old seed phrase back.
+func (s *PostgresStore) UpdateUserSeedPhrase(ctx context.Context, userID uuid.UUID, seedPhrase string) error {
+	tag, err := s.db.Exec(ctx, `UPDATE users SET seed_phrase = $1, version = version + 1 WHERE id = $2`, seedPhrase, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user seed phrase: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	return nil
+}
+
+// DeleteUser deletes the user with their writing sessions, Ankys, badges and
+// Farcaster account in one transaction; the rest of what they owned, newen
+// ledger included, cascades. What lives outside the database, listed by
+// GetUserFootprint, has to be read first and removed by the caller.
 func (s *PostgresStore) DeleteUser(ctx context.Context, userID uuid.UUID) error {
-	query := `DELETE FROM users WHERE id = $1`
-	_, err := s.db.Exec(ctx, query, userID)
-	return err
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin deleting user: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	var farcasterUserID *uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT farcaster_user_id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&farcasterUserID)
+	if err != nil {
+		return fmt.Errorf("failed to lock user: %w", classifyQueryError(ctx, err))
+	}
+
+	// Sessions and Ankys point at each other, so the links are cut first
+	deletes := []string{
+		`UPDATE writing_sessions SET anky_id = NULL WHERE user_id = $1 OR anky_id IN (SELECT id FROM ankys WHERE user_id = $1)`,
+		`DELETE FROM ankys WHERE user_id = $1 OR writing_session_id IN (SELECT id FROM writing_sessions WHERE user_id = $1)`,
+		`DELETE FROM writing_sessions WHERE user_id = $1`,
+		`DELETE FROM badges WHERE user_id = $1`,
+		`DELETE FROM anky_license_changes WHERE user_id = $1`,
+		`DELETE FROM users WHERE id = $1`,
+	}
+	for _, query := range deletes {
+		if _, err := tx.Exec(ctx, query, userID); err != nil {
+			return fmt.Errorf("failed to delete user data: %w", classifyQueryError(ctx, err))
+		}
+	}
+	if farcasterUserID != nil {
+		if _, err := tx.Exec(ctx, `DELETE FROM farcaster_users WHERE id = $1`, *farcasterUserID); err != nil {
+			return fmt.Errorf("failed to delete farcaster user: %w", classifyQueryError(ctx, err))
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit user deletion: %w", classifyQueryError(ctx, err))
+	}
+	return nil
 }
 
 // ******************** Privy user operations ********************
@@ -254,6 +590,74 @@ func (s *PostgresStore) CreatePrivyUser(ctx context.Context, user *types.PrivyUs
 	return err
 }
 
+// ErrPrivyDIDTaken is returned when the Privy DID is linked to another user.
+var ErrPrivyDIDTaken = errors.New("this privy account is linked to another user")
+
+// SavePrivyUser links the Privy DID to user.UserID and replaces its linked
+// accounts with user.LinkedAccounts, in one transaction. It returns
+// ErrPrivyDIDTaken when the DID belongs to someone else.
+func (s *PostgresStore) SavePrivyUser(ctx context.Context, user *types.PrivyUser) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin saving privy user: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	var did string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO privy_users (did, user_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (did) DO UPDATE SET created_at = EXCLUDED.created_at
+		WHERE privy_users.user_id = EXCLUDED.user_id
+		RETURNING did
+	`, user.DID, user.UserID, user.CreatedAt).Scan(&did)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPrivyDIDTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save privy user: %w", classifyQueryError(ctx, err))
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM linked_accounts WHERE privy_user_id = $1`, user.DID); err != nil {
+		return fmt.Errorf("failed to clear linked accounts: %w", classifyQueryError(ctx, err))
+	}
+	for _, account := range user.LinkedAccounts {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO linked_accounts (
+				id, privy_user_id, type, address, chain_type, fid, owner_address, username,
+				display_name, bio, profile_picture, profile_picture_url,
+				verified_at, first_verified_at, latest_verified_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		`,
+			s.IDs().NewID(),
+			user.DID,
+			account.Type,
+			account.Address,
+			account.ChainType,
+			account.FID,
+			account.OwnerAddress,
+			account.Username,
+			account.DisplayName,
+			account.Bio,
+			account.ProfilePicture,
+			account.ProfilePictureURL,
+			account.VerifiedAt,
+			account.FirstVerifiedAt,
+			account.LatestVerifiedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert linked account: %w", classifyQueryError(ctx, err))
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit privy user: %w", classifyQueryError(ctx, err))
+	}
+	return nil
+}
+
 // ******************** Writing session operations ********************
 func (s *PostgresStore) CreateWritingSession(ctx context.Context, ws *types.WritingSession) error {
 	query := `
@@ -284,7 +688,7 @@ func (s *PostgresStore) CreateWritingSession(ctx context.Context, ws *types.Writ
 }
 
 func (s *PostgresStore) GetWritingSessionById(ctx context.Context, sessionID uuid.UUID) (*types.WritingSession, error) {
-	query := `SELECT * FROM writing_sessions WHERE id = $1`
+	query := `SELECT ` + writingSessionColumns + ` FROM writing_sessions WHERE id = $1`
 	row := s.db.QueryRow(ctx, query, sessionID)
 	return scanIntoWritingSession(row)
 }
@@ -294,7 +698,7 @@ func (s *PostgresStore) GetUserWritingSessions(ctx context.Context, userID uuid.
 	var args []interface{}
 
 	args = append(args, userID)
-	query = `SELECT * FROM writing_sessions WHERE user_id = $1`
+	query = `SELECT ` + writingSessionColumns + ` FROM writing_sessions WHERE user_id = $1`
 
 	if onlyAnkys {
 		query += ` AND is_anky = true`
@@ -321,11 +725,69 @@ func (s *PostgresStore) GetUserWritingSessions(ctx context.Context, userID uuid.
 	return writingSessions, nil
 }
 
+// writingSearchHeadline is how ts_headline cuts the snippets of search results
+const writingSearchHeadline = `StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=25, MinWords=8, FragmentDelimiter=" … "`
+
+// SearchUserWritingSessions returns the user's sessions whose writing
+// matches the search terms, best match first. Terms take web search syntax:
+// quoted phrases, "or" and -excluded words. Archived sessions come without a
+// snippet, their writing isn't in the database; see HighlightWriting.
+func (s *PostgresStore) SearchUserWritingSessions(ctx context.Context, userID uuid.UUID, terms string, limit int, offset int) ([]*types.WritingSessionSearchResult, error) {
+	query := `
+		SELECT ` + writingSessionColumns + `, ts_rank(writing_search, q),
+			CASE WHEN archived THEN '' ELSE ts_headline('simple', writing, q, '` + writingSearchHeadline + `') END
+		FROM writing_sessions, websearch_to_tsquery('simple', $2) q
+		WHERE user_id = $1 AND writing_search @@ q
+		ORDER BY ts_rank(writing_search, q) DESC, starting_timestamp DESC
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := s.db.Query(ctx, query, userID, terms, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search writing sessions: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]*types.WritingSessionSearchResult, 0)
+	for rows.Next() {
+		result := new(types.WritingSessionSearchResult)
+		result.Session, err = scanIntoWritingSession(searchResultRow{rows, result})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan writing session: %w", err)
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// searchResultRow scans the rank and snippet that follow the session columns
+// of a search result into it.
+type searchResultRow struct {
+	pgx.Row
+	result *types.WritingSessionSearchResult
+}
+
+func (r searchResultRow) Scan(dest ...interface{}) error {
+	return r.Row.Scan(append(dest, &r.result.Rank, &r.result.Snippet)...)
+}
+
+// HighlightWriting cuts a snippet of the writing around the words matching
+// the search terms, like the snippets of SearchUserWritingSessions.
+func (s *PostgresStore) HighlightWriting(ctx context.Context, writing string, terms string) (string, error) {
+	var snippet string
+	query := `SELECT ts_headline('simple', $1, websearch_to_tsquery('simple', $2), '` + writingSearchHeadline + `')`
+	if err := s.db.QueryRow(ctx, query, writing, terms).Scan(&snippet); err != nil {
+		return "", fmt.Errorf("failed to highlight writing: %w", err)
+	}
+	return snippet, nil
+}
+
+// UpdateWritingSession saves every field of the session but the writing of
+// archived sessions, which stays in the archive even when ws was rehydrated.
 func (s *PostgresStore) UpdateWritingSession(ctx context.Context, ws *types.WritingSession) error {
 	query := `
 		UPDATE writing_sessions SET 
 			status = $1,
-			writing = $2,
+			writing = CASE WHEN archived THEN writing ELSE $2 END,
 			words_written = $3,
 			time_spent = $4,
 			ending_timestamp = $5,
@@ -354,98 +816,341 @@ func (s *PostgresStore) UpdateWritingSession(ctx context.Context, ws *types.Writ
 	return err
 }
 
-// ******************** Anky operations ********************
+// UpdateWritingSessionFocus stores the focus metrics computed from the session's keystrokes.
+func (s *PostgresStore) UpdateWritingSessionFocus(ctx context.Context, sessionID uuid.UUID, focus *types.FocusMetrics) error {
+	focusJSON, err := json.Marshal(focus)
+	if err != nil {
+		return fmt.Errorf("failed to marshal focus metrics: %w", err)
+	}
 
-func (s *PostgresStore) GetAnkys(ctx context.Context, limit int, offset int) ([]*types.Anky, error) {
-	query := `SELECT * FROM ankys ORDER BY created_at DESC LIMIT $1 OFFSET $2`
-	rows, err := s.db.Query(ctx, query, limit, offset)
+	query := `UPDATE writing_sessions SET focus_score = $1, focus_metrics = $2 WHERE id = $3`
+	tag, err := s.db.Exec(ctx, query, focus.Score, focusJSON, sessionID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get ankys: %w", err)
+		return fmt.Errorf("failed to update writing session focus: %w", err)
 	}
-	defer rows.Close()
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("writing session %s not found", sessionID)
+	}
+	return nil
+}
 
-	ankys := make([]*types.Anky, 0)
-	for rows.Next() {
-		anky, err := scanIntoAnky(rows)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan anky: %w", err)
-		}
-		ankys = append(ankys, anky)
+// UpdateWritingSessionPaste stores what the session's keystrokes show of
+// pasted text and whether the session is flagged for it.
+func (s *PostgresStore) UpdateWritingSessionPaste(ctx context.Context, sessionID uuid.UUID, paste *types.PasteMetrics) error {
+	pasteJSON, err := json.Marshal(paste)
+	if err != nil {
+		return fmt.Errorf("failed to marshal paste metrics: %w", err)
 	}
 
-	return ankys, nil
+	query := `UPDATE writing_sessions SET paste_flagged = $1, paste_metrics = $2 WHERE id = $3`
+	tag, err := s.db.Exec(ctx, query, paste.Flagged, pasteJSON, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update writing session paste: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("writing session %s not found", sessionID)
+	}
+	return nil
 }
 
-func (s *PostgresStore) GetAnkyByID(ctx context.Context, ankyID uuid.UUID) (*types.Anky, error) {
-	query := `SELECT * FROM ankys WHERE id = $1`
-	row := s.db.QueryRow(ctx, query, ankyID)
-	return scanIntoAnky(row)
+// UpdateWritingSessionSuspect stores how scripted the session's keystrokes
+// look and whether the session is suspect for it.
+func (s *PostgresStore) UpdateWritingSessionSuspect(ctx context.Context, sessionID uuid.UUID, cheat *types.CheatMetrics) error {
+	cheatJSON, err := json.Marshal(cheat)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cheat metrics: %w", err)
+	}
+
+	query := `UPDATE writing_sessions SET suspect = $1, suspect_score = $2, cheat_metrics = $3 WHERE id = $4`
+	tag, err := s.db.Exec(ctx, query, cheat.Suspect, cheat.Score, cheatJSON, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update writing session suspect: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("writing session %s not found", sessionID)
+	}
+	return nil
 }
 
-func (s *PostgresStore) GetAnkysByUserID(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]*types.Anky, error) {
-	query := `SELECT * FROM ankys WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`
-	rows, err := s.db.Query(ctx, query, userID, limit, offset)
+// GetSuspectWritingSessions returns the suspect sessions, the most scripted
+// looking first.
+func (s *PostgresStore) GetSuspectWritingSessions(ctx context.Context, limit int, offset int) ([]*types.WritingSession, error) {
+	query := `
+		SELECT ` + writingSessionColumns + ` FROM writing_sessions
+		WHERE suspect
+		ORDER BY suspect_score DESC, starting_timestamp DESC
+		LIMIT $1 OFFSET $2`
+	rows, err := s.db.Query(ctx, query, limit, offset)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get ankys by user ID: %w", err)
+		return nil, fmt.Errorf("failed to get suspect writing sessions: %w", err)
 	}
 	defer rows.Close()
 
-	ankys := make([]*types.Anky, 0)
+	sessions := make([]*types.WritingSession, 0)
 	for rows.Next() {
-		anky, err := scanIntoAnky(rows)
+		session, err := scanIntoWritingSession(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan anky: %w", err)
+			return nil, err
 		}
-		ankys = append(ankys, anky)
+		sessions = append(sessions, session)
 	}
+	return sessions, rows.Err()
+}
 
-	return ankys, nil
+// UpdateWritingSessionProgress stores the writing of a session that is still
+// being streamed by its owner, so it survives the client going away
+// mid-session.
+func (s *PostgresStore) UpdateWritingSessionProgress(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID, writing string, wordsWritten int, timeSpent int) error {
+	query := `UPDATE writing_sessions SET writing = $1, words_written = $2, time_spent = $3 WHERE id = $4 AND user_id = $5`
+	tag, err := s.db.Exec(ctx, query, writing, wordsWritten, timeSpent, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update writing session progress: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("writing session %s not found", sessionID)
+	}
+	return nil
 }
 
-func (s *PostgresStore) CreateAnky(ctx context.Context, anky *types.Anky) error {
-	// Add debug logging
-	log.Printf("Creating Anky with ID: %s, UserID: %s, WritingSessionID: %s",
-		anky.ID, anky.UserID, anky.WritingSessionID)
+// UpdateWritingSessionSummary stores the short summary shown in list endpoints.
+func (s *PostgresStore) UpdateWritingSessionSummary(ctx context.Context, sessionID uuid.UUID, summary string) error {
+	query := `UPDATE writing_sessions SET summary = $1 WHERE id = $2`
+	tag, err := s.db.Exec(ctx, query, summary, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update writing session summary: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("writing session %s not found", sessionID)
+	}
+	return nil
+}
 
+// GetUserFocusHistory returns the focus score of the user's latest sessions, newest first.
+func (s *PostgresStore) GetUserFocusHistory(ctx context.Context, userID uuid.UUID, limit int) ([]*types.FocusPoint, error) {
 	query := `
-        INSERT INTO ankys (
-            id, user_id, writing_session_id, chosen_prompt, 
-            anky_reflection, image_prompt, follow_up_prompt, 
-            image_url, image_ipfs_hash, status, cast_hash, 
-            created_at, last_updated_at
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
-    `
+		SELECT id, starting_timestamp, focus_score, COALESCE(focus_metrics->>'label', '')
+		FROM writing_sessions
+		WHERE user_id = $1 AND focus_score IS NOT NULL
+		ORDER BY starting_timestamp DESC
+		LIMIT $2
+	`
+	rows, err := s.db.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get focus history: %w", err)
+	}
+	defer rows.Close()
 
-	// Initialize LastUpdatedAt if it's zero
-	if anky.LastUpdatedAt.IsZero() {
-		anky.LastUpdatedAt = time.Now().UTC()
+	points := make([]*types.FocusPoint, 0)
+	for rows.Next() {
+		point := new(types.FocusPoint)
+		if err := rows.Scan(&point.SessionID, &point.StartingTimestamp, &point.Score, &point.Label); err != nil {
+			return nil, fmt.Errorf("failed to scan focus point: %w", err)
+		}
+		points = append(points, point)
 	}
+	return points, rows.Err()
+}
 
-	_, err := s.db.Exec(ctx, query,
-		anky.ID,               // $1
-		anky.UserID,           // $2
-		anky.WritingSessionID, // $3
-		anky.ChosenPrompt,     // $4
-		anky.AnkyReflection,   // $5
-		anky.ImagePrompt,      // $6
-		anky.FollowUpPrompt,   // $7
-		anky.ImageURL,         // $8
-		anky.ImageIPFSHash,    // $9
-		anky.Status,           // $10
-		anky.CastHash,         // $11
-		anky.CreatedAt,        // $12
-		anky.LastUpdatedAt,    // $13
-	)
+// GetUserJourneyCounts fills in how many sessions the user wrote and how many
+// of them got or answered one of Anky's reflections.
+func (s *PostgresStore) GetUserJourneyCounts(ctx context.Context, userID uuid.UUID) (*types.UserJourney, error) {
+	query := `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE anky_id IS NOT NULL OR parent_anky_id IS NOT NULL)
+		FROM writing_sessions
+		WHERE user_id = $1
+	`
+	journey := &types.UserJourney{UserID: userID}
+	if err := s.db.QueryRow(ctx, query, userID).Scan(&journey.SessionCount, &journey.ReflectionCount); err != nil {
+		return nil, fmt.Errorf("failed to count user journey: %w", err)
+	}
+	return journey, nil
+}
 
+// GetUserWritingSessionsBetween returns every session the user started in [from, to).
+func (s *PostgresStore) GetUserWritingSessionsBetween(ctx context.Context, userID uuid.UUID, from time.Time, to time.Time) ([]*types.WritingSession, error) {
+	query := `
+		SELECT ` + writingSessionColumns + ` FROM writing_sessions
+		WHERE user_id = $1 AND starting_timestamp >= $2 AND starting_timestamp < $3
+		ORDER BY starting_timestamp ASC
+	`
+	rows, err := s.db.Query(ctx, query, userID, from, to)
 	if err != nil {
-		return fmt.Errorf("failed to create anky: %w", err)
+		return nil, fmt.Errorf("failed to get user writing sessions between dates: %w", err)
 	}
+	defer rows.Close()
 
-	return nil
-}
+	writingSessions := make([]*types.WritingSession, 0)
+	for rows.Next() {
+		writingSession, err := scanIntoWritingSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan writing session: %w", err)
+		}
+		writingSessions = append(writingSessions, writingSession)
+	}
 
-func (s *PostgresStore) UpdateAnky(ctx context.Context, anky *types.Anky) error {
-	query := `
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return writingSessions, nil
+}
+
+// GetUserIDsWithSessionsBetween returns the users that started at least one session in [from, to).
+func (s *PostgresStore) GetUserIDsWithSessionsBetween(ctx context.Context, from time.Time, to time.Time) ([]uuid.UUID, error) {
+	query := `
+		SELECT DISTINCT user_id FROM writing_sessions
+		WHERE user_id IS NOT NULL AND starting_timestamp >= $1 AND starting_timestamp < $2
+	`
+	rows, err := s.db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users with sessions: %w", err)
+	}
+	defer rows.Close()
+
+	userIDs := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user ID: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return userIDs, nil
+}
+
+// ******************** Anky operations ********************
+
+func (s *PostgresStore) GetAnkys(ctx context.Context, limit int, offset int) ([]*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	rows, err := s.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+
+	return ankys, nil
+}
+
+func (s *PostgresStore) GetAnkyByID(ctx context.Context, ankyID uuid.UUID) (*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE id = $1`
+	row := s.db.QueryRow(ctx, query, ankyID)
+	return scanIntoAnky(row)
+}
+
+func (s *PostgresStore) GetAnkyByWritingSessionID(ctx context.Context, writingSessionID uuid.UUID) (*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE writing_session_id = $1 AND NOT year_in_review`
+	row := s.db.QueryRow(ctx, query, writingSessionID)
+	return scanIntoAnky(row)
+}
+
+func (s *PostgresStore) GetAnkysByUserID(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`
+	rows, err := s.db.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys by user ID: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+
+	return ankys, nil
+}
+
+// ErrSessionHasAnky is returned when the writing session already became an Anky.
+var ErrSessionHasAnky = errors.New("this writing session already has an anky")
+
+// CreateAnky stores the new Anky. Every session becomes at most one Anky: a
+// second one gets ErrSessionHasAnky, year in review Ankys aside.
+const insertAnkyQuery = `
+        INSERT INTO ankys (
+            id, user_id, writing_session_id, chosen_prompt, 
+            anky_reflection, image_prompt, follow_up_prompt, 
+            image_url, image_ipfs_hash, status, cast_hash, 
+            created_at, last_updated_at, fid, ticker, token_name,
+            storage_degraded, metadata_uri, license, reveal_at, cast_on_reveal, year_in_review
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+    `
+
+// insertAnkyArgs fills in the defaults of a new Anky and returns the
+// arguments of insertAnkyQuery.
+func (s *PostgresStore) insertAnkyArgs(anky *types.Anky) []interface{} {
+	// Initialize LastUpdatedAt if it's zero
+	if anky.LastUpdatedAt.IsZero() {
+		anky.LastUpdatedAt = s.Clock().Now().UTC()
+	}
+	if anky.License == "" {
+		anky.License = types.DefaultLicense
+	}
+
+	return []interface{}{
+		anky.ID,               // $1
+		anky.UserID,           // $2
+		anky.WritingSessionID, // $3
+		anky.ChosenPrompt,     // $4
+		anky.AnkyReflection,   // $5
+		anky.ImagePrompt,      // $6
+		anky.FollowUpPrompt,   // $7
+		anky.ImageURL,         // $8
+		anky.ImageIPFSHash,    // $9
+		anky.Status,           // $10
+		anky.CastHash,         // $11
+		anky.CreatedAt,        // $12
+		anky.LastUpdatedAt,    // $13
+		anky.FID,              // $14
+		anky.Ticker,           // $15
+		anky.TokenName,        // $16
+		anky.StorageDegraded,  // $17
+		anky.MetadataURI,      // $18
+		anky.License,          // $19
+		anky.RevealAt,         // $20
+		anky.CastOnReveal,     // $21
+		anky.YearInReview,     // $22
+	}
+}
+
+func (s *PostgresStore) CreateAnky(ctx context.Context, anky *types.Anky) error {
+	// Add debug logging
+	log.Printf("Creating Anky with ID: %s, UserID: %s, WritingSessionID: %s",
+		anky.ID, anky.UserID, anky.WritingSessionID)
+
+	_, err := s.db.Exec(ctx, insertAnkyQuery, s.insertAnkyArgs(anky)...)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrSessionHasAnky
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create anky: %w", err)
+	}
+	anky.Version = 1
+
+	return nil
+}
+
+// UpdateAnky saves the Anky if it is still at anky.Version, like UpdateUser.
+func (s *PostgresStore) UpdateAnky(ctx context.Context, anky *types.Anky) error {
+	query := `
 		UPDATE ankys SET 
 			user_id = $1,
 			writing_session_id = $2,
@@ -458,37 +1163,916 @@ func (s *PostgresStore) UpdateAnky(ctx context.Context, anky *types.Anky) error
 			status = $9,
 			cast_hash = $10,
 			last_updated_at = $11,
-			fid = $12
-		WHERE id = $13`
-	_, err := s.db.Exec(ctx, query,
+			fid = $12,
+			ticker = $13,
+			token_name = $14,
+			storage_degraded = $15,
+			metadata_uri = $16,
+			version = version + 1
+		WHERE id = $17 AND ($18 = 0 OR version = $18)
+		RETURNING version`
+	var version int
+	err := s.db.QueryRow(ctx, query,
 		anky.UserID,
 		anky.WritingSessionID,
 		anky.ChosenPrompt,
 		anky.AnkyReflection,
 		anky.ImagePrompt,
 
-		anky.FollowUpPrompt,
-		anky.ImageURL,
-		anky.ImageIPFSHash,
-		anky.Status,
-		anky.CastHash,
-		anky.LastUpdatedAt,
-		anky.ID,
-		anky.FID,
+		anky.FollowUpPrompt,
+		anky.ImageURL,
+		anky.ImageIPFSHash,
+		anky.Status,
+		anky.CastHash,
+		anky.LastUpdatedAt,
+		anky.FID,
+		anky.Ticker,
+		anky.TokenName,
+		anky.StorageDegraded,
+		anky.MetadataURI,
+		anky.ID,
+		anky.Version,
+	).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = s.versionConflict(ctx, "ankys", "anky", anky.ID, anky.Version)
+	}
+	if err != nil {
+		return err
+	}
+	anky.Version = version
+	return nil
+}
+
+// GetParkedAnkys returns the Ankys left in status, pending_to_cast or
+// pending_image, since before the given time, oldest first. Pages after the
+// first start after the last Anky of the previous one.
+func (s *PostgresStore) GetParkedAnkys(ctx context.Context, status string, before time.Time, after *types.Anky, limit int) ([]*types.Anky, error) {
+	var afterUpdatedAt *time.Time
+	afterID := uuid.Nil
+	if after != nil {
+		afterUpdatedAt, afterID = &after.LastUpdatedAt, after.ID
+	}
+	query := `
+		SELECT ` + ankyColumns + ` FROM ankys
+		WHERE status = $1 AND last_updated_at < $2
+			AND ($3::timestamptz IS NULL OR (last_updated_at, id) > ($3, $4))
+		ORDER BY last_updated_at ASC, id ASC
+		LIMIT $5`
+	rows, err := s.db.Query(ctx, query, status, before, afterUpdatedAt, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parked ankys: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// GetStuckAnkys returns the Ankys whose pipeline stopped short of a final
+// status and hasn't moved since before the given time, oldest first.
+func (s *PostgresStore) GetStuckAnkys(ctx context.Context, before time.Time, limit int) ([]*types.Anky, error) {
+	query := `
+		SELECT ` + ankyColumns + ` FROM ankys
+		WHERE status NOT IN ('completed', 'pending_to_cast', 'pending_image', 'unpublished') AND last_updated_at < $1
+		ORDER BY last_updated_at ASC
+		LIMIT $2`
+	rows, err := s.db.Query(ctx, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stuck ankys: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+func (s *PostgresStore) GetLastAnkyByUserID(ctx context.Context, userID uuid.UUID) (*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
+	row := s.db.QueryRow(ctx, query, userID)
+	return scanIntoAnky(row)
+}
+
+// ******************** Anky status timeline operations ********************
+
+// GetDegradedAnkys returns the oldest Ankys still waiting for their image to be pinned.
+func (s *PostgresStore) GetDegradedAnkys(ctx context.Context, limit int) ([]*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE storage_degraded = TRUE ORDER BY created_at ASC LIMIT $1`
+	rows, err := s.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get degraded ankys: %w", err)
+	}
+	defer rows.Close()
+
+	var ankys []*types.Anky
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, err
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// SaveAnkyImages stores the Anky's image collection, replacing any panel at the same position.
+func (s *PostgresStore) SaveAnkyImages(ctx context.Context, ankyID uuid.UUID, images []*types.AnkyImage) error {
+	query := `
+		INSERT INTO anky_images (anky_id, position, image_prompt, image_url, image_ipfs_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (anky_id, position) DO UPDATE SET
+			image_prompt = EXCLUDED.image_prompt,
+			image_url = EXCLUDED.image_url,
+			image_ipfs_hash = EXCLUDED.image_ipfs_hash
+	`
+	for _, image := range images {
+		image.AnkyID = ankyID
+		if image.CreatedAt.IsZero() {
+			image.CreatedAt = s.Clock().Now().UTC()
+		}
+		_, err := s.db.Exec(ctx, query, image.AnkyID, image.Position, image.ImagePrompt, image.ImageURL, image.ImageIPFSHash, image.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save anky image %d: %w", image.Position, err)
+		}
+	}
+	return nil
+}
+
+// GetAnkyImagesByAnkyIDs returns the image collections of the given Ankys,
+// ordered by position. Ankys without a collection are left out of the map.
+func (s *PostgresStore) GetAnkyImagesByAnkyIDs(ctx context.Context, ankyIDs []uuid.UUID) (map[uuid.UUID][]*types.AnkyImage, error) {
+	images := make(map[uuid.UUID][]*types.AnkyImage)
+	if len(ankyIDs) == 0 {
+		return images, nil
+	}
+
+	query := `
+		SELECT anky_id, position, image_prompt, COALESCE(image_url, ''), COALESCE(image_ipfs_hash, ''), created_at
+		FROM anky_images
+		WHERE anky_id = ANY($1)
+		ORDER BY anky_id, position
+	`
+	rows, err := s.db.Query(ctx, query, ankyIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky images: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		image := new(types.AnkyImage)
+		if err := rows.Scan(&image.AnkyID, &image.Position, &image.ImagePrompt, &image.ImageURL, &image.ImageIPFSHash, &image.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan anky image: %w", err)
+		}
+		images[image.AnkyID] = append(images[image.AnkyID], image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return images, nil
+}
+
+func (s *PostgresStore) GetAnkyImageVariant(ctx context.Context, ankyID uuid.UUID, size string) (*types.AnkyImageVariant, error) {
+	query := `SELECT anky_id, size, url, width, height, created_at FROM anky_image_variants WHERE anky_id = $1 AND size = $2`
+	variant := new(types.AnkyImageVariant)
+	err := s.db.QueryRow(ctx, query, ankyID, size).Scan(
+		&variant.AnkyID,
+		&variant.Size,
+		&variant.URL,
+		&variant.Width,
+		&variant.Height,
+		&variant.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+// SaveAnkyImageVariant stores the variant, replacing an earlier one of the same size.
+func (s *PostgresStore) SaveAnkyImageVariant(ctx context.Context, variant *types.AnkyImageVariant) error {
+	query := `
+		INSERT INTO anky_image_variants (anky_id, size, url, width, height, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (anky_id, size) DO UPDATE SET
+			url = EXCLUDED.url,
+			width = EXCLUDED.width,
+			height = EXCLUDED.height,
+			created_at = EXCLUDED.created_at
+	`
+	_, err := s.db.Exec(ctx, query, variant.AnkyID, variant.Size, variant.URL, variant.Width, variant.Height, variant.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save anky image variant: %w", err)
+	}
+	return nil
+}
+
+// AttachAnkyImages loads the image collections of the given Ankys into their Images field.
+func (s *PostgresStore) AttachAnkyImages(ctx context.Context, ankys ...*types.Anky) error {
+	ids := make([]uuid.UUID, 0, len(ankys))
+	for _, anky := range ankys {
+		ids = append(ids, anky.ID)
+	}
+	images, err := s.GetAnkyImagesByAnkyIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, anky := range ankys {
+		anky.Images = images[anky.ID]
+	}
+	return nil
+}
+
+// GetAnkysByMarketCap returns Ankys ordered by the market cap of the latest
+// snapshot of the token clanker deployed for them. Ankys without market data
+// come last, newest first. A userID only returns the Ankys of that user, nil
+// those of everyone.
+func (s *PostgresStore) GetAnkysByMarketCap(ctx context.Context, userID *uuid.UUID, limit int, offset int) ([]*types.Anky, error) {
+	query := `
+		SELECT ` + ankyColumns + ` FROM ankys a
+		LEFT JOIN LATERAL (
+			SELECT market_cap_usd FROM anky_market_snapshots m
+			WHERE m.anky_id = a.id AND lower(m.token_address) = lower(a.token_address)
+			ORDER BY m.captured_at DESC
+			LIMIT 1
+		) latest ON TRUE
+		WHERE $1::uuid IS NULL OR a.user_id = $1
+		ORDER BY latest.market_cap_usd DESC NULLS LAST, a.created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys by market cap: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// GetAnkysWithToken returns the most recent Ankys clanker deployed a token for.
+func (s *PostgresStore) GetAnkysWithToken(ctx context.Context, limit int) ([]*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE token_address <> '' ORDER BY created_at DESC LIMIT $1`
+	rows, err := s.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys with a token: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// GetAnkysForCastReconciliation returns completed, cast Ankys, the ones checked longest ago first.
+func (s *PostgresStore) GetAnkysForCastReconciliation(ctx context.Context, limit int) ([]*types.Anky, error) {
+	query := `
+		SELECT ` + ankyColumns + ` FROM ankys
+		WHERE status = 'completed' AND cast_hash <> ''
+		ORDER BY cast_checked_at ASC NULLS FIRST
+		LIMIT $1`
+	rows, err := s.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys for cast reconciliation: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// GetAnkysWithMissingCasts returns the Ankys flagged by the cast reconciler, most recently flagged first.
+func (s *PostgresStore) GetAnkysWithMissingCasts(ctx context.Context, limit int, offset int) ([]*types.Anky, error) {
+	query := `SELECT ` + ankyColumns + ` FROM ankys WHERE cast_missing_at IS NOT NULL ORDER BY cast_missing_at DESC LIMIT $1 OFFSET $2`
+	rows, err := s.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys with missing casts: %w", err)
+	}
+	defer rows.Close()
+
+	ankys := make([]*types.Anky, 0)
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan anky: %w", err)
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// MarkAnkyCastChecked records a reconciliation check. A missing cast keeps the
+// time it was first found missing; a cast that resolves again clears the flag.
+func (s *PostgresStore) MarkAnkyCastChecked(ctx context.Context, ankyID uuid.UUID, missing bool) error {
+	query := `
+		UPDATE ankys SET
+			cast_checked_at = NOW(),
+			cast_missing_at = CASE WHEN $2 THEN COALESCE(cast_missing_at, NOW()) ELSE NULL END
+		WHERE id = $1`
+	_, err := s.db.Exec(ctx, query, ankyID, missing)
+	if err != nil {
+		return fmt.Errorf("failed to mark anky cast checked: %w", err)
+	}
+	return nil
+}
+
+// GetAnkysToReveal returns the time capsules whose reveal time passed before
+// the given time and whose pipeline finished, the longest overdue first.
+func (s *PostgresStore) GetAnkysToReveal(ctx context.Context, before time.Time, limit int) ([]*types.Anky, error) {
+	query := `
+		SELECT ` + ankyColumns + ` FROM ankys
+		WHERE reveal_at <= $1 AND revealed_at IS NULL AND status IN ('completed', 'pending_to_cast')
+		ORDER BY reveal_at ASC
+		LIMIT $2
+	`
+	rows, err := s.db.Query(ctx, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ankys to reveal: %w", err)
+	}
+	defer rows.Close()
+
+	var ankys []*types.Anky
+	for rows.Next() {
+		anky, err := scanIntoAnky(rows)
+		if err != nil {
+			return nil, err
+		}
+		ankys = append(ankys, anky)
+	}
+	return ankys, rows.Err()
+}
+
+// MarkAnkyRevealed sets the time the Anky was revealed and bumps its version.
+// It returns false when the Anky was already revealed, so two reveal passes
+// can't both act on it.
+func (s *PostgresStore) MarkAnkyRevealed(ctx context.Context, ankyID uuid.UUID, revealedAt time.Time) (bool, error) {
+	query := `
+		UPDATE ankys SET revealed_at = $2, last_updated_at = $2, version = version + 1
+		WHERE id = $1 AND revealed_at IS NULL`
+	tag, err := s.db.Exec(ctx, query, ankyID, revealedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark anky revealed: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) CreateAnkyMarketSnapshot(ctx context.Context, snapshot *types.AnkyMarketSnapshot) error {
+	if snapshot.ID == uuid.Nil {
+		snapshot.ID = s.IDs().NewID()
+	}
+	if snapshot.CapturedAt.IsZero() {
+		snapshot.CapturedAt = s.Clock().Now().UTC()
+	}
+
+	query := `
+		INSERT INTO anky_market_snapshots (
+			id, anky_id, token_address, chain_id, price_usd, market_cap_usd,
+			liquidity_usd, volume_24h_usd, source, captured_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := s.db.Exec(ctx, query,
+		snapshot.ID,
+		snapshot.AnkyID,
+		snapshot.TokenAddress,
+		snapshot.ChainID,
+		snapshot.PriceUSD,
+		snapshot.MarketCapUSD,
+		snapshot.LiquidityUSD,
+		snapshot.Volume24hUSD,
+		snapshot.Source,
+		snapshot.CapturedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create anky market snapshot: %w", err)
+	}
+	return nil
+}
+
+// GetAnkyMarketSnapshots returns the Anky's latest market snapshots, newest first.
+func (s *PostgresStore) GetAnkyMarketSnapshots(ctx context.Context, ankyID uuid.UUID, limit int) ([]*types.AnkyMarketSnapshot, error) {
+	query := `
+		SELECT id, anky_id, token_address, chain_id, price_usd, COALESCE(market_cap_usd, 0),
+			COALESCE(liquidity_usd, 0), COALESCE(volume_24h_usd, 0), source, captured_at
+		FROM anky_market_snapshots
+		WHERE anky_id = $1
+		ORDER BY captured_at DESC
+		LIMIT $2
+	`
+	rows, err := s.db.Query(ctx, query, ankyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky market snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := make([]*types.AnkyMarketSnapshot, 0)
+	for rows.Next() {
+		snapshot := new(types.AnkyMarketSnapshot)
+		if err := rows.Scan(
+			&snapshot.ID,
+			&snapshot.AnkyID,
+			&snapshot.TokenAddress,
+			&snapshot.ChainID,
+			&snapshot.PriceUSD,
+			&snapshot.MarketCapUSD,
+			&snapshot.LiquidityUSD,
+			&snapshot.Volume24hUSD,
+			&snapshot.Source,
+			&snapshot.CapturedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan anky market snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return snapshots, nil
+}
+
+// UpdateAnkyLicense switches the Anky's license and records the change in its
+// audit trail, in a single statement so neither happens without the other.
+func (s *PostgresStore) UpdateAnkyLicense(ctx context.Context, change *types.AnkyLicenseChange) error {
+	if change.ID == uuid.Nil {
+		change.ID = s.IDs().NewID()
+	}
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = s.Clock().Now().UTC()
+	}
+
+	query := `
+		WITH previous AS (
+			SELECT license FROM ankys WHERE id = $2 FOR UPDATE
+		), updated AS (
+			UPDATE ankys SET license = $4, last_updated_at = $5 WHERE id = $2
+		)
+		INSERT INTO anky_license_changes (id, anky_id, user_id, previous_license, new_license, created_at)
+		SELECT $1, $2, $3, previous.license, $4, $5 FROM previous
+		RETURNING previous_license
+	`
+	err := s.db.QueryRow(ctx, query, change.ID, change.AnkyID, change.UserID, change.NewLicense, change.CreatedAt).Scan(&change.PreviousLicense)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("anky %s not found", change.AnkyID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update anky license: %w", err)
+	}
+	return nil
+}
+
+// GetAnkyLicenseChanges returns the license audit trail of an Anky, oldest first.
+func (s *PostgresStore) GetAnkyLicenseChanges(ctx context.Context, ankyID uuid.UUID) ([]*types.AnkyLicenseChange, error) {
+	query := `
+		SELECT id, anky_id, user_id, previous_license, new_license, created_at
+		FROM anky_license_changes
+		WHERE anky_id = $1
+		ORDER BY created_at ASC
+	`
+	rows, err := s.db.Query(ctx, query, ankyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky license changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := make([]*types.AnkyLicenseChange, 0)
+	for rows.Next() {
+		change := new(types.AnkyLicenseChange)
+		if err := rows.Scan(&change.ID, &change.AnkyID, &change.UserID, &change.PreviousLicense, &change.NewLicense, &change.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan anky license change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func (s *PostgresStore) CreateAnkyStatusEvent(ctx context.Context, event *types.AnkyStatusEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = s.IDs().NewID()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = s.Clock().Now().UTC()
+	}
+
+	query := `
+		INSERT INTO anky_status_events (id, anky_id, status, detail, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := s.db.Exec(ctx, query, event.ID, event.AnkyID, event.Status, event.Detail, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create anky status event: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetAnkyStatusEvents(ctx context.Context, ankyID uuid.UUID) ([]*types.AnkyStatusEvent, error) {
+	query := `
+		SELECT id, anky_id, status, detail, created_at
+		FROM anky_status_events
+		WHERE anky_id = $1
+		ORDER BY created_at ASC
+	`
+	rows, err := s.db.Query(ctx, query, ankyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anky status events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]*types.AnkyStatusEvent, 0)
+	for rows.Next() {
+		event := new(types.AnkyStatusEvent)
+		var detail *string
+		if err := rows.Scan(&event.ID, &event.AnkyID, &event.Status, &detail, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan anky status event: %w", err)
+		}
+		if detail != nil {
+			event.Detail = *detail
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// ******************** Year in review operations ********************
+
+func (s *PostgresStore) GetYearInReview(ctx context.Context, userID uuid.UUID, year int) (*types.YearInReview, error) {
+	query := `
+		SELECT user_id, year, stats, narrative, anky_id, generated_at
+		FROM year_in_reviews
+		WHERE user_id = $1 AND year = $2
+	`
+	review := new(types.YearInReview)
+	var statsJSON []byte
+	var narrative *string
+	err := s.db.QueryRow(ctx, query, userID, year).Scan(
+		&review.UserID,
+		&review.Year,
+		&statsJSON,
+		&narrative,
+		&review.AnkyID,
+		&review.GeneratedAt,
 	)
-	return err
+	if err != nil {
+		return nil, fmt.Errorf("failed to get year in review: %w", err)
+	}
+
+	if err := json.Unmarshal(statsJSON, &review.Stats); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal year in review stats: %w", err)
+	}
+	if narrative != nil {
+		review.Narrative = *narrative
+	}
+	return review, nil
 }
 
-func (s *PostgresStore) GetLastAnkyByUserID(ctx context.Context, userID uuid.UUID) (*types.Anky, error) {
-	query := `SELECT * FROM ankys WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
-	row := s.db.QueryRow(ctx, query, userID)
-	return scanIntoAnky(row)
+func (s *PostgresStore) SaveYearInReview(ctx context.Context, review *types.YearInReview) error {
+	statsJSON, err := json.Marshal(review.Stats)
+	if err != nil {
+		return fmt.Errorf("failed to marshal year in review stats: %w", err)
+	}
+
+	query := `
+		INSERT INTO year_in_reviews (user_id, year, stats, narrative, anky_id, generated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, year) DO UPDATE SET
+			stats = EXCLUDED.stats,
+			narrative = EXCLUDED.narrative,
+			anky_id = COALESCE(EXCLUDED.anky_id, year_in_reviews.anky_id),
+			generated_at = EXCLUDED.generated_at
+	`
+	_, err = s.db.Exec(ctx, query,
+		review.UserID,
+		review.Year,
+		statsJSON,
+		review.Narrative,
+		review.AnkyID,
+		review.GeneratedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save year in review: %w", err)
+	}
+	return nil
+}
+
+// MintYearInReviewAnky creates the year in review Anky of the recap and
+// links it, unless the recap already has one. The recap's row is locked for
+// the whole mint, so concurrent mints create a single Anky; the ones that
+// lose get the ID of the Anky that was minted and created false.
+func (s *PostgresStore) MintYearInReviewAnky(ctx context.Context, userID uuid.UUID, year int, anky *types.Anky) (ankyID uuid.UUID, created bool, err error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to begin minting year in review: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	var minted *uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT anky_id FROM year_in_reviews WHERE user_id = $1 AND year = $2 FOR UPDATE`, userID, year).Scan(&minted)
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to lock year in review: %w", classifyQueryError(ctx, err))
+	}
+	if minted != nil {
+		return *minted, false, nil
+	}
+
+	if _, err := tx.Exec(ctx, insertAnkyQuery, s.insertAnkyArgs(anky)...); err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to create year in review anky: %w", classifyQueryError(ctx, err))
+	}
+	if _, err := tx.Exec(ctx, `UPDATE year_in_reviews SET anky_id = $3 WHERE user_id = $1 AND year = $2`, userID, year, anky.ID); err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to link year in review anky: %w", classifyQueryError(ctx, err))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to commit year in review mint: %w", classifyQueryError(ctx, err))
+	}
+	anky.Version = 1
+	return anky.ID, true, nil
+}
+
+// ******************** FID request operations ********************
+
+const fidRequestColumns = `id, user_id, device_fingerprint, ip_address, score, decision, signals, status, reviewer_note, created_at, reviewed_at`
+
+func (s *PostgresStore) CreateFIDRequest(ctx context.Context, request *types.FIDRequest) error {
+	if request.ID == uuid.Nil {
+		request.ID = s.IDs().NewID()
+	}
+	if request.CreatedAt.IsZero() {
+		request.CreatedAt = s.Clock().Now().UTC()
+	}
+
+	signalsJSON, err := json.Marshal(request.Signals)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fid request signals: %w", err)
+	}
+
+	query := `
+		INSERT INTO fid_requests (` + fidRequestColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err = s.db.Exec(ctx, query,
+		request.ID,
+		request.UserID,
+		request.DeviceFingerprint,
+		request.IPAddress,
+		request.Score,
+		request.Decision,
+		signalsJSON,
+		request.Status,
+		request.ReviewerNote,
+		request.CreatedAt,
+		request.ReviewedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create fid request: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetFIDRequestByID(ctx context.Context, id uuid.UUID) (*types.FIDRequest, error) {
+	query := `SELECT ` + fidRequestColumns + ` FROM fid_requests WHERE id = $1`
+	return scanIntoFIDRequest(s.db.QueryRow(ctx, query, id))
+}
+
+func (s *PostgresStore) GetLatestFIDRequestByUserID(ctx context.Context, userID uuid.UUID) (*types.FIDRequest, error) {
+	query := `SELECT ` + fidRequestColumns + ` FROM fid_requests WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
+	return scanIntoFIDRequest(s.db.QueryRow(ctx, query, userID))
+}
+
+func (s *PostgresStore) GetFIDRequestsByStatus(ctx context.Context, status string, limit int, offset int) ([]*types.FIDRequest, error) {
+	query := `SELECT ` + fidRequestColumns + ` FROM fid_requests WHERE status = $1 ORDER BY created_at ASC LIMIT $2 OFFSET $3`
+	rows, err := s.db.Query(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fid requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := make([]*types.FIDRequest, 0)
+	for rows.Next() {
+		request, err := scanIntoFIDRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+// ReviewFIDRequest records an admin decision on a pending FID request.
+func (s *PostgresStore) ReviewFIDRequest(ctx context.Context, id uuid.UUID, status string, note string) error {
+	query := `
+		UPDATE fid_requests
+		SET status = $1, reviewer_note = $2, reviewed_at = NOW()
+		WHERE id = $3 AND status = 'pending'
+	`
+	tag, err := s.db.Exec(ctx, query, status, note, id)
+	if err != nil {
+		return fmt.Errorf("failed to review fid request: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("fid request %s is not pending review", id)
+	}
+	return nil
+}
+
+// CountUsersSharingFingerprint counts the other users that requested a FID from the same device.
+func (s *PostgresStore) CountUsersSharingFingerprint(ctx context.Context, fingerprint string, userID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(DISTINCT user_id) FROM (
+			SELECT user_id FROM fid_requests WHERE device_fingerprint = $1
+			UNION
+			SELECT user_id FROM user_metadata WHERE device_id = $1
+		) AS devices
+		WHERE user_id <> $2
+	`
+	var count int
+	if err := s.db.QueryRow(ctx, query, fingerprint, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users sharing fingerprint: %w", err)
+	}
+	return count, nil
+}
+
+func scanIntoFIDRequest(row pgx.Row) (*types.FIDRequest, error) {
+	request := new(types.FIDRequest)
+	var fingerprint, ipAddress, reviewerNote *string
+	var signalsJSON []byte
+	err := row.Scan(
+		&request.ID,
+		&request.UserID,
+		&fingerprint,
+		&ipAddress,
+		&request.Score,
+		&request.Decision,
+		&signalsJSON,
+		&request.Status,
+		&reviewerNote,
+		&request.CreatedAt,
+		&request.ReviewedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan fid request: %w", err)
+	}
+
+	if fingerprint != nil {
+		request.DeviceFingerprint = *fingerprint
+	}
+	if ipAddress != nil {
+		request.IPAddress = *ipAddress
+	}
+	if reviewerNote != nil {
+		request.ReviewerNote = *reviewerNote
+	}
+	if err := json.Unmarshal(signalsJSON, &request.Signals); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fid request signals: %w", err)
+	}
+	return request, nil
+}
+
+// ******************** Prompt operations ********************
+
+// UpsertPrompt sets the FID's upcoming prompt and appends it to the FID's prompt history.
+func (s *PostgresStore) UpsertPrompt(ctx context.Context, prompt *types.WritingPrompt) error {
+	query := `
+		WITH upserted AS (
+			INSERT INTO prompts (fid, prompt, source, created_at, updated_at)
+			VALUES ($1, $2, $3, NOW(), NOW())
+			ON CONFLICT (fid) DO UPDATE SET
+				prompt = EXCLUDED.prompt,
+				source = EXCLUDED.source,
+				updated_at = NOW()
+			RETURNING created_at, updated_at
+		), history AS (
+			INSERT INTO prompt_history (fid, prompt, source) VALUES ($1, $2, $3)
+		)
+		SELECT created_at, updated_at FROM upserted
+	`
+	err := s.db.QueryRow(ctx, query, prompt.FID, prompt.Prompt, prompt.Source).Scan(&prompt.CreatedAt, &prompt.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert prompt: %w", err)
+	}
+	return nil
+}
+
+// GetPrompt returns the FID's own upcoming prompt, wrapping pgx.ErrNoRows when it has none.
+func (s *PostgresStore) GetPrompt(ctx context.Context, fid int) (*types.WritingPrompt, error) {
+	query := `SELECT ` + writingPromptColumns + ` FROM prompts WHERE fid = $1`
+	prompt, err := scanIntoWritingPrompt(s.db.QueryRow(ctx, query, fid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt of fid %d: %w", fid, err)
+	}
+	return prompt, nil
+}
+
+// GetPromptOrDefault returns the FID's upcoming prompt, or the default prompt
+// if it has none. pgx.ErrNoRows is wrapped when the default was deleted too.
+func (s *PostgresStore) GetPromptOrDefault(ctx context.Context, fid int) (*types.WritingPrompt, error) {
+	query := `SELECT ` + writingPromptColumns + ` FROM prompts WHERE fid = $1 OR fid = $2 ORDER BY fid = $1 DESC LIMIT 1`
+	prompt, err := scanIntoWritingPrompt(s.db.QueryRow(ctx, query, fid, types.DefaultPromptFID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt of fid %d: %w", fid, err)
+	}
+	return prompt, nil
+}
+
+func (s *PostgresStore) GetPrompts(ctx context.Context, limit int, offset int) ([]*types.WritingPrompt, error) {
+	query := `SELECT ` + writingPromptColumns + ` FROM prompts ORDER BY updated_at DESC LIMIT $1 OFFSET $2`
+	rows, err := s.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompts: %w", err)
+	}
+	defer rows.Close()
+
+	prompts := make([]*types.WritingPrompt, 0)
+	for rows.Next() {
+		prompt, err := scanIntoWritingPrompt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan prompt: %w", err)
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, rows.Err()
+}
+
+// GetPromptHistory returns the prompts set for the FID, newest first.
+func (s *PostgresStore) GetPromptHistory(ctx context.Context, fid int, limit int) ([]*types.WritingPromptHistoryEntry, error) {
+	query := `
+		SELECT id, fid, prompt, source, created_at FROM prompt_history
+		WHERE fid = $1 ORDER BY created_at DESC LIMIT $2
+	`
+	rows, err := s.db.Query(ctx, query, fid, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt history: %w", err)
+	}
+	defer rows.Close()
+
+	history := make([]*types.WritingPromptHistoryEntry, 0)
+	for rows.Next() {
+		entry := new(types.WritingPromptHistoryEntry)
+		if err := rows.Scan(&entry.ID, &entry.FID, &entry.Prompt, &entry.Source, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan prompt history: %w", err)
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// DeletePrompt removes the FID's upcoming prompt, so it falls back to the
+// default one. The FID's prompt history is kept.
+func (s *PostgresStore) DeletePrompt(ctx context.Context, fid int) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM prompts WHERE fid = $1`, fid)
+	if err != nil {
+		return fmt.Errorf("failed to delete prompt: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("prompt of fid %d not found: %w", fid, pgx.ErrNoRows)
+	}
+	return nil
 }
 
 // ******************** Badge operations ********************
 
 func (s *PostgresStore) GetUserBadges(ctx context.Context, userID uuid.UUID) ([]*types.Badge, error) {
-	query := `SELECT * FROM badges WHERE user_id = $1`
+	query := `SELECT ` + badgeColumns + ` FROM badges WHERE user_id = $1`
 	rows, err := s.db.Query(ctx, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user badges: %w", err)
@@ -507,19 +2091,199 @@ func (s *PostgresStore) GetUserBadges(ctx context.Context, userID uuid.UUID) ([]
 	return badges, nil
 }
 
+func (s *PostgresStore) CreateBadge(ctx context.Context, badge *types.Badge) error {
+	if badge.UnlockedAt.IsZero() {
+		badge.UnlockedAt = s.Clock().Now().UTC()
+	}
+	query := `
+		INSERT INTO badges (user_id, name, description, unlocked_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	err := s.db.QueryRow(ctx, query, badge.UserID, badge.Name, badge.Description, badge.UnlockedAt).Scan(&badge.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create badge: %w", err)
+	}
+	return nil
+}
+
+// ******************** Newen operations ********************
+
+var ErrInsufficientNewen = errors.New("insufficient newen balance")
+
+const newenTransactionColumns = `id, user_id, direction, amount, source, description, writing_session_id, created_at, idempotency_key`
+
+const insertNewenTransaction = `
+	INSERT INTO newen_transactions (` + newenTransactionColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))
+`
+
+func (s *PostgresStore) newenTransactionArgs(transaction *types.NewenTransaction) []interface{} {
+	if transaction.ID == uuid.Nil {
+		transaction.ID = s.IDs().NewID()
+	}
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = s.Clock().Now().UTC()
+	}
+	return []interface{}{
+		transaction.ID,
+		transaction.UserID,
+		transaction.Direction,
+		transaction.Amount,
+		transaction.Source,
+		transaction.Description,
+		transaction.WritingSessionID,
+		transaction.CreatedAt,
+		transaction.IdempotencyKey,
+	}
+}
+
+func (s *PostgresStore) CreateNewenTransaction(ctx context.Context, transaction *types.NewenTransaction) error {
+	if _, err := s.db.Exec(ctx, insertNewenTransaction, s.newenTransactionArgs(transaction)...); err != nil {
+		return fmt.Errorf("failed to create newen transaction: %w", err)
+	}
+	return nil
+}
+
+// CreateNewenWritingReward credits the reward of a writing session unless it
+// was already granted, and reports whether this call granted it.
+func (s *PostgresStore) CreateNewenWritingReward(ctx context.Context, transaction *types.NewenTransaction) (bool, error) {
+	transaction.Direction = types.NewenCredit
+	transaction.Source = types.NewenSourceWritingReward
+	query := insertNewenTransaction + ` ON CONFLICT (writing_session_id) WHERE source = 'writing_reward' DO NOTHING`
+	tag, err := s.db.Exec(ctx, query, s.newenTransactionArgs(transaction)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to create newen writing reward: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CreateNewenDebit records the debit only if the balance covers it, returns
+// ErrInsufficientNewen otherwise, and returns the balance left. The user's row
+// stays locked until the debit is written so two spends can't both pass the
+// check. A debit whose idempotency key the user already used isn't recorded
+// again: transaction is filled in with the earlier one and replayed is true.
+func (s *PostgresStore) CreateNewenDebit(ctx context.Context, transaction *types.NewenTransaction) (balance int, replayed bool, err error) {
+	transaction.Direction = types.NewenDebit
+
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin newen debit: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, transaction.UserID); err != nil {
+		return 0, false, fmt.Errorf("failed to lock user: %w", classifyQueryError(ctx, err))
+	}
+
+	var current int64
+	if err := tx.QueryRow(ctx, newenBalanceQuery, transaction.UserID).Scan(&current); err != nil {
+		return 0, false, fmt.Errorf("failed to get newen balance: %w", classifyQueryError(ctx, err))
+	}
+
+	if transaction.IdempotencyKey != "" {
+		query := `SELECT ` + newenTransactionSelectColumns + ` FROM newen_transactions WHERE user_id = $1 AND idempotency_key = $2`
+		earlier, err := scanNewenTransaction(tx.QueryRow(ctx, query, transaction.UserID, transaction.IdempotencyKey))
+		if err == nil {
+			*transaction = *earlier
+			return int(current), true, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, fmt.Errorf("failed to look up idempotency key: %w", classifyQueryError(ctx, err))
+		}
+	}
+
+	if current < int64(transaction.Amount) {
+		return int(current), false, ErrInsufficientNewen
+	}
+	if _, err := tx.Exec(ctx, insertNewenTransaction, s.newenTransactionArgs(transaction)...); err != nil {
+		return 0, false, fmt.Errorf("failed to create newen debit: %w", classifyQueryError(ctx, err))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, false, fmt.Errorf("failed to commit newen debit: %w", classifyQueryError(ctx, err))
+	}
+	return int(current) - transaction.Amount, false, nil
+}
+
+const newenBalanceQuery = `
+	SELECT COALESCE(SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END), 0)::BIGINT
+	FROM newen_transactions WHERE user_id = $1
+`
+
+// GetNewenBalance sums the user's newen ledger.
+func (s *PostgresStore) GetNewenBalance(ctx context.Context, userID uuid.UUID) (int, error) {
+	var balance int64
+	if err := s.db.QueryRow(ctx, newenBalanceQuery, userID).Scan(&balance); err != nil {
+		return 0, fmt.Errorf("failed to get newen balance: %w", err)
+	}
+	return int(balance), nil
+}
+
+// GetNewenTransactions returns the user's ledger entries, most recent first.
+func (s *PostgresStore) GetNewenTransactions(ctx context.Context, userID uuid.UUID, limit int, offset int) ([]*types.NewenTransaction, error) {
+	query := `
+		SELECT ` + newenTransactionSelectColumns + `
+		FROM newen_transactions
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get newen transactions: %w", err)
+	}
+	defer rows.Close()
+
+	transactions := []*types.NewenTransaction{}
+	for rows.Next() {
+		transaction, err := scanNewenTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan newen transaction: %w", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, rows.Err()
+}
+
 // ******************** Scan functions ********************
 // Scan functions are essential utilities that map database query results into Go structs.
 // They handle the conversion of raw database rows into strongly-typed application objects,
 // providing type safety and reducing boilerplate code throughout the codebase.
 
+const newenTransactionSelectColumns = `id, user_id, direction, amount, source, description, writing_session_id, created_at, COALESCE(idempotency_key, '')`
+
+func scanNewenTransaction(row pgx.Row) (*types.NewenTransaction, error) {
+	transaction := new(types.NewenTransaction)
+	err := row.Scan(
+		&transaction.ID,
+		&transaction.UserID,
+		&transaction.Direction,
+		&transaction.Amount,
+		&transaction.Source,
+		&transaction.Description,
+		&transaction.WritingSessionID,
+		&transaction.CreatedAt,
+		&transaction.IdempotencyKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
 func scanIntoUser(row pgx.Row) (*types.User, error) {
 	user := new(types.User)
 	var isAnonymous bool
 	var settings interface{}
-	var metadataID *uuid.UUID
-	var farcasterUserID *uuid.UUID
+	var farcasterUserID, metadataID *uuid.UUID
+	var metadataCreatedAt, metadataLastActive *time.Time
+	var privyDID *string
+	var privyCreatedAt *time.Time
+	farcasterUser := new(types.FarcasterUser)
+	metadata := new(types.UserMetadata)
 
-	log.Printf("[DB] Starting to scan row")
 	err := row.Scan(
 		&user.ID,
 		&user.PrivyDID,
@@ -531,16 +2295,57 @@ func scanIntoUser(row pgx.Row) (*types.User, error) {
 		&user.UpdatedAt,
 		&user.JWT,
 		&isAnonymous,
+		&user.Version,
 		&farcasterUserID,
+		&farcasterUser.FID,
+		&farcasterUser.Username,
+		&farcasterUser.DisplayName,
+		&farcasterUser.ProfilePicture,
+		&farcasterUser.CustodyAddress,
+		&farcasterUser.Bio,
+		&farcasterUser.FollowerCount,
+		&farcasterUser.FollowingCount,
+		&farcasterUser.SignerUUID,
 		&metadataID,
+		&metadata.DeviceID,
+		&metadata.Platform,
+		&metadata.DeviceModel,
+		&metadata.OSVersion,
+		&metadata.AppVersion,
+		&metadata.ScreenWidth,
+		&metadata.ScreenHeight,
+		&metadata.Locale,
+		&metadata.Timezone,
+		&metadataCreatedAt,
+		&metadataLastActive,
+		&metadata.UserAgent,
+		&metadata.InstallationSource,
+		&privyDID,
+		&privyCreatedAt,
 	)
 	if err != nil {
-		log.Printf("[DB] Scan error: %v", err)
 		return nil, fmt.Errorf("failed to scan user: %w", err)
 	}
-	log.Printf("[DB] Successfully scanned basic fields")
 
 	user.IsAnonymous = isAnonymous
+	if farcasterUserID != nil {
+		user.FarcasterUser = farcasterUser
+	}
+	if metadataID != nil {
+		if metadataCreatedAt != nil {
+			metadata.CreatedAt = *metadataCreatedAt
+		}
+		if metadataLastActive != nil {
+			metadata.LastActive = *metadataLastActive
+		}
+		user.UserMetadata = metadata
+	}
+	if privyDID != nil {
+		user.PrivyUser = &types.PrivyUser{DID: *privyDID, UserID: user.ID}
+		if privyCreatedAt != nil {
+			user.PrivyUser.CreatedAt = *privyCreatedAt
+		}
+	}
 
 	// Convert settings
 	if settings != nil {
@@ -555,16 +2360,22 @@ func scanIntoUser(row pgx.Row) (*types.User, error) {
 		user.Settings = &userSettings
 	}
 
-	log.Printf("[DB] Completed user scan: %+v", user)
 	return user, nil
 }
 
+// writingSessionColumns are the columns scanIntoWritingSession reads, in order.
+const writingSessionColumns = `id, session_index_for_user, user_id, starting_timestamp, ending_timestamp,
+	prompt, writing, words_written, newen_earned, time_spent, is_anky, parent_anky_id, anky_response,
+	status, anky_id, is_onboarding, focus_score, focus_metrics, paste_flagged, paste_metrics, summary,
+	archived, archived_at, archive_key, archive_checksum, suspect, suspect_score, cheat_metrics`
+
 func scanIntoWritingSession(row pgx.Row) (*types.WritingSession, error) {
 	ws := new(types.WritingSession)
 	var endingTimestamp *time.Time
 	var parentAnkyID *uuid.UUID
 	var ankyResponse *string
 	var ankyID *uuid.UUID
+	var focusMetrics, pasteMetrics, cheatMetrics []byte
 
 	err := row.Scan(
 		&ws.ID,
@@ -583,11 +2394,42 @@ func scanIntoWritingSession(row pgx.Row) (*types.WritingSession, error) {
 		&ws.Status,
 		&ankyID,
 		&ws.IsOnboarding,
+		&ws.FocusScore,
+		&focusMetrics,
+		&ws.PasteFlagged,
+		&pasteMetrics,
+		&ws.Summary,
+		&ws.Archived,
+		&ws.ArchivedAt,
+		&ws.ArchiveKey,
+		&ws.ArchiveChecksum,
+		&ws.Suspect,
+		&ws.SuspectScore,
+		&cheatMetrics,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan writing session: %w", err)
 	}
 
+	if focusMetrics != nil {
+		ws.FocusMetrics = new(types.FocusMetrics)
+		if err := json.Unmarshal(focusMetrics, ws.FocusMetrics); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal focus metrics: %w", err)
+		}
+	}
+	if pasteMetrics != nil {
+		ws.PasteMetrics = new(types.PasteMetrics)
+		if err := json.Unmarshal(pasteMetrics, ws.PasteMetrics); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal paste metrics: %w", err)
+		}
+	}
+	if cheatMetrics != nil {
+		ws.CheatMetrics = new(types.CheatMetrics)
+		if err := json.Unmarshal(cheatMetrics, ws.CheatMetrics); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cheat metrics: %w", err)
+		}
+	}
+
 	// Handle nullable fields
 	if endingTimestamp != nil {
 		ws.EndingTimestamp = endingTimestamp
@@ -601,8 +2443,17 @@ func scanIntoWritingSession(row pgx.Row) (*types.WritingSession, error) {
 	return ws, nil
 }
 
+// ankyColumns are the columns scanIntoAnky reads, in order.
+const ankyColumns = `id, user_id, writing_session_id, chosen_prompt, anky_reflection, image_prompt,
+	follow_up_prompt, image_url, image_ipfs_hash, status, cast_hash, created_at, last_updated_at,
+	fid, ticker, token_name, storage_degraded, metadata_uri, license, cast_checked_at, cast_missing_at,
+	reveal_at, revealed_at, cast_on_reveal, onchain_status, onchain_tx_hash, metadata_ipfs_hash, token_id,
+	onchain_block_number, onchain_confirmed_at, token_address, token_deployed_at, year_in_review, version`
+
 func scanIntoAnky(row pgx.Row) (*types.Anky, error) {
 	anky := new(types.Anky)
+	var fid *int
+	var ticker, tokenName, metadataURI *string
 	err := row.Scan(
 		&anky.ID,
 		&anky.UserID,
@@ -617,13 +2468,67 @@ func scanIntoAnky(row pgx.Row) (*types.Anky, error) {
 		&anky.CastHash,
 		&anky.CreatedAt,
 		&anky.LastUpdatedAt,
+		&fid,
+		&ticker,
+		&tokenName,
+		&anky.StorageDegraded,
+		&metadataURI,
+		&anky.License,
+		&anky.CastCheckedAt,
+		&anky.CastMissingAt,
+		&anky.RevealAt,
+		&anky.RevealedAt,
+		&anky.CastOnReveal,
+		&anky.OnchainStatus,
+		&anky.OnchainTxHash,
+		&anky.MetadataIPFSHash,
+		&anky.TokenID,
+		&anky.OnchainBlockNumber,
+		&anky.OnchainConfirmedAt,
+		&anky.TokenAddress,
+		&anky.TokenDeployedAt,
+		&anky.YearInReview,
+		&anky.Version,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan anky: %w", err)
 	}
+
+	// Handle nullable fields
+	if fid != nil {
+		anky.FID = *fid
+	}
+	if ticker != nil {
+		anky.Ticker = *ticker
+	}
+	if tokenName != nil {
+		anky.TokenName = *tokenName
+	}
+	if metadataURI != nil {
+		anky.MetadataURI = *metadataURI
+	}
 	return anky, nil
 }
 
+const writingPromptColumns = `fid, prompt, source, created_at, updated_at`
+
+func scanIntoWritingPrompt(row pgx.Row) (*types.WritingPrompt, error) {
+	prompt := new(types.WritingPrompt)
+	err := row.Scan(
+		&prompt.FID,
+		&prompt.Prompt,
+		&prompt.Source,
+		&prompt.CreatedAt,
+		&prompt.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return prompt, nil
+}
+
+const badgeColumns = `id, user_id, name, description, unlocked_at`
+
 func scanIntoBadge(row pgx.Row) (*types.Badge, error) {
 	badge := new(types.Badge)
 	err := row.Scan(
@@ -631,6 +2536,7 @@ func scanIntoBadge(row pgx.Row) (*types.Badge, error) {
 		&badge.UserID,
 		&badge.Name,
 		&badge.Description,
+		&badge.UnlockedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan badge: %w", err)
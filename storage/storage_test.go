@@ -0,0 +1,61 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+func createTestUser(t *testing.T, store *storage.PostgresStore, anonymous bool) *types.User {
+	t.Helper()
+	now := time.Now().UTC()
+	user := &types.User{ID: uuid.New(), IsAnonymous: anonymous, CreatedAt: now, UpdatedAt: now}
+	if err := store.CreateUserWithRelations(context.Background(), user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	return user
+}
+
+func createTestSession(t *testing.T, store *storage.PostgresStore, userID uuid.UUID, index int, startedAt time.Time) *types.WritingSession {
+	t.Helper()
+	session := &types.WritingSession{
+		ID:                  uuid.New(),
+		UserID:              userID,
+		SessionIndexForUser: index,
+		StartingTimestamp:   startedAt,
+		Prompt:              "what is alive in you this morning?",
+		Status:              "completed",
+		Writing:             "the morning light came through the window",
+	}
+	if err := store.CreateWritingSession(context.Background(), session); err != nil {
+		t.Fatalf("creating writing session: %v", err)
+	}
+	return session
+}
+
+// createTestAnky stores a writer, one of their sessions and its Anky, with
+// whatever the test set on the Anky.
+func createTestAnky(t *testing.T, store *storage.PostgresStore, anky *types.Anky) *types.Anky {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	user := createTestUser(t, store, false)
+	session := createTestSession(t, store, user.ID, 0, now)
+
+	anky.ID = uuid.New()
+	anky.UserID = user.ID
+	anky.WritingSessionID = session.ID
+	anky.ChosenPrompt = session.Prompt
+	anky.CreatedAt = now
+	if anky.Status == "" {
+		anky.Status = "completed"
+	}
+	if err := store.CreateAnky(ctx, anky); err != nil {
+		t.Fatalf("creating anky: %v", err)
+	}
+	return anky
+}
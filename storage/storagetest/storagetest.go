@@ -0,0 +1,75 @@
+// Package storagetest opens throwaway Postgres stores for tests that need a
+// real database.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// Open creates a database of its own for the test on the server at
+// TEST_DATABASE_URL, migrates it and drops it when the test ends. Tests are
+// skipped when TEST_DATABASE_URL is not set.
+func Open(t testing.TB) *storage.PostgresStore {
+	t.Helper()
+	serverURL := strings.TrimSpace(os.Getenv("TEST_DATABASE_URL"))
+	if serverURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, serverURL)
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+	name := "anky_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		admin.Close(ctx)
+		t.Fatalf("creating test database: %v", err)
+	}
+
+	connStr, err := withDatabase(serverURL, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.OpenPostgresStore(connStr, migrationsDir())
+	if err != nil {
+		t.Fatalf("opening test store: %v", err)
+	}
+
+	t.Cleanup(func() {
+		store.Close()
+		if _, err := admin.Exec(ctx, "DROP DATABASE IF EXISTS "+name+" WITH (FORCE)"); err != nil {
+			t.Logf("dropping test database %s: %v", name, err)
+		}
+		admin.Close(ctx)
+	})
+	return store
+}
+
+// withDatabase points the connection URL at another database of the server.
+func withDatabase(serverURL string, name string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid TEST_DATABASE_URL: %w", err)
+	}
+	u.Path = "/" + name
+	return u.String(), nil
+}
+
+// migrationsDir finds storage/migrations from this file, tests run in the
+// directory of their package.
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "migrations")
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+const streakDateLayout = "2006-01-02"
+
+// GetUserWritingStreak computes the user's writing streaks from the days on
+// which they finished a session, in the timezone of their metadata. Users
+// without a known timezone are counted in UTC.
+func (s *PostgresStore) GetUserWritingStreak(ctx context.Context, userID uuid.UUID) (*types.WritingStreak, error) {
+	var timezone string
+	query := `
+		SELECT COALESCE(m.timezone, '')
+		FROM users u
+		LEFT JOIN user_metadata m ON m.id = u.metadata_id
+		WHERE u.id = $1
+	`
+	if err := s.db.QueryRow(ctx, query, userID).Scan(&timezone); err != nil {
+		return nil, fmt.Errorf("failed to get user timezone: %w", err)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" {
+		if timezone != "" {
+			log.Printf("⚠️ Unknown timezone %q of user %s, counting streak in UTC", timezone, userID)
+		}
+		location, timezone = time.UTC, "UTC"
+	}
+
+	query = `
+		SELECT DISTINCT to_char(ending_timestamp AT TIME ZONE $2, 'YYYY-MM-DD')
+		FROM writing_sessions
+		WHERE user_id = $1 AND ending_timestamp IS NOT NULL
+		ORDER BY 1
+	`
+	rows, err := s.db.Query(ctx, query, userID, timezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writing days: %w", err)
+	}
+	defer rows.Close()
+
+	days := make([]time.Time, 0)
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, fmt.Errorf("failed to scan writing day: %w", err)
+		}
+		day, err := time.Parse(streakDateLayout, date)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse writing day %q: %w", date, err)
+		}
+		days = append(days, day)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over writing days: %w", err)
+	}
+
+	streak := computeWritingStreak(days, s.Clock().Now().In(location))
+	streak.UserID = userID
+	streak.Timezone = timezone
+	return streak, nil
+}
+
+// computeWritingStreak counts the runs of consecutive days in days, which are
+// distinct dates at midnight UTC in ascending order. today is the current time
+// in the writer's timezone.
+func computeWritingStreak(days []time.Time, today time.Time) *types.WritingStreak {
+	streak := &types.WritingStreak{}
+	if len(days) == 0 {
+		return streak
+	}
+
+	run := 0
+	for i, day := range days {
+		if i > 0 && day.Sub(days[i-1]) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		streak.Longest = max(streak.Longest, run)
+	}
+
+	last := days[len(days)-1]
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	streak.LastWrittenDate = last.Format(streakDateLayout)
+	streak.WrittenToday = last.Equal(todayDate)
+	if streak.WrittenToday || last.Equal(todayDate.AddDate(0, 0, -1)) {
+		streak.Current = run
+	}
+	return streak
+}
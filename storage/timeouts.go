@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const defaultQueryTimeout = 5 * time.Second
+
+// Errors returned (wrapped) by every PostgresStore method when a query did not
+// finish. Use errors.Is to tell them apart from regular query failures.
+var (
+	ErrQueryTimeout  = errors.New("storage: query timed out")
+	ErrQueryCanceled = errors.New("storage: query canceled")
+)
+
+// queryStats is published through expvar under "storage_queries".
+var queryStats = expvar.NewMap("storage_queries")
+
+func init() {
+	expvar.Publish("storage_query_cancellation_rate", expvar.Func(func() interface{} {
+		total := queryStatValue("total")
+		if total == 0 {
+			return 0.0
+		}
+		return float64(queryStatValue("timeout")+queryStatValue("canceled")) / float64(total)
+	}))
+}
+
+func queryStatValue(key string) int64 {
+	if v, ok := queryStats.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// queryTimeoutFromEnv reads STORAGE_QUERY_TIMEOUT_MS, falling back to defaultQueryTimeout.
+func queryTimeoutFromEnv() time.Duration {
+	if ms, err := strconv.Atoi(os.Getenv("STORAGE_QUERY_TIMEOUT_MS")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return defaultQueryTimeout
+}
+
+// timeoutDB wraps the pool so that every query runs under a deadline, even
+// when the caller's context has none, and records how each query ended.
+type timeoutDB struct {
+	pool    *pgxpool.Pool
+	timeout time.Duration
+}
+
+func (d *timeoutDB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d.timeout)
+}
+
+func (d *timeoutDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	started := time.Now()
+	tag, err := d.pool.Exec(ctx, sql, args...)
+	recordQuery(ctx, sql, started)
+	return tag, classifyQueryError(ctx, err)
+}
+
+func (d *timeoutDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, cancel := d.withTimeout(ctx)
+
+	started := time.Now()
+	rows, err := d.pool.Query(ctx, sql, args...)
+	recordQuery(ctx, sql, started)
+	if err != nil {
+		cancel()
+		return nil, classifyQueryError(ctx, err)
+	}
+	return &timeoutRows{Rows: rows, ctx: ctx, cancel: cancel}, nil
+}
+
+func (d *timeoutDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	ctx, cancel := d.withTimeout(ctx)
+	started := time.Now()
+	return &timeoutRow{row: d.pool.QueryRow(ctx, sql, args...), ctx: ctx, cancel: cancel, sql: sql, started: started}
+}
+
+func (d *timeoutDB) Close() {
+	d.pool.Close()
+}
+
+// timeoutRows releases the query deadline once the rows are closed.
+type timeoutRows struct {
+	pgx.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+}
+
+func (r *timeoutRows) Close() {
+	r.Rows.Close()
+	if !r.closed {
+		r.closed = true
+		classifyQueryError(r.ctx, r.Rows.Err())
+	}
+	r.cancel()
+}
+
+func (r *timeoutRows) Err() error {
+	return wrapQueryError(r.ctx, r.Rows.Err())
+}
+
+// timeoutRow releases the query deadline once the row is scanned.
+type timeoutRow struct {
+	row     pgx.Row
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sql     string
+	started time.Time
+}
+
+func (r *timeoutRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	err := r.row.Scan(dest...)
+	recordQuery(r.ctx, r.sql, r.started)
+	return classifyQueryError(r.ctx, err)
+}
+
+// classifyQueryError counts the outcome of a query and wraps timeouts and
+// cancellations in ErrQueryTimeout and ErrQueryCanceled.
+func classifyQueryError(ctx context.Context, err error) error {
+	queryStats.Add("total", 1)
+	switch {
+	case err == nil, errors.Is(err, pgx.ErrNoRows):
+		queryStats.Add("ok", 1)
+	case isQueryTimeout(ctx, err):
+		queryStats.Add("timeout", 1)
+	case isQueryCanceled(ctx, err):
+		queryStats.Add("canceled", 1)
+	default:
+		queryStats.Add("error", 1)
+	}
+	return wrapQueryError(ctx, err)
+}
+
+func wrapQueryError(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isQueryTimeout(ctx, err):
+		return fmt.Errorf("%w: %w", ErrQueryTimeout, err)
+	case isQueryCanceled(ctx, err):
+		return fmt.Errorf("%w: %w", ErrQueryCanceled, err)
+	default:
+		return err
+	}
+}
+
+func isQueryTimeout(ctx context.Context, err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return true
+	}
+	// 57014 is query_canceled, which is what statement_timeout raises
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "57014" && ctx.Err() == nil
+}
+
+func isQueryCanceled(ctx context.Context, err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled)
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const userDeletionColumns = `user_id, requested_at, purge_after, unpin_ipfs`
+
+func scanUserDeletion(row pgx.Row) (*types.UserDeletion, error) {
+	deletion := new(types.UserDeletion)
+	if err := row.Scan(&deletion.UserID, &deletion.RequestedAt, &deletion.PurgeAfter, &deletion.UnpinIPFS); err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// ScheduleUserDeletion schedules the purge of the user. Asking again keeps
+// the original date and only updates whether IPFS content is unpinned.
+func (s *PostgresStore) ScheduleUserDeletion(ctx context.Context, deletion *types.UserDeletion) error {
+	query := `
+		INSERT INTO user_deletions (user_id, requested_at, purge_after, unpin_ipfs)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET unpin_ipfs = EXCLUDED.unpin_ipfs
+		RETURNING ` + userDeletionColumns
+	scheduled, err := scanUserDeletion(s.db.QueryRow(ctx, query, deletion.UserID, deletion.RequestedAt, deletion.PurgeAfter, deletion.UnpinIPFS))
+	if err != nil {
+		return fmt.Errorf("failed to schedule user deletion: %w", err)
+	}
+	*deletion = *scheduled
+	return nil
+}
+
+// GetUserDeletion returns the scheduled deletion of the user, wrapping
+// pgx.ErrNoRows when there is none.
+func (s *PostgresStore) GetUserDeletion(ctx context.Context, userID uuid.UUID) (*types.UserDeletion, error) {
+	query := `SELECT ` + userDeletionColumns + ` FROM user_deletions WHERE user_id = $1`
+	deletion, err := scanUserDeletion(s.db.QueryRow(ctx, query, userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user deletion: %w", err)
+	}
+	return deletion, nil
+}
+
+// IsUserDeletionScheduled reports whether the account is in its grace period
+// and so treated as deleted.
+func (s *PostgresStore) IsUserDeletionScheduled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var scheduled bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM user_deletions WHERE user_id = $1)`, userID).Scan(&scheduled)
+	if err != nil {
+		return false, fmt.Errorf("failed to check user deletion: %w", err)
+	}
+	return scheduled, nil
+}
+
+// CancelUserDeletion unschedules the deletion of the user and reports
+// whether one was scheduled.
+func (s *PostgresStore) CancelUserDeletion(ctx context.Context, userID uuid.UUID) (bool, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM user_deletions WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel user deletion: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetDueUserDeletions returns up to limit deletions whose grace period ended
+// before the given time, oldest first.
+func (s *PostgresStore) GetDueUserDeletions(ctx context.Context, before time.Time, limit int) ([]*types.UserDeletion, error) {
+	query := `SELECT ` + userDeletionColumns + ` FROM user_deletions WHERE purge_after <= $1 ORDER BY purge_after LIMIT $2`
+	rows, err := s.db.Query(ctx, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due user deletions: %w", err)
+	}
+	defer rows.Close()
+
+	deletions := make([]*types.UserDeletion, 0)
+	for rows.Next() {
+		deletion, err := scanUserDeletion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user deletion: %w", err)
+		}
+		deletions = append(deletions, deletion)
+	}
+	return deletions, rows.Err()
+}
+
+// GetUserFootprint returns what the user left outside the database, which
+// DeleteUser can't remove: archived writing, exports, the files named after
+// their sessions and FIDs, and the IPFS content of their Ankys.
+func (s *PostgresStore) GetUserFootprint(ctx context.Context, userID uuid.UUID) (*types.UserFootprint, error) {
+	footprint := &types.UserFootprint{
+		SessionIDs: make([]uuid.UUID, 0),
+		FIDs:       make([]int, 0),
+		IPFSHashes: make([]string, 0),
+	}
+
+	var err error
+	if footprint.ArchiveKeys, err = s.GetUserArchiveKeys(ctx, userID); err != nil {
+		return nil, err
+	}
+	if footprint.ExportKeys, err = s.GetUserExportKeys(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `SELECT id FROM writing_sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user session ids: %w", err)
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan session id: %w", err)
+		}
+		footprint.SessionIDs = append(footprint.SessionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user session ids: %w", err)
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT fid FROM users WHERE id = $1 AND fid > 0
+		UNION
+		SELECT fid FROM ankys WHERE user_id = $1 AND fid > 0`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user fids: %w", err)
+	}
+	for rows.Next() {
+		var fid int
+		if err := rows.Scan(&fid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan fid: %w", err)
+		}
+		footprint.FIDs = append(footprint.FIDs, fid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user fids: %w", err)
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT image_ipfs_hash FROM ankys WHERE user_id = $1 AND image_ipfs_hash <> ''
+		UNION
+		SELECT substring(metadata_uri FROM 8) FROM ankys WHERE user_id = $1 AND metadata_uri LIKE 'ipfs://%'
+		UNION
+		SELECT metadata_ipfs_hash FROM ankys WHERE user_id = $1 AND metadata_ipfs_hash <> ''
+		UNION
+		SELECT i.image_ipfs_hash FROM anky_images i JOIN ankys a ON a.id = i.anky_id
+		WHERE a.user_id = $1 AND i.image_ipfs_hash <> ''
+		UNION
+		SELECT v.image_ipfs_hash FROM anky_image_versions v JOIN ankys a ON a.id = v.anky_id
+		WHERE a.user_id = $1 AND v.image_ipfs_hash <> ''`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user ipfs hashes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan ipfs hash: %w", err)
+		}
+		footprint.IPFSHashes = append(footprint.IPFSHashes, hash)
+	}
+	return footprint, rows.Err()
+}
@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage"
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+func onLeaderboard(t *testing.T, store *storage.PostgresStore, userID uuid.UUID) bool {
+	t.Helper()
+	entries, err := store.GetLeaderboard(context.Background(), types.LeaderboardMetricSessions, types.LeaderboardPeriodAll, 100, 0)
+	if err != nil {
+		t.Fatalf("reading leaderboard: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAScheduledDeletionHidesTheUserUntilCancelled(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	user := createTestUser(t, store, false)
+	endedAt := now
+	session := &types.WritingSession{
+		ID:                uuid.New(),
+		UserID:            user.ID,
+		StartingTimestamp: now.Add(-8 * time.Minute),
+		EndingTimestamp:   &endedAt,
+		Prompt:            "what is alive in you this morning?",
+		Status:            "completed",
+		Writing:           "the morning light came through the window",
+		WordsWritten:      7,
+	}
+	if err := store.CreateWritingSession(ctx, session); err != nil {
+		t.Fatalf("creating writing session: %v", err)
+	}
+	if !onLeaderboard(t, store, user.ID) {
+		t.Fatal("the writer isn't on the leaderboard to begin with")
+	}
+
+	deletion := &types.UserDeletion{UserID: user.ID, RequestedAt: now, PurgeAfter: now.Add(time.Hour)}
+	if err := store.ScheduleUserDeletion(ctx, deletion); err != nil {
+		t.Fatal(err)
+	}
+	if scheduled, err := store.IsUserDeletionScheduled(ctx, user.ID); err != nil || !scheduled {
+		t.Errorf("scheduled = %v, %v, want true", scheduled, err)
+	}
+	if onLeaderboard(t, store, user.ID) {
+		t.Error("a writer being deleted is on the leaderboard")
+	}
+
+	if _, err := store.CancelUserDeletion(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if scheduled, err := store.IsUserDeletionScheduled(ctx, user.ID); err != nil || scheduled {
+		t.Errorf("scheduled = %v, %v after cancelling, want false", scheduled, err)
+	}
+	if !onLeaderboard(t, store, user.ID) {
+		t.Error("the writer is still off the leaderboard after cancelling")
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const userExportColumns = `id, user_id, status, blob_key, download_token, size_bytes, error, created_at, completed_at, expires_at`
+
+func scanUserExport(row pgx.Row) (*types.UserExport, error) {
+	export := new(types.UserExport)
+	var blobKey, downloadToken, exportErr *string
+	err := row.Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&blobKey,
+		&downloadToken,
+		&export.SizeBytes,
+		&exportErr,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if blobKey != nil {
+		export.BlobKey = *blobKey
+	}
+	if downloadToken != nil {
+		export.DownloadToken = *downloadToken
+	}
+	if exportErr != nil {
+		export.Error = *exportErr
+	}
+	return export, nil
+}
+
+// CreateUserExport stores a pending export and fills in its ID and creation time.
+func (s *PostgresStore) CreateUserExport(ctx context.Context, export *types.UserExport) error {
+	export.ID = s.IDs().NewID()
+	export.Status = types.UserExportPending
+	if export.CreatedAt.IsZero() {
+		export.CreatedAt = s.Clock().Now().UTC()
+	}
+	query := `INSERT INTO user_exports (id, user_id, status, created_at) VALUES ($1, $2, $3, $4)`
+	if _, err := s.db.Exec(ctx, query, export.ID, export.UserID, export.Status, export.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create user export: %w", err)
+	}
+	return nil
+}
+
+// GetLatestUserExport returns the user's most recent export, wrapping
+// pgx.ErrNoRows when they never asked for one.
+func (s *PostgresStore) GetLatestUserExport(ctx context.Context, userID uuid.UUID) (*types.UserExport, error) {
+	query := `SELECT ` + userExportColumns + ` FROM user_exports WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
+	export, err := scanUserExport(s.db.QueryRow(ctx, query, userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest user export: %w", err)
+	}
+	return export, nil
+}
+
+// GetUserExportByToken returns the ready, unexpired export with the download
+// token, wrapping pgx.ErrNoRows otherwise.
+func (s *PostgresStore) GetUserExportByToken(ctx context.Context, token string) (*types.UserExport, error) {
+	query := `
+		SELECT ` + userExportColumns + ` FROM user_exports
+		WHERE download_token = $1 AND status = $2 AND expires_at > $3`
+	export, err := scanUserExport(s.db.QueryRow(ctx, query, token, types.UserExportReady, s.Clock().Now()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user export: %w", err)
+	}
+	return export, nil
+}
+
+// CompleteUserExport marks the export ready with its zip and download token.
+func (s *PostgresStore) CompleteUserExport(ctx context.Context, export *types.UserExport) error {
+	query := `
+		UPDATE user_exports SET status = $2, blob_key = $3, download_token = $4, size_bytes = $5,
+			completed_at = $6, expires_at = $7
+		WHERE id = $1`
+	_, err := s.db.Exec(ctx, query,
+		export.ID,
+		types.UserExportReady,
+		export.BlobKey,
+		export.DownloadToken,
+		export.SizeBytes,
+		export.CompletedAt,
+		export.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to complete user export: %w", err)
+	}
+	export.Status = types.UserExportReady
+	return nil
+}
+
+// FailUserExport marks the export failed with the reason.
+func (s *PostgresStore) FailUserExport(ctx context.Context, exportID uuid.UUID, reason string) error {
+	query := `UPDATE user_exports SET status = $2, error = $3, completed_at = $4 WHERE id = $1`
+	if _, err := s.db.Exec(ctx, query, exportID, types.UserExportFailed, reason, s.Clock().Now()); err != nil {
+		return fmt.Errorf("failed to mark user export failed: %w", err)
+	}
+	return nil
+}
+
+// GetExpiredUserExports returns exports that expired before the given time,
+// oldest first.
+func (s *PostgresStore) GetExpiredUserExports(ctx context.Context, before time.Time, limit int) ([]*types.UserExport, error) {
+	query := `SELECT ` + userExportColumns + ` FROM user_exports WHERE expires_at < $1 ORDER BY expires_at ASC LIMIT $2`
+	rows, err := s.db.Query(ctx, query, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired user exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := make([]*types.UserExport, 0)
+	for rows.Next() {
+		export, err := scanUserExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user export: %w", err)
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// GetUserExportKeys returns the blob keys of the user's exports, so they can
+// be deleted along with the user.
+func (s *PostgresStore) GetUserExportKeys(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	rows, err := s.db.Query(ctx, `SELECT blob_key FROM user_exports WHERE user_id = $1 AND blob_key IS NOT NULL`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user export keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan user export key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteUserExport forgets the export. Its zip must be deleted first.
+func (s *PostgresStore) DeleteUserExport(ctx context.Context, exportID uuid.UUID) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM user_exports WHERE id = $1`, exportID); err != nil {
+		return fmt.Errorf("failed to delete user export: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotAnonymous  = errors.New("only anonymous accounts can be merged")
+	ErrMergeIntoSelf = errors.New("an account can't be merged into itself")
+)
+
+// MergeAnonymousUser moves the writing sessions, ankys, badges and newen
+// transactions of an anonymous account to a registered one, and deletes the
+// anonymous account, all in one transaction. The moved sessions are numbered
+// again among the registered account's. Webhooks, buddies, reminders,
+// opt-ins, handoffs, exports, announcement reads, year in reviews and linked
+// accounts follow them; where the registered account already has its own,
+// for the same day, year or announcement, it keeps it and the anonymous
+// account's is dropped with it. Recaps and usage counters are rebuilt for
+// the registered account.
+func (s *PostgresStore) MergeAnonymousUser(ctx context.Context, anonymousUserID uuid.UUID, targetUserID uuid.UUID) (*types.UserMerge, error) {
+	if anonymousUserID == targetUserID {
+		return nil, ErrMergeIntoSelf
+	}
+
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin user merge: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	// Both rows are locked in a fixed order so concurrent merges can't deadlock
+	rows, err := tx.Query(ctx, `SELECT id FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, anonymousUserID, targetUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock users: %w", classifyQueryError(ctx, err))
+	}
+	rows.Close()
+
+	anonymous, err := scanIntoUser(tx.QueryRow(ctx, selectUsers+`WHERE u.id = $1`, anonymousUserID))
+	if err != nil {
+		return nil, err
+	}
+	if anonymous.IsRegistered() {
+		return nil, ErrNotAnonymous
+	}
+	target, err := scanIntoUser(tx.QueryRow(ctx, selectUsers+`WHERE u.id = $1`, targetUserID))
+	if err != nil {
+		return nil, err
+	}
+
+	merge := &types.UserMerge{AnonymousUserID: anonymousUserID, MergedInto: targetUserID, MergedAt: s.Clock().Now().UTC()}
+
+	// A writer has one buddy at a time: the anonymous account's pair ends
+	// when the registered account already has one
+	_, err = tx.Exec(ctx, `
+		UPDATE writing_buddies SET ended_at = $3
+		WHERE ended_at IS NULL
+			AND pair_id IN (SELECT pair_id FROM writing_buddies WHERE user_id = $1 AND ended_at IS NULL)
+			AND EXISTS (SELECT 1 FROM writing_buddies WHERE user_id = $2 AND ended_at IS NULL)`,
+		anonymousUserID, targetUserID, merge.MergedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to end buddy pair: %w", classifyQueryError(ctx, err))
+	}
+
+	moves := []struct {
+		count *int
+		query string
+	}{
+		{&merge.WritingSessions, `UPDATE writing_sessions SET user_id = $2 WHERE user_id = $1`},
+		{&merge.Ankys, `UPDATE ankys SET user_id = $2 WHERE user_id = $1`},
+		{&merge.Badges, `UPDATE badges SET user_id = $2 WHERE user_id = $1`},
+		// An idempotency key the registered account already used is dropped
+		// from the moved transaction instead of failing the merge
+		{&merge.NewenTransactions, `
+			UPDATE newen_transactions t SET user_id = $2,
+				idempotency_key = CASE WHEN EXISTS (
+					SELECT 1 FROM newen_transactions o WHERE o.user_id = $2 AND o.idempotency_key = t.idempotency_key
+				) THEN NULL ELSE t.idempotency_key END
+			WHERE t.user_id = $1`},
+		{nil, `UPDATE anky_license_changes SET user_id = $2 WHERE user_id = $1`},
+		{nil, `UPDATE fid_requests SET user_id = $2 WHERE user_id = $1`},
+		{nil, `UPDATE farcaster_unlinks SET user_id = $2 WHERE user_id = $1`},
+		{nil, `UPDATE webhooks SET user_id = $2 WHERE user_id = $1`},
+		// The two accounts can't stay each other's buddies
+		{nil, `DELETE FROM writing_buddies WHERE (user_id = $1 AND buddy_id = $2) OR (user_id = $2 AND buddy_id = $1)`},
+		{nil, `UPDATE writing_buddies SET user_id = $2 WHERE user_id = $1`},
+		{nil, `UPDATE writing_buddies SET buddy_id = $2 WHERE buddy_id = $1`},
+		{nil, `UPDATE buddy_opt_ins SET user_id = $2 WHERE user_id = $1 AND NOT EXISTS (SELECT 1 FROM buddy_opt_ins WHERE user_id = $2)`},
+		{nil, `
+			UPDATE buddy_nudges n SET user_id = $2 WHERE n.user_id = $1 AND NOT EXISTS (
+				SELECT 1 FROM buddy_nudges o WHERE o.user_id = $2 AND o.missed_date = n.missed_date
+			)`},
+		{nil, `UPDATE buddy_nudges SET from_user_id = $2 WHERE from_user_id = $1`},
+		{nil, `
+			UPDATE writing_reminders r SET user_id = $2 WHERE r.user_id = $1 AND NOT EXISTS (
+				SELECT 1 FROM writing_reminders o WHERE o.user_id = $2 AND o.reminder_date = r.reminder_date
+			)`},
+		{nil, `UPDATE reminder_opt_outs SET user_id = $2 WHERE user_id = $1 AND NOT EXISTS (SELECT 1 FROM reminder_opt_outs WHERE user_id = $2)`},
+		{nil, `UPDATE dataset_opt_ins SET user_id = $2 WHERE user_id = $1 AND NOT EXISTS (SELECT 1 FROM dataset_opt_ins WHERE user_id = $2)`},
+		{nil, `UPDATE session_handoffs SET user_id = $2 WHERE user_id = $1`},
+		{nil, `UPDATE user_exports SET user_id = $2 WHERE user_id = $1`},
+		{nil, `
+			UPDATE announcement_reads r SET user_id = $2 WHERE r.user_id = $1 AND NOT EXISTS (
+				SELECT 1 FROM announcement_reads o WHERE o.user_id = $2 AND o.announcement_id = r.announcement_id
+			)`},
+		{nil, `
+			UPDATE year_in_reviews y SET user_id = $2 WHERE y.user_id = $1 AND NOT EXISTS (
+				SELECT 1 FROM year_in_reviews o WHERE o.user_id = $2 AND o.year = y.year
+			)`},
+		// Linked accounts hang off the Privy account
+		{nil, `UPDATE privy_users SET user_id = $2 WHERE user_id = $1`},
+	}
+	for _, move := range moves {
+		tag, err := tx.Exec(ctx, move.query, anonymousUserID, targetUserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to move user data: %w", classifyQueryError(ctx, err))
+		}
+		if move.count != nil {
+			*move.count = int(tag.RowsAffected())
+		}
+	}
+
+	// Sessions are numbered in the order they were written
+	_, err = tx.Exec(ctx, `
+		UPDATE writing_sessions w SET session_index_for_user = n.session_index
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY starting_timestamp, id) - 1 AS session_index
+			FROM writing_sessions WHERE user_id = $1
+		) n
+		WHERE w.id = n.id AND w.session_index_for_user <> n.session_index`, targetUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to renumber writing sessions: %w", classifyQueryError(ctx, err))
+	}
+
+	// A frames writer keeps their FID when they sign up in the app
+	if anonymous.FID != 0 && target.FID == 0 {
+		if _, err := tx.Exec(ctx, `UPDATE users SET fid = $2 WHERE id = $1`, targetUserID, anonymous.FID); err != nil {
+			return nil, fmt.Errorf("failed to move fid: %w", classifyQueryError(ctx, err))
+		}
+	}
+
+	query := `
+		INSERT INTO user_merges (anonymous_user_id, merged_into, writing_sessions, ankys, badges, newen_transactions, merged_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err = tx.Exec(ctx, query, merge.AnonymousUserID, merge.MergedInto, merge.WritingSessions, merge.Ankys, merge.Badges, merge.NewenTransactions, merge.MergedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record user merge: %w", classifyQueryError(ctx, err))
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, anonymousUserID); err != nil {
+		return nil, fmt.Errorf("failed to delete merged user: %w", classifyQueryError(ctx, err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit user merge: %w", classifyQueryError(ctx, err))
+	}
+	return merge, nil
+}
+
+// IsUserMerged reports whether the account was merged into another one.
+func (s *PostgresStore) IsUserMerged(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var merged bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM user_merges WHERE anonymous_user_id = $1)`, userID).Scan(&merged)
+	if err != nil {
+		return false, fmt.Errorf("failed to check user merge: %w", err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,85 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/storage/storagetest"
+	"github.com/ankylat/anky/server/types"
+)
+
+func TestMergeAnonymousUserMovesEverythingTheAccountOwned(t *testing.T) {
+	store := storagetest.Open(t)
+	ctx := context.Background()
+	anonymous := createTestUser(t, store, true)
+	target := createTestUser(t, store, false)
+
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 8, 0, 0, 0, time.UTC) }
+	first := createTestSession(t, store, anonymous.ID, 0, day(1))
+	second := createTestSession(t, store, target.ID, 0, day(2))
+	third := createTestSession(t, store, anonymous.ID, 1, day(3))
+
+	webhook := &types.Webhook{UserID: anonymous.ID, URL: "https://example.com/hook", Secret: "secret", Events: []string{"completed"}}
+	if err := store.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.OptInToResearchDataset(ctx, anonymous.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Both are opted in to buddies and paired, the registered account's pair stays
+	for _, user := range []*types.User{anonymous, target} {
+		if err := store.OptInToBuddies(ctx, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anonymousBuddy, targetBuddy := createTestUser(t, store, false), createTestUser(t, store, false)
+	if _, err := store.CreateBuddyPair(ctx, anonymous.ID, anonymousBuddy.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateBuddyPair(ctx, target.ID, targetBuddy.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.MergeAnonymousUser(ctx, anonymous.ID, target.ID); err != nil {
+		t.Fatalf("MergeAnonymousUser: %v", err)
+	}
+
+	webhooks, err := store.GetWebhooks(ctx, target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 1 || webhooks[0].ID != webhook.ID {
+		t.Errorf("registered account's webhooks = %v, want the anonymous account's", webhooks)
+	}
+	if optedIn, err := store.IsOptedInToResearchDataset(ctx, target.ID); err != nil || !optedIn {
+		t.Errorf("research dataset opt-in = %v, %v, want it moved", optedIn, err)
+	}
+	if optedIn, err := store.IsOptedInToBuddies(ctx, target.ID); err != nil || !optedIn {
+		t.Errorf("buddy opt-in = %v, %v, want it kept", optedIn, err)
+	}
+	pair, err := store.GetActiveBuddyPair(ctx, target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.BuddyID != targetBuddy.ID {
+		t.Errorf("buddy = %s, want the registered account's %s", pair.BuddyID, targetBuddy.ID)
+	}
+	if _, err := store.GetActiveBuddyPair(ctx, anonymousBuddy.ID); err == nil {
+		t.Error("the anonymous account's buddy is still paired")
+	}
+
+	want := map[string]int{first.ID.String(): 0, second.ID.String(): 1, third.ID.String(): 2}
+	sessions, err := store.GetUserWritingSessions(ctx, target.ID, false, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != len(want) {
+		t.Fatalf("registered account has %d sessions, want %d", len(sessions), len(want))
+	}
+	for _, session := range sessions {
+		if session.SessionIndexForUser != want[session.ID.String()] {
+			t.Errorf("session of %s is number %d, want %d", session.StartingTimestamp.Format(time.DateOnly), session.SessionIndexForUser, want[session.ID.String()])
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ErrVersionConflict is returned by updates of rows that changed since they
+// were read. Errors wrapping it are *VersionConflictError.
+var ErrVersionConflict = errors.New("storage: row was modified concurrently")
+
+// VersionConflictError tells which row an update lost the race on and the
+// version it is at now, so the caller can read it again and retry.
+type VersionConflictError struct {
+	Resource       string
+	ID             uuid.UUID
+	Version        int
+	CurrentVersion int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s %s was modified concurrently: updated at version %d, it is at version %d", e.Resource, e.ID, e.Version, e.CurrentVersion)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// versionConflict explains why a versioned update of table matched no row:
+// either the row is gone, in which case it returns pgx.ErrNoRows, or it moved
+// past the version the update was read at.
+func (s *PostgresStore) versionConflict(ctx context.Context, table string, resource string, id uuid.UUID, version int) error {
+	var current int
+	query := fmt.Sprintf(`SELECT version FROM %s WHERE id = $1`, table)
+	if err := s.db.QueryRow(ctx, query, id).Scan(&current); err != nil {
+		return err
+	}
+	return &VersionConflictError{Resource: resource, ID: id, Version: version, CurrentVersion: current}
+}
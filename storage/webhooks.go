@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const webhookColumns = `id, user_id, url, secret, events, all_ankys, created_at, last_delivery_at, COALESCE(last_error, ''), consecutive_failures, disabled_at`
+
+func scanWebhook(row pgx.Row) (*types.Webhook, error) {
+	webhook := new(types.Webhook)
+	err := row.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret, &webhook.Events, &webhook.AllAnkys,
+		&webhook.CreatedAt, &webhook.LastDeliveryAt, &webhook.LastError, &webhook.ConsecutiveFailures, &webhook.DisabledAt)
+	return webhook, err
+}
+
+func (s *PostgresStore) CreateWebhook(ctx context.Context, webhook *types.Webhook) error {
+	webhook.ID = s.IDs().NewID()
+	webhook.CreatedAt = s.Clock().Now()
+	query := `
+		INSERT INTO webhooks (id, user_id, url, secret, events, all_ankys, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := s.db.Exec(ctx, query, webhook.ID, webhook.UserID, webhook.URL, webhook.Secret, webhook.Events, webhook.AllAnkys, webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) CountWebhooks(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM webhooks WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count webhooks: %w", err)
+	}
+	return count, nil
+}
+
+// GetWebhooks returns the user's webhooks, oldest first, with their secrets.
+func (s *PostgresStore) GetWebhooks(ctx context.Context, userID uuid.UUID) ([]*types.Webhook, error) {
+	return s.queryWebhooks(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE user_id = $1 ORDER BY created_at ASC`, userID)
+}
+
+// GetWebhooksForEvent returns the enabled webhooks to call when an Anky of
+// the owner reaches the event: the owner's and the ones for every Anky.
+// ownerID is nil for Ankys without a stored owner, like frames Ankys.
+func (s *PostgresStore) GetWebhooksForEvent(ctx context.Context, event string, ownerID *uuid.UUID) ([]*types.Webhook, error) {
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		WHERE disabled_at IS NULL AND $1 = ANY(events) AND (all_ankys OR user_id = $2)
+		ORDER BY created_at ASC`
+	return s.queryWebhooks(ctx, query, event, ownerID)
+}
+
+func (s *PostgresStore) queryWebhooks(ctx context.Context, query string, args ...interface{}) ([]*types.Webhook, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []*types.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook deletes one of the user's webhooks and reports whether it
+// existed.
+func (s *PostgresStore) DeleteWebhook(ctx context.Context, userID uuid.UUID, webhookID uuid.UUID) (bool, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, webhookID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordWebhookDelivery stores the outcome of a delivery, deliveryErr empty
+// when it succeeded. The webhook is disabled once maxFailures deliveries in
+// a row failed.
+func (s *PostgresStore) RecordWebhookDelivery(ctx context.Context, webhookID uuid.UUID, deliveryErr string, maxFailures int) error {
+	query := `
+		UPDATE webhooks SET
+			last_delivery_at = $2,
+			last_error = NULLIF($3, ''),
+			consecutive_failures = CASE WHEN $3 = '' THEN 0 ELSE consecutive_failures + 1 END,
+			disabled_at = CASE WHEN $3 <> '' AND consecutive_failures + 1 >= $4 THEN $2 ELSE disabled_at END
+		WHERE id = $1`
+	if _, err := s.db.Exec(ctx, query, webhookID, s.Clock().Now(), deliveryErr, maxFailures); err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrSessionNotOwned = errors.New("session belongs to another user")
+	ErrSyncConflict    = errors.New("session was already finished with different content")
+)
+
+// SyncWritingSession stores a session finished offline and returns
+// types.SyncAccepted, or types.SyncDuplicate when the server already has it:
+// the same content, or an older copy according to the vector clocks. A
+// session started online is finished by its synced copy. A finished session
+// never changes, a diverging copy fails with ErrSyncConflict.
+func (s *PostgresStore) SyncWritingSession(ctx context.Context, ws *types.WritingSession, clock types.VectorClock) (string, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin session sync: %w", classifyQueryError(ctx, err))
+	}
+	defer tx.Rollback(context.Background())
+
+	// Syncs of one writer run one at a time, which also keeps their session indexes unique
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, ws.UserID); err != nil {
+		return "", fmt.Errorf("failed to lock user: %w", classifyQueryError(ctx, err))
+	}
+
+	existing, err := scanIntoWritingSession(tx.QueryRow(ctx, `SELECT `+writingSessionColumns+` FROM writing_sessions WHERE id = $1 FOR UPDATE`, ws.ID))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		if err := s.insertSyncedWritingSession(ctx, tx, ws); err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", fmt.Errorf("failed to get writing session: %w", classifyQueryError(ctx, err))
+	case existing.UserID != ws.UserID:
+		return "", ErrSessionNotOwned
+	case existing.EndingTimestamp == nil:
+		ws.SessionIndexForUser = existing.SessionIndexForUser
+		if err := finishSyncedWritingSession(ctx, tx, ws); err != nil {
+			return "", err
+		}
+	default:
+		var stored types.VectorClock
+		err := tx.QueryRow(ctx, `SELECT clock FROM writing_session_sync_clocks WHERE session_id = $1`, ws.ID).Scan(&stored)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("failed to get sync clock: %w", classifyQueryError(ctx, err))
+		}
+		sameWriting := existing.Writing == ws.Writing
+		if existing.Archived && existing.ArchiveChecksum != nil {
+			sameWriting = *existing.ArchiveChecksum == WritingChecksum(ws.Writing)
+		}
+		sameContent := sameWriting && existing.EndingTimestamp.Equal(*ws.EndingTimestamp)
+		if !sameContent && (stored == nil || !clock.DominatedBy(stored)) {
+			return "", ErrSyncConflict
+		}
+		if err := s.saveSyncClock(ctx, tx, ws, stored.Merge(clock)); err != nil {
+			return "", err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return "", fmt.Errorf("failed to commit session sync: %w", classifyQueryError(ctx, err))
+		}
+		return types.SyncDuplicate, nil
+	}
+
+	if err := s.saveSyncClock(ctx, tx, ws, clock); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to commit session sync: %w", classifyQueryError(ctx, err))
+	}
+	return types.SyncAccepted, nil
+}
+
+func (s *PostgresStore) insertSyncedWritingSession(ctx context.Context, tx pgx.Tx, ws *types.WritingSession) error {
+	query := `SELECT COALESCE(MAX(session_index_for_user) + 1, 0) FROM writing_sessions WHERE user_id = $1`
+	if err := tx.QueryRow(ctx, query, ws.UserID).Scan(&ws.SessionIndexForUser); err != nil {
+		return fmt.Errorf("failed to get next session index: %w", classifyQueryError(ctx, err))
+	}
+
+	query = `
+		INSERT INTO writing_sessions (
+			id, user_id, session_index_for_user, starting_timestamp, ending_timestamp,
+			prompt, status, writing, words_written, newen_earned,
+			time_spent, is_anky, parent_anky_id, anky_response, is_onboarding
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`
+	_, err := tx.Exec(ctx, query,
+		ws.ID,
+		ws.UserID,
+		ws.SessionIndexForUser,
+		ws.StartingTimestamp,
+		ws.EndingTimestamp,
+		ws.Prompt,
+		ws.Status,
+		ws.Writing,
+		ws.WordsWritten,
+		ws.NewenEarned,
+		ws.TimeSpent,
+		ws.IsAnky,
+		ws.ParentAnkyID,
+		ws.AnkyResponse,
+		ws.IsOnboarding,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert synced writing session: %w", classifyQueryError(ctx, err))
+	}
+	return nil
+}
+
+func finishSyncedWritingSession(ctx context.Context, tx pgx.Tx, ws *types.WritingSession) error {
+	query := `
+		UPDATE writing_sessions SET
+			starting_timestamp = $1,
+			ending_timestamp = $2,
+			status = $3,
+			writing = $4,
+			words_written = $5,
+			newen_earned = $6,
+			time_spent = $7,
+			is_anky = $8,
+			parent_anky_id = $9,
+			anky_response = $10,
+			is_onboarding = is_onboarding OR $11
+		WHERE id = $12
+	`
+	_, err := tx.Exec(ctx, query,
+		ws.StartingTimestamp,
+		ws.EndingTimestamp,
+		ws.Status,
+		ws.Writing,
+		ws.WordsWritten,
+		ws.NewenEarned,
+		ws.TimeSpent,
+		ws.IsAnky,
+		ws.ParentAnkyID,
+		ws.AnkyResponse,
+		ws.IsOnboarding,
+		ws.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish synced writing session: %w", classifyQueryError(ctx, err))
+	}
+	return nil
+}
+
+func (s *PostgresStore) saveSyncClock(ctx context.Context, tx pgx.Tx, ws *types.WritingSession, clock types.VectorClock) error {
+	encoded, err := json.Marshal(clock)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync clock: %w", err)
+	}
+	query := `
+		INSERT INTO writing_session_sync_clocks (session_id, clock, synced_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (session_id) DO UPDATE SET clock = EXCLUDED.clock, synced_at = EXCLUDED.synced_at
+	`
+	if _, err := tx.Exec(ctx, query, ws.ID, encoded, s.Clock().Now().UTC()); err != nil {
+		return fmt.Errorf("failed to save sync clock: %w", classifyQueryError(ctx, err))
+	}
+	return nil
+}
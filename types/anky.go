@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -57,6 +60,93 @@ type CreateWritingSessionEndRequest struct {
 	Status          string    `json:"status"`
 	IsOnboarding    bool      `json:"is_onboarding"`
 	Text            string    `json:"text"`
+	// The session long string with its keystrokes, see
+	// utils.ParseWritingSession. Sessions ended without it are unverified.
+	KeystrokeLog string `json:"keystroke_log"`
+}
+
+// VectorClock counts, per device, the edits a client made to a session while
+// offline. A copy whose clock is dominated by another's is an older version of it.
+type VectorClock map[string]uint64
+
+// DominatedBy reports whether every device counter of v is at most the one in other.
+func (v VectorClock) DominatedBy(other VectorClock) bool {
+	for device, counter := range v {
+		if counter > other[device] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge returns the highest counter of each device in either clock.
+func (v VectorClock) Merge(other VectorClock) VectorClock {
+	merged := make(VectorClock, len(v)+len(other))
+	for device, counter := range v {
+		merged[device] = counter
+	}
+	for device, counter := range other {
+		merged[device] = max(merged[device], counter)
+	}
+	return merged
+}
+
+// SyncWritingSessionsRequest is a batch of sessions written and finished
+// offline. Their IDs are generated by the client, so sending a batch again
+// never creates a session twice.
+type SyncWritingSessionsRequest struct {
+	Sessions []SyncedWritingSession `json:"sessions"`
+}
+
+type SyncedWritingSession struct {
+	ID                uuid.UUID   `json:"id"`
+	StartingTimestamp time.Time   `json:"starting_timestamp"`
+	EndingTimestamp   time.Time   `json:"ending_timestamp"`
+	Prompt            string      `json:"prompt"`
+	Text              string      `json:"text"`
+	IsOnboarding      bool        `json:"is_onboarding"`
+	ParentAnkyID      *uuid.UUID  `json:"parent_anky_id,omitempty"`
+	AnkyResponse      string      `json:"anky_response,omitempty"`
+	Clock             VectorClock `json:"clock"`
+	// The session long string with its keystrokes, sessions synced without
+	// it are unverified
+	KeystrokeLog string `json:"keystroke_log,omitempty"`
+}
+
+// Outcomes of syncing one session
+const (
+	SyncAccepted  = "accepted"
+	SyncDuplicate = "duplicate"
+	SyncRejected  = "rejected"
+)
+
+type WritingSessionSyncResult struct {
+	ID     uuid.UUID `json:"id"`
+	Status string    `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// SessionHandoff is a code that lets another device pick up a pending
+// session and its prompt. Sessions stored through /writing-session-started
+// carry their user; frames sessions, which aren't stored yet, their FID.
+type SessionHandoff struct {
+	ID         uuid.UUID  `json:"-"`
+	Code       string     `json:"code"`
+	SessionID  uuid.UUID  `json:"session_id"`
+	UserID     *uuid.UUID `json:"user_id,omitempty"`
+	FID        int        `json:"fid,omitempty"`
+	Prompt     string     `json:"prompt"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RedeemedAt *time.Time `json:"redeemed_at,omitempty"`
+}
+
+// WritingSessionSearchResult is a session whose writing matched a search,
+// with the passages that matched between <mark> tags.
+type WritingSessionSearchResult struct {
+	Session *WritingSession `json:"session"`
+	Snippet string          `json:"snippet"`
+	Rank    float32         `json:"rank"`
 }
 
 type CreateAnkyRequest struct {
@@ -67,23 +157,28 @@ type CreateAnkyRequest struct {
 }
 
 type User struct {
-	ID              uuid.UUID        `json:"id"`
-	IsAnonymous     bool             `json:"is_anonymous"`
-	PrivyDID        string           `json:"privy_did"`
-	PrivyUser       *PrivyUser       `json:"privy_user"`
-	FarcasterUser   *FarcasterUser   `json:"farcaster_user"`
-	FID             int              `json:"fid"`
-	Settings        *UserSettings    `json:"settings"`
-	SeedPhrase      string           `json:"seed_phrase"`
+	ID            uuid.UUID      `json:"id"`
+	IsAnonymous   bool           `json:"is_anonymous"`
+	PrivyDID      string         `json:"privy_did"`
+	PrivyUser     *PrivyUser     `json:"privy_user"`
+	FarcasterUser *FarcasterUser `json:"farcaster_user"`
+	FID           int            `json:"fid"`
+	Settings      *UserSettings  `json:"settings"`
+	// Never serialized: the encrypted seed phrase and the session token stay on the server
+	SeedPhrase      string           `json:"-"`
 	WalletAddress   string           `json:"wallet_address"`
 	CreatedAt       time.Time        `json:"created_at"`
 	UpdatedAt       time.Time        `json:"updated_at"`
-	JWT             string           `json:"jwt"`
+	JWT             string           `json:"-"`
 	WritingSessions []WritingSession `json:"writing_sessions"`
 	Ankys           []Anky           `json:"ankys"`
 	Badges          []Badge          `json:"badges"`
 	Languages       []string         `json:"languages"`
 	UserMetadata    *UserMetadata    `json:"user_metadata"`
+
+	// Version the row was read at, updates fail once someone else changed it.
+	// Zero skips the check, for clients that don't send it yet.
+	Version int `json:"version"`
 }
 
 type FarcasterUser struct {
@@ -134,6 +229,168 @@ type Badge struct {
 	UnlockedAt  time.Time `json:"unlocked_at"`
 }
 
+// Directions of a newen transaction
+const (
+	NewenCredit = "credit"
+	NewenDebit  = "debit"
+)
+
+// Where newen transactions come from
+const (
+	NewenSourceWritingReward = "writing_reward"
+	NewenSourceSpend         = "spend"
+	NewenSourceAdjustment    = "adjustment"
+)
+
+// NewenTransaction is an entry of the newen ledger. Amount is always
+// positive, Direction says which way it moves the balance.
+type NewenTransaction struct {
+	ID               uuid.UUID  `json:"id" bson:"id"`
+	UserID           uuid.UUID  `json:"user_id" bson:"user_id"`
+	Direction        string     `json:"direction" bson:"direction"`
+	Amount           int        `json:"amount" bson:"amount"`
+	Source           string     `json:"source" bson:"source"`
+	Description      string     `json:"description" bson:"description"`
+	WritingSessionID *uuid.UUID `json:"writing_session_id,omitempty" bson:"writing_session_id"`
+	CreatedAt        time.Time  `json:"created_at" bson:"created_at"`
+	// Set by the client on spends so retrying one never debits twice
+	IdempotencyKey string `json:"idempotency_key,omitempty" bson:"idempotency_key"`
+}
+
+// SignedAmount is what the transaction adds to the balance.
+func (t *NewenTransaction) SignedAmount() int {
+	if t.Direction == NewenDebit {
+		return -t.Amount
+	}
+	return t.Amount
+}
+
+// Backup verification outcomes
+const (
+	BackupVerificationPassed = "passed"
+	BackupVerificationFailed = "failed"
+)
+
+// BackupVerification is the outcome of restoring the latest logical backup
+// into a scratch schema and comparing its row counts with the live tables.
+type BackupVerification struct {
+	ID            uuid.UUID           `json:"id" bson:"id"`
+	BackupFile    string              `json:"backup_file" bson:"backup_file"`
+	BackupTakenAt *time.Time          `json:"backup_taken_at" bson:"backup_taken_at"`
+	Status        string              `json:"status" bson:"status"`
+	Checks        []*BackupTableCheck `json:"checks" bson:"checks"`
+	Error         string              `json:"error,omitempty" bson:"error"`
+	StartedAt     time.Time           `json:"started_at" bson:"started_at"`
+	FinishedAt    time.Time           `json:"finished_at" bson:"finished_at"`
+}
+
+type BackupTableCheck struct {
+	Table      string `json:"table" bson:"table"`
+	BackupRows int64  `json:"backup_rows" bson:"backup_rows"`
+	LiveRows   int64  `json:"live_rows" bson:"live_rows"`
+	OK         bool   `json:"ok" bson:"ok"`
+	Detail     string `json:"detail,omitempty" bson:"detail"`
+}
+
+// SessionArchivalRun is the outcome of one pass of the session archival job.
+type SessionArchivalRun struct {
+	// Sessions that ended before this are archived
+	EndedBefore     time.Time `json:"ended_before"`
+	Archived        int       `json:"archived"`
+	Failed          int       `json:"failed"`
+	RawBytes        int64     `json:"raw_bytes"`
+	CompressedBytes int64     `json:"compressed_bytes"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+}
+
+// AnonymousUserID is the user every anonymous writing session is stored
+// under.
+var AnonymousUserID = uuid.Nil
+
+// Retention actions for the old sessions of the anonymous user
+const (
+	AnonymousRetentionDelete  = "delete"
+	AnonymousRetentionArchive = "archive"
+)
+
+// AnonymousPruneReport is what one prune of the anonymous user's old
+// sessions removed, or would remove on a dry run.
+type AnonymousPruneReport struct {
+	// Sessions that started before this are pruned
+	StartedBefore time.Time  `json:"started_before"`
+	Action        string     `json:"action"`
+	DryRun        bool       `json:"dry_run"`
+	Sessions      int        `json:"sessions"`
+	Ankys         int        `json:"ankys"`
+	OldestSession *time.Time `json:"oldest_session,omitempty"`
+	// Blob store keys of the bundles archived sessions were written to
+	ArchiveKeys []string  `json:"archive_keys,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+type SessionArchiveStats struct {
+	ArchivedSessions int64 `json:"archived_sessions"`
+	// Ended sessions old enough to be archived that still have their writing
+	PendingSessions int64      `json:"pending_sessions"`
+	LastArchivedAt  *time.Time `json:"last_archived_at"`
+}
+
+// Statuses of a user export
+const (
+	UserExportPending = "pending"
+	UserExportReady   = "ready"
+	UserExportFailed  = "failed"
+)
+
+// UserExport is a zip of everything a user wrote, built in the background.
+// The download token is the link to it once it is ready.
+type UserExport struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        uuid.UUID  `json:"user_id"`
+	Status        string     `json:"status"`
+	BlobKey       string     `json:"-"`
+	DownloadToken string     `json:"-"`
+	SizeBytes     int64      `json:"size_bytes"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// UserDeletion is an account its owner asked to delete. It is purged once
+// PurgeAfter passes, unless the owner cancels first.
+type UserDeletion struct {
+	UserID      uuid.UUID `json:"user_id"`
+	RequestedAt time.Time `json:"requested_at"`
+	PurgeAfter  time.Time `json:"purge_after"`
+	UnpinIPFS   bool      `json:"unpin_ipfs"`
+}
+
+// UserFootprint is what a user left outside the database: the blobs, local
+// files and IPFS content that have to be scrubbed when they are purged.
+type UserFootprint struct {
+	ArchiveKeys []string
+	ExportKeys  []string
+	SessionIDs  []uuid.UUID
+	FIDs        []int
+	IPFSHashes  []string
+}
+
+// IdempotencyRecord is a request made with an idempotency key and, once it
+// completed, the response that is replayed to retries.
+type IdempotencyRecord struct {
+	Route       string     `json:"route"`
+	Key         string     `json:"key"`
+	RequestHash string     `json:"request_hash"`
+	StatusCode  int        `json:"status_code"`
+	ContentType string     `json:"content_type"`
+	Response    []byte     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
 type UserSettings struct {
 	Language       string         `json:"language"`
 	AnkyOnProfile  *AnkyOnProfile `json:"anky_on_profile"`
@@ -141,6 +398,19 @@ type UserSettings struct {
 	DisplayName    string         `json:"display_name"`
 	Bio            string         `json:"bio"`
 	Username       string         `json:"username"`
+	// License new Ankys are published under when the submission doesn't choose one
+	DefaultLicense string `json:"default_license,omitempty"`
+	// Format of Anky's reflections and prompts when the request doesn't choose one
+	ResponseFormat string `json:"response_format,omitempty"`
+	// Topics the writer wants their prompts to explore or stay away from
+	PromptTopics *PromptTopics `json:"prompt_topics,omitempty"`
+	// Keeps the writer off the public leaderboards
+	HideFromLeaderboard bool `json:"hide_from_leaderboard,omitempty"`
+}
+
+type PromptTopics struct {
+	Explore []string `json:"explore"`
+	Avoid   []string `json:"avoid"`
 }
 
 type PrivyUser struct {
@@ -183,6 +453,28 @@ type WritingSession struct {
 	TimeSpent *int `json:"time_spent" bson:"time_spent"`
 	IsAnky    bool `json:"is_anky" bson:"is_anky"`
 
+	// Keystroke cadence, only known for sessions submitted with their keystrokes
+	FocusScore   *int          `json:"focus_score" bson:"focus_score"`
+	FocusMetrics *FocusMetrics `json:"focus_metrics" bson:"focus_metrics"`
+
+	// Pasted text found in the keystrokes keeps the session from earning newen and becoming an Anky
+	PasteFlagged bool          `json:"paste_flagged" bson:"paste_flagged"`
+	PasteMetrics *PasteMetrics `json:"paste_metrics" bson:"paste_metrics"`
+
+	// Keystrokes that don't look typed by hand keep the session from earning newen, becoming an Anky and counting toward a FID
+	Suspect      bool          `json:"suspect" bson:"suspect"`
+	SuspectScore *int          `json:"suspect_score" bson:"suspect_score"`
+	CheatMetrics *CheatMetrics `json:"cheat_metrics" bson:"cheat_metrics"`
+
+	// One or two sentences about the writing, only generated for sessions that became Ankys
+	Summary *string `json:"summary" bson:"summary"`
+
+	// Old sessions have their writing moved to the archive, see services.SessionArchiveService
+	Archived        bool       `json:"archived" bson:"archived"`
+	ArchivedAt      *time.Time `json:"archived_at" bson:"archived_at"`
+	ArchiveKey      *string    `json:"-" bson:"archive_key"`
+	ArchiveChecksum *string    `json:"-" bson:"archive_checksum"`
+
 	// Threading component
 	ParentAnkyID *uuid.UUID `json:"parent_anky_id" bson:"parent_anky_id"`
 	AnkyResponse *string    `json:"anky_response" bson:"anky_response"`
@@ -195,6 +487,139 @@ type WritingSession struct {
 	Anky   *Anky      `json:"anky" bson:"anky"`
 }
 
+// FocusMetrics describes the cadence of a writing session, derived from the
+// delays between keystrokes.
+type FocusMetrics struct {
+	// Score goes from 0 (fragmented) to 100 (one long breath)
+	Score              int     `json:"score"`
+	Label              string  `json:"label"`
+	LongestFlowSeconds int     `json:"longest_flow_seconds"`
+	PauseCount         int     `json:"pause_count"`
+	LongPauseCount     int     `json:"long_pause_count"`
+	MedianDelayMs      int     `json:"median_delay_ms"`
+	RhythmVariability  float64 `json:"rhythm_variability"`
+}
+
+// PasteMetrics describes the text of a writing session that wasn't typed:
+// keystrokes that inserted many characters at once and bursts of keystrokes
+// faster than anyone types.
+type PasteMetrics struct {
+	Flagged          bool `json:"flagged"`
+	TotalCharacters  int  `json:"total_characters"`
+	PastedCharacters int  `json:"pasted_characters"`
+	LargestPaste     int  `json:"largest_paste"`
+	BurstCharacters  int  `json:"burst_characters"`
+	BurstCount       int  `json:"burst_count"`
+}
+
+// Reasons a writing session is suspect of not being typed by hand
+const (
+	CheatReasonPaste              = "paste"
+	CheatReasonConstantIntervals  = "constant_intervals"
+	CheatReasonRepeatedCharacters = "repeated_characters"
+)
+
+// CheatMetrics describes how much the keystrokes of a writing session look
+// scripted: text that wasn't typed, intervals too regular for a hand and one
+// key held down or mashed. The shares are of the session's characters.
+type CheatMetrics struct {
+	// Score goes from 0 (typed by hand) to 100 (scripted)
+	Score                  int      `json:"score"`
+	Suspect                bool     `json:"suspect"`
+	Reasons                []string `json:"reasons"`
+	PastedShare            float64  `json:"pasted_share"`
+	ConstantIntervalShare  float64  `json:"constant_interval_share"`
+	RepeatedCharacterShare float64  `json:"repeated_character_share"`
+}
+
+// ReplayKeystroke is one keystroke of a session replay: the key, the delay
+// since the keystroke before and when it was pressed since the session began.
+type ReplayKeystroke struct {
+	Key     string `json:"key"`
+	DelayMs int    `json:"delay_ms"`
+	AtMs    int    `json:"at_ms"`
+}
+
+// WPMPoint is the typing speed over one window of a session replay.
+type WPMPoint struct {
+	AtSeconds int     `json:"at_seconds"`
+	WPM       float64 `json:"wpm"`
+}
+
+// PauseBucket counts the pauses of a session replay that lasted at least
+// MinMs and less than MaxMs; the last bucket has no MaxMs.
+type PauseBucket struct {
+	MinMs int `json:"min_ms"`
+	MaxMs int `json:"max_ms,omitempty"`
+	Count int `json:"count"`
+}
+
+// ReplayStats describes how a session was typed, for the app to show along
+// its playback.
+type ReplayStats struct {
+	Keystrokes        int           `json:"keystrokes"`
+	DurationMs        int           `json:"duration_ms"`
+	AverageWPM        float64       `json:"average_wpm"`
+	WPMOverTime       []WPMPoint    `json:"wpm_over_time"`
+	PauseDistribution []PauseBucket `json:"pause_distribution"`
+	LongestPauseMs    int           `json:"longest_pause_ms"`
+	LongestPauseAtMs  int           `json:"longest_pause_at_ms"`
+}
+
+// WritingSessionReplay is the keystroke timeline of a session, normalized so
+// the app can animate its playback.
+type WritingSessionReplay struct {
+	SessionID  uuid.UUID         `json:"session_id"`
+	Prompt     string            `json:"prompt"`
+	StartedAt  time.Time         `json:"started_at"`
+	Keystrokes []ReplayKeystroke `json:"keystrokes"`
+	Stats      ReplayStats       `json:"stats"`
+}
+
+// FocusPoint is one session in a user's focus analytics.
+type FocusPoint struct {
+	SessionID         uuid.UUID `json:"session_id"`
+	StartingTimestamp time.Time `json:"starting_timestamp"`
+	Score             int       `json:"score"`
+	Label             string    `json:"label"`
+}
+
+// WritingStreak counts consecutive days, in the writer's timezone, on which
+// they finished at least one writing session. The current streak survives
+// until the end of the day after the last one written.
+type WritingStreak struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Current int       `json:"current_streak"`
+	Longest int       `json:"longest_streak"`
+	// YYYY-MM-DD in Timezone, empty until the first session is finished
+	LastWrittenDate string `json:"last_written_date,omitempty"`
+	WrittenToday    bool   `json:"written_today"`
+	Timezone        string `json:"timezone"`
+}
+
+// Depth levels of the writing prompts a user receives. Writers start at the
+// surface, move on to what they avoid looking at, then to making peace with it.
+const (
+	PromptDepthSurface     = "surface"
+	PromptDepthShadow      = "shadow"
+	PromptDepthIntegration = "integration"
+)
+
+// UserJourney is where a user stands in the prompt depth progression.
+type UserJourney struct {
+	UserID uuid.UUID `json:"user_id"`
+	Level  string    `json:"level"`
+
+	SessionCount int `json:"session_count"`
+	// Sessions that got one of Anky's reflections or answered one
+	ReflectionCount int `json:"reflection_count"`
+
+	// Empty once the user reached the deepest level
+	NextLevel              string `json:"next_level,omitempty"`
+	SessionsToNextLevel    int    `json:"sessions_to_next_level"`
+	ReflectionsToNextLevel int    `json:"reflections_to_next_level"`
+}
+
 type Anky struct {
 	ID               uuid.UUID `json:"id" bson:"id"`
 	UserID           uuid.UUID `json:"user_id" bson:"user_id"`
@@ -214,6 +639,493 @@ type Anky struct {
 
 	Ticker    string `json:"ticker" bson:"ticker"`
 	TokenName string `json:"token_name" bson:"token_name"`
+
+	// Contract of the token clanker deployed, set once it replied to the cast
+	TokenAddress    string     `json:"token_address,omitempty" bson:"token_address"`
+	TokenDeployedAt *time.Time `json:"token_deployed_at,omitempty" bson:"token_deployed_at"`
+
+	// Set when IPFS pinning failed and MetadataURI holds data URI metadata instead
+	StorageDegraded bool   `json:"storage_degraded" bson:"storage_degraded"`
+	MetadataURI     string `json:"metadata_uri" bson:"metadata_uri"`
+
+	// Ordered collection for deep-dive sessions, stored in anky_images
+	Images []*AnkyImage `json:"images,omitempty" bson:"images"`
+
+	License string `json:"license" bson:"license"`
+
+	// Set by the cast reconciler. CastMissingAt is when the cast was first
+	// found missing on Farcaster, nil while it still resolves.
+	CastCheckedAt *time.Time `json:"cast_checked_at,omitempty" bson:"cast_checked_at"`
+	CastMissingAt *time.Time `json:"cast_missing_at,omitempty" bson:"cast_missing_at"`
+
+	// Time capsules are generated right away but withheld until RevealAt,
+	// see Sealed. RevealedAt is set by the reveal job.
+	RevealAt     *time.Time `json:"reveal_at,omitempty" bson:"reveal_at"`
+	RevealedAt   *time.Time `json:"revealed_at,omitempty" bson:"revealed_at"`
+	CastOnReveal bool       `json:"cast_on_reveal" bson:"cast_on_reveal"`
+
+	// The NFT the Anky NFT contract reveals with the pinned metadata, see
+	// OnchainStatusQueued and the states after it
+	OnchainStatus      string     `json:"onchain_status,omitempty" bson:"onchain_status"`
+	OnchainTxHash      string     `json:"onchain_tx_hash,omitempty" bson:"onchain_tx_hash"`
+	MetadataIPFSHash   string     `json:"metadata_ipfs_hash,omitempty" bson:"metadata_ipfs_hash"`
+	TokenID            string     `json:"token_id,omitempty" bson:"token_id"`
+	OnchainBlockNumber *int64     `json:"onchain_block_number,omitempty" bson:"onchain_block_number"`
+	OnchainConfirmedAt *time.Time `json:"onchain_confirmed_at,omitempty" bson:"onchain_confirmed_at"`
+
+	// Year in review Ankys hang off the longest session of the year next to
+	// its own Anky; every other session becomes at most one Anky
+	YearInReview bool `json:"year_in_review,omitempty" bson:"year_in_review"`
+
+	// Version the row was read at, see User.Version
+	Version int `json:"version" bson:"version"`
+}
+
+// Statuses of Ankys the safety review holds back, see AnkyReview
+const (
+	AnkyStatusInReview = "in_review"
+	AnkyStatusRejected = "rejected"
+)
+
+// HeldForReview reports whether the Anky waits for a moderator or was
+// rejected by one. Only its writer sees it then.
+func (a *Anky) HeldForReview() bool {
+	return a.Status == AnkyStatusInReview || a.Status == AnkyStatusRejected
+}
+
+// Sealed reports whether the Anky is a time capsule that wasn't revealed yet.
+func (a *Anky) Sealed() bool {
+	return a.RevealAt != nil && a.RevealedAt == nil
+}
+
+// Withheld returns the Anky as it may be shown: sealed Ankys and the ones
+// held for review come back as a copy without their reflection, image and
+// the metadata that carries them, the rest as they are.
+func (a *Anky) Withheld() *Anky {
+	if !a.Sealed() && !a.HeldForReview() {
+		return a
+	}
+	withheld := *a
+	withheld.AnkyReflection = ""
+	withheld.ImagePrompt = ""
+	withheld.FollowUpPrompt = ""
+	withheld.ImageURL = ""
+	withheld.ImageIPFSHash = ""
+	withheld.MetadataURI = ""
+	withheld.MetadataIPFSHash = ""
+	withheld.Images = nil
+	return &withheld
+}
+
+// States of an Anky's NFT. Queued Ankys wait for their image to be pinned
+// or their time capsule to open, pending ones for their transaction to be
+// confirmed.
+const (
+	OnchainStatusQueued    = "queued"
+	OnchainStatusPending   = "pending"
+	OnchainStatusConfirmed = "confirmed"
+	OnchainStatusFailed    = "failed"
+)
+
+// Stages an Anky pipeline can be made of, see services.DefaultPipelineSpec
+const (
+	PipelineStageReflection = "reflection"
+	PipelineStageToken      = "token"
+	PipelineStageImage      = "image"
+	PipelineStagePin        = "pin"
+	PipelineStageReview     = "review"
+	PipelineStageOnchain    = "onchain"
+	PipelineStageCast       = "cast"
+	PipelineStageSummary    = "summary"
+)
+
+// PipelineSpec is the ordered stages Ankys go through, with their parameters.
+type PipelineSpec struct {
+	Stages []PipelineStage `json:"stages"`
+}
+
+type PipelineStage struct {
+	Name   string            `json:"name"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Season is a period of Anky with its own pipeline. The current season is the
+// last one that started.
+type Season struct {
+	Number    int          `json:"number"`
+	Name      string       `json:"name"`
+	Pipeline  PipelineSpec `json:"pipeline"`
+	StartsAt  time.Time    `json:"starts_at"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// AnkyImage is one panel of an Anky's image collection.
+type AnkyImage struct {
+	AnkyID        uuid.UUID `json:"anky_id" bson:"anky_id"`
+	Position      int       `json:"position" bson:"position"`
+	ImagePrompt   string    `json:"image_prompt" bson:"image_prompt"`
+	ImageURL      string    `json:"image_url" bson:"image_url"`
+	ImageIPFSHash string    `json:"image_ipfs_hash" bson:"image_ipfs_hash"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Sizes an Anky's image can be exported in
+const (
+	ImageSizeThumbnail = "thumbnail"
+	ImageSizeCard      = "card"
+	ImageSizeFull      = "full"
+)
+
+var ImageSizes = []string{ImageSizeThumbnail, ImageSizeCard, ImageSizeFull}
+
+func IsValidImageSize(size string) bool {
+	return slices.Contains(ImageSizes, size)
+}
+
+// AnkyImageVariant is a resized copy of an Anky's image.
+type AnkyImageVariant struct {
+	AnkyID    uuid.UUID `json:"anky_id"`
+	Size      string    `json:"size"`
+	URL       string    `json:"url"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IPFSPin is content pinned through Pinata. ReuseCount is how many uploads
+// of the same bytes were skipped because of it.
+type IPFSPin struct {
+	ContentHash string    `json:"content_hash"`
+	IPFSHash    string    `json:"ipfs_hash"`
+	Name        string    `json:"name"`
+	SizeBytes   int64     `json:"size_bytes"`
+	ReuseCount  int       `json:"reuse_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+}
+
+// AnkyMarketSnapshot is the market data of an Anky's token at one point in time.
+type AnkyMarketSnapshot struct {
+	ID           uuid.UUID `json:"id" bson:"id"`
+	AnkyID       uuid.UUID `json:"anky_id" bson:"anky_id"`
+	TokenAddress string    `json:"token_address" bson:"token_address"`
+	ChainID      string    `json:"chain_id" bson:"chain_id"`
+	PriceUSD     float64   `json:"price_usd" bson:"price_usd"`
+	MarketCapUSD float64   `json:"market_cap_usd" bson:"market_cap_usd"`
+	LiquidityUSD float64   `json:"liquidity_usd" bson:"liquidity_usd"`
+	Volume24hUSD float64   `json:"volume_24h_usd" bson:"volume_24h_usd"`
+	Source       string    `json:"source" bson:"source"`
+	CapturedAt   time.Time `json:"captured_at" bson:"captured_at"`
+}
+
+type AnkyStatusEvent struct {
+	ID        uuid.UUID `json:"id" bson:"id"`
+	AnkyID    uuid.UUID `json:"anky_id" bson:"anky_id"`
+	Status    string    `json:"status" bson:"status"`
+	Detail    string    `json:"detail" bson:"detail"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Licenses an Anky can be published under, from most to least restrictive
+const (
+	LicenseAllRightsReserved = "all-rights-reserved"
+	LicenseCCBY              = "cc-by"
+	LicenseCC0               = "cc0"
+
+	DefaultLicense = LicenseAllRightsReserved
+)
+
+// Licenses lists every license, in order of permissiveness
+var Licenses = []string{LicenseAllRightsReserved, LicenseCCBY, LicenseCC0}
+
+func IsValidLicense(license string) bool {
+	return slices.Contains(Licenses, license)
+}
+
+// CanRelicense reports whether an Anky published under from can switch to to.
+// Creative Commons grants can't be revoked, so a license can only become
+// more permissive.
+func CanRelicense(from string, to string) bool {
+	if !IsValidLicense(to) {
+		return false
+	}
+	if !IsValidLicense(from) {
+		return true
+	}
+	return slices.Index(Licenses, to) >= slices.Index(Licenses, from)
+}
+
+// LicenseURL links to the legal text of the license, empty for all rights reserved.
+func LicenseURL(license string) string {
+	switch license {
+	case LicenseCCBY:
+		return "https://creativecommons.org/licenses/by/4.0/"
+	case LicenseCC0:
+		return "https://creativecommons.org/publicdomain/zero/1.0/"
+	}
+	return ""
+}
+
+// PreferredLicense is the license the user publishes under unless a submission says otherwise.
+func (u *User) PreferredLicense() string {
+	if u != nil && u.Settings != nil && IsValidLicense(u.Settings.DefaultLicense) {
+		return u.Settings.DefaultLicense
+	}
+	return DefaultLicense
+}
+
+// Periods and metrics writers can be ranked by on the leaderboard. Day and
+// week are the last 24 hours and the last 7 days.
+const (
+	LeaderboardPeriodDay  = "day"
+	LeaderboardPeriodWeek = "week"
+	LeaderboardPeriodAll  = "all"
+
+	LeaderboardMetricWords    = "words"
+	LeaderboardMetricNewen    = "newen"
+	LeaderboardMetricSessions = "sessions"
+)
+
+var (
+	LeaderboardPeriods = []string{LeaderboardPeriodDay, LeaderboardPeriodWeek, LeaderboardPeriodAll}
+	LeaderboardMetrics = []string{LeaderboardMetricWords, LeaderboardMetricNewen, LeaderboardMetricSessions}
+)
+
+// LeaderboardEntry is a writer's place on a leaderboard, with only what their
+// public profile shows.
+type LeaderboardEntry struct {
+	Rank           int       `json:"rank"`
+	UserID         uuid.UUID `json:"user_id"`
+	Username       string    `json:"username,omitempty"`
+	DisplayName    string    `json:"display_name,omitempty"`
+	ProfilePicture string    `json:"profile_picture,omitempty"`
+	Value          int64     `json:"value"`
+}
+
+// Formats Anky's AI generated text can be returned in. Plain and SSML are
+// meant for screen readers and text to speech.
+const (
+	ResponseFormatMarkdown = "markdown"
+	ResponseFormatPlain    = "plain"
+	ResponseFormatSSML     = "ssml"
+
+	DefaultResponseFormat = ResponseFormatMarkdown
+)
+
+var ResponseFormats = []string{ResponseFormatMarkdown, ResponseFormatPlain, ResponseFormatSSML}
+
+func IsValidResponseFormat(format string) bool {
+	return slices.Contains(ResponseFormats, format)
+}
+
+// PreferredResponseFormat is the format the user reads Anky's responses in unless a request says otherwise.
+func (u *User) PreferredResponseFormat() string {
+	if u != nil && u.Settings != nil && IsValidResponseFormat(u.Settings.ResponseFormat) {
+		return u.Settings.ResponseFormat
+	}
+	return DefaultResponseFormat
+}
+
+// Prompt topics are short phrases like "grief" or "my father" that end up in
+// the instructions of the language model, so they are kept to plain words.
+const (
+	MaxPromptTopics      = 10
+	MaxPromptTopicLength = 40
+)
+
+var promptTopicPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} '-]*$`)
+
+// NormalizePromptTopics trims, lowercases and deduplicates both lists, and
+// rejects topics that are too long, hold anything but words, or appear in both.
+func NormalizePromptTopics(topics *PromptTopics) (*PromptTopics, error) {
+	if topics == nil {
+		return nil, nil
+	}
+	normalize := func(list []string, kind string) ([]string, error) {
+		normalized := []string{}
+		for _, topic := range list {
+			topic = strings.ToLower(strings.Join(strings.Fields(topic), " "))
+			if topic == "" || slices.Contains(normalized, topic) {
+				continue
+			}
+			if len([]rune(topic)) > MaxPromptTopicLength {
+				return nil, fmt.Errorf("topic %q is longer than %d characters", topic, MaxPromptTopicLength)
+			}
+			if !promptTopicPattern.MatchString(topic) {
+				return nil, fmt.Errorf("topic %q may only hold letters, numbers, spaces, hyphens and apostrophes", topic)
+			}
+			normalized = append(normalized, topic)
+		}
+		if len(normalized) > MaxPromptTopics {
+			return nil, fmt.Errorf("at most %d topics to %s are allowed", MaxPromptTopics, kind)
+		}
+		return normalized, nil
+	}
+
+	explore, err := normalize(topics.Explore, "explore")
+	if err != nil {
+		return nil, err
+	}
+	avoid, err := normalize(topics.Avoid, "avoid")
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range explore {
+		if slices.Contains(avoid, topic) {
+			return nil, fmt.Errorf("topic %q can't be both explored and avoided", topic)
+		}
+	}
+	return &PromptTopics{Explore: explore, Avoid: avoid}, nil
+}
+
+// PreferredPromptTopics are the topics the user's prompts should explore and avoid.
+func (u *User) PreferredPromptTopics() *PromptTopics {
+	if u == nil || u.Settings == nil || u.Settings.PromptTopics == nil {
+		return &PromptTopics{}
+	}
+	return u.Settings.PromptTopics
+}
+
+// Frames writers are identified by FID. The prompt stored for DefaultPromptFID,
+// which Farcaster never assigns, is served to FIDs without a prompt of their own.
+const (
+	DefaultPromptFID     = 0
+	DefaultWritingPrompt = "what are you grateful for today?"
+
+	PromptSourceDefault = "default"
+	PromptSourceLLM     = "llm"
+	PromptSourceAdmin   = "admin"
+	PromptSourceImport  = "import"
+	// Served to everyone while a campaign with a prompt runs
+	PromptSourceCampaign = "campaign"
+)
+
+// WritingPrompt is the prompt a FID is shown the next time it sets up a writing session.
+type WritingPrompt struct {
+	FID       int       `json:"fid" bson:"fid"`
+	Prompt    string    `json:"prompt" bson:"prompt"`
+	Source    string    `json:"source" bson:"source"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// WritingPromptHistoryEntry is a prompt that was set for a FID at some point.
+type WritingPromptHistoryEntry struct {
+	ID        uuid.UUID `json:"id" bson:"id"`
+	FID       int       `json:"fid" bson:"fid"`
+	Prompt    string    `json:"prompt" bson:"prompt"`
+	Source    string    `json:"source" bson:"source"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// AnkyLicenseChange is one entry of the audit trail of an Anky's license.
+type AnkyLicenseChange struct {
+	ID              uuid.UUID `json:"id" bson:"id"`
+	AnkyID          uuid.UUID `json:"anky_id" bson:"anky_id"`
+	UserID          uuid.UUID `json:"-" bson:"user_id"`
+	PreviousLicense string    `json:"previous_license" bson:"previous_license"`
+	NewLicense      string    `json:"new_license" bson:"new_license"`
+	CreatedAt       time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Where a version of an Anky's reflection came from
+const (
+	AnkyReflectionSourcePipeline    = "pipeline"
+	AnkyReflectionSourceRegenerated = "regenerated"
+)
+
+// AnkyReflection is one version of an Anky's reflection. The canonical
+// version is the Anky's AnkyReflection and the story of its metadata.
+type AnkyReflection struct {
+	ID         uuid.UUID `json:"id" bson:"id"`
+	AnkyID     uuid.UUID `json:"anky_id" bson:"anky_id"`
+	Version    int       `json:"version" bson:"version"`
+	Reflection string    `json:"reflection" bson:"reflection"`
+	Source     string    `json:"source" bson:"source"`
+	Canonical  bool      `json:"canonical" bson:"canonical"`
+	// The spend that paid for a regenerated version
+	NewenTransactionID *uuid.UUID `json:"newen_transaction_id,omitempty" bson:"newen_transaction_id"`
+	CreatedAt          time.Time  `json:"created_at" bson:"created_at"`
+}
+
+// Where a version of an Anky's image came from, and where it stands
+const (
+	AnkyImageSourcePipeline    = "pipeline"
+	AnkyImageSourceRegenerated = "regenerated"
+
+	AnkyImageGenerating = "generating"
+	// The candidates are ready for the owner to keep one
+	AnkyImageReady  = "ready"
+	AnkyImageKept   = "kept"
+	AnkyImageFailed = "failed"
+)
+
+// AnkyImageVersion is one image an Anky had or could have. Regenerated
+// versions hold the image backend's candidates until the owner keeps one,
+// which is then uploaded and pinned. The current version is the Anky's image.
+type AnkyImageVersion struct {
+	ID              uuid.UUID  `json:"id"`
+	AnkyID          uuid.UUID  `json:"anky_id"`
+	Version         int        `json:"version"`
+	Source          string     `json:"source"`
+	Status          string     `json:"status"`
+	ImagePrompt     string     `json:"image_prompt,omitempty"`
+	CandidateURLs   []string   `json:"candidate_urls"`
+	ChosenCandidate *int       `json:"chosen_candidate,omitempty"`
+	ImageURL        string     `json:"image_url,omitempty"`
+	ImageIPFSHash   string     `json:"image_ipfs_hash,omitempty"`
+	Current         bool       `json:"current"`
+	Error           string     `json:"error,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+}
+
+// FID request decisions and review statuses
+const (
+	FIDDecisionAccept = "accept"
+	FIDDecisionReview = "review"
+	FIDDecisionReject = "reject"
+
+	FIDRequestAccepted = "accepted"
+	FIDRequestPending  = "pending"
+	FIDRequestApproved = "approved"
+	FIDRequestRejected = "rejected"
+)
+
+type FIDRequest struct {
+	ID                uuid.UUID          `json:"id" bson:"id"`
+	UserID            uuid.UUID          `json:"user_id" bson:"user_id"`
+	DeviceFingerprint string             `json:"device_fingerprint" bson:"device_fingerprint"`
+	IPAddress         string             `json:"ip_address" bson:"ip_address"`
+	Score             float64            `json:"score" bson:"score"`
+	Decision          string             `json:"decision" bson:"decision"`
+	Signals           map[string]float64 `json:"signals" bson:"signals"`
+	Status            string             `json:"status" bson:"status"`
+	ReviewerNote      string             `json:"reviewer_note" bson:"reviewer_note"`
+	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
+	ReviewedAt        *time.Time         `json:"reviewed_at" bson:"reviewed_at"`
+}
+
+type YearInReviewStats struct {
+	TotalSessions         int     `json:"total_sessions"`
+	TotalAnkys            int     `json:"total_ankys"`
+	TotalWords            int     `json:"total_words"`
+	TotalSecondsWriting   int     `json:"total_seconds_writing"`
+	LongestSessionSeconds int     `json:"longest_session_seconds"`
+	ActiveDays            int     `json:"active_days"`
+	LongestStreakDays     int     `json:"longest_streak_days"`
+	MostActiveMonth       string  `json:"most_active_month"`
+	NewenEarned           float64 `json:"newen_earned"`
+}
+
+type YearInReview struct {
+	UserID      uuid.UUID         `json:"user_id" bson:"user_id"`
+	Year        int               `json:"year" bson:"year"`
+	Stats       YearInReviewStats `json:"stats" bson:"stats"`
+	Narrative   string            `json:"narrative" bson:"narrative"`
+	AnkyID      *uuid.UUID        `json:"anky_id" bson:"anky_id"`
+	GeneratedAt time.Time         `json:"generated_at" bson:"generated_at"`
 }
 
 type AnkyOnProfile struct {
@@ -236,8 +1148,34 @@ type AnkyOnboardingResponse struct {
 	AiModelUsed               string    `json:"ai_model_used" bson:"ai_model_used"`
 }
 
+// AnkySessionSeconds is how long a session lasts to become an Anky
+const AnkySessionSeconds = 480
+
+// IsValidAnky reports whether the session lasted long enough to become an
+// Anky. Unverified sessions never do.
 func (ws *WritingSession) IsValidAnky() bool {
-	return ws.TimeSpent != nil && *ws.TimeSpent >= 480 // 8 minutes in seconds
+	return ws.Verified() && ws.TimeSpent != nil && *ws.TimeSpent >= AnkySessionSeconds
+}
+
+// Verified reports whether the session came with its keystroke log, which
+// was checked for pasted text and scripted typing. Unverified sessions never
+// earn newen, become Ankys or count toward a FID.
+func (ws *WritingSession) Verified() bool {
+	return ws.CheatMetrics != nil
+}
+
+// WritingSessionClock is the server's account of a session's 8 minute
+// timer. Clients drift and stop counting in the background, the server's
+// clock is the one sessions are judged by.
+type WritingSessionClock struct {
+	SessionID        uuid.UUID `json:"session_id"`
+	StartedAt        time.Time `json:"started_at"`
+	ServerTime       time.Time `json:"server_time"`
+	ElapsedSeconds   int       `json:"elapsed_seconds"`
+	RemainingSeconds int       `json:"remaining_seconds"`
+	// When the session becomes an Anky
+	AnkyAt  time.Time  `json:"anky_at"`
+	EndedAt *time.Time `json:"ended_at,omitempty"`
 }
 
 func (ws *WritingSession) SetAnkyStatus() {
@@ -249,6 +1187,24 @@ func (ws *WritingSession) SetAnkyStatus() {
 	}
 }
 
+// UserMerge records an anonymous account whose history was moved to the
+// registered account the writer logged in with.
+type UserMerge struct {
+	AnonymousUserID   uuid.UUID `json:"anonymous_user_id"`
+	MergedInto        uuid.UUID `json:"merged_into"`
+	WritingSessions   int       `json:"writing_sessions"`
+	Ankys             int       `json:"ankys"`
+	Badges            int       `json:"badges"`
+	NewenTransactions int       `json:"newen_transactions"`
+	MergedAt          time.Time `json:"merged_at"`
+}
+
+// IsRegistered reports whether the user signed up in the app, as opposed to
+// the anonymous accounts created for frames FIDs and first app launches.
+func (u *User) IsRegistered() bool {
+	return !u.IsAnonymous || u.PrivyDID != ""
+}
+
 func NewUser(id uuid.UUID, isAnonymous bool, createdAt time.Time, userMetadata *UserMetadata) *User {
 	log.Printf("Creating new user with ID: %s", id)
 
@@ -304,6 +1260,7 @@ func NewAnky(writingSessionID uuid.UUID, chosenPrompt string, userID uuid.UUID)
 		WritingSessionID: writingSessionID,
 		ChosenPrompt:     chosenPrompt,
 		CreatedAt:        time.Now().UTC(),
+		License:          DefaultLicense,
 	}
 }
 
@@ -373,6 +1330,8 @@ type Message struct {
 
 type ChatRequest struct {
 	Messages []Message `json:"messages"`
+	// Overrides the model of the configured LLM provider for this request
+	Model string `json:"model,omitempty"`
 }
 
 type LLMRequest struct {
@@ -505,7 +1464,11 @@ func EncryptString(plaintext string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return EncryptStringWithKey(plaintext, key)
+}
 
+// EncryptStringWithKey encrypts with AES-GCM under the given 32 byte key.
+func EncryptStringWithKey(plaintext string, key []byte) (string, error) {
 	// Create cipher block
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -537,7 +1500,11 @@ func DecryptString(encryptedString string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return DecryptStringWithKey(encryptedString, key)
+}
 
+// DecryptStringWithKey decrypts a string encrypted by EncryptStringWithKey under the same key.
+func DecryptStringWithKey(encryptedString string, key []byte) (string, error) {
 	// Decode from base64
 	ciphertext, err := base64.StdEncoding.DecodeString(encryptedString)
 	if err != nil {
@@ -576,7 +1543,11 @@ func getEncryptionKey() ([]byte, error) {
 	if encodedKey == "" {
 		return nil, fmt.Errorf("ENCRYPTION_KEY environment variable not set")
 	}
+	return ParseEncryptionKey(encodedKey)
+}
 
+// ParseEncryptionKey decodes a base64 encoded 32 byte key, the format of ENCRYPTION_KEY.
+func ParseEncryptionKey(encodedKey string) ([]byte, error) {
 	key, err := base64.StdEncoding.DecodeString(encodedKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode encryption key: %v", err)
@@ -588,3 +1559,291 @@ func getEncryptionKey() ([]byte, error) {
 
 	return key, nil
 }
+
+// Incident severities and statuses, as shown on the status page
+const (
+	IncidentMinor    = "minor"
+	IncidentMajor    = "major"
+	IncidentCritical = "critical"
+
+	IncidentInvestigating = "investigating"
+	IncidentIdentified    = "identified"
+	IncidentMonitoring    = "monitoring"
+	IncidentResolved      = "resolved"
+)
+
+// Incident is posted by admins to the status page while something is broken.
+type Incident struct {
+	ID         uuid.UUID  `json:"id"`
+	Title      string     `json:"title"`
+	Message    string     `json:"message"`
+	Severity   string     `json:"severity"`
+	Status     string     `json:"status"`
+	Components []string   `json:"components"`
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// QueueDepths counts the work waiting in each pipeline queue.
+type QueueDepths struct {
+	// Ankys whose pipeline hasn't reached a final status
+	AnkysProcessing int `json:"ankys_processing"`
+	// Ankys that are ready and wait for casting to Farcaster to work again
+	AnkysAwaitingCast int `json:"ankys_awaiting_cast"`
+	FIDRequestsReview int `json:"fid_requests_review"`
+}
+
+// BuddyPair is one side of a buddy pairing, as seen by UserID.
+type BuddyPair struct {
+	PairID   uuid.UUID  `json:"pair_id"`
+	UserID   uuid.UUID  `json:"user_id"`
+	BuddyID  uuid.UUID  `json:"buddy_id"`
+	PairedAt time.Time  `json:"paired_at"`
+	EndedAt  *time.Time `json:"ended_at,omitempty"`
+}
+
+// WritingBuddy is what a writer sees of their buddy: whether they are keeping
+// up, never what they wrote.
+type WritingBuddy struct {
+	PairID   uuid.UUID `json:"pair_id"`
+	Name     string    `json:"name"`
+	PairedAt time.Time `json:"paired_at"`
+	// In the buddy's own timezone
+	WrittenToday    bool   `json:"written_today"`
+	CurrentStreak   int    `json:"current_streak"`
+	LastWrittenDate string `json:"last_written_date,omitempty"`
+}
+
+// Why a nudge was sent: the buddy tapped the nudge button, or the writer
+// missed a day
+const (
+	BuddyNudgeFromBuddy = "from_buddy"
+	BuddyNudgeMissedDay = "missed_day"
+)
+
+// BuddyNudge reminds a writer that their buddy is counting on them.
+type BuddyNudge struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	FromUserID *uuid.UUID `json:"-"`
+	Kind       string     `json:"kind"`
+	// YYYY-MM-DD the writer missed, in their timezone
+	MissedDate string     `json:"missed_date,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReadAt     *time.Time `json:"read_at,omitempty"`
+}
+
+// ReminderSchedule is when a writer gets their daily writing reminder: a
+// little before the hour they usually start writing, learned from their
+// recent sessions, or the default hour until there are enough of them.
+type ReminderSchedule struct {
+	Enabled bool `json:"enabled"`
+	// IANA name the hours are in, UTC when the writer's is unknown
+	Timezone string `json:"timezone"`
+	// Local hour, 0 to 23, the writer usually starts writing
+	TypicalHour int `json:"typical_hour"`
+	// Local time of day the reminder is sent, HH:MM
+	ReminderTime string `json:"reminder_time"`
+	// False while the writer has too few recent sessions and the default is used
+	Learned            bool `json:"learned"`
+	SessionsConsidered int  `json:"sessions_considered"`
+	// Share of the recent sessions, weighted by recency, started within an
+	// hour of TypicalHour
+	Confidence float64 `json:"confidence"`
+	// Unset when reminders are off
+	NextReminderAt *time.Time `json:"next_reminder_at,omitempty"`
+}
+
+// WritingReminder is a daily writing reminder the reminder job sent.
+type WritingReminder struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"-"`
+	// YYYY-MM-DD in the writer's timezone
+	ReminderDate string     `json:"reminder_date"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	Learned      bool       `json:"learned"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReadAt       *time.Time `json:"read_at,omitempty"`
+}
+
+// Pipeline statuses webhooks can be called for
+const (
+	WebhookEventReflectionCompleted = "reflection_completed"
+	WebhookEventImageGenerated      = "image_generated"
+	WebhookEventCompleted           = "completed"
+)
+
+// Webhook is a URL called with a signed payload when Ankys reach one of its
+// events.
+type Webhook struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	URL    string    `json:"url"`
+	// Only shown when the webhook is registered
+	Secret              string     `json:"secret,omitempty"`
+	Events              []string   `json:"events"`
+	AllAnkys            bool       `json:"all_ankys"`
+	CreatedAt           time.Time  `json:"created_at"`
+	LastDeliveryAt      *time.Time `json:"last_delivery_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+}
+
+// WebhookPayload is the body of a webhook callback.
+type WebhookPayload struct {
+	// Unique per delivery, retries of a delivery keep it
+	ID         uuid.UUID  `json:"id"`
+	Event      string     `json:"event"`
+	SessionID  string     `json:"session_id"`
+	AnkyID     *uuid.UUID `json:"anky_id,omitempty"`
+	ImageURL   string     `json:"image_url,omitempty"`
+	OccurredAt time.Time  `json:"occurred_at"`
+}
+
+// Kinds of announcements, which clients may show differently
+const (
+	AnnouncementSeason   = "season"
+	AnnouncementFeature  = "feature"
+	AnnouncementDowntime = "downtime"
+)
+
+// Announcement is an in-app message of the what's-new feed.
+type Announcement struct {
+	ID    uuid.UUID `json:"id"`
+	Kind  string    `json:"kind"`
+	Title string    `json:"title"`
+	Body  string    `json:"body"`
+	URL   string    `json:"url,omitempty"`
+	// Hidden until then and after ExpiresAt
+	PublishedAt time.Time  `json:"published_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	// Whether the user asking has read it, always false in admin listings
+	Read bool `json:"read"`
+}
+
+// Campaign is a time-boxed event, e.g. a double newen weekend or a special
+// prompt everyone writes to. While it runs, rewards are multiplied by
+// NewenMultiplier and, when Prompt is set, it replaces everyone's prompt.
+type Campaign struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	// What clients show on the banner, and where it links to if anywhere
+	BannerText string `json:"banner_text"`
+	BannerURL  string `json:"banner_url,omitempty"`
+	// 1 leaves rewards as they are
+	NewenMultiplier float64   `json:"newen_multiplier"`
+	Prompt          string    `json:"prompt,omitempty"`
+	StartsAt        time.Time `json:"starts_at"`
+	EndsAt          time.Time `json:"ends_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Why an Anky was held for review
+const (
+	ReviewReasonCrisis     = "crisis"
+	ReviewReasonModeration = "moderation"
+	ReviewReasonNSFWImage  = "nsfw_image"
+)
+
+// Verdicts of a review, pending until a moderator gives one
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// AnkyReview is an Anky the safety review held before it was made public.
+// Approved Ankys go on through their pipeline, rejected ones stop there.
+type AnkyReview struct {
+	ID      uuid.UUID `json:"id"`
+	AnkyID  uuid.UUID `json:"anky_id"`
+	Reasons []string  `json:"reasons"`
+	// What was flagged, e.g. the moderation categories
+	Detail     string     `json:"detail"`
+	Status     string     `json:"status"`
+	ReviewerID *uuid.UUID `json:"reviewer_id,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// AnkyReviewQueue is a page of reviews with how the pending ones keep up
+// with the review SLA.
+type AnkyReviewQueue struct {
+	Pending int `json:"pending"`
+	// How long the oldest pending review has waited, 0 without any
+	OldestPendingSeconds int `json:"oldest_pending_seconds"`
+	SLASeconds           int `json:"sla_seconds"`
+	// Pending reviews that waited longer than the SLA
+	BreachingSLA int           `json:"breaching_sla"`
+	Reviews      []*AnkyReview `json:"reviews"`
+}
+
+// Kinds of casts addressed to the Anky account
+const (
+	FarcasterMentionMention = "mention"
+	FarcasterMentionReply   = "reply"
+)
+
+// FarcasterMention is a cast mentioning the Anky account or replying to one
+// of its casts.
+type FarcasterMention struct {
+	CastHash       string `json:"cast_hash"`
+	AuthorFID      int    `json:"author_fid"`
+	AuthorUsername string `json:"author_username"`
+	Text           string `json:"text"`
+	Kind           string `json:"kind"`
+	// Empty for casts that start a thread
+	ParentHash string     `json:"parent_hash,omitempty"`
+	ThreadHash string     `json:"thread_hash"`
+	ReceivedAt time.Time  `json:"received_at"`
+	ReplyHash  string     `json:"reply_hash,omitempty"`
+	RepliedAt  *time.Time `json:"replied_at,omitempty"`
+	ReplyError string     `json:"reply_error,omitempty"`
+}
+
+// ResearchDataset is a month of anonymized writing statistics, contributed
+// by the writers who opted in, as published on IPFS.
+type ResearchDataset struct {
+	ID uuid.UUID `json:"id"`
+	// First day of the month and first day of the next one
+	PeriodStart  time.Time `json:"period_start"`
+	PeriodEnd    time.Time `json:"period_end"`
+	IPFSHash     string    `json:"ipfs_hash"`
+	URL          string    `json:"url"`
+	Contributors int       `json:"contributors"`
+	Sessions     int       `json:"sessions"`
+	PublishedAt  time.Time `json:"published_at"`
+}
+
+// ResearchDatasetStats is the content of a research dataset: totals and
+// distributions of the contributed sessions, without any identifier or text.
+// Hours and weekdays are in UTC, weekdays start on Monday.
+type ResearchDatasetStats struct {
+	Version              int             `json:"version"`
+	PeriodStart          string          `json:"period_start"`
+	PeriodEnd            string          `json:"period_end"`
+	Contributors         int             `json:"contributors"`
+	Sessions             int             `json:"sessions"`
+	Ankys                int             `json:"ankys"`
+	TotalWords           int64           `json:"total_words"`
+	TotalSecondsWriting  int64           `json:"total_seconds_writing"`
+	MedianSessionSeconds float64         `json:"median_session_seconds"`
+	AverageFocusScore    *float64        `json:"average_focus_score"`
+	SessionsByDuration   []DatasetBucket `json:"sessions_by_duration"`
+	SessionsByWords      []DatasetBucket `json:"sessions_by_words"`
+	SessionsByHour       [24]int         `json:"sessions_by_hour"`
+	SessionsByWeekday    [7]int          `json:"sessions_by_weekday"`
+}
+
+// DatasetBucket counts the sessions in one range of a distribution.
+type DatasetBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
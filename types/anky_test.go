@@ -0,0 +1,37 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithheldHidesSealedAndHeldAnkys(t *testing.T) {
+	revealAt := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		anky     Anky
+		withheld bool
+	}{
+		{"completed", Anky{Status: "completed"}, false},
+		{"sealed", Anky{Status: "completed", RevealAt: &revealAt}, true},
+		{"in review", Anky{Status: AnkyStatusInReview}, true},
+		{"rejected", Anky{Status: AnkyStatusRejected}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			anky := tc.anky
+			anky.AnkyReflection = "the reflection"
+			anky.ImageURL = "https://example.com/anky.png"
+			anky.MetadataIPFSHash = "QmMetadata"
+
+			shown := anky.Withheld()
+			hidden := shown.AnkyReflection == "" && shown.ImageURL == "" && shown.MetadataIPFSHash == ""
+			if hidden != tc.withheld {
+				t.Errorf("withheld = %v, want %v", hidden, tc.withheld)
+			}
+			if anky.ImageURL == "" {
+				t.Error("Withheld changed the Anky instead of a copy")
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Handlers never write a User as is. PrivateUser is what the user themselves
+// (and admins) get back, PublicUser what anyone else may see. Neither carries
+// the seed phrase, the JWT or the Farcaster signer; fields added to User stay
+// out of the responses until they are mapped here.
+
+// FarcasterProfile is the part of a FarcasterUser that is safe to share.
+type FarcasterProfile struct {
+	FID            int    `json:"fid"`
+	Username       string `json:"username"`
+	DisplayName    string `json:"display_name"`
+	ProfilePicture string `json:"pfp_url"`
+	CustodyAddress string `json:"custody_address"`
+	Bio            string `json:"bio"`
+	FollowerCount  int    `json:"follower_count"`
+	FollowingCount int    `json:"following_count"`
+}
+
+type PublicUser struct {
+	ID             uuid.UUID         `json:"id"`
+	FID            int               `json:"fid"`
+	Username       string            `json:"username"`
+	DisplayName    string            `json:"display_name"`
+	Bio            string            `json:"bio"`
+	ProfilePicture string            `json:"profile_picture"`
+	AnkyOnProfile  *AnkyOnProfile    `json:"anky_on_profile"`
+	FarcasterUser  *FarcasterProfile `json:"farcaster_user"`
+	Badges         []Badge           `json:"badges"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+type PrivateUser struct {
+	ID              uuid.UUID         `json:"id"`
+	IsAnonymous     bool              `json:"is_anonymous"`
+	PrivyDID        string            `json:"privy_did"`
+	PrivyUser       *PrivyUser        `json:"privy_user"`
+	FarcasterUser   *FarcasterProfile `json:"farcaster_user"`
+	FID             int               `json:"fid"`
+	Settings        *UserSettings     `json:"settings"`
+	WalletAddress   string            `json:"wallet_address"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	WritingSessions []WritingSession  `json:"writing_sessions"`
+	Ankys           []Anky            `json:"ankys"`
+	Badges          []Badge           `json:"badges"`
+	Languages       []string          `json:"languages"`
+	UserMetadata    *UserMetadata     `json:"user_metadata"`
+	Version         int               `json:"version"`
+}
+
+func NewFarcasterProfile(farcasterUser *FarcasterUser) *FarcasterProfile {
+	if farcasterUser == nil {
+		return nil
+	}
+	return &FarcasterProfile{
+		FID:            farcasterUser.FID,
+		Username:       farcasterUser.Username,
+		DisplayName:    farcasterUser.DisplayName,
+		ProfilePicture: farcasterUser.ProfilePicture,
+		CustodyAddress: farcasterUser.CustodyAddress,
+		Bio:            farcasterUser.Bio,
+		FollowerCount:  farcasterUser.FollowerCount,
+		FollowingCount: farcasterUser.FollowingCount,
+	}
+}
+
+func NewPublicUser(user *User) *PublicUser {
+	public := &PublicUser{
+		ID:            user.ID,
+		FID:           user.FID,
+		FarcasterUser: NewFarcasterProfile(user.FarcasterUser),
+		Badges:        user.Badges,
+		CreatedAt:     user.CreatedAt,
+	}
+	if user.Settings != nil {
+		public.Username = user.Settings.Username
+		public.DisplayName = user.Settings.DisplayName
+		public.Bio = user.Settings.Bio
+		public.ProfilePicture = user.Settings.ProfilePicture
+		public.AnkyOnProfile = user.Settings.AnkyOnProfile
+	}
+	return public
+}
+
+func NewPrivateUser(user *User) *PrivateUser {
+	return &PrivateUser{
+		ID:              user.ID,
+		IsAnonymous:     user.IsAnonymous,
+		PrivyDID:        user.PrivyDID,
+		PrivyUser:       user.PrivyUser,
+		FarcasterUser:   NewFarcasterProfile(user.FarcasterUser),
+		FID:             user.FID,
+		Settings:        user.Settings,
+		WalletAddress:   user.WalletAddress,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		WritingSessions: user.WritingSessions,
+		Ankys:           user.Ankys,
+		Badges:          user.Badges,
+		Languages:       user.Languages,
+		UserMetadata:    user.UserMetadata,
+		Version:         user.Version,
+	}
+}
+
+func NewPrivateUsers(users []*User) []*PrivateUser {
+	private := make([]*PrivateUser, 0, len(users))
+	for _, user := range users {
+		private = append(private, NewPrivateUser(user))
+	}
+	return private
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"math"
+	"unicode/utf8"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// Fewer keystrokes than this are too few to judge
+	cheatMinKeystrokes = 50
+	// Delays within this of the one before are the same interval, hands
+	// are never that regular for long
+	constantIntervalJitterMs = 3
+	// Keystrokes in a row at the same interval before they count
+	constantIntervalMinRun = 20
+	// The same character this many times in a row is a key held or mashed
+	repeatedCharacterMinRun = 8
+
+	// Each signal alone makes a session suspect once it reaches its share
+	cheatPastedShare            = 0.1
+	cheatConstantIntervalShare  = 0.2
+	cheatRepeatedCharacterShare = 0.2
+	// Weaker signals add up, sessions are suspect from this score
+	cheatSuspectScore = 80
+)
+
+// AnalyzeCadence scores how scripted the keystrokes of a session look, from
+// the text that wasn't typed (see DetectPaste), runs of keystrokes at the
+// same interval and runs of the same character. Each signal is weighed
+// against the share at which it alone makes the session suspect, and the
+// score is the chance any of them is right.
+func AnalyzeCadence(keyStrokes []KeyStroke, paste types.PasteMetrics) types.CheatMetrics {
+	metrics := types.CheatMetrics{Reasons: []string{}}
+	if len(keyStrokes) < cheatMinKeystrokes || paste.TotalCharacters == 0 {
+		return metrics
+	}
+
+	metrics.PastedShare = float64(paste.PastedCharacters+paste.BurstCharacters) / float64(paste.TotalCharacters)
+	metrics.ConstantIntervalShare = constantIntervalShare(keyStrokes)
+	metrics.RepeatedCharacterShare = float64(repeatedCharacters(keyStrokes)) / float64(paste.TotalCharacters)
+
+	signals := []struct {
+		reason string
+		share  float64
+		limit  float64
+	}{
+		{types.CheatReasonPaste, metrics.PastedShare, cheatPastedShare},
+		{types.CheatReasonConstantIntervals, metrics.ConstantIntervalShare, cheatConstantIntervalShare},
+		{types.CheatReasonRepeatedCharacters, metrics.RepeatedCharacterShare, cheatRepeatedCharacterShare},
+	}
+	human := 1.0
+	for _, signal := range signals {
+		weight := math.Min(signal.share/signal.limit, 1)
+		if weight >= 1 {
+			metrics.Reasons = append(metrics.Reasons, signal.reason)
+		}
+		human *= 1 - weight
+	}
+
+	metrics.PastedShare = math.Round(metrics.PastedShare*100) / 100
+	metrics.ConstantIntervalShare = math.Round(metrics.ConstantIntervalShare*100) / 100
+	metrics.RepeatedCharacterShare = math.Round(metrics.RepeatedCharacterShare*100) / 100
+	metrics.Score = int(math.Round(100 * (1 - human)))
+	metrics.Suspect = metrics.Score >= cheatSuspectScore
+	return metrics
+}
+
+// constantIntervalShare is the share of the typed keystrokes that came in
+// long runs at the same interval. Pauses end a run.
+func constantIntervalShare(keyStrokes []KeyStroke) float64 {
+	typed, constant, run := 0, 0, 0
+	previous := -1
+	endRun := func() {
+		if run >= constantIntervalMinRun {
+			constant += run
+		}
+		run = 0
+	}
+
+	// The first delay is the time before the first keystroke
+	for _, keyStroke := range keyStrokes[1:] {
+		delay := keyStroke.Delay
+		if delay < 0 || delay >= focusPauseMs {
+			endRun()
+			previous = -1
+			continue
+		}
+		typed++
+		if previous >= 0 && abs(delay-previous) <= constantIntervalJitterMs {
+			if run == 0 {
+				// The keystroke before started the run
+				run = 1
+			}
+			run++
+		} else {
+			endRun()
+		}
+		previous = delay
+	}
+	endRun()
+
+	if typed == 0 {
+		return 0
+	}
+	return math.Min(float64(constant)/float64(typed), 1)
+}
+
+// repeatedCharacters counts the characters in long runs of the same one.
+// Spaces don't count, nor do keys that aren't a single character.
+func repeatedCharacters(keyStrokes []KeyStroke) int {
+	repeated, run := 0, 0
+	last := ""
+	endRun := func() {
+		if run >= repeatedCharacterMinRun {
+			repeated += run
+		}
+		run = 0
+	}
+
+	for _, keyStroke := range keyStrokes {
+		key := keyStroke.Key
+		if key == " " || utf8.RuneCountInString(key) != 1 {
+			endRun()
+			last = ""
+			continue
+		}
+		if key != last {
+			endRun()
+			last = key
+		}
+		run++
+	}
+	endRun()
+	return repeated
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
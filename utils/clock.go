@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock tells the current time. Storage and services read it instead of
+// calling time.Now, so tests can pin and move time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the real wall clock, used everywhere in production.
+var SystemClock Clock = systemClock{}
+
+// FixedClock stands still at the time it was set to until a test moves it.
+type FixedClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFixedClock(now time.Time) *FixedClock {
+	return &FixedClock{now: now}
+}
+
+func (c *FixedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FixedClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func (c *FixedClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// IDGenerator hands out the IDs of new records, in place of uuid.New.
+type IDGenerator interface {
+	NewID() uuid.UUID
+}
+
+type randomIDs struct{}
+
+func (randomIDs) NewID() uuid.UUID {
+	return uuid.New()
+}
+
+// RandomIDs generates random v4 UUIDs, used everywhere in production.
+var RandomIDs IDGenerator = randomIDs{}
+
+// SequentialIDs counts up from 00000000-0000-0000-0000-000000000001, so tests
+// know every ID before it is handed out.
+type SequentialIDs struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+func NewSequentialIDs() *SequentialIDs {
+	return &SequentialIDs{next: 1}
+}
+
+func (g *SequentialIDs) NewID() uuid.UUID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[8:], g.next)
+	g.next++
+	return id
+}
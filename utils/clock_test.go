@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFixedClockOnlyMovesWhenTold(t *testing.T) {
+	start := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	clock := NewFixedClock(start)
+
+	if !clock.Now().Equal(start) || !clock.Now().Equal(clock.Now()) {
+		t.Fatalf("Now = %v, want it to stay at %v", clock.Now(), start)
+	}
+	clock.Advance(90 * time.Second)
+	if want := start.Add(90 * time.Second); !clock.Now().Equal(want) {
+		t.Errorf("after Advance, Now = %v, want %v", clock.Now(), want)
+	}
+	later := time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC)
+	clock.Set(later)
+	if !clock.Now().Equal(later) {
+		t.Errorf("after Set, Now = %v, want %v", clock.Now(), later)
+	}
+}
+
+func TestSequentialIDsCountUp(t *testing.T) {
+	ids := NewSequentialIDs()
+	want := []string{
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0000-0000-000000000002",
+		"00000000-0000-0000-0000-000000000003",
+	}
+	for _, w := range want {
+		if got := ids.NewID(); got.String() != w {
+			t.Errorf("NewID = %s, want %s", got, w)
+		}
+	}
+	if NewSequentialIDs().NewID().String() != want[0] {
+		t.Error("a new generator doesn't start over")
+	}
+}
+
+func TestProductionDefaults(t *testing.T) {
+	if time.Since(SystemClock.Now()).Abs() > time.Minute {
+		t.Errorf("SystemClock.Now = %v, not the wall clock", SystemClock.Now())
+	}
+	a, b := RandomIDs.NewID(), RandomIDs.NewID()
+	if a == b || a == uuid.Nil || a.Version() != 4 {
+		t.Errorf("RandomIDs handed out %s and %s, want distinct v4 UUIDs", a, b)
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Phrases of writers who may be in danger, in English and Spanish. They are
+// matched as whole words, whatever their case.
+var crisisPhrases = []string{
+	"kill myself",
+	"killing myself",
+	"end my life",
+	"ending my life",
+	"take my own life",
+	"want to die",
+	"wanna die",
+	"better off dead",
+	"no reason to live",
+	"suicide",
+	"suicidal",
+	"self harm",
+	"self-harm",
+	"cut myself",
+	"hurt myself",
+	"end it all",
+	"quiero morir",
+	"quiero morirme",
+	"matarme",
+	"suicidarme",
+	"quitarme la vida",
+	"suicidio",
+}
+
+var crisisPattern = func() *regexp.Regexp {
+	quoted := make([]string, 0, len(crisisPhrases))
+	for _, phrase := range crisisPhrases {
+		quoted = append(quoted, regexp.QuoteMeta(phrase))
+	}
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+}()
+
+// DetectCrisis returns the crisis phrases found in the writing, each once and
+// lowercased, none when it reads as safe. It errs on the side of flagging:
+// a human reviews what it finds.
+func DetectCrisis(writing string) []string {
+	seen := make(map[string]bool)
+	found := []string{}
+	for _, match := range crisisPattern.FindAllString(writing, -1) {
+		phrase := strings.ToLower(match)
+		if !seen[phrase] {
+			seen[phrase] = true
+			found = append(found, phrase)
+		}
+	}
+	return found
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileStore reads and writes the files of the legacy data directory
+// (writing sessions, frames metadata, prompts) until they move to Postgres.
+// Operations on one path are serialized, and whole files are written to a
+// temporary file that is fsynced and renamed over the old one, so readers
+// see the old content or the new one, never half of it.
+type FileStore struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.RWMutex
+	// Operations holding or waiting for the lock, it is dropped at zero
+	refs int
+}
+
+// Files is the FileStore every file-based code path goes through, so they
+// all see each other's locks.
+var Files = NewFileStore()
+
+func NewFileStore() *FileStore {
+	return &FileStore{locks: map[string]*pathLock{}}
+}
+
+func lockKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// lock takes the lock of every path, in a fixed order so two renames can't
+// deadlock, and returns the function releasing them.
+func (s *FileStore) lock(write bool, paths ...string) func() {
+	keys := make([]string, 0, len(paths))
+	for _, path := range paths {
+		key := lockKey(path)
+		if !contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	held := make([]*pathLock, 0, len(keys))
+	s.mu.Lock()
+	for _, key := range keys {
+		l, ok := s.locks[key]
+		if !ok {
+			l = &pathLock{}
+			s.locks[key] = l
+		}
+		l.refs++
+		held = append(held, l)
+	}
+	s.mu.Unlock()
+
+	for _, l := range held {
+		if write {
+			l.Lock()
+		} else {
+			l.RLock()
+		}
+	}
+
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			if write {
+				held[i].Unlock()
+			} else {
+				held[i].RUnlock()
+			}
+		}
+		s.mu.Lock()
+		for i, l := range held {
+			l.refs--
+			if l.refs == 0 {
+				delete(s.locks, keys[i])
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FileStore) ReadFile(path string) ([]byte, error) {
+	unlock := s.lock(false, path)
+	defer unlock()
+	return os.ReadFile(path)
+}
+
+func (s *FileStore) Stat(path string) (fs.FileInfo, error) {
+	unlock := s.lock(false, path)
+	defer unlock()
+	return os.Stat(path)
+}
+
+// WriteFile replaces the file with data, creating it and its directory if
+// needed.
+func (s *FileStore) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	unlock := s.lock(true, path)
+	defer unlock()
+	return writeFileAtomic(path, data, perm)
+}
+
+// Update replaces the file with what fn makes of its current content, nil
+// when the file doesn't exist yet. Nothing is written when fn fails.
+func (s *FileStore) Update(path string, perm fs.FileMode, fn func(existing []byte) ([]byte, error)) error {
+	unlock := s.lock(true, path)
+	defer unlock()
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	updated, err := fn(existing)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, updated, perm)
+}
+
+// Append adds data at the end of an existing file and fsyncs it. It fails
+// with fs.ErrNotExist when there is no file yet.
+func (s *FileStore) Append(path string, data []byte) error {
+	unlock := s.lock(true, path)
+	defer unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Remove deletes the file, removing a missing file is not an error.
+func (s *FileStore) Remove(path string) error {
+	unlock := s.lock(true, path)
+	defer unlock()
+
+	if err := os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (s *FileStore) Rename(oldPath string, newPath string) error {
+	unlock := s.lock(true, oldPath, newPath)
+	defer unlock()
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(newPath))
+}
+
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error setting file mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir fsyncs the directory, so a rename or removal in it survives a
+// crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory %s: %w", dir, err)
+	}
+	return nil
+}
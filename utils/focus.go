@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// A gap this long between keystrokes breaks the flow
+	focusPauseMs = 2000
+	// Long pauses are the ones that get close to the 8 second session timeout
+	focusLongPauseMs = 5000
+)
+
+// ComputeFocusMetrics scores how continuously the session was written: how
+// long the longest uninterrupted stretch was, how much time went into pauses
+// and how steady the typing rhythm was.
+func ComputeFocusMetrics(keyStrokes []KeyStroke) types.FocusMetrics {
+	metrics := types.FocusMetrics{Label: FocusLabel(0)}
+	if len(keyStrokes) < 2 {
+		return metrics
+	}
+
+	var totalMs, pauseMs, flowMs, longestFlowMs int
+	typingDelays := make([]float64, 0, len(keyStrokes))
+	// The first delay is the time before the first keystroke, not part of the rhythm
+	for _, keyStroke := range keyStrokes[1:] {
+		delay := keyStroke.Delay
+		if delay < 0 {
+			continue
+		}
+		totalMs += delay
+
+		if delay >= focusPauseMs {
+			metrics.PauseCount++
+			if delay >= focusLongPauseMs {
+				metrics.LongPauseCount++
+			}
+			pauseMs += delay
+			flowMs = 0
+			continue
+		}
+
+		typingDelays = append(typingDelays, float64(delay))
+		flowMs += delay
+		if flowMs > longestFlowMs {
+			longestFlowMs = flowMs
+		}
+	}
+	if totalMs == 0 {
+		return metrics
+	}
+
+	metrics.LongestFlowSeconds = longestFlowMs / 1000
+	metrics.MedianDelayMs = int(median(typingDelays))
+	metrics.RhythmVariability = math.Round(coefficientOfVariation(typingDelays)*100) / 100
+
+	flowRatio := float64(longestFlowMs) / float64(totalMs)
+	pauseRatio := float64(pauseMs) / float64(totalMs)
+	steadiness := 1 / (1 + metrics.RhythmVariability)
+
+	score := 100 * (0.45*flowRatio + 0.35*(1-pauseRatio) + 0.2*steadiness)
+	metrics.Score = int(math.Round(math.Max(0, math.Min(100, score))))
+	metrics.Label = FocusLabel(metrics.Score)
+	return metrics
+}
+
+// FocusLabel buckets a focus score.
+func FocusLabel(score int) string {
+	switch {
+	case score >= 80:
+		return "one_long_breath"
+	case score >= 60:
+		return "steady"
+	case score >= 40:
+		return "waves"
+	default:
+		return "fragmented"
+	}
+}
+
+// DescribeFocus puts the metrics into words for reflections.
+func DescribeFocus(m types.FocusMetrics) string {
+	switch m.Label {
+	case "one_long_breath":
+		return fmt.Sprintf("you wrote in one long breath, your longest stretch without stopping lasted %d seconds", m.LongestFlowSeconds)
+	case "steady":
+		return fmt.Sprintf("you kept a steady rhythm, pausing %d times along the way", m.PauseCount)
+	case "waves":
+		return fmt.Sprintf("you wrote in waves, %d pauses breaking the stream into pieces", m.PauseCount)
+	default:
+		return "you wrote in fragments, stopping and starting again and again"
+	}
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values))
+	return math.Sqrt(variance) / mean
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// Words a Latin script text needs before its language is guessed
+	minLanguageWords = 8
+	// How many more stopwords the winning language needs than the runner up
+	minStopwordLead = 1.25
+)
+
+// languageNames are the languages DetectLanguage tells apart, by ISO 639-1 code.
+var languageNames = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"pt": "Portuguese",
+	"fr": "French",
+	"it": "Italian",
+	"de": "German",
+	"ru": "Russian",
+	"el": "Greek",
+	"ar": "Arabic",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"zh": "Chinese",
+	"th": "Thai",
+}
+
+// stopwords are frequent words of each Latin script language that are rare
+// in the others. Words shared by several of them, like "a" or "de", are left
+// out.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "was", "i", "you", "it", "that", "of", "to", "my", "this", "with", "have", "not", "what", "but", "be", "are", "me"},
+	"es": {"el", "los", "las", "y", "es", "que", "yo", "pero", "mi", "con", "para", "una", "del", "lo", "como", "muy", "porque", "esto", "estoy", "sí"},
+	"pt": {"o", "os", "e", "é", "não", "eu", "que", "mas", "meu", "minha", "com", "uma", "do", "da", "isso", "muito", "porque", "estou", "você", "também"},
+	"fr": {"le", "les", "et", "est", "je", "pas", "que", "mais", "mon", "avec", "pour", "une", "du", "ce", "qui", "très", "parce", "suis", "c'est", "j'ai"},
+	"it": {"il", "gli", "e", "è", "non", "io", "che", "ma", "mio", "con", "per", "una", "della", "questo", "sono", "molto", "perché", "anche", "mi", "ho"},
+	"de": {"der", "die", "das", "und", "ist", "ich", "nicht", "aber", "mein", "mit", "für", "eine", "ein", "zu", "auch", "sehr", "weil", "bin", "habe", "es"},
+}
+
+// LanguageName is the English name of the language, or the code itself when
+// it is not one DetectLanguage knows.
+func LanguageName(code string) string {
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// DetectLanguage guesses the language of the text and returns its ISO 639-1
+// code, or "" when the text is too short or too mixed to tell. Scripts used
+// by a single language decide it outright; Latin script texts are told apart
+// by their stopwords.
+func DetectLanguage(text string) string {
+	scripts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			scripts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+	for code, count := range scripts {
+		if code != "ja" && count > letters/2 {
+			return code
+		}
+	}
+	return detectLatinLanguage(text)
+}
+
+func detectLatinLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if len(words) < minLanguageWords {
+		return ""
+	}
+
+	counts := make(map[string]int, len(stopwords))
+	for _, word := range words {
+		for code, list := range stopwords {
+			for _, stopword := range list {
+				if word == stopword {
+					counts[code]++
+					break
+				}
+			}
+		}
+	}
+
+	best, runnerUp := "", ""
+	for code, count := range counts {
+		switch {
+		case best == "" || count > counts[best] || (count == counts[best] && code < best):
+			best, runnerUp = code, best
+		case runnerUp == "" || count > counts[runnerUp]:
+			runnerUp = code
+		}
+	}
+	if best == "" || counts[best] < 2 || float64(counts[best]) < minStopwordLead*float64(counts[runnerUp]) {
+		return ""
+	}
+	return best
+}
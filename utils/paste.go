@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"unicode/utf8"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// A keystroke inserting this many characters at once was pasted; keyboard
+	// suggestions insert a word at most
+	pasteMinCharacters = 20
+	// Faster than anyone types, keystrokes this close together come from a script
+	burstMaxDelayMs = 15
+	// Keystrokes in a row that fast before they count as a burst
+	burstMinCharacters = 25
+	// Sessions are flagged when at least this many characters weren't typed...
+	pasteFlagMinCharacters = 50
+	// ...and they make up at least this share of the writing
+	pasteFlagMinShare = 0.1
+)
+
+// DetectPaste looks for text that wasn't typed in the keystrokes of a
+// session: single keystrokes that insert many characters, and bursts of
+// keystrokes too fast to be typed by hand. A few suspicious characters are
+// tolerated, sessions are only flagged when they weigh in the writing.
+func DetectPaste(keyStrokes []KeyStroke) types.PasteMetrics {
+	var metrics types.PasteMetrics
+	burst := 0
+	endBurst := func() {
+		if burst >= burstMinCharacters {
+			metrics.BurstCharacters += burst
+			metrics.BurstCount++
+		}
+		burst = 0
+	}
+
+	for _, keyStroke := range keyStrokes {
+		switch keyStroke.Key {
+		case "Backspace", "Enter":
+			endBurst()
+			continue
+		}
+		length := utf8.RuneCountInString(keyStroke.Key)
+		metrics.TotalCharacters += length
+
+		if length >= pasteMinCharacters {
+			endBurst()
+			metrics.PastedCharacters += length
+			if length > metrics.LargestPaste {
+				metrics.LargestPaste = length
+			}
+			continue
+		}
+		if length == 1 && keyStroke.Delay >= 0 && keyStroke.Delay < burstMaxDelayMs {
+			burst++
+			continue
+		}
+		endBurst()
+	}
+	endBurst()
+
+	suspicious := metrics.PastedCharacters + metrics.BurstCharacters
+	metrics.Flagged = suspicious >= pasteFlagMinCharacters &&
+		float64(suspicious) >= pasteFlagMinShare*float64(metrics.TotalCharacters)
+	return metrics
+}
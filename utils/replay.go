@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+const (
+	// Typing speed is measured over windows this long
+	replayWPMWindowMs = 10000
+	// Gaps shorter than this are typing, not pauses
+	replayMinPauseMs = 1000
+	// Five characters make a word, as typing tests count them
+	charactersPerWord = 5
+
+	// ReplayBinaryContentType is served for replays asked for in binary
+	ReplayBinaryContentType = "application/vnd.anky.replay"
+	replayBinaryMagic       = "ANKR"
+	replayBinaryVersion     = 1
+)
+
+// Upper bounds of the pause buckets, the session times out at 8 seconds
+var replayPauseBounds = []int{2000, 4000, 6000, 8000}
+
+// NormalizeReplay turns parsed keystrokes into a replay timeline, each
+// keystroke stamped with when it was pressed since the session began.
+func NormalizeReplay(keyStrokes []KeyStroke) []types.ReplayKeystroke {
+	timeline := make([]types.ReplayKeystroke, 0, len(keyStrokes))
+	atMs := 0
+	for _, keyStroke := range keyStrokes {
+		delay := keyStroke.Delay
+		if delay < 0 {
+			delay = 0
+		}
+		atMs += delay
+		timeline = append(timeline, types.ReplayKeystroke{Key: keyStroke.Key, DelayMs: delay, AtMs: atMs})
+	}
+	return timeline
+}
+
+// ComputeReplayStats measures the typing speed over time and the pauses of a
+// replay timeline. Backspaces don't count as typed characters.
+func ComputeReplayStats(timeline []types.ReplayKeystroke) types.ReplayStats {
+	stats := types.ReplayStats{
+		Keystrokes:        len(timeline),
+		WPMOverTime:       []types.WPMPoint{},
+		PauseDistribution: make([]types.PauseBucket, 0, len(replayPauseBounds)+1),
+	}
+	minMs := replayMinPauseMs
+	for _, bound := range replayPauseBounds {
+		stats.PauseDistribution = append(stats.PauseDistribution, types.PauseBucket{MinMs: minMs, MaxMs: bound})
+		minMs = bound
+	}
+	stats.PauseDistribution = append(stats.PauseDistribution, types.PauseBucket{MinMs: minMs})
+	if len(timeline) == 0 {
+		return stats
+	}
+	stats.DurationMs = timeline[len(timeline)-1].AtMs
+
+	windowCount := (stats.DurationMs + replayWPMWindowMs - 1) / replayWPMWindowMs
+	if windowCount == 0 {
+		windowCount = 1
+	}
+	windows := make([]int, windowCount)
+	characters := 0
+	for i, keyStroke := range timeline {
+		if keyStroke.Key != "Backspace" {
+			typed := 1
+			if keyStroke.Key != "Enter" {
+				typed = utf8.RuneCountInString(keyStroke.Key)
+			}
+			characters += typed
+			// A keystroke right at the end belongs to the last window
+			window := keyStroke.AtMs / replayWPMWindowMs
+			if window >= windowCount {
+				window = windowCount - 1
+			}
+			windows[window] += typed
+		}
+
+		// The first delay is the time before the first keystroke, not a pause
+		if i == 0 || keyStroke.DelayMs < replayMinPauseMs {
+			continue
+		}
+		bucket := len(replayPauseBounds)
+		for j, bound := range replayPauseBounds {
+			if keyStroke.DelayMs < bound {
+				bucket = j
+				break
+			}
+		}
+		stats.PauseDistribution[bucket].Count++
+		if keyStroke.DelayMs > stats.LongestPauseMs {
+			stats.LongestPauseMs = keyStroke.DelayMs
+			stats.LongestPauseAtMs = timeline[i-1].AtMs
+		}
+	}
+
+	for i, windowCharacters := range windows {
+		windowMs := replayWPMWindowMs
+		// The last window is only as long as what's left of the session
+		if i == len(windows)-1 {
+			windowMs = stats.DurationMs - i*replayWPMWindowMs
+		}
+		stats.WPMOverTime = append(stats.WPMOverTime, types.WPMPoint{
+			AtSeconds: i * replayWPMWindowMs / 1000,
+			WPM:       wordsPerMinute(windowCharacters, windowMs),
+		})
+	}
+	stats.AverageWPM = wordsPerMinute(characters, stats.DurationMs)
+	return stats
+}
+
+func wordsPerMinute(characters int, durationMs int) float64 {
+	if durationMs <= 0 {
+		return 0
+	}
+	wpm := float64(characters) / charactersPerWord / (float64(durationMs) / 60000)
+	return math.Round(wpm*10) / 10
+}
+
+// EncodeReplayBinary packs a replay timeline into a gzipped binary stream:
+// the magic "ANKR", a version byte and the number of keystrokes, then for
+// each keystroke its delay in milliseconds and the length of its key as
+// uvarints followed by the key in UTF-8. Timestamps are left out, they are
+// the running sum of the delays.
+func EncodeReplayBinary(timeline []types.ReplayKeystroke) ([]byte, error) {
+	var raw bytes.Buffer
+	raw.WriteString(replayBinaryMagic)
+	raw.WriteByte(replayBinaryVersion)
+	varint := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(value int) {
+		raw.Write(varint[:binary.PutUvarint(varint, uint64(value))])
+	}
+	writeUvarint(len(timeline))
+	for _, keyStroke := range timeline {
+		writeUvarint(keyStroke.DelayMs)
+		writeUvarint(len(keyStroke.Key))
+		raw.WriteString(keyStroke.Key)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(raw.Bytes()); err != nil {
+		return nil, fmt.Errorf("error compressing replay: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing replay: %w", err)
+	}
+	return compressed.Bytes(), nil
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"strings"
+
+	"github.com/ankylat/anky/server/types"
+)
+
+var (
+	codeFenceRegexp      = regexp.MustCompile("(?m)^[ \\t]*```.*$\\n?")
+	imageRegexp          = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	linkRegexp           = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	headingRegexp        = regexp.MustCompile(`(?m)^[ \t]{0,3}#{1,6}[ \t]+`)
+	blockquoteRegexp     = regexp.MustCompile(`(?m)^[ \t]*>[ \t]?`)
+	horizontalRuleRegexp = regexp.MustCompile(`(?m)^[ \t]*([-*_][ \t]*){3,}$`)
+	bulletRegexp         = regexp.MustCompile(`(?m)^([ \t]*)[-*+][ \t]+`)
+	boldRegexp           = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	italicRegexp         = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*|\b_(\S(?:.*?\S)?)_\b`)
+	strikethroughRegexp  = regexp.MustCompile(`~~(.+?)~~`)
+	inlineCodeRegexp     = regexp.MustCompile("`([^`]+)`")
+
+	dashRegexp         = regexp.MustCompile(`\s*(--|—|–)\s*`)
+	repeatedMarkRegexp = regexp.MustCompile(`([!?])[!?]+`)
+	ellipsisRegexp     = regexp.MustCompile(`\.{2,}|…`)
+	spacesRegexp       = regexp.MustCompile(`[ \t]+`)
+	blankLinesRegexp   = regexp.MustCompile(`\n\s*\n+`)
+	paragraphRegexp    = regexp.MustCompile(`\n{2,}`)
+)
+
+// Typographic characters screen readers either skip or spell out
+var punctuationReplacer = strings.NewReplacer(
+	"“", `"`, "”", `"`, "„", `"`, "«", `"`, "»", `"`,
+	"‘", "'", "’", "'", "‚", "'",
+	"\u00a0", " ", "\u200b", "", "\u200d", "", "\ufeff", "",
+)
+
+// FormatAIResponse renders text generated by the LLM, which writes markdown,
+// in the given response format. Unknown formats leave the text untouched.
+func FormatAIResponse(text string, format string) string {
+	switch format {
+	case types.ResponseFormatPlain:
+		return PlainText(text)
+	case types.ResponseFormatSSML:
+		return SSML(PlainText(text))
+	}
+	return text
+}
+
+// PlainText strips markdown and normalizes punctuation so that screen readers
+// read the text the way it is meant to be heard.
+func PlainText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	text = codeFenceRegexp.ReplaceAllString(text, "")
+	text = imageRegexp.ReplaceAllString(text, "$1")
+	text = linkRegexp.ReplaceAllString(text, "$1")
+	text = horizontalRuleRegexp.ReplaceAllString(text, "")
+	text = headingRegexp.ReplaceAllString(text, "")
+	text = blockquoteRegexp.ReplaceAllString(text, "")
+	text = bulletRegexp.ReplaceAllString(text, "$1")
+	text = boldRegexp.ReplaceAllString(text, "$1$2")
+	text = italicRegexp.ReplaceAllString(text, "$1$2")
+	text = strikethroughRegexp.ReplaceAllString(text, "$1")
+	text = inlineCodeRegexp.ReplaceAllString(text, "$1")
+
+	text = punctuationReplacer.Replace(text)
+	text = dashRegexp.ReplaceAllString(text, ", ")
+	text = repeatedMarkRegexp.ReplaceAllString(text, "$1")
+	text = ellipsisRegexp.ReplaceAllString(text, "...")
+	text = spacesRegexp.ReplaceAllString(text, " ")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesRegexp.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// SSML wraps plain text for text to speech engines, one paragraph per
+// paragraph of the text and a pause for every ellipsis.
+func SSML(text string) string {
+	var b strings.Builder
+	b.WriteString("<speak>")
+	for _, paragraph := range paragraphRegexp.Split(text, -1) {
+		if paragraph == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		for i, part := range strings.Split(paragraph, "...") {
+			if i > 0 {
+				b.WriteString(`<break strength="medium"/>`)
+			}
+			b.WriteString(escapeXML(part))
+		}
+		b.WriteString("</p>")
+	}
+	b.WriteString("</speak>")
+	return b.String()
+}
+
+func escapeXML(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	// EscapeText encodes newlines, a space reads the same
+	return strings.ReplaceAll(buf.String(), "&#xA;", " ")
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Versions of the session long string. Legacy sessions start right away with
+// four metadata lines: user ID, session ID, prompt and starting timestamp.
+// Versioned sessions start with a "v1" or "v2" line, and give their ending
+// timestamp on the line after the starting one. Timestamps are Unix
+// milliseconds. Each line after the metadata is a keystroke: up to v1 the key
+// and its delay in seconds, "a 0.132", and in v2 a JSON object with the delay
+// in milliseconds, {"key":"a","delay_ms":132}, so any key can be written.
+const (
+	SessionFormatLegacy = 0
+	SessionFormatV1     = 1
+	SessionFormatV2     = 2
+
+	// LatestSessionFormat is the version new session strings are written in
+	LatestSessionFormat = SessionFormatV2
+)
+
+var sessionFormatHeaders = map[string]int{
+	"v1": SessionFormatV1,
+	"v2": SessionFormatV2,
+}
+
+// SessionHeader is the metadata at the top of a session long string.
+type SessionHeader struct {
+	// SessionFormatLegacy, SessionFormatV1 or SessionFormatV2
+	FormatVersion int
+	UserID        string
+	SessionID     string
+	Prompt        string
+	Timestamp     string
+	// Only versioned sessions say when they ended
+	StartedAt time.Time
+	EndedAt   *time.Time
+}
+
+// jsonKeyStroke is a keystroke line of a v2 session.
+type jsonKeyStroke struct {
+	Key     string `json:"key"`
+	DelayMs int    `json:"delay_ms"`
+}
+
+// ParseSessionHeader reads the metadata of a session long string and returns
+// it with the keystroke lines after it, which are left unparsed.
+func ParseSessionHeader(content string) (*SessionHeader, []string, error) {
+	lines := strings.Split(content, "\n")
+
+	version := SessionFormatLegacy
+	if v, ok := sessionFormatHeaders[strings.TrimSpace(lines[0])]; ok {
+		version = v
+		lines = lines[1:]
+	}
+	metadataLines := 4
+	if version != SessionFormatLegacy {
+		metadataLines = 5
+	}
+	if len(lines) < metadataLines {
+		return nil, nil, fmt.Errorf("invalid writing session format: %d metadata lines, need %d", len(lines), metadataLines)
+	}
+
+	header := &SessionHeader{
+		FormatVersion: version,
+		UserID:        strings.TrimSpace(lines[0]),
+		SessionID:     strings.TrimSpace(lines[1]),
+		Prompt:        strings.TrimSpace(lines[2]),
+		Timestamp:     strings.TrimSpace(lines[3]),
+	}
+	if version != SessionFormatLegacy {
+		startedAt, err := parseUnixMillis(lines[3])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid starting timestamp: %w", err)
+		}
+		endedAt, err := parseUnixMillis(lines[4])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ending timestamp: %w", err)
+		}
+		header.StartedAt = startedAt
+		header.EndedAt = &endedAt
+	} else if startedAt, err := parseUnixMillis(lines[3]); err == nil {
+		header.StartedAt = startedAt
+	}
+	return header, lines[metadataLines:], nil
+}
+
+// Encode writes the metadata lines of the header in its format version,
+// ending with a newline so keystroke lines can follow.
+func (h *SessionHeader) Encode() string {
+	prompt := strings.ReplaceAll(h.Prompt, "\n", " ")
+	if h.FormatVersion == SessionFormatLegacy {
+		return strings.Join([]string{h.UserID, h.SessionID, prompt, h.Timestamp}, "\n") + "\n"
+	}
+
+	endedAt := h.StartedAt
+	if h.EndedAt != nil {
+		endedAt = *h.EndedAt
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("v%d", h.FormatVersion),
+		h.UserID,
+		h.SessionID,
+		prompt,
+		strconv.FormatInt(h.StartedAt.UnixMilli(), 10),
+		strconv.FormatInt(endedAt.UnixMilli(), 10),
+	}, "\n") + "\n"
+}
+
+// ParseKeyStroke reads a keystroke line of a session in the format version.
+// It reports false for lines that aren't keystrokes, which are skipped.
+func ParseKeyStroke(version int, line string) (KeyStroke, bool, error) {
+	if strings.TrimSpace(line) == "" {
+		return KeyStroke{}, false, nil
+	}
+
+	if version >= SessionFormatV2 {
+		var keyStroke jsonKeyStroke
+		if err := json.Unmarshal([]byte(line), &keyStroke); err != nil {
+			return KeyStroke{}, false, fmt.Errorf("invalid keystroke %q: %w", line, err)
+		}
+		if keyStroke.DelayMs < 0 {
+			return KeyStroke{}, false, fmt.Errorf("negative keystroke delay %d", keyStroke.DelayMs)
+		}
+		return KeyStroke{Key: keyStroke.Key, Delay: keyStroke.DelayMs}, true, nil
+	}
+
+	// Don't trim the line, a space keystroke is written "  0.132"
+	var key, delayStr string
+	if strings.HasPrefix(line, " ") && strings.Count(line, " ") == 2 {
+		key = " "
+		delayStr = strings.TrimSpace(line)
+	} else {
+		lastSpaceIndex := strings.LastIndex(line, " ")
+		if lastSpaceIndex == -1 {
+			return KeyStroke{}, false, nil
+		}
+		key = strings.TrimSpace(line[:lastSpaceIndex])
+		delayStr = strings.TrimSpace(line[lastSpaceIndex+1:])
+	}
+
+	delay, err := strconv.ParseFloat(delayStr, 64)
+	if err != nil {
+		return KeyStroke{}, false, nil
+	}
+	if delay < 0 {
+		return KeyStroke{}, false, fmt.Errorf("negative keystroke delay %s", delayStr)
+	}
+	return KeyStroke{Key: key, Delay: int(math.Round(delay * 1000))}, true, nil
+}
+
+// EncodeKeyStroke writes a keystroke line in the format version, without
+// the newline.
+func EncodeKeyStroke(version int, keyStroke KeyStroke) string {
+	if version >= SessionFormatV2 {
+		line, _ := json.Marshal(jsonKeyStroke{Key: keyStroke.Key, DelayMs: keyStroke.Delay})
+		return string(line)
+	}
+	return keyStroke.Key + " " + strconv.FormatFloat(float64(keyStroke.Delay)/1000, 'f', -1, 64)
+}
+
+// EncodeWritingSession writes the session back as a long string in its
+// format version, which ParseWritingSession reads back the same.
+func EncodeWritingSession(session *WritingSession) string {
+	var b strings.Builder
+	b.WriteString(session.SessionHeader.Encode())
+	for _, keyStroke := range session.KeyStrokes {
+		b.WriteString(EncodeKeyStroke(session.FormatVersion, keyStroke))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// parseUnixMillis reads a timestamp line of a session long string.
+func parseUnixMillis(value string) (time.Time, error) {
+	millis, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	return time.UnixMilli(millis).UTC(), nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWritingSessionRoundTrip(t *testing.T) {
+	endedAt := testSessionStart.Add(8600 * time.Millisecond)
+	keyStrokes := []KeyStroke{
+		{Key: "h", Delay: 0},
+		{Key: " ", Delay: 120},
+		{Key: "Enter", Delay: 95},
+		{Key: "ñ", Delay: 210},
+		{Key: "Backspace", Delay: 133},
+		{Key: "x", Delay: 42},
+	}
+	for _, version := range []int{SessionFormatLegacy, SessionFormatV1, SessionFormatV2} {
+		header := SessionHeader{
+			FormatVersion: version,
+			UserID:        "user-1",
+			SessionID:     "session-1",
+			Prompt:        "what is alive\nin you?",
+		}
+		if version == SessionFormatLegacy {
+			header.Timestamp = millis(testSessionStart.UnixMilli())
+		} else {
+			header.StartedAt = testSessionStart
+			header.EndedAt = &endedAt
+		}
+		encoded := EncodeWritingSession(&WritingSession{SessionHeader: header, KeyStrokes: keyStrokes})
+
+		parsed, err := ParseWritingSession(encoded)
+		if err != nil {
+			t.Fatalf("v%d: parsing what was encoded: %v\n%s", version, err, encoded)
+		}
+		if parsed.FormatVersion != version || parsed.UserID != "user-1" || parsed.SessionID != "session-1" || parsed.Prompt != "what is alive in you?" {
+			t.Errorf("v%d: header = %+v", version, parsed.SessionHeader)
+		}
+		if !parsed.StartedAt.Equal(testSessionStart) {
+			t.Errorf("v%d: started at %v, want %v", version, parsed.StartedAt, testSessionStart)
+		}
+		if version != SessionFormatLegacy && (parsed.EndedAt == nil || !parsed.EndedAt.Equal(endedAt)) {
+			t.Errorf("v%d: ended at %v, want %v", version, parsed.EndedAt, endedAt)
+		}
+		if !reflect.DeepEqual(parsed.KeyStrokes, keyStrokes) {
+			t.Errorf("v%d: keystrokes = %+v, want %+v", version, parsed.KeyStrokes, keyStrokes)
+		}
+		if again := EncodeWritingSession(parsed); again != encoded {
+			t.Errorf("v%d: encoding the parsed session gives\n%s\nnot\n%s", version, again, encoded)
+		}
+	}
+}
+
+func TestV2KeyStrokesHoldAnyKey(t *testing.T) {
+	for _, key := range []string{"a b", `"`, "\\", "{", "0.5", "🙂", "\t"} {
+		line := EncodeKeyStroke(SessionFormatV2, KeyStroke{Key: key, Delay: 75})
+		got, ok, err := ParseKeyStroke(SessionFormatV2, line)
+		if err != nil || !ok || got.Key != key || got.Delay != 75 {
+			t.Errorf("key %q encoded as %s read back as %+v, %v, %v", key, line, got, ok, err)
+		}
+	}
+}
+
+func TestV1KeyStrokeDelaysAreSeconds(t *testing.T) {
+	if line := EncodeKeyStroke(SessionFormatV1, KeyStroke{Key: "a", Delay: 132}); line != "a 0.132" {
+		t.Errorf("encoded as %q, want %q", line, "a 0.132")
+	}
+	got, ok, err := ParseKeyStroke(SessionFormatV1, "a 0.1324")
+	if err != nil || !ok || got.Delay != 132 {
+		t.Errorf("read back %+v, %v, %v, want the delay rounded to 132ms", got, ok, err)
+	}
+}
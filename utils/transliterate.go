@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MaxTickerLength = 24
+	MaxFnameLength  = 16
+	// Longest slug base, before the number that makes it unique
+	MaxSlugBaseLength = 60
+)
+
+var (
+	validTickerRegexp = regexp.MustCompile(`^[A-Z0-9]{2,24}$`)
+	validFnameRegexp  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,15}$`)
+	validSlugRegexp   = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// asciiReplacements covers letters that do not decompose into an ASCII base
+// letter plus combining marks, and the Greek and Cyrillic alphabets.
+var asciiReplacements = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "TH", 'ł': "l", 'Ł': "L",
+	'ı': "i", 'ŋ': "ng", 'Ŋ': "NG", 'ħ': "h", 'Ħ': "H",
+
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// Transliterate converts s to ASCII. Accented letters lose their accents,
+// Greek and Cyrillic are romanized, and anything else without a sensible
+// ASCII form is dropped.
+func Transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		switch {
+		case r < unicode.MaxASCII:
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks left over from the decomposition
+		default:
+			lower := unicode.ToLower(r)
+			replacement, ok := asciiReplacements[lower]
+			if !ok {
+				continue
+			}
+			if lower != r {
+				replacement = strings.ToUpper(replacement)
+			}
+			b.WriteString(replacement)
+		}
+	}
+	return b.String()
+}
+
+// ValidTicker reports whether the ticker only uses characters markets accept.
+func ValidTicker(ticker string) bool {
+	return validTickerRegexp.MatchString(ticker)
+}
+
+// ValidFname reports whether fname follows Farcaster's fname rules.
+func ValidFname(fname string) bool {
+	return validFnameRegexp.MatchString(fname)
+}
+
+// ValidSlug reports whether slug could have been made by SlugBase, number
+// included.
+func ValidSlug(slug string) bool {
+	return len(slug) <= MaxSlugBaseLength+5 && validSlugRegexp.MatchString(slug)
+}
+
+// SlugBase turns a token name into the lowercase, dash separated start of a
+// URL slug, "Wisdom, Light & Dancing" into "wisdom-light-dancing". Names
+// with nothing usable give "anky".
+func SlugBase(tokenName string) string {
+	var b strings.Builder
+	lastWasDash := true
+	for _, r := range strings.ToLower(Transliterate(tokenName)) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			b.WriteRune(r)
+			lastWasDash = false
+		case !lastWasDash:
+			b.WriteRune('-')
+			lastWasDash = true
+		}
+	}
+
+	base := strings.Trim(b.String(), "-")
+	if len(base) > MaxSlugBaseLength {
+		base = strings.TrimRight(base[:MaxSlugBaseLength], "-")
+	}
+	if base == "" {
+		base = "anky"
+	}
+	return base
+}
+
+// SanitizeTicker turns an LLM generated ticker into an uppercase ASCII ticker.
+// When nothing usable is left, a deterministic ticker derived from seed (for
+// example the session ID) is returned so retries produce the same result.
+func SanitizeTicker(raw string, seed string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(Transliterate(raw)) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+
+	ticker := b.String()
+	if len(ticker) > MaxTickerLength {
+		ticker = ticker[:MaxTickerLength]
+	}
+	if !ValidTicker(ticker) {
+		ticker = fmt.Sprintf("ANKY%s", strings.ToUpper(shortHash(seed, 6)))
+	}
+	return ticker
+}
+
+// SanitizeFname derives a valid Farcaster fname from raw (usually the token
+// name), falling back to a deterministic name derived from seed.
+func SanitizeFname(raw string, seed string) string {
+	var b strings.Builder
+	lastWasDash := true
+	for _, r := range strings.ToLower(Transliterate(raw)) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			b.WriteRune(r)
+			lastWasDash = false
+		case !lastWasDash:
+			b.WriteRune('-')
+			lastWasDash = true
+		}
+	}
+
+	fname := strings.Trim(b.String(), "-")
+	if len(fname) > MaxFnameLength {
+		fname = strings.TrimRight(fname[:MaxFnameLength], "-")
+	}
+	if !ValidFname(fname) {
+		fname = fmt.Sprintf("anky-%s", shortHash(seed, 8))
+	}
+	return fname
+}
+
+// shortHash returns n base36 characters derived from s.
+func shortHash(s string, n int) string {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+
+	const alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = alphabet[sum%36]
+		sum /= 36
+	}
+	return string(out)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/ankylat/anky/server/types"
@@ -24,10 +25,61 @@ func GetAnkyID(r *http.Request) (uuid.UUID, error) {
 	return uuid.Parse(vars["id"])
 }
 
+// Scopes limit what a JWT is allowed to do. Full account tokens carry the
+// default user scopes; bots, frames and integrations get a subset.
+const (
+	ScopeWriteSessions = "write:sessions"
+	ScopeReadProfile   = "read:profile"
+	ScopeAdmin         = "admin"
+)
+
+// DefaultUserScopes are granted to tokens issued at registration and to legacy
+// tokens that were issued before scopes existed.
+var DefaultUserScopes = []string{ScopeWriteSessions, ScopeReadProfile}
+
+// KnownScopes lists every scope the server understands.
+var KnownScopes = []string{ScopeWriteSessions, ScopeReadProfile, ScopeAdmin}
+
+// fullAccountTokenTTL is how long the tokens issued at login last.
+const fullAccountTokenTTL = 400 * 24 * time.Hour
+
 func CreateJWT(user *types.User) (string, error) {
+	scopes := append([]string{}, DefaultUserScopes...)
+	if IsAdminUser(user.ID) {
+		scopes = append(scopes, ScopeAdmin)
+	}
+
+	now := time.Now()
 	claims := &jwt.MapClaims{
-		"expiresAt": time.Now().Add(400 * 24 * time.Hour).Unix(),
+		"expiresAt": now.Add(fullAccountTokenTTL).Unix(),
+		"exp":       now.Add(fullAccountTokenTTL).Unix(),
+		"iat":       now.Unix(),
 		"userID":    user.ID,
+		"scopes":    scopes,
+	}
+
+	secretKey := os.Getenv("JWT_SECRET")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(secretKey))
+}
+
+// CreateScopedJWT issues a token for userID that only carries the given scopes
+// and expires after ttl.
+func CreateScopedJWT(userID uuid.UUID, scopes []string, ttl time.Duration) (string, error) {
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return "", fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+
+	now := time.Now()
+	claims := &jwt.MapClaims{
+		"expiresAt": now.Add(ttl).Unix(),
+		"exp":       now.Add(ttl).Unix(),
+		"iat":       now.Unix(),
+		"userID":    userID,
+		"scopes":    scopes,
 	}
 
 	secretKey := os.Getenv("JWT_SECRET")
@@ -36,6 +88,88 @@ func CreateJWT(user *types.User) (string, error) {
 	return token.SignedString([]byte(secretKey))
 }
 
+// ScopesFromClaims returns the scopes carried by a token. Tokens issued before
+// scopes were introduced get the default user scopes. The admin scope only
+// counts while the user is still listed in ADMIN_USER_IDS, so removing them
+// revokes it on the tokens they hold.
+func ScopesFromClaims(claims *jwt.MapClaims) []string {
+	raw, ok := (*claims)["scopes"].([]interface{})
+	if !ok {
+		return append([]string{}, DefaultUserScopes...)
+	}
+
+	userID, err := UserIDFromClaims(claims)
+	scopes := make([]string, 0, len(raw))
+	for _, scope := range raw {
+		str, ok := scope.(string)
+		if !ok {
+			continue
+		}
+		if str == ScopeAdmin && (err != nil || !IsAdminUser(userID)) {
+			continue
+		}
+		scopes = append(scopes, str)
+	}
+	return scopes
+}
+
+// ExpiryFromClaims returns when a token expires, from exp or, on tokens
+// issued before exp was set, expiresAt.
+func ExpiryFromClaims(claims *jwt.MapClaims) (time.Time, bool) {
+	for _, claim := range []string{"exp", "expiresAt"} {
+		if unix, ok := (*claims)[claim].(float64); ok {
+			return time.Unix(int64(unix), 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// UserIDFromClaims returns the user the token was issued to.
+func UserIDFromClaims(claims *jwt.MapClaims) (uuid.UUID, error) {
+	raw, ok := (*claims)["userID"].(string)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("token has no user ID")
+	}
+	return uuid.Parse(raw)
+}
+
+// HasScopes reports whether granted covers every required scope. The admin
+// scope covers everything.
+func HasScopes(granted []string, required ...string) bool {
+	grantedSet := make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		grantedSet[scope] = true
+	}
+	if grantedSet[ScopeAdmin] {
+		return true
+	}
+	for _, scope := range required {
+		if !grantedSet[scope] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAdminUser reports whether the user is listed in ADMIN_USER_IDS.
+func IsAdminUser(userID uuid.UUID) bool {
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		if strings.TrimSpace(id) == userID.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownScope(scope string) bool {
+	for _, known := range KnownScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
 func ValidateJWT(token string) (*jwt.MapClaims, error) {
 	secretKey := os.Getenv("JWT_SECRET")
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
@@ -46,6 +180,11 @@ func ValidateJWT(token string) (*jwt.MapClaims, error) {
 	}
 
 	if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok && parsedToken.Valid {
+		// jwt enforces exp; tokens issued before it was set only carry
+		// expiresAt
+		if expiresAt, ok := claims["expiresAt"].(float64); ok && time.Now().Unix() > int64(expiresAt) {
+			return nil, jwt.ErrTokenExpired
+		}
 		return &claims, nil
 	}
 
@@ -102,7 +241,7 @@ func PrintBeautifulLog(emoji string, stepNumber int, message string, details int
 	separator := "=================================================="
 
 	// Print the top separator
-	fmt.Println("\n" + separator)
+	log.Println(separator)
 
 	// Format the step number with leading zeros for better alignment
 	stepStr := fmt.Sprintf("%03d", stepNumber)
@@ -116,25 +255,25 @@ func PrintBeautifulLog(emoji string, stepNumber int, message string, details int
 	}
 
 	// Print the main log line with emoji, step number and message
-	fmt.Printf("%s [Step %s] %s | %s\n", statusPrefix, stepStr, emoji, message)
+	log.Printf("%s [Step %s] %s | %s", statusPrefix, stepStr, emoji, message)
 
 	// If additional details were provided, print them in a structured way
 	if details != nil {
-		fmt.Println("📋 Details:")
+		log.Println("📋 Details:")
 		switch v := details.(type) {
 		case string:
-			fmt.Printf("   %s\n", v)
+			log.Printf("   %s", v)
 		case error:
-			fmt.Printf("   Error: %v\n", v)
+			log.Printf("   Error: %v", v)
 		case map[string]interface{}:
 			for key, value := range v {
-				fmt.Printf("   %s: %v\n", key, value)
+				log.Printf("   %s: %v", key, value)
 			}
 		default:
-			fmt.Printf("   %+v\n", v)
+			log.Printf("   %+v", v)
 		}
 	}
 
 	// Print the bottom separator
-	fmt.Println(separator + "\n")
+	log.Println(separator)
 }
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ankylat/anky/server/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func signTestJWT(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestFullAccountTokensExpire(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	token, err := CreateJWT(&types.User{ID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("fresh token: %v", err)
+	}
+	if _, ok := (*claims)["exp"]; !ok {
+		t.Error("full account token has no exp")
+	}
+
+	expired := signTestJWT(t, jwt.MapClaims{"userID": uuid.NewString(), "exp": time.Now().Add(-time.Minute).Unix()})
+	if _, err := ValidateJWT(expired); !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("expired token error = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestTokensWithOnlyExpiresAtExpire(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	expired := signTestJWT(t, jwt.MapClaims{"userID": uuid.NewString(), "expiresAt": time.Now().Add(-time.Minute).Unix()})
+	if _, err := ValidateJWT(expired); !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("expired legacy token error = %v, want ErrTokenExpired", err)
+	}
+
+	valid := signTestJWT(t, jwt.MapClaims{"userID": uuid.NewString(), "expiresAt": time.Now().Add(time.Hour).Unix()})
+	if _, err := ValidateJWT(valid); err != nil {
+		t.Errorf("valid legacy token: %v", err)
+	}
+}
+
+func TestAdminScopeFollowsAdminUserIDs(t *testing.T) {
+	userID := uuid.New()
+	claims := &jwt.MapClaims{"userID": userID.String(), "scopes": []interface{}{ScopeReadProfile, ScopeAdmin}}
+
+	t.Setenv("ADMIN_USER_IDS", userID.String())
+	if scopes := ScopesFromClaims(claims); !HasScopes(scopes, ScopeAdmin) {
+		t.Errorf("scopes = %v, want admin while listed", scopes)
+	}
+
+	t.Setenv("ADMIN_USER_IDS", uuid.NewString())
+	scopes := ScopesFromClaims(claims)
+	if HasScopes(scopes, ScopeAdmin) || !HasScopes(scopes, ScopeReadProfile) {
+		t.Errorf("scopes = %v, want admin dropped once unlisted", scopes)
+	}
+}
+
+func TestExpiryFromClaims(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	if got, ok := ExpiryFromClaims(&jwt.MapClaims{"exp": float64(exp.Unix()), "expiresAt": float64(exp.Add(time.Hour).Unix())}); !ok || !got.Equal(exp) {
+		t.Errorf("expiry = %v %v, want exp %v", got, ok, exp)
+	}
+	if got, ok := ExpiryFromClaims(&jwt.MapClaims{"expiresAt": float64(exp.Unix())}); !ok || !got.Equal(exp) {
+		t.Errorf("expiry = %v %v, want expiresAt %v", got, ok, exp)
+	}
+	if _, ok := ExpiryFromClaims(&jwt.MapClaims{}); ok {
+		t.Error("a token without expiry has one")
+	}
+}
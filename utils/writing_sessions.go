@@ -2,19 +2,22 @@ package utils
 
 import (
 	"fmt"
-	"os"
-	"strconv"
+	"log"
 	"strings"
+	"time"
+
+	"github.com/ankylat/anky/server/logging"
+	"github.com/ankylat/anky/server/types"
 )
 
 type WritingSession struct {
-	UserID     string
-	SessionID  string
-	Prompt     string
-	Timestamp  string
+	SessionHeader
 	KeyStrokes []KeyStroke
 	RawContent string
 	TimeSpent  int
+	Focus      types.FocusMetrics
+	Paste      types.PasteMetrics
+	Cheat      types.CheatMetrics
 }
 
 type KeyStroke struct {
@@ -22,143 +25,141 @@ type KeyStroke struct {
 	Delay int
 }
 
-func ParseWritingSession(content string) (*WritingSession, error) {
-	fmt.Println("🔍 Starting to parse writing session...")
-	fmt.Printf("📄 Raw content:\n%s\n", content)
-	lines := strings.Split(content, "\n")
-	fmt.Printf("📝 Found %d lines in content\n", len(lines))
-
-	if len(lines) < 4 {
-		fmt.Println("❌ Invalid format: Not enough lines")
-		return nil, fmt.Errorf("invalid writing session format")
+const (
+	// sessionTimeout is the silence that ends every writing session
+	sessionTimeout = 8 * time.Second
+	// Leeway between the keystrokes of a versioned session and its
+	// timestamps, for the client's timers not firing exactly on time
+	sessionClockTolerance = 2 * time.Second
+)
+
+// Duration is how long the session lasted. Versioned sessions say when they
+// started and ended; legacy sessions lasted the delays between keystrokes
+// plus the timeout that ended them.
+func (s *WritingSession) Duration() time.Duration {
+	if s.EndedAt != nil {
+		return s.EndedAt.Sub(s.StartedAt)
+	}
+	return s.keyStrokesDuration() + sessionTimeout
+}
+
+func (s *WritingSession) keyStrokesDuration() time.Duration {
+	var total time.Duration
+	for _, keyStroke := range s.KeyStrokes {
+		total += time.Duration(keyStroke.Delay) * time.Millisecond
+	}
+	return total
+}
+
+// validateTiming checks the keystrokes of a versioned session fit between its
+// timestamps, and that it ended by timing out after the last one.
+func (s *WritingSession) validateTiming() error {
+	if s.EndedAt == nil {
+		return nil
+	}
+	if s.EndedAt.Before(s.StartedAt) {
+		return fmt.Errorf("writing session ends before it starts")
+	}
+	typing := s.keyStrokesDuration()
+	if typing > s.Duration()+sessionClockTolerance {
+		return fmt.Errorf("keystrokes take %s, longer than the %s the session lasted", typing, s.Duration())
 	}
+	if s.Duration() > typing+sessionTimeout+sessionClockTolerance {
+		return fmt.Errorf("writing session lasts %s, more than its keystrokes and the timeout after them", s.Duration())
+	}
+	return nil
+}
+
+func ParseWritingSession(content string) (*WritingSession, error) {
+	log.Println("🔍 Starting to parse writing session...")
+	log.Printf("📄 Raw content: %s", logging.Content(content))
 
-	session := &WritingSession{
-		UserID:    strings.TrimSpace(lines[0]),
-		SessionID: strings.TrimSpace(lines[1]),
-		Prompt:    strings.TrimSpace(lines[2]),
-		Timestamp: strings.TrimSpace(lines[3]),
-		TimeSpent: 0,
+	header, lines, err := ParseSessionHeader(content)
+	if err != nil {
+		log.Printf("❌ Invalid format: %v", err)
+		return nil, err
 	}
+	log.Printf("📝 Found %d keystroke lines in content", len(lines))
+	session := &WritingSession{SessionHeader: *header}
 
-	fmt.Printf("📋 Session metadata:\n"+
+	log.Printf("📋 Session metadata (format v%d):\n"+
 		"UserID: %s\n"+
 		"SessionID: %s\n"+
 		"Prompt: %s\n"+
 		"Timestamp: %s\n",
-		session.UserID, session.SessionID, session.Prompt, session.Timestamp)
+		session.FormatVersion, session.UserID, session.SessionID, session.Prompt, session.Timestamp)
 
 	var keyStrokes []KeyStroke
 	var constructedText strings.Builder
-	totalMilliseconds := 0 // Track total time in milliseconds
-	fmt.Println("⏱️ Starting to track session duration")
 
-	for i := 4; i < len(lines); i++ {
-		line := lines[i] // Don't trim the space here
-		if line == "" {
-			continue
+	for _, line := range lines {
+		keyStroke, ok, err := ParseKeyStroke(session.FormatVersion, line)
+		if err != nil {
+			return nil, err
 		}
-
-		// Handle the case where the line starts with a space (meaning it's a space keystroke)
-		var key string
-		var delayStr string
-
-		if strings.HasPrefix(line, " ") && strings.Count(line, " ") == 2 {
-			// This is a space keystroke
-			key = " "
-			delayStr = strings.TrimSpace(line)
-			fmt.Println("🔤 Found space keystroke")
-		} else {
-			lastSpaceIndex := strings.LastIndex(line, " ")
-			if lastSpaceIndex == -1 {
-				fmt.Printf("⚠️ Skipping invalid line: %s\n", line)
-				continue
+		if !ok {
+			if line != "" {
+				log.Printf("⚠️ Skipping invalid line: %s", line)
 			}
-			key = strings.TrimSpace(line[:lastSpaceIndex])
-			delayStr = strings.TrimSpace(line[lastSpaceIndex+1:])
-		}
-
-		// Try to parse delay as float first
-		delayFloat, err := strconv.ParseFloat(delayStr, 64)
-		if err != nil {
-			fmt.Printf("⚠️ Invalid delay value: %s\n", delayStr)
 			continue
 		}
-
-		// Convert to milliseconds and add to total
-		delay := int(delayFloat * 1000)
-		totalMilliseconds += delay
-		fmt.Printf("⏱️ Added delay of %d milliseconds\n", delay)
-
-		keyStroke := KeyStroke{
-			Key:   key,
-			Delay: delay,
-		}
 		keyStrokes = append(keyStrokes, keyStroke)
 
-		switch key {
+		switch keyStroke.Key {
 		case "Backspace":
 			if constructedText.Len() > 0 {
 				str := constructedText.String()
 				constructedText.Reset()
 				constructedText.WriteString(str[:len(str)-1])
-				fmt.Println("⌫ Processed backspace")
+				log.Println("⌫ Processed backspace")
 			}
 		case "Enter":
 			constructedText.WriteString("\n")
-			fmt.Println("↵ Processed enter key")
+			log.Println("↵ Processed enter key")
 		case " ":
 			constructedText.WriteRune(' ')
-			fmt.Println("␣ Processed space")
+			log.Println("␣ Processed space")
 		default:
-			constructedText.WriteString(key)
-			fmt.Printf("⌨️ Added key: %s\n", key)
+			constructedText.WriteString(keyStroke.Key)
 		}
 	}
 
 	session.KeyStrokes = keyStrokes
 	session.RawContent = constructedText.String()
-	session.TimeSpent = (totalMilliseconds / 1000) + 8 // Convert to seconds and add base duration
-	session.TimeSpent = 490
+	session.Focus = ComputeFocusMetrics(keyStrokes)
+	session.Paste = DetectPaste(keyStrokes)
+	session.Cheat = AnalyzeCadence(keyStrokes, session.Paste)
+	if err := session.validateTiming(); err != nil {
+		return nil, err
+	}
+	session.TimeSpent = int(session.Duration().Seconds())
 
-	fmt.Printf("✅ Finished parsing session:\n"+
+	log.Printf("✅ Finished parsing session:\n"+
 		"Total keystrokes: %d\n"+
 		"Content length: %d characters\n"+
-		"Total time: %d seconds\n",
-		len(keyStrokes), len(session.RawContent), session.TimeSpent)
+		"Total time: %d seconds\n"+
+		"Focus score: %d (%s)\n",
+		len(keyStrokes), len(session.RawContent), session.TimeSpent, session.Focus.Score, session.Focus.Label)
 
 	return session, nil
 }
 func SaveWritingSessionLocally(content string) (*WritingSession, error) {
-	fmt.Println("🔍 Starting to parse writing session...")
-	fmt.Printf("📄 Raw content:\n%s\n", content)
-	lines := strings.Split(content, "\n")
-	fmt.Printf("📝 Found %d lines in content\n", len(lines))
-
-	if len(lines) < 4 {
-		fmt.Println("❌ Invalid format: Not enough lines")
-		return nil, fmt.Errorf("invalid writing session format")
-	}
+	log.Println("🔍 Starting to parse writing session...")
+	log.Printf("📄 Raw content: %s", logging.Content(content))
 
-	session := &WritingSession{
-		UserID:    strings.TrimSpace(lines[0]),
-		SessionID: strings.TrimSpace(lines[1]),
-		Prompt:    strings.TrimSpace(lines[2]),
-		Timestamp: strings.TrimSpace(lines[3]),
-		TimeSpent: 0,
+	header, _, err := ParseSessionHeader(content)
+	if err != nil {
+		log.Printf("❌ Invalid format: %v", err)
+		return nil, err
 	}
+	session := &WritingSession{SessionHeader: *header}
 
-	// Create user directory if it doesn't exist
 	userDir := fmt.Sprintf("data/framesgiving/%s", session.UserID)
-	if err := os.MkdirAll(userDir, 0755); err != nil {
-		fmt.Printf("❌ Error creating directory: %v\n", err)
-		return nil, fmt.Errorf("error creating directory: %v", err)
-	}
 
 	// Save full session content to individual file
 	sessionPath := fmt.Sprintf("%s/%s.txt", userDir, session.SessionID)
-	if err := os.WriteFile(sessionPath, []byte(content), 0644); err != nil {
-		fmt.Printf("❌ Error saving session file: %v\n", err)
+	if err := Files.WriteFile(sessionPath, []byte(content), 0644); err != nil {
+		log.Printf("❌ Error saving session file: %v", err)
 		return nil, fmt.Errorf("error saving session file: %v", err)
 	}
 
@@ -166,19 +167,15 @@ func SaveWritingSessionLocally(content string) (*WritingSession, error) {
 	sessionsPath := fmt.Sprintf("%s/%s_writing_sessions.txt", userDir, session.UserID)
 	sessionLine := fmt.Sprintf("%s\n", session.SessionID)
 
-	f, err := os.OpenFile(sessionsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	err = Files.Update(sessionsPath, 0644, func(existing []byte) ([]byte, error) {
+		return append(existing, sessionLine...), nil
+	})
 	if err != nil {
-		fmt.Printf("❌ Error opening sessions file: %v\n", err)
-		return nil, fmt.Errorf("error opening sessions file: %v", err)
-	}
-	defer f.Close()
-
-	if _, err := f.WriteString(sessionLine); err != nil {
-		fmt.Printf("❌ Error writing to sessions file: %v\n", err)
+		log.Printf("❌ Error writing to sessions file: %v", err)
 		return nil, fmt.Errorf("error writing to sessions file: %v", err)
 	}
 
-	fmt.Printf("✅ Successfully saved writing session for user %s\n", session.UserID)
+	log.Printf("✅ Successfully saved writing session for user %s", session.UserID)
 	return session, nil
 }
 
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSessionStart = time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+
+// versionedSession writes a session of the keystroke lines in version,
+// ending endedAfter its start.
+func versionedSession(version string, endedAfter time.Duration, keyStrokes ...string) string {
+	start := testSessionStart.UnixMilli()
+	lines := []string{version, "user-1", "session-1", "what is alive in you?",
+		millis(start), millis(start + endedAfter.Milliseconds())}
+	return strings.Join(append(lines, keyStrokes...), "\n") + "\n"
+}
+
+func millis(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func TestParseWritingSessionReadsEveryFormat(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		version  int
+		duration time.Duration
+	}{
+		{
+			name:     "legacy",
+			content:  "user-1\nsession-1\nwhat is alive in you?\n" + millis(testSessionStart.UnixMilli()) + "\nh 0.1\ni 0.2\n",
+			version:  SessionFormatLegacy,
+			duration: 300*time.Millisecond + sessionTimeout,
+		},
+		{
+			name:     "v1",
+			content:  versionedSession("v1", 8300*time.Millisecond, "h 0.1", "i 0.2"),
+			version:  SessionFormatV1,
+			duration: 8300 * time.Millisecond,
+		},
+		{
+			name:     "v2",
+			content:  versionedSession("v2", 8300*time.Millisecond, `{"key":"h","delay_ms":100}`, `{"key":"i","delay_ms":200}`),
+			version:  SessionFormatV2,
+			duration: 8300 * time.Millisecond,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			session, err := ParseWritingSession(c.content)
+			if err != nil {
+				t.Fatalf("ParseWritingSession: %v", err)
+			}
+			if session.FormatVersion != c.version || session.UserID != "user-1" || session.SessionID != "session-1" || session.Prompt != "what is alive in you?" {
+				t.Errorf("header = %+v", session.SessionHeader)
+			}
+			if !session.StartedAt.Equal(testSessionStart) {
+				t.Errorf("started at %v, want %v", session.StartedAt, testSessionStart)
+			}
+			if session.RawContent != "hi" || len(session.KeyStrokes) != 2 || session.KeyStrokes[1].Delay != 200 {
+				t.Errorf("content %q from %+v", session.RawContent, session.KeyStrokes)
+			}
+			if session.Duration() != c.duration || session.TimeSpent != int(c.duration.Seconds()) {
+				t.Errorf("duration %s, time spent %d, want %s", session.Duration(), session.TimeSpent, c.duration)
+			}
+		})
+	}
+}
+
+func TestParseWritingSessionKeys(t *testing.T) {
+	// A v1 space is written with the delay after two spaces
+	v1, err := ParseWritingSession(versionedSession("v1", 8500*time.Millisecond, "a 0.1", "  0.1", "b 0.1", "Enter 0.1", "c 0.1", "Backspace 0.1", "d 0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.RawContent != "a b\nd" {
+		t.Errorf("v1 content = %q, want %q", v1.RawContent, "a b\nd")
+	}
+
+	// v2 writes keys a v1 line can't hold
+	v2, err := ParseWritingSession(versionedSession("v2", 8300*time.Millisecond, `{"key":"ñ 1","delay_ms":100}`, `{"key":"\"","delay_ms":200}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.RawContent != `ñ 1"` {
+		t.Errorf("v2 content = %q", v2.RawContent)
+	}
+}
+
+func TestParseWritingSessionRejectsInvalidSessions(t *testing.T) {
+	cases := map[string]string{
+		"too few legacy metadata lines": "user-1\nsession-1\nprompt",
+		"too few v2 metadata lines":     "v2\nuser-1\nsession-1\nprompt\n" + millis(testSessionStart.UnixMilli()),
+		"bad starting timestamp":        "v1\nuser-1\nsession-1\nprompt\nyesterday\n" + millis(testSessionStart.UnixMilli()) + "\n",
+		"bad ending timestamp":          "v1\nuser-1\nsession-1\nprompt\n" + millis(testSessionStart.UnixMilli()) + "\nlater\n",
+		"ends before it starts":         versionedSession("v2", -time.Second),
+		"keystrokes outlast session":    versionedSession("v2", time.Second, `{"key":"h","delay_ms":5000}`),
+		"session outlasts keystrokes":   versionedSession("v2", time.Minute, `{"key":"h","delay_ms":100}`),
+		"v2 line that isn't JSON":       versionedSession("v2", 8100*time.Millisecond, "h 0.1"),
+		"negative v2 delay":             versionedSession("v2", 8*time.Second, `{"key":"h","delay_ms":-100}`),
+		"negative v1 delay":             versionedSession("v1", 8*time.Second, "h -0.1"),
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseWritingSession(content); err == nil {
+				t.Error("ParseWritingSession accepted it")
+			}
+		})
+	}
+}
+
+func TestParseWritingSessionSkipsLinesThatArentKeystrokes(t *testing.T) {
+	session, err := ParseWritingSession(versionedSession("v1", 8300*time.Millisecond, "h 0.1", "garbage", "x notanumber", "i 0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.RawContent != "hi" {
+		t.Errorf("content = %q, want the two keystrokes", session.RawContent)
+	}
+}